@@ -0,0 +1,13 @@
+module github.com/Dancode-188/synckit/examples/go-bot
+
+go 1.22
+
+require github.com/Dancode-188/synckit/server/go v0.0.0
+
+require (
+	github.com/goccy/go-json v0.10.5 // indirect
+	github.com/gorilla/websocket v1.5.1 // indirect
+	golang.org/x/net v0.26.0 // indirect
+)
+
+replace github.com/Dancode-188/synckit/server/go => ../../server/go
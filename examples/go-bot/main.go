@@ -0,0 +1,74 @@
+// Command go-bot is a headless SyncKit client: it joins a document, shows
+// up in its awareness, and answers every "ping" field another client
+// writes with a "pong" of its own.
+//
+//	go run . -url ws://localhost:8080/ws -doc room:lobby
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/pkg/client"
+)
+
+func main() {
+	url := flag.String("url", "ws://localhost:8080/ws", "SyncKit websocket endpoint")
+	docID := flag.String("doc", "room:lobby", "document to join")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c, err := client.Dial(ctx, *url, client.Options{
+		Token:       os.Getenv("SYNCKIT_TOKEN"),
+		UserID:      "go-bot",
+		BatchWindow: 50 * time.Millisecond,
+		OnError:     func(err error) { log.Println("error:", err) },
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	doc, err := c.Open(ctx, *docID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("joined %s at seq %d with %d fields", doc.ID(), doc.Seq(), len(doc.State()))
+
+	doc.SetAwareness(map[string]interface{}{"name": "go-bot", "status": "listening"})
+	doc.OnAwareness(func(clientID string, state map[string]interface{}) {
+		if state == nil {
+			log.Printf("%s left", clientID)
+			return
+		}
+		log.Printf("%s is here: %v", clientID, state)
+	})
+
+	pings := make(chan interface{}, 16)
+	doc.OnChange(func(changes map[string]interface{}) {
+		if ping, ok := changes["ping"]; ok && ping != nil {
+			select {
+			case pings <- ping:
+			default: // Callbacks must not block; drop pings while busy
+			}
+		}
+	})
+
+	for {
+		select {
+		case ping := <-pings:
+			doc.Set("pong", map[string]interface{}{"ping": ping, "at": time.Now().UTC().Format(time.RFC3339)})
+			if err := doc.Flush(ctx); err != nil {
+				log.Println("pong not saved:", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
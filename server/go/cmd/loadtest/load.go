@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// settings describe one load test
+type settings struct {
+	url         string
+	token       string                              // Token every client sends, when set
+	mint        func(userID string) (string, error) // Mints a token per client; anonymous when nil too
+	clients     int
+	docs        int
+	subscribers int // Clients subscribed to each document
+	rate        float64
+	duration    time.Duration
+	drain       time.Duration
+	prefix      string
+	payload     int
+	concurrency int
+}
+
+// fieldPrefix starts the field each client writes, one per client so
+// last-writer-wins never drops one client's delta for another's
+const fieldPrefix = "lt:"
+
+// assign decides which documents each client subscribes to: document d
+// goes to subscribers consecutive clients, starting where document d-1's
+// left off, so documents share clients evenly
+func assign(clients, docs, subscribers int) [][]int {
+	out := make([][]int, clients)
+	next := 0
+	for d := 0; d < docs; d++ {
+		for k := 0; k < subscribers; k++ {
+			out[next%clients] = append(out[next%clients], d)
+			next++
+		}
+	}
+	return out
+}
+
+// sent is a delta awaiting its ACK
+type sent struct {
+	docID string
+	at    time.Time
+}
+
+// loadClient is one websocket client of the load
+type loadClient struct {
+	n        int
+	clientID string
+	userID   string
+	docs     []string
+	ws       *websocket.Conn
+	stats    *stats
+
+	mu      sync.Mutex
+	pending map[string]sent // Message ID -> delta awaiting its ACK
+}
+
+// runLoad connects the clients, writes for the duration, waits out the
+// drain and reports what happened
+func runLoad(ctx context.Context, s settings) *Report {
+	st := newStats()
+	docsOf := assign(s.clients, s.docs, s.subscribers)
+	runID := protocol.NewID()[:8]
+
+	// Connect, a few clients at a time
+	clients := make([]*loadClient, s.clients)
+	slots := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+	for i := range clients {
+		c := &loadClient{
+			n:        i,
+			clientID: fmt.Sprintf("loadtest-%s-%d", runID, i),
+			userID:   fmt.Sprintf("loadtest-%d", i),
+			stats:    st,
+			pending:  make(map[string]sent),
+		}
+		for _, d := range docsOf[i] {
+			c.docs = append(c.docs, fmt.Sprintf("%s%s-%d", s.prefix, runID, d))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+			if err := c.connect(ctx, s); err != nil {
+				st.recordConnectFailure(err)
+				return
+			}
+			clients[c.n] = c
+		}()
+	}
+	wg.Wait()
+
+	// Deltas reach the other connected subscribers of their document
+	fanout := map[string]int{}
+	for _, c := range clients {
+		if c == nil {
+			continue
+		}
+		st.connected++
+		for _, docID := range c.docs {
+			fanout[docID]++
+		}
+	}
+	for docID, n := range fanout {
+		fanout[docID] = n - 1
+	}
+
+	// Broadcasts carry their send time relative to start
+	st.start = time.Now()
+	var readers sync.WaitGroup
+	for _, c := range clients {
+		if c != nil {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				c.read(fanout)
+			}()
+		}
+	}
+
+	start := time.Now()
+	writeCtx, cancel := context.WithTimeout(ctx, s.duration)
+	var writers sync.WaitGroup
+	for _, c := range clients {
+		if c != nil && len(c.docs) > 0 {
+			writers.Add(1)
+			go func() {
+				defer writers.Done()
+				c.write(writeCtx, s)
+			}()
+		}
+	}
+	writers.Wait()
+	cancel()
+	elapsed := time.Since(start)
+
+	// Let ACKs and broadcasts in flight arrive
+	select {
+	case <-time.After(s.drain):
+	case <-ctx.Done():
+	}
+	for _, c := range clients {
+		if c != nil {
+			c.close()
+		}
+	}
+	readers.Wait()
+
+	return st.report(s, elapsed)
+}
+
+// connect dials, authenticates and subscribes
+func (c *loadClient) connect(ctx context.Context, s settings) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	c.ws = ws
+	if deadline, ok := ctx.Deadline(); ok {
+		ws.SetReadDeadline(deadline)
+	}
+
+	authPayload := map[string]interface{}{"clientId": c.clientID, "userId": c.userID}
+	switch {
+	case s.token != "":
+		authPayload["token"] = s.token
+	case s.mint != nil:
+		token, err := s.mint(c.userID)
+		if err != nil {
+			ws.Close()
+			return fmt.Errorf("token: %w", err)
+		}
+		authPayload["token"] = token
+	}
+	if err := c.request(protocol.TypeAuth, authPayload, protocol.TypeAuthSuccess); err != nil {
+		ws.Close()
+		return fmt.Errorf("auth: %w", err)
+	}
+	for _, docID := range c.docs {
+		if err := c.request(protocol.TypeSubscribe, map[string]interface{}{"docId": docID}, protocol.TypeSyncResponse); err != nil {
+			ws.Close()
+			return fmt.Errorf("subscribe: %w", err)
+		}
+	}
+	ws.SetReadDeadline(time.Time{})
+	return nil
+}
+
+// request sends a message and reads until the reply of the wanted type
+// answering it, failing on an error answering it
+func (c *loadClient) request(msgType string, payload map[string]interface{}, want string) error {
+	id, err := c.send(msgType, payload)
+	if err != nil {
+		return err
+	}
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		msg, err := protocol.DecodeMessage(data)
+		if err != nil || msg.Origin != id {
+			continue
+		}
+		switch msg.Type {
+		case want:
+			return nil
+		case protocol.TypeError, protocol.TypeAuthError:
+			code, _ := msg.Payload["code"].(string)
+			text, _ := msg.Payload["error"].(string)
+			return fmt.Errorf("%s: %s", code, text)
+		}
+	}
+}
+
+// send writes one message and returns its ID
+func (c *loadClient) send(msgType string, payload map[string]interface{}) (string, error) {
+	id := protocol.NewID()
+	payload["id"] = id
+	data, err := protocol.EncodeMessage(msgType, payload, time.Now().UnixMilli())
+	if err != nil {
+		return "", err
+	}
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return id, c.ws.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// write sends deltas at the configured rate, taking the client's documents
+// in turn, until ctx is done
+func (c *loadClient) write(ctx context.Context, s settings) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / s.rate))
+	defer ticker.Stop()
+	field := fieldPrefix + c.clientID
+	padding := strings.Repeat("x", s.payload)
+	for n := 0; ; n++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		docID := c.docs[n%len(c.docs)]
+		now := time.Now()
+		changes := map[string]interface{}{field: map[string]interface{}{
+			"n":    n,
+			"sent": now.Sub(c.stats.start).Microseconds(),
+			"pad":  padding,
+		}}
+
+		// Recorded first, since the ACK may beat WriteMessage returning
+		id := protocol.NewID()
+		c.mu.Lock()
+		c.pending[id] = sent{docID: docID, at: now}
+		c.mu.Unlock()
+		data, err := protocol.EncodeMessage(protocol.TypeDelta, map[string]interface{}{
+			"id":      id,
+			"docId":   docID,
+			"changes": changes,
+		}, now.UnixMilli())
+		if err == nil {
+			c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err = c.ws.WriteMessage(websocket.BinaryMessage, data)
+		}
+		if err != nil {
+			c.mu.Lock()
+			delete(c.pending, id)
+			c.mu.Unlock()
+			c.stats.recordSendFailure()
+			return
+		}
+		c.stats.recordSent()
+	}
+}
+
+// read takes in ACKs, broadcasts and errors until the connection closes
+func (c *loadClient) read(fanout map[string]int) {
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			c.stats.recordUnacked(len(c.pending))
+			c.mu.Unlock()
+			return
+		}
+		received := time.Now()
+		msg, err := protocol.DecodeMessage(data)
+		if err != nil {
+			c.stats.recordError("UNDECODABLE")
+			continue
+		}
+		switch msg.Type {
+		case protocol.TypeAck:
+			d, ok := c.answered(msg.Origin)
+			if !ok {
+				continue
+			}
+			switch status, _ := msg.Payload["status"].(string); status {
+			case "", "applied":
+				c.stats.recordAck(received.Sub(d.at), fanout[d.docID])
+			default:
+				reason, _ := msg.Payload["reason"].(string)
+				c.stats.recordRejection(status, reason)
+			}
+
+		case protocol.TypeDelta:
+			changes, _ := msg.Payload["changes"].(map[string]interface{})
+			for field, v := range changes {
+				value, _ := v.(map[string]interface{})
+				sentAt, ok := value["sent"].(float64)
+				if !strings.HasPrefix(field, fieldPrefix) || !ok {
+					continue
+				}
+				c.stats.recordDelivery(received.Sub(c.stats.start) - time.Duration(sentAt)*time.Microsecond)
+			}
+
+		case protocol.TypeError:
+			c.answered(msg.Origin)
+			code, _ := msg.Payload["code"].(string)
+			c.stats.recordError(code)
+
+		case protocol.TypeSyncRequired:
+			c.stats.recordSyncRequired()
+		}
+	}
+}
+
+// answered removes and returns the delta a message answers
+func (c *loadClient) answered(origin string) (sent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.pending[origin]
+	if ok {
+		delete(c.pending, origin)
+	}
+	return d, ok
+}
+
+// close says goodbye and closes the connection, ending read
+func (c *loadClient) close() {
+	c.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "load test done"),
+		time.Now().Add(time.Second))
+	c.ws.Close()
+}
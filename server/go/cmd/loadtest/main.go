@@ -0,0 +1,205 @@
+// Command loadtest drives websocket traffic at a SyncKit server to find
+// out how much one instance sustains. It connects clients, subscribes each
+// document to a number of them and has every client write deltas at a
+// steady rate, then reports how long deltas took to reach the other
+// subscribers, how many never did, and the errors the server sent.
+//
+//	loadtest -url ws://localhost:8080/ws -clients 200 -docs 20 -subscribers 10 -rate 2 -duration 1m
+//	loadtest -smoke -json -
+//
+// Clients authenticate with tokens signed with the configured JWT secret,
+// one user per client, or anonymously when there is none. The server's
+// per-IP connection and message limits apply to the load, so raise them
+// for large runs from one machine.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+)
+
+const usage = `Usage: loadtest [flags]
+
+Connects -clients websocket clients to -url, subscribes -subscribers of
+them to each of -docs documents, and has each client write -rate deltas a
+second to its documents for -duration. A human summary goes to stdout, or
+to stderr when -json writes the report to stdout.
+
+-smoke runs a small, short load and exits 1 unless every delta was
+acknowledged and delivered without errors, for integration tests.
+
+Flags:
+`
+
+// smoke is the load -smoke runs, unless flags say otherwise
+var smoke = map[string]string{
+	"clients":     "4",
+	"docs":        "2",
+	"subscribers": "2",
+	"rate":        "10",
+	"duration":    "2s",
+	"drain":       "1s",
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes a load test and returns the process exit code
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON config file for the JWT secret; environment variables override its values")
+	url := fs.String("url", os.Getenv("SYNCKIT_WS_URL"), "websocket endpoint (default ws://localhost:PORT/ws)")
+	token := fs.String("token", os.Getenv("SYNCKIT_TOKEN"), "token every client authenticates with (default: one per client, signed with JWT_SECRET)")
+	clients := fs.Int("clients", 50, "websocket clients to connect")
+	docs := fs.Int("docs", 10, "documents to spread the load over")
+	subscribers := fs.Int("subscribers", 5, "clients subscribed to each document")
+	rate := fs.Float64("rate", 2, "deltas each client writes a second, across its documents")
+	duration := fs.Duration("duration", 30*time.Second, "how long clients write for")
+	drain := fs.Duration("drain", 2*time.Second, "how long to wait for ACKs and broadcasts after writing stops")
+	prefix := fs.String("prefix", "room:loadtest:", "prefix of the document IDs written, ending in a colon; the server's public document policy must allow it")
+	payload := fs.Int("payload", 64, "bytes of padding in each delta")
+	concurrency := fs.Int("connect-concurrency", 32, "clients connecting at once")
+	jsonOut := fs.String("json", "", `write the report as JSON to this file ("-" for stdout)`)
+	smokeRun := fs.Bool("smoke", false, "run a small load and fail unless it is delivered cleanly")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "loadtest: unexpected arguments %q\n", fs.Args())
+		fs.Usage()
+		return 2
+	}
+	if *smokeRun {
+		set := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		for name, value := range smoke {
+			if !set[name] {
+				fs.Set(name, value)
+			}
+		}
+	}
+	if *clients < 1 || *docs < 1 || *subscribers < 1 || *rate <= 0 || *duration <= 0 || *concurrency < 1 || *payload < 0 {
+		fmt.Fprintln(stderr, "loadtest: -clients, -docs, -subscribers, -rate, -duration and -connect-concurrency must be positive")
+		return 2
+	}
+	if !strings.HasSuffix(*prefix, ":") {
+		fmt.Fprintln(stderr, "loadtest: -prefix must end in a colon, so tokens can grant the documents under it")
+		return 2
+	}
+
+	cfg, err := config.LoadFromFile(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "loadtest: invalid configuration: %v\n", err)
+		return 1
+	}
+	s := settings{
+		url:         *url,
+		token:       *token,
+		clients:     *clients,
+		docs:        *docs,
+		subscribers: min(*subscribers, *clients),
+		rate:        *rate,
+		duration:    *duration,
+		drain:       *drain,
+		prefix:      *prefix,
+		payload:     *payload,
+		concurrency: *concurrency,
+	}
+	if s.url == "" {
+		s.url = defaultURL(cfg)
+	}
+	if s.token == "" && cfg.JWTSecret != "" && cfg.JWTAlgorithm == "HS256" {
+		s.mint = devTokens(cfg, *prefix, *duration+*drain+time.Hour)
+	}
+
+	report := runLoad(ctx, s)
+
+	summary := stdout
+	if *jsonOut != "" {
+		if *jsonOut == "-" {
+			summary = stderr
+		}
+		if err := writeJSON(*jsonOut, stdout, report); err != nil {
+			fmt.Fprintf(stderr, "loadtest: %v\n", err)
+			return 1
+		}
+	}
+	report.printSummary(summary)
+
+	if report.Connected == 0 {
+		fmt.Fprintln(stderr, "loadtest: no client connected")
+		return 1
+	}
+	if *smokeRun {
+		if problems := report.problems(); len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintf(stderr, "loadtest: smoke run failed: %s\n", p)
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// defaultURL is the websocket endpoint this host's configuration would serve
+func defaultURL(cfg *config.Config) string {
+	scheme := "ws"
+	if cfg.TLSEnabled() {
+		scheme = "wss"
+	}
+	host := cfg.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(cfg.Port)) + "/ws"
+}
+
+// devTokens mints a token per load client, reading and writing the
+// documents under prefix, valid for ttl
+func devTokens(cfg *config.Config, prefix string, ttl time.Duration) func(userID string) (string, error) {
+	perms := auth.DocumentPermissions{CanRead: []string{prefix + "*"}, CanWrite: []string{prefix + "*"}}
+	rules := auth.ClaimRules{Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience, Leeway: cfg.JWTLeeway}
+	return func(userID string) (string, error) {
+		return auth.IssueAccessToken(userID, "", perms, cfg.JWTSecret, ttl, rules)
+	}
+}
+
+// writeJSON writes the report to path, or to stdout for "-"
+func writeJSON(path string, stdout io.Writer, report *Report) error {
+	w := stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/pkg/synckit"
+)
+
+const testSecret = "test-secret-that-is-at-least-32-characters"
+
+// serve runs an embedded server configured from the config file at path
+// and returns its websocket URL
+func serve(t *testing.T, path string) string {
+	t.Helper()
+	cfg, err := synckit.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := synckit.New(
+		synckit.WithConfig(cfg),
+		synckit.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		ts.Close()
+	})
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+}
+
+// runLoadtest runs the tool and returns its exit code, the JSON report it
+// wrote to stdout and stderr
+func runLoadtest(t *testing.T, args ...string) (int, *Report, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), append(args, "-json", "-"), &stdout, &stderr)
+	var report Report
+	if stdout.Len() > 0 {
+		if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+			t.Fatalf("report is not JSON: %v\n%s", err, stdout.String())
+		}
+	}
+	return code, &report, stderr.String()
+}
+
+func TestAssign_SpreadsSubscribersEvenly(t *testing.T) {
+	got := assign(4, 3, 2)
+	want := [][]int{{0, 2}, {0, 2}, {1}, {1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("assign(4, 3, 2) = %v, want %v", got, want)
+	}
+	// More subscriptions than clients wrap around
+	if got := assign(2, 2, 2); !reflect.DeepEqual(got, [][]int{{0, 1}, {0, 1}}) {
+		t.Errorf("assign(2, 2, 2) = %v", got)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	got := summarize(durations)
+	want := Latency{Count: 100, Min: 1, Mean: 50.5, P50: 50, P90: 90, P95: 95, P99: 99, Max: 100}
+	if got != want {
+		t.Errorf("summarize = %+v, want %+v", got, want)
+	}
+	if got := summarize(nil); got != (Latency{}) {
+		t.Errorf("summarize(nil) = %+v, want zero", got)
+	}
+}
+
+func TestSmoke_Anonymous(t *testing.T) {
+	url := serve(t, "")
+	code, report, stderr := runLoadtest(t, "-smoke", "-url", url, "-duration", "1s", "-drain", "500ms")
+	if code != 0 {
+		t.Fatalf("exit code %d\n%s", code, stderr)
+	}
+	if report.Connected != 4 || report.DeltasSent == 0 || report.DeltasAcked != report.DeltasSent {
+		t.Errorf("report = %+v, want 4 clients with every delta acked", report)
+	}
+	// Two subscribers a document: each delta reaches one other client
+	if report.BroadcastsExpected != report.DeltasAcked || report.BroadcastsReceived != report.BroadcastsExpected {
+		t.Errorf("broadcasts %d of %d for %d deltas", report.BroadcastsReceived, report.BroadcastsExpected, report.DeltasAcked)
+	}
+	if report.BroadcastLatency.Count == 0 || report.BroadcastLatency.P99 <= 0 || report.AckLatency.Count != int(report.DeltasAcked) {
+		t.Errorf("latencies = %+v, %+v", report.BroadcastLatency, report.AckLatency)
+	}
+	if !strings.Contains(stderr, "Broadcast latency") {
+		t.Errorf("summary not on stderr with -json -:\n%s", stderr)
+	}
+}
+
+func TestSmoke_DevTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "synckit.yaml")
+	config := "jwt_secret: " + testSecret + "\nsynckit_auth_required: true\n"
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	url := serve(t, path)
+
+	code, report, stderr := runLoadtest(t, "-smoke", "-config", path, "-url", url, "-duration", "1s", "-drain", "500ms")
+	if code != 0 || report.Connected != 4 {
+		t.Fatalf("exit code %d, %d connected\n%s", code, report.Connected, stderr)
+	}
+
+	// Without the secret the server turns every client away
+	code, report, stderr = runLoadtest(t, "-smoke", "-url", url, "-duration", "500ms")
+	if code != 1 || report.Connected != 0 || len(report.ConnectErrors) == 0 {
+		t.Errorf("exit code %d, report %+v, want every client refused\n%s", code, report, stderr)
+	}
+}
+
+func TestRun_RejectsBadFlags(t *testing.T) {
+	var stderr bytes.Buffer
+	if code := run(context.Background(), []string{"-clients", "0"}, io.Discard, &stderr); code != 2 {
+		t.Errorf("exit code %d for -clients 0, want 2", code)
+	}
+	if code := run(context.Background(), []string{"extra"}, io.Discard, &stderr); code != 2 {
+		t.Errorf("exit code %d for an argument, want 2", code)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// stats gathers what the clients see. connected and start are set
+// between phases; the rest is guarded by mu.
+type stats struct {
+	connected int
+	start     time.Time
+
+	mu            sync.Mutex
+	connectErrors map[string]int64
+	sent          int64
+	acked         int64
+	rejected      int64
+	unacked       int64
+	expected      int64
+	delivered     int64
+	syncRequired  int64
+	errors        map[string]int64
+	latencies     []time.Duration // Delta sent -> broadcast received
+	ackLatencies  []time.Duration // Delta sent -> ACK received
+}
+
+func newStats() *stats {
+	return &stats{connectErrors: map[string]int64{}, errors: map[string]int64{}}
+}
+
+func (s *stats) recordConnectFailure(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connectErrors[err.Error()]++
+}
+
+func (s *stats) recordSent() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent++
+}
+
+func (s *stats) recordSendFailure() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors["SEND_FAILED"]++
+}
+
+// recordAck records an applied delta, which fanout other clients should get
+func (s *stats) recordAck(latency time.Duration, fanout int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acked++
+	s.expected += int64(fanout)
+	s.ackLatencies = append(s.ackLatencies, latency)
+}
+
+func (s *stats) recordRejection(status, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected++
+	if reason == "" {
+		reason = "unknown"
+	}
+	s.errors[strings.ToUpper(status+"_"+reason)]++
+}
+
+func (s *stats) recordUnacked(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unacked += int64(n)
+}
+
+func (s *stats) recordDelivery(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered++
+	s.latencies = append(s.latencies, latency)
+}
+
+func (s *stats) recordError(code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code == "" {
+		code = "UNKNOWN"
+	}
+	s.errors[code]++
+}
+
+func (s *stats) recordSyncRequired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncRequired++
+}
+
+// Report is the outcome of a load test, as -json writes it
+type Report struct {
+	URL                    string  `json:"url"`
+	Clients                int     `json:"clients"`
+	Documents              int     `json:"documents"`
+	SubscribersPerDocument int     `json:"subscribersPerDocument"`
+	RatePerClient          float64 `json:"ratePerClient"`
+	DurationSeconds        float64 `json:"durationSeconds"`
+
+	Connected     int              `json:"connected"`
+	ConnectErrors map[string]int64 `json:"connectErrors"`
+
+	DeltasSent         int64   `json:"deltasSent"`
+	DeltasAcked        int64   `json:"deltasAcked"`
+	DeltasRejected     int64   `json:"deltasRejected"`
+	DeltasUnacked      int64   `json:"deltasUnacknowledged"`
+	AckedPerSecond     float64 `json:"ackedPerSecond"`
+	BroadcastsExpected int64   `json:"broadcastsExpected"`
+	BroadcastsReceived int64   `json:"broadcastsReceived"`
+	BroadcastsDropped  int64   `json:"broadcastsDropped"`
+	SyncRequired       int64   `json:"syncRequired"`
+
+	// Errors counts error messages by code, and rejected deltas by status
+	// and reason
+	Errors map[string]int64 `json:"errors"`
+
+	// BroadcastLatency runs from sending a delta to another subscriber
+	// receiving it; AckLatency to the sender receiving its ACK
+	BroadcastLatency Latency `json:"broadcastLatencyMs"`
+	AckLatency       Latency `json:"ackLatencyMs"`
+}
+
+// Latency summarizes durations in milliseconds
+type Latency struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// summarize sorts durations and summarizes them
+func summarize(durations []time.Duration) Latency {
+	if len(durations) == 0 {
+		return Latency{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	return Latency{
+		Count: len(durations),
+		Min:   ms(durations[0]),
+		Mean:  ms(total / time.Duration(len(durations))),
+		P50:   ms(percentile(durations, 0.50)),
+		P90:   ms(percentile(durations, 0.90)),
+		P95:   ms(percentile(durations, 0.95)),
+		P99:   ms(percentile(durations, 0.99)),
+		Max:   ms(durations[len(durations)-1]),
+	}
+}
+
+// percentile is the nearest-rank percentile p of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+func ms(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+func (s *stats) report(set settings, elapsed time.Duration) *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &Report{
+		URL:                    set.url,
+		Clients:                set.clients,
+		Documents:              set.docs,
+		SubscribersPerDocument: set.subscribers,
+		RatePerClient:          set.rate,
+		DurationSeconds:        math.Round(elapsed.Seconds()*1000) / 1000,
+		Connected:              s.connected,
+		ConnectErrors:          s.connectErrors,
+		DeltasSent:             s.sent,
+		DeltasAcked:            s.acked,
+		DeltasRejected:         s.rejected,
+		DeltasUnacked:          s.unacked,
+		BroadcastsExpected:     s.expected,
+		BroadcastsReceived:     s.delivered,
+		BroadcastsDropped:      max(0, s.expected-s.delivered),
+		SyncRequired:           s.syncRequired,
+		Errors:                 s.errors,
+		BroadcastLatency:       summarize(s.latencies),
+		AckLatency:             summarize(s.ackLatencies),
+	}
+	if elapsed > 0 {
+		r.AckedPerSecond = math.Round(float64(s.acked)/elapsed.Seconds()*10) / 10
+	}
+	return r
+}
+
+// problems lists what keeps a run from being clean: everything connected,
+// was acknowledged and delivered, and the server reported no errors
+func (r *Report) problems() []string {
+	var out []string
+	if r.Connected < r.Clients {
+		out = append(out, fmt.Sprintf("%d of %d clients failed to connect", r.Clients-r.Connected, r.Clients))
+	}
+	if r.DeltasSent == 0 {
+		out = append(out, "no deltas sent")
+	}
+	if r.DeltasRejected > 0 || r.DeltasUnacked > 0 {
+		out = append(out, fmt.Sprintf("%d deltas rejected and %d unacknowledged", r.DeltasRejected, r.DeltasUnacked))
+	}
+	if r.BroadcastsDropped > 0 || (r.BroadcastsExpected > 0 && r.BroadcastsReceived == 0) {
+		out = append(out, fmt.Sprintf("%d of %d broadcasts not received", r.BroadcastsDropped, r.BroadcastsExpected))
+	}
+	if r.SyncRequired > 0 {
+		out = append(out, fmt.Sprintf("%d sync_required messages", r.SyncRequired))
+	}
+	for code, n := range r.Errors {
+		out = append(out, fmt.Sprintf("%d %s errors", n, code))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// printSummary writes the report for people
+func (r *Report) printSummary(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Target\t%s\n", r.URL)
+	fmt.Fprintf(tw, "Load\t%d clients, %d documents x %d subscribers, %g deltas/s each for %.1fs\n",
+		r.Clients, r.Documents, r.SubscribersPerDocument, r.RatePerClient, r.DurationSeconds)
+	fmt.Fprintf(tw, "Connected\t%d\n", r.Connected)
+	fmt.Fprintf(tw, "Deltas\t%d sent, %d acked (%.1f/s), %d rejected, %d unacknowledged\n",
+		r.DeltasSent, r.DeltasAcked, r.AckedPerSecond, r.DeltasRejected, r.DeltasUnacked)
+	fmt.Fprintf(tw, "Broadcasts\t%d of %d received, %d dropped, %d sync_required\n",
+		r.BroadcastsReceived, r.BroadcastsExpected, r.BroadcastsDropped, r.SyncRequired)
+	fmt.Fprintf(tw, "Broadcast latency\t%s\n", r.BroadcastLatency)
+	fmt.Fprintf(tw, "ACK latency\t%s\n", r.AckLatency)
+	for _, line := range countLines(r.ConnectErrors) {
+		fmt.Fprintf(tw, "Connect error\t%s\n", line)
+	}
+	for _, line := range countLines(r.Errors) {
+		fmt.Fprintf(tw, "Error\t%s\n", line)
+	}
+	tw.Flush()
+}
+
+func (l Latency) String() string {
+	if l.Count == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("p50 %.1fms  p90 %.1fms  p95 %.1fms  p99 %.1fms  max %.1fms  (n=%d)",
+		l.P50, l.P90, l.P95, l.P99, l.Max, l.Count)
+}
+
+// countLines renders counts as "n key" lines, most frequent first
+func countLines(counts map[string]int64) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = fmt.Sprintf("%d x %s", counts[k], k)
+	}
+	return lines
+}
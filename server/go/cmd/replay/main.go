@@ -0,0 +1,243 @@
+// Command replay checks that a document's delta log reproduces its stored
+// state. It rebuilds the document from its latest snapshot and the deltas
+// logged after it, applying them as the server did, and prints every field
+// where the rebuilt and stored states differ.
+//
+//	replay room:standup
+//	replay -at 2024-05-01T09:30:00Z room:standup
+//	replay -fix room:standup
+//
+// -at rebuilds the document as it stood at that time, from the snapshot
+// and deltas before it. -fix overwrites the stored document with the
+// rebuilt state once confirmed; servers holding the document in memory
+// keep their copy until they load it again.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+const usage = `Usage: replay [flags] DOC_ID
+
+Rebuilds DOC_ID from its snapshots and delta log in DATABASE_URL and diffs
+the result against the stored document. Exits 1 when they differ.
+
+Flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// openStorage connects to the configured database; tests replace it
+var openStorage = func(ctx context.Context, cfg *config.Config) (storage.StorageAdapter, error) {
+	if cfg.DatabaseURL == "" {
+		return nil, errors.New("DATABASE_URL is not set")
+	}
+	storageConfig := storage.DefaultStorageConfig()
+	storageConfig.ConnectionString = cfg.DatabaseURL
+	storageConfig.PoolMinConns = 1
+	adapter := storage.NewPostgresAdapter(storageConfig)
+	if err := adapter.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("connecting to the database: %w", err)
+	}
+	return adapter, nil
+}
+
+// result is what -output json prints
+type result struct {
+	DocumentID string                 `json:"documentId"`
+	At         *time.Time             `json:"at,omitempty"`
+	SnapshotID string                 `json:"snapshotId,omitempty"`
+	Deltas     int                    `json:"deltas"`
+	Last       *time.Time             `json:"lastDelta,omitempty"`
+	State      map[string]interface{} `json:"state,omitempty"` // With -at only
+	Diffs      []storage.FieldDiff    `json:"diffs"`
+	Fixed      bool                   `json:"fixed"`
+}
+
+// run checks one document and returns the process exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON config file; environment variables override its values")
+	atFlag := fs.String("at", "", "rebuild the document as of this RFC 3339 time instead of now")
+	fix := fs.Bool("fix", false, "overwrite the stored document with the rebuilt state")
+	yes := fs.Bool("yes", false, "overwrite without asking, with -fix")
+	format := fs.String("output", "table", "output format: table or json")
+	timeout := fs.Duration("timeout", 5*time.Minute, "time limit for the replay")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(stderr, "replay: -output must be table or json, not %q\n", *format)
+		return 2
+	}
+	var at time.Time
+	if *atFlag != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339Nano, *atFlag); err != nil {
+			fmt.Fprintf(stderr, "replay: -at must be an RFC 3339 time: %v\n", err)
+			return 2
+		}
+	}
+	docID := fs.Arg(0)
+
+	cfg, err := config.LoadFromFile(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: invalid configuration: %v\n", err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	adapter, err := openStorage(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %v\n", err)
+		return 1
+	}
+	defer adapter.Disconnect(context.Background())
+
+	doc, err := adapter.GetDocument(ctx, docID)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %v\n", err)
+		return 1
+	}
+	replay, err := storage.ReplayDocument(ctx, adapter, docID, at)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %v\n", err)
+		return 1
+	}
+	if doc == nil && replay.Snapshot == nil && replay.Deltas == 0 {
+		fmt.Fprintf(stderr, "replay: no document, snapshots or deltas for %q\n", docID)
+		return 1
+	}
+	var stored map[string]interface{}
+	if doc != nil {
+		stored = doc.State
+	}
+
+	res := result{DocumentID: docID, Deltas: replay.Deltas, Diffs: replay.Diff(stored)}
+	if res.Diffs == nil {
+		res.Diffs = []storage.FieldDiff{}
+	}
+	if replay.Snapshot != nil {
+		res.SnapshotID = replay.Snapshot.ID
+	}
+	if !replay.Last.IsZero() {
+		res.Last = &replay.Last
+	}
+	if !at.IsZero() {
+		res.At = &at
+		res.State = replay.State
+	}
+
+	if *fix && len(res.Diffs) > 0 {
+		if !*yes && !confirm(stdin, stderr, fmt.Sprintf("Overwrite %s with the replayed state, changing %d fields?", docID, len(res.Diffs))) {
+			fmt.Fprintln(stderr, "replay: not overwritten")
+		} else if _, err := adapter.SaveDocument(ctx, docID, replay.StoredState()); err != nil {
+			fmt.Fprintf(stderr, "replay: overwriting %s: %v\n", docID, err)
+			return 1
+		} else {
+			res.Fixed = true
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			fmt.Fprintf(stderr, "replay: %v\n", err)
+			return 1
+		}
+	} else {
+		printResult(stdout, &res, replay)
+	}
+
+	// A past state is expected to differ from the current one
+	if len(res.Diffs) > 0 && at.IsZero() && !res.Fixed {
+		return 1
+	}
+	return 0
+}
+
+// confirm asks a yes/no question on stderr and reads the answer from stdin
+func confirm(stdin io.Reader, stderr io.Writer, question string) bool {
+	fmt.Fprintf(stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// printResult writes the outcome for people
+func printResult(w io.Writer, res *result, replay *storage.Replay) {
+	base := "an empty document"
+	if replay.Snapshot != nil {
+		base = fmt.Sprintf("snapshot %s of %s", replay.Snapshot.ID, replay.Snapshot.CreatedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Replayed %d deltas of %s onto %s", res.Deltas, res.DocumentID, base)
+	if res.Last != nil {
+		fmt.Fprintf(w, ", through %s", res.Last.Format(time.RFC3339Nano))
+	}
+	fmt.Fprintln(w)
+
+	if res.At != nil {
+		fmt.Fprintf(w, "\nState at %s:\n", res.At.Format(time.RFC3339Nano))
+		data, _ := json.MarshalIndent(res.State, "", "  ")
+		fmt.Fprintf(w, "%s\n\n", data)
+	}
+
+	switch {
+	case len(res.Diffs) == 0:
+		fmt.Fprintln(w, "The stored document matches the replay.")
+		return
+	case res.At != nil:
+		fmt.Fprintf(w, "%d fields differ from the stored document:\n", len(res.Diffs))
+	default:
+		fmt.Fprintf(w, "%d fields diverge from the delta log:\n", len(res.Diffs))
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIELD\tSTORED\tREPLAYED")
+	for _, d := range res.Diffs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Field, jsonValue(d.Stored), jsonValue(d.Replayed))
+	}
+	tw.Flush()
+	if res.Fixed {
+		fmt.Fprintf(w, "Overwrote %s with the replayed state. Servers holding it in memory keep their copy until they load it again.\n", res.DocumentID)
+	}
+}
+
+// jsonValue renders a field value, "-" when unset
+func jsonValue(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// useMemory points the command at an in-memory adapter holding a document
+// whose log sets and deletes fields, and returns the adapter and the time
+// of the first delta
+func useMemory(t *testing.T) (*storage.MemoryAdapter, time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	adapter := storage.NewMemoryAdapter()
+	if err := adapter.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	state := map[string]interface{}{}
+	var first time.Time
+	for _, d := range []storage.DeltaEntry{
+		{OperationType: storage.OpSet, FieldPath: "title", Value: map[string]interface{}{"text": "Standup"}},
+		{OperationType: storage.OpSet, FieldPath: "owner.name", Value: map[string]interface{}{"first": "Ada"}},
+		{OperationType: storage.OpDelete, FieldPath: "title"},
+	} {
+		d.DocumentID = "room:replay"
+		delta, err := adapter.SaveDelta(ctx, &d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.IsZero() {
+			first = delta.Timestamp
+		}
+		if err := storage.ApplyDelta(state, delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := adapter.SaveDocument(ctx, "room:replay", state); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := openStorage
+	openStorage = func(ctx context.Context, _ *config.Config) (storage.StorageAdapter, error) {
+		// Each run disconnects when done
+		return adapter, adapter.Connect(ctx)
+	}
+	t.Cleanup(func() { openStorage = previous })
+	return adapter, first
+}
+
+func runReplay(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_CleanDocument(t *testing.T) {
+	useMemory(t)
+	code, stdout, stderr := runReplay("", "room:replay")
+	if code != 0 || !strings.Contains(stdout, "Replayed 3 deltas") || !strings.Contains(stdout, "matches the replay") {
+		t.Errorf("exit %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+	}
+}
+
+func TestRun_DivergenceAndFix(t *testing.T) {
+	adapter, _ := useMemory(t)
+	ctx := context.Background()
+	if _, err := adapter.SaveDocument(ctx, "room:replay", map[string]interface{}{
+		"owner.name": map[string]interface{}{"first": "Grace"},
+		"stray":      true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, _ := runReplay("", "-output", "json", "room:replay")
+	var res result
+	if err := json.Unmarshal([]byte(stdout), &res); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, stdout)
+	}
+	if code != 1 || len(res.Diffs) != 2 || res.Diffs[0].Field != "owner.name" || res.Diffs[1].Field != "stray" {
+		t.Errorf("exit %d, diffs %+v, want owner.name and stray", code, res.Diffs)
+	}
+
+	// Declining leaves the document alone
+	if code, _, stderr := runReplay("n\n", "-fix", "room:replay"); code != 1 || !strings.Contains(stderr, "not overwritten") {
+		t.Errorf("declined fix: exit %d, stderr %q", code, stderr)
+	}
+	if code, stdout, stderr := runReplay("y\n", "-fix", "room:replay"); code != 0 || !strings.Contains(stdout, "Overwrote room:replay") {
+		t.Errorf("fix: exit %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+	}
+	if code, stdout, _ := runReplay("", "room:replay"); code != 0 {
+		t.Errorf("after the fix: exit %d\n%s", code, stdout)
+	}
+}
+
+func TestRun_At(t *testing.T) {
+	_, first := useMemory(t)
+	code, stdout, stderr := runReplay("", "-output", "json", "-at", first.Format(time.RFC3339Nano), "room:replay")
+	var res result
+	if err := json.Unmarshal([]byte(stdout), &res); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, stderr)
+	}
+	// The past state differs from today's without failing
+	if code != 0 || res.Deltas != 1 || res.State["title"] == nil || len(res.Diffs) != 2 {
+		t.Errorf("exit %d, result %+v", code, res)
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	useMemory(t)
+	for _, args := range [][]string{{}, {"a", "b"}, {"-at", "yesterday", "room:replay"}, {"-output", "xml", "room:replay"}} {
+		if code, _, _ := runReplay("", args...); code != 2 {
+			t.Errorf("%q: exit %d, want 2", args, code)
+		}
+	}
+	if code, _, stderr := runReplay("", "room:none"); code != 1 || !strings.Contains(stderr, "no document") {
+		t.Errorf("unknown document: exit %d, stderr %q", code, stderr)
+	}
+}
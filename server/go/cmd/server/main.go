@@ -2,34 +2,65 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/Dancode-188/synckit/server/go/internal/config"
-	"github.com/Dancode-188/synckit/server/go/internal/server"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/version"
+	"github.com/Dancode-188/synckit/server/go/pkg/synckit"
 )
 
 func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON config file; environment variables override its values")
+	showVersion := flag.Bool("version", false, "print build information and exit")
+	flag.Parse()
+
+	build := version.Get()
+	if *showVersion {
+		fmt.Println(build)
+		return
+	}
+
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := synckit.LoadConfig(*configFile)
+	if err != nil {
+		slog.Error("Invalid configuration", "err", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel))
 
 	// Create server
-	srv := server.New(cfg)
+	srv, err := synckit.New(synckit.WithConfig(cfg))
+	if err != nil {
+		slog.Error("Failed to create server", "err", err)
+		os.Exit(1)
+	}
 
 	// Start server in goroutine
 	go func() {
 		addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
-		log.Printf("🚀 SyncKit Server starting on %s", addr)
-		log.Printf("📊 Health check: http://%s/health", addr)
-		log.Printf("🔌 WebSocket: ws://%s/ws", addr)
+		scheme, wsScheme := "http", "ws"
+		if cfg.TLSEnabled() {
+			scheme, wsScheme = "https", "wss"
+		}
+		slog.Info("SyncKit Server starting",
+			"version", build.Version,
+			"commit", build.Commit,
+			"build_time", build.BuildTime,
+			"addr", addr,
+			"health", fmt.Sprintf("%s://%s/health", scheme, addr),
+			"websocket", fmt.Sprintf("%s://%s/ws", wsScheme, addr),
+		)
 
 		if err := srv.Start(addr); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+			slog.Error("Failed to start server", "err", err)
+			os.Exit(1)
 		}
 	}()
 
@@ -38,15 +69,15 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("📛 Shutting down gracefully...")
+	slog.Info("Shutting down gracefully")
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("⚠️  Forced shutdown: %v", err)
+		slog.Warn("Forced shutdown", "err", err)
 	}
 
-	log.Println("✅ Server shut down")
+	slog.Info("Server shut down")
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// backupResult is what backup prints
+type backupResult struct {
+	Destination string                `json:"destination"`
+	Location    string                `json:"location"`
+	Bytes       int64                 `json:"bytes"`
+	Counts      *storage.BackupCounts `json:"counts"`
+}
+
+// backup archives every document. Through the server it triggers POST
+// /admin/backup, which writes NAME into BACKUP_DIR or uploads it to S3;
+// with -direct it reads PostgreSQL and writes the archive to FILE, or to
+// stdout for "-".
+func (c *cli) backup(ctx context.Context, args []string) error {
+	fs := subcommand("backup")
+	toS3 := fs.Bool("s3", false, "upload to BACKUP_S3_BUCKET instead of writing to BACKUP_DIR")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 || c.direct && len(positional) != 1 {
+		return fmt.Errorf("%w: backup takes a name, or a file with -direct", errUsage)
+	}
+
+	var result backupResult
+	if c.direct {
+		if *toS3 {
+			return fmt.Errorf("%w: -s3 goes through the server; drop -direct", errUsage)
+		}
+		adapter, err := c.connectStorage(ctx)
+		if err != nil {
+			return err
+		}
+		defer (&storageDocuments{adapter: adapter}).close()
+		res, err := backupToFile(ctx, adapter, positional[0], c.out)
+		if err != nil {
+			return err
+		}
+		if res.Location == "-" {
+			return nil // The archive is the output
+		}
+		result = *res
+	} else {
+		token, err := c.bearer()
+		if err != nil {
+			return err
+		}
+		body := map[string]string{} // The server picks BACKUP_DIR when it is set
+		if *toS3 {
+			body["destination"] = "s3"
+		}
+		if len(positional) == 1 {
+			body["name"] = positional[0]
+		}
+		if err := c.call(ctx, http.MethodPost, "/admin/backup", token, body, &result); err != nil {
+			return err
+		}
+	}
+
+	counts := result.Counts
+	return c.print(result, fields(
+		"Location", result.Location,
+		"Bytes", strconv.FormatInt(result.Bytes, 10),
+		"Documents", strconv.Itoa(counts.Documents),
+		"Text documents", strconv.Itoa(counts.TextDocuments),
+		"Deltas", strconv.Itoa(counts.Deltas),
+		"Snapshots", strconv.Itoa(counts.Snapshots),
+		"Grants", strconv.Itoa(counts.Grants),
+	))
+}
+
+// backupToFile writes a backup of adapter to path, or to stdout for "-".
+// A file is written beside path and renamed over it once complete, so a
+// failed backup never leaves a partial archive.
+func backupToFile(ctx context.Context, adapter storage.StorageAdapter, path string, stdout io.Writer) (*backupResult, error) {
+	if path == "-" {
+		buf := bufio.NewWriter(stdout)
+		counts, err := storage.Backup(ctx, adapter, buf)
+		if err == nil {
+			err = buf.Flush()
+		}
+		return &backupResult{Destination: "file", Location: "-", Counts: counts}, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".synckit-backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name()) // Fails harmlessly once renamed
+	buf := bufio.NewWriter(f)
+	counts, err := storage.Backup(ctx, adapter, buf)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	size, _ := f.Seek(0, io.SeekCurrent)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &backupResult{Destination: "file", Location: path, Bytes: size, Counts: counts}, nil
+}
+
+// restore loads a backup into PostgreSQL. It only runs with -direct:
+// servers keep documents they have loaded in memory and will not see
+// restored ones until they reload them.
+func (c *cli) restore(ctx context.Context, args []string) error {
+	fs := subcommand("restore")
+	merge := fs.Bool("merge", false, "keep documents, deltas and snapshots that already exist (the default)")
+	overwrite := fs.Bool("overwrite", false, "replace documents, deltas and snapshots that already exist")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: restore takes one backup file", errUsage)
+	}
+	if *merge && *overwrite {
+		return fmt.Errorf("%w: -merge and -overwrite are exclusive", errUsage)
+	}
+	if !c.direct {
+		return errors.New("restore writes to the database: pass -direct")
+	}
+
+	adapter, err := c.connectStorage(ctx)
+	if err != nil {
+		return err
+	}
+	defer (&storageDocuments{adapter: adapter}).close()
+	result, err := restoreFromFile(ctx, adapter, positional[0], *overwrite)
+	if err != nil {
+		return err
+	}
+
+	restored, skipped := result.Restored, result.Skipped
+	t := table{header: []string{"", "RESTORED", "SKIPPED"}}
+	for _, row := range []struct {
+		name              string
+		restored, skipped int
+	}{
+		{"Documents", restored.Documents, skipped.Documents},
+		{"Text documents", restored.TextDocuments, skipped.TextDocuments},
+		{"Deltas", restored.Deltas, skipped.Deltas},
+		{"Snapshots", restored.Snapshots, skipped.Snapshots},
+		{"Grants", restored.Grants, skipped.Grants},
+	} {
+		t.rows = append(t.rows, []string{row.name, strconv.Itoa(row.restored), strconv.Itoa(row.skipped)})
+	}
+	return c.print(result, t)
+}
+
+// restoreFromFile restores the backup at path, or stdin for "-", into
+// adapter
+func restoreFromFile(ctx context.Context, adapter storage.StorageAdapter, path string, overwrite bool) (*storage.RestoreResult, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return storage.Restore(ctx, adapter, bufio.NewReader(r), storage.RestoreOptions{Overwrite: overwrite})
+}
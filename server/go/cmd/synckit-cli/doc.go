@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/Dancode-188/synckit/server/go/pkg/client"
+)
+
+// documents reads and writes documents through the server or, with
+// -direct, in PostgreSQL
+type documents interface {
+	get(ctx context.Context, docID string) (map[string]interface{}, error)
+	// update applies changes, nil values deleting fields, and returns the
+	// resulting state
+	update(ctx context.Context, docID string, changes map[string]interface{}) (map[string]interface{}, error)
+	list(ctx context.Context, opts listOptions) ([]documentInfo, error)
+	close()
+}
+
+type listOptions struct {
+	owner         string // Server only
+	limit, offset int    // Direct only
+}
+
+// documentInfo is one row of doc list
+type documentInfo struct {
+	ID        string     `json:"id"`
+	Version   int64      `json:"version,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// documents opens the backend the flags select
+func (c *cli) documents(ctx context.Context) (documents, error) {
+	if c.direct {
+		adapter, err := c.connectStorage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &storageDocuments{adapter: adapter}, nil
+	}
+	token, err := c.bearer()
+	if err != nil {
+		return nil, err
+	}
+	return &serverDocuments{c: c, token: token}, nil
+}
+
+func (c *cli) docGet(ctx context.Context, args []string) error {
+	positional, err := parseArgs(subcommand("doc get"), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: doc get takes one document ID", errUsage)
+	}
+	docs, err := c.documents(ctx)
+	if err != nil {
+		return err
+	}
+	defer docs.close()
+
+	state, err := docs.get(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	return c.printState(positional[0], state)
+}
+
+func (c *cli) docSet(ctx context.Context, args []string) error {
+	positional, err := parseArgs(subcommand("doc set"), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 2 {
+		return fmt.Errorf("%w: doc set takes a document ID and KEY=VALUE pairs", errUsage)
+	}
+	changes, err := parseAssignments(positional[1:])
+	if err != nil {
+		return err
+	}
+	return c.update(ctx, positional[0], changes)
+}
+
+func (c *cli) docDelete(ctx context.Context, args []string) error {
+	positional, err := parseArgs(subcommand("doc delete"), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 1 {
+		return fmt.Errorf("%w: doc delete takes a document ID and optionally keys", errUsage)
+	}
+	changes := make(map[string]interface{})
+	for _, key := range positional[1:] {
+		changes[key] = nil
+	}
+	return c.update(ctx, positional[0], changes)
+}
+
+// update applies changes and prints the resulting state. No changes means
+// deleting every field.
+func (c *cli) update(ctx context.Context, docID string, changes map[string]interface{}) error {
+	docs, err := c.documents(ctx)
+	if err != nil {
+		return err
+	}
+	defer docs.close()
+
+	if len(changes) == 0 {
+		state, err := docs.get(ctx, docID)
+		if err != nil {
+			return err
+		}
+		for key := range state {
+			changes[key] = nil
+		}
+	}
+	state, err := docs.update(ctx, docID, changes)
+	if err != nil {
+		return err
+	}
+	return c.printState(docID, state)
+}
+
+func (c *cli) docList(ctx context.Context, args []string) error {
+	fs := subcommand("doc list")
+	var opts listOptions
+	fs.StringVar(&opts.owner, "owner", "me", "list the documents this user owns (server only; other users need an admin token)")
+	fs.IntVar(&opts.limit, "limit", 100, "documents per page (-direct only)")
+	fs.IntVar(&opts.offset, "offset", 0, "documents to skip (-direct only)")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("%w: doc list takes no arguments", errUsage)
+	}
+	docs, err := c.documents(ctx)
+	if err != nil {
+		return err
+	}
+	defer docs.close()
+
+	infos, err := docs.list(ctx, opts)
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"ID"}}
+	if c.direct {
+		t.header = append(t.header, "VERSION", "UPDATED")
+	}
+	for _, info := range infos {
+		row := []string{info.ID}
+		if c.direct {
+			updated := ""
+			if info.UpdatedAt != nil {
+				updated = info.UpdatedAt.UTC().Format(time.RFC3339)
+			}
+			row = append(row, strconv.FormatInt(info.Version, 10), updated)
+		}
+		t.rows = append(t.rows, row)
+	}
+	return c.print(infos, t)
+}
+
+// printState prints a document's fields sorted by key
+func (c *cli) printState(docID string, state map[string]interface{}) error {
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	t := table{header: []string{"KEY", "VALUE"}}
+	for _, key := range keys {
+		t.rows = append(t.rows, []string{key, formatValue(state[key])})
+	}
+	return c.print(map[string]interface{}{"id": docID, "state": state}, t)
+}
+
+// parseAssignments reads KEY=VALUE arguments. A VALUE that parses as JSON
+// is used as such; anything else is a string, so name=alice works unquoted.
+func parseAssignments(args []string) (map[string]interface{}, error) {
+	changes := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		key, raw, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w: %q is not KEY=VALUE", errUsage, arg)
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			value = raw
+		}
+		changes[key] = value
+	}
+	return changes, nil
+}
+
+// serverDocuments goes through a running server: the websocket protocol
+// for document state, which the HTTP API does not expose, and
+// GET /api/documents for lists
+type serverDocuments struct {
+	c      *cli
+	token  string
+	client *client.Client
+}
+
+func (s *serverDocuments) open(ctx context.Context, docID string) (*client.Document, error) {
+	if s.client == nil {
+		wsURL := "ws" + strings.TrimPrefix(s.c.server, "http") + "/ws"
+		cl, err := client.Dial(ctx, wsURL, client.Options{Token: s.token})
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", wsURL, err)
+		}
+		s.client = cl
+	}
+	return s.client.Open(ctx, docID)
+}
+
+func (s *serverDocuments) get(ctx context.Context, docID string) (map[string]interface{}, error) {
+	doc, err := s.open(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	return doc.State(), nil
+}
+
+func (s *serverDocuments) update(ctx context.Context, docID string, changes map[string]interface{}) (map[string]interface{}, error) {
+	doc, err := s.open(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Update(changes); err != nil {
+		return nil, err
+	}
+	if err := doc.Flush(ctx); err != nil {
+		return nil, err
+	}
+	return doc.State(), nil
+}
+
+func (s *serverDocuments) list(ctx context.Context, opts listOptions) ([]documentInfo, error) {
+	var resp struct {
+		DocumentIDs []string `json:"documentIds"`
+	}
+	if err := s.c.call(ctx, http.MethodGet, "/api/documents?owner="+url.QueryEscape(opts.owner), s.token, nil, &resp); err != nil {
+		return nil, err
+	}
+	infos := make([]documentInfo, 0, len(resp.DocumentIDs))
+	for _, id := range resp.DocumentIDs {
+		infos = append(infos, documentInfo{ID: id})
+	}
+	return infos, nil
+}
+
+func (s *serverDocuments) close() {
+	if s.client != nil {
+		s.client.Close()
+	}
+}
+
+// storageDocuments works on PostgreSQL directly, for when the server is
+// down. A running server keeps documents it has loaded in memory and will
+// not see these writes until it reloads them.
+type storageDocuments struct {
+	adapter storage.StorageAdapter
+}
+
+func (s *storageDocuments) get(ctx context.Context, docID string) (map[string]interface{}, error) {
+	doc, err := s.adapter.GetDocument(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("document %q not found", docID)
+	}
+	return doc.State, nil
+}
+
+func (s *storageDocuments) update(ctx context.Context, docID string, changes map[string]interface{}) (map[string]interface{}, error) {
+	state := make(map[string]interface{})
+	doc, err := s.adapter.GetDocument(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	if doc != nil {
+		state = doc.State
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(state, key)
+		} else {
+			state[key] = value
+		}
+	}
+	saved, err := s.adapter.SaveDocument(ctx, docID, state)
+	if err != nil {
+		return nil, err
+	}
+	return saved.State, nil
+}
+
+func (s *storageDocuments) list(ctx context.Context, opts listOptions) ([]documentInfo, error) {
+	docs, err := s.adapter.ListDocuments(ctx, opts.limit, opts.offset)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]documentInfo, 0, len(docs))
+	for _, doc := range docs {
+		updatedAt := doc.UpdatedAt
+		infos = append(infos, documentInfo{ID: doc.ID, Version: doc.Version, UpdatedAt: &updatedAt})
+	}
+	return infos, nil
+}
+
+func (s *storageDocuments) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.adapter.Disconnect(ctx)
+}
+
+// connectStorage connects to DATABASE_URL
+func (c *cli) connectStorage(ctx context.Context) (storage.StorageAdapter, error) {
+	if c.cfg.DatabaseURL == "" {
+		return nil, errors.New("-direct needs DATABASE_URL")
+	}
+	storageConfig := storage.DefaultStorageConfig()
+	storageConfig.ConnectionString = c.cfg.DatabaseURL
+	storageConfig.PoolMinConns = 1
+	adapter := storage.NewPostgresAdapter(storageConfig)
+	if err := adapter.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("connecting to the database: %w", err)
+	}
+	return adapter, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// health reports GET /health/ready and fails unless the server is healthy
+func (c *cli) health(ctx context.Context, args []string) error {
+	positional, err := parseArgs(subcommand("health"), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("%w: health takes no arguments", errUsage)
+	}
+
+	var report struct {
+		Status        string            `json:"status"`
+		Checks        map[string]string `json:"checks"`
+		Connections   int               `json:"connections"`
+		UptimeSeconds int64             `json:"uptimeSeconds"`
+		Version       string            `json:"version"`
+	}
+	// The report is sent with 503 as well, so it is decoded whatever the status
+	status, data, err := c.request(ctx, http.MethodGet, "/health/ready", "", nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return fmt.Errorf("GET /health/ready: HTTP %d", status)
+	}
+
+	t := fields(
+		"status", report.Status,
+		"version", report.Version,
+		"connections", strconv.Itoa(report.Connections),
+		"uptime", strconv.FormatInt(report.UptimeSeconds, 10)+"s",
+	)
+	deps := make([]string, 0, len(report.Checks))
+	for dep := range report.Checks {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+	for _, dep := range deps {
+		t.rows = append(t.rows, []string{dep, report.Checks[dep]})
+	}
+	if err := c.print(report, t); err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("server is %s", report.Status)
+	}
+	return nil
+}
+
+// cleanupRun removes old sessions, deltas, snapshots and audit events,
+// through POST /admin/cleanup or, with -direct, in PostgreSQL. Without
+// flags the storage defaults apply.
+func (c *cli) cleanupRun(ctx context.Context, args []string) error {
+	fs := subcommand("cleanup run")
+	var options storage.CleanupOptions
+	fs.IntVar(&options.OldSessionsHours, "sessions-hours", 0, "remove sessions idle this many hours")
+	fs.IntVar(&options.OldDeltasDays, "deltas-days", 0, "remove deltas older than this many days")
+	fs.IntVar(&options.OldSnapshotsDays, "snapshots-days", 0, "remove snapshots older than this many days")
+	fs.IntVar(&options.MaxSnapshotsPerDocument, "max-snapshots", 0, "keep at most this many snapshots per document")
+	fs.IntVar(&options.OldAuditEventsDays, "audit-days", 0, "remove audit events older than this many days")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("%w: cleanup run takes no arguments", errUsage)
+	}
+	var opts *storage.CleanupOptions
+	if options != (storage.CleanupOptions{}) {
+		opts = &options
+	}
+
+	var result storage.CleanupResult
+	if c.direct {
+		adapter, err := c.connectStorage(ctx)
+		if err != nil {
+			return err
+		}
+		defer (&storageDocuments{adapter: adapter}).close()
+		res, err := adapter.Cleanup(ctx, opts)
+		if err != nil {
+			return err
+		}
+		result = *res
+	} else {
+		token, err := c.bearer()
+		if err != nil {
+			return err
+		}
+		var body interface{} // No body, not null, for the server's defaults
+		if opts != nil {
+			body = opts
+		}
+		if err := c.call(ctx, http.MethodPost, "/admin/cleanup", token, body, &result); err != nil {
+			return err
+		}
+	}
+
+	return c.print(result, fields(
+		"sessions deleted", strconv.Itoa(result.SessionsDeleted),
+		"deltas deleted", strconv.Itoa(result.DeltasDeleted),
+		"snapshots deleted", strconv.Itoa(result.SnapshotsDeleted),
+		"audit events deleted", strconv.Itoa(result.AuditEventsDeleted),
+	))
+}
+
+// call sends an HTTP request to the server and decodes its JSON response
+// into out. Error responses become errors carrying the server's message.
+func (c *cli) call(ctx context.Context, method, path, token string, body, out interface{}) error {
+	status, data, err := c.request(ctx, method, path, token, body)
+	if err != nil {
+		return err
+	}
+	if status/100 != 2 {
+		var apiErr struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s %s: %s (%s)", method, path, apiErr.Error, apiErr.Code)
+		}
+		return fmt.Errorf("%s %s: HTTP %d", method, path, status)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s %s: decoding response: %w", method, path, err)
+	}
+	return nil
+}
+
+// request sends an HTTP request to the server, returning the response
+// status and body whatever the status
+func (c *cli) request(ctx context.Context, method, path, token string, body interface{}) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	return resp.StatusCode, data, err
+}
@@ -0,0 +1,276 @@
+// Command synckit-cli is an operator's tool for a SyncKit server: it mints
+// and inspects tokens, reads and writes documents, checks health, runs
+// storage cleanup, and backs up and restores storage. It loads
+// configuration the way the server does, from the environment layered over
+// an optional config file.
+//
+//	synckit-cli token create -user alice -write 'room:*' -expires 1h
+//	synckit-cli -output json doc get room:standup
+//	synckit-cli doc set room:standup status=ready count=3
+//	synckit-cli -direct doc list
+//	synckit-cli health
+//	synckit-cli -direct -timeout 1h backup synckit.jsonl
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+)
+
+const usage = `Usage: synckit-cli [flags] <command> [args]
+
+Commands:
+  token create -user ID [-email E] [-read DOCS] [-write DOCS] [-admin] [-expires 24h]
+  token inspect [TOKEN]
+  doc get DOC_ID
+  doc set DOC_ID KEY=VALUE...     (VALUE is JSON, or a plain string)
+  doc delete DOC_ID [KEY...]      (every field when no keys are given)
+  doc list [-owner me] [-limit 100] [-offset 0]
+  health
+  cleanup run [-sessions-hours N] [-deltas-days N] [-snapshots-days N]
+              [-max-snapshots N] [-audit-days N]
+  backup [-s3] [NAME]             (into the server's BACKUP_DIR, or S3)
+  -direct backup FILE             (FILE is - for stdout)
+  -direct restore [-merge | -overwrite] FILE
+
+Flags:
+`
+
+// errUsage marks errors in how the command was invoked; they exit with 2
+var errUsage = errors.New("usage")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// cli holds what every command shares: configuration, the server to talk
+// to and how to print results
+type cli struct {
+	cfg     *config.Config
+	server  string // Base HTTP URL of the server
+	token   string // Bearer token; minted from the JWT secret when empty
+	direct  bool   // Use DATABASE_URL instead of the server
+	format  string // "table" or "json"
+	timeout time.Duration
+	out     io.Writer
+}
+
+// run executes one command and returns the process exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("synckit-cli", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON config file; environment variables override its values")
+	server := fs.String("server", os.Getenv("SYNCKIT_URL"), "server URL (default http://localhost:PORT)")
+	token := fs.String("token", os.Getenv("SYNCKIT_TOKEN"), "bearer token for the server (default: a short-lived admin token signed with JWT_SECRET)")
+	direct := fs.Bool("direct", false, "read and write PostgreSQL at DATABASE_URL instead of going through the server")
+	format := fs.String("output", "table", "output format: table or json")
+	timeout := fs.Duration("timeout", 30*time.Second, "time limit for the command")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(stderr, "synckit-cli: -output must be table or json, not %q\n", *format)
+		fs.Usage()
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := config.LoadFromFile(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "synckit-cli: invalid configuration: %v\n", err)
+		return 1
+	}
+	c := &cli{
+		cfg:     cfg,
+		server:  strings.TrimSuffix(*server, "/"),
+		token:   *token,
+		direct:  *direct,
+		format:  *format,
+		timeout: *timeout,
+		out:     stdout,
+	}
+	if c.server == "" {
+		c.server = defaultServerURL(cfg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if err := c.dispatch(ctx, fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "synckit-cli: %v\n", err)
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+func (c *cli) dispatch(ctx context.Context, args []string) error {
+	command := strings.Join(args[:min(2, len(args))], " ")
+	rest := args[min(2, len(args)):]
+	switch command {
+	case "token create":
+		return c.tokenCreate(rest)
+	case "token inspect":
+		return c.tokenInspect(rest)
+	case "doc get":
+		return c.docGet(ctx, rest)
+	case "doc set":
+		return c.docSet(ctx, rest)
+	case "doc delete":
+		return c.docDelete(ctx, rest)
+	case "doc list":
+		return c.docList(ctx, rest)
+	case "cleanup run":
+		return c.cleanupRun(ctx, rest)
+	}
+	switch args[0] {
+	case "health":
+		return c.health(ctx, args[1:])
+	case "backup":
+		return c.backup(ctx, args[1:])
+	case "restore":
+		return c.restore(ctx, args[1:])
+	}
+	return fmt.Errorf("%w: unknown command %q", errUsage, strings.Join(args, " "))
+}
+
+// defaultServerURL is the server this host's configuration would start
+func defaultServerURL(cfg *config.Config) string {
+	scheme := "http"
+	if cfg.TLSEnabled() {
+		scheme = "https"
+	}
+	host := cfg.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+}
+
+// bearer is the token to send the server: -token, else a five-minute admin
+// token signed with the configured secret, else the admin API key
+func (c *cli) bearer() (string, error) {
+	if c.token != "" {
+		return c.token, nil
+	}
+	if c.cfg.JWTSecret != "" && c.cfg.JWTAlgorithm == "HS256" {
+		return auth.IssueAccessToken("synckit-cli", "", auth.CreateAdminPermissions(), c.cfg.JWTSecret, 5*time.Minute, c.claimRules())
+	}
+	if c.cfg.AdminAPIKey != "" {
+		return c.cfg.AdminAPIKey, nil
+	}
+	return "", errors.New("no credentials: pass -token or set SYNCKIT_TOKEN, JWT_SECRET or ADMIN_API_KEY")
+}
+
+func (c *cli) claimRules() auth.ClaimRules {
+	return auth.ClaimRules{Issuer: c.cfg.JWTIssuer, Audience: c.cfg.JWTAudience, Leeway: c.cfg.JWTLeeway}
+}
+
+// parseArgs parses fs from args, allowing flags after positional arguments
+// as well as before them, and returns the positional ones
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// subcommand returns a flag set that reports errors instead of printing
+// them, so run can print usage once
+func subcommand(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// table is a result laid out in columns, for -output table
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// print writes v as indented JSON, or t as aligned columns
+func (c *cli) print(v interface{}, t table) error {
+	if c.format == "json" {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	if len(t.header) > 0 {
+		fmt.Fprintln(w, strings.Join(t.header, "\t"))
+	}
+	for _, row := range t.rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// fields lays out name/value pairs as a two-column table
+func fields(pairs ...string) table {
+	t := table{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		t.rows = append(t.rows, []string{pairs[i], pairs[i+1]})
+	}
+	return t
+}
+
+// stringList is a flag taking comma-separated values, repeatable
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// formatValue renders a document value for a table cell
+func formatValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/server"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+const testSecret = "test-secret-that-is-at-least-32-characters"
+
+// writeConfig writes a config file setting the JWT secret, issuer and
+// audience, plus any extra lines
+func writeConfig(t *testing.T, extra ...string) string {
+	t.Helper()
+	lines := append([]string{
+		"jwt_secret: " + testSecret,
+		"jwt_issuer: synckit-test",
+		"jwt_audience: editors",
+	}, extra...)
+	path := filepath.Join(t.TempDir(), "synckit.yaml")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runCLI runs the CLI and returns its exit code, stdout and stderr
+func runCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestParseArgs_AllowsFlagsAfterArguments(t *testing.T) {
+	fs := subcommand("doc list")
+	owner := fs.String("owner", "me", "")
+	limit := fs.Int("limit", 100, "")
+
+	positional, err := parseArgs(fs, []string{"room:1", "-owner", "bob", "k=v", "-limit=5", "--", "-x=1"})
+	if err != nil {
+		t.Fatalf("parseArgs: %v", err)
+	}
+	if want := []string{"room:1", "k=v", "-x=1"}; !reflect.DeepEqual(positional, want) {
+		t.Errorf("positional = %q, want %q", positional, want)
+	}
+	if *owner != "bob" || *limit != 5 {
+		t.Errorf("owner, limit = %q, %d", *owner, *limit)
+	}
+
+	if _, err := parseArgs(subcommand("doc get"), []string{"room:1", "-nope"}); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("unknown flag error = %v, want a usage error", err)
+	}
+}
+
+func TestParseAssignments(t *testing.T) {
+	changes, err := parseAssignments([]string{`count=3`, `name=alice`, `tags=["a","b"]`, `quoted="x=y"`, `empty=`})
+	if err != nil {
+		t.Fatalf("parseAssignments: %v", err)
+	}
+	want := map[string]interface{}{
+		"count":  float64(3),
+		"name":   "alice",
+		"tags":   []interface{}{"a", "b"},
+		"quoted": "x=y",
+		"empty":  "",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+
+	for _, bad := range []string{"novalue", "=3"} {
+		if _, err := parseAssignments([]string{bad}); err == nil {
+			t.Errorf("parseAssignments(%q) accepted", bad)
+		}
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	cfg := writeConfig(t)
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no command", []string{"-config", cfg}, 2},
+		{"unknown command", []string{"-config", cfg, "token", "burn"}, 2},
+		{"bad output", []string{"-output", "xml", "health"}, 2},
+		{"missing user", []string{"-config", cfg, "token", "create"}, 2},
+		{"stray argument", []string{"-config", cfg, "doc", "list", "extra"}, 2},
+		{"direct backup without a file", []string{"-config", cfg, "-direct", "backup"}, 2},
+		{"restore without a file", []string{"-config", cfg, "-direct", "restore"}, 2},
+		{"merge and overwrite", []string{"-config", cfg, "-direct", "restore", "-merge", "-overwrite", "x.jsonl"}, 2},
+		{"help", []string{"-h"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := runCLI(t, tt.args...)
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if tt.code == 2 && !strings.Contains(stderr, "Usage:") {
+				t.Errorf("stderr = %q, want usage", stderr)
+			}
+		})
+	}
+}
+
+func TestToken_CreateAndInspect(t *testing.T) {
+	cfg := writeConfig(t)
+	code, stdout, stderr := runCLI(t, "-config", cfg, "-output", "json",
+		"token", "create", "-user", "alice", "-email", "alice@example.com",
+		"-read", "room:*,doc-1", "-write", "room:*", "-expires", "90m")
+	if code != 0 {
+		t.Fatalf("token create exited %d: %s", code, stderr)
+	}
+	var created struct {
+		Token     string    `json:"token"`
+		UserID    string    `json:"userId"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &created); err != nil {
+		t.Fatalf("decoding %q: %v", stdout, err)
+	}
+
+	// The token verifies against the auth package with the configured rules
+	rules := auth.ClaimRules{Issuer: "synckit-test", Audience: "editors"}
+	payload, err := auth.VerifyTokenWithRules(created.Token, testSecret, rules)
+	if err != nil {
+		t.Fatalf("VerifyTokenWithRules: %v", err)
+	}
+	if payload.UserID != "alice" || payload.Email != "alice@example.com" {
+		t.Errorf("claims = %q %q", payload.UserID, payload.Email)
+	}
+	if !auth.CanReadDocument(payload, "doc-1") || !auth.CanWriteDocument(payload, "room:7") || auth.CanWriteDocument(payload, "doc-1") {
+		t.Errorf("permissions = %+v", payload.Permissions)
+	}
+	if ttl := time.Until(payload.ExpiresAt.Time); ttl < 89*time.Minute || ttl > 90*time.Minute {
+		t.Errorf("token expires in %v, want 90m", ttl)
+	}
+	if _, err := auth.VerifyTokenWithRules(created.Token, testSecret, auth.ClaimRules{Audience: "viewers"}); err == nil {
+		t.Error("token verified for another audience")
+	}
+
+	t.Run("inspect", func(t *testing.T) {
+		code, stdout, stderr := runCLI(t, "-config", cfg, "-output", "json", "token", "inspect", created.Token)
+		if code != 0 {
+			t.Fatalf("token inspect exited %d: %s", code, stderr)
+		}
+		var claims map[string]interface{}
+		if err := json.Unmarshal([]byte(stdout), &claims); err != nil {
+			t.Fatal(err)
+		}
+		if claims["userId"] != "alice" || claims["verified"] != "valid" || claims["issuer"] != "synckit-test" ||
+			claims["type"] != "access" || claims["expired"] != false {
+			t.Errorf("inspect = %v", claims)
+		}
+	})
+
+	t.Run("inspect table", func(t *testing.T) {
+		code, stdout, _ := runCLI(t, "-config", cfg, "-token", created.Token, "token", "inspect")
+		if code != 0 || !strings.Contains(stdout, "verified     valid") || !strings.Contains(stdout, "read=room:*,doc-1 write=room:*") {
+			t.Errorf("exit %d, table:\n%s", code, stdout)
+		}
+	})
+
+	t.Run("inspect with another secret", func(t *testing.T) {
+		other := filepath.Join(t.TempDir(), "other.yaml")
+		os.WriteFile(other, []byte("jwt_secret: another-secret-that-is-32-characters-long\n"), 0o600)
+		code, stdout, stderr := runCLI(t, "-config", other, "token", "inspect", created.Token)
+		if code != 1 || !strings.Contains(stderr, "token is not valid") {
+			t.Errorf("exit %d, stderr %q, want an invalid token", code, stderr)
+		}
+		if !strings.Contains(stdout, "alice") {
+			t.Errorf("claims not printed for an invalid token:\n%s", stdout)
+		}
+	})
+
+	t.Run("inspect garbage", func(t *testing.T) {
+		if code, _, stderr := runCLI(t, "-config", cfg, "token", "inspect", "not-a-token"); code != 1 || !strings.Contains(stderr, "not a JWT") {
+			t.Errorf("exit %d, stderr %q", code, stderr)
+		}
+	})
+}
+
+func TestToken_CreateAdmin(t *testing.T) {
+	cfg := writeConfig(t)
+	code, stdout, stderr := runCLI(t, "-config", cfg, "token", "create", "-user", "ops", "-admin")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	token := ""
+	for _, line := range strings.Split(stdout, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "token" {
+			token = fields[1]
+		}
+	}
+	payload, err := auth.VerifyTokenWithRules(token, testSecret, auth.ClaimRules{Issuer: "synckit-test", Audience: "editors"})
+	if err != nil {
+		t.Fatalf("VerifyTokenWithRules(%q): %v", token, err)
+	}
+	if !payload.Permissions.IsAdmin {
+		t.Errorf("permissions = %+v, want admin", payload.Permissions)
+	}
+	if time.Until(payload.ExpiresAt.Time) < auth.DefaultAccessTokenTTL-time.Minute {
+		t.Errorf("token expires at %v, want the default lifetime", payload.ExpiresAt)
+	}
+}
+
+// TestDocumentsAndHealth runs the document and health commands against a
+// server on a local port
+func TestDocumentsAndHealth(t *testing.T) {
+	path := writeConfig(t)
+	cfg, err := config.LoadFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(cfg)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+	base := []string{"-config", path, "-server", "http://" + ln.Addr().String()}
+	cli := func(args ...string) string {
+		t.Helper()
+		code, stdout, stderr := runCLI(t, append(append([]string{}, base...), args...)...)
+		if code != 0 {
+			t.Fatalf("%v exited %d: %s", args, code, stderr)
+		}
+		return stdout
+	}
+
+	if out := cli("health"); !strings.Contains(out, "healthy") {
+		t.Errorf("health:\n%s", out)
+	}
+
+	cli("doc", "set", "room:cli", "title=Standup", "count=3")
+	var doc struct {
+		State map[string]interface{} `json:"state"`
+	}
+	if err := json.Unmarshal([]byte(cli("-output", "json", "doc", "get", "room:cli")), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"title": "Standup", "count": float64(3)}; !reflect.DeepEqual(doc.State, want) {
+		t.Errorf("state = %v, want %v", doc.State, want)
+	}
+
+	if out := cli("doc", "delete", "room:cli", "count"); strings.Contains(out, "count") || !strings.Contains(out, "Standup") {
+		t.Errorf("after deleting count:\n%s", out)
+	}
+	if out := cli("doc", "delete", "room:cli"); strings.TrimSpace(out) != "KEY  VALUE" {
+		t.Errorf("after deleting everything:\n%s", out)
+	}
+	cli("doc", "list")
+
+	code, _, stderr := runCLI(t, append(base, "cleanup", "run")...)
+	if code != 1 || !strings.Contains(stderr, "STORAGE_UNAVAILABLE") {
+		t.Errorf("cleanup without storage: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, stderr := runCLI(t, append(base, "-direct", "doc", "list")...); code != 1 || !strings.Contains(stderr, "DATABASE_URL") {
+		t.Errorf("-direct without a database: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, stderr := runCLI(t, append(base, "backup")...); code != 1 || !strings.Contains(stderr, "STORAGE_UNAVAILABLE") {
+		t.Errorf("backup without storage: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, stderr := runCLI(t, append(base, "restore", "x.jsonl")...); code != 1 || !strings.Contains(stderr, "-direct") {
+		t.Errorf("restore through the server: exit %d, stderr %q", code, stderr)
+	}
+}
+
+func TestBackupAndRestoreFiles(t *testing.T) {
+	ctx := context.Background()
+	source := storage.NewMemoryAdapter()
+	source.Connect(ctx)
+	if _, err := source.SaveDocument(ctx, "room:cli", map[string]interface{}{"title": "Standup"}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "synckit.jsonl")
+	res, err := backupToFile(ctx, source, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != res.Bytes || res.Counts.Documents != 1 {
+		t.Errorf("backup = %+v, file %v, %v", res, info, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("backup left %d files, want 1", len(entries))
+	}
+
+	var stdout bytes.Buffer
+	if _, err := backupToFile(ctx, source, "-", &stdout); err != nil || !strings.HasPrefix(stdout.String(), `{"type":"header"`) {
+		t.Errorf("backup to stdout = %q, %v", stdout.String(), err)
+	}
+
+	target := storage.NewMemoryAdapter()
+	target.Connect(ctx)
+	for _, step := range []struct {
+		overwrite         bool
+		restored, skipped int
+	}{{false, 1, 0}, {false, 0, 1}, {true, 1, 0}} {
+		result, err := restoreFromFile(ctx, target, path, step.overwrite)
+		if err != nil || result.Restored.Documents != step.restored || result.Skipped.Documents != step.skipped {
+			t.Errorf("restore (overwrite %v) = %+v, %v", step.overwrite, result, err)
+		}
+	}
+	if doc, _ := target.GetDocument(ctx, "room:cli"); doc == nil || doc.State["title"] != "Standup" {
+		t.Errorf("restored document = %+v", doc)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+)
+
+// tokenCreate mints an access token with the configured secret, issuer and
+// audience
+func (c *cli) tokenCreate(args []string) error {
+	fs := subcommand("token create")
+	userID := fs.String("user", "", "user ID the token is for (required)")
+	email := fs.String("email", "", "email claim")
+	var read, write stringList
+	fs.Var(&read, "read", "document IDs or patterns the user can read, comma-separated or repeated")
+	fs.Var(&write, "write", "document IDs or patterns the user can write, comma-separated or repeated")
+	admin := fs.Bool("admin", false, "grant admin: every document and the admin API")
+	tenant := fs.String("tenant", "", "tenant the token is confined to")
+	expires := fs.Duration("expires", auth.DefaultAccessTokenTTL, "lifetime of the token")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(positional) > 0:
+		return fmt.Errorf("%w: token create takes no arguments, got %q", errUsage, positional)
+	case *userID == "":
+		return fmt.Errorf("%w: token create needs -user", errUsage)
+	case *expires <= 0:
+		return fmt.Errorf("%w: -expires must be positive", errUsage)
+	}
+	if c.cfg.JWTAlgorithm != "HS256" {
+		return fmt.Errorf("tokens can only be minted for HS256; JWT_ALGORITHM is %s", c.cfg.JWTAlgorithm)
+	}
+	if c.cfg.JWTSecret == "" {
+		return errors.New("JWT_SECRET is not set")
+	}
+
+	permissions := auth.CreateUserPermissions(read, write)
+	if *admin {
+		permissions = auth.CreateAdminPermissions()
+	}
+	if permissions.CanRead == nil {
+		permissions.CanRead = []string{}
+	}
+	if permissions.CanWrite == nil {
+		permissions.CanWrite = []string{}
+	}
+	token, err := auth.IssueTenantAccessToken(*userID, *email, *tenant, permissions, c.cfg.JWTSecret, *expires, c.claimRules())
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(*expires).UTC().Truncate(time.Second)
+	result := map[string]interface{}{
+		"token":       token,
+		"userId":      *userID,
+		"expiresAt":   expiresAt,
+		"permissions": permissions,
+	}
+	t := fields(
+		"token", token,
+		"user", *userID,
+		"expires", expiresAt.Format(time.RFC3339),
+		"permissions", describePermissions(permissions),
+	)
+	if *tenant != "" {
+		result["tenantId"] = *tenant
+		t.rows = append(t.rows, []string{"tenant", *tenant})
+	}
+	return c.print(result, t)
+}
+
+// tokenInspect decodes a token and, when the secret is configured,
+// verifies it. An invalid token is still printed, then reported as an
+// error.
+func (c *cli) tokenInspect(args []string) error {
+	fs := subcommand("token inspect")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	token := c.token
+	switch len(positional) {
+	case 0:
+		if token == "" {
+			return fmt.Errorf("%w: token inspect needs a token, or -token", errUsage)
+		}
+	case 1:
+		token = positional[0]
+	default:
+		return fmt.Errorf("%w: token inspect takes one token", errUsage)
+	}
+
+	claims, err := auth.DecodeTokenWithoutVerification(token)
+	if err != nil {
+		return fmt.Errorf("not a JWT: %w", err)
+	}
+
+	verified := "not checked (JWT_SECRET is not set)"
+	var invalid error
+	if c.cfg.JWTSecret != "" && c.cfg.JWTAlgorithm == "HS256" {
+		verify := auth.VerifyTokenWithRules
+		if claims.Type == auth.TokenTypeRefresh {
+			verify = auth.VerifyRefreshToken
+		}
+		if _, invalid = verify(token, c.cfg.JWTSecret, c.claimRules()); invalid == nil {
+			verified = "valid"
+		} else {
+			verified = "invalid: " + invalid.Error()
+		}
+	}
+
+	tokenType := claims.Type
+	if tokenType == "" {
+		tokenType = "access"
+	}
+	result := map[string]interface{}{
+		"userId":      claims.UserID,
+		"type":        tokenType,
+		"permissions": claims.Permissions,
+		"verified":    verified,
+	}
+	t := fields("user", claims.UserID, "type", tokenType)
+	add := func(key, label, value string) {
+		if value != "" {
+			result[key] = value
+			t.rows = append(t.rows, []string{label, value})
+		}
+	}
+	add("email", "email", claims.Email)
+	add("tenantId", "tenant", claims.TenantID)
+	add("id", "id", claims.ID)
+	add("issuer", "issuer", claims.Issuer)
+	add("audience", "audience", strings.Join(claims.Audience, ","))
+	if claims.IssuedAt != nil {
+		add("issuedAt", "issued", claims.IssuedAt.UTC().Format(time.RFC3339))
+	}
+	if claims.ExpiresAt != nil {
+		add("expiresAt", "expires", claims.ExpiresAt.UTC().Format(time.RFC3339))
+		result["expired"] = claims.ExpiresAt.Before(time.Now())
+	}
+	t.rows = append(t.rows,
+		[]string{"permissions", describePermissions(claims.Permissions)},
+		[]string{"verified", verified},
+	)
+
+	if err := c.print(result, t); err != nil {
+		return err
+	}
+	if invalid != nil {
+		return fmt.Errorf("token is not valid: %w", invalid)
+	}
+	return nil
+}
+
+// describePermissions summarises permissions for a table cell
+func describePermissions(p auth.DocumentPermissions) string {
+	if p.IsAdmin {
+		return "admin"
+	}
+	parts := []string{
+		"read=" + strings.Join(p.CanRead, ","),
+		"write=" + strings.Join(p.CanWrite, ","),
+	}
+	docs := make([]string, 0, len(p.CanWriteFields))
+	for doc := range p.CanWriteFields {
+		docs = append(docs, doc)
+	}
+	sort.Strings(docs)
+	for _, doc := range docs {
+		parts = append(parts, fmt.Sprintf("fields[%s]=%s", doc, strings.Join(p.CanWriteFields[doc], ",")))
+	}
+	return strings.Join(parts, " ")
+}
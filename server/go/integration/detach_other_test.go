@@ -0,0 +1,22 @@
+//go:build integration && !unix
+
+package integration
+
+import (
+	"os"
+	"os/exec"
+)
+
+// detach does nothing where process groups work differently; the signal
+// handler in run still removes the containers
+func detach(cmd *exec.Cmd) {}
+
+// alive reports whether the process pid is still running
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,19 @@
+//go:build integration && unix
+
+package integration
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach puts cmd in a process group of its own, so signals sent to the
+// test's group, such as Ctrl-C, leave it running
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// alive reports whether the process pid is still running
+func alive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
@@ -0,0 +1,16 @@
+// Package integration tests the server against real PostgreSQL and Redis,
+// which the unit tests stand in for with the memory adapter and miniredis.
+// Its tests only build with the integration tag and need Docker:
+//
+//	go test -tags=integration ./integration
+//
+// A run starts postgres:15 and redis:7 containers and removes them when it
+// ends, whether tests pass, fail, panic, time out or are interrupted. They
+// carry the label synckit-integration, so
+//
+//	docker rm -f $(docker ps -aq --filter label=synckit-integration)
+//
+// clears any a killed run left behind. SYNCKIT_TEST_DATABASE_URL and
+// SYNCKIT_TEST_REDIS_URL point the tests at running servers instead; the
+// schema is applied to the database either way.
+package integration
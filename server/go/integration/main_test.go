@@ -0,0 +1,274 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// containerLabel marks the containers a run starts, valued with its runID
+const containerLabel = "synckit-integration"
+
+// reaperEnv, set to "<pid>:<runID>", makes the test binary a reaper for
+// that run rather than running tests
+const reaperEnv = "SYNCKIT_INTEGRATION_REAPER"
+
+// schemaFile is the PostgreSQL schema the servers share
+const schemaFile = "../../typescript/src/storage/schema.sql"
+
+// serviceTimeout bounds waiting for a container to accept connections
+const serviceTimeout = time.Minute
+
+var (
+	runID       = protocol.NewID()[:12]
+	databaseURL string
+	redisURL    string
+
+	// pool is the Docker connection, nil when no container is needed
+	pool *dockertest.Pool
+
+	resourcesMu sync.Mutex
+	resources   []*dockertest.Resource
+)
+
+func TestMain(m *testing.M) {
+	if spec := os.Getenv(reaperEnv); spec != "" {
+		reap(spec)
+		return
+	}
+	os.Exit(run(m))
+}
+
+// run starts the services, runs the tests and purges the containers,
+// which also happens when the run is interrupted or dies
+func run(m *testing.M) int {
+	defer purgeContainers()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		purgeContainers()
+		os.Exit(1)
+	}()
+
+	if err := startServices(); err != nil {
+		fmt.Fprintf(os.Stderr, "integration: %v\n", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// startServices sets databaseURL and redisURL, starting containers for
+// those not given in the environment, and applies the schema
+func startServices() error {
+	databaseURL = os.Getenv("SYNCKIT_TEST_DATABASE_URL")
+	redisURL = os.Getenv("SYNCKIT_TEST_REDIS_URL")
+	if databaseURL == "" || redisURL == "" {
+		var err error
+		if pool, err = dockertest.NewPool(""); err == nil {
+			err = pool.Client.Ping()
+		}
+		if err != nil {
+			return fmt.Errorf("connecting to Docker: %w", err)
+		}
+		pool.MaxWait = serviceTimeout
+		if err := startReaper(); err != nil {
+			return fmt.Errorf("starting the container reaper: %w", err)
+		}
+	}
+
+	if databaseURL == "" {
+		addr, err := startContainer("postgres", "15-alpine", "5432",
+			"POSTGRES_USER=synckit", "POSTGRES_PASSWORD=synckit", "POSTGRES_DB=synckit")
+		if err != nil {
+			return err
+		}
+		databaseURL = "postgres://synckit:synckit@" + addr + "/synckit?sslmode=disable"
+	}
+	if redisURL == "" {
+		addr, err := startContainer("redis", "7-alpine", "6379")
+		if err != nil {
+			return err
+		}
+		redisURL = "redis://" + addr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if err := waitFor(ctx, "PostgreSQL", pingPostgres); err != nil {
+		return err
+	}
+	if err := waitFor(ctx, "Redis", pingRedis); err != nil {
+		return err
+	}
+	return applySchema(ctx)
+}
+
+// startReaper starts a copy of the test binary that outlives this process
+// and removes the run's containers once it exits, however that happens
+func startReaper() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable)
+	cmd.Env = append(os.Environ(), reaperEnv+"="+strconv.Itoa(os.Getpid())+":"+runID)
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// reap waits for the process in spec to exit, then removes every container
+// labelled with its run ID
+func reap(spec string) {
+	pidText, id, _ := strings.Cut(spec, ":")
+	pid, err := strconv.Atoi(pidText)
+	if err != nil || id == "" {
+		return
+	}
+	for alive(pid) {
+		time.Sleep(time.Second)
+	}
+	reaper, err := dockertest.NewPool("")
+	if err != nil {
+		return
+	}
+	containers, err := reaper.Client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": {containerLabel + "=" + id}},
+	})
+	if err != nil {
+		return
+	}
+	for _, c := range containers {
+		reaper.Client.RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, Force: true, RemoveVolumes: true})
+	}
+}
+
+// startContainer runs repository:tag, publishing port on a free loopback
+// port, and returns the host:port to reach it on
+func startContainer(repository, tag, port string, env ...string) (string, error) {
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository:   repository,
+		Tag:          tag,
+		Env:          env,
+		Labels:       map[string]string{containerLabel: runID},
+		ExposedPorts: []string{port + "/tcp"},
+		PortBindings: map[docker.Port][]docker.PortBinding{
+			docker.Port(port + "/tcp"): {{HostIP: "127.0.0.1"}},
+		},
+	}, func(config *docker.HostConfig) {
+		config.PublishAllPorts = false
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		return "", fmt.Errorf("starting %s:%s: %w", repository, tag, err)
+	}
+	resourcesMu.Lock()
+	resources = append(resources, resource)
+	resourcesMu.Unlock()
+	return resource.GetHostPort(port + "/tcp"), nil
+}
+
+// purgeContainers removes every container this run started
+func purgeContainers() {
+	resourcesMu.Lock()
+	defer resourcesMu.Unlock()
+	for _, resource := range resources {
+		if err := pool.Purge(resource); err != nil {
+			fmt.Fprintf(os.Stderr, "integration: removing %s: %v\n", resource.Container.Name, err)
+		}
+	}
+	resources = nil
+}
+
+// waitFor pings a service until it answers. Containers this run started
+// are retried with backoff for up to serviceTimeout; services given in the
+// environment are expected to be up already.
+func waitFor(ctx context.Context, name string, ping func(context.Context) error) error {
+	var err error
+	if pool != nil {
+		err = pool.Retry(func() error { return ping(ctx) })
+	} else {
+		err = ping(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("%s did not come up: %w", name, err)
+	}
+	return nil
+}
+
+func pingPostgres(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	return conn.Ping(ctx)
+}
+
+func pingRedis(ctx context.Context) error {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return err
+	}
+	client := redis.NewClient(opt)
+	defer client.Close()
+	return client.Ping(ctx).Err()
+}
+
+// applySchema creates the tables; the schema only adds what is missing
+func applySchema(ctx context.Context) error {
+	schema, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return err
+	}
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	if _, err := conn.Exec(ctx, string(schema)); err != nil {
+		return fmt.Errorf("applying %s: %w", schemaFile, err)
+	}
+	return nil
+}
+
+// uniquePrefix keeps a test's documents and channels apart from other
+// runs sharing the services
+func uniquePrefix(name string) string {
+	return "integration-" + name + "-" + protocol.NewID()[:8]
+}
+
+// receive returns the next value from ch, failing the test after a while
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	var zero T
+	return zero
+}
@@ -0,0 +1,96 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// connectRedis returns a connected pub/sub client on channels under
+// prefix, disconnected when t ends
+func connectRedis(t *testing.T, prefix string) *storage.RedisPubSub {
+	t.Helper()
+	pubsub, err := storage.NewRedisPubSub(&storage.RedisPubSubConfig{URL: redisURL, ChannelPrefix: prefix})
+	if err != nil {
+		t.Fatalf("NewRedisPubSub: %v", err)
+	}
+	if err := pubsub.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { pubsub.Disconnect(context.Background()) })
+	return pubsub
+}
+
+func TestRedisPubSub_TwoClients(t *testing.T) {
+	ctx := context.Background()
+	prefix := uniquePrefix("pubsub") + ":"
+	a := connectRedis(t, prefix)
+	b := connectRedis(t, prefix)
+
+	deltas := make(chan string, 4)
+	if err := b.SubscribeToDocument(ctx, "room:1", func(data []byte) { deltas <- string(data) }); err != nil {
+		t.Fatalf("SubscribeToDocument: %v", err)
+	}
+	presence := make(chan string, 4)
+	if err := b.SubscribeToPresence(ctx, func(event, serverID string, metadata map[string]interface{}) {
+		presence <- event + " " + serverID
+	}); err != nil {
+		t.Fatalf("SubscribeToPresence: %v", err)
+	}
+	broadcasts := make(chan string, 4)
+	if err := b.SubscribeToBroadcast(ctx, func(event string, data interface{}) { broadcasts <- event }); err != nil {
+		t.Fatalf("SubscribeToBroadcast: %v", err)
+	}
+
+	if err := a.PublishDelta(ctx, "room:1", map[string]string{"title": "From A"}); err != nil {
+		t.Fatalf("PublishDelta: %v", err)
+	}
+	if got := receive(t, deltas, "the delta"); got != `{"title":"From A"}` {
+		t.Errorf("delta = %s", got)
+	}
+
+	if err := a.AnnouncePresence(ctx, "server-a", map[string]interface{}{"version": "test"}); err != nil {
+		t.Fatalf("AnnouncePresence: %v", err)
+	}
+	if err := a.AnnounceShutdown(ctx, "server-a"); err != nil {
+		t.Fatalf("AnnounceShutdown: %v", err)
+	}
+	for _, want := range []string{"online server-a", "offline server-a"} {
+		if got := receive(t, presence, want); got != want {
+			t.Errorf("presence = %q, want %q", got, want)
+		}
+	}
+
+	if err := a.PublishBroadcast(ctx, "maintenance", map[string]bool{"enabled": true}); err != nil {
+		t.Fatalf("PublishBroadcast: %v", err)
+	}
+	if got := receive(t, broadcasts, "the broadcast"); got != "maintenance" {
+		t.Errorf("broadcast = %q", got)
+	}
+
+	// Awareness one client sets is visible to the other
+	if err := storage.NewRedisAwareness(a, 0).SetAwareness(ctx, "room:1", "client-a", map[string]interface{}{"cursor": 3.0}); err != nil {
+		t.Fatalf("SetAwareness: %v", err)
+	}
+	states, err := storage.NewRedisAwareness(b, 0).GetAwareness(ctx, "room:1")
+	if err != nil || states["client-a"]["cursor"] != 3.0 {
+		t.Errorf("GetAwareness = %v, %v", states, err)
+	}
+
+	// Nothing arrives after unsubscribing
+	if err := b.UnsubscribeFromDocument(ctx, "room:1"); err != nil {
+		t.Fatalf("UnsubscribeFromDocument: %v", err)
+	}
+	if err := a.PublishDelta(ctx, "room:1", map[string]string{"title": "Late"}); err != nil {
+		t.Fatalf("PublishDelta: %v", err)
+	}
+	select {
+	case got := <-deltas:
+		t.Errorf("received %s after unsubscribing", got)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
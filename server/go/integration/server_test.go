@@ -0,0 +1,122 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/server"
+	"github.com/Dancode-188/synckit/server/go/pkg/client"
+)
+
+const testSecret = "integration-secret-that-is-at-least-32-characters"
+
+// startServer serves a SyncKit server persisting to the shared database
+// and relaying through the shared Redis on channels under prefix, and
+// returns its websocket URL
+func startServer(t *testing.T, serverID, prefix string) string {
+	t.Helper()
+	t.Setenv("JWT_SECRET", testSecret)
+	t.Setenv("DATABASE_URL", databaseURL)
+	t.Setenv("REDIS_URL", redisURL)
+	t.Setenv("REDIS_CHANNEL_PREFIX", prefix)
+	t.Setenv("SERVER_ID", serverID)
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	srv := server.New(cfg)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+	return "ws://" + ln.Addr().String() + "/ws"
+}
+
+// openDocument connects to url as userID and opens docID, closing both
+// when t ends
+func openDocument(t *testing.T, url, userID, docID string) *client.Document {
+	t.Helper()
+	perms := auth.DocumentPermissions{CanRead: []string{docID}, CanWrite: []string{docID}}
+	token, err := auth.GenerateAccessToken(userID, "", perms, testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, url, client.Options{Token: token})
+	if err != nil {
+		t.Fatalf("Dial %s: %v", url, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	doc, err := c.Open(ctx, docID)
+	if err != nil {
+		t.Fatalf("Open %s: %v", docID, err)
+	}
+	return doc
+}
+
+// Two servers share a document through Redis and persist it to
+// PostgreSQL, where a third server started later finds it
+func TestServers_RelayAndPersist(t *testing.T) {
+	prefix := uniquePrefix("servers")
+	docID := "room:" + prefix
+	urlA := startServer(t, "server-a", prefix)
+	urlB := startServer(t, "server-b", prefix)
+
+	alice := openDocument(t, urlA, "alice", docID)
+	bob := openDocument(t, urlB, "bob", docID)
+	changes := make(chan map[string]interface{}, 4)
+	bob.OnChange(func(c map[string]interface{}) { changes <- c })
+
+	if err := alice.Update(map[string]interface{}{"title": "Standup", "count": 3}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := alice.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := receive(t, changes, "bob to see alice's change through server B"); got["title"] != "Standup" {
+		t.Errorf("bob saw %v, want alice's title", got)
+	}
+
+	// Acknowledged changes are in PostgreSQL
+	adapter := connectPostgres(t)
+	defer adapter.DeleteDocument(context.Background(), docID)
+	doc, err := adapter.GetDocument(ctx, docID)
+	if err != nil || doc == nil || doc.State["title"] != "Standup" || doc.State["count"] != 3.0 {
+		t.Fatalf("stored document = %+v, %v", doc, err)
+	}
+
+	// Bob's reply reaches alice the other way
+	aliceChanges := make(chan map[string]interface{}, 4)
+	alice.OnChange(func(c map[string]interface{}) { aliceChanges <- c })
+	if err := bob.Set("status", "ready"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, aliceChanges, "alice to see bob's change through server A"); got["status"] != "ready" {
+		t.Errorf("alice saw %v, want bob's status", got)
+	}
+	if err := bob.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	urlC := startServer(t, "server-c", prefix)
+	carol := openDocument(t, urlC, "carol", docID)
+	if state := carol.State(); state["title"] != "Standup" || state["status"] != "ready" {
+		t.Errorf("server C loaded %v, want both changes", state)
+	}
+}
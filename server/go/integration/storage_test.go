@@ -0,0 +1,103 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/Dancode-188/synckit/server/go/pkg/storagetest"
+)
+
+func newPostgres() storage.StorageAdapter {
+	cfg := storage.DefaultStorageConfig()
+	cfg.ConnectionString = databaseURL
+	return storage.NewPostgresAdapter(cfg)
+}
+
+// connectPostgres returns a connected adapter, disconnected when t ends
+func connectPostgres(t *testing.T) storage.StorageAdapter {
+	t.Helper()
+	adapter := newPostgres()
+	if err := adapter.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { adapter.Disconnect(context.Background()) })
+	return adapter
+}
+
+func TestPostgresAdapter_Contract(t *testing.T) {
+	storagetest.RunAdapterTests(t, newPostgres)
+}
+
+func TestPostgresAdapter_RestoresBackupFromMemory(t *testing.T) {
+	ctx := context.Background()
+	source := storage.NewMemoryAdapter()
+	if err := source.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	docID := uniquePrefix("backup")
+	if _, err := source.SaveDocument(ctx, docID, map[string]interface{}{"title": "Standup"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.SaveDelta(ctx, &storage.DeltaEntry{
+		DocumentID: docID, ClientID: "client-1", OperationType: storage.OpSet, FieldPath: "title",
+		Value: map[string]interface{}{"text": "Standup"}, ClockValue: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.MergeVectorClock(ctx, docID, map[string]int64{"client-1": 1}); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if _, err := storage.Backup(ctx, source, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	target := connectPostgres(t)
+	defer target.DeleteDocument(ctx, docID)
+	for _, overwrite := range []bool{false, true} {
+		result, err := storage.Restore(ctx, target, bytes.NewReader(archive.Bytes()), storage.RestoreOptions{Overwrite: overwrite})
+		if err != nil || result.Restored.Documents != 1 || result.Restored.Deltas != 1 {
+			t.Fatalf("Restore (overwrite %v) = %+v, %v", overwrite, result, err)
+		}
+	}
+	doc, err := target.GetDocument(ctx, docID)
+	if err != nil || doc == nil || doc.State["title"] != "Standup" {
+		t.Errorf("restored document = %+v, %v", doc, err)
+	}
+	if clock, _ := target.GetVectorClock(ctx, docID); clock["client-1"] != 1 {
+		t.Errorf("restored clock = %v", clock)
+	}
+	if deltas, _ := target.QueryDeltas(ctx, docID, storage.DeltaQuery{Limit: 10}); len(deltas) != 1 {
+		t.Errorf("restored %d deltas, want 1", len(deltas))
+	}
+}
+
+func TestPostgresAdapter_FlushWatermarks(t *testing.T) {
+	ctx := context.Background()
+	watermarks := connectPostgres(t).(storage.FlushWatermarkStorage)
+	clientID, docID := uniquePrefix("client"), uniquePrefix("flush")
+
+	if entry, err := watermarks.GetFlushWatermark(ctx, clientID, docID); err != nil || entry != nil {
+		t.Fatalf("GetFlushWatermark before any save = %+v, %v; want nil", entry, err)
+	}
+	saves := []storage.FlushWatermarkEntry{
+		{FlushID: "flush-1", Watermark: 4},
+		{FlushID: "flush-1", Watermark: 2}, // Late save of the same flush
+		{FlushID: "flush-2", Watermark: 0}, // New flush starts over
+	}
+	want := []int64{4, 4, 0}
+	for i, save := range saves {
+		save.ClientID, save.DocumentID = clientID, docID
+		if err := watermarks.SaveFlushWatermark(ctx, &save); err != nil {
+			t.Fatalf("SaveFlushWatermark(%+v): %v", save, err)
+		}
+		entry, err := watermarks.GetFlushWatermark(ctx, clientID, docID)
+		if err != nil || entry == nil || entry.Watermark != want[i] {
+			t.Errorf("after save %d watermark = %+v, %v; want %d", i, entry, err, want[i])
+		}
+	}
+}
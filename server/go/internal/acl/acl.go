@@ -0,0 +1,424 @@
+// Package acl grants principals access to individual documents, on top of
+// the document IDs listed in their tokens.
+package acl
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+)
+
+// Role is what a grant allows on a document. Each role includes the ones
+// before it.
+type Role string
+
+// Roles, weakest first
+const (
+	RoleRead  Role = "read"
+	RoleWrite Role = "write"
+	RoleAdmin Role = "admin" // May also grant and revoke access to the document
+)
+
+// ErrInvalidRole is returned for roles other than read, write and admin
+var ErrInvalidRole = errors.New("role must be read, write or admin")
+
+// DefaultCacheTTL is how long a principal's grants are cached
+const DefaultCacheTTL = 30 * time.Second
+
+// lookupTimeout bounds loading a principal's grants when the caller has no
+// deadline of its own
+const lookupTimeout = 5 * time.Second
+
+func (r Role) rank() int {
+	switch r {
+	case RoleRead:
+		return 1
+	case RoleWrite:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// ParseRole checks that role is read, write or admin
+func ParseRole(role string) (Role, error) {
+	if r := Role(role); r.rank() > 0 {
+		return r, nil
+	}
+	return "", ErrInvalidRole
+}
+
+// Allows reports whether r includes want
+func (r Role) Allows(want Role) bool {
+	return r.rank() > 0 && r.rank() >= want.rank()
+}
+
+// Grant gives a principal, a user ID, a role on a document
+type Grant struct {
+	DocumentID string    `json:"documentId"`
+	Principal  string    `json:"principal"`
+	Role       Role      `json:"role"`
+	Fields     []string  `json:"fields,omitempty"` // Field patterns a write grant is limited to; nil for every field
+	GrantedBy  string    `json:"grantedBy,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// DocumentACLStore keeps grants and document owners
+type DocumentACLStore interface {
+	// SaveGrant adds a grant or replaces the principal's role and fields on
+	// the document
+	SaveGrant(ctx context.Context, grant *Grant) error
+	// DeleteGrant reports false if the principal had no grant
+	DeleteGrant(ctx context.Context, docID, principal string) (bool, error)
+	DocumentGrants(ctx context.Context, docID string) ([]*Grant, error)
+	PrincipalGrants(ctx context.Context, principal string) ([]*Grant, error)
+
+	// ClaimDocument records principal as the document's owner unless it
+	// already has one, and returns the owner
+	ClaimDocument(ctx context.Context, docID, principal string) (string, error)
+	// DocumentOwner returns the document's owner, or "" if it has none
+	DocumentOwner(ctx context.Context, docID string) (string, error)
+	OwnedDocuments(ctx context.Context, principal string) ([]string, error)
+}
+
+// MemoryStore is a DocumentACLStore for a single server. Grants are lost on
+// restart.
+//
+// The zero value is ready to use.
+type MemoryStore struct {
+	mu     sync.Mutex
+	grants map[string]map[string]*Grant // document ID -> principal -> grant
+	owners map[string]string            // document ID -> owner
+}
+
+// SaveGrant implements DocumentACLStore
+func (m *MemoryStore) SaveGrant(ctx context.Context, grant *Grant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.grants == nil {
+		m.grants = make(map[string]map[string]*Grant)
+	}
+	if m.grants[grant.DocumentID] == nil {
+		m.grants[grant.DocumentID] = make(map[string]*Grant)
+	}
+	grant.CreatedAt = time.Now()
+	stored := *grant
+	m.grants[grant.DocumentID][grant.Principal] = &stored
+	return nil
+}
+
+// DeleteGrant implements DocumentACLStore
+func (m *MemoryStore) DeleteGrant(ctx context.Context, docID, principal string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.grants[docID][principal]; !ok {
+		return false, nil
+	}
+	delete(m.grants[docID], principal)
+	if len(m.grants[docID]) == 0 {
+		delete(m.grants, docID)
+	}
+	return true, nil
+}
+
+// DocumentGrants implements DocumentACLStore, oldest first
+func (m *MemoryStore) DocumentGrants(ctx context.Context, docID string) ([]*Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	grants := make([]*Grant, 0, len(m.grants[docID]))
+	for _, grant := range m.grants[docID] {
+		copied := *grant
+		grants = append(grants, &copied)
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if !grants[i].CreatedAt.Equal(grants[j].CreatedAt) {
+			return grants[i].CreatedAt.Before(grants[j].CreatedAt)
+		}
+		return grants[i].Principal < grants[j].Principal
+	})
+	return grants, nil
+}
+
+// PrincipalGrants implements DocumentACLStore
+func (m *MemoryStore) PrincipalGrants(ctx context.Context, principal string) ([]*Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var grants []*Grant
+	for _, byPrincipal := range m.grants {
+		if grant, ok := byPrincipal[principal]; ok {
+			copied := *grant
+			grants = append(grants, &copied)
+		}
+	}
+	return grants, nil
+}
+
+// ClaimDocument implements DocumentACLStore
+func (m *MemoryStore) ClaimDocument(ctx context.Context, docID, principal string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if owner, ok := m.owners[docID]; ok {
+		return owner, nil
+	}
+	if m.owners == nil {
+		m.owners = make(map[string]string)
+	}
+	m.owners[docID] = principal
+	return principal, nil
+}
+
+// DocumentOwner implements DocumentACLStore
+func (m *MemoryStore) DocumentOwner(ctx context.Context, docID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.owners[docID], nil
+}
+
+// OwnedDocuments implements DocumentACLStore, sorted by ID
+func (m *MemoryStore) OwnedDocuments(ctx context.Context, principal string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var docIDs []string
+	for docID, owner := range m.owners {
+		if owner == principal {
+			docIDs = append(docIDs, docID)
+		}
+	}
+	sort.Strings(docIDs)
+	return docIDs, nil
+}
+
+// Evaluator decides document access from a token's permissions or, failing
+// those, the principal's grants. A document's owner has the admin role on
+// it. Grants are cached per principal; changes
+// made through the Evaluator invalidate this server's cache at once, and
+// other servers see them when their cache expires.
+//
+// A nil *Evaluator checks token permissions only.
+type Evaluator struct {
+	store DocumentACLStore
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	cache   map[string]cachedGrants // principal -> grants
+	gen     uint64                  // Bumped by Invalidate, so loads racing a change are not cached
+	loading map[string]bool         // Principals being reloaded for CanReadCached
+}
+
+type cachedGrants struct {
+	access  map[string]access // document ID -> access
+	expires time.Time
+}
+
+// access is what a principal's grant or ownership allows on a document
+type access struct {
+	role   Role
+	fields []string // Field patterns writes are limited to, nil for every field
+}
+
+// NewEvaluator creates an Evaluator over store, caching each principal's
+// grants for ttl (0 disables caching)
+func NewEvaluator(store DocumentACLStore, ttl time.Duration) *Evaluator {
+	return &Evaluator{store: store, ttl: ttl, cache: make(map[string]cachedGrants), loading: make(map[string]bool)}
+}
+
+// CanRead reports whether the token may read the document
+func (e *Evaluator) CanRead(ctx context.Context, payload *auth.TokenPayload, docID string) bool {
+	return auth.CanReadDocument(payload, docID) || e.allows(ctx, payload, docID, RoleRead)
+}
+
+// CanReadCached is CanRead for callers that must not wait on the store,
+// such as broadcasts: grants count only once the principal's are cached. An
+// entry is used for up to twice the cache TTL, being reloaded in the
+// background once it expires, so grant holders keep receiving changes while
+// it is refreshed. With caching disabled only token permissions count.
+func (e *Evaluator) CanReadCached(payload *auth.TokenPayload, docID string) bool {
+	if auth.CanReadDocument(payload, docID) {
+		return true
+	}
+	if e == nil || payload == nil || payload.UserID == "" || !auth.WithinTenant(payload, docID) {
+		return false
+	}
+	return e.cachedAccess(payload.UserID)[docID].role.Allows(RoleRead)
+}
+
+// Warm loads a token's grants into the cache ahead of CanReadCached
+func (e *Evaluator) Warm(ctx context.Context, payload *auth.TokenPayload) {
+	if e == nil || payload == nil || payload.UserID == "" {
+		return
+	}
+	e.access(ctx, payload.UserID)
+}
+
+// cachedAccess returns a principal's cached grants without reading the
+// store, starting a reload when they are missing or expired
+func (e *Evaluator) cachedAccess(principal string) map[string]access {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	cached, ok := e.cache[principal]
+	now := time.Now()
+	if e.ttl > 0 && (!ok || !now.Before(cached.expires)) && !e.loading[principal] {
+		e.loading[principal] = true
+		go func() {
+			// access bounds the lookup with lookupTimeout
+			e.access(context.Background(), principal)
+			e.mu.Lock()
+			delete(e.loading, principal)
+			e.mu.Unlock()
+		}()
+	}
+	if !ok || !now.Before(cached.expires.Add(e.ttl)) {
+		return nil
+	}
+	return cached.access
+}
+
+// CanWrite reports whether the token may write to the document
+func (e *Evaluator) CanWrite(ctx context.Context, payload *auth.TokenPayload, docID string) bool {
+	return auth.CanWriteDocument(payload, docID) || e.allows(ctx, payload, docID, RoleWrite)
+}
+
+// WritableFields returns the field patterns writes by the token to a
+// document are limited to, and false if it may write every field. It
+// assumes CanWrite. Unlimited write access from the token or a grant wins;
+// otherwise the limits of both add up.
+func (e *Evaluator) WritableFields(ctx context.Context, payload *auth.TokenPayload, docID string) ([]string, bool) {
+	var patterns []string
+	if auth.CanWriteDocument(payload, docID) {
+		fields, restricted := auth.WritableFields(payload, docID)
+		if !restricted {
+			return nil, false
+		}
+		patterns = fields
+	}
+	if e == nil || payload == nil || payload.UserID == "" {
+		return patterns, true
+	}
+	granted := e.access(ctx, payload.UserID)[docID]
+	if granted.role.Allows(RoleWrite) {
+		if granted.fields == nil {
+			return nil, false
+		}
+		patterns = append(patterns, granted.fields...)
+	}
+	return patterns, true
+}
+
+// CanManage reports whether the token may grant and revoke access to the
+// document: admins, the document's owner and principals granted the admin
+// role on it. Nobody manages documents outside their token's tenant.
+func (e *Evaluator) CanManage(ctx context.Context, payload *auth.TokenPayload, docID string) bool {
+	if !auth.WithinTenant(payload, docID) {
+		return false
+	}
+	if payload != nil && payload.Permissions.IsAdmin {
+		return true
+	}
+	return e.allows(ctx, payload, docID, RoleAdmin)
+}
+
+func (e *Evaluator) allows(ctx context.Context, payload *auth.TokenPayload, docID string, want Role) bool {
+	if e == nil || payload == nil || payload.UserID == "" || !auth.WithinTenant(payload, docID) {
+		return false
+	}
+	return e.access(ctx, payload.UserID)[docID].role.Allows(want)
+}
+
+// access returns what a principal may do by document. A store that cannot
+// be read is logged and grants nothing.
+func (e *Evaluator) access(ctx context.Context, principal string) map[string]access {
+	e.mu.Lock()
+	cached, ok := e.cache[principal]
+	gen := e.gen
+	e.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.access
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, lookupTimeout)
+		defer cancel()
+	}
+	grants, err := e.store.PrincipalGrants(ctx, principal)
+	var owned []string
+	if err == nil {
+		owned, err = e.store.OwnedDocuments(ctx, principal)
+	}
+	if err != nil {
+		slog.Warn("Failed to load document grants", "principal", principal, "err", err)
+		return nil
+	}
+	byDoc := make(map[string]access, len(grants)+len(owned))
+	for _, grant := range grants {
+		byDoc[grant.DocumentID] = access{role: grant.Role, fields: grant.Fields}
+	}
+	for _, docID := range owned {
+		byDoc[docID] = access{role: RoleAdmin}
+	}
+	e.mu.Lock()
+	if e.ttl > 0 && e.gen == gen {
+		e.cache[principal] = cachedGrants{access: byDoc, expires: time.Now().Add(e.ttl)}
+	}
+	e.mu.Unlock()
+	return byDoc
+}
+
+// Grant gives a principal a role on a document, replacing any earlier one.
+// Fields only limit write grants.
+func (e *Evaluator) Grant(ctx context.Context, grant *Grant) error {
+	if _, err := ParseRole(string(grant.Role)); err != nil {
+		return err
+	}
+	if err := auth.ValidateFieldPatterns(grant.Fields); err != nil {
+		return err
+	}
+	defer e.Invalidate(grant.Principal)
+	return e.store.SaveGrant(ctx, grant)
+}
+
+// Revoke removes a principal's grant on a document. It reports false if
+// there was none.
+func (e *Evaluator) Revoke(ctx context.Context, docID, principal string) (bool, error) {
+	defer e.Invalidate(principal)
+	return e.store.DeleteGrant(ctx, docID, principal)
+}
+
+// ClaimDocument makes principal the owner of a document that has none, and
+// returns the document's owner
+func (e *Evaluator) ClaimDocument(ctx context.Context, docID, principal string) (string, error) {
+	owner, err := e.store.ClaimDocument(ctx, docID, principal)
+	if err == nil && owner == principal {
+		e.Invalidate(principal)
+	}
+	return owner, err
+}
+
+// Owner returns a document's owner, or "" if it has none
+func (e *Evaluator) Owner(ctx context.Context, docID string) (string, error) {
+	return e.store.DocumentOwner(ctx, docID)
+}
+
+// OwnedDocuments lists the documents principal owns
+func (e *Evaluator) OwnedDocuments(ctx context.Context, principal string) ([]string, error) {
+	return e.store.OwnedDocuments(ctx, principal)
+}
+
+// Grants lists a document's grants
+func (e *Evaluator) Grants(ctx context.Context, docID string) ([]*Grant, error) {
+	return e.store.DocumentGrants(ctx, docID)
+}
+
+// Invalidate drops a principal's cached grants
+func (e *Evaluator) Invalidate(principal string) {
+	e.mu.Lock()
+	delete(e.cache, principal)
+	e.gen++
+	e.mu.Unlock()
+}
@@ -0,0 +1,200 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+)
+
+// countingStore counts principal lookups and can be made to fail
+type countingStore struct {
+	MemoryStore
+	lookups atomic.Int32
+	fail    atomic.Bool
+}
+
+func (s *countingStore) PrincipalGrants(ctx context.Context, principal string) ([]*Grant, error) {
+	s.lookups.Add(1)
+	if s.fail.Load() {
+		return nil, errors.New("store down")
+	}
+	return s.MemoryStore.PrincipalGrants(ctx, principal)
+}
+
+func user(id string, canRead ...string) *auth.TokenPayload {
+	return &auth.TokenPayload{UserID: id, Permissions: auth.CreateUserPermissions(canRead, nil)}
+}
+
+func TestEvaluator_CombinesTokenPermissionsAndGrants(t *testing.T) {
+	ctx := context.Background()
+	e := NewEvaluator(&MemoryStore{}, time.Minute)
+	e.Grant(ctx, &Grant{DocumentID: "doc-1", Principal: "bob", Role: RoleWrite})
+	e.Grant(ctx, &Grant{DocumentID: "doc-2", Principal: "bob", Role: RoleRead})
+
+	bob := user("bob", "doc-3")
+	for _, tc := range []struct {
+		docID                   string
+		read, write, manageable bool
+	}{
+		{"doc-1", true, true, false},
+		{"doc-2", true, false, false},
+		{"doc-3", true, false, false}, // From the token
+		{"doc-4", false, false, false},
+	} {
+		if got := e.CanRead(ctx, bob, tc.docID); got != tc.read {
+			t.Errorf("CanRead(%s) = %v, want %v", tc.docID, got, tc.read)
+		}
+		if got := e.CanWrite(ctx, bob, tc.docID); got != tc.write {
+			t.Errorf("CanWrite(%s) = %v, want %v", tc.docID, got, tc.write)
+		}
+		if got := e.CanManage(ctx, bob, tc.docID); got != tc.manageable {
+			t.Errorf("CanManage(%s) = %v, want %v", tc.docID, got, tc.manageable)
+		}
+	}
+
+	admin := &auth.TokenPayload{UserID: "root", Permissions: auth.CreateAdminPermissions()}
+	if !e.CanManage(ctx, admin, "doc-4") {
+		t.Error("admin cannot manage doc-4")
+	}
+	var none *Evaluator
+	if none.CanRead(ctx, bob, "doc-1") || !none.CanRead(ctx, bob, "doc-3") {
+		t.Error("nil Evaluator should check token permissions only")
+	}
+	if err := e.Grant(ctx, &Grant{DocumentID: "doc-1", Principal: "bob", Role: "owner"}); err != ErrInvalidRole {
+		t.Errorf("unknown role error = %v, want ErrInvalidRole", err)
+	}
+}
+
+func TestEvaluator_CachesAndInvalidatesOnChange(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{}
+	e := NewEvaluator(store, time.Hour)
+	bob := user("bob")
+
+	e.Grant(ctx, &Grant{DocumentID: "doc-1", Principal: "bob", Role: RoleRead})
+	for i := 0; i < 3; i++ {
+		if !e.CanRead(ctx, bob, "doc-1") {
+			t.Fatal("granted read refused")
+		}
+	}
+	if got := store.lookups.Load(); got != 1 {
+		t.Errorf("lookups = %d, want 1 while cached", got)
+	}
+
+	if revoked, _ := e.Revoke(ctx, "doc-1", "bob"); !revoked {
+		t.Fatal("Revoke reported no grant")
+	}
+	if e.CanRead(ctx, bob, "doc-1") {
+		t.Error("read allowed after revoke")
+	}
+
+	// A store that cannot be read grants nothing
+	e.Grant(ctx, &Grant{DocumentID: "doc-1", Principal: "bob", Role: RoleRead})
+	store.fail.Store(true)
+	if e.CanRead(ctx, bob, "doc-1") {
+		t.Error("read allowed with the store down")
+	}
+}
+
+// blockingStore holds principal lookups until release is closed
+type blockingStore struct {
+	MemoryStore
+	release chan struct{}
+	lookups atomic.Int32
+}
+
+func (s *blockingStore) PrincipalGrants(ctx context.Context, principal string) ([]*Grant, error) {
+	s.lookups.Add(1)
+	<-s.release
+	return s.MemoryStore.PrincipalGrants(ctx, principal)
+}
+
+func TestEvaluator_CanReadCachedNeverWaits(t *testing.T) {
+	ctx := context.Background()
+	store := &blockingStore{release: make(chan struct{})}
+	store.SaveGrant(ctx, &Grant{DocumentID: "doc-1", Principal: "bob", Role: RoleRead})
+	e := NewEvaluator(store, 50*time.Millisecond)
+	bob := user("bob", "doc-2")
+
+	// With nothing cached only the token counts, and the grants load in
+	// the background
+	done := make(chan bool)
+	go func() { done <- e.CanReadCached(bob, "doc-1") && e.CanReadCached(bob, "doc-2") }()
+	select {
+	case both := <-done:
+		if both {
+			t.Error("uncached grant allowed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("CanReadCached waited on the store")
+	}
+	close(store.release)
+	waitFor(t, func() bool { return e.CanReadCached(bob, "doc-1") })
+	if got := store.lookups.Load(); got != 1 {
+		t.Errorf("lookups = %d, want one background load", got)
+	}
+
+	// An expired entry still counts while it is reloaded, but not beyond
+	// twice the TTL
+	time.Sleep(60 * time.Millisecond)
+	if !e.CanReadCached(bob, "doc-1") {
+		t.Error("expired grant refused while reloading")
+	}
+	waitFor(t, func() bool { return store.lookups.Load() == 2 })
+
+	// Invalidating drops it at once
+	e.Invalidate("bob")
+	if e.CanReadCached(bob, "doc-1") {
+		t.Error("grant allowed after Invalidate")
+	}
+	var none *Evaluator
+	if none.CanReadCached(bob, "doc-1") || !none.CanReadCached(bob, "doc-2") {
+		t.Error("nil Evaluator should check token permissions only")
+	}
+}
+
+// waitFor polls cond for up to two seconds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEvaluator_WritableFields(t *testing.T) {
+	ctx := context.Background()
+	e := NewEvaluator(&MemoryStore{}, time.Minute)
+	e.Grant(ctx, &Grant{DocumentID: "spec", Principal: "bob", Role: RoleWrite, Fields: []string{"comments.*"}})
+	e.Grant(ctx, &Grant{DocumentID: "notes", Principal: "bob", Role: RoleWrite})
+
+	bob := user("bob")
+	if fields, limited := e.WritableFields(ctx, bob, "spec"); !limited || len(fields) != 1 || fields[0] != "comments.*" {
+		t.Errorf("WritableFields(spec) = %v %v, want the grant's fields", fields, limited)
+	}
+	if _, limited := e.WritableFields(ctx, bob, "notes"); limited {
+		t.Error("grant without fields limits writes")
+	}
+
+	// Unlimited write access from the token wins over a limited grant
+	bob.Permissions.CanWrite = []string{"spec"}
+	if _, limited := e.WritableFields(ctx, bob, "spec"); limited {
+		t.Error("token write access limited by a grant's fields")
+	}
+	// Limits from both add up
+	bob.Permissions.CanWriteFields = map[string][]string{"spec": {"title"}}
+	if fields, limited := e.WritableFields(ctx, bob, "spec"); !limited || len(fields) != 2 {
+		t.Errorf("WritableFields(spec) = %v %v, want the token's and the grant's fields", fields, limited)
+	}
+
+	if err := e.Grant(ctx, &Grant{DocumentID: "spec", Principal: "bob", Role: RoleWrite, Fields: []string{"a..b"}}); !errors.Is(err, auth.ErrInvalidPermissions) {
+		t.Errorf("Grant with a bad field pattern: error = %v, want ErrInvalidPermissions", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Package audit records security-relevant events: authentication failures,
+// permission denials, rate-limit hits, bans and administrator actions.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// Event types
+const (
+	EventAuthFailure      = "auth_failure"
+	EventPermissionDenied = "permission_denied"
+	EventRateLimited      = "rate_limited"
+	EventQuotaExceeded    = "quota_exceeded"
+	EventBan              = "ban"
+	EventUnban            = "unban"
+	EventAdminDisconnect  = "admin_disconnect"
+	EventAdminCompact     = "admin_compact"
+	EventAdminRestore     = "admin_restore"
+	EventAdminCleanup     = "admin_cleanup"
+	EventAdminBackup      = "admin_backup"
+	EventAdminMaintenance = "admin_maintenance"
+	EventAdminRevoke      = "admin_revoke"
+	EventAdminAPIKey      = "admin_api_key"
+	EventAdminSchema      = "admin_schema"
+	EventDocumentGrant    = "document_grant"
+	EventDocumentShare    = "document_share"
+)
+
+// Event is one audited occurrence
+type Event struct {
+	Type         string                 `json:"type"`
+	Actor        string                 `json:"actor,omitempty"` // User ID, when known
+	DocumentID   string                 `json:"documentId,omitempty"`
+	ConnectionID string                 `json:"connectionId,omitempty"`
+	IP           string                 `json:"ip,omitempty"`
+	Timestamp    time.Time              `json:"timestamp"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+}
+
+// AuditLogger records events. Log must not block the caller for long; it is
+// called from message handling paths.
+type AuditLogger interface {
+	Log(event Event)
+}
+
+// Nop discards every event
+type Nop struct{}
+
+// Log discards event
+func (Nop) Log(Event) {}
+
+// Multi sends every event to each of its loggers
+type Multi []AuditLogger
+
+// Log records event with every logger
+func (m Multi) Log(event Event) {
+	for _, l := range m {
+		l.Log(event)
+	}
+}
+
+// stamp fills in the timestamp of an event that has none
+func stamp(event Event) Event {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	return event
+}
+
+// LogLogger writes each event as an "Audit event" log record with the event
+// fields as attributes
+type LogLogger struct {
+	logger *slog.Logger
+}
+
+// NewLogLogger creates a logger writing to logger
+func NewLogLogger(logger *slog.Logger) *LogLogger {
+	return &LogLogger{logger: logger}
+}
+
+// Log writes event
+func (l *LogLogger) Log(event Event) {
+	attrs := []slog.Attr{slog.String("event", event.Type)}
+	for _, field := range []struct{ key, value string }{
+		{"actor", event.Actor},
+		{"doc_id", event.DocumentID},
+		{"conn_id", event.ConnectionID},
+		{"ip", event.IP},
+	} {
+		if field.value != "" {
+			attrs = append(attrs, slog.String(field.key, field.value))
+		}
+	}
+	if len(event.Details) > 0 {
+		attrs = append(attrs, slog.Any("details", event.Details))
+	}
+	l.logger.LogAttrs(context.Background(), slog.LevelInfo, "Audit event", attrs...)
+}
+
+// EventStore saves audit events. storage.StorageAdapter implements it.
+type EventStore interface {
+	SaveAuditEvent(ctx context.Context, event *storage.AuditEventEntry) (*storage.AuditEventEntry, error)
+}
+
+// Default StorageLogger settings
+const (
+	DefaultQueueSize    = 1024
+	DefaultWriteTimeout = 5 * time.Second
+)
+
+// StorageLogger saves events to an EventStore from a background goroutine.
+// Events arriving while the queue is full are dropped and counted.
+type StorageLogger struct {
+	store   EventStore
+	queue   chan Event
+	dropped atomic.Int64
+	done    chan struct{}
+	closeMu sync.Once
+}
+
+// NewStorageLogger creates a logger saving to store. Close it to flush.
+func NewStorageLogger(store EventStore) *StorageLogger {
+	l := &StorageLogger{
+		store: store,
+		queue: make(chan Event, DefaultQueueSize),
+		done:  make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Log queues event for saving
+func (l *StorageLogger) Log(event Event) {
+	select {
+	case l.queue <- stamp(event):
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many events were dropped because the queue was full
+func (l *StorageLogger) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+func (l *StorageLogger) run() {
+	defer close(l.done)
+	for event := range l.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultWriteTimeout)
+		_, err := l.store.SaveAuditEvent(ctx, &storage.AuditEventEntry{
+			EventType:    event.Type,
+			Actor:        event.Actor,
+			DocumentID:   event.DocumentID,
+			ConnectionID: event.ConnectionID,
+			IP:           event.IP,
+			Details:      event.Details,
+			CreatedAt:    event.Timestamp,
+		})
+		cancel()
+		if err != nil {
+			slog.Warn("Failed to save audit event", "event", event.Type, "err", err)
+		}
+	}
+}
+
+// Close saves the queued events and stops the logger. Log must not be called
+// afterwards.
+func (l *StorageLogger) Close() {
+	l.closeMu.Do(func() { close(l.queue) })
+	<-l.done
+}
@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/Dancode-188/synckit/server/go/pkg/storagefake"
+)
+
+func jsonLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, nil))
+}
+
+func TestLogLogger_WritesStructuredRecord(t *testing.T) {
+	var buf bytes.Buffer
+	NewLogLogger(jsonLogger(&buf)).Log(Event{
+		Type:       EventPermissionDenied,
+		Actor:      "alice",
+		DocumentID: "room:1",
+		Details:    map[string]interface{}{"action": "delta"},
+	})
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("record is not JSON: %v", err)
+	}
+	if decoded["msg"] != "Audit event" || decoded["event"] != EventPermissionDenied ||
+		decoded["actor"] != "alice" || decoded["doc_id"] != "room:1" {
+		t.Errorf("decoded = %v", decoded)
+	}
+	if details, _ := decoded["details"].(map[string]interface{}); details["action"] != "delta" {
+		t.Errorf("details = %v", decoded["details"])
+	}
+	if _, ok := decoded["ip"]; ok {
+		t.Errorf("empty fields should be omitted: %v", decoded)
+	}
+}
+
+type fakeStore struct {
+	mu    sync.Mutex
+	saved []*storage.AuditEventEntry
+}
+
+func (f *fakeStore) SaveAuditEvent(ctx context.Context, event *storage.AuditEventEntry) (*storage.AuditEventEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.saved = append(f.saved, event)
+	return event, nil
+}
+
+func TestStorageLogger_SavesEventsByClose(t *testing.T) {
+	store := &fakeStore{}
+	l := NewStorageLogger(store)
+	l.Log(Event{Type: EventBan, IP: "10.0.0.1", Details: map[string]interface{}{"reason": "abuse"}})
+	l.Log(Event{Type: EventUnban, IP: "10.0.0.1"})
+	l.Close()
+
+	if len(store.saved) != 2 {
+		t.Fatalf("saved %d events, want 2", len(store.saved))
+	}
+	first := store.saved[0]
+	if first.EventType != EventBan || first.IP != "10.0.0.1" || first.Details["reason"] != "abuse" || first.CreatedAt.IsZero() {
+		t.Errorf("first = %+v", first)
+	}
+}
+
+func TestStorageLogger_SurvivesStoreErrors(t *testing.T) {
+	store := storagefake.New()
+	store.Fail("SaveAuditEvent", errors.New("down"))
+	l := NewStorageLogger(store)
+	l.Log(Event{Type: EventAuthFailure})
+	l.Log(Event{Type: EventRateLimited})
+	l.Close()
+	l.Close() // Safe to call twice
+	if calls := store.Calls("SaveAuditEvent"); calls != 2 {
+		t.Errorf("tried to save %d events, want 2", calls)
+	}
+}
+
+func TestMulti_LogsToEveryLogger(t *testing.T) {
+	var a, b bytes.Buffer
+	Multi{NewLogLogger(jsonLogger(&a)), Nop{}, NewLogLogger(jsonLogger(&b))}.Log(Event{Type: EventRateLimited})
+	if !strings.Contains(a.String(), EventRateLimited) || !strings.Contains(b.String(), EventRateLimited) {
+		t.Errorf("outputs = %q, %q; want both to carry the event", a.String(), b.String())
+	}
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Errors for API key authentication
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrRevokedAPIKey = errors.New("API key revoked")
+)
+
+// apiKeyPrefix starts every generated API key, so leaked keys are easy to
+// spot
+const apiKeyPrefix = "sk_"
+
+// APIKeyUserPrefix starts the user ID of clients authenticated with an API
+// key, followed by the key's ID
+const APIKeyUserPrefix = "apikey:"
+
+// APIKey is a long-lived credential for server-to-server clients. Only a
+// hash of the secret is kept.
+type APIKey struct {
+	ID          string              `json:"id"`
+	Name        string              `json:"name"`
+	Prefix      string              `json:"prefix,omitempty"` // Start of the secret, to tell keys apart
+	Hash        string              `json:"-"`                // Hex SHA-256 of the secret
+	Permissions DocumentPermissions `json:"permissions"`
+	CreatedBy   string              `json:"createdBy,omitempty"`
+	CreatedAt   time.Time           `json:"createdAt"`
+	RevokedAt   *time.Time          `json:"revokedAt,omitempty"`
+	Configured  bool                `json:"configured,omitempty"` // From configuration, so not revocable at runtime
+}
+
+// UserID is the user ID clients authenticated with the key act as
+func (k *APIKey) UserID() string {
+	return APIKeyUserPrefix + k.ID
+}
+
+// payload is the synthetic token of clients authenticated with the key.
+// It is issued now, so revoking the key's user ID cuts off connections
+// already open.
+func (k *APIKey) payload() *TokenPayload {
+	return &TokenPayload{
+		UserID:      k.UserID(),
+		Permissions: k.Permissions,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  k.Name,
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+	}
+}
+
+// HashAPIKey returns the hex SHA-256 of an API key, as stored
+func HashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey generates an API key with the given permissions. The secret is
+// returned once and only its hash kept in the key.
+func NewAPIKey(name string, permissions DocumentPermissions) (string, *APIKey, error) {
+	if err := ValidatePermissions(permissions); err != nil {
+		return "", nil, err
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+	random, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+	secret := apiKeyPrefix + random
+	return secret, &APIKey{
+		ID:          id[:12],
+		Name:        name,
+		Prefix:      secret[:len(apiKeyPrefix)+6],
+		Hash:        HashAPIKey(secret),
+		Permissions: permissions,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// APIKeyStore keeps API keys created at runtime
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	ListAPIKeys(ctx context.Context) ([]*APIKey, error)
+	// RevokeAPIKey reports false if no unrevoked key has the ID
+	RevokeAPIKey(ctx context.Context, id string) (bool, error)
+	// FindAPIKey returns the key with the hash, revoked or not, or nil
+	FindAPIKey(ctx context.Context, hash string) (*APIKey, error)
+}
+
+// MemoryAPIKeys is an APIKeyStore for a single server. Keys are lost on
+// restart.
+//
+// The zero value is ready to use.
+type MemoryAPIKeys struct {
+	mu   sync.Mutex
+	keys map[string]*APIKey // ID -> key
+}
+
+// CreateAPIKey implements APIKeyStore
+func (m *MemoryAPIKeys) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keys == nil {
+		m.keys = make(map[string]*APIKey)
+	}
+	stored := *key
+	m.keys[key.ID] = &stored
+	return nil
+}
+
+// ListAPIKeys implements APIKeyStore, oldest first
+func (m *MemoryAPIKeys) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]*APIKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		copied := *key
+		keys = append(keys, &copied)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// RevokeAPIKey implements APIKeyStore
+func (m *MemoryAPIKeys) RevokeAPIKey(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[id]
+	if !ok || key.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	return true, nil
+}
+
+// FindAPIKey implements APIKeyStore
+func (m *MemoryAPIKeys) FindAPIKey(ctx context.Context, hash string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range m.keys {
+		if key.Hash == hash {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// APIKeys authenticates API keys: those configured up front and those
+// created at runtime in a store
+type APIKeys struct {
+	configured []*APIKey
+	store      APIKeyStore
+}
+
+// NewAPIKeys parses configured keys written as "name:sha256" or
+// "name:sha256:role", where sha256 is the hex SHA-256 of the key (see
+// HashAPIKey) and role is admin, writer (the default) or reader. The name
+// doubles as the key's ID. Keys created at runtime live in store.
+func NewAPIKeys(entries []string, store APIKeyStore) (*APIKeys, error) {
+	a := &APIKeys{store: store}
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("key %q must be name:sha256 or name:sha256:role", entry)
+		}
+		name, hash := parts[0], strings.ToLower(parts[1])
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("key %s: hash must be 64 hex characters", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("key %s is listed twice", name)
+		}
+		seen[name] = true
+		role := RoleWriter
+		if len(parts) == 3 {
+			role = parts[2]
+		}
+		perms, err := rolePermissions(role)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", name, err)
+		}
+		a.configured = append(a.configured, &APIKey{ID: name, Name: name, Hash: hash, Permissions: perms, Configured: true})
+	}
+	return a, nil
+}
+
+// Store is where keys created at runtime are kept
+func (a *APIKeys) Store() APIKeyStore {
+	return a.store
+}
+
+// Authenticate resolves an API key to the token of the client presenting
+// it, so permission checks treat it like a JWT. It returns ErrInvalidAPIKey
+// for an unknown key and ErrRevokedAPIKey for a revoked one; other errors
+// mean the store could not be read.
+func (a *APIKeys) Authenticate(ctx context.Context, secret string) (*TokenPayload, error) {
+	if secret == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	hash := HashAPIKey(secret)
+	// Every configured key is compared, so timing does not tell them apart
+	var match *APIKey
+	for _, key := range a.configured {
+		if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) == 1 {
+			match = key
+		}
+	}
+	if match != nil {
+		return match.payload(), nil
+	}
+
+	if a.store == nil {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := a.store.FindAPIKey(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrRevokedAPIKey
+	}
+	return key.payload(), nil
+}
+
+// List returns the configured keys followed by the stored ones
+func (a *APIKeys) List(ctx context.Context) ([]*APIKey, error) {
+	keys := make([]*APIKey, 0, len(a.configured))
+	for _, key := range a.configured {
+		copied := *key
+		keys = append(keys, &copied)
+	}
+	if a.store == nil {
+		return keys, nil
+	}
+	stored, err := a.store.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append(keys, stored...), nil
+}
+
+// IsConfigured reports whether id names a configured key
+func (a *APIKeys) IsConfigured(id string) bool {
+	for _, key := range a.configured {
+		if key.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeys_ConfiguredKeys(t *testing.T) {
+	keys, err := NewAPIKeys([]string{
+		"worker:" + HashAPIKey("worker-secret"),
+		"reporting:" + strings.ToUpper(HashAPIKey("reporting-secret")) + ":reader",
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewAPIKeys failed: %v", err)
+	}
+	ctx := context.Background()
+
+	payload, err := keys.Authenticate(ctx, "worker-secret")
+	if err != nil || payload.UserID != "apikey:worker" || !CanWriteDocument(payload, "doc-1") {
+		t.Errorf("worker key = %+v, %v; want apikey:worker with write access", payload, err)
+	}
+	payload, err = keys.Authenticate(ctx, "reporting-secret")
+	if err != nil || !CanReadDocument(payload, "doc-1") || CanWriteDocument(payload, "doc-1") {
+		t.Errorf("reader key = %+v, %v; want read-only access", payload, err)
+	}
+	for _, secret := range []string{"", "wrong", HashAPIKey("worker-secret")} {
+		if _, err := keys.Authenticate(ctx, secret); err != ErrInvalidAPIKey {
+			t.Errorf("Authenticate(%q) error = %v, want ErrInvalidAPIKey", secret, err)
+		}
+	}
+	if !keys.IsConfigured("worker") || keys.IsConfigured("other") {
+		t.Error("IsConfigured does not match the configured keys")
+	}
+}
+
+func TestNewAPIKeys_RejectsBadEntries(t *testing.T) {
+	hash := HashAPIKey("secret")
+	for _, entries := range [][]string{
+		{"worker"},
+		{"worker:not-hex"},
+		{"worker:" + hash[:60]},
+		{"worker:" + hash + ":owner"},
+		{"worker:" + hash, "worker:" + hash},
+		{":" + hash},
+	} {
+		if _, err := NewAPIKeys(entries, nil); err == nil {
+			t.Errorf("NewAPIKeys(%q) succeeded, want an error", entries)
+		}
+	}
+}
+
+func TestAPIKeys_StoredKeys(t *testing.T) {
+	store := &MemoryAPIKeys{}
+	keys, _ := NewAPIKeys(nil, store)
+	ctx := context.Background()
+
+	secret, key, err := NewAPIKey("ingest", CreateUserPermissions([]string{"doc-1"}, []string{"doc-1"}))
+	if err != nil {
+		t.Fatalf("NewAPIKey failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, "sk_") || !strings.HasPrefix(secret, key.Prefix) || key.Hash != HashAPIKey(secret) {
+		t.Errorf("key = %+v for %q, want its prefix and hash", key, secret)
+	}
+	store.CreateAPIKey(ctx, key)
+
+	// Permissions are scoped to the key's documents
+	payload, err := keys.Authenticate(ctx, secret)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if payload.UserID != "apikey:"+key.ID || !CanWriteDocument(payload, "doc-1") || CanReadDocument(payload, "doc-2") {
+		t.Errorf("payload = %+v, want access to doc-1 only", payload)
+	}
+
+	if revoked, _ := store.RevokeAPIKey(ctx, key.ID); !revoked {
+		t.Fatal("RevokeAPIKey = false, want true")
+	}
+	if revoked, _ := store.RevokeAPIKey(ctx, key.ID); revoked {
+		t.Error("revoking twice = true, want false")
+	}
+	if _, err := keys.Authenticate(ctx, secret); err != ErrRevokedAPIKey {
+		t.Errorf("revoked key error = %v, want ErrRevokedAPIKey", err)
+	}
+	listed, _ := keys.List(ctx)
+	if len(listed) != 1 || listed[0].RevokedAt == nil {
+		t.Errorf("List = %+v, want the revoked key", listed)
+	}
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidCredentials is returned for an unknown user or a wrong password
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// User is who a set of credentials belongs to and what they may access
+type User struct {
+	UserID      string
+	Email       string
+	Permissions DocumentPermissions
+	TenantID    string // Tenant the user's tokens are confined to, if any
+}
+
+// CredentialVerifier checks the credentials exchanged for tokens at
+// POST /auth/token. It returns ErrInvalidCredentials when they do not match
+// a user; other errors mean the check itself failed.
+type CredentialVerifier interface {
+	VerifyCredentials(ctx context.Context, email, password string) (*User, error)
+}
+
+// StaticUsers verifies credentials against a fixed list of users, for
+// development and tests
+type StaticUsers struct {
+	users map[string]staticUser // email -> user
+}
+
+type staticUser struct {
+	password string
+	user     User
+}
+
+// Roles of static users
+const (
+	RoleAdmin  = "admin"  // Admin permissions
+	RoleWriter = "writer" // Read and write every document (the default)
+	RoleReader = "reader" // Read every document
+)
+
+// NewStaticUsers parses users written as "email:password",
+// "email:password:role" or "email:password:role:tenant". The email doubles
+// as the user ID.
+func NewStaticUsers(entries []string) (*StaticUsers, error) {
+	s := &StaticUsers{users: make(map[string]staticUser, len(entries))}
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 4 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("user %q must be email:password, email:password:role or email:password:role:tenant", entry)
+		}
+		role := RoleWriter
+		if len(parts) >= 3 && parts[2] != "" {
+			role = parts[2]
+		}
+		perms, err := rolePermissions(role)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", parts[0], err)
+		}
+		var tenantID string
+		if len(parts) == 4 {
+			tenantID = parts[3]
+			if err := ValidateTenantID(tenantID); err != nil {
+				return nil, fmt.Errorf("user %s: %w", parts[0], err)
+			}
+		}
+		s.users[parts[0]] = staticUser{
+			password: parts[1],
+			user:     User{UserID: parts[0], Email: parts[0], Permissions: perms, TenantID: tenantID},
+		}
+	}
+	return s, nil
+}
+
+// rolePermissions returns the permissions of a role over every document
+func rolePermissions(role string) (DocumentPermissions, error) {
+	switch role {
+	case RoleAdmin:
+		return CreateAdminPermissions(), nil
+	case RoleWriter:
+		return CreateUserPermissions([]string{"*"}, []string{"*"}), nil
+	case RoleReader:
+		return CreateUserPermissions([]string{"*"}, []string{}), nil
+	}
+	return DocumentPermissions{}, fmt.Errorf("role must be admin, writer or reader (got %q)", role)
+}
+
+// VerifyCredentials implements CredentialVerifier
+func (s *StaticUsers) VerifyCredentials(ctx context.Context, email, password string) (*User, error) {
+	entry, ok := s.users[email]
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(entry.password)) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	user := entry.user
+	return &user, nil
+}
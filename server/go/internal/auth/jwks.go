@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults for JWKSOptions
+const (
+	DefaultJWKSRefreshInterval    = time.Hour
+	DefaultJWKSMinRefreshInterval = 30 * time.Second
+	defaultJWKSTimeout            = 10 * time.Second
+
+	// maxJWKSSize bounds the key set response; real sets are a few KB
+	maxJWKSSize = 1 << 20
+)
+
+// ErrUnknownKey is returned for a key ID the JWKS does not list
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// JWKSOptions configures a JWKS
+type JWKSOptions struct {
+	// RefreshInterval is how long fetched keys are used before they are
+	// fetched again (default DefaultJWKSRefreshInterval)
+	RefreshInterval time.Duration
+
+	// MinRefreshInterval is the least time between fetches triggered by
+	// unknown key IDs, so forged kids cannot flood the endpoint (default
+	// DefaultJWKSMinRefreshInterval)
+	MinRefreshInterval time.Duration
+
+	// HTTPClient fetches the key set (default: a client with a 10 second
+	// timeout)
+	HTTPClient *http.Client
+}
+
+// JWKS serves public keys from a JSON Web Key Set endpoint, such as an
+// identity provider's. Keys are cached, fetched again in the background after
+// the refresh interval, and on an unknown key ID as key rotation would cause.
+// If a fetch fails the keys already held stay in use.
+type JWKS struct {
+	url  string
+	opts JWKSOptions
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey // kid -> key
+	fetchedAt time.Time                   // Last successful fetch
+	triedAt   time.Time                   // Last fetch attempt
+	fetching  *jwksFetch                  // The fetch under way, if any
+}
+
+// jwksFetch is a fetch of the key set running in the background
+type jwksFetch struct {
+	done chan struct{} // Closed when the fetch ends
+	err  error         // Set before done is closed
+}
+
+// NewJWKS creates a key set fetched from url on first use
+func NewJWKS(url string, opts JWKSOptions) *JWKS {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = DefaultJWKSRefreshInterval
+	}
+	if opts.MinRefreshInterval <= 0 {
+		opts.MinRefreshInterval = DefaultJWKSMinRefreshInterval
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: defaultJWKSTimeout}
+	}
+	return &JWKS{url: url, opts: opts}
+}
+
+// Key returns the key with the given ID. An empty kid matches the only key
+// of a single-key set. A known key is returned at once, stale or not; only
+// an unknown kid waits for the key set to be fetched.
+func (j *JWKS) Key(kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	now := time.Now()
+	key, known := j.lookup(kid)
+	var fetch *jwksFetch
+	if !known || now.Sub(j.fetchedAt) >= j.opts.RefreshInterval {
+		fetch = j.refresh(now)
+	}
+	j.mu.Unlock()
+	if known {
+		return key, nil
+	}
+	if fetch == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+
+	<-fetch.done
+	j.mu.Lock()
+	key, known = j.lookup(kid)
+	j.mu.Unlock()
+	if !known {
+		if fetch.err != nil {
+			return nil, fetch.err
+		}
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+// refresh starts fetching the key set in the background, unless a fetch is
+// under way or the last began less than MinRefreshInterval ago, and returns
+// the fetch under way or nil. Must be called with mu held.
+func (j *JWKS) refresh(now time.Time) *jwksFetch {
+	if j.fetching != nil || now.Sub(j.triedAt) < j.opts.MinRefreshInterval {
+		return j.fetching
+	}
+	j.triedAt = now
+	fetch := &jwksFetch{done: make(chan struct{})}
+	j.fetching = fetch
+	go func() {
+		keys, err := j.fetch()
+		j.mu.Lock()
+		if err == nil {
+			j.keys = keys
+			j.fetchedAt = now
+		}
+		j.fetching = nil
+		j.mu.Unlock()
+		fetch.err = err
+		close(fetch.done)
+	}()
+	return fetch
+}
+
+// lookup finds a cached key. Must be called with mu held.
+func (j *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// fetch reads the endpoint's keys
+func (j *JWKS) fetch() (map[string]crypto.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultJWKSTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	if len(body) > maxJWKSSize {
+		return nil, fmt.Errorf("fetching JWKS: response exceeds %d bytes", maxJWKSSize)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys of types this server cannot use are skipped, not fatal
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.KID] = key
+		}
+	}
+	return keys, nil
+}
+
+// jsonWebKey is the part of an RFC 7517 key used here
+type jsonWebKey struct {
+	KTY string `json:"kty"`
+	KID string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+	CRV string `json:"crv"` // EC curve
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KTY {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.CRV != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.CRV)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if x.BitLen() > 256 || y.BitLen() > 256 {
+			return nil, errors.New("EC point is not on P-256")
+		}
+		point := make([]byte, 65)
+		point[0] = 4 // Uncompressed
+		x.FillBytes(point[1:33])
+		y.FillBytes(point[33:])
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, errors.New("EC point is not on P-256")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KTY)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -3,6 +3,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -15,6 +17,11 @@ type DocumentPermissions struct {
 	CanRead  []string `json:"canRead"`  // Document IDs user can read
 	CanWrite []string `json:"canWrite"` // Document IDs user can write
 	IsAdmin  bool     `json:"isAdmin"`  // Admin has access to all documents
+
+	// CanWriteFields limits writes to the documents an entry's key covers
+	// (an ID or pattern, as in CanWrite) to the fields matching its
+	// patterns. Documents no key covers have every field writable.
+	CanWriteFields map[string][]string `json:"canWriteFields,omitempty"`
 }
 
 // TokenPayload represents JWT token claims
@@ -22,9 +29,56 @@ type TokenPayload struct {
 	UserID      string              `json:"userId"`
 	Email       string              `json:"email,omitempty"`
 	Permissions DocumentPermissions `json:"permissions"`
+	Type        string              `json:"type,omitempty"` // TokenTypeRefresh, or empty for access tokens
+
+	// TenantID confines the token to the documents of one tenant, those
+	// whose IDs start with "<tenantId>:" (see WithinTenant)
+	TenantID string `json:"tenantId,omitempty"`
+
 	jwt.RegisteredClaims
 }
 
+// TokenTypeRefresh marks refresh tokens, which only buy new tokens
+const TokenTypeRefresh = "refresh"
+
+// Lifetimes of issued tokens
+const (
+	DefaultAccessTokenTTL  = 24 * time.Hour
+	DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// ClaimRules are registered claims tokens are issued with and must carry.
+// The zero value checks neither issuer nor audience and allows no clock
+// skew.
+type ClaimRules struct {
+	Issuer   string        // Required iss, when set
+	Audience string        // Required aud entry, when set
+	Leeway   time.Duration // Clock skew allowed for exp, nbf and iat
+}
+
+// parserOptions are the jwt parser options enforcing r
+func (r ClaimRules) parserOptions() []jwt.ParserOption {
+	var options []jwt.ParserOption
+	if r.Issuer != "" {
+		options = append(options, jwt.WithIssuer(r.Issuer))
+	}
+	if r.Audience != "" {
+		options = append(options, jwt.WithAudience(r.Audience))
+	}
+	if r.Leeway > 0 {
+		options = append(options, jwt.WithLeeway(r.Leeway))
+	}
+	return options
+}
+
+// stamp sets the issuer and audience on claims being issued
+func (r ClaimRules) stamp(claims *jwt.RegisteredClaims) {
+	claims.Issuer = r.Issuer
+	if r.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{r.Audience}
+	}
+}
+
 // Errors for JWT validation
 var (
 	ErrInvalidToken = errors.New("invalid token")
@@ -38,20 +92,56 @@ var (
 // - Validate signature with secret
 // - Check expiration
 // - Return nil on any error (no exceptions propagated)
+//
+// Refresh tokens are rejected; use VerifyRefreshToken for those.
 func VerifyToken(tokenString, secret string) (*TokenPayload, error) {
+	return VerifyTokenWithRules(tokenString, secret, ClaimRules{})
+}
+
+// VerifyTokenWithRules is VerifyToken also enforcing rules
+func VerifyTokenWithRules(tokenString, secret string, rules ClaimRules) (*TokenPayload, error) {
+	claims, err := parseToken(tokenString, secret, rules.parserOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type == TokenTypeRefresh {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// VerifyRefreshToken verifies and decodes a refresh token made by
+// IssueRefreshToken with the same rules
+func VerifyRefreshToken(tokenString, secret string, rules ClaimRules) (*TokenPayload, error) {
+	claims, err := parseToken(tokenString, secret, rules.parserOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != TokenTypeRefresh || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func parseToken(tokenString, secret string, options ...jwt.ParserOption) (*TokenPayload, error) {
 	// Validate secret length (security requirement)
 	if len(secret) < 32 {
 		return nil, ErrShortSecret
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &TokenPayload{}, func(token *jwt.Token) (interface{}, error) {
+	return parseClaims(tokenString, func(token *jwt.Token) (interface{}, error) {
 		// Validate signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(secret), nil
-	})
+	}, options...)
+}
 
+// parseClaims verifies a token with the key keyFunc picks, mapping failures
+// to ErrExpiredToken and ErrInvalidToken
+func parseClaims(tokenString string, keyFunc jwt.Keyfunc, options ...jwt.ParserOption) (*TokenPayload, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &TokenPayload{}, keyFunc, options...)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, ErrExpiredToken
@@ -60,28 +150,59 @@ func VerifyToken(tokenString, secret string) (*TokenPayload, error) {
 	}
 
 	if claims, ok := token.Claims.(*TokenPayload); ok && token.Valid {
+		if claims.TenantID != "" && ValidateTenantID(claims.TenantID) != nil {
+			return nil, ErrInvalidToken
+		}
 		return claims, nil
 	}
 
 	return nil, ErrInvalidToken
 }
 
-// GenerateAccessToken generates a JWT access token.
+// GenerateAccessToken generates a JWT access token. Its unique ID (jti)
+// lets it be revoked before it expires.
 func GenerateAccessToken(userID string, email string, permissions DocumentPermissions, secret string, expiresIn time.Duration) (string, error) {
+	return IssueAccessToken(userID, email, permissions, secret, expiresIn, ClaimRules{})
+}
+
+// IssueAccessToken is GenerateAccessToken with the issuer and audience of
+// rules
+func IssueAccessToken(userID, email string, permissions DocumentPermissions, secret string, expiresIn time.Duration, rules ClaimRules) (string, error) {
+	return IssueTenantAccessToken(userID, email, "", permissions, secret, expiresIn, rules)
+}
+
+// IssueTenantAccessToken is IssueAccessToken for a user of a tenant. An
+// empty tenantID issues a token without one.
+func IssueTenantAccessToken(userID, email, tenantID string, permissions DocumentPermissions, secret string, expiresIn time.Duration, rules ClaimRules) (string, error) {
 	if len(secret) < 32 {
 		return "", ErrShortSecret
 	}
+	if err := ValidatePermissions(permissions); err != nil {
+		return "", err
+	}
+	if tenantID != "" {
+		if err := ValidateTenantID(tenantID); err != nil {
+			return "", err
+		}
+	}
 
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
 	now := time.Now()
 	claims := &TokenPayload{
 		UserID:      userID,
 		Email:       email,
 		Permissions: permissions,
+		TenantID:    tenantID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
 			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
+	rules.stamp(&claims.RegisteredClaims)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(secret))
@@ -93,8 +214,13 @@ func GenerateRefreshToken(userID, secret string, expiresIn time.Duration) (strin
 		return "", ErrShortSecret
 	}
 
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
 	now := time.Now()
 	claims := jwt.RegisteredClaims{
+		ID:        id,
 		Subject:   userID,
 		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
 		IssuedAt:  jwt.NewNumericDate(now),
@@ -104,14 +230,61 @@ func GenerateRefreshToken(userID, secret string, expiresIn time.Duration) (strin
 	return token.SignedString([]byte(secret))
 }
 
+// IssueRefreshToken generates a refresh token carrying the user's
+// permissions, for access tokens issued from it, and a unique ID, so it can
+// be retired once exchanged. It carries the issuer and audience of rules.
+func IssueRefreshToken(userID, email string, permissions DocumentPermissions, secret string, expiresIn time.Duration, rules ClaimRules) (string, error) {
+	return IssueTenantRefreshToken(userID, email, "", permissions, secret, expiresIn, rules)
+}
+
+// IssueTenantRefreshToken is IssueRefreshToken for a user of a tenant,
+// which access tokens issued from it carry too
+func IssueTenantRefreshToken(userID, email, tenantID string, permissions DocumentPermissions, secret string, expiresIn time.Duration, rules ClaimRules) (string, error) {
+	if len(secret) < 32 {
+		return "", ErrShortSecret
+	}
+
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := &TokenPayload{
+		UserID:      userID,
+		Email:       email,
+		Permissions: permissions,
+		Type:        TokenTypeRefresh,
+		TenantID:    tenantID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   userID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	rules.stamp(&claims.RegisteredClaims)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// newTokenID returns a random token ID for the jti claim
+func newTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
 // GenerateTokens generates both access and refresh tokens for a user.
 func GenerateTokens(userID, email string, permissions DocumentPermissions, secret string) (accessToken, refreshToken string, err error) {
-	accessToken, err = GenerateAccessToken(userID, email, permissions, secret, 24*time.Hour)
+	accessToken, err = GenerateAccessToken(userID, email, permissions, secret, DefaultAccessTokenTTL)
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshToken, err = GenerateRefreshToken(userID, secret, 7*24*time.Hour)
+	refreshToken, err = GenerateRefreshToken(userID, secret, DefaultRefreshTokenTTL)
 	if err != nil {
 		return "", "", err
 	}
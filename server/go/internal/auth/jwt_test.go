@@ -1,6 +1,11 @@
 package auth
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -108,6 +113,247 @@ func TestGenerateRefreshToken_ShortSecret(t *testing.T) {
 	}
 }
 
+func TestIssueRefreshToken(t *testing.T) {
+	perms := CreateUserPermissions([]string{"doc-1"}, nil)
+	token, err := IssueRefreshToken("user-1", "test@example.com", perms, testSecret, time.Hour, ClaimRules{})
+	if err != nil {
+		t.Fatalf("IssueRefreshToken failed: %v", err)
+	}
+
+	payload, err := VerifyRefreshToken(token, testSecret, ClaimRules{})
+	if err != nil {
+		t.Fatalf("VerifyRefreshToken failed: %v", err)
+	}
+	if payload.UserID != "user-1" || payload.Email != "test@example.com" || payload.ID == "" {
+		t.Errorf("payload = %+v, want user-1's with an ID", payload)
+	}
+	if !CanReadDocument(payload, "doc-1") || CanReadDocument(payload, "doc-2") {
+		t.Errorf("Permissions = %+v, want read access to doc-1 only", payload.Permissions)
+	}
+
+	if _, err := VerifyToken(token, testSecret); err == nil {
+		t.Error("VerifyToken accepted a refresh token")
+	}
+	access, _ := GenerateAccessToken("user-1", "", perms, testSecret, time.Hour)
+	if _, err := VerifyRefreshToken(access, testSecret, ClaimRules{}); err == nil {
+		t.Error("VerifyRefreshToken accepted an access token")
+	}
+}
+
+func TestShareTokens(t *testing.T) {
+	token, claims, err := IssueShareToken("doc-1", false, testSecret, time.Hour, ClaimRules{})
+	if err != nil {
+		t.Fatalf("IssueShareToken failed: %v", err)
+	}
+	if claims.UserID != ShareUserPrefix+claims.ID {
+		t.Errorf("UserID = %q, want %q", claims.UserID, ShareUserPrefix+claims.ID)
+	}
+
+	share, err := VerifyShareToken(token, testSecret, ClaimRules{})
+	if err != nil {
+		t.Fatalf("VerifyShareToken failed: %v", err)
+	}
+	if SharedDocument(share) != "doc-1" || !CanReadDocument(share, "doc-1") || CanWriteDocument(share, "doc-1") || CanReadDocument(share, "doc-2") {
+		t.Errorf("Permissions = %+v, want read access to doc-1 only", share.Permissions)
+	}
+	// Share tokens authenticate like access tokens
+	if _, err := VerifyToken(token, testSecret); err != nil {
+		t.Errorf("VerifyToken refused a share token: %v", err)
+	}
+
+	access, err := IssueShareAccessToken(share, testSecret, ClaimRules{})
+	if err != nil {
+		t.Fatalf("IssueShareAccessToken failed: %v", err)
+	}
+	payload, err := VerifyToken(access, testSecret)
+	if err != nil {
+		t.Fatalf("VerifyToken failed: %v", err)
+	}
+	if payload.ID != share.ID || payload.UserID != share.UserID || payload.Type != "" {
+		t.Errorf("access token = %+v, want the share's ID and user", payload)
+	}
+	if payload.ExpiresAt.After(share.ExpiresAt.Time) {
+		t.Errorf("access token expires at %v, after the share at %v", payload.ExpiresAt, share.ExpiresAt)
+	}
+	if _, err := VerifyShareToken(access, testSecret, ClaimRules{}); err == nil {
+		t.Error("VerifyShareToken accepted an access token")
+	}
+
+	expired, _, _ := IssueShareToken("doc-1", true, testSecret, -time.Minute, ClaimRules{})
+	if _, err := VerifyShareToken(expired, testSecret, ClaimRules{}); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("VerifyShareToken(expired) = %v, want ErrExpiredToken", err)
+	}
+}
+
+func TestVerifyTokenWithRules(t *testing.T) {
+	rules := ClaimRules{Issuer: "https://idp.example.com", Audience: "synckit", Leeway: 10 * time.Second}
+	now := time.Now()
+	sign := func(claims jwt.RegisteredClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &TokenPayload{UserID: "user-1", RegisteredClaims: claims}).
+			SignedString([]byte(testSecret))
+		if err != nil {
+			t.Fatalf("SignedString failed: %v", err)
+		}
+		return token
+	}
+	valid := jwt.RegisteredClaims{
+		Issuer:    rules.Issuer,
+		Audience:  jwt.ClaimStrings{"other-service", rules.Audience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+	with := func(change func(*jwt.RegisteredClaims)) jwt.RegisteredClaims {
+		claims := valid
+		change(&claims)
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		claims  jwt.RegisteredClaims
+		rules   ClaimRules
+		wantErr error
+	}{
+		{"matching issuer and audience", valid, rules, nil},
+		{"wrong issuer", with(func(c *jwt.RegisteredClaims) { c.Issuer = "https://evil.example.com" }), rules, ErrInvalidToken},
+		{"wrong audience", with(func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other-service"} }), rules, ErrInvalidToken},
+		{"missing issuer", with(func(c *jwt.RegisteredClaims) { c.Issuer = "" }), rules, ErrInvalidToken},
+		{"missing audience", with(func(c *jwt.RegisteredClaims) { c.Audience = nil }), rules, ErrInvalidToken},
+		{"missing claims with checks disabled", jwt.RegisteredClaims{ExpiresAt: valid.ExpiresAt}, ClaimRules{}, nil},
+		{"expired within leeway", with(func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-5 * time.Second)) }), rules, nil},
+		{"expired beyond leeway", with(func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-15 * time.Second)) }), rules, ErrExpiredToken},
+		{"expired without leeway", with(func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-5 * time.Second)) }), ClaimRules{}, ErrExpiredToken},
+		{"not yet valid within leeway", with(func(c *jwt.RegisteredClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(5 * time.Second)) }), rules, nil},
+		{"not yet valid beyond leeway", with(func(c *jwt.RegisteredClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(15 * time.Second)) }), rules, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyTokenWithRules(sign(tt.claims), testSecret, tt.rules)
+			if err != tt.wantErr {
+				t.Errorf("VerifyTokenWithRules error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIssueAccessToken_CarriesRules(t *testing.T) {
+	rules := ClaimRules{Issuer: "synckit", Audience: "synckit-clients"}
+	token, err := IssueAccessToken("user-1", "", CreateAdminPermissions(), testSecret, time.Hour, rules)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	payload, err := VerifyTokenWithRules(token, testSecret, rules)
+	if err != nil {
+		t.Fatalf("VerifyTokenWithRules failed: %v", err)
+	}
+	if payload.Issuer != "synckit" || len(payload.Audience) != 1 || payload.Audience[0] != "synckit-clients" {
+		t.Errorf("claims = %q, %v; want the issuer and audience", payload.Issuer, payload.Audience)
+	}
+	if _, err := VerifyTokenWithRules(token, testSecret, ClaimRules{Audience: "another-service"}); err != ErrInvalidToken {
+		t.Errorf("token for another audience: error = %v, want ErrInvalidToken", err)
+	}
+
+	refresh, _ := IssueRefreshToken("user-1", "", CreateAdminPermissions(), testSecret, time.Hour, rules)
+	if _, err := VerifyRefreshToken(refresh, testSecret, rules); err != nil {
+		t.Errorf("VerifyRefreshToken failed: %v", err)
+	}
+	if _, err := VerifyRefreshToken(refresh, testSecret, ClaimRules{Issuer: "other"}); err != ErrInvalidToken {
+		t.Errorf("refresh token from another issuer: error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestTenantTokens(t *testing.T) {
+	token, err := IssueTenantAccessToken("user-1", "", "acme", CreateUserPermissions([]string{"*"}, []string{"*"}), testSecret, time.Hour, ClaimRules{})
+	if err != nil {
+		t.Fatalf("IssueTenantAccessToken failed: %v", err)
+	}
+	payload, err := VerifyToken(token, testSecret)
+	if err != nil || payload.TenantID != "acme" {
+		t.Fatalf("VerifyToken = %+v, %v; want tenant acme", payload, err)
+	}
+
+	// Wildcard permissions stop at the tenant's prefix
+	for docID, want := range map[string]bool{"acme:doc": true, "acme:": false, "globex:doc": false, "doc": false} {
+		if got := CanReadDocument(payload, docID); got != want {
+			t.Errorf("CanReadDocument(%q) = %v, want %v", docID, got, want)
+		}
+		if got := CanWriteDocument(payload, docID); got != want {
+			t.Errorf("CanWriteDocument(%q) = %v, want %v", docID, got, want)
+		}
+	}
+
+	if _, err := IssueTenantAccessToken("user-1", "", "acme:eu", payload.Permissions, testSecret, time.Hour, ClaimRules{}); !errors.Is(err, ErrInvalidTenant) {
+		t.Errorf("issuing for tenant acme:eu error = %v, want ErrInvalidTenant", err)
+	}
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &TokenPayload{
+		UserID:           "user-1",
+		TenantID:         "acme:eu",
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatalf("SignedString failed: %v", err)
+	}
+	if _, err := VerifyToken(forged, testSecret); err == nil {
+		t.Error("VerifyToken accepted an invalid tenant claim")
+	}
+
+	if RequireTenant(payload) != nil || RequireTenant(&TokenPayload{Permissions: CreateAdminPermissions()}) != nil {
+		t.Error("RequireTenant refused a tenant's token or an admin's")
+	}
+	if err := RequireTenant(&TokenPayload{}); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("RequireTenant without a tenant = %v, want ErrTenantRequired", err)
+	}
+
+	// Only admins act for another tenant
+	if _, err := OverrideTenant(payload, "globex"); !errors.Is(err, ErrTenantOverride) {
+		t.Errorf("OverrideTenant by a user = %v, want ErrTenantOverride", err)
+	}
+	admin := &TokenPayload{UserID: "root", Permissions: CreateAdminPermissions()}
+	scoped, err := OverrideTenant(admin, "globex")
+	if err != nil || scoped.TenantID != "globex" || admin.TenantID != "" {
+		t.Errorf("OverrideTenant by an admin = %+v, %v; want a globex copy", scoped, err)
+	}
+	if CanReadDocument(scoped, "acme:doc") || !CanReadDocument(scoped, "globex:doc") {
+		t.Error("admin acting for globex is not confined to it")
+	}
+}
+
+func TestNewStaticUsers(t *testing.T) {
+	users, err := NewStaticUsers([]string{"alice@example.com:pw", "root@example.com:pw:admin", "bob@example.com:pw:reader"})
+	if err != nil {
+		t.Fatalf("NewStaticUsers failed: %v", err)
+	}
+	ctx := context.Background()
+
+	alice, err := users.VerifyCredentials(ctx, "alice@example.com", "pw")
+	if err != nil || alice.UserID != "alice@example.com" || alice.Permissions.IsAdmin {
+		t.Errorf("alice = %+v, %v; want a writer", alice, err)
+	}
+	if root, _ := users.VerifyCredentials(ctx, "root@example.com", "pw"); root == nil || !root.Permissions.IsAdmin {
+		t.Errorf("root = %+v, want an admin", root)
+	}
+	if bob, _ := users.VerifyCredentials(ctx, "bob@example.com", "pw"); bob == nil || len(bob.Permissions.CanWrite) != 0 {
+		t.Errorf("bob = %+v, want a reader", bob)
+	}
+	if _, err := users.VerifyCredentials(ctx, "alice@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password error = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := users.VerifyCredentials(ctx, "nobody@example.com", "pw"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown user error = %v, want ErrInvalidCredentials", err)
+	}
+
+	if carol, err := NewStaticUsers([]string{"carol@example.com:pw:writer:acme"}); err != nil {
+		t.Errorf("NewStaticUsers with a tenant failed: %v", err)
+	} else if user, _ := carol.VerifyCredentials(ctx, "carol@example.com", "pw"); user == nil || user.TenantID != "acme" {
+		t.Errorf("carol = %+v, want tenant acme", user)
+	}
+
+	for _, entry := range []string{"alice@example.com", ":pw", "alice@example.com:", "alice@example.com:pw:owner", "alice@example.com:pw:writer:a b"} {
+		if _, err := NewStaticUsers([]string{entry}); err == nil {
+			t.Errorf("NewStaticUsers(%q) succeeded, want an error", entry)
+		}
+	}
+}
+
 func TestGenerateTokens(t *testing.T) {
 	perms := CreateUserPermissions([]string{"doc-1", "doc-2"}, []string{"doc-1"})
 	access, refresh, err := GenerateTokens("user-1", "test@example.com", perms, testSecret)
@@ -214,6 +460,175 @@ func TestCanWriteDocument_SpecificDoc(t *testing.T) {
 	}
 }
 
+func TestCanReadDocument_Patterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		docID   string
+		want    bool
+	}{
+		// Exact IDs and the global wildcard are unchanged
+		{"org:42:doc", "org:42:doc", true},
+		{"org:42:doc", "org:42:doc2", false},
+		{"*", "org:42:doc", true},
+
+		// Trailing wildcards cover one or more segments
+		{"org:42:*", "org:42:doc", true},
+		{"org:42:*", "org:42:docs:a", true},
+		{"org:42:*", "org:42", false},
+		{"org:42:*", "org:42:", false},
+		{"org:42:*", "org:421", false},
+		{"org:42:*", "org:421:doc", false},
+		{"org:42:*", "org:4:doc", false},
+		{"org:42:*", "xorg:42:doc", false},
+		{"org:*", "org:42:doc", true},
+
+		// Inner wildcards cover exactly one segment
+		{"org:*:settings", "org:42:settings", true},
+		{"org:*:settings", "org:421:settings", true},
+		{"org:*:settings", "org:42:a:settings", false},
+		{"org:*:settings", "org::settings", false},
+		{"org:*:settings", "org:42:settings:old", false},
+		{"org:*:settings", "org:42:setting", false},
+		{"org:*:*", "org:42:doc", true},
+		{"org:*:*", "org:42", false},
+		{"*:settings", "org:settings", true},
+		{"*:settings", "settings", false},
+
+		// "*" inside a segment, or regexp syntax, is matched literally
+		{"org:4*", "org:42", false},
+		{"org*", "org:42", false},
+		{"org.:*", "orgx:42", false},
+		{"org:[0-9]+:*", "org:42:doc", false},
+	}
+	for _, tt := range tests {
+		payload := &TokenPayload{Permissions: CreateUserPermissions([]string{tt.pattern}, []string{tt.pattern})}
+		if got := CanReadDocument(payload, tt.docID); got != tt.want {
+			t.Errorf("CanReadDocument(%q, %q) = %v, want %v", tt.pattern, tt.docID, got, tt.want)
+		}
+		if got := CanWriteDocument(payload, tt.docID); got != tt.want {
+			t.Errorf("CanWriteDocument(%q, %q) = %v, want %v", tt.pattern, tt.docID, got, tt.want)
+		}
+	}
+}
+
+func TestCanReadDocument_OverlappingPatterns(t *testing.T) {
+	payload := &TokenPayload{
+		Permissions: CreateUserPermissions(
+			[]string{"org:42:*", "org:*:settings", "org:7:readme"},
+			[]string{"org:42:drafts:*"},
+		),
+	}
+	tests := []struct {
+		docID       string
+		read, write bool
+	}{
+		{"org:42:settings", true, false},
+		{"org:7:settings", true, false},
+		{"org:7:readme", true, false},
+		{"org:7:notes", false, false},
+		{"org:42:drafts:a", true, true},
+		{"org:42:drafts", true, false},
+		{"org:421:drafts:a", false, false},
+	}
+	for _, tt := range tests {
+		if got := CanReadDocument(payload, tt.docID); got != tt.read {
+			t.Errorf("CanReadDocument(%q) = %v, want %v", tt.docID, got, tt.read)
+		}
+		if got := CanWriteDocument(payload, tt.docID); got != tt.write {
+			t.Errorf("CanWriteDocument(%q) = %v, want %v", tt.docID, got, tt.write)
+		}
+	}
+}
+
+func TestValidatePermissions(t *testing.T) {
+	many := make([]string, MaxPermissionPatterns+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("org:%d:*", i)
+	}
+	tests := []struct {
+		name    string
+		perms   DocumentPermissions
+		wantErr bool
+	}{
+		{"exact IDs", CreateUserPermissions([]string{"doc-1", "doc.with.dots"}, nil), false},
+		{"global wildcard", CreateAdminPermissions(), false},
+		{"trailing wildcard", CreateUserPermissions([]string{"org:42:*"}, nil), false},
+		{"inner wildcard", CreateUserPermissions(nil, []string{"org:*:settings"}), false},
+		{"partial segment", CreateUserPermissions([]string{"org:4*"}, nil), true},
+		{"empty segment", CreateUserPermissions([]string{"org::*"}, nil), true},
+		{"invalid characters", CreateUserPermissions(nil, []string{"org.x:*"}), true},
+		{"at the cap", CreateUserPermissions(many[:MaxPermissionPatterns], many[:MaxPermissionPatterns]), false},
+		{"over the cap", CreateUserPermissions(many, nil), true},
+		{"field rules", DocumentPermissions{CanWrite: []string{"spec:*"}, CanWriteFields: map[string][]string{"spec:*": {"comments.*", "title"}}}, false},
+		{"field rule partial segment", DocumentPermissions{CanWriteFields: map[string][]string{"spec:1": {"comment*"}}}, true},
+		{"field rule empty segment", DocumentPermissions{CanWriteFields: map[string][]string{"spec:1": {"comments..x"}}}, true},
+		{"field rule bad document pattern", DocumentPermissions{CanWriteFields: map[string][]string{"spec:1*": {"title"}}}, true},
+		{"field rules over the cap", DocumentPermissions{CanWriteFields: map[string][]string{"spec:1": many}}, true},
+	}
+	for _, tt := range tests {
+		err := ValidatePermissions(tt.perms)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ValidatePermissions error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidPermissions) {
+			t.Errorf("%s: error %v is not ErrInvalidPermissions", tt.name, err)
+		}
+	}
+
+	if _, err := GenerateAccessToken("user-1", "", CreateUserPermissions([]string{"org:4*"}, nil), testSecret, time.Hour); !errors.Is(err, ErrInvalidPermissions) {
+		t.Errorf("GenerateAccessToken with a bad pattern: error = %v, want ErrInvalidPermissions", err)
+	}
+	if _, _, err := NewAPIKey("ingest", CreateUserPermissions(many, nil)); !errors.Is(err, ErrInvalidPermissions) {
+		t.Errorf("NewAPIKey over the pattern cap: error = %v, want ErrInvalidPermissions", err)
+	}
+}
+
+func TestMatchesField(t *testing.T) {
+	tests := []struct {
+		pattern string
+		field   string
+		want    bool
+	}{
+		{"title", "title", true},
+		{"title", "title.text", false},
+		{"*", "content", true},
+		{"comments.*", "comments.c1", true},
+		{"comments.*", "comments.c1.body", true},
+		{"comments.*", "comments", false},
+		{"comments.*", "commentsx.c1", false},
+		{"sections.*.title", "sections.2.title", true},
+		{"sections.*.title", "sections.2.body", false},
+		{"sections.*.title", "sections.2.3.title", false},
+	}
+	for _, tt := range tests {
+		if got := MatchesField(tt.pattern, tt.field); got != tt.want {
+			t.Errorf("MatchesField(%q, %q) = %v, want %v", tt.pattern, tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestWritableFields(t *testing.T) {
+	payload := &TokenPayload{Permissions: DocumentPermissions{
+		CanWrite: []string{"spec:*"},
+		CanWriteFields: map[string][]string{
+			"spec:*":     {"comments.*"},
+			"spec:draft": {"title"},
+		},
+	}}
+	if _, limited := WritableFields(payload, "notes:1"); limited {
+		t.Error("document without field rules is limited")
+	}
+	fields, limited := WritableFields(payload, "spec:draft")
+	sort.Strings(fields)
+	if !limited || strings.Join(fields, ",") != "comments.*,title" {
+		t.Errorf("WritableFields(spec:draft) = %v %v, want the rules of both keys", fields, limited)
+	}
+	payload.Permissions.IsAdmin = true
+	if _, limited := WritableFields(payload, "spec:draft"); limited {
+		t.Error("admin is limited to fields")
+	}
+}
+
 func TestCanWriteDocument_NilPayload(t *testing.T) {
 	if CanWriteDocument(nil, "doc-1") {
 		t.Error("Nil payload should not allow write")
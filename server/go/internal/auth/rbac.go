@@ -1,19 +1,36 @@
 package auth
 
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxPermissionPatterns caps the wildcard patterns in each of CanRead and
+// CanWrite, since every one is tried against each document checked
+const MaxPermissionPatterns = 32
+
+// ErrInvalidPermissions is returned for permissions with malformed patterns
+var ErrInvalidPermissions = errors.New("invalid document permissions")
+
+// patternSegment is a literal segment of a document ID pattern
+var patternSegment = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
 // CanReadDocument checks if user can read a document.
 func CanReadDocument(payload *TokenPayload, documentID string) bool {
-	if payload == nil {
+	if payload == nil || !WithinTenant(payload, documentID) {
 		return false
 	}
 
-	// Admins can read everything
+	// Admins can read everything within their tenant
 	if payload.Permissions.IsAdmin {
 		return true
 	}
 
 	// Wildcard means access to all
 	for _, id := range payload.Permissions.CanRead {
-		if id == "*" || id == documentID {
+		if matchesDocument(id, documentID) {
 			return true
 		}
 	}
@@ -23,18 +40,18 @@ func CanReadDocument(payload *TokenPayload, documentID string) bool {
 
 // CanWriteDocument checks if user can write to a document.
 func CanWriteDocument(payload *TokenPayload, documentID string) bool {
-	if payload == nil {
+	if payload == nil || !WithinTenant(payload, documentID) {
 		return false
 	}
 
-	// Admins can write everything
+	// Admins can write everything within their tenant
 	if payload.Permissions.IsAdmin {
 		return true
 	}
 
 	// Wildcard means access to all
 	for _, id := range payload.Permissions.CanWrite {
-		if id == "*" || id == documentID {
+		if matchesDocument(id, documentID) {
 			return true
 		}
 	}
@@ -42,6 +59,125 @@ func CanWriteDocument(payload *TokenPayload, documentID string) bool {
 	return false
 }
 
+// WritableFields returns the field patterns CanWriteFields limits the token
+// to in a document, and false if it may write every field. Admins may write
+// every field; keys covering the document add up.
+func WritableFields(payload *TokenPayload, documentID string) ([]string, bool) {
+	if payload == nil || payload.Permissions.IsAdmin {
+		return nil, false
+	}
+	var patterns []string
+	restricted := false
+	for entry, fields := range payload.Permissions.CanWriteFields {
+		if matchesDocument(entry, documentID) {
+			restricted = true
+			patterns = append(patterns, fields...)
+		}
+	}
+	return patterns, restricted
+}
+
+// MatchesField reports whether a field pattern covers a field: "*", the
+// field's exact name, or a pattern of dot-separated segments where "*"
+// stands for any one segment ("sections.*.title") and a final "*" for one or
+// more ("comments.*", which covers "comments.c1" but not "comments" itself).
+func MatchesField(pattern, field string) bool {
+	return matchesSegments(pattern, field, ".")
+}
+
+// matchesDocument reports whether an entry of CanRead or CanWrite covers a
+// document: "*", the document's exact ID, or a pattern of colon-separated
+// segments where "*" stands for any one segment ("org:*:settings") and a
+// final "*" for one or more ("org:42:*").
+func matchesDocument(entry, documentID string) bool {
+	return matchesSegments(entry, documentID, ":")
+}
+
+// matchesSegments matches value against a pattern of sep-separated segments
+func matchesSegments(pattern, value, sep string) bool {
+	if pattern == "*" || pattern == value {
+		return true
+	}
+	if !strings.Contains(pattern, "*") {
+		return false
+	}
+	for {
+		want, patternRest, patternMore := strings.Cut(pattern, sep)
+		got, valueRest, valueMore := strings.Cut(value, sep)
+		if want == "*" {
+			if got == "" {
+				return false
+			}
+			if !patternMore {
+				return true
+			}
+		} else if want != got {
+			return false
+		}
+		if !patternMore || !valueMore {
+			return patternMore == valueMore
+		}
+		pattern, value = patternRest, valueRest
+	}
+}
+
+// ValidatePermissions checks the patterns in permissions: "*" must be a
+// whole segment, segments must not be empty, and each list may hold at most
+// MaxPermissionPatterns patterns. Exact document IDs are not checked. The
+// keys of CanWriteFields are checked like CanWrite and its values with
+// ValidateFieldPatterns.
+func ValidatePermissions(permissions DocumentPermissions) error {
+	if err := validatePatterns("canRead", permissions.CanRead); err != nil {
+		return err
+	}
+	if err := validatePatterns("canWrite", permissions.CanWrite); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(permissions.CanWriteFields))
+	for key, fields := range permissions.CanWriteFields {
+		keys = append(keys, key)
+		if err := ValidateFieldPatterns(fields); err != nil {
+			return fmt.Errorf("%w (canWriteFields %q)", err, key)
+		}
+	}
+	return validatePatterns("canWriteFields", keys)
+}
+
+// ValidateFieldPatterns checks a list of field patterns: at most
+// MaxPermissionPatterns, no empty segments, and "*" only as a whole segment
+func ValidateFieldPatterns(patterns []string) error {
+	if len(patterns) > MaxPermissionPatterns {
+		return fmt.Errorf("%w: more than %d field patterns", ErrInvalidPermissions, MaxPermissionPatterns)
+	}
+	for _, pattern := range patterns {
+		for _, segment := range strings.Split(pattern, ".") {
+			if segment == "" || (segment != "*" && strings.Contains(segment, "*")) {
+				return fmt.Errorf("%w: field pattern %q: segments must be * or non-empty names without *", ErrInvalidPermissions, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+func validatePatterns(field string, entries []string) error {
+	patterns := 0
+	for _, entry := range entries {
+		if entry == "*" || !strings.Contains(entry, "*") {
+			continue
+		}
+		patterns++
+		if patterns > MaxPermissionPatterns {
+			return fmt.Errorf("%w: %s has more than %d patterns", ErrInvalidPermissions, field, MaxPermissionPatterns)
+		}
+		for _, segment := range strings.Split(entry, ":") {
+			if segment != "*" && !patternSegment.MatchString(segment) {
+				return fmt.Errorf("%w: %s pattern %q: segments must be * or letters, digits, _ and -", ErrInvalidPermissions, field, entry)
+			}
+		}
+	}
+	return nil
+}
+
 // CreateUserPermissions creates non-admin user permissions.
 func CreateUserPermissions(canRead, canWrite []string) DocumentPermissions {
 	return DocumentPermissions{
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RefreshTokenStore remembers refresh tokens that have been exchanged, so
+// each is accepted once
+type RefreshTokenStore interface {
+	// Use marks a refresh token's ID used until it expires, reporting false
+	// if it had already been used
+	Use(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error)
+}
+
+// MemoryRefreshTokens is a RefreshTokenStore for a single server
+//
+// The zero value is ready to use.
+type MemoryRefreshTokens struct {
+	mu   sync.Mutex
+	used map[string]time.Time // Token ID -> expiry
+}
+
+// Use implements RefreshTokenStore. Expired entries are dropped as new ones
+// come in.
+func (m *MemoryRefreshTokens) Use(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.used == nil {
+		m.used = make(map[string]time.Time)
+	}
+	if expiry, ok := m.used[tokenID]; ok && expiry.After(now) {
+		return false, nil
+	}
+	for id, expiry := range m.used {
+		if !expiry.After(now) {
+			delete(m.used, id)
+		}
+	}
+	m.used[tokenID] = expiresAt
+	return true, nil
+}
+
+// RedisRefreshTokens is a RefreshTokenStore shared by every server using
+// the same Redis
+type RedisRefreshTokens struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisRefreshTokens creates a store keeping used token IDs under
+// prefix (default "synckit")
+func NewRedisRefreshTokens(client *redis.Client, prefix string) *RedisRefreshTokens {
+	if prefix == "" {
+		prefix = "synckit"
+	}
+	return &RedisRefreshTokens{client: client, prefix: prefix}
+}
+
+// Use implements RefreshTokenStore
+func (r *RedisRefreshTokens) Use(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return false, nil
+	}
+	return r.client.SetNX(ctx, r.prefix+":refresh_used:"+tokenID, 1, ttl).Result()
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RevocationStore is the denylist of tokens revoked before they expire.
+// Entries are only kept while the tokens they cover could still be valid.
+type RevocationStore interface {
+	// RevokeToken denies the token with the given ID (jti) until it expires
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+
+	// RevokeUser denies every token of userID issued before the given time.
+	// The entry is kept until the last of them expires.
+	RevokeUser(ctx context.Context, userID string, before, until time.Time) error
+
+	// IsRevoked reports whether a verified token has been revoked
+	IsRevoked(ctx context.Context, token *TokenPayload) (bool, error)
+}
+
+// revoked reports whether a token was revoked by either kind of entry,
+// given the time its user's tokens were revoked before (zero if never)
+func revoked(token *TokenPayload, tokenDenied bool, userBefore time.Time) bool {
+	if tokenDenied {
+		return true
+	}
+	if userBefore.IsZero() || token.IssuedAt == nil {
+		return false
+	}
+	return token.IssuedAt.Time.Before(userBefore)
+}
+
+// MemoryRevocations is a RevocationStore for a single server
+//
+// The zero value is ready to use.
+type MemoryRevocations struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time   // Token ID -> expiry
+	users  map[string]userRevoked // User ID -> revocation
+}
+
+type userRevoked struct {
+	before time.Time
+	until  time.Time
+}
+
+// RevokeToken implements RevocationStore
+func (m *MemoryRevocations) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(time.Now())
+	if m.tokens == nil {
+		m.tokens = make(map[string]time.Time)
+	}
+	m.tokens[tokenID] = expiresAt
+	return nil
+}
+
+// RevokeUser implements RevocationStore. A later cutoff replaces an earlier
+// one.
+func (m *MemoryRevocations) RevokeUser(ctx context.Context, userID string, before, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(time.Now())
+	if m.users == nil {
+		m.users = make(map[string]userRevoked)
+	}
+	if prev, ok := m.users[userID]; ok {
+		if prev.before.After(before) {
+			before = prev.before
+		}
+		if prev.until.After(until) {
+			until = prev.until
+		}
+	}
+	m.users[userID] = userRevoked{before: before, until: until}
+	return nil
+}
+
+// IsRevoked implements RevocationStore
+func (m *MemoryRevocations) IsRevoked(ctx context.Context, token *TokenPayload) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	expiry, denied := m.tokens[token.ID]
+	denied = denied && token.ID != "" && expiry.After(now)
+	var before time.Time
+	if user, ok := m.users[token.UserID]; ok && user.until.After(now) {
+		before = user.before
+	}
+	return revoked(token, denied, before), nil
+}
+
+// prune drops expired entries. Must be called with mu held.
+func (m *MemoryRevocations) prune(now time.Time) {
+	for id, expiry := range m.tokens {
+		if !expiry.After(now) {
+			delete(m.tokens, id)
+		}
+	}
+	for id, user := range m.users {
+		if !user.until.After(now) {
+			delete(m.users, id)
+		}
+	}
+}
+
+// RedisRevocations is a RevocationStore shared by every server using the
+// same Redis
+type RedisRevocations struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisRevocations creates a store keeping revocations under prefix
+// (default "synckit")
+func NewRedisRevocations(client *redis.Client, prefix string) *RedisRevocations {
+	if prefix == "" {
+		prefix = "synckit"
+	}
+	return &RedisRevocations{client: client, prefix: prefix}
+}
+
+// revokeUserScript keeps the later of the stored and new cutoffs, and the
+// longer of the two lifetimes
+var revokeUserScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local before = tonumber(ARGV[1])
+if current > before then
+	before = current
+end
+local ttl = tonumber(ARGV[2])
+local remaining = redis.call("PTTL", KEYS[1])
+if remaining > ttl then
+	ttl = remaining
+end
+redis.call("SET", KEYS[1], before, "PX", ttl)
+return before
+`)
+
+func (r *RedisRevocations) tokenKey(tokenID string) string {
+	return r.prefix + ":revoked:token:" + tokenID
+}
+
+func (r *RedisRevocations) userKey(userID string) string {
+	return r.prefix + ":revoked:user:" + userID
+}
+
+// RevokeToken implements RevocationStore
+func (r *RedisRevocations) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return r.client.Set(ctx, r.tokenKey(tokenID), 1, ttl).Err()
+}
+
+// RevokeUser implements RevocationStore. A later cutoff replaces an earlier
+// one.
+func (r *RedisRevocations) RevokeUser(ctx context.Context, userID string, before, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	return revokeUserScript.Run(ctx, r.client, []string{r.userKey(userID)}, before.UnixMilli(), ttl.Milliseconds()).Err()
+}
+
+// IsRevoked implements RevocationStore
+func (r *RedisRevocations) IsRevoked(ctx context.Context, token *TokenPayload) (bool, error) {
+	pipe := r.client.Pipeline()
+	var tokenExists *redis.IntCmd
+	if token.ID != "" {
+		tokenExists = pipe.Exists(ctx, r.tokenKey(token.ID))
+	}
+	userBefore := pipe.Get(ctx, r.userKey(token.UserID))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	denied := tokenExists != nil && tokenExists.Val() > 0
+	var before time.Time
+	if ms, err := strconv.ParseInt(userBefore.Val(), 10, 64); err == nil {
+		before = time.UnixMilli(ms)
+	}
+	return revoked(token, denied, before), nil
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+func testRevocationStore(t *testing.T, store RevocationStore) {
+	ctx := context.Background()
+	issued := time.Now().Add(-time.Minute)
+	token := func(id, userID string) *TokenPayload {
+		return &TokenPayload{UserID: userID, RegisteredClaims: jwt.RegisteredClaims{
+			ID:       id,
+			IssuedAt: jwt.NewNumericDate(issued),
+		}}
+	}
+
+	if revoked, err := store.IsRevoked(ctx, token("t1", "alice")); err != nil || revoked {
+		t.Fatalf("IsRevoked before revoking = %v, %v; want false", revoked, err)
+	}
+
+	if err := store.RevokeToken(ctx, "t1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeToken failed: %v", err)
+	}
+	if revoked, _ := store.IsRevoked(ctx, token("t1", "alice")); !revoked {
+		t.Error("revoked token t1 allowed")
+	}
+	if revoked, _ := store.IsRevoked(ctx, token("t2", "alice")); revoked {
+		t.Error("token t2 denied by t1's revocation")
+	}
+
+	if err := store.RevokeUser(ctx, "bob", time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeUser failed: %v", err)
+	}
+	if revoked, _ := store.IsRevoked(ctx, token("t3", "bob")); !revoked {
+		t.Error("bob's token issued before the cutoff allowed")
+	}
+	// An earlier cutoff does not undo a later one
+	store.RevokeUser(ctx, "bob", issued.Add(-time.Hour), time.Now().Add(time.Hour))
+	if revoked, _ := store.IsRevoked(ctx, token("t3", "bob")); !revoked {
+		t.Error("earlier cutoff replaced the later one")
+	}
+	fresh := token("t4", "bob")
+	fresh.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+	if revoked, _ := store.IsRevoked(ctx, fresh); revoked {
+		t.Error("bob's token issued after the cutoff denied")
+	}
+}
+
+func TestMemoryRevocations(t *testing.T) {
+	testRevocationStore(t, &MemoryRevocations{})
+
+	store := &MemoryRevocations{}
+	ctx := context.Background()
+	store.RevokeToken(ctx, "expired", time.Now().Add(-time.Second))
+	if revoked, _ := store.IsRevoked(ctx, &TokenPayload{RegisteredClaims: jwt.RegisteredClaims{ID: "expired"}}); revoked {
+		t.Error("revocation outlived the token's expiry")
+	}
+}
+
+func TestRedisRevocations(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	testRevocationStore(t, NewRedisRevocations(client, "test"))
+
+	if ttl := mr.TTL("test:revoked:token:t1"); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("token revocation TTL = %v, want the token's remaining lifetime", ttl)
+	}
+	if ttl := mr.TTL("test:revoked:user:bob"); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("user revocation TTL = %v, want up to an hour", ttl)
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenTypeShare marks share tokens, which give whoever holds a link access
+// to one document
+const TokenTypeShare = "share"
+
+// ShareUserPrefix starts the user ID of share token holders, followed by
+// the token's ID
+const ShareUserPrefix = "share:"
+
+// SharePermissions are the permissions of a share link to docID: reading,
+// and writing when write is set
+func SharePermissions(docID string, write bool) DocumentPermissions {
+	perms := DocumentPermissions{CanRead: []string{docID}, CanWrite: []string{}}
+	if write {
+		perms.CanWrite = []string{docID}
+	}
+	return perms
+}
+
+// IssueShareToken issues a share token for docID lasting expiresIn, with
+// the issuer and audience of rules. Its holders act as ShareUserPrefix plus
+// its unique ID, which revokes it. Being an ordinary JWT, it authenticates
+// websocket connections directly.
+func IssueShareToken(docID string, write bool, secret string, expiresIn time.Duration, rules ClaimRules) (string, *TokenPayload, error) {
+	return IssueTenantShareToken(docID, write, "", secret, expiresIn, rules)
+}
+
+// IssueTenantShareToken is IssueShareToken for a document of a tenant,
+// which the share and the access tokens exchanged for it are confined to
+func IssueTenantShareToken(docID string, write bool, tenantID, secret string, expiresIn time.Duration, rules ClaimRules) (string, *TokenPayload, error) {
+	if len(secret) < 32 {
+		return "", nil, ErrShortSecret
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	claims := &TokenPayload{
+		UserID:      ShareUserPrefix + id,
+		Permissions: SharePermissions(docID, write),
+		Type:        TokenTypeShare,
+		TenantID:    tenantID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	rules.stamp(&claims.RegisteredClaims)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// VerifyShareToken verifies and decodes a share token made by
+// IssueShareToken with the same rules
+func VerifyShareToken(tokenString, secret string, rules ClaimRules) (*TokenPayload, error) {
+	claims, err := parseToken(tokenString, secret, rules.parserOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != TokenTypeShare || claims.ID == "" || claims.UserID != ShareUserPrefix+claims.ID {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// SharedDocument returns the document a share token is for
+func SharedDocument(share *TokenPayload) string {
+	if len(share.Permissions.CanRead) != 1 {
+		return ""
+	}
+	return share.Permissions.CanRead[0]
+}
+
+// IssueShareAccessToken issues the holder of a verified share token an
+// access token with its permissions. It keeps the share's ID, so revoking
+// the share revokes it too, and expires with the share if not sooner.
+func IssueShareAccessToken(share *TokenPayload, secret string, rules ClaimRules) (string, error) {
+	if len(secret) < 32 {
+		return "", ErrShortSecret
+	}
+	if share.Type != TokenTypeShare || !strings.HasPrefix(share.UserID, ShareUserPrefix) {
+		return "", ErrInvalidToken
+	}
+	now := time.Now()
+	expiresAt := now.Add(DefaultAccessTokenTTL)
+	if share.ExpiresAt != nil && share.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = share.ExpiresAt.Time
+	}
+	claims := &TokenPayload{
+		UserID:      share.UserID,
+		Permissions: share.Permissions,
+		TenantID:    share.TenantID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        share.ID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	rules.stamp(&claims.RegisteredClaims)
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
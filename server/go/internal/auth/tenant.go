@@ -0,0 +1,64 @@
+package auth
+
+import "errors"
+
+// Errors for tenant scoping
+var (
+	ErrTenantRequired = errors.New("token has no tenant")
+	ErrInvalidTenant  = errors.New("tenant must be letters, digits, underscores and hyphens")
+	ErrTenantOverride = errors.New("only admin tokens may act for another tenant")
+)
+
+// TenantPrefix is the prefix of a tenant's document IDs: "<tenantID>:"
+func TenantPrefix(tenantID string) string {
+	return tenantID + ":"
+}
+
+// InTenant reports whether a document belongs to a tenant: its ID is the
+// tenant's prefix followed by at least one more character
+func InTenant(tenantID, documentID string) bool {
+	prefix := TenantPrefix(tenantID)
+	return len(documentID) > len(prefix) && documentID[:len(prefix)] == prefix
+}
+
+// ValidateTenantID checks that a tenant ID is one document ID segment
+func ValidateTenantID(tenantID string) error {
+	if !patternSegment.MatchString(tenantID) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// WithinTenant reports whether a token's tenant, if it has one, holds the
+// document. A token with a tenant reaches no other document, whatever its
+// permissions or grants; one without a tenant is not limited.
+func WithinTenant(payload *TokenPayload, documentID string) bool {
+	return payload == nil || payload.TenantID == "" || InTenant(payload.TenantID, documentID)
+}
+
+// RequireTenant checks a token for a deployment hosting several tenants:
+// only admin tokens may leave the tenant out, reaching every tenant's
+// documents
+func RequireTenant(payload *TokenPayload) error {
+	if payload.TenantID == "" && !payload.Permissions.IsAdmin {
+		return ErrTenantRequired
+	}
+	return nil
+}
+
+// OverrideTenant returns a copy of an admin token acting within tenantID
+// instead of its own tenant, or the token itself when tenantID is empty
+func OverrideTenant(payload *TokenPayload, tenantID string) (*TokenPayload, error) {
+	if tenantID == "" || tenantID == payload.TenantID {
+		return payload, nil
+	}
+	if !payload.Permissions.IsAdmin {
+		return nil, ErrTenantOverride
+	}
+	if err := ValidateTenantID(tenantID); err != nil {
+		return nil, err
+	}
+	scoped := *payload
+	scoped.TenantID = tenantID
+	return &scoped, nil
+}
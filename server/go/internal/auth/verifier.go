@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithms access tokens may be signed with
+const (
+	AlgorithmHS256 = "HS256" // Shared secret (the default)
+	AlgorithmRS256 = "RS256" // RSA public key
+	AlgorithmES256 = "ES256" // ECDSA P-256 public key
+)
+
+// TokenVerifier verifies access tokens presented by clients
+type TokenVerifier interface {
+	VerifyToken(tokenString string) (*TokenPayload, error)
+}
+
+// VerifierConfig configures a Verifier
+type VerifierConfig struct {
+	// Algorithm tokens must be signed with; tokens claiming any other are
+	// rejected (default AlgorithmHS256)
+	Algorithm string
+
+	// Secret verifies HS256 tokens
+	Secret string
+
+	// PublicKey verifies RS256 or ES256 tokens, unless JWKS is set
+	PublicKey crypto.PublicKey
+
+	// JWKS supplies RS256 or ES256 keys by the tokens' key ID (kid)
+	JWKS *JWKS
+
+	// Rules are the issuer, audience and clock skew tokens are checked
+	// against, whatever the algorithm
+	Rules ClaimRules
+}
+
+// Verifier is a TokenVerifier for one algorithm
+type Verifier struct {
+	config VerifierConfig
+}
+
+// NewVerifier checks that config has a key suited to its algorithm
+func NewVerifier(config VerifierConfig) (*Verifier, error) {
+	switch config.Algorithm {
+	case "":
+		config.Algorithm = AlgorithmHS256
+		fallthrough
+	case AlgorithmHS256:
+		if len(config.Secret) < 32 {
+			return nil, ErrShortSecret
+		}
+	case AlgorithmRS256, AlgorithmES256:
+		if config.JWKS == nil && config.PublicKey == nil {
+			return nil, fmt.Errorf("%s needs a public key or a JWKS URL", config.Algorithm)
+		}
+		if config.JWKS == nil {
+			if err := checkKeyType(config.Algorithm, config.PublicKey); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q (want HS256, RS256 or ES256)", config.Algorithm)
+	}
+	return &Verifier{config: config}, nil
+}
+
+// VerifyToken implements TokenVerifier. Only the configured algorithm is
+// accepted, so a token claiming HS256 cannot be checked against a public
+// key used as a secret. Refresh tokens are rejected, as by VerifyToken.
+func (v *Verifier) VerifyToken(tokenString string) (*TokenPayload, error) {
+	if v.config.Algorithm == AlgorithmHS256 {
+		return VerifyTokenWithRules(tokenString, v.config.Secret, v.config.Rules)
+	}
+
+	options := append(v.config.Rules.parserOptions(), jwt.WithValidMethods([]string{v.config.Algorithm}))
+	claims, err := parseClaims(tokenString, v.key, options...)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type == TokenTypeRefresh {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// key picks the public key a token is checked against
+func (v *Verifier) key(token *jwt.Token) (interface{}, error) {
+	key := v.config.PublicKey
+	if v.config.JWKS != nil {
+		kid, _ := token.Header["kid"].(string)
+		var err error
+		if key, err = v.config.JWKS.Key(kid); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkKeyType(v.config.Algorithm, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// checkKeyType reports a key that cannot verify the algorithm
+func checkKeyType(algorithm string, key crypto.PublicKey) error {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if algorithm == AlgorithmRS256 {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if algorithm == AlgorithmES256 {
+			return nil
+		}
+	}
+	return fmt.Errorf("%T cannot verify %s tokens", key, algorithm)
+}
+
+// LoadPublicKey reads a PEM-encoded RSA or ECDSA public key, or the key of
+// a PEM certificate, for verifying tokens signed with algorithm
+func LoadPublicKey(path, algorithm string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key crypto.PublicKey
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err = jwt.ParseRSAPublicKeyFromPEM(data)
+	case AlgorithmES256:
+		key, err = jwt.ParseECPublicKeyFromPEM(data)
+	default:
+		return nil, errors.New("public keys are only used with RS256 or ES256")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signToken signs claims for user-1 with the given method, key and key ID
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, expiresIn time.Duration) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, &TokenPayload{
+		UserID:      "user-1",
+		Permissions: CreateUserPermissions([]string{"*"}, nil),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString failed: %v", err)
+	}
+	return signed
+}
+
+// writePublicKey writes key as a PEM file and returns its path and bytes
+func writePublicKey(t *testing.T, key crypto.PublicKey) (string, []byte) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path, data
+}
+
+func TestVerifier_RS256PublicKey(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	path, pemBytes := writePublicKey(t, &key.PublicKey)
+	publicKey, err := LoadPublicKey(path, AlgorithmRS256)
+	if err != nil {
+		t.Fatalf("LoadPublicKey failed: %v", err)
+	}
+	v, err := NewVerifier(VerifierConfig{Algorithm: AlgorithmRS256, PublicKey: publicKey})
+	if err != nil {
+		t.Fatalf("NewVerifier failed: %v", err)
+	}
+
+	payload, err := v.VerifyToken(signToken(t, jwt.SigningMethodRS256, key, "", time.Hour))
+	if err != nil || payload.UserID != "user-1" {
+		t.Fatalf("VerifyToken = %+v, %v; want user-1", payload, err)
+	}
+	if _, err := v.VerifyToken(signToken(t, jwt.SigningMethodRS256, key, "", -time.Minute)); err != ErrExpiredToken {
+		t.Errorf("expired token error = %v, want ErrExpiredToken", err)
+	}
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := v.VerifyToken(signToken(t, jwt.SigningMethodRS256, other, "", time.Hour)); err == nil {
+		t.Error("token signed with another key accepted")
+	}
+
+	// Algorithm confusion: HS256 keyed with the public key's PEM
+	if _, err := v.VerifyToken(signToken(t, jwt.SigningMethodHS256, pemBytes, "", time.Hour)); err == nil {
+		t.Error("HS256 token signed with the public key accepted")
+	}
+	if _, err := v.VerifyToken(signToken(t, jwt.SigningMethodHS256, []byte(testSecret), "", time.Hour)); err == nil {
+		t.Error("HS256 token accepted by an RS256 verifier")
+	}
+}
+
+func TestVerifier_ES256PublicKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	path, _ := writePublicKey(t, &key.PublicKey)
+	publicKey, err := LoadPublicKey(path, AlgorithmES256)
+	if err != nil {
+		t.Fatalf("LoadPublicKey failed: %v", err)
+	}
+	v, _ := NewVerifier(VerifierConfig{Algorithm: AlgorithmES256, PublicKey: publicKey})
+	if _, err := v.VerifyToken(signToken(t, jwt.SigningMethodES256, key, "", time.Hour)); err != nil {
+		t.Errorf("VerifyToken failed: %v", err)
+	}
+
+	if _, err := LoadPublicKey(path, AlgorithmRS256); err == nil {
+		t.Error("EC key loaded for RS256")
+	}
+	for name, config := range map[string]VerifierConfig{
+		"no key":       {Algorithm: AlgorithmES256},
+		"wrong key":    {Algorithm: AlgorithmRS256, PublicKey: publicKey},
+		"short secret": {Algorithm: AlgorithmHS256, Secret: "short"},
+		"unknown alg":  {Algorithm: "none"},
+	} {
+		if _, err := NewVerifier(config); err == nil {
+			t.Errorf("%s: NewVerifier succeeded, want an error", name)
+		}
+	}
+}
+
+func TestVerifier_EnforcesRules(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	v, _ := NewVerifier(VerifierConfig{
+		Algorithm: AlgorithmES256,
+		PublicKey: &key.PublicKey,
+		Rules:     ClaimRules{Audience: "synckit"},
+	})
+	// signToken sets no audience
+	if _, err := v.VerifyToken(signToken(t, jwt.SigningMethodES256, key, "", time.Hour)); err != ErrInvalidToken {
+		t.Errorf("token without audience: error = %v, want ErrInvalidToken", err)
+	}
+
+	hs, _ := NewVerifier(VerifierConfig{Secret: testSecret, Rules: ClaimRules{Issuer: "synckit"}})
+	token, _ := IssueAccessToken("user-1", "", CreateAdminPermissions(), testSecret, time.Hour, ClaimRules{Issuer: "other"})
+	if _, err := hs.VerifyToken(token); err != ErrInvalidToken {
+		t.Errorf("HS256 token from another issuer: error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifier_HS256MatchesVerifyToken(t *testing.T) {
+	v, err := NewVerifier(VerifierConfig{Secret: testSecret})
+	if err != nil {
+		t.Fatalf("NewVerifier failed: %v", err)
+	}
+	token, _ := GenerateAccessToken("user-1", "", CreateAdminPermissions(), testSecret, time.Hour)
+	if _, err := v.VerifyToken(token); err != nil {
+		t.Errorf("VerifyToken failed: %v", err)
+	}
+}
+
+// fakeJWKS serves a key set that tests can replace, counting fetches
+type fakeJWKS struct {
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches atomic.Int32
+}
+
+func (f *fakeJWKS) set(keys ...map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = keys
+}
+
+func (f *fakeJWKS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.fetches.Add(1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": f.keys})
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func TestJWKS_SelectsKeysByKidAndRefreshesOnRotation(t *testing.T) {
+	first, _ := rsa.GenerateKey(rand.Reader, 2048)
+	second, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := &fakeJWKS{}
+	keys.set(rsaJWK("k1", &first.PublicKey), ecJWK("ec1", &ecKey.PublicKey))
+	srv := httptest.NewServer(keys)
+	defer srv.Close()
+
+	jwks := NewJWKS(srv.URL, JWKSOptions{MinRefreshInterval: time.Nanosecond})
+	v, err := NewVerifier(VerifierConfig{Algorithm: AlgorithmRS256, JWKS: jwks})
+	if err != nil {
+		t.Fatalf("NewVerifier failed: %v", err)
+	}
+
+	if _, err := v.VerifyToken(signToken(t, jwt.SigningMethodRS256, first, "k1", time.Hour)); err != nil {
+		t.Fatalf("token signed with k1: %v", err)
+	}
+	if _, err := v.VerifyToken(signToken(t, jwt.SigningMethodRS256, second, "k1", time.Hour)); err == nil {
+		t.Error("token claiming k1 but signed with another key accepted")
+	}
+	// An EC key in the set cannot verify RS256 tokens
+	if _, err := v.VerifyToken(signToken(t, jwt.SigningMethodES256, ecKey, "ec1", time.Hour)); err == nil {
+		t.Error("ES256 token accepted by an RS256 verifier")
+	}
+	fetches := keys.fetches.Load()
+
+	// The provider rotates to k2; the unknown kid triggers a refetch
+	keys.set(rsaJWK("k2", &second.PublicKey))
+	if _, err := v.VerifyToken(signToken(t, jwt.SigningMethodRS256, second, "k2", time.Hour)); err != nil {
+		t.Fatalf("token signed with rotated k2: %v", err)
+	}
+	if got := keys.fetches.Load(); got != fetches+1 {
+		t.Errorf("fetches after rotation = %d, want %d", got, fetches+1)
+	}
+}
+
+func TestJWKS_RateLimitsUnknownKidRefreshes(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys := &fakeJWKS{}
+	keys.set(rsaJWK("k1", &key.PublicKey))
+	srv := httptest.NewServer(keys)
+	defer srv.Close()
+
+	jwks := NewJWKS(srv.URL, JWKSOptions{MinRefreshInterval: time.Hour})
+	for i := 0; i < 5; i++ {
+		if _, err := jwks.Key("forged"); err == nil {
+			t.Fatal("unknown kid returned a key")
+		}
+	}
+	if got := keys.fetches.Load(); got != 1 {
+		t.Errorf("fetches = %d, want 1 for repeated unknown kids", got)
+	}
+	if _, err := jwks.Key("k1"); err != nil {
+		t.Errorf("known kid: %v", err)
+	}
+}
+
+func TestJWKS_RefreshesAfterIntervalAndKeepsKeysOnFailure(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys := &fakeJWKS{}
+	keys.set(rsaJWK("k1", &key.PublicKey))
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			keys.fetches.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		keys.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	jwks := NewJWKS(srv.URL, JWKSOptions{RefreshInterval: time.Nanosecond, MinRefreshInterval: time.Nanosecond})
+	if _, err := jwks.Key("k1"); err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+	down.Store(true)
+	if _, err := jwks.Key("k1"); err != nil {
+		t.Errorf("Key with the endpoint down = %v, want the cached key", err)
+	}
+	// The refresh runs in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for keys.fetches.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("fetches = %d, want a refresh after the interval", keys.fetches.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := jwks.Key("k1"); err != nil {
+		t.Errorf("Key after the failed refresh = %v, want the cached key", err)
+	}
+}
+
+func TestJWKS_StaleKeysDoNotWaitForTheEndpoint(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys := &fakeJWKS{}
+	keys.set(rsaJWK("k1", &key.PublicKey))
+	release := make(chan struct{})
+	var slow atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			<-release
+		}
+		keys.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	jwks := NewJWKS(srv.URL, JWKSOptions{RefreshInterval: time.Nanosecond, MinRefreshInterval: time.Nanosecond})
+	if _, err := jwks.Key("k1"); err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+	slow.Store(true)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := jwks.Key("k1"); err != nil {
+			t.Fatalf("Key during a refresh = %v, want the cached key", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Key took %v while the endpoint hung, want the cached key at once", elapsed)
+	}
+}
+
+func TestJWKS_RefusesOversizedResponses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys":[],"padding":"`))
+		w.Write(bytes.Repeat([]byte("x"), maxJWKSSize))
+		w.Write([]byte(`"}`))
+	}))
+	defer srv.Close()
+
+	jwks := NewJWKS(srv.URL, JWKSOptions{})
+	if _, err := jwks.Key("k1"); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Key = %v, want the response refused for its size", err)
+	}
+}
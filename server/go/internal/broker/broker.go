@@ -0,0 +1,48 @@
+// Package broker defines the messaging servers use to coordinate: relaying
+// document traffic, broadcasting events and announcing presence.
+// storage.RedisPubSub and NATS implement it.
+package broker
+
+import (
+	"context"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// Broker carries messages between the servers of a deployment. Payloads
+// are JSON encoded. Messages on one subscription are delivered one at a
+// time, in the order they were published; a server receives its own
+// messages too.
+type Broker interface {
+	// PublishDelta and PublishAwareness send to a document's subscribers
+	PublishDelta(ctx context.Context, documentID string, delta interface{}) error
+	PublishAwareness(ctx context.Context, documentID string, state interface{}) error
+
+	// SubscribeToDocument delivers the document's messages published from
+	// when it returns on
+	SubscribeToDocument(ctx context.Context, documentID string, handler func([]byte)) error
+	UnsubscribeFromDocument(ctx context.Context, documentID string) error
+
+	// PublishBroadcast sends an event to every server
+	PublishBroadcast(ctx context.Context, event string, data interface{}) error
+	SubscribeToBroadcast(ctx context.Context, handler func(event string, data interface{})) error
+
+	// AnnouncePresence and AnnounceShutdown tell the other servers this one
+	// came online or is going away; handlers get "online" or "offline"
+	AnnouncePresence(ctx context.Context, serverID string, metadata map[string]interface{}) error
+	AnnounceShutdown(ctx context.Context, serverID string) error
+	SubscribeToPresence(ctx context.Context, handler func(event string, serverID string, metadata map[string]interface{})) error
+
+	// IsConnected reports whether the broker was reachable when last used
+	IsConnected() bool
+
+	// OnReconnect sets fn to be called once subscriptions are restored
+	// after the broker was unreachable, with the documents whose messages
+	// may have been missed meanwhile
+	OnReconnect(fn func(documentIDs []string))
+
+	GetStats() storage.Stats
+	Disconnect(ctx context.Context) error
+}
+
+var _ Broker = (*storage.RedisPubSub)(nil)
@@ -0,0 +1,200 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/alicebob/miniredis/v2"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+)
+
+// runNATSServer starts an in-process NATS server listening on port, or on a
+// free port when it is natsserver.RANDOM_PORT, and stops it after the test
+func runNATSServer(t *testing.T, port int) *natsserver.Server {
+	t.Helper()
+	srv, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: port, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// brokerBackend starts a broker server for one test and returns how to
+// connect to it and how to drop every connection to it
+type brokerBackend func(t *testing.T) (connect func(t *testing.T) Broker, interrupt func())
+
+var backends = map[string]brokerBackend{
+	"redis": func(t *testing.T) (func(t *testing.T) Broker, func()) {
+		mr := miniredis.RunT(t)
+		connect := func(t *testing.T) Broker {
+			pubsub, err := storage.NewRedisPubSub(&storage.RedisPubSubConfig{URL: "redis://" + mr.Addr(), ChannelPrefix: "test:"})
+			if err != nil {
+				t.Fatalf("NewRedisPubSub: %v", err)
+			}
+			if err := pubsub.Connect(context.Background()); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			t.Cleanup(func() { pubsub.Disconnect(context.Background()) })
+			return pubsub
+		}
+		interrupt := func() {
+			mr.Close()
+			if err := mr.Restart(); err != nil {
+				t.Fatalf("Restart: %v", err)
+			}
+		}
+		return connect, interrupt
+	},
+	"nats": func(t *testing.T) (func(t *testing.T) Broker, func()) {
+		srv := runNATSServer(t, natsserver.RANDOM_PORT)
+		url := srv.ClientURL()
+		connect := func(t *testing.T) Broker {
+			n := NewNATS(NATSConfig{URL: url, SubjectPrefix: "test."})
+			if err := n.Connect(context.Background()); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			t.Cleanup(func() { n.Disconnect(context.Background()) })
+			return n
+		}
+		interrupt := func() {
+			port := srv.Addr().(*net.TCPAddr).Port
+			srv.Shutdown()
+			srv.WaitForShutdown()
+			srv = runNATSServer(t, port)
+		}
+		return connect, interrupt
+	},
+}
+
+// forEachBroker runs test against every Broker implementation, so they are
+// held to the same semantics
+func forEachBroker(t *testing.T, test func(t *testing.T, connect func(t *testing.T) Broker, interrupt func())) {
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			connect, interrupt := backend(t)
+			test(t, connect, interrupt)
+		})
+	}
+}
+
+func expectMessage[T any](t *testing.T, received <-chan T, want T) {
+	t.Helper()
+	select {
+	case got := <-received:
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("received %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %v", want)
+	}
+}
+
+func TestBroker_DeliversDocumentMessagesInOrder(t *testing.T) {
+	forEachBroker(t, func(t *testing.T, connect func(t *testing.T) Broker, _ func()) {
+		ctx := context.Background()
+		a, b := connect(t), connect(t)
+
+		received := make(chan string, 64)
+		for _, docID := range []string{"room:1", "room:2"} {
+			docID := docID
+			if err := a.SubscribeToDocument(ctx, docID, func(data []byte) { received <- docID + " " + string(data) }); err != nil {
+				t.Fatalf("SubscribeToDocument: %v", err)
+			}
+		}
+
+		b.PublishDelta(ctx, "room:other", 0)
+		for i := 1; i <= 20; i++ {
+			if err := b.PublishDelta(ctx, "room:1", i); err != nil {
+				t.Fatalf("PublishDelta: %v", err)
+			}
+		}
+		b.PublishAwareness(ctx, "room:1", "cursor")
+		for i := 1; i <= 20; i++ {
+			expectMessage(t, received, fmt.Sprintf("room:1 %d", i))
+		}
+		expectMessage(t, received, `room:1 "cursor"`)
+
+		// Nothing more for an unsubscribed document; the publisher's own
+		// subscriptions see its messages too
+		if err := a.UnsubscribeFromDocument(ctx, "room:1"); err != nil {
+			t.Fatalf("UnsubscribeFromDocument: %v", err)
+		}
+		b.PublishDelta(ctx, "room:1", 21)
+		a.PublishDelta(ctx, "room:2", 1)
+		expectMessage(t, received, "room:2 1")
+
+		if stats := a.GetStats(); !stats.Connected || stats.SubscribedChannels != 1 || stats.TotalHandlers != 1 {
+			t.Errorf("stats = %+v, want connected with room:2's handler", stats)
+		}
+	})
+}
+
+func TestBroker_BroadcastAndPresence(t *testing.T) {
+	type presence struct {
+		Event, ServerID string
+		Metadata        map[string]interface{}
+	}
+	forEachBroker(t, func(t *testing.T, connect func(t *testing.T) Broker, _ func()) {
+		ctx := context.Background()
+		a, b := connect(t), connect(t)
+
+		broadcasts := make(chan string, 4)
+		presences := make(chan presence, 4)
+		if err := a.SubscribeToBroadcast(ctx, func(event string, data interface{}) {
+			broadcasts <- fmt.Sprintf("%s %v", event, data)
+		}); err != nil {
+			t.Fatalf("SubscribeToBroadcast: %v", err)
+		}
+		if err := a.SubscribeToPresence(ctx, func(event, serverID string, metadata map[string]interface{}) {
+			presences <- presence{event, serverID, metadata}
+		}); err != nil {
+			t.Fatalf("SubscribeToPresence: %v", err)
+		}
+
+		if err := b.PublishBroadcast(ctx, "config_changed", map[string]interface{}{"key": "value"}); err != nil {
+			t.Fatalf("PublishBroadcast: %v", err)
+		}
+		expectMessage(t, broadcasts, "config_changed map[key:value]")
+
+		b.AnnouncePresence(ctx, "server-b", map[string]interface{}{"version": "test"})
+		b.AnnounceShutdown(ctx, "server-b")
+		expectMessage(t, presences, presence{"online", "server-b", map[string]interface{}{"version": "test"}})
+		expectMessage(t, presences, presence{"offline", "server-b", nil})
+	})
+}
+
+func TestBroker_ReportsDocumentsAfterReconnecting(t *testing.T) {
+	forEachBroker(t, func(t *testing.T, connect func(t *testing.T) Broker, interrupt func()) {
+		ctx := context.Background()
+		a, b := connect(t), connect(t)
+
+		received := make(chan string, 8)
+		for _, docID := range []string{"room:2", "room:1"} {
+			a.SubscribeToDocument(ctx, docID, func(data []byte) { received <- string(data) })
+		}
+		reconnected := make(chan []string, 4)
+		a.OnReconnect(func(documentIDs []string) { reconnected <- documentIDs })
+
+		interrupt()
+		expectMessage(t, reconnected, []string{"room:1", "room:2"})
+		if !a.IsConnected() || a.GetStats().Reconnects == 0 {
+			t.Errorf("after reconnecting: connected %v, stats %+v", a.IsConnected(), a.GetStats())
+		}
+
+		// Subscriptions were restored
+		if err := b.PublishDelta(ctx, "room:1", 1); err != nil {
+			t.Fatalf("PublishDelta after reconnect: %v", err)
+		}
+		expectMessage(t, received, "1")
+	})
+}
@@ -0,0 +1,331 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/nats-io/nats.go"
+)
+
+// Backoff between attempts to reach NATS again, as for Redis
+const (
+	reconnectMinDelay = 100 * time.Millisecond
+	reconnectMaxDelay = 10 * time.Second
+)
+
+// flushTimeout bounds waiting for NATS to confirm a subscription when the
+// caller's context has no deadline
+const flushTimeout = 5 * time.Second
+
+// NATSConfig holds NATS connection configuration
+type NATSConfig struct {
+	URL string
+
+	// SubjectPrefix starts every subject, e.g. "synckit." for
+	// synckit.doc.<docId>, synckit.broadcast and synckit.presence
+	SubjectPrefix string
+
+	// Name identifies the connection in NATS monitoring
+	Name string
+}
+
+// NATS implements Broker over core NATS subjects named like the Redis
+// channels, with "." in place of ":". Like Redis pub/sub, messages
+// published while a server is disconnected are not delivered to it.
+type NATS struct {
+	config      NATSConfig
+	conn        *nats.Conn
+	handlers    map[string][]func([]byte) // subject -> handlers
+	handlersMu  sync.RWMutex
+	subs        map[string]*nats.Subscription // Broadcast and presence subjects
+	docSub      *nats.Subscription            // Wildcard subscription serving every document subject; nil until the first
+	subsMu      sync.Mutex
+	panics      atomic.Int64
+	onReconnect atomic.Pointer[func(documentIDs []string)]
+}
+
+// NewNATS creates a NATS broker; Connect connects it
+func NewNATS(config NATSConfig) *NATS {
+	return &NATS{
+		config:   config,
+		handlers: make(map[string][]func([]byte)),
+		subs:     make(map[string]*nats.Subscription),
+	}
+}
+
+// Connect connects to NATS. Once connected, a lost connection is retried
+// with exponential backoff for as long as the broker is open.
+func (n *NATS) Connect(ctx context.Context) error {
+	opts := []nats.Option{
+		nats.Name(n.config.Name),
+		nats.MaxReconnects(-1),
+		nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			delay := reconnectMinDelay
+			for i := 1; i < attempts && delay < reconnectMaxDelay; i++ {
+				delay *= 2
+			}
+			return min(delay, reconnectMaxDelay)
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			// Subscriptions are resent before this is called, but may not
+			// have reached the server yet
+			conn.FlushTimeout(flushTimeout)
+			n.documentsReconnected()
+		}),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		opts = append(opts, nats.Timeout(time.Until(deadline)))
+	}
+	conn, err := nats.Connect(n.config.URL, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	n.conn = conn
+	return nil
+}
+
+// Disconnect closes the connection
+func (n *NATS) Disconnect(ctx context.Context) error {
+	if n.conn != nil {
+		n.conn.Close()
+	}
+	return nil
+}
+
+// IsConnected reports whether the connection to NATS is up
+func (n *NATS) IsConnected() bool {
+	return n.conn != nil && n.conn.IsConnected()
+}
+
+// OnReconnect sets fn to be called when the connection is restored, with
+// the documents that have handlers. fn runs on the connection's callback
+// goroutine.
+func (n *NATS) OnReconnect(fn func(documentIDs []string)) {
+	n.onReconnect.Store(&fn)
+}
+
+// PublishDelta publishes a delta to a document subject
+func (n *NATS) PublishDelta(ctx context.Context, documentID string, delta interface{}) error {
+	return n.publish(n.documentSubject(documentID), delta)
+}
+
+// PublishAwareness publishes an awareness update to a document subject
+func (n *NATS) PublishAwareness(ctx context.Context, documentID string, state interface{}) error {
+	return n.publish(n.documentSubject(documentID), state)
+}
+
+// SubscribeToDocument registers a handler for a document's messages. Every
+// document subject is served by one wildcard subscription, made with the
+// first.
+func (n *NATS) SubscribeToDocument(ctx context.Context, documentID string, handler func([]byte)) error {
+	n.subsMu.Lock()
+	defer n.subsMu.Unlock()
+	if n.docSub == nil {
+		sub, err := n.conn.Subscribe(n.documentSubject(">"), n.dispatch)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to documents: %w", err)
+		}
+		if err := n.flush(ctx); err != nil {
+			sub.Unsubscribe()
+			return fmt.Errorf("failed to subscribe to documents: %w", err)
+		}
+		n.docSub = sub
+	}
+
+	subject := n.documentSubject(documentID)
+	n.handlersMu.Lock()
+	n.handlers[subject] = append(n.handlers[subject], handler)
+	n.handlersMu.Unlock()
+	return nil
+}
+
+// UnsubscribeFromDocument removes a document's handlers
+func (n *NATS) UnsubscribeFromDocument(ctx context.Context, documentID string) error {
+	n.handlersMu.Lock()
+	delete(n.handlers, n.documentSubject(documentID))
+	n.handlersMu.Unlock()
+	return nil
+}
+
+// PublishBroadcast publishes to the broadcast subject (all servers)
+func (n *NATS) PublishBroadcast(ctx context.Context, event string, data interface{}) error {
+	return n.publish(n.config.SubjectPrefix+"broadcast", storage.BroadcastEvent{Event: event, Data: data})
+}
+
+// SubscribeToBroadcast subscribes to the broadcast subject
+func (n *NATS) SubscribeToBroadcast(ctx context.Context, handler func(event string, data interface{})) error {
+	return n.subscribe(ctx, n.config.SubjectPrefix+"broadcast", func(data []byte) {
+		var evt storage.BroadcastEvent
+		if err := json.Unmarshal(data, &evt); err == nil {
+			handler(evt.Event, evt.Data)
+		}
+	})
+}
+
+// AnnouncePresence announces server presence
+func (n *NATS) AnnouncePresence(ctx context.Context, serverID string, metadata map[string]interface{}) error {
+	return n.publish(n.config.SubjectPrefix+"presence", storage.PresenceEvent{
+		Type:      "server_online",
+		ServerID:  serverID,
+		Timestamp: time.Now().UnixMilli(),
+		Metadata:  metadata,
+	})
+}
+
+// AnnounceShutdown announces server shutdown
+func (n *NATS) AnnounceShutdown(ctx context.Context, serverID string) error {
+	return n.publish(n.config.SubjectPrefix+"presence", storage.PresenceEvent{
+		Type:      "server_offline",
+		ServerID:  serverID,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// SubscribeToPresence subscribes to server presence events
+func (n *NATS) SubscribeToPresence(ctx context.Context, handler func(event string, serverID string, metadata map[string]interface{})) error {
+	return n.subscribe(ctx, n.config.SubjectPrefix+"presence", func(data []byte) {
+		var evt storage.PresenceEvent
+		if err := json.Unmarshal(data, &evt); err != nil {
+			return
+		}
+		switch evt.Type {
+		case "server_online":
+			handler("online", evt.ServerID, evt.Metadata)
+		case "server_offline":
+			handler("offline", evt.ServerID, evt.Metadata)
+		}
+	})
+}
+
+// GetStats returns broker statistics
+func (n *NATS) GetStats() storage.Stats {
+	n.handlersMu.RLock()
+	defer n.handlersMu.RUnlock()
+
+	totalHandlers := 0
+	for _, handlers := range n.handlers {
+		totalHandlers += len(handlers)
+	}
+	var reconnects int64
+	if n.conn != nil {
+		reconnects = int64(n.conn.Stats().Reconnects)
+	}
+	return storage.Stats{
+		Connected:          n.IsConnected(),
+		SubscribedChannels: len(n.handlers),
+		TotalHandlers:      totalHandlers,
+		Reconnects:         reconnects,
+		HandlerPanics:      n.panics.Load(),
+	}
+}
+
+func (n *NATS) publish(subject string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %w", err)
+	}
+	return n.conn.Publish(subject, payload)
+}
+
+// subscribe registers a handler for a subject, subscribing with the first
+// and waiting for NATS to have it so nothing published afterwards is missed
+func (n *NATS) subscribe(ctx context.Context, subject string, handler func([]byte)) error {
+	n.handlersMu.Lock()
+	n.handlers[subject] = append(n.handlers[subject], handler)
+	isFirstHandler := len(n.handlers[subject]) == 1
+	n.handlersMu.Unlock()
+	if !isFirstHandler {
+		return nil
+	}
+
+	n.subsMu.Lock()
+	defer n.subsMu.Unlock()
+	sub, err := n.conn.Subscribe(subject, n.dispatch)
+	if err == nil {
+		if err = n.flush(ctx); err != nil {
+			sub.Unsubscribe()
+		}
+	}
+	if err != nil {
+		n.handlersMu.Lock()
+		delete(n.handlers, subject)
+		n.handlersMu.Unlock()
+		return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+	n.subs[subject] = sub
+	return nil
+}
+
+// flush waits for NATS to have processed everything sent so far
+func (n *NATS) flush(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flushTimeout)
+		defer cancel()
+	}
+	return n.conn.FlushWithContext(ctx)
+}
+
+// dispatch hands a message to the handlers of its subject. NATS calls it
+// for one message of a subscription at a time.
+func (n *NATS) dispatch(msg *nats.Msg) {
+	n.handlersMu.RLock()
+	handlers := n.handlers[msg.Subject]
+	n.handlersMu.RUnlock()
+	for _, handler := range handlers {
+		n.runHandler(msg.Subject, handler, msg.Data)
+	}
+}
+
+// documentsReconnected tells the OnReconnect callback about every document
+// with handlers, since any of them may have missed messages
+func (n *NATS) documentsReconnected() {
+	fn := n.onReconnect.Load()
+	if fn == nil {
+		return
+	}
+	docPrefix := n.documentSubject("")
+	var documentIDs []string
+	n.handlersMu.RLock()
+	for subject := range n.handlers {
+		if strings.HasPrefix(subject, docPrefix) {
+			documentIDs = append(documentIDs, strings.TrimPrefix(subject, docPrefix))
+		}
+	}
+	n.handlersMu.RUnlock()
+	if len(documentIDs) > 0 {
+		sort.Strings(documentIDs)
+		(*fn)(documentIDs)
+	}
+}
+
+func (n *NATS) documentSubject(documentID string) string {
+	return n.config.SubjectPrefix + "doc." + documentID
+}
+
+// runHandler calls handler, logging and counting a panic so one bad message
+// does not stop the subscription
+func (n *NATS) runHandler(subject string, handler func([]byte), payload []byte) {
+	defer func() {
+		if v := recover(); v != nil {
+			n.panics.Add(1)
+			slog.Error("Relay handler panicked",
+				"err", fmt.Sprint(v),
+				"subject", subject,
+				"stack", string(debug.Stack()),
+			)
+		}
+	}()
+	handler(payload)
+}
+
+var _ Broker = (*NATS)(nil)
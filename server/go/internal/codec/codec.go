@@ -0,0 +1,96 @@
+// Package codec selects the JSON implementation the server decodes client
+// messages and stores documents with. The standard library is the default;
+// go-json is a faster drop-in selected with JSON_CODEC.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	gojson "github.com/goccy/go-json"
+)
+
+// Codec names
+const (
+	Std    = "std"
+	GoJSON = "go-json"
+)
+
+// Codec marshals and unmarshals JSON as encoding/json does
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type stdCodec struct{}
+
+func (stdCodec) Name() string                               { return Std }
+func (stdCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (stdCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type goJSONCodec struct{}
+
+func (goJSONCodec) Name() string                               { return GoJSON }
+func (goJSONCodec) Marshal(v interface{}) ([]byte, error)      { return gojson.Marshal(v) }
+func (goJSONCodec) Unmarshal(data []byte, v interface{}) error { return gojson.Unmarshal(data, v) }
+
+var codecs = []Codec{stdCodec{}, goJSONCodec{}}
+
+// current is the codec in use
+var current atomic.Pointer[Codec]
+
+func init() {
+	current.Store(&codecs[0])
+}
+
+// Names lists the available codecs, the default first
+func Names() []string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+// Lookup returns the codec called name. Empty means the default.
+func Lookup(name string) (Codec, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return codecs[0], nil
+	}
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown JSON codec %q (want one of %s)", name, strings.Join(Names(), ", "))
+}
+
+// Use makes the codec called name the one Marshal and Unmarshal use. It is
+// meant to be called once at startup.
+func Use(name string) error {
+	c, err := Lookup(name)
+	if err != nil {
+		return err
+	}
+	current.Store(&c)
+	return nil
+}
+
+// Current returns the codec in use
+func Current() Codec {
+	return *current.Load()
+}
+
+// Marshal encodes v as JSON with the codec in use
+func Marshal(v interface{}) ([]byte, error) {
+	return Current().Marshal(v)
+}
+
+// Unmarshal decodes JSON into v with the codec in use
+func Unmarshal(data []byte, v interface{}) error {
+	return Current().Unmarshal(data, v)
+}
@@ -0,0 +1,92 @@
+package codec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"", Std},
+		{"std", Std},
+		{" GO-JSON ", GoJSON},
+	} {
+		c, err := Lookup(tc.name)
+		if err != nil || c.Name() != tc.want {
+			t.Errorf("Lookup(%q) = %v, %v, want %s", tc.name, c, err, tc.want)
+		}
+	}
+	if _, err := Lookup("sonic"); err == nil || !strings.Contains(err.Error(), "std, go-json") {
+		t.Errorf("Lookup(sonic) error = %v, want the codecs listed", err)
+	}
+	if names := Names(); !reflect.DeepEqual(names, []string{Std, GoJSON}) {
+		t.Errorf("Names() = %v, want the default first", names)
+	}
+}
+
+func TestUse(t *testing.T) {
+	if Current().Name() != Std {
+		t.Fatalf("default codec = %s, want %s", Current().Name(), Std)
+	}
+	t.Cleanup(func() { Use(Std) })
+
+	if err := Use(GoJSON); err != nil {
+		t.Fatal(err)
+	}
+	if Current().Name() != GoJSON {
+		t.Errorf("codec after Use = %s, want %s", Current().Name(), GoJSON)
+	}
+	if err := Use("sonic"); err == nil || Current().Name() != GoJSON {
+		t.Errorf("Use(sonic) = %v and switched to %s, want an error and no change", err, Current().Name())
+	}
+	data, err := Marshal(map[string]interface{}{"a": 1})
+	if err != nil || string(data) != `{"a":1}` {
+		t.Errorf("Marshal = %s, %v", data, err)
+	}
+}
+
+// payloads are decoded, encoded and decoded again by both codecs
+var payloads = []string{
+	`{"type":"delta","docId":"room:1","changes":{"title":"Standup","done":false,"tags":["a","b"],"owner":null}}`,
+	`{"int":42,"negative":-7,"float":3.25,"exponent":1e21,"small":1.5e-7,"maxSafe":9007199254740991,"zero":0}`,
+	`{"quote\"key":1,"back\\slash":2,"new\nline":3,"tab\tkey":4,"été":5,"<html>&amp;":6," ":7}`,
+	`{"nested":{"deeper":{"list":[1,{"x":"y"},[2.5]]}},"empty":{},"none":[]}`,
+}
+
+func TestCodecsRoundTripTheSamePayloads(t *testing.T) {
+	for _, payload := range payloads {
+		var want interface{}
+		if err := (stdCodec{}).Unmarshal([]byte(payload), &want); err != nil {
+			t.Fatalf("std Unmarshal(%s): %v", payload, err)
+		}
+
+		for _, c := range codecs {
+			var got interface{}
+			if err := c.Unmarshal([]byte(payload), &got); err != nil {
+				t.Errorf("%s Unmarshal(%s): %v", c.Name(), payload, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s decoded %s as %v, want %v", c.Name(), payload, got, want)
+			}
+			encoded, err := c.Marshal(got)
+			if err != nil {
+				t.Errorf("%s Marshal: %v", c.Name(), err)
+				continue
+			}
+
+			// The encodings need not match byte for byte (go-json writes
+			// 1.5e-07 where encoding/json writes 1.5e-7), but each codec
+			// reads back what the other writes
+			for _, other := range codecs {
+				var again interface{}
+				if err := other.Unmarshal(encoded, &again); err != nil || !reflect.DeepEqual(again, want) {
+					t.Errorf("%s decoding %s output %s = %v, %v", other.Name(), c.Name(), encoded, again, err)
+				}
+			}
+		}
+	}
+}
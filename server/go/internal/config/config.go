@@ -1,9 +1,17 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
-	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
 )
 
 // Config holds server configuration
@@ -13,60 +21,582 @@ type Config struct {
 	Port        int
 	Environment string
 
+	// TLS: serve https/wss when both files are set. TLSClientCA additionally
+	// requires client certificates signed by it (mTLS).
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSClientCA string
+
+	// Plaintext port serving only the health endpoints, for probes that
+	// cannot present a certificate (0 disables it)
+	HealthPort int
+
+	// Port of the gRPC DocumentService listener (0 disables it)
+	GRPCPort int
+
+	// HTTP server timeouts
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// Largest HTTP request body accepted, in bytes (0 keeps the server
+	// default of 2MB)
+	MaxRequestBodyBytes int64
+
 	// Authentication
 	JWTSecret string
 
+	// Algorithm client tokens are verified with: HS256 against JWTSecret,
+	// or RS256/ES256 against JWTPublicKeyFile or the keys at JWTJWKSURL.
+	// Tokens this server issues are always HS256.
+	JWTAlgorithm     string
+	JWTPublicKeyFile string
+	JWTJWKSURL       string
+	JWTJWKSRefresh   time.Duration // How long fetched JWKS keys are cached
+
+	// Issuer (iss) and audience (aud) client tokens must carry and issued
+	// tokens get (empty skips the check), and the clock skew allowed when
+	// checking their expiry
+	JWTIssuer   string
+	JWTAudience string
+	JWTLeeway   time.Duration
+
+	// Static key accepted as a bearer token on /admin/* alongside admin JWTs
+	// (empty accepts JWTs only)
+	AdminAPIKey string
+
+	// Admin requests per minute per IP, failed ones included (0 keeps the
+	// server default)
+	AdminRateLimit int
+
+	// Users POST /auth/token accepts, as "email:password[:role]" (see
+	// auth.NewStaticUsers). Ignored in production unless
+	// AuthDevUsersInProduction is set.
+	AuthDevUsers             []string
+	AuthDevUsersInProduction bool
+
+	// Requests to /auth/token and /auth/refresh per minute per IP (0 keeps
+	// the server default)
+	AuthRateLimit int
+
+	// API keys for server-to-server clients, as "name:sha256[:role]" (see
+	// auth.NewAPIKeys). More can be created through /admin/apikeys.
+	APIKeys []string
+
+	// How long each user's document grants are cached (0 disables the
+	// cache); other servers see grant changes once it expires
+	ACLCacheTTL time.Duration
+
+	// Let authenticated users create documents their token does not cover;
+	// the creator owns the document and keeps access to it
+	OpenDocumentCreation bool
+
+	// Host several tenants: tokens must carry a tenantId claim, except
+	// admins', and reach only documents whose IDs start with "<tenantId>:"
+	MultiTenancy bool
+
+	// JSON file mapping document ID prefixes to the JSON Schemas their
+	// documents must follow; deltas that break them are refused. More can
+	// be set through /admin/schemas.
+	SchemaFile string
+
+	// What a delta is checked for: "state" (default), the document it would
+	// leave, or "changes", only the fields it changes
+	SchemaValidation string
+
+	// Refuse websocket connections that authenticate without a token
+	// (default: true in production, false elsewhere); without it they get
+	// full access
+	AuthRequired bool
+
+	// Let connections without a token read public documents even when
+	// authentication is required; they can never write
+	AnonymousRead bool
+
+	// Check the token revocation list before every delta, not only when a
+	// client authenticates
+	TokenRevocationOnWrite bool
+
 	// Database (optional)
 	DatabaseURL string
 
 	// Redis (optional)
-	RedisURL          string
+	RedisURL           string
 	RedisChannelPrefix string
 
-	// CORS
+	// Redis deployment for relaying between servers: "single" (default,
+	// REDIS_URL), "sentinel" or "cluster". With sentinel or cluster,
+	// REDIS_URL is optional and only supplies credentials.
+	RedisMode           string
+	RedisSentinelMaster string
+	RedisSentinelAddrs  []string
+	RedisClusterAddrs   []string
+
+	// TLS for the relay's Redis connections, on top of a rediss:// URL
+	RedisTLSCAFile             string
+	RedisTLSInsecureSkipVerify bool
+
+	// Which broker relays between servers: "redis" (default, REDIS_URL) or
+	// "nats" (NATS_URL)
+	Broker  string
+	NATSURL string
+
+	// How deltas travel between servers: "pubsub" (default) or "streams",
+	// which lets a server catch up after losing Redis for a while
+	RedisTransport string
+
+	// Deltas kept per document stream with the streams transport (0 keeps
+	// the default)
+	RedisStreamMaxLen int
+
+	// Most handlers of one relayed channel running at once (0 keeps the
+	// default)
+	RedisHandlerWorkers int
+
+	// How often servers compare checksums of the documents they share (0
+	// keeps the hub default)
+	RelayChecksumInterval time.Duration
+
+	// Identifies this server to the others (default a random ID). Keeping
+	// it across restarts lets the streams transport resume where it left off.
+	ServerID string
+
+	// Browser origins allowed by CORS_ORIGINS, as listed
 	CORSOrigins []string
+
+	// Which browser origins may open websockets and read HTTP responses,
+	// compiled from CORSOrigins
+	Origins *security.OriginPolicy
+
+	// Close an older connection when a new one authenticates with the same
+	// user and client ID
+	KickDuplicateClients bool
+
+	// Negotiate permessage-deflate with websocket clients that offer it
+	WebsocketCompression bool
+
+	// Payload size in bytes above which messages are deflate-compressed for
+	// clients that ask for it when authenticating (0 disables)
+	CompressionThreshold int
+
+	// Refuse a whole batch envelope when any message in it does not decode
+	StrictBatches bool
+
+	// Resume buffer: recent broadcasts kept per document for reconnecting
+	// clients (0 keeps the hub defaults)
+	ResumeBufferSize int
+	ResumeRetention  time.Duration
+
+	// Reconnect delay suggested to clients on shutdown (0 keeps the hub default)
+	ShutdownReconnectDelay time.Duration
+
+	// Goroutines handling document messages (0 uses GOMAXPROCS)
+	HubWorkers int
+
+	// How often clients that ask for heartbeats are sent the server's
+	// status (0 sends none)
+	HeartbeatInterval time.Duration
+
+	// Rejected or dropped deltas per document before sync_required (0 keeps the hub default)
+	SyncRequiredThreshold int
+
+	// Send ACKs only after the delta's document has been persisted
+	// (needs DatabaseURL)
+	DurableAcks bool
+
+	// Documents and approximate bytes kept in memory before idle ones are
+	// evicted, and how long a document must go unchanged first (needs
+	// DatabaseURL; 0 leaves a bound off or keeps the hub default)
+	DocumentCacheSize  int
+	DocumentCacheBytes int64
+	DocumentCacheIdle  time.Duration
+
+	// Keep serving from memory while the database is down, buffering writes
+	// to up to WriteBufferSize documents, with the database probed every
+	// StorageHealthInterval (needs DatabaseURL; 0 keeps the hub default)
+	DegradedMode          bool
+	StorageHealthInterval time.Duration
+	WriteBufferSize       int
+
+	// How long a connection may stay unauthenticated (0 keeps the hub default)
+	AuthTimeout time.Duration
+
+	// How long before its token expires a connection is asked to
+	// authenticate again, and how long after it is closed (0 keeps the hub
+	// defaults)
+	TokenExpiryWarning time.Duration
+	TokenExpiryGrace   time.Duration
+
+	// How long handling one message may take, storage calls included (0
+	// keeps the hub default)
+	MessageTimeout time.Duration
+
+	// Start in maintenance mode, refusing document writes until an admin
+	// turns it off. The message and retry hint are passed on to clients.
+	MaintenanceMode       bool
+	MaintenanceMessage    string
+	MaintenanceRetryAfter time.Duration
+
+	// Security limits: connections, rates, document sizes, subscriptions
+	// and awareness traffic
+	Limits security.Limits
+
+	// Which documents clients may access at all
+	PublicDocuments *security.PublicDocumentPolicy
+
+	// Automatic bans for IPs that keep hitting the rate limit (zero fields
+	// keep the security defaults)
+	Bans security.BanOptions
+
+	// Write security audit events to the log (they are also stored when
+	// DatabaseURL is set)
+	AuditLog bool
+
+	// Days stored audit events are kept (0 keeps them forever)
+	AuditRetentionDays int
+
+	// Directory POST /admin/backup writes archives to (empty disables
+	// backups to files)
+	BackupDir string
+
+	// S3 bucket POST /admin/backup uploads archives to, the endpoint of an
+	// S3-compatible service when not AWS, and the credentials to sign the
+	// uploads with
+	BackupS3Bucket    string
+	BackupS3Region    string
+	BackupS3Endpoint  string
+	BackupS3AccessKey string
+	BackupS3SecretKey string
+
+	// Serve Prometheus metrics on /metrics
+	MetricsEnabled bool
+
+	// Bearer token required to scrape /metrics (empty leaves it open)
+	MetricsToken string
+
+	// Require a valid JWT to read /stats
+	StatsAuthRequired bool
+
+	// Minimum level of log records written
+	LogLevel slog.Level
+
+	// Log output format: logging.FormatJSON or logging.FormatText
+	LogFormat string
+
+	// JSON implementation for decoding messages and storing documents:
+	// codec.Std or codec.GoJSON
+	JSONCodec string
 }
 
-// Load loads configuration from environment variables
-func Load() *Config {
-	env := getEnv("ENVIRONMENT", "development")
-	jwtSecret := getEnv("JWT_SECRET", "")
+// Load reads configuration from environment variables, layered over
+// CONFIG_FILE when that is set
+func Load() (*Config, error) {
+	return LoadFromFile(os.Getenv("CONFIG_FILE"))
+}
 
-	if jwtSecret == "" {
-		if env == "production" {
-			panic("JWT_SECRET environment variable is required in production")
+// LoadFromFile reads configuration from a YAML or JSON file, with
+// environment variables overriding its values and defaults filling the rest.
+// An empty path reads the environment only. Every problem found, from
+// malformed values to failed validation, is returned together.
+func LoadFromFile(path string) (*Config, error) {
+	src := &source{}
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, err
 		}
+		src.file = values
+	}
+
+	cfg := src.load()
+	errs := append(src.errs, src.unknownKeys()...)
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if cfg.Origins.Mode() == security.OriginPolicyStrict && len(cfg.CORSOrigins) == 0 {
+		slog.Warn("ORIGIN_POLICY=strict without CORS_ORIGINS: every browser origin will be rejected")
+	}
+	return cfg, nil
+}
+
+func (src *source) load() *Config {
+	env := src.string("ENVIRONMENT", "development")
+	jwtSecret := src.string("JWT_SECRET", "")
+	if jwtSecret == "" && env != "production" {
 		jwtSecret = "development-secret-do-not-use-in-production"
 	}
 
-	if env == "production" && len(jwtSecret) < 32 {
-		panic(fmt.Sprintf("JWT_SECRET must be at least 32 characters in production (got %d)", len(jwtSecret)))
+	publicDocs, err := security.NewPublicDocumentPolicy(src.publicDocumentRules())
+	if err != nil {
+		src.fail(fmt.Errorf("invalid public document policy: %w", err))
+	}
+
+	logLevel, err := logging.ParseLevel(src.string("LOG_LEVEL", "info"))
+	if err != nil {
+		src.fail(fmt.Errorf("invalid LOG_LEVEL: %w", err))
+	}
+	defaultFormat := logging.FormatText
+	if env == "production" {
+		defaultFormat = logging.FormatJSON
+	}
+	logFormat, err := logging.ParseFormat(src.string("LOG_FORMAT", defaultFormat))
+	if err != nil {
+		src.fail(fmt.Errorf("invalid LOG_FORMAT: %w", err))
+	}
+	jsonCodec := codec.Std
+	if c, err := codec.Lookup(src.string("JSON_CODEC", codec.Std)); err != nil {
+		src.fail(fmt.Errorf("invalid JSON_CODEC: %w", err))
+	} else {
+		jsonCodec = c.Name()
+	}
+
+	originRules := src.originRules(env)
+	origins, err := security.NewOriginPolicy(originRules)
+	if err != nil {
+		src.fail(fmt.Errorf("invalid origin policy: %w", err))
+		origins, _ = security.NewOriginPolicy(security.OriginRules{Mode: security.OriginPolicyStrict})
 	}
 
 	return &Config{
-		Host:               getEnv("HOST", "0.0.0.0"),
-		Port:               getEnvInt("PORT", 8080),
-		Environment:        env,
-		JWTSecret:          jwtSecret,
-		DatabaseURL:        getEnv("DATABASE_URL", ""),
-		RedisURL:           getEnv("REDIS_URL", ""),
-		RedisChannelPrefix: getEnv("REDIS_CHANNEL_PREFIX", "synckit"),
-		CORSOrigins:        []string{"*"}, // TODO: Parse from env
+		Host:                       src.string("HOST", "0.0.0.0"),
+		Port:                       src.int("PORT", 8080),
+		Environment:                env,
+		TLSCertFile:                src.string("TLS_CERT_FILE", ""),
+		TLSKeyFile:                 src.string("TLS_KEY_FILE", ""),
+		TLSClientCA:                src.string("TLS_CLIENT_CA", ""),
+		HealthPort:                 src.int("HEALTH_PORT", 0),
+		GRPCPort:                   src.int("GRPC_PORT", 0),
+		ReadTimeout:                src.seconds("HTTP_READ_TIMEOUT_SECONDS", 15),
+		WriteTimeout:               src.seconds("HTTP_WRITE_TIMEOUT_SECONDS", 15),
+		IdleTimeout:                src.seconds("HTTP_IDLE_TIMEOUT_SECONDS", 60),
+		MaxRequestBodyBytes:        int64(src.int("MAX_REQUEST_BODY_BYTES", 0)),
+		JWTSecret:                  jwtSecret,
+		JWTAlgorithm:               src.string("JWT_ALGORITHM", "HS256"),
+		JWTPublicKeyFile:           src.string("JWT_PUBLIC_KEY_FILE", ""),
+		JWTJWKSURL:                 src.string("JWT_JWKS_URL", ""),
+		JWTJWKSRefresh:             src.seconds("JWT_JWKS_REFRESH_SECONDS", 3600),
+		JWTIssuer:                  src.string("JWT_ISSUER", ""),
+		JWTAudience:                src.string("JWT_AUDIENCE", ""),
+		JWTLeeway:                  src.seconds("JWT_LEEWAY", 0),
+		AdminAPIKey:                src.string("ADMIN_API_KEY", ""),
+		AdminRateLimit:             src.int("ADMIN_RATE_LIMIT", 0),
+		AuthDevUsers:               src.list("AUTH_DEV_USERS", splitList),
+		AuthDevUsersInProduction:   src.bool("AUTH_DEV_USERS_IN_PRODUCTION", false),
+		AuthRateLimit:              src.int("AUTH_RATE_LIMIT", 0),
+		APIKeys:                    src.list("API_KEYS", splitList),
+		ACLCacheTTL:                src.seconds("ACL_CACHE_SECONDS", 30),
+		OpenDocumentCreation:       src.bool("OPEN_DOCUMENT_CREATION", false),
+		MultiTenancy:               src.bool("MULTI_TENANCY", false),
+		SchemaFile:                 src.string("SCHEMA_FILE", ""),
+		SchemaValidation:           src.string("SCHEMA_VALIDATION", "state"),
+		AuthRequired:               src.bool("SYNCKIT_AUTH_REQUIRED", env == "production"),
+		AnonymousRead:              src.bool("ANONYMOUS_READ", false),
+		TokenRevocationOnWrite:     src.bool("TOKEN_REVOCATION_CHECK_WRITES", false),
+		DatabaseURL:                src.string("DATABASE_URL", ""),
+		RedisURL:                   src.string("REDIS_URL", ""),
+		RedisChannelPrefix:         src.string("REDIS_CHANNEL_PREFIX", "synckit"),
+		RedisMode:                  src.string("REDIS_MODE", "single"),
+		RedisSentinelMaster:        src.string("REDIS_SENTINEL_MASTER", ""),
+		RedisSentinelAddrs:         src.list("REDIS_SENTINEL_ADDRS", splitList),
+		RedisClusterAddrs:          src.list("REDIS_CLUSTER_ADDRS", splitList),
+		RedisTLSCAFile:             src.string("REDIS_TLS_CA_FILE", ""),
+		RedisTLSInsecureSkipVerify: src.bool("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+		Broker:                     src.string("BROKER", "redis"),
+		NATSURL:                    src.string("NATS_URL", ""),
+		RedisTransport:             src.string("REDIS_TRANSPORT", "pubsub"),
+		RedisStreamMaxLen:          src.int("REDIS_STREAM_MAX_LEN", 0),
+		RedisHandlerWorkers:        src.int("REDIS_HANDLER_WORKERS", 0),
+		RelayChecksumInterval:      src.seconds("RELAY_CHECKSUM_INTERVAL_SECONDS", 0),
+		ServerID:                   src.string("SERVER_ID", ""),
+		CORSOrigins:                originRules.Allowed,
+		Origins:                    origins,
+
+		KickDuplicateClients: src.bool("KICK_DUPLICATE_CLIENTS", false),
+		WebsocketCompression: src.bool("WEBSOCKET_COMPRESSION", false),
+		CompressionThreshold: src.int("COMPRESSION_THRESHOLD", 16384),
+		StrictBatches:        src.bool("STRICT_BATCHES", false),
+
+		ResumeBufferSize: src.int("RESUME_BUFFER_SIZE", 0),
+		ResumeRetention:  src.seconds("RESUME_RETENTION_SECONDS", 0),
+
+		ShutdownReconnectDelay: src.seconds("SHUTDOWN_RECONNECT_DELAY_SECONDS", 0),
+		HubWorkers:             src.int("HUB_WORKERS", 0),
+		HeartbeatInterval:      src.seconds("HEARTBEAT_INTERVAL_SECONDS", 0),
+		SyncRequiredThreshold:  src.int("SYNC_REQUIRED_THRESHOLD", 0),
+		DurableAcks:            src.bool("DURABLE_ACKS", false),
+		DocumentCacheSize:      src.int("DOCUMENT_CACHE_SIZE", 0),
+		DocumentCacheBytes:     int64(src.int("DOCUMENT_CACHE_BYTES", 0)),
+		DocumentCacheIdle:      src.seconds("DOCUMENT_CACHE_IDLE_SECONDS", 0),
+		DegradedMode:           src.bool("DEGRADED_MODE", true),
+		StorageHealthInterval:  src.seconds("STORAGE_HEALTH_INTERVAL_SECONDS", 0),
+		WriteBufferSize:        src.int("WRITE_BUFFER_SIZE", 0),
+		AuthTimeout:            src.seconds("AUTH_TIMEOUT", 0),
+		TokenExpiryWarning:     src.seconds("TOKEN_EXPIRY_WARNING_SECONDS", 0),
+		TokenExpiryGrace:       src.seconds("TOKEN_EXPIRY_GRACE_SECONDS", 0),
+		MessageTimeout:         src.seconds("MESSAGE_TIMEOUT_SECONDS", 0),
+
+		MaintenanceMode:       src.bool("MAINTENANCE_MODE", false),
+		MaintenanceMessage:    src.string("MAINTENANCE_MESSAGE", ""),
+		MaintenanceRetryAfter: src.seconds("MAINTENANCE_RETRY_AFTER_SECONDS", 0),
+
+		Limits:          src.limits(),
+		PublicDocuments: publicDocs,
+		Bans: security.BanOptions{
+			Threshold: src.int("BAN_THRESHOLD", 0),
+			Window:    src.seconds("BAN_WINDOW_SECONDS", 0),
+			Duration:  src.seconds("BAN_DURATION_SECONDS", 0),
+		},
+
+		AuditLog:           src.bool("AUDIT_LOG", true),
+		AuditRetentionDays: src.int("AUDIT_RETENTION_DAYS", 90),
+
+		BackupDir:         src.string("BACKUP_DIR", ""),
+		BackupS3Bucket:    src.string("BACKUP_S3_BUCKET", ""),
+		BackupS3Region:    src.string("BACKUP_S3_REGION", "us-east-1"),
+		BackupS3Endpoint:  src.string("BACKUP_S3_ENDPOINT", ""),
+		BackupS3AccessKey: src.string("BACKUP_S3_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+		BackupS3SecretKey: src.string("BACKUP_S3_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+
+		MetricsEnabled: src.bool("METRICS_ENABLED", false),
+		MetricsToken:   src.string("METRICS_TOKEN", ""),
+
+		StatsAuthRequired: src.bool("STATS_AUTH_REQUIRED", false),
+
+		LogLevel:  logLevel,
+		LogFormat: logFormat,
+		JSONCodec: jsonCodec,
 	}
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// TLSEnabled reports whether the server serves TLS itself
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// limits reads security limits, defaulting each to security.DefaultLimits
+func (src *source) limits() security.Limits {
+	d := security.DefaultLimits()
+	return security.Limits{
+		MaxConnectionsPerIP:  src.int("MAX_CONNECTIONS_PER_IP", d.MaxConnectionsPerIP),
+		MaxMessagesPerMinute: src.int("MAX_MESSAGES_PER_MINUTE", d.MaxMessagesPerMinute),
+		MaxBlocksPerDoc:      src.int("MAX_BLOCKS_PER_DOC", d.MaxBlocksPerDoc),
+		MaxBlockSize:         src.int("MAX_BLOCK_SIZE", d.MaxBlockSize),
+		MaxDocSize:           src.int("MAX_DOC_SIZE", d.MaxDocSize),
+		MaxDocsPerIP:         src.int("MAX_DOCS_PER_IP", d.MaxDocsPerIP),
+		MaxDocsPerHour:       src.int("MAX_DOCS_PER_HOUR", d.MaxDocsPerHour),
+		MaxMessageSize:       src.int("MAX_MESSAGE_SIZE", d.MaxMessageSize),
+
+		MaxUnauthenticatedPerIP:       src.int("MAX_UNAUTHENTICATED_PER_IP", d.MaxUnauthenticatedPerIP),
+		MaxAnonymousMessagesPerMinute: src.int("MAX_ANONYMOUS_MESSAGES_PER_MINUTE", d.MaxAnonymousMessagesPerMinute),
+
+		MaxSubscriptionsPerConnection: src.int("MAX_SUBSCRIPTIONS_PER_CONNECTION", d.MaxSubscriptionsPerConnection),
+		MaxSubscribersPerDocument:     src.int("MAX_SUBSCRIBERS_PER_DOCUMENT", d.MaxSubscribersPerDocument),
+		MaxPrefixSubscriptions:        src.int("MAX_PREFIX_SUBSCRIPTIONS", d.MaxPrefixSubscriptions),
+		MaxListSubscriptions:          src.int("MAX_LIST_SUBSCRIPTIONS", d.MaxListSubscriptions),
+		MaxAwarenessStateSize:         src.int("MAX_AWARENESS_STATE_SIZE", d.MaxAwarenessStateSize),
+		MaxAwarenessUpdatesPerSecond:  src.int("AWARENESS_UPDATES_PER_SECOND", d.MaxAwarenessUpdatesPerSecond),
+	}
+}
+
+// publicDocumentRules reads the public document policy. IDs and prefixes
+// are comma-separated; patterns are separated by whitespace since regular
+// expressions may contain commas. Without any IDs, prefixes or patterns the
+// defaults apply.
+func (src *source) publicDocumentRules() security.PublicDocumentRules {
+	rules := security.PublicDocumentRules{
+		Mode:     src.string("PUBLIC_DOC_MODE", security.PolicyModeRules),
+		IDs:      src.list("PUBLIC_DOC_IDS", splitList),
+		Prefixes: src.list("PUBLIC_DOC_PREFIXES", splitList),
+		Patterns: src.list("PUBLIC_DOC_PATTERNS", strings.Fields),
+	}
+	if len(rules.IDs) == 0 && len(rules.Prefixes) == 0 && len(rules.Patterns) == 0 {
+		defaults := security.DefaultPublicDocumentRules()
+		defaults.Mode = rules.Mode
+		return defaults
+	}
+	return rules
+}
+
+// originRules reads the websocket origin policy. Production defaults to
+// strict mode, everything else to permissive.
+func (src *source) originRules(env string) security.OriginRules {
+	mode := security.OriginPolicyPermissive
+	if env == "production" {
+		mode = security.OriginPolicyStrict
+	}
+	return security.OriginRules{
+		Mode:            src.string("ORIGIN_POLICY", mode),
+		Allowed:         src.list("CORS_ORIGINS", splitList),
+		AllowNonBrowser: src.bool("ALLOW_NON_BROWSER_CLIENTS", false),
+	}
+}
+
+// RelayRedisConfigured reports whether Redis settings for relaying between
+// servers were given, by REDIS_URL or by sentinel or cluster addresses
+func (c *Config) RelayRedisConfigured() bool {
+	switch c.RedisMode {
+	case "sentinel":
+		return len(c.RedisSentinelAddrs) > 0
+	case "cluster":
+		return len(c.RedisClusterAddrs) > 0
 	}
-	return defaultValue
+	return c.RedisURL != ""
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Redacted returns a copy of c that is safe to show: secrets are replaced
+// and passwords are removed from connection URLs
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	for _, secret := range []*string{&redacted.JWTSecret, &redacted.AdminAPIKey, &redacted.MetricsToken, &redacted.BackupS3SecretKey} {
+		if *secret != "" {
+			*secret = redactedValue
+		}
+	}
+	redacted.DatabaseURL = redactURL(c.DatabaseURL)
+	redacted.RedisURL = redactURL(c.RedisURL)
+	if len(c.AuthDevUsers) > 0 {
+		redacted.AuthDevUsers = make([]string, len(c.AuthDevUsers))
+		for i, user := range c.AuthDevUsers {
+			email, _, _ := strings.Cut(user, ":")
+			redacted.AuthDevUsers[i] = email + ":" + redactedValue
 		}
 	}
-	return defaultValue
+	if len(c.APIKeys) > 0 {
+		redacted.APIKeys = make([]string, len(c.APIKeys))
+		for i, key := range c.APIKeys {
+			name, _, _ := strings.Cut(key, ":")
+			redacted.APIKeys[i] = name + ":" + redactedValue
+		}
+	}
+	return &redacted
+}
+
+// redactedValue replaces secrets in Redacted
+const redactedValue = "[redacted]"
+
+// redactURL masks the password in a connection URL. Values that do not
+// parse are masked entirely since they might hold credentials.
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redactedValue
+	}
+	return u.Redacted()
 }
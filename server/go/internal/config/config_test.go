@@ -0,0 +1,236 @@
+package config
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+)
+
+func mustLoad(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return cfg
+}
+
+func TestLoad_LimitsFromEnv(t *testing.T) {
+	t.Setenv("MAX_CONNECTIONS_PER_IP", "7")
+	t.Setenv("MAX_DOC_SIZE", "1024")
+
+	cfg := mustLoad(t)
+	if cfg.Limits.MaxConnectionsPerIP != 7 || cfg.Limits.MaxDocSize != 1024 {
+		t.Errorf("Limits = %+v, want MaxConnectionsPerIP 7 and MaxDocSize 1024", cfg.Limits)
+	}
+	if cfg.Limits.MaxMessagesPerMinute != 500 {
+		t.Errorf("MaxMessagesPerMinute = %d, want the default 500", cfg.Limits.MaxMessagesPerMinute)
+	}
+}
+
+func TestLoad_RejectsNonPositiveLimits(t *testing.T) {
+	for _, value := range []string{"0", "-5"} {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("MAX_MESSAGES_PER_MINUTE", value)
+			if _, err := Load(); err == nil {
+				t.Errorf("Load() accepted MAX_MESSAGES_PER_MINUTE=%s", value)
+			}
+		})
+	}
+}
+
+func TestLoad_RedisSentinel(t *testing.T) {
+	t.Setenv("REDIS_MODE", "sentinel")
+	t.Setenv("REDIS_SENTINEL_MASTER", "mymaster")
+	t.Setenv("REDIS_SENTINEL_ADDRS", "sentinel-1:26379, sentinel-2:26379")
+
+	cfg := mustLoad(t)
+	if len(cfg.RedisSentinelAddrs) != 2 || cfg.RedisSentinelAddrs[1] != "sentinel-2:26379" {
+		t.Errorf("RedisSentinelAddrs = %v, want both sentinels", cfg.RedisSentinelAddrs)
+	}
+	if !cfg.RelayRedisConfigured() {
+		t.Error("RelayRedisConfigured() = false without REDIS_URL, want true for sentinels")
+	}
+}
+
+func TestLoad_PublicDocumentPolicy(t *testing.T) {
+	t.Setenv("PUBLIC_DOC_IDS", "lobby")
+	t.Setenv("PUBLIC_DOC_PATTERNS", `^team-\d{2,}$ ^pub:`)
+
+	policy := mustLoad(t).PublicDocuments
+	for docID, want := range map[string]bool{"lobby": true, "team-42": true, "pub:x": true, "playground": false} {
+		if got := policy.Allows(docID); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", docID, got, want)
+		}
+	}
+}
+
+func TestLoad_RejectsInvalidPublicDocumentPattern(t *testing.T) {
+	t.Setenv("PUBLIC_DOC_PATTERNS", `^room:(`)
+	if _, err := Load(); err == nil {
+		t.Error("Load() accepted an invalid PUBLIC_DOC_PATTERNS")
+	}
+}
+
+func TestLoad_OriginPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		origin  string
+		allowed bool
+	}{
+		{"development is permissive", map[string]string{}, "https://anything.test", true},
+		{"production is strict", map[string]string{"ENVIRONMENT": "production", "JWT_SECRET": "a-production-secret-of-32-characters"}, "", false},
+		{"production allowlist", map[string]string{"ENVIRONMENT": "production", "JWT_SECRET": "a-production-secret-of-32-characters", "CORS_ORIGINS": "https://*.example.com"}, "https://app.example.com", true},
+		{"non-browser clients", map[string]string{"ORIGIN_POLICY": "strict", "ALLOW_NON_BROWSER_CLIENTS": "true"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if got := mustLoad(t).Origins.Allows(tt.origin); got != tt.allowed {
+				t.Errorf("Allows(%q) = %v, want %v", tt.origin, got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestLoad_CORSOrigins(t *testing.T) {
+	if got := mustLoad(t).CORSOrigins; len(got) != 0 {
+		t.Errorf("default CORSOrigins = %v, want none", got)
+	}
+	t.Setenv("CORS_ORIGINS", " https://app.example.com, ,https://*.example.org ")
+	got := mustLoad(t).CORSOrigins
+	if len(got) != 2 || got[0] != "https://app.example.com" || got[1] != "https://*.example.org" {
+		t.Errorf("CORSOrigins = %v", got)
+	}
+}
+
+func TestLoad_RejectsWildcardOriginInStrictMode(t *testing.T) {
+	t.Setenv("ORIGIN_POLICY", "strict")
+	t.Setenv("CORS_ORIGINS", "*")
+	if _, err := Load(); err == nil {
+		t.Error("Load() accepted CORS_ORIGINS=* in strict mode")
+	}
+}
+
+func TestLoad_Logging(t *testing.T) {
+	cfg := mustLoad(t)
+	if cfg.LogLevel != slog.LevelInfo || cfg.LogFormat != logging.FormatText {
+		t.Errorf("development defaults = %v %s, want INFO text", cfg.LogLevel, cfg.LogFormat)
+	}
+
+	t.Setenv("ENVIRONMENT", "production")
+	t.Setenv("JWT_SECRET", "a-production-secret-of-32-characters")
+	t.Setenv("LOG_LEVEL", "debug")
+	cfg = mustLoad(t)
+	if cfg.LogLevel != slog.LevelDebug || cfg.LogFormat != logging.FormatJSON {
+		t.Errorf("production = %v %s, want DEBUG json", cfg.LogLevel, cfg.LogFormat)
+	}
+}
+
+func TestLoad_JSONCodec(t *testing.T) {
+	if got := mustLoad(t).JSONCodec; got != codec.Std {
+		t.Errorf("default JSONCodec = %q, want %q", got, codec.Std)
+	}
+	t.Setenv("JSON_CODEC", " Go-JSON ")
+	if got := mustLoad(t).JSONCodec; got != codec.GoJSON {
+		t.Errorf("JSONCodec = %q, want %q", got, codec.GoJSON)
+	}
+	t.Setenv("JSON_CODEC", "sonic")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "JSON_CODEC") {
+		t.Errorf("Load() error = %v, want an invalid JSON_CODEC", err)
+	}
+}
+
+func TestLoad_AuthRequired(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		required bool
+	}{
+		{"development default", map[string]string{}, false},
+		{"production default", map[string]string{"ENVIRONMENT": "production", "JWT_SECRET": "a-production-secret-of-32-characters"}, true},
+		{"required in development", map[string]string{"SYNCKIT_AUTH_REQUIRED": "true"}, true},
+		{"optional in production", map[string]string{"ENVIRONMENT": "production", "JWT_SECRET": "a-production-secret-of-32-characters", "SYNCKIT_AUTH_REQUIRED": "false"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if got := mustLoad(t).AuthRequired; got != tt.required {
+				t.Errorf("AuthRequired = %v, want %v", got, tt.required)
+			}
+		})
+	}
+}
+
+func TestLoad_RejectsUnknownLogLevel(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "chatty")
+	if _, err := Load(); err == nil {
+		t.Error("Load() accepted LOG_LEVEL=chatty")
+	}
+}
+
+func TestLoad_TLSAndTimeouts(t *testing.T) {
+	cfg := mustLoad(t)
+	if cfg.TLSEnabled() || cfg.HealthPort != 0 {
+		t.Errorf("defaults: TLS %v, health port %d; want plaintext only", cfg.TLSEnabled(), cfg.HealthPort)
+	}
+	if cfg.ReadTimeout != 15*time.Second || cfg.WriteTimeout != 15*time.Second || cfg.IdleTimeout != 60*time.Second {
+		t.Errorf("default timeouts = %v %v %v", cfg.ReadTimeout, cfg.WriteTimeout, cfg.IdleTimeout)
+	}
+
+	t.Setenv("TLS_CERT_FILE", "/etc/synckit/tls.crt")
+	t.Setenv("TLS_KEY_FILE", "/etc/synckit/tls.key")
+	t.Setenv("TLS_CLIENT_CA", "/etc/synckit/ca.crt")
+	t.Setenv("HEALTH_PORT", "8081")
+	t.Setenv("HTTP_WRITE_TIMEOUT_SECONDS", "0")
+	t.Setenv("HTTP_IDLE_TIMEOUT_SECONDS", "300")
+	cfg = mustLoad(t)
+	if !cfg.TLSEnabled() || cfg.TLSClientCA != "/etc/synckit/ca.crt" || cfg.HealthPort != 8081 {
+		t.Errorf("TLS config = %+v", cfg)
+	}
+	if cfg.WriteTimeout != 0 || cfg.IdleTimeout != 300*time.Second {
+		t.Errorf("timeouts = %v %v, want 0 and 5m", cfg.WriteTimeout, cfg.IdleTimeout)
+	}
+}
+
+func TestLoad_RejectsCertificateWithoutKey(t *testing.T) {
+	t.Setenv("TLS_CERT_FILE", "/etc/synckit/tls.crt")
+	if _, err := Load(); err == nil {
+		t.Error("Load() accepted TLS_CERT_FILE without TLS_KEY_FILE")
+	}
+}
+
+func TestLoad_RejectsShortAdminAPIKey(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "too-short")
+	if _, err := Load(); err == nil {
+		t.Error("Load() accepted a 9-character ADMIN_API_KEY")
+	}
+}
+
+func TestLoad_BackupS3(t *testing.T) {
+	t.Setenv("BACKUP_S3_BUCKET", "synckit-backups")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, err := Load(); err == nil {
+		t.Error("Load() accepted BACKUP_S3_BUCKET without credentials")
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "aws-secret")
+	cfg := mustLoad(t)
+	if cfg.BackupS3AccessKey != "AKIDEXAMPLE" || cfg.BackupS3SecretKey != "aws-secret" || cfg.BackupS3Region != "us-east-1" {
+		t.Errorf("S3 settings = %q %q %q, want the AWS credentials in us-east-1", cfg.BackupS3AccessKey, cfg.BackupS3SecretKey, cfg.BackupS3Region)
+	}
+	if cfg.Redacted().BackupS3SecretKey == "aws-secret" {
+		t.Error("Redacted() kept BACKUP_S3_SECRET_ACCESS_KEY")
+	}
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fileValue is a setting from the config file: a scalar, or a list for
+// settings such as CORS_ORIGINS
+type fileValue struct {
+	scalar string
+	list   []string
+	isList bool
+}
+
+// readFile reads a .yaml, .yml or .json config file. Settings use the
+// environment variable names, in any case:
+//
+//	port: 8080
+//	jwt_secret: "..."
+//	cors_origins:
+//	  - https://app.example.com
+//	  - https://*.example.com
+func readFile(path string) (map[string]fileValue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	var values map[string]fileValue
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		values, err = parseYAML(data)
+	case ".json":
+		values, err = parseJSON(data)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension %q (want .yaml, .yml or .json)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+func parseJSON(data []byte) (map[string]fileValue, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]fileValue, len(raw))
+	for key, value := range raw {
+		if value == nil {
+			continue
+		}
+		if items, ok := value.([]interface{}); ok {
+			list := make([]string, 0, len(items))
+			for _, item := range items {
+				scalar, err := jsonScalar(key, item)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, scalar)
+			}
+			values[strings.ToUpper(key)] = fileValue{list: list, isList: true}
+			continue
+		}
+		scalar, err := jsonScalar(key, value)
+		if err != nil {
+			return nil, err
+		}
+		values[strings.ToUpper(key)] = fileValue{scalar: scalar}
+	}
+	return values, nil
+}
+
+func jsonScalar(key string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("%s: nested objects are not supported", key)
+	}
+}
+
+// parseYAML reads the subset of YAML a flat settings file needs: top-level
+// "key: value" pairs with plain or quoted scalars, inline ([a, b]) and
+// block ("- a") lists, and comments. Nested mappings are rejected.
+func parseYAML(data []byte) (map[string]fileValue, error) {
+	values := make(map[string]fileValue)
+	var listKey string // Key whose block list is being read
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := stripYAMLComment(scanner.Text())
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+
+		indented := line[0] == ' ' || line[0] == '\t'
+		if indented || strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" || !strings.HasPrefix(trimmed, "-") {
+				return nil, fmt.Errorf("line %d: nested mappings are not supported", lineNo)
+			}
+			item, err := yamlScalar(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			value := values[listKey]
+			value.list = append(value.list, item)
+			values[listKey] = value
+			continue
+		}
+
+		key, rest, found := strings.Cut(trimmed, ":")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", lineNo)
+		}
+		key = strings.ToUpper(key)
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", lineNo, strings.ToLower(key))
+		}
+		rest = strings.TrimSpace(rest)
+		listKey = ""
+
+		switch {
+		case rest == "":
+			// A block list follows
+			listKey = key
+			values[key] = fileValue{list: []string{}, isList: true}
+		case rest == "~" || rest == "null":
+		case strings.HasPrefix(rest, "["):
+			list, err := yamlInlineList(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			values[key] = fileValue{list: list, isList: true}
+		case strings.HasPrefix(rest, "{"):
+			return nil, fmt.Errorf("line %d: nested mappings are not supported", lineNo)
+		default:
+			scalar, err := yamlScalar(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			values[key] = fileValue{scalar: scalar}
+		}
+	}
+	return values, scanner.Err()
+}
+
+// stripYAMLComment drops a "#" comment that starts the line or follows
+// whitespace, outside quotes
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlScalar unquotes a double- or single-quoted scalar; plain scalars are
+// returned as written
+func yamlScalar(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", value)
+		}
+		return s, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("invalid quoted string %s", value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+	return value, nil
+}
+
+// yamlInlineList parses [a, "b", c]. Items may not contain commas.
+func yamlInlineList(value string) ([]string, error) {
+	if !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("unterminated list %s", value)
+	}
+	list := []string{}
+	for _, item := range strings.Split(value[1:len(value)-1], ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		scalar, err := yamlScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, scalar)
+	}
+	return list, nil
+}
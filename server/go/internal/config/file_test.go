@@ -0,0 +1,197 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFromFile_Precedence(t *testing.T) {
+	path := writeConfigFile(t, "synckit.yaml", `
+# Listener
+port: 9000
+host: "127.0.0.1"   # quoted
+redis_channel_prefix: 'team # a'
+cors_origins:
+  - https://app.example.com
+  - https://*.example.org
+max_doc_size: 2048
+`)
+	t.Setenv("PORT", "9100")
+
+	cfg, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+	if cfg.Port != 9100 {
+		t.Errorf("Port = %d, want 9100 from the environment", cfg.Port)
+	}
+	if cfg.Host != "127.0.0.1" || cfg.RedisChannelPrefix != "team # a" || cfg.Limits.MaxDocSize != 2048 {
+		t.Errorf("file values = %q %q %d", cfg.Host, cfg.RedisChannelPrefix, cfg.Limits.MaxDocSize)
+	}
+	if len(cfg.CORSOrigins) != 2 || !cfg.Origins.Allows("https://docs.example.org") {
+		t.Errorf("CORSOrigins = %v", cfg.CORSOrigins)
+	}
+	if cfg.Limits.MaxMessagesPerMinute != 500 || cfg.IdleTimeout.Seconds() != 60 {
+		t.Errorf("defaults not applied: %d messages/min, idle %v", cfg.Limits.MaxMessagesPerMinute, cfg.IdleTimeout)
+	}
+}
+
+func TestLoadFromFile_JSON(t *testing.T) {
+	path := writeConfigFile(t, "synckit.json", `{
+		"port": 9200,
+		"metrics_enabled": true,
+		"cors_origins": ["https://app.example.com"],
+		"public_doc_patterns": ["^a,b$", "^pub:"]
+	}`)
+
+	cfg, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+	if cfg.Port != 9200 || !cfg.MetricsEnabled || len(cfg.CORSOrigins) != 1 {
+		t.Errorf("cfg = port %d, metrics %v, origins %v", cfg.Port, cfg.MetricsEnabled, cfg.CORSOrigins)
+	}
+	if !cfg.PublicDocuments.Allows("a,b") || !cfg.PublicDocuments.Allows("pub:x") {
+		t.Error("pattern list from the file was not applied item by item")
+	}
+}
+
+func TestLoadFromFile_ViaConfigFileEnv(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "synckit.yml", "port: 9300\n"))
+	if cfg := mustLoad(t); cfg.Port != 9300 {
+		t.Errorf("Port = %d, want 9300 from CONFIG_FILE", cfg.Port)
+	}
+}
+
+func TestLoadFromFile_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"unknown key", "c.yaml", "prot: 8080\n", `unknown setting "prot"`},
+		{"nested mapping", "c.yaml", "server:\n  port: 8080\n", "nested mappings are not supported"},
+		{"duplicate key", "c.yaml", "port: 1\nport: 2\n", "port is set twice"},
+		{"bad integer", "c.yaml", "port: eighty\n", `PORT: invalid integer "eighty"`},
+		{"list for scalar", "c.yaml", "host: [a, b]\n", "HOST: expected a single value"},
+		{"nested JSON", "c.json", `{"limits": {"max_doc_size": 1}}`, "nested objects are not supported"},
+		{"unsupported extension", "c.toml", "port = 1\n", "unsupported extension"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFromFile(writeConfigFile(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadFromFile error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadFromFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadFromFile accepted a missing file")
+	}
+}
+
+func TestValidate_ReportsEveryProblem(t *testing.T) {
+	t.Setenv("PORT", "70000")
+	t.Setenv("DATABASE_URL", "mysql://db.internal/synckit")
+	t.Setenv("REDIS_URL", "redis://")
+	t.Setenv("REDIS_TRANSPORT", "kafka")
+	t.Setenv("BROKER", "nats")
+	t.Setenv("REDIS_MODE", "cluster")
+	t.Setenv("CORS_ORIGINS", "https://app.example.com/path")
+	t.Setenv("MAX_DOC_SIZE", "0")
+	t.Setenv("AUDIT_LOG", "maybe")
+	t.Setenv("AUTH_DEV_USERS", "alice@example.com:pw:owner")
+	t.Setenv("JWT_LEEWAY", "-5")
+	t.Setenv("ACL_CACHE_SECONDS", "-1")
+	t.Setenv("TOKEN_EXPIRY_GRACE_SECONDS", "-1")
+	t.Setenv("COMPRESSION_THRESHOLD", "-1")
+	t.Setenv("HEARTBEAT_INTERVAL_SECONDS", "-1")
+	t.Setenv("DOCUMENT_CACHE_SIZE", "-1")
+	t.Setenv("WRITE_BUFFER_SIZE", "-1")
+	t.Setenv("SCHEMA_VALIDATION", "full")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Load accepted an invalid configuration")
+	}
+	for _, want := range []string{
+		"PORT must be between 1 and 65535",
+		"DATABASE_URL: scheme must be one of postgres, postgresql",
+		"REDIS_URL: missing host",
+		`REDIS_TRANSPORT must be pubsub or streams (got "kafka")`,
+		"REDIS_MODE=cluster requires REDIS_CLUSTER_ADDRS",
+		"BROKER=nats requires NATS_URL",
+		`CORS_ORIGINS: invalid origin "https://app.example.com/path"`,
+		"invalid security limits",
+		`AUDIT_LOG: invalid boolean "maybe"`,
+		"AUTH_DEV_USERS: user alice@example.com: role must be admin, writer or reader",
+		"JWT_LEEWAY must not be negative",
+		"ACL_CACHE_SECONDS must not be negative",
+		"TOKEN_EXPIRY_WARNING_SECONDS and TOKEN_EXPIRY_GRACE_SECONDS must not be negative",
+		"COMPRESSION_THRESHOLD must not be negative",
+		"HEARTBEAT_INTERVAL_SECONDS must not be negative",
+		"DOCUMENT_CACHE_SIZE, DOCUMENT_CACHE_BYTES and DOCUMENT_CACHE_IDLE_SECONDS must not be negative",
+		"STORAGE_HEALTH_INTERVAL_SECONDS and WRITE_BUFFER_SIZE must not be negative",
+		`SCHEMA_VALIDATION must be state or changes (got "full")`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestValidate_ProductionSecret(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "production")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "JWT_SECRET is required") {
+		t.Errorf("Load error = %v, want missing JWT_SECRET", err)
+	}
+	t.Setenv("JWT_SECRET", "short")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "at least 32 characters") {
+		t.Errorf("Load error = %v, want short JWT_SECRET", err)
+	}
+}
+
+func TestValidate_JWTAlgorithm(t *testing.T) {
+	t.Setenv("JWT_ALGORITHM", "RS256")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "requires exactly one of JWT_PUBLIC_KEY_FILE or JWT_JWKS_URL") {
+		t.Errorf("Load error = %v, want a missing key", err)
+	}
+	t.Setenv("JWT_PUBLIC_KEY_FILE", filepath.Join(t.TempDir(), "missing.pem"))
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "JWT_PUBLIC_KEY_FILE:") {
+		t.Errorf("Load error = %v, want an unreadable key file", err)
+	}
+
+	t.Setenv("JWT_PUBLIC_KEY_FILE", "")
+	t.Setenv("JWT_JWKS_URL", "https://idp.example.com/.well-known/jwks.json")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.JWTAlgorithm != "RS256" || cfg.JWTJWKSRefresh != time.Hour {
+		t.Errorf("JWT config = %s, %v; want RS256, 1h", cfg.JWTAlgorithm, cfg.JWTJWKSRefresh)
+	}
+	// JWT_SECRET is not needed in production when tokens are asymmetric
+	t.Setenv("ENVIRONMENT", "production")
+	if _, err := Load(); err != nil {
+		t.Errorf("Load in production without JWT_SECRET: %v", err)
+	}
+
+	t.Setenv("JWT_ALGORITHM", "HS256")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "require JWT_ALGORITHM=RS256 or ES256") {
+		t.Errorf("Load error = %v, want a JWKS URL rejected for HS256", err)
+	}
+}
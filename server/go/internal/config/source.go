@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// source looks settings up in the environment first, then in the config
+// file. Malformed values are collected in errs rather than silently
+// replaced by defaults.
+type source struct {
+	file map[string]fileValue // Keyed by environment variable name
+	used map[string]bool
+	errs []error
+}
+
+// lookup returns the raw value of key and whether it is set. An empty
+// environment variable counts as unset.
+func (src *source) lookup(key string) (fileValue, bool) {
+	if src.used == nil {
+		src.used = make(map[string]bool)
+	}
+	src.used[key] = true
+	if value := os.Getenv(key); value != "" {
+		return fileValue{scalar: value}, true
+	}
+	value, ok := src.file[key]
+	return value, ok
+}
+
+func (src *source) fail(err error) {
+	src.errs = append(src.errs, err)
+}
+
+func (src *source) string(key, defaultValue string) string {
+	value, ok := src.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	if value.isList {
+		src.fail(fmt.Errorf("%s: expected a single value, got a list", key))
+		return defaultValue
+	}
+	return value.scalar
+}
+
+func (src *source) int(key string, defaultValue int) int {
+	value, ok := src.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value.scalar)
+	if err != nil || value.isList {
+		src.fail(fmt.Errorf("%s: invalid integer %q", key, value.scalar))
+		return defaultValue
+	}
+	return n
+}
+
+func (src *source) bool(key string, defaultValue bool) bool {
+	value, ok := src.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value.scalar)
+	if err != nil || value.isList {
+		src.fail(fmt.Errorf("%s: invalid boolean %q", key, value.scalar))
+		return defaultValue
+	}
+	return b
+}
+
+// seconds reads a whole number of seconds
+func (src *source) seconds(key string, defaultValue int) time.Duration {
+	return time.Duration(src.int(key, defaultValue)) * time.Second
+}
+
+// list reads a list: from the environment as a string cut up by split, from
+// the file as either a list or such a string
+func (src *source) list(key string, split func(string) []string) []string {
+	value, ok := src.lookup(key)
+	if !ok {
+		return split("")
+	}
+	if value.isList {
+		return value.list
+	}
+	return split(value.scalar)
+}
+
+// unknownKeys reports file settings that no lookup asked for, which are
+// most likely typos
+func (src *source) unknownKeys() []error {
+	var unknown []string
+	for key := range src.file {
+		if !src.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	errs := make([]error, 0, len(unknown))
+	for _, key := range unknown {
+		errs = append(errs, fmt.Errorf("unknown setting %q in config file", strings.ToLower(key)))
+	}
+	return errs
+}
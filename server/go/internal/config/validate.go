@@ -0,0 +1,235 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/schema"
+)
+
+// Validate checks the configuration for values the server cannot run with,
+// returning every problem found joined into one error
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		fail("PORT must be between 1 and 65535 (got %d)", c.Port)
+	}
+	if c.HealthPort < 0 || c.HealthPort > 65535 {
+		fail("HEALTH_PORT must be between 1 and 65535, or 0 to disable it (got %d)", c.HealthPort)
+	} else if c.HealthPort != 0 && c.HealthPort == c.Port {
+		fail("HEALTH_PORT must differ from PORT (both %d)", c.Port)
+	}
+	if c.GRPCPort < 0 || c.GRPCPort > 65535 {
+		fail("GRPC_PORT must be between 1 and 65535, or 0 to disable it (got %d)", c.GRPCPort)
+	} else if c.GRPCPort != 0 && (c.GRPCPort == c.Port || c.GRPCPort == c.HealthPort) {
+		fail("GRPC_PORT must differ from PORT and HEALTH_PORT (got %d)", c.GRPCPort)
+	}
+
+	asymmetric := c.JWTAlgorithm == auth.AlgorithmRS256 || c.JWTAlgorithm == auth.AlgorithmES256
+	if c.Environment == "production" {
+		if c.JWTSecret == "" {
+			// Asymmetric setups only need the secret to issue tokens
+			if !asymmetric {
+				fail("JWT_SECRET is required in production")
+			}
+		} else if len(c.JWTSecret) < 32 {
+			fail("JWT_SECRET must be at least 32 characters in production (got %d)", len(c.JWTSecret))
+		}
+	}
+	switch {
+	case c.JWTAlgorithm == "" || c.JWTAlgorithm == auth.AlgorithmHS256:
+		if c.JWTPublicKeyFile != "" || c.JWTJWKSURL != "" {
+			fail("JWT_PUBLIC_KEY_FILE and JWT_JWKS_URL require JWT_ALGORITHM=RS256 or ES256")
+		}
+	case asymmetric:
+		if (c.JWTPublicKeyFile == "") == (c.JWTJWKSURL == "") {
+			fail("JWT_ALGORITHM=%s requires exactly one of JWT_PUBLIC_KEY_FILE or JWT_JWKS_URL", c.JWTAlgorithm)
+		} else if c.JWTPublicKeyFile != "" {
+			if _, err := auth.LoadPublicKey(c.JWTPublicKeyFile, c.JWTAlgorithm); err != nil {
+				fail("JWT_PUBLIC_KEY_FILE: %v", err)
+			}
+		}
+		if err := validateURL(c.JWTJWKSURL, "https", "http"); err != nil {
+			fail("JWT_JWKS_URL: %v", err)
+		}
+	default:
+		fail("JWT_ALGORITHM must be HS256, RS256 or ES256 (got %q)", c.JWTAlgorithm)
+	}
+	if c.JWTJWKSRefresh < 0 {
+		fail("JWT_JWKS_REFRESH_SECONDS must not be negative")
+	}
+	if c.JWTLeeway < 0 {
+		fail("JWT_LEEWAY must not be negative")
+	}
+	if c.HeartbeatInterval < 0 {
+		fail("HEARTBEAT_INTERVAL_SECONDS must not be negative")
+	}
+	if c.DocumentCacheSize < 0 || c.DocumentCacheBytes < 0 || c.DocumentCacheIdle < 0 {
+		fail("DOCUMENT_CACHE_SIZE, DOCUMENT_CACHE_BYTES and DOCUMENT_CACHE_IDLE_SECONDS must not be negative")
+	}
+	if c.StorageHealthInterval < 0 || c.WriteBufferSize < 0 {
+		fail("STORAGE_HEALTH_INTERVAL_SECONDS and WRITE_BUFFER_SIZE must not be negative")
+	}
+
+	if c.AdminAPIKey != "" && len(c.AdminAPIKey) < 32 {
+		fail("ADMIN_API_KEY must be at least 32 characters (got %d)", len(c.AdminAPIKey))
+	}
+	if c.AdminRateLimit < 0 {
+		fail("ADMIN_RATE_LIMIT must not be negative (got %d)", c.AdminRateLimit)
+	}
+	if _, err := auth.NewStaticUsers(c.AuthDevUsers); err != nil {
+		fail("AUTH_DEV_USERS: %v", err)
+	}
+	if c.AuthRateLimit < 0 {
+		fail("AUTH_RATE_LIMIT must not be negative (got %d)", c.AuthRateLimit)
+	}
+	if _, err := auth.NewAPIKeys(c.APIKeys, nil); err != nil {
+		fail("API_KEYS: %v", err)
+	}
+	if c.ACLCacheTTL < 0 {
+		fail("ACL_CACHE_SECONDS must not be negative")
+	}
+	if c.SchemaValidation != schema.ModeState && c.SchemaValidation != schema.ModeChanges {
+		fail("SCHEMA_VALIDATION must be state or changes (got %q)", c.SchemaValidation)
+	} else if c.SchemaFile != "" {
+		if _, err := schema.LoadFile(c.SchemaFile, c.SchemaValidation); err != nil {
+			fail("SCHEMA_FILE: %v", err)
+		}
+	}
+	if c.TokenExpiryWarning < 0 || c.TokenExpiryGrace < 0 {
+		fail("TOKEN_EXPIRY_WARNING_SECONDS and TOKEN_EXPIRY_GRACE_SECONDS must not be negative")
+	}
+	if c.CompressionThreshold < 0 {
+		fail("COMPRESSION_THRESHOLD must not be negative (got %d)", c.CompressionThreshold)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		fail("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSClientCA != "" && !c.TLSEnabled() {
+		fail("TLS_CLIENT_CA requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 {
+		fail("HTTP timeouts must not be negative")
+	}
+	if c.MaxRequestBodyBytes < 0 {
+		fail("MAX_REQUEST_BODY_BYTES must not be negative (got %d)", c.MaxRequestBodyBytes)
+	}
+	if c.MaintenanceRetryAfter < 0 {
+		fail("MAINTENANCE_RETRY_AFTER_SECONDS must not be negative")
+	}
+
+	if err := validateURL(c.DatabaseURL, "postgres", "postgresql"); err != nil {
+		fail("DATABASE_URL: %v", err)
+	}
+	if c.BackupS3Bucket != "" && (c.BackupS3AccessKey == "" || c.BackupS3SecretKey == "") {
+		fail("BACKUP_S3_BUCKET requires BACKUP_S3_ACCESS_KEY_ID and BACKUP_S3_SECRET_ACCESS_KEY")
+	}
+	if err := validateURL(c.BackupS3Endpoint, "https", "http"); err != nil {
+		fail("BACKUP_S3_ENDPOINT: %v", err)
+	}
+	if err := validateURL(c.RedisURL, "redis", "rediss", "unix"); err != nil {
+		fail("REDIS_URL: %v", err)
+	}
+	switch c.RedisMode {
+	case "", "single":
+	case "sentinel":
+		if c.RedisSentinelMaster == "" || len(c.RedisSentinelAddrs) == 0 {
+			fail("REDIS_MODE=sentinel requires REDIS_SENTINEL_MASTER and REDIS_SENTINEL_ADDRS")
+		}
+	case "cluster":
+		if len(c.RedisClusterAddrs) == 0 {
+			fail("REDIS_MODE=cluster requires REDIS_CLUSTER_ADDRS")
+		}
+		if c.RedisTransport == "streams" {
+			// One read covers every followed stream, which cluster
+			// rejects once they hash to different slots
+			fail("REDIS_TRANSPORT=streams is not supported with REDIS_MODE=cluster")
+		}
+	default:
+		fail("REDIS_MODE must be single, sentinel or cluster (got %q)", c.RedisMode)
+	}
+	if c.RedisTLSCAFile != "" {
+		if _, err := os.Stat(c.RedisTLSCAFile); err != nil {
+			fail("REDIS_TLS_CA_FILE: %v", err)
+		}
+	}
+	switch c.Broker {
+	case "", "redis":
+	case "nats":
+		if c.NATSURL == "" {
+			fail("BROKER=nats requires NATS_URL")
+		}
+		if c.RedisTransport == "streams" {
+			fail("REDIS_TRANSPORT=streams requires BROKER=redis")
+		}
+	default:
+		fail("BROKER must be redis or nats (got %q)", c.Broker)
+	}
+	if err := validateURL(c.NATSURL, "nats", "tls"); err != nil {
+		fail("NATS_URL: %v", err)
+	}
+	if c.RedisTransport != "" && c.RedisTransport != "pubsub" && c.RedisTransport != "streams" {
+		fail("REDIS_TRANSPORT must be pubsub or streams (got %q)", c.RedisTransport)
+	}
+	if c.RedisStreamMaxLen < 0 {
+		fail("REDIS_STREAM_MAX_LEN must not be negative (got %d)", c.RedisStreamMaxLen)
+	}
+	if c.RedisHandlerWorkers < 0 {
+		fail("REDIS_HANDLER_WORKERS must not be negative (got %d)", c.RedisHandlerWorkers)
+	}
+
+	for _, origin := range c.CORSOrigins {
+		if err := validateOrigin(origin); err != nil {
+			fail("CORS_ORIGINS: %v", err)
+		}
+	}
+
+	if err := c.Limits.Validate(); err != nil {
+		fail("invalid security limits: %v", err)
+	}
+	return errors.Join(errs...)
+}
+
+// validateURL accepts an empty value or a URL with one of schemes
+func validateURL(value string, schemes ...string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		// The parse error repeats the URL, which may hold a password
+		return errors.New("not a valid URL")
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			if scheme != "unix" && u.Host == "" {
+				return errors.New("missing host")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("scheme must be one of %s (got %q)", strings.Join(schemes, ", "), u.Scheme)
+}
+
+// validateOrigin checks that an exact origin is scheme://host[:port] with
+// no path. "*" and wildcard patterns are checked by the origin policy.
+func validateOrigin(origin string) error {
+	if strings.Contains(origin, "*") {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" ||
+		u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("invalid origin %q (want scheme://host[:port])", origin)
+	}
+	return nil
+}
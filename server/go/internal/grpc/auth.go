@@ -0,0 +1,122 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// caller is who made a call, as established by authenticate
+type caller struct {
+	token *auth.TokenPayload
+	ip    string
+}
+
+type callerKey struct{}
+
+// callerFrom returns the caller authenticate attached to ctx
+func callerFrom(ctx context.Context) caller {
+	c, _ := ctx.Value(callerKey{}).(caller)
+	return c
+}
+
+// authenticate verifies the bearer token in the "authorization" metadata and
+// refuses banned IPs, returning ctx with the caller attached
+func (s *Service) authenticate(ctx context.Context) (context.Context, error) {
+	c := caller{}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		c.ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(c.ip); err == nil {
+			c.ip = host
+		}
+	}
+	if s.opts.SecurityManager != nil && s.opts.SecurityManager.Bans.IsBanned(c.ip) {
+		return nil, status.Error(codes.PermissionDenied, "Your IP is banned")
+	}
+
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token = strings.TrimPrefix(values[0], "Bearer ")
+		}
+	}
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "Missing token")
+	}
+	var payload *auth.TokenPayload
+	var err error
+	if s.opts.Verifier != nil {
+		payload, err = s.opts.Verifier.VerifyToken(token)
+	} else {
+		payload, err = auth.VerifyToken(token, s.opts.JWTSecret)
+	}
+	if err != nil || payload == nil {
+		s.opts.Audit.Log(audit.Event{
+			Type:    audit.EventAuthFailure,
+			IP:      c.ip,
+			Details: map[string]interface{}{"code": "INVALID_TOKEN", "transport": "grpc"},
+		})
+		return nil, status.Error(codes.Unauthenticated, "Invalid or expired token")
+	}
+	if s.opts.Revocations != nil {
+		revoked, err := s.opts.Revocations.IsRevoked(ctx, payload)
+		if err != nil {
+			s.opts.Logger.Warn("Token revocation check failed", "err", err)
+		} else if revoked {
+			s.opts.Audit.Log(audit.Event{
+				Type:    audit.EventAuthFailure,
+				Actor:   payload.UserID,
+				IP:      c.ip,
+				Details: map[string]interface{}{"code": "TOKEN_REVOKED", "transport": "grpc"},
+			})
+			return nil, status.Error(codes.Unauthenticated, "Token has been revoked")
+		}
+	}
+	if s.opts.MultiTenancy && auth.RequireTenant(payload) != nil {
+		s.opts.Audit.Log(audit.Event{
+			Type:    audit.EventAuthFailure,
+			Actor:   payload.UserID,
+			IP:      c.ip,
+			Details: map[string]interface{}{"code": "TENANT_REQUIRED", "transport": "grpc"},
+		})
+		return nil, status.Error(codes.PermissionDenied, "A token with a tenant is required")
+	}
+	c.token = payload
+	return context.WithValue(ctx, callerKey{}, c), nil
+}
+
+// unaryAuth authenticates unary calls
+func (s *Service) unaryAuth(ctx context.Context, req interface{}, _ *grpclib.UnaryServerInfo, handler grpclib.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// streamAuth authenticates streaming calls
+func (s *Service) streamAuth(srv interface{}, ss grpclib.ServerStream, _ *grpclib.StreamServerInfo, handler grpclib.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticatedStream carries the caller in its context
+type authenticatedStream struct {
+	grpclib.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
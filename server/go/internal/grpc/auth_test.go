@@ -0,0 +1,113 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const testSecret = "this-is-a-test-secret-that-is-at-least-32-chars"
+
+// recordingAudit keeps the events logged to it
+type recordingAudit struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (r *recordingAudit) Log(event audit.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingAudit) all() []audit.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]audit.Event(nil), r.events...)
+}
+
+func tokenFor(t *testing.T, userID, tenantID string, perms auth.DocumentPermissions) string {
+	t.Helper()
+	token, err := auth.IssueTenantAccessToken(userID, "", tenantID, perms, testSecret, time.Hour, auth.ClaimRules{})
+	if err != nil {
+		t.Fatalf("IssueTenantAccessToken failed: %v", err)
+	}
+	return token
+}
+
+// incoming returns a server-side call context from 203.0.113.7 carrying
+// authorization as metadata, if set
+func incoming(authorization string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4000}})
+	if authorization == "" {
+		return ctx
+	}
+	return metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", authorization))
+}
+
+func TestAuthenticate_ReadsTokenFromMetadata(t *testing.T) {
+	rec := &recordingAudit{}
+	s := NewService(Options{JWTSecret: testSecret, Audit: rec})
+	token := tokenFor(t, "alice", "", auth.CreateUserPermissions([]string{"*"}, nil))
+
+	// The Bearer prefix is optional
+	for _, value := range []string{"Bearer " + token, token} {
+		ctx, err := s.authenticate(incoming(value))
+		if err != nil {
+			t.Fatalf("authenticate(%.10s...) failed: %v", value, err)
+		}
+		c := callerFrom(ctx)
+		if c.token == nil || c.token.UserID != "alice" || c.ip != "203.0.113.7" {
+			t.Errorf("caller = %+v, want alice from 203.0.113.7", c)
+		}
+	}
+
+	forged, err := auth.GenerateAccessToken("alice", "", auth.CreateUserPermissions([]string{"*"}, nil), "another-secret-that-is-at-least-32-chars", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, authorization string
+	}{
+		{"missing", ""},
+		{"empty bearer", "Bearer "},
+		{"invalid", "Bearer not-a-token"},
+		{"wrong secret", "Bearer " + forged},
+	} {
+		if _, err := s.authenticate(incoming(tc.authorization)); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s token: error = %v, want Unauthenticated", tc.name, err)
+		}
+	}
+	if events := rec.all(); len(events) != 2 || events[0].Type != audit.EventAuthFailure || events[0].IP != "203.0.113.7" {
+		t.Errorf("audit events = %+v, want an auth failure for each bad token", events)
+	}
+}
+
+func TestAuthenticate_RefusesBannedIPsAndTenantlessTokens(t *testing.T) {
+	manager := security.NewSecurityManager(security.DefaultLimits())
+	manager.Bans.Ban("203.0.113.7", time.Hour, "test")
+	s := NewService(Options{JWTSecret: testSecret, SecurityManager: manager})
+	token := tokenFor(t, "alice", "", auth.CreateUserPermissions([]string{"*"}, nil))
+	if _, err := s.authenticate(incoming("Bearer " + token)); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("banned IP: error = %v, want PermissionDenied", err)
+	}
+
+	s = NewService(Options{JWTSecret: testSecret, MultiTenancy: true})
+	if _, err := s.authenticate(incoming("Bearer " + token)); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("token without a tenant: error = %v, want PermissionDenied", err)
+	}
+	tenant := tokenFor(t, "alice", "acme", auth.CreateUserPermissions([]string{"*"}, nil))
+	if _, err := s.authenticate(incoming("Bearer " + tenant)); err != nil {
+		t.Errorf("tenant token: error = %v", err)
+	}
+}
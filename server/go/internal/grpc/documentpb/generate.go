@@ -0,0 +1,5 @@
+// Package documentpb holds the DocumentService protocol buffers and gRPC
+// stubs generated from document.proto
+package documentpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative document.proto
@@ -0,0 +1,371 @@
+// Package grpc serves DocumentService, the document operations of
+// documentpb/document.proto, for backend services. It shares the websocket
+// hub and storage adapter with the HTTP server: every call runs as a
+// websocket.Session, so permission checks, limits and audit events are the
+// same as for websocket clients, and a Patch is broadcast to websocket
+// subscribers like any other delta.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/Dancode-188/synckit/server/go/internal/acl"
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/grpc/documentpb"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/Dancode-188/synckit/server/go/internal/websocket"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Options configures the service
+type Options struct {
+	Hub       *websocket.Hub
+	Storage   storage.StorageAdapter // Nil when documents are kept in memory only
+	JWTSecret string
+
+	// Verifier checks callers' tokens (nil verifies HS256 tokens with
+	// JWTSecret)
+	Verifier auth.TokenVerifier
+
+	// Refuses revoked tokens when set
+	Revocations auth.RevocationStore
+
+	// Decides which documents callers may access at all (the default policy
+	// when nil)
+	PublicDocuments *security.PublicDocumentPolicy
+
+	// Grants access to documents beyond the token's permissions (token
+	// permissions only when nil)
+	ACL *acl.Evaluator
+
+	// Refuses banned IPs and enforces document creation quotas when set
+	SecurityManager *security.SecurityManager
+
+	// Refuses tokens without a tenant, except admins' (see
+	// websocket.HubOptions.MultiTenancy)
+	MultiTenancy bool
+
+	Audit  audit.AuditLogger
+	Logger *slog.Logger
+}
+
+// Service implements documentpb.DocumentServiceServer
+type Service struct {
+	documentpb.UnimplementedDocumentServiceServer
+	opts Options
+}
+
+// NewService creates the service
+func NewService(opts Options) *Service {
+	if opts.PublicDocuments == nil {
+		opts.PublicDocuments = security.DefaultPublicDocumentPolicy()
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Nop{}
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Service{opts: opts}
+}
+
+// NewServer creates a gRPC server serving DocumentService, authenticating
+// every call
+func NewServer(opts Options, serverOpts ...grpclib.ServerOption) *grpclib.Server {
+	s := NewService(opts)
+	serverOpts = append(serverOpts,
+		grpclib.ChainUnaryInterceptor(s.unaryAuth),
+		grpclib.ChainStreamInterceptor(s.streamAuth),
+	)
+	server := grpclib.NewServer(serverOpts...)
+	documentpb.RegisterDocumentServiceServer(server, s)
+	return server
+}
+
+// Get returns the current state of a document
+func (s *Service) Get(ctx context.Context, req *documentpb.GetRequest) (*documentpb.Document, error) {
+	reply, err := s.call(ctx, protocol.TypeSubscribe, map[string]interface{}{
+		"docId": req.DocId,
+		"mode":  websocket.ModeRead,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return document(reply)
+}
+
+// Put replaces the state of a document. Subscribers are told to resync.
+// A state that breaks the document's schema is refused with
+// InvalidArgument naming the first violation.
+func (s *Service) Put(ctx context.Context, req *documentpb.PutRequest) (*documentpb.Document, error) {
+	if err := s.checkWrite(ctx, "put", req.DocId); err != nil {
+		return nil, err
+	}
+	if violations := s.opts.Hub.ValidateDocument(req.DocId, req.State.AsMap()); len(violations) > 0 {
+		v := violations[0]
+		return nil, status.Errorf(codes.InvalidArgument, "document breaks its schema: %s %s", v.Path, v.Message)
+	}
+	if err := s.opts.Hub.RestoreDocument(ctx, req.DocId, req.State.AsMap()); err != nil {
+		s.opts.Logger.Error("Failed to persist document", "doc_id", req.DocId, "err", err)
+		return nil, status.Error(codes.Internal, "Failed to persist document")
+	}
+	return s.Get(ctx, &documentpb.GetRequest{DocId: req.DocId})
+}
+
+// Patch applies changes to a document as a delta
+func (s *Service) Patch(ctx context.Context, req *documentpb.PatchRequest) (*documentpb.PatchResponse, error) {
+	delta := map[string]interface{}{
+		"docId":   req.DocId,
+		"changes": req.Changes.AsMap(),
+	}
+	if req.Timestamp > 0 {
+		delta["timestamp"] = float64(req.Timestamp)
+	}
+	ack, err := s.call(ctx, protocol.TypeDelta, delta)
+	if err != nil {
+		return nil, err
+	}
+
+	seq, _ := ack.Payload["seq"].(float64)
+	reason, _ := ack.Payload["reason"].(string)
+	return &documentpb.PatchResponse{
+		DocId:   req.DocId,
+		Applied: ack.Payload["status"] == "applied",
+		Reason:  reason,
+		Seq:     int64(seq),
+	}, nil
+}
+
+// Delete removes a document from the hub and from storage
+func (s *Service) Delete(ctx context.Context, req *documentpb.DeleteRequest) (*documentpb.DeleteResponse, error) {
+	if err := s.checkWrite(ctx, "delete", req.DocId); err != nil {
+		return nil, err
+	}
+	deleted := s.opts.Hub.DeleteDocument(req.DocId)
+	if s.opts.Storage != nil {
+		stored, err := s.opts.Storage.DeleteDocument(ctx, req.DocId)
+		if err != nil {
+			s.opts.Logger.Error("Failed to delete document", "doc_id", req.DocId, "err", err)
+			return nil, status.Error(codes.Internal, "Failed to delete document")
+		}
+		deleted = deleted || stored
+	}
+	return &documentpb.DeleteResponse{Deleted: deleted}, nil
+}
+
+// List returns a page of the readable document IDs under a prefix
+func (s *Service) List(ctx context.Context, req *documentpb.ListRequest) (*documentpb.ListResponse, error) {
+	payload := map[string]interface{}{"prefix": req.Prefix, "cursor": req.Cursor}
+	if req.Limit > 0 {
+		payload["limit"] = float64(req.Limit)
+	}
+	reply, err := s.call(ctx, protocol.TypeSubscribeList, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &documentpb.ListResponse{}
+	docIDs, _ := reply.Payload["docIds"].([]interface{})
+	for _, id := range docIDs {
+		if docID, ok := id.(string); ok {
+			resp.DocIds = append(resp.DocIds, docID)
+		}
+	}
+	resp.NextCursor, _ = reply.Payload["nextCursor"].(string)
+	return resp, nil
+}
+
+// WatchDocument streams the document's state, then every delta applied to
+// it. When the document is replaced the state is sent again.
+func (s *Service) WatchDocument(req *documentpb.WatchRequest, stream documentpb.DocumentService_WatchDocumentServer) error {
+	ctx := stream.Context()
+	c := callerFrom(ctx)
+	opts := websocket.EventStreamOptions{Token: c.token, ClientIP: c.ip}
+	if req.ResumeFrom != nil {
+		opts.ResumeFrom, opts.Resume = req.GetResumeFrom(), true
+	}
+	events, err := s.opts.Hub.OpenEventStream(ctx, req.DocId, opts)
+	if err != nil {
+		return sessionError(err)
+	}
+	defer events.Close()
+
+	for {
+		msg, err := events.Next(ctx)
+		if err != nil {
+			return sessionError(err)
+		}
+
+		switch msg.Type {
+		case protocol.TypeSyncResponse:
+			if msg.Payload["resumed"] == true {
+				deltas, _ := msg.Payload["deltas"].([]interface{})
+				for _, d := range deltas {
+					delta, _ := d.(map[string]interface{})
+					if err := sendDelta(stream, delta); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			doc, err := document(msg)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&documentpb.WatchEvent{Event: &documentpb.WatchEvent_Document{Document: doc}}); err != nil {
+				return err
+			}
+
+		case protocol.TypeDelta:
+			if err := sendDelta(stream, msg.Payload); err != nil {
+				return err
+			}
+
+		case protocol.TypeSyncRequired:
+			// The document was replaced or this watcher fell behind; subscribing
+			// again sends the full state
+			_, err := events.Send(ctx, protocol.TypeSubscribe, map[string]interface{}{
+				"docId": req.DocId,
+				"mode":  websocket.ModeRead,
+			})
+			if err != nil {
+				return sessionError(err)
+			}
+
+		case protocol.TypeError:
+			return hubError(msg)
+		}
+	}
+}
+
+// call sends one message through a short-lived session and waits for the
+// reply to it, or for an error
+func (s *Service) call(ctx context.Context, msgType string, payload map[string]interface{}) (*protocol.Message, error) {
+	c := callerFrom(ctx)
+	session, err := s.opts.Hub.OpenSession(ctx, "grpc-", websocket.SessionOptions{
+		Token:           c.token,
+		ClientIP:        c.ip,
+		SecurityManager: s.opts.SecurityManager,
+	})
+	if err != nil {
+		return nil, sessionError(err)
+	}
+	defer session.Close()
+
+	id, err := session.Send(ctx, msgType, payload)
+	if err != nil {
+		return nil, sessionError(err)
+	}
+	for {
+		msg, err := session.Next(ctx)
+		if err != nil {
+			return nil, sessionError(err)
+		}
+		if msg.Type == protocol.TypeError {
+			return nil, hubError(msg)
+		}
+		if msg.Origin == id {
+			return msg, nil
+		}
+	}
+}
+
+// checkWrite applies the websocket delta checks to operations the hub has
+// no message for: the document ID, maintenance mode, the public document
+// policy, then the token's write permission. These replace or delete the
+// whole document, so writers limited to some fields are refused.
+func (s *Service) checkWrite(ctx context.Context, action, docID string) error {
+	if valid, errMsg := security.ValidateDocumentID(docID); !valid {
+		return status.Error(codes.InvalidArgument, errMsg)
+	}
+	if s.opts.Hub.Maintenance().Enabled {
+		return status.Error(codes.Unavailable, websocket.DefaultMaintenanceError)
+	}
+	c := callerFrom(ctx)
+	if s.opts.PublicDocuments.Allows(docID) && s.opts.ACL.CanWrite(ctx, c.token, docID) {
+		if _, limited := s.opts.ACL.WritableFields(ctx, c.token, docID); !limited {
+			return nil
+		}
+	}
+	s.opts.Audit.Log(audit.Event{
+		Type:       audit.EventPermissionDenied,
+		Actor:      c.token.UserID,
+		DocumentID: docID,
+		IP:         c.ip,
+		Details:    map[string]interface{}{"action": action, "code": "PERMISSION_DENIED", "transport": "grpc"},
+	})
+	return status.Error(codes.PermissionDenied, "Permission denied")
+}
+
+// document converts a sync_response carrying the full state
+func document(msg *protocol.Message) (*documentpb.Document, error) {
+	state, _ := msg.Payload["state"].(map[string]interface{})
+	pb, err := structpb.NewStruct(state)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Document state is not representable")
+	}
+	docID, _ := msg.Payload["docId"].(string)
+	seq, _ := msg.Payload["seq"].(float64)
+	return &documentpb.Document{DocId: docID, State: pb, Seq: int64(seq)}, nil
+}
+
+// sendDelta converts a broadcast delta and sends it on stream
+func sendDelta(stream documentpb.DocumentService_WatchDocumentServer, delta map[string]interface{}) error {
+	changes, _ := delta["changes"].(map[string]interface{})
+	pb, err := structpb.NewStruct(changes)
+	if err != nil {
+		return status.Error(codes.Internal, "Delta is not representable")
+	}
+	docID, _ := delta["docId"].(string)
+	seq, _ := delta["seq"].(float64)
+	ts, _ := delta["timestamp"].(float64)
+	return stream.Send(&documentpb.WatchEvent{Event: &documentpb.WatchEvent_Delta{Delta: &documentpb.Delta{
+		DocId:     docID,
+		Seq:       int64(seq),
+		Changes:   pb,
+		Timestamp: int64(ts),
+	}}})
+}
+
+// errorCodes maps the hub's error codes to gRPC status codes; the rest are
+// FailedPrecondition
+var errorCodes = map[string]codes.Code{
+	"NOT_AUTHENTICATED":       codes.Unauthenticated,
+	"ACCESS_DENIED":           codes.PermissionDenied,
+	"PERMISSION_DENIED":       codes.PermissionDenied,
+	"READ_ONLY":               codes.PermissionDenied,
+	"INVALID_REQUEST":         codes.InvalidArgument,
+	"INVALID_DOCUMENT_ID":     codes.InvalidArgument,
+	"DOCUMENT_QUOTA_EXCEEDED": codes.ResourceExhausted,
+	"SUBSCRIPTION_LIMIT":      codes.ResourceExhausted,
+	"DOCUMENT_FULL":           codes.ResourceExhausted,
+	"RATE_LIMITED":            codes.ResourceExhausted,
+	"MAINTENANCE_MODE":        codes.Unavailable,
+}
+
+// hubError converts an error message from the hub into a status error
+func hubError(msg *protocol.Message) error {
+	text, _ := msg.Payload["error"].(string)
+	code, _ := msg.Payload["code"].(string)
+	grpcCode, ok := errorCodes[code]
+	if !ok {
+		grpcCode = codes.FailedPrecondition
+	}
+	return status.Error(grpcCode, text)
+}
+
+// sessionError converts a session failure into a status error
+func sessionError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Unavailable, "Server is shutting down")
+}
@@ -0,0 +1,83 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHubError_MapsCodes(t *testing.T) {
+	for _, tc := range []struct {
+		code string
+		want codes.Code
+	}{
+		{"ACCESS_DENIED", codes.PermissionDenied},
+		{"PERMISSION_DENIED", codes.PermissionDenied},
+		{"READ_ONLY", codes.PermissionDenied},
+		{"NOT_AUTHENTICATED", codes.Unauthenticated},
+		{"INVALID_DOCUMENT_ID", codes.InvalidArgument},
+		{"RATE_LIMITED", codes.ResourceExhausted},
+		{"MAINTENANCE_MODE", codes.Unavailable},
+		{"SOMETHING_NEW", codes.FailedPrecondition},
+		{"", codes.FailedPrecondition},
+	} {
+		msg := protocol.NewMessage(protocol.TypeError, map[string]interface{}{"error": "Nope", "code": tc.code})
+		st, _ := status.FromError(hubError(msg))
+		if st.Code() != tc.want || st.Message() != "Nope" {
+			t.Errorf("hubError(%q) = %v %q, want %v with the hub's message", tc.code, st.Code(), st.Message(), tc.want)
+		}
+	}
+}
+
+func TestCheckWrite_DeniesWithoutWholeDocumentAccess(t *testing.T) {
+	rec := &recordingAudit{}
+	s := NewService(Options{
+		Hub:       websocket.NewHub(websocket.AuthConfig{JWTSecret: testSecret}),
+		JWTSecret: testSecret,
+		Audit:     rec,
+	})
+	call := func(perms auth.DocumentPermissions) context.Context {
+		ctx, err := s.authenticate(incoming("Bearer " + tokenFor(t, "alice", "", perms)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ctx
+	}
+
+	if err := s.checkWrite(call(auth.CreateUserPermissions([]string{"*"}, []string{"*"})), "put", "room:1"); err != nil {
+		t.Errorf("writer: error = %v, want none", err)
+	}
+	if err := s.checkWrite(call(auth.CreateUserPermissions([]string{"*"}, []string{"*"})), "put", "bad id!"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("invalid document ID: error = %v, want InvalidArgument", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		perms auth.DocumentPermissions
+	}{
+		{"reader", auth.CreateUserPermissions([]string{"*"}, nil)},
+		{"other documents", auth.CreateUserPermissions([]string{"*"}, []string{"room:2"})},
+		{"limited to some fields", auth.DocumentPermissions{
+			CanRead:        []string{"*"},
+			CanWrite:       []string{"*"},
+			CanWriteFields: map[string][]string{"room:1": {"comments.*"}},
+		}},
+	} {
+		if err := s.checkWrite(call(tc.perms), "delete", "room:1"); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("%s: error = %v, want PermissionDenied", tc.name, err)
+		}
+	}
+	events := rec.all()
+	if len(events) != 3 {
+		t.Fatalf("audit events = %+v, want one per denial", events)
+	}
+	if e := events[0]; e.Type != audit.EventPermissionDenied || e.Actor != "alice" || e.DocumentID != "room:1" || e.Details["action"] != "delete" {
+		t.Errorf("audit event = %+v", e)
+	}
+}
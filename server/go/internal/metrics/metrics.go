@@ -0,0 +1,381 @@
+// Package metrics is a small registry of counters, gauges and histograms
+// exported in the Prometheus text format. Components record into instruments
+// created from a Registry rather than importing a metrics library, and the
+// server exposes the registry on /metrics.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds named metric families. Safe for concurrent use.
+type Registry struct {
+	mu          sync.Mutex
+	families    map[string]family
+	constLabels string // Formatted labels added to every sample
+}
+
+// family writes one or more metric families in text format
+type family interface {
+	write(w *textWriter)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// register adds f under name. Registering a name twice is a programming
+// error and panics.
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.families[name] = f
+}
+
+// SetConstLabels sets labels added to every sample the registry writes, such
+// as the build version. They must not clash with the families' own labels.
+func (r *Registry) SetConstLabels(labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+
+	r.mu.Lock()
+	r.constLabels = formatLabels(names, values)
+	r.mu.Unlock()
+}
+
+// WriteText writes every family in the Prometheus text exposition format,
+// sorted by name
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]family, len(names))
+	for i, name := range names {
+		families[i] = r.families[name]
+	}
+	tw := &textWriter{Writer: bufio.NewWriter(w), constLabels: r.constLabels}
+	r.mu.Unlock()
+
+	for _, f := range families {
+		f.write(tw)
+	}
+	return tw.Flush()
+}
+
+// Handler serves the registry in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// Counter is a monotonically increasing count
+type Counter struct {
+	v atomic.Uint64
+}
+
+// Add increases the counter by n
+func (c *Counter) Add(n uint64) { c.v.Add(n) }
+
+// Inc increases the counter by one
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Value returns the current count
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// NewCounter registers an unlabelled counter
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// CounterVec is a set of counters partitioned by label values
+type CounterVec struct {
+	vec[*Counter]
+}
+
+// NewCounterVec registers a counter family with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec[*Counter]{
+		name:   name,
+		help:   help,
+		labels: labels,
+		kind:   "counter",
+		create: func() *Counter { return &Counter{} },
+		sample: func(w *textWriter, name, labels string, c *Counter) {
+			writeSample(w, name, labels, float64(c.Value()))
+		},
+		children: make(map[string]*child[*Counter]),
+	}}
+	r.register(name, &v.vec)
+	return v
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	upper []float64
+
+	mu     sync.Mutex
+	counts []uint64 // Per-bucket counts; the last entry is +Inf
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upper: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// Snapshot returns the cumulative count for each bucket, the total count and
+// the sum of observations
+func (h *Histogram) Snapshot() (cumulative []uint64, count uint64, sum float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cumulative = make([]uint64, len(h.upper))
+	var running uint64
+	for i := range cumulative {
+		running += h.counts[i]
+		cumulative[i] = running
+	}
+	return cumulative, h.count, h.sum
+}
+
+// NewHistogram registers an unlabelled histogram with the given bucket upper
+// bounds, which must be sorted
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// HistogramVec is a set of histograms partitioned by label values
+type HistogramVec struct {
+	vec[*Histogram]
+}
+
+// NewHistogramVec registers a histogram family with the given bucket upper
+// bounds, which must be sorted, and label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{vec[*Histogram]{
+		name:   name,
+		help:   help,
+		labels: labels,
+		kind:   "histogram",
+		create: func() *Histogram { return newHistogram(buckets) },
+		sample: func(w *textWriter, name, labels string, h *Histogram) {
+			cumulative, count, sum := h.Snapshot()
+			writeHistogram(w, name, labels, buckets, cumulative, count, sum)
+		},
+		children: make(map[string]*child[*Histogram]),
+	}}
+	r.register(name, &v.vec)
+	return v
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn at scrape time
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, funcFamily(func(w *textWriter) {
+		writeHeader(w, name, help, "gauge")
+		writeSample(w, name, "", fn())
+	}))
+}
+
+// NewCounterFunc registers a counter whose value is read from fn at scrape
+// time, for counts kept elsewhere
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, funcFamily(func(w *textWriter) {
+		writeHeader(w, name, help, "counter")
+		writeSample(w, name, "", fn())
+	}))
+}
+
+// NewHistogramFunc registers a histogram built at scrape time from the
+// values fn returns, for distributions over current state such as
+// connections per IP
+func (r *Registry) NewHistogramFunc(name, help string, buckets []float64, fn func() []float64) {
+	r.register(name, funcFamily(func(w *textWriter) {
+		h := newHistogram(buckets)
+		for _, v := range fn() {
+			h.Observe(v)
+		}
+		cumulative, count, sum := h.Snapshot()
+		writeHeader(w, name, help, "histogram")
+		writeHistogram(w, name, "", buckets, cumulative, count, sum)
+	}))
+}
+
+type funcFamily func(w *textWriter)
+
+func (f funcFamily) write(w *textWriter) { f(w) }
+
+// vec holds the children of a labelled family, keyed by label values
+type vec[T any] struct {
+	name   string
+	help   string
+	labels []string
+	kind   string
+	create func() T
+	sample func(w *textWriter, name, labels string, m T)
+
+	mu       sync.RWMutex
+	children map[string]*child[T]
+}
+
+type child[T any] struct {
+	values []string
+	metric T
+}
+
+// With returns the child for the given label values, creating it on first
+// use. The number of values must match the family's label names.
+func (v *vec[T]) With(values ...string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c.metric
+	}
+	c = &child[T]{values: append([]string(nil), values...), metric: v.create()}
+	v.children[key] = c
+	return c.metric
+}
+
+// Each calls fn for every child with its label values
+func (v *vec[T]) Each(fn func(values []string, m T)) {
+	v.mu.RLock()
+	children := make([]*child[T], 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	v.mu.RUnlock()
+
+	for _, c := range children {
+		fn(c.values, c.metric)
+	}
+}
+
+func (v *vec[T]) write(w *textWriter) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]*child[T], len(keys))
+	for i, key := range keys {
+		children[i] = v.children[key]
+	}
+	v.mu.RUnlock()
+
+	writeHeader(w, v.name, v.help, v.kind)
+	for _, c := range children {
+		v.sample(w, v.name, formatLabels(v.labels, c.values), c.metric)
+	}
+}
+
+// textWriter buffers the text format, adding the registry's constant labels
+// to each sample
+type textWriter struct {
+	*bufio.Writer
+	constLabels string
+}
+
+func writeHeader(w *textWriter, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+func writeSample(w *textWriter, name, labels string, v float64) {
+	if w.constLabels != "" {
+		if labels != "" {
+			labels = w.constLabels + "," + labels
+		} else {
+			labels = w.constLabels
+		}
+	}
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func writeHistogram(w *textWriter, name, labels string, buckets []float64, cumulative []uint64, count uint64, sum float64) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, upper := range buckets {
+		writeSample(w, name+"_bucket", labels+sep+`le="`+formatFloat(upper)+`"`, float64(cumulative[i]))
+	}
+	writeSample(w, name+"_bucket", labels+sep+`le="+Inf"`, float64(count))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
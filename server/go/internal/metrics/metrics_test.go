@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	return b.String()
+}
+
+func expectLines(t *testing.T, text string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, text)
+		}
+	}
+}
+
+func TestRegistry_Counters(t *testing.T) {
+	r := NewRegistry()
+	total := r.NewCounter("test_events_total", "Events seen.")
+	byType := r.NewCounterVec("test_messages_total", "Messages by type.", "type")
+
+	total.Add(3)
+	byType.With("delta").Inc()
+	byType.With("delta").Inc()
+	byType.With(`we"ird`).Inc()
+
+	expectLines(t, scrape(t, r),
+		"# HELP test_events_total Events seen.",
+		"# TYPE test_events_total counter",
+		"test_events_total 3",
+		"# TYPE test_messages_total counter",
+		`test_messages_total{type="delta"} 2`,
+		`test_messages_total{type="we\"ird"} 1`,
+	)
+	if got := byType.With("delta").Value(); got != 2 {
+		t.Errorf("Value = %d, want 2", got)
+	}
+}
+
+func TestRegistry_Histogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("test_seconds", "Latency.", []float64{0.1, 1}, "op")
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		h.With("get").Observe(v)
+	}
+
+	expectLines(t, scrape(t, r),
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{op="get",le="0.1"} 2`,
+		`test_seconds_bucket{op="get",le="1"} 3`,
+		`test_seconds_bucket{op="get",le="+Inf"} 4`,
+		`test_seconds_sum{op="get"} 2.65`,
+		`test_seconds_count{op="get"} 4`,
+	)
+}
+
+func TestRegistry_FuncMetrics(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("test_active", "Active things.", func() float64 { return 7 })
+	r.NewHistogramFunc("test_per_key", "Things per key.", []float64{1, 5}, func() []float64 {
+		return []float64{1, 1, 3, 9}
+	})
+
+	expectLines(t, scrape(t, r),
+		"# TYPE test_active gauge",
+		"test_active 7",
+		`test_per_key_bucket{le="1"} 2`,
+		`test_per_key_bucket{le="5"} 3`,
+		`test_per_key_bucket{le="+Inf"} 4`,
+		"test_per_key_count 4",
+	)
+}
+
+func TestRegistry_ConstLabels(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_events_total", "Events seen.").Inc()
+	r.NewCounterVec("test_messages_total", "Messages by type.", "type").With("delta").Inc()
+	r.NewHistogram("test_seconds", "Latency.", []float64{1}).Observe(0.5)
+	r.SetConstLabels(map[string]string{"version": "1.2.3", "commit": "abc123"})
+
+	expectLines(t, scrape(t, r),
+		`test_events_total{commit="abc123",version="1.2.3"} 1`,
+		`test_messages_total{commit="abc123",version="1.2.3",type="delta"} 1`,
+		`test_seconds_bucket{commit="abc123",version="1.2.3",le="1"} 1`,
+		`test_seconds_count{commit="abc123",version="1.2.3"} 1`,
+	)
+}
+
+func TestRegistry_Runtime(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterRuntime()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	for _, name := range []string{"go_goroutines ", "go_memstats_alloc_bytes ", "go_gc_cycles_total "} {
+		if !strings.Contains(rec.Body.String(), "\n"+name) {
+			t.Errorf("missing %s", name)
+		}
+	}
+}
+
+func TestRegistry_DuplicateNamePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice should panic")
+		}
+	}()
+	r.NewCounter("test_total", "")
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"runtime"
+	"time"
+)
+
+// RegisterRuntime registers Go runtime metrics: goroutines, heap and GC
+// statistics. Memory statistics are read once per scrape.
+func (r *Registry) RegisterRuntime() {
+	r.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	r.register("go_memstats", funcFamily(writeMemStats))
+}
+
+func writeMemStats(w *textWriter) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"go_memstats_alloc_bytes", "Bytes of allocated heap objects.", float64(m.Alloc)},
+		{"go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", float64(m.HeapInuse)},
+		{"go_memstats_heap_objects", "Number of allocated heap objects.", float64(m.HeapObjects)},
+		{"go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", float64(m.Sys)},
+		{"go_memstats_next_gc_bytes", "Heap size target of the next GC cycle.", float64(m.NextGC)},
+	}
+	for _, g := range gauges {
+		writeHeader(w, g.name, g.help, "gauge")
+		writeSample(w, g.name, "", g.value)
+	}
+
+	writeHeader(w, "go_gc_cycles_total", "Number of completed GC cycles.", "counter")
+	writeSample(w, "go_gc_cycles_total", "", float64(m.NumGC))
+	writeHeader(w, "go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", "counter")
+	writeSample(w, "go_gc_pause_seconds_total", "", time.Duration(m.PauseTotalNs).Seconds())
+}
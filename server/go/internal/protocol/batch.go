@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// BATCH carries several messages in one frame. It is an envelope rather
+// than a message type: its payload is the inner messages, each a complete
+// binary message of its own:
+// [count:4] then count times [len:4][message:len bytes]
+const BATCH MessageTypeCode = 0x23
+
+// MaxBatchMessages bounds the messages in one batch
+const MaxBatchMessages = 1000
+
+// batchEntryMin is the smallest a batch entry can be: its length and a
+// message header
+const batchEntryMin = 4 + 13
+
+// BatchItem is one message of a decoded batch, or why it did not decode
+type BatchItem struct {
+	Message *Message
+	Err     error
+}
+
+// IsBatch reports whether data is a batch envelope
+func IsBatch(data []byte) bool {
+	return len(data) >= 13 && MessageTypeCode(data[0]) == BATCH
+}
+
+// EncodeBatch encodes messages, in order, as one batch envelope
+func EncodeBatch(messages []Message, timestamp int64) ([]byte, error) {
+	return EncodeBatchWith(messages, timestamp, EncodeOptions{})
+}
+
+// EncodeBatchWith is EncodeBatch encoding each message with opts
+func EncodeBatchWith(messages []Message, timestamp int64, opts EncodeOptions) ([]byte, error) {
+	if len(messages) > MaxBatchMessages {
+		return nil, fmt.Errorf("batch of %d messages exceeds %d", len(messages), MaxBatchMessages)
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	var header [17]byte
+	header[0] = byte(BATCH)
+	binary.BigEndian.PutUint64(header[1:9], uint64(timestamp))
+	binary.BigEndian.PutUint32(header[13:17], uint32(len(messages)))
+	buf.Write(header[:])
+	for i, msg := range messages {
+		// Each message is preceded by its length, filled in once it is known
+		at := buf.Len()
+		buf.Write(header[:4])
+		if err := EncodeMessageTo(buf, msg.Type, msg.Payload, msg.Timestamp, opts); err != nil {
+			return nil, fmt.Errorf("message %d of batch: %w", i, err)
+		}
+		binary.BigEndian.PutUint32(buf.Bytes()[at:], uint32(buf.Len()-at-4))
+	}
+
+	data := bytes.Clone(buf.Bytes())
+	binary.BigEndian.PutUint32(data[9:13], uint32(len(data)-13))
+	return data, nil
+}
+
+// DecodeBatch decodes a batch envelope into its messages, in order. A
+// message that does not decode is reported in its item and the rest still
+// decode, unless the decoder is Strict. Errors in the envelope itself
+// reject the whole batch.
+func (d Decoder) DecodeBatch(data []byte) ([]BatchItem, error) {
+	if !IsBatch(data) {
+		return nil, errors.New("not a batch")
+	}
+	// The only flag an envelope takes is the server's sequence number
+	payloadLen := binary.BigEndian.Uint32(data[9:13])
+	headerLen := uint32(13)
+	var serverSeq uint64
+	if payloadLen&extendedHeader != 0 {
+		if len(data) < 22 || data[13] != FlagServerSeq {
+			return nil, errors.New("batch envelopes take no flags but FlagServerSeq")
+		}
+		payloadLen &^= extendedHeader
+		headerLen = 22
+		serverSeq = binary.BigEndian.Uint64(data[14:22])
+	}
+	if d.MaxPayloadSize > 0 && uint64(payloadLen) > uint64(d.MaxPayloadSize) {
+		return nil, fmt.Errorf("%w: declared %d bytes, limit %d", ErrPayloadTooLarge, payloadLen, d.MaxPayloadSize)
+	}
+	if uint32(len(data)) < headerLen+payloadLen {
+		return nil, fmt.Errorf("incomplete message: expected %d bytes, got %d", headerLen+payloadLen, len(data))
+	}
+	payload := data[headerLen : headerLen+payloadLen]
+	if len(payload) < 4 {
+		return nil, errors.New("batch has no message count")
+	}
+	count := binary.BigEndian.Uint32(payload)
+	payload = payload[4:]
+	if count > MaxBatchMessages {
+		return nil, fmt.Errorf("batch of %d messages exceeds %d", count, MaxBatchMessages)
+	}
+	if uint64(count)*batchEntryMin > uint64(len(payload)) {
+		return nil, fmt.Errorf("batch of %d messages truncated", count)
+	}
+
+	items := make([]BatchItem, 0, count)
+	for i := 0; i < int(count); i++ {
+		if len(payload) < 4 {
+			return nil, fmt.Errorf("batch truncated at message %d", i)
+		}
+		size := binary.BigEndian.Uint32(payload)
+		if uint64(size) > uint64(len(payload)-4) {
+			return nil, fmt.Errorf("batch truncated at message %d", i)
+		}
+		inner := payload[4 : 4+size]
+		payload = payload[4+size:]
+
+		var item BatchItem
+		if IsBatch(inner) {
+			item.Err = errors.New("batches may not be nested")
+		} else {
+			item.Message, item.Err = d.Decode(inner)
+		}
+		if item.Message != nil && serverSeq != 0 {
+			item.Message.ServerSeq = serverSeq
+		}
+		if item.Err != nil && d.Strict {
+			return nil, fmt.Errorf("message %d of batch: %w", i, item.Err)
+		}
+		items = append(items, item)
+	}
+	if len(payload) != 0 {
+		return nil, fmt.Errorf("batch has %d trailing bytes", len(payload))
+	}
+	return items, nil
+}
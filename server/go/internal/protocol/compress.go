@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// Payload flags, in the flags byte of extended envelopes
+const (
+	FlagDeflate  byte = 0x01 // Payload is raw deflate (RFC 1951) compressed
+	FlagMsgpack  byte = 0x02 // Payload is MessagePack rather than JSON
+	FlagChecksum byte = 0x04 // A CRC32C of the payload follows the flags byte
+	// The server's sequence number for the frame follows the flags byte
+	// and checksum, if any
+	FlagServerSeq byte = 0x08
+)
+
+// extendedHeader is set in the payload length of envelopes whose 13-byte
+// header is followed by a flags byte. Plain envelopes never set it, so
+// decoders that predate flags see them unchanged.
+const extendedHeader uint32 = 1 << 31
+
+// knownFlags are the flags DecodeMessage understands
+const knownFlags = FlagDeflate | FlagMsgpack | FlagChecksum | FlagServerSeq
+
+// MaxInflatedPayload bounds the JSON a compressed payload may inflate to
+const MaxInflatedPayload = 32 << 20
+
+// inflate decompresses a deflate payload, refusing ones that inflate beyond
+// limit bytes
+func inflate(data []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate payload: %w", err)
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("%w: inflates beyond %d bytes", ErrPayloadTooLarge, limit)
+	}
+	return out, nil
+}
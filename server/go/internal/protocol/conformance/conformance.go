@@ -0,0 +1,224 @@
+// Package conformance holds the wire protocol's conformance fixtures, shared
+// with the TypeScript server: golden binary frames with what they decode to,
+// and scripted client sessions with the replies a server must send. The
+// fixtures are the JSON files in fixtures/, which other implementations
+// read as they are; this package loads them and plays them against a server.
+package conformance
+
+import (
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+//go:embed fixtures/frames.json fixtures/scenarios.json
+var fixtures embed.FS
+
+// Frame is a golden binary frame. A frame a decoder must accept decodes to
+// its Type, Timestamp and Payload, and encoding those with its Options gives
+// its Hex back when it is Exact. JSON payload keys are in sorted order, as
+// the Go server writes them.
+type Frame struct {
+	Name string `json:"name"`
+	// Hex is the frame as it travels on the wire
+	Hex string `json:"hex"`
+	// Reject is set for frames a decoder must refuse: "malformed", or
+	// "checksum" for a payload that does not match its checksum
+	Reject string `json:"reject,omitempty"`
+
+	Type      string                 `json:"type,omitempty"`
+	Timestamp int64                  `json:"timestamp,omitempty"`
+	Options   *FrameOptions          `json:"options,omitempty"`
+	Payload   map[string]interface{} `json:"payload,omitempty"`
+	// Messages are the contents of a batch envelope, whose Type is "batch"
+	Messages []Frame `json:"messages,omitempty"`
+}
+
+// FrameOptions are the payload options a frame was encoded with
+type FrameOptions struct {
+	Encoding string `json:"encoding,omitempty"` // "msgpack", or empty for JSON
+	Compress bool   `json:"compress,omitempty"` // Deflate the payload
+	Checksum bool   `json:"checksum,omitempty"` // Add a CRC32C of the payload
+	// ServerSeq is the frame's sequence number, for clients that negotiated
+	// serverSeq (0 for none). Batch envelopes may carry one too.
+	ServerSeq uint64 `json:"serverSeq,omitempty"`
+}
+
+// Exact reports whether encoding the frame must give its Hex back. Deflate
+// output differs between compressors and MessagePack maps have no key
+// order, so frames using either only need to decode.
+func (f Frame) Exact() bool {
+	if o := f.Options; o != nil && (o.Compress || o.Encoding != "") {
+		return false
+	}
+	for _, inner := range f.Messages {
+		if !inner.Exact() {
+			return false
+		}
+	}
+	return true
+}
+
+// BatchType is the Type of batch envelope frames
+const BatchType = "batch"
+
+// Bytes returns the frame's wire bytes
+func (f Frame) Bytes() ([]byte, error) {
+	return hex.DecodeString(f.Hex)
+}
+
+// EncodeOptions returns the protocol options the frame was encoded with;
+// none for a nil FrameOptions
+func (o *FrameOptions) EncodeOptions() protocol.EncodeOptions {
+	if o == nil {
+		return protocol.EncodeOptions{}
+	}
+	opts := protocol.EncodeOptions{Encoding: protocol.Encoding(o.Encoding), Checksum: o.Checksum}
+	if o.Compress {
+		opts.CompressAbove = 1
+	}
+	return opts
+}
+
+// Scenario is a scripted session between clients and a server. Its steps run
+// in order; each either sends a message from a client or expects the next
+// message that client receives. Scenarios assume a fresh server with
+// authentication disabled and the default limits.
+type Scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Steps       []Step `json:"steps"`
+}
+
+// Step is one send or expectation in a scenario
+type Step struct {
+	Client string   `json:"client"`
+	Send   *Message `json:"send,omitempty"`
+	Expect *Message `json:"expect,omitempty"`
+}
+
+// Message is a message sent or expected. An expected payload matches when
+// every field it lists is present with the value it gives, Any matching
+// every value; fields it does not list may hold anything.
+type Message struct {
+	Type    string                 `json:"type"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// Any matches every value of a field that is present
+const Any = "<any>"
+
+// Frames returns the golden frames
+func Frames() ([]Frame, error) {
+	var frames []Frame
+	return frames, load("fixtures/frames.json", &frames)
+}
+
+// Scenarios returns the scripted sessions
+func Scenarios() ([]Scenario, error) {
+	var scenarios []Scenario
+	return scenarios, load("fixtures/scenarios.json", &scenarios)
+}
+
+func load(name string, v interface{}) error {
+	data, err := fixtures.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// Conn is one client's connection to the server under test
+type Conn interface {
+	// Send writes one binary frame
+	Send(data []byte) error
+	// Receive reads the next frame, failing if none arrives in time
+	Receive() ([]byte, error)
+}
+
+// Run plays a scenario, connecting each client with dial when it first
+// appears, and returns the first divergence from the script
+func Run(s Scenario, dial func(client string) (Conn, error)) error {
+	conns := make(map[string]Conn)
+	for i, step := range s.Steps {
+		conn, ok := conns[step.Client]
+		if !ok {
+			var err error
+			if conn, err = dial(step.Client); err != nil {
+				return fmt.Errorf("step %d: connecting %s: %w", i, step.Client, err)
+			}
+			conns[step.Client] = conn
+		}
+
+		switch {
+		case step.Send != nil:
+			data, err := protocol.EncodeMessage(step.Send.Type, step.Send.Payload, time.Now().UnixMilli())
+			if err != nil {
+				return fmt.Errorf("step %d: encoding %s: %w", i, step.Send.Type, err)
+			}
+			if err := conn.Send(data); err != nil {
+				return fmt.Errorf("step %d: %s sending %s: %w", i, step.Client, step.Send.Type, err)
+			}
+
+		case step.Expect != nil:
+			data, err := conn.Receive()
+			if err != nil {
+				return fmt.Errorf("step %d: %s waiting for %s: %w", i, step.Client, step.Expect.Type, err)
+			}
+			msg, err := protocol.DecodeMessage(data)
+			if err != nil {
+				return fmt.Errorf("step %d: %s received a frame that does not decode: %w", i, step.Client, err)
+			}
+			if msg.Type != step.Expect.Type || !Matches(step.Expect.Payload, msg.Payload) {
+				return fmt.Errorf("step %d: %s received %s %v, want %s %v", i, step.Client, msg.Type, msg.Payload, step.Expect.Type, step.Expect.Payload)
+			}
+
+		default:
+			return fmt.Errorf("step %d: neither sends nor expects", i)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether got matches the expected value want: objects
+// match when got has every field of want with a matching value, arrays
+// element by element, and anything else by equality
+func Matches(want, got interface{}) bool {
+	if want == Any {
+		return true
+	}
+	switch want := want.(type) {
+	case map[string]interface{}:
+		got, ok := got.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			field, present := got[key]
+			if !present || !Matches(value, field) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		got, ok := got.([]interface{})
+		if !ok || len(got) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !Matches(want[i], got[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(want, got)
+}
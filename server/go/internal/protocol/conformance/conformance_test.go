@@ -0,0 +1,261 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/websocket"
+)
+
+var update = flag.Bool("update", false, "rewrite the hex of accepted frames in fixtures/frames.json from their decoded fields")
+
+// encodeFrame encodes a frame's decoded fields as this server would
+func encodeFrame(f Frame) ([]byte, error) {
+	data, err := encodeUnstamped(f)
+	if err != nil || f.Options == nil || f.Options.ServerSeq == 0 {
+		return data, err
+	}
+	return protocol.StampServerSeq(data, f.Options.ServerSeq)
+}
+
+// encodeUnstamped encodes a frame without its sequence number
+func encodeUnstamped(f Frame) ([]byte, error) {
+	if f.Type != BatchType {
+		return protocol.EncodeMessageWith(f.Type, f.Payload, f.Timestamp, f.Options.EncodeOptions())
+	}
+
+	// Batches may mix encodings, so they are framed here from their messages
+	payload := binary.BigEndian.AppendUint32(nil, uint32(len(f.Messages)))
+	for _, inner := range f.Messages {
+		data, err := encodeFrame(inner)
+		if err != nil {
+			return nil, err
+		}
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(data)))
+		payload = append(payload, data...)
+	}
+	header := make([]byte, 13, 13+len(payload))
+	header[0] = byte(protocol.BATCH)
+	binary.BigEndian.PutUint64(header[1:9], uint64(f.Timestamp))
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(payload)))
+	return append(header, payload...), nil
+}
+
+// checkDecoded compares a decoded message with a frame's decoded fields
+func checkDecoded(t *testing.T, f Frame, msg *protocol.Message) {
+	t.Helper()
+	if msg.Type != f.Type || msg.Timestamp != f.Timestamp || !reflect.DeepEqual(msg.Payload, f.Payload) {
+		t.Errorf("%s: decoded %s at %d: %v, want %s at %d: %v", f.Name, msg.Type, msg.Timestamp, msg.Payload, f.Type, f.Timestamp, f.Payload)
+	}
+}
+
+// decodeFrame decodes a frame's bytes, reporting any message that fails
+func decodeFrame(f Frame, data []byte) ([]*protocol.Message, error) {
+	if !protocol.IsBatch(data) {
+		msg, err := protocol.DecodeMessage(data)
+		return []*protocol.Message{msg}, err
+	}
+	items, err := protocol.Decoder{}.DecodeBatch(data)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*protocol.Message, len(items))
+	for i, item := range items {
+		if item.Err != nil {
+			return nil, item.Err
+		}
+		messages[i] = item.Message
+	}
+	return messages, nil
+}
+
+func TestFrames(t *testing.T) {
+	frames, err := Frames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		// Frames that need not encode exactly keep the bytes they have
+		for i, f := range frames {
+			if f.Reject == "" && (f.Hex == "" || f.Exact()) {
+				data, err := encodeFrame(f)
+				if err != nil {
+					t.Fatalf("%s: %v", f.Name, err)
+				}
+				frames[i].Hex = hex.EncodeToString(data)
+			}
+		}
+		var out bytes.Buffer
+		enc := json.NewEncoder(&out)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(frames); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile("fixtures/frames.json", out.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, f := range frames {
+		data, err := f.Bytes()
+		if err != nil {
+			t.Errorf("%s: bad hex: %v", f.Name, err)
+			continue
+		}
+		messages, err := decodeFrame(f, data)
+
+		if f.Reject != "" {
+			if err == nil {
+				t.Errorf("%s: decoded, want it rejected", f.Name)
+			} else if f.Reject == "checksum" && !errors.Is(err, protocol.ErrChecksumMismatch) {
+				t.Errorf("%s: error = %v, want a checksum mismatch", f.Name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: does not decode: %v", f.Name, err)
+			continue
+		}
+		if f.Type == BatchType {
+			if len(messages) != len(f.Messages) {
+				t.Errorf("%s: %d messages, want %d", f.Name, len(messages), len(f.Messages))
+				continue
+			}
+			for i, inner := range f.Messages {
+				checkDecoded(t, inner, messages[i])
+			}
+		} else {
+			checkDecoded(t, f, messages[0])
+		}
+
+		var wantSeq uint64
+		if f.Options != nil {
+			wantSeq = f.Options.ServerSeq
+		}
+		for _, msg := range messages {
+			if msg.ServerSeq != wantSeq {
+				t.Errorf("%s: serverSeq = %d, want %d", f.Name, msg.ServerSeq, wantSeq)
+			}
+		}
+
+		if f.Options != nil && f.Options.Compress && data[13]&protocol.FlagDeflate == 0 {
+			t.Errorf("%s: not flagged as deflated", f.Name)
+		}
+		if !f.Exact() {
+			continue
+		}
+		encoded, err := encodeFrame(f)
+		if err != nil {
+			t.Errorf("%s: does not encode: %v", f.Name, err)
+		} else if !bytes.Equal(encoded, data) {
+			t.Errorf("%s: encodes to\n%x\nwant\n%s", f.Name, encoded, f.Hex)
+		}
+	}
+}
+
+func TestFramesCoverEveryMessageType(t *testing.T) {
+	frames, err := Frames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	covered := map[string]bool{}
+	for _, f := range frames {
+		if f.Reject == "" {
+			covered[f.Type] = true
+		}
+	}
+	for _, name := range protocol.TypeNames() {
+		if !covered[name] {
+			t.Errorf("no frame of type %s", name)
+		}
+	}
+	if !covered[BatchType] {
+		t.Error("no batch frame")
+	}
+}
+
+// wsConn is a client of the in-process server
+type wsConn struct {
+	ws *gorilla.Conn
+}
+
+func (c wsConn) Send(data []byte) error {
+	return c.ws.WriteMessage(gorilla.BinaryMessage, data)
+}
+
+func (c wsConn) Receive() ([]byte, error) {
+	c.ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := c.ws.ReadMessage()
+	return data, err
+}
+
+// newServer starts a hub behind a websocket endpoint, as the server wires
+// them, with authentication disabled
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	hub := websocket.NewHubWithOptions(websocket.AuthConfig{}, websocket.HubOptions{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	go hub.Run()
+
+	upgrader := gorilla.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := websocket.NewConnection(r.RemoteAddr, ws, hub)
+		hub.Register <- conn
+		go conn.WritePump()
+		go conn.ReadPump()
+	}))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		hub.Stop(ctx)
+		ts.Close()
+	})
+	return ts
+}
+
+func TestScenarios(t *testing.T) {
+	scenarios, err := Scenarios()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range scenarios {
+		t.Run(s.Name, func(t *testing.T) {
+			ts := newServer(t)
+			url := "ws" + strings.TrimPrefix(ts.URL, "http")
+			err := Run(s, func(client string) (Conn, error) {
+				ws, _, err := gorilla.DefaultDialer.Dial(url, nil)
+				if err != nil {
+					return nil, err
+				}
+				t.Cleanup(func() { ws.Close() })
+				return wsConn{ws}, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
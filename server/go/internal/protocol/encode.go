@@ -0,0 +1,160 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+)
+
+// castagnoli is the CRC32C table envelope checksums use
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// maxPooledBuffer is the largest buffer returned to bufferPool, so one huge
+// message does not pin its memory for good
+const maxPooledBuffer = 1 << 20
+
+// bufferPool holds the scratch buffers messages are encoded into
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// flateWriters holds deflate compressors, which are expensive to create
+var flateWriters = sync.Pool{New: func() interface{} {
+	w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return w
+}}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// EncodeMessageTo appends a message encoded with opts to buf, in the format
+// EncodeMessageWith returns
+func EncodeMessageTo(buf *bytes.Buffer, messageType string, payload map[string]interface{}, timestamp int64, opts EncodeOptions) error {
+	return encodeMessageTo(buf, messageType, timestamp, opts, func(dst []byte) ([]byte, error) {
+		if opts.Encoding == EncodingMsgpack {
+			return appendMsgpack(dst, payload)
+		}
+		return appendJSON(dst, payload)
+	})
+}
+
+// EncodePatchedMessageTo appends a message whose payload is raw, the JSON
+// object a client's payload was decoded from, with the members named in set
+// or drop removed and those in set added. It copies raw rather than encoding
+// the whole payload again. It reports false, appending nothing, for clients
+// taking MessagePack and for payloads it cannot splice, which are then
+// encoded with EncodeMessageTo instead.
+func EncodePatchedMessageTo(buf *bytes.Buffer, messageType string, raw []byte, set map[string]interface{}, drop []string, timestamp int64, opts EncodeOptions) (bool, error) {
+	if opts.Encoding == EncodingMsgpack {
+		return false, nil
+	}
+	err := encodeMessageTo(buf, messageType, timestamp, opts, func(dst []byte) ([]byte, error) {
+		encoded, ok, err := appendPatchedJSON(dst, raw, set, drop)
+		if err == nil && !ok {
+			err = errUnspliceable
+		}
+		return encoded, err
+	})
+	if errors.Is(err, errUnspliceable) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// errUnspliceable stops EncodePatchedMessageTo for a payload it must encode
+// in full
+var errUnspliceable = errors.New("payload cannot be spliced")
+
+// encodeMessageTo appends a message to buf with the payload appendPayload
+// writes in opts.Encoding
+func encodeMessageTo(buf *bytes.Buffer, messageType string, timestamp int64, opts EncodeOptions, appendPayload func([]byte) ([]byte, error)) error {
+	typeCode, ok := typeNameToCode[messageType]
+	if !ok {
+		typeCode = ERROR
+	}
+
+	// Reserve the header; the flags byte only when the payload may need one
+	start := buf.Len()
+	headerLen := 13
+	var flags byte
+	if opts.Checksum {
+		flags |= FlagChecksum
+		headerLen = 18
+	} else if opts.Encoding == EncodingMsgpack || opts.CompressAbove > 0 {
+		headerLen = 14
+	}
+	var header [18]byte
+	buf.Write(header[:headerLen])
+
+	if opts.Encoding == EncodingMsgpack {
+		flags |= FlagMsgpack
+	}
+	encoded, err := appendPayload(buf.AvailableBuffer())
+	if err != nil {
+		buf.Truncate(start)
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	buf.Write(encoded)
+
+	payloadStart := start + headerLen
+	if opts.CompressAbove > 0 && buf.Len()-payloadStart > opts.CompressAbove {
+		compressed := getBuffer()
+		defer putBuffer(compressed)
+		if err := deflateTo(compressed, buf.Bytes()[payloadStart:]); err != nil {
+			buf.Truncate(start)
+			return fmt.Errorf("failed to compress payload: %w", err)
+		}
+		// Incompressible payloads go out as they are
+		if compressed.Len() < buf.Len()-payloadStart {
+			flags |= FlagDeflate
+			buf.Truncate(payloadStart)
+			buf.Write(compressed.Bytes())
+		}
+	}
+
+	// A plain payload takes the 13-byte header, moving it up a byte if a
+	// flags byte was reserved
+	data := buf.Bytes()[start:]
+	if flags == 0 && headerLen == 14 {
+		copy(data[13:], data[14:])
+		buf.Truncate(buf.Len() - 1)
+		data = data[:len(data)-1]
+		headerLen = 13
+	}
+
+	payloadLen := uint32(len(data) - headerLen)
+	data[0] = byte(typeCode)
+	binary.BigEndian.PutUint64(data[1:9], uint64(timestamp))
+	if flags != 0 {
+		binary.BigEndian.PutUint32(data[9:13], payloadLen|extendedHeader)
+		data[13] = flags
+		if flags&FlagChecksum != 0 {
+			binary.BigEndian.PutUint32(data[14:18], crc32.Checksum(data[18:], castagnoli))
+		}
+	} else {
+		binary.BigEndian.PutUint32(data[9:13], payloadLen)
+	}
+	return nil
+}
+
+// deflateTo compresses data into dst at the default level
+func deflateTo(dst *bytes.Buffer, data []byte) error {
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(dst)
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
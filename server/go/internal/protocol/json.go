@@ -0,0 +1,331 @@
+package protocol
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
+)
+
+// appendJSON appends the JSON encoding of v to buf, byte for byte what
+// json.Marshal produces. Payloads are maps, slices, strings and numbers,
+// which it writes directly rather than by reflection; anything else goes
+// through the codec in use, and strings needing escapes beyond the common
+// ones through encoding/json.
+func appendJSON(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "null"...), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case string:
+		return appendJSONString(buf, v)
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int32:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case uint32:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(buf, v, 10), nil
+	case float64:
+		return appendJSONFloat(buf, v)
+	case map[string]interface{}:
+		if v == nil {
+			return append(buf, "null"...), nil
+		}
+		// encoding/json writes map keys in order
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		buf = append(buf, '{')
+		for i, key := range keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			var err error
+			if buf, err = appendJSONString(buf, key); err != nil {
+				return nil, err
+			}
+			buf = append(buf, ':')
+			if buf, err = appendJSON(buf, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, '}'), nil
+	case []interface{}:
+		if v == nil {
+			return append(buf, "null"...), nil
+		}
+		buf = append(buf, '[')
+		for i, value := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			var err error
+			if buf, err = appendJSON(buf, value); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	case []string:
+		if v == nil {
+			return append(buf, "null"...), nil
+		}
+		buf = append(buf, '[')
+		for i, value := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			var err error
+			if buf, err = appendJSONString(buf, value); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	}
+
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, data...), nil
+}
+
+// jsonPlain marks the ASCII characters a JSON string holds unescaped
+var jsonPlain = func() (plain [utf8.RuneSelf]bool) {
+	for c := ' '; c < utf8.RuneSelf; c++ {
+		plain[c] = c != '"' && c != '\\' && c != '<' && c != '>' && c != '&'
+	}
+	return plain
+}()
+
+// appendJSONString writes text with quotes, backslashes and the common
+// whitespace escaped. Control characters, HTML characters, line separators
+// and invalid UTF-8 are left to encoding/json, whose escaping of them has
+// varied between Go versions.
+func appendJSONString(buf []byte, s string) ([]byte, error) {
+	open := len(buf)
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf && jsonPlain[c] {
+			i++
+			continue
+		}
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if (r == utf8.RuneError && size == 1) || r == '\u2028' || r == '\u2029' {
+				return appendJSONStringSlow(buf[:open], s)
+			}
+			i += size
+			continue
+		}
+		var escape byte
+		switch {
+		case c == '"' || c == '\\':
+			escape = c
+		case c == '\n':
+			escape = 'n'
+		case c == '\r':
+			escape = 'r'
+		case c == '\t':
+			escape = 't'
+		default:
+			return appendJSONStringSlow(buf[:open], s)
+		}
+		buf = append(buf, s[start:i]...)
+		buf = append(buf, '\\', escape)
+		i++
+		start = i
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"'), nil
+}
+
+func appendJSONStringSlow(buf []byte, s string) ([]byte, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, data...), nil
+}
+
+// appendJSONFloat formats f as encoding/json does: like ES6, in exponent
+// form only for very large and very small magnitudes
+func appendJSONFloat(buf []byte, f float64) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, &json.UnsupportedValueError{Str: strconv.FormatFloat(f, 'g', -1, 64)}
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	buf = strconv.AppendFloat(buf, f, format, -1, 64)
+	if format == 'e' {
+		// Clean up e-09 to e-9
+		n := len(buf)
+		if n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+	return buf, nil
+}
+
+// appendPatchedJSON appends raw, a valid JSON object, with the members named
+// in set or drop removed and those in set appended in key order. Kept
+// members are copied as they are. It reports false, having appended
+// nothing, when raw is not an object or has a key with escapes, which might
+// spell one of the names.
+func appendPatchedJSON(buf, raw []byte, set map[string]interface{}, drop []string) ([]byte, bool, error) {
+	start := len(buf)
+	i := skipJSONSpace(raw, 0)
+	if i == len(raw) || raw[i] != '{' {
+		return buf, false, nil
+	}
+	buf = append(buf, '{')
+	members := 0
+	for i++; ; {
+		i = skipJSONSpace(raw, i)
+		if i == len(raw) {
+			return buf[:start], false, nil
+		}
+		switch raw[i] {
+		case '}':
+			return appendJSONMembers(buf, start, members, set, drop)
+		case ',':
+			i++
+			continue
+		case '"':
+		default:
+			return buf[:start], false, nil
+		}
+
+		member := i
+		end := i + 1
+		for end < len(raw) && raw[end] != '"' {
+			if raw[end] == '\\' {
+				return buf[:start], false, nil
+			}
+			end++
+		}
+		if end == len(raw) {
+			return buf[:start], false, nil
+		}
+		key := raw[i+1 : end]
+		i = skipJSONSpace(raw, end+1)
+		if i == len(raw) || raw[i] != ':' {
+			return buf[:start], false, nil
+		}
+		var ok bool
+		if i, ok = skipJSONValue(raw, skipJSONSpace(raw, i+1)); !ok {
+			return buf[:start], false, nil
+		}
+		if _, replaced := set[string(key)]; replaced || slices.Contains(drop, string(key)) {
+			continue
+		}
+		if members > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, raw[member:i]...)
+		members++
+	}
+}
+
+// appendJSONMembers finishes the object appendPatchedJSON began at start
+// with set's members, skipping any also in drop
+func appendJSONMembers(buf []byte, start, members int, set map[string]interface{}, drop []string) ([]byte, bool, error) {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		if !slices.Contains(drop, key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if members > 0 {
+			buf = append(buf, ',')
+		}
+		var err error
+		if buf, err = appendJSONString(buf, key); err != nil {
+			return buf[:start], false, err
+		}
+		buf = append(buf, ':')
+		if buf, err = appendJSON(buf, set[key]); err != nil {
+			return buf[:start], false, err
+		}
+		members++
+	}
+	return append(buf, '}'), true, nil
+}
+
+// skipJSONSpace returns the index of the first non-whitespace byte of data
+// from i, or len(data)
+func skipJSONSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipJSONValue returns the index just past the JSON value starting at i,
+// reporting false if data ends first
+func skipJSONValue(data []byte, i int) (int, bool) {
+	start, depth := i, 0
+	for ; i < len(data); i++ {
+		switch data[i] {
+		case '"':
+			end, ok := skipJSONString(data, i)
+			if !ok {
+				return end, false
+			}
+			if depth == 0 {
+				return end, true
+			}
+			i = end - 1
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				// Ends the object holding a number or literal
+				return i, i > start
+			}
+			depth--
+			if depth == 0 {
+				return i + 1, true
+			}
+		case ',', ' ', '\t', '\n', '\r':
+			if depth == 0 {
+				return i, i > start
+			}
+		}
+	}
+	return i, false
+}
+
+// skipJSONString returns the index just past the string starting at i
+func skipJSONString(data []byte, i int) (int, bool) {
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, true
+		}
+	}
+	return i, false
+}
@@ -0,0 +1,47 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// NewID returns a fresh random ID for a message, connection or server
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewMessage builds a message the server originates. payload becomes the
+// message's payload, with "type" and a fresh "id" set, and "timestamp" set
+// to now unless it carries one, as forwarded deltas do. An "origin" it
+// carries is dropped, since only WithOrigin may set it.
+func NewMessage(messageType string, payload map[string]interface{}) *Message {
+	if payload == nil {
+		payload = make(map[string]interface{}, 3)
+	}
+	msg := &Message{
+		Type:      messageType,
+		ID:        NewID(),
+		Timestamp: time.Now().UnixMilli(),
+		Payload:   payload,
+	}
+	payload["type"] = messageType
+	payload["id"] = msg.ID
+	if _, ok := payload["timestamp"]; !ok {
+		payload["timestamp"] = msg.Timestamp
+	}
+	delete(payload, "origin")
+	return msg
+}
+
+// WithOrigin records id, the ID of the client message that m answers or
+// forwards, as m's origin. An empty id leaves m without one.
+func (m *Message) WithOrigin(id string) *Message {
+	if id != "" {
+		m.Origin = id
+		m.Payload["origin"] = id
+	}
+	return m
+}
@@ -0,0 +1,365 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
+)
+
+// Encoding is a payload codec a client may choose at auth
+type Encoding string
+
+const (
+	EncodingJSON    Encoding = "json"
+	EncodingMsgpack Encoding = "msgpack"
+)
+
+// maxMsgpackDepth bounds the nesting of decoded MessagePack payloads
+const maxMsgpackDepth = 10000
+
+var errMsgpackTruncated = errors.New("msgpack payload truncated")
+
+// marshalMsgpack encodes a payload as MessagePack. Values are limited to
+// what JSON can carry, so a payload decodes to the same values whichever
+// codec carried it; types without a fast path go through their JSON form.
+func marshalMsgpack(payload map[string]interface{}) ([]byte, error) {
+	buf := make([]byte, 0, 256)
+	return appendMsgpack(buf, payload)
+}
+
+func appendMsgpack(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case string:
+		return appendMsgpackString(buf, v), nil
+	case int:
+		return appendMsgpackInt(buf, int64(v)), nil
+	case int32:
+		return appendMsgpackInt(buf, int64(v)), nil
+	case int64:
+		return appendMsgpackInt(buf, v), nil
+	case uint32:
+		return appendMsgpackInt(buf, int64(v)), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			buf = append(buf, 0xcf)
+			return binary.BigEndian.AppendUint64(buf, v), nil
+		}
+		return appendMsgpackInt(buf, int64(v)), nil
+	case float32:
+		return appendMsgpackFloat(buf, float64(v)), nil
+	case float64:
+		return appendMsgpackFloat(buf, v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(buf, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendMsgpackFloat(buf, f), nil
+	case map[string]interface{}:
+		buf = appendMsgpackHeader(buf, 0x80, 0xde, len(v))
+		for key, value := range v {
+			buf = appendMsgpackString(buf, key)
+			var err error
+			if buf, err = appendMsgpack(buf, value); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case []interface{}:
+		buf = appendMsgpackHeader(buf, 0x90, 0xdc, len(v))
+		for _, value := range v {
+			var err error
+			if buf, err = appendMsgpack(buf, value); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case []string:
+		buf = appendMsgpackHeader(buf, 0x90, 0xdc, len(v))
+		for _, value := range v {
+			buf = appendMsgpackString(buf, value)
+		}
+		return buf, nil
+	case map[string]string:
+		buf = appendMsgpackHeader(buf, 0x80, 0xde, len(v))
+		for key, value := range v {
+			buf = appendMsgpackString(buf, key)
+			buf = appendMsgpackString(buf, value)
+		}
+		return buf, nil
+	}
+
+	// Structs, typed slices and the like take the shape they have in JSON
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := codec.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(buf, generic)
+}
+
+// appendMsgpackHeader appends a map or array header for n entries: the fix
+// form below 16, else the 16 or 32-bit form following wide
+func appendMsgpackHeader(buf []byte, fix, wide byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, wide), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, wide+1), uint32(n))
+	}
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func appendMsgpackInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		return append(buf, byte(i))
+	case i < 0 && i >= -32:
+		return append(buf, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(buf, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i))
+	}
+}
+
+// appendMsgpackFloat writes whole numbers JavaScript holds exactly as
+// integers, which are smaller, and the rest as float 64
+func appendMsgpackFloat(buf []byte, f float64) []byte {
+	if f == math.Trunc(f) && math.Abs(f) <= 1<<53 && !(f == 0 && math.Signbit(f)) {
+		return appendMsgpackInt(buf, int64(f))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f))
+}
+
+// unmarshalMsgpack decodes a MessagePack payload into the values
+// json.Unmarshal produces: maps with string keys, []interface{}, float64
+// numbers, strings, bools and nil. Binary and extension types have no JSON
+// equivalent and are rejected.
+func unmarshalMsgpack(data []byte) (map[string]interface{}, error) {
+	d := msgpackDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("msgpack payload has %d trailing bytes", len(d.data)-d.pos)
+	}
+	payload, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("msgpack payload is not a map")
+	}
+	return payload, nil
+}
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// next consumes n bytes
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// length reads a size of n bytes
+func (d *msgpackDecoder) length(n int) (int, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	default:
+		return int(binary.BigEndian.Uint32(b)), nil
+	}
+}
+
+func (d *msgpackDecoder) value(depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("msgpack payload nested too deeply")
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	switch c := b[0]; {
+	case c <= 0x7f:
+		return float64(c), nil
+	case c >= 0xe0:
+		return float64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.arrayOf(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.stringOf(int(c & 0x1f))
+	}
+
+	switch c := b[0]; c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return finite(float64(math.Float32frombits(binary.BigEndian.Uint32(b))))
+	case 0xcb:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return finite(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := d.next(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		var u uint64
+		for _, x := range b {
+			u = u<<8 | uint64(x)
+		}
+		return float64(u), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+		var u uint64
+		for _, x := range b {
+			u = u<<8 | uint64(x)
+		}
+		// Sign-extend from the encoded width
+		shift := 64 - 8*size
+		return float64(int64(u<<shift) >> shift), nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.stringOf(n)
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(n, depth)
+	default:
+		return nil, fmt.Errorf("unsupported msgpack type %#x", c)
+	}
+}
+
+// finite refuses the floats JSON has no way to write
+func finite(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("msgpack float %v has no JSON equivalent", f)
+	}
+	return f, nil
+}
+
+func (d *msgpackDecoder) stringOf(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(b) {
+		return nil, errors.New("msgpack string is not valid UTF-8")
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) arrayOf(n, depth int) (interface{}, error) {
+	// Every element takes at least a byte, which bounds the allocation
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	arr := make([]interface{}, n)
+	for i := range arr {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) mapOf(n, depth int) (interface{}, error) {
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, errMsgpackTruncated
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errors.New("msgpack map key is not a string")
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
@@ -0,0 +1,221 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// AuthPayload is the payload of an auth message. A client sends a token,
+// an API key or neither; UserID names anonymous clients when auth is off.
+type AuthPayload struct {
+	Token    string `json:"token"`
+	APIKey   string `json:"apiKey"`
+	UserID   string `json:"userId"`
+	ClientID string `json:"clientId"`
+
+	// TenantID lets an admin token act within a tenant, as a token with
+	// that tenantId claim would
+	TenantID string `json:"tenantId"`
+
+	// ProtocolVersion and Capabilities are left loose: an unsupported
+	// version is refused as such, and unknown capabilities are ignored
+	ProtocolVersion interface{}   `json:"protocolVersion"`
+	Capabilities    []interface{} `json:"capabilities"`
+
+	// Opt-ins from before capability negotiation
+	Compression string `json:"compression"`
+	Encoding    string `json:"encoding"`
+}
+
+// SubscribePayload is the payload of a subscribe message
+type SubscribePayload struct {
+	DocID string `json:"docId"`
+	// Mode is "write" (the default) or "read"
+	Mode string `json:"mode"`
+	// ResumeFrom maps document IDs to the last sequence number the client saw
+	ResumeFrom map[string]float64 `json:"resumeFrom"`
+}
+
+// Validate reports missing fields
+func (p *SubscribePayload) Validate() error {
+	return requireField("docId", p.DocID)
+}
+
+// DocumentPayload is the payload of messages that only name a document:
+// unsubscribe and awareness_subscribe
+type DocumentPayload struct {
+	DocID string `json:"docId"`
+}
+
+// Validate reports missing fields
+func (p *DocumentPayload) Validate() error {
+	return requireField("docId", p.DocID)
+}
+
+// PrefixPayload is the payload of subscribe_prefix and unsubscribe_prefix
+type PrefixPayload struct {
+	Prefix string `json:"prefix"`
+}
+
+// Validate reports missing fields
+func (p *PrefixPayload) Validate() error {
+	return requireField("prefix", p.Prefix)
+}
+
+// ListPayload is the payload of subscribe_list and unsubscribe_list. An
+// empty prefix lists every document.
+type ListPayload struct {
+	Prefix string `json:"prefix"`
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit"`
+}
+
+// DeltaPayload is the part of a delta message the server reads before
+// applying it. The delta itself, clock and all, is applied and broadcast
+// from Message.Payload as the client sent it.
+type DeltaPayload struct {
+	DocID   string                 `json:"docId"`
+	Changes map[string]interface{} `json:"changes"`
+}
+
+// Validate reports missing fields
+func (p *DeltaPayload) Validate() error {
+	return requireField("docId", p.DocID)
+}
+
+// DeltaBatchPayload is the payload of a delta_batch message. Deltas that are
+// not objects are rejected one by one rather than failing the batch.
+//
+// A client flushing deltas it queued offline names the flush with FlushID
+// and numbers its deltas from 0 across however many batches it takes: the
+// first delta of each batch is number DeltaIndex. The server keeps a
+// watermark per client and document, the highest number up to which it has
+// handled every delta of the flush, applied or rejected. Its ACK reports it
+// as "watermark" (-1 before any) along with "flushId". Delivery is then
+// at-least-once from the client and exactly-once in effect:
+//
+//   - A delta at or below the watermark was handled before and is skipped,
+//     with status "duplicate". Re-sending a batch whose ACK was lost, or
+//     the whole flush after a reconnect, changes nothing.
+//   - A delta beyond watermark+1 would skip deltas the server never saw,
+//     so it is rejected with reason "out_of_order" and the watermark stays.
+//     The client re-sends from watermark+1.
+//   - A new FlushID starts a new flush, forgetting the last one's
+//     watermark. A client has one flush per document at a time.
+//
+// With storage configured the watermark is saved once the document state
+// covering it is, so it survives reconnecting to another server or a
+// restart. Batches without a FlushID are applied as they come.
+type DeltaBatchPayload struct {
+	DocID  string        `json:"docId"`
+	Deltas []interface{} `json:"deltas"`
+
+	FlushID    string `json:"flushId"`
+	DeltaIndex int64  `json:"deltaIndex"`
+}
+
+// MaxFlushIDLength bounds DeltaBatchPayload.FlushID
+const MaxFlushIDLength = 128
+
+// Validate reports missing fields
+func (p *DeltaBatchPayload) Validate() error {
+	if err := requireField("docId", p.DocID); err != nil {
+		return err
+	}
+	if p.Deltas == nil {
+		return &PayloadError{Field: "deltas"}
+	}
+	if len(p.FlushID) > MaxFlushIDLength {
+		return &PayloadError{Field: "flushId", Problem: fmt.Sprintf("longer than %d bytes", MaxFlushIDLength)}
+	}
+	if p.DeltaIndex < 0 || (p.DeltaIndex > 0 && p.FlushID == "") {
+		return &PayloadError{Field: "deltaIndex", Problem: "must be 0 or more, with a flushId"}
+	}
+	return nil
+}
+
+// AwarenessPayload is the payload of an awareness_update message
+type AwarenessPayload struct {
+	DocID string                 `json:"docId"`
+	State map[string]interface{} `json:"state"`
+}
+
+// Validate reports missing fields
+func (p *AwarenessPayload) Validate() error {
+	if err := requireField("docId", p.DocID); err != nil {
+		return err
+	}
+	if p.State == nil {
+		return &PayloadError{Field: "state"}
+	}
+	return nil
+}
+
+// PayloadError is a payload field that is missing or has the wrong type
+type PayloadError struct {
+	Field string
+	// Problem is empty for a missing field
+	Problem string
+}
+
+func (e *PayloadError) Error() string {
+	if e.Problem == "" {
+		return "missing " + e.Field
+	}
+	return "invalid " + e.Field + ": " + e.Problem
+}
+
+func requireField(name, value string) error {
+	if value == "" {
+		return &PayloadError{Field: name}
+	}
+	return nil
+}
+
+// UnmarshalPayload decodes a message's payload into v, a pointer to one of
+// the payload structs, and validates it if v has a Validate method. Decoded
+// JSON payloads are read from the bytes they arrived as; MessagePack
+// payloads and messages built in code go through their Payload map. Fields
+// of the wrong type are reported as a *PayloadError.
+func UnmarshalPayload(msg *Message, v interface{}) error {
+	raw := msg.raw
+	if raw == nil {
+		var err error
+		if raw, err = appendJSON(nil, msg.Payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return &PayloadError{Field: typeErr.Field, Problem: "must be " + describeType(typeErr.Type)}
+		}
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	if validator, ok := v.(interface{ Validate() error }); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// describeType names a Go type as the JSON it decodes from
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return t.String()
+}
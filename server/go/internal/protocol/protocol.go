@@ -1,33 +1,51 @@
 package protocol
 
 import (
+	"bytes"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"math"
+	"sort"
+
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
 )
 
 // MessageTypeCode represents binary message type codes (must match SDK client exactly)
 type MessageTypeCode byte
 
 const (
-	AUTH              MessageTypeCode = 0x01
-	AUTH_SUCCESS      MessageTypeCode = 0x02
-	AUTH_ERROR        MessageTypeCode = 0x03
-	SUBSCRIBE         MessageTypeCode = 0x10
-	UNSUBSCRIBE       MessageTypeCode = 0x11
-	SYNC_REQUEST      MessageTypeCode = 0x12
-	SYNC_RESPONSE     MessageTypeCode = 0x13
-	SYNC_STEP1        MessageTypeCode = 0x14
-	SYNC_STEP2        MessageTypeCode = 0x15
-	DELTA             MessageTypeCode = 0x20
-	ACK               MessageTypeCode = 0x21
-	DELTA_BATCH       MessageTypeCode = 0x22
-	PING              MessageTypeCode = 0x30
-	PONG              MessageTypeCode = 0x31
-	AWARENESS_UPDATE  MessageTypeCode = 0x40
+	AUTH                MessageTypeCode = 0x01
+	AUTH_SUCCESS        MessageTypeCode = 0x02
+	AUTH_ERROR          MessageTypeCode = 0x03
+	TOKEN_EXPIRING      MessageTypeCode = 0x04
+	SUBSCRIBE           MessageTypeCode = 0x10
+	UNSUBSCRIBE         MessageTypeCode = 0x11
+	SYNC_REQUEST        MessageTypeCode = 0x12
+	SYNC_RESPONSE       MessageTypeCode = 0x13
+	SYNC_STEP1          MessageTypeCode = 0x14
+	SYNC_STEP2          MessageTypeCode = 0x15
+	SUBSCRIBE_PREFIX    MessageTypeCode = 0x16
+	UNSUBSCRIBE_PREFIX  MessageTypeCode = 0x17
+	PREFIX_DOCUMENTS    MessageTypeCode = 0x18
+	SUBSCRIBE_LIST      MessageTypeCode = 0x19
+	UNSUBSCRIBE_LIST    MessageTypeCode = 0x1A
+	DOCUMENT_LIST       MessageTypeCode = 0x1B
+	LIST_CHANGED        MessageTypeCode = 0x1C
+	SYNC_REQUIRED       MessageTypeCode = 0x1D
+	DELTA               MessageTypeCode = 0x20
+	ACK                 MessageTypeCode = 0x21
+	DELTA_BATCH         MessageTypeCode = 0x22
+	PING                MessageTypeCode = 0x30
+	PONG                MessageTypeCode = 0x31
+	HEARTBEAT           MessageTypeCode = 0x32
+	AWARENESS_UPDATE    MessageTypeCode = 0x40
 	AWARENESS_SUBSCRIBE MessageTypeCode = 0x41
-	AWARENESS_STATE   MessageTypeCode = 0x42
-	ERROR             MessageTypeCode = 0xFF
+	AWARENESS_STATE     MessageTypeCode = 0x42
+	SERVER_SHUTDOWN     MessageTypeCode = 0x50
+	MAINTENANCE         MessageTypeCode = 0x51
+	ERROR               MessageTypeCode = 0xFF
 )
 
 // MessageType represents string message type names
@@ -36,70 +54,140 @@ const (
 	TypeDisconnect = "disconnect"
 	TypePing       = "ping"
 	TypePong       = "pong"
-
-	TypeAuth        = "auth"
-	TypeAuthSuccess = "auth_success"
-	TypeAuthError   = "auth_error"
-
-	TypeSubscribe    = "subscribe"
-	TypeUnsubscribe  = "unsubscribe"
-	TypeSyncRequest  = "sync_request"
-	TypeSyncResponse = "sync_response"
-	TypeSyncStep1    = "sync_step1"
-	TypeSyncStep2    = "sync_step2"
-	TypeDelta        = "delta"
-	TypeDeltaBatch   = "delta_batch"
-	TypeAck          = "ack"
+	TypeHeartbeat  = "heartbeat"
+
+	TypeAuth          = "auth"
+	TypeAuthSuccess   = "auth_success"
+	TypeAuthError     = "auth_error"
+	TypeTokenExpiring = "token_expiring"
+
+	TypeSubscribe         = "subscribe"
+	TypeUnsubscribe       = "unsubscribe"
+	TypeSyncRequest       = "sync_request"
+	TypeSyncResponse      = "sync_response"
+	TypeSyncStep1         = "sync_step1"
+	TypeSyncStep2         = "sync_step2"
+	TypeSubscribePrefix   = "subscribe_prefix"
+	TypeUnsubscribePrefix = "unsubscribe_prefix"
+	TypePrefixDocuments   = "prefix_documents"
+	TypeSubscribeList     = "subscribe_list"
+	TypeUnsubscribeList   = "unsubscribe_list"
+	TypeDocumentList      = "document_list"
+	TypeListChanged       = "list_changed"
+	TypeSyncRequired      = "sync_required"
+	TypeDelta             = "delta"
+	TypeDeltaBatch        = "delta_batch"
+	TypeAck               = "ack"
 
 	TypeAwarenessUpdate    = "awareness_update"
 	TypeAwarenessSubscribe = "awareness_subscribe"
 	TypeAwarenessState     = "awareness_state"
 
+	TypeServerShutdown = "server_shutdown"
+	TypeMaintenance    = "maintenance"
+
 	TypeError = "error"
 )
 
 // Map type codes to type names
 var typeCodeToName = map[MessageTypeCode]string{
-	AUTH:              TypeAuth,
-	AUTH_SUCCESS:      TypeAuthSuccess,
-	AUTH_ERROR:        TypeAuthError,
-	SUBSCRIBE:         TypeSubscribe,
-	UNSUBSCRIBE:       TypeUnsubscribe,
-	SYNC_REQUEST:      TypeSyncRequest,
-	SYNC_RESPONSE:     TypeSyncResponse,
-	SYNC_STEP1:        TypeSyncStep1,
-	SYNC_STEP2:        TypeSyncStep2,
-	DELTA:             TypeDelta,
-	ACK:               TypeAck,
-	DELTA_BATCH:       TypeDeltaBatch,
-	PING:              TypePing,
-	PONG:              TypePong,
-	AWARENESS_UPDATE:  TypeAwarenessUpdate,
+	AUTH:                TypeAuth,
+	AUTH_SUCCESS:        TypeAuthSuccess,
+	AUTH_ERROR:          TypeAuthError,
+	TOKEN_EXPIRING:      TypeTokenExpiring,
+	SUBSCRIBE:           TypeSubscribe,
+	UNSUBSCRIBE:         TypeUnsubscribe,
+	SYNC_REQUEST:        TypeSyncRequest,
+	SYNC_RESPONSE:       TypeSyncResponse,
+	SYNC_STEP1:          TypeSyncStep1,
+	SYNC_STEP2:          TypeSyncStep2,
+	SUBSCRIBE_PREFIX:    TypeSubscribePrefix,
+	UNSUBSCRIBE_PREFIX:  TypeUnsubscribePrefix,
+	PREFIX_DOCUMENTS:    TypePrefixDocuments,
+	SUBSCRIBE_LIST:      TypeSubscribeList,
+	UNSUBSCRIBE_LIST:    TypeUnsubscribeList,
+	DOCUMENT_LIST:       TypeDocumentList,
+	LIST_CHANGED:        TypeListChanged,
+	SYNC_REQUIRED:       TypeSyncRequired,
+	DELTA:               TypeDelta,
+	ACK:                 TypeAck,
+	DELTA_BATCH:         TypeDeltaBatch,
+	PING:                TypePing,
+	PONG:                TypePong,
+	HEARTBEAT:           TypeHeartbeat,
+	AWARENESS_UPDATE:    TypeAwarenessUpdate,
 	AWARENESS_SUBSCRIBE: TypeAwarenessSubscribe,
-	AWARENESS_STATE:   TypeAwarenessState,
-	ERROR:             TypeError,
+	AWARENESS_STATE:     TypeAwarenessState,
+	SERVER_SHUTDOWN:     TypeServerShutdown,
+	MAINTENANCE:         TypeMaintenance,
+	ERROR:               TypeError,
 }
 
 // Map type names to type codes
 var typeNameToCode = map[string]MessageTypeCode{
-	TypeAuth:        AUTH,
-	TypeAuthSuccess: AUTH_SUCCESS,
-	TypeAuthError:   AUTH_ERROR,
-	TypeSubscribe:   SUBSCRIBE,
-	TypeUnsubscribe: UNSUBSCRIBE,
-	TypeSyncRequest: SYNC_REQUEST,
-	TypeSyncResponse: SYNC_RESPONSE,
-	TypeSyncStep1:   SYNC_STEP1,
-	TypeSyncStep2:   SYNC_STEP2,
-	TypeDelta:       DELTA,
-	TypeAck:         ACK,
-	TypeDeltaBatch:  DELTA_BATCH,
-	TypePing:        PING,
-	TypePong:        PONG,
-	TypeAwarenessUpdate: AWARENESS_UPDATE,
+	TypeAuth:               AUTH,
+	TypeAuthSuccess:        AUTH_SUCCESS,
+	TypeAuthError:          AUTH_ERROR,
+	TypeTokenExpiring:      TOKEN_EXPIRING,
+	TypeSubscribe:          SUBSCRIBE,
+	TypeUnsubscribe:        UNSUBSCRIBE,
+	TypeSyncRequest:        SYNC_REQUEST,
+	TypeSyncResponse:       SYNC_RESPONSE,
+	TypeSyncStep1:          SYNC_STEP1,
+	TypeSyncStep2:          SYNC_STEP2,
+	TypeSubscribePrefix:    SUBSCRIBE_PREFIX,
+	TypeUnsubscribePrefix:  UNSUBSCRIBE_PREFIX,
+	TypePrefixDocuments:    PREFIX_DOCUMENTS,
+	TypeSubscribeList:      SUBSCRIBE_LIST,
+	TypeUnsubscribeList:    UNSUBSCRIBE_LIST,
+	TypeDocumentList:       DOCUMENT_LIST,
+	TypeListChanged:        LIST_CHANGED,
+	TypeSyncRequired:       SYNC_REQUIRED,
+	TypeDelta:              DELTA,
+	TypeAck:                ACK,
+	TypeDeltaBatch:         DELTA_BATCH,
+	TypePing:               PING,
+	TypePong:               PONG,
+	TypeHeartbeat:          HEARTBEAT,
+	TypeAwarenessUpdate:    AWARENESS_UPDATE,
 	TypeAwarenessSubscribe: AWARENESS_SUBSCRIBE,
-	TypeAwarenessState: AWARENESS_STATE,
-	TypeError:       ERROR,
+	TypeAwarenessState:     AWARENESS_STATE,
+	TypeServerShutdown:     SERVER_SHUTDOWN,
+	TypeMaintenance:        MAINTENANCE,
+	TypeError:              ERROR,
+}
+
+// serverOnlyTypes are sent by the server and never accepted from clients
+var serverOnlyTypes = map[string]bool{
+	TypeAuthSuccess:     true,
+	TypeAuthError:       true,
+	TypeTokenExpiring:   true,
+	TypeSyncResponse:    true,
+	TypeSyncStep2:       true,
+	TypePrefixDocuments: true,
+	TypeDocumentList:    true,
+	TypeListChanged:     true,
+	TypeSyncRequired:    true,
+	TypeHeartbeat:       true,
+	TypeAwarenessState:  true,
+	TypeServerShutdown:  true,
+	TypeMaintenance:     true,
+	TypeError:           true,
+}
+
+// TypeNames returns every message type name that has a binary type code, sorted
+func TypeNames() []string {
+	names := make([]string, 0, len(typeNameToCode))
+	for name := range typeNameToCode {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsServerOnly reports whether a message type is only ever sent by the server
+func IsServerOnly(name string) bool {
+	return serverOnlyTypes[name]
 }
 
 // Message represents a WebSocket message
@@ -108,64 +196,135 @@ type Message struct {
 	ID        string                 `json:"id"`
 	Timestamp int64                  `json:"timestamp"`
 	Payload   map[string]interface{} `json:"-"`
+
+	// Origin is the ID of the client message that a server message answers
+	// or forwards, when there is one
+	Origin string `json:"-"`
+
+	// ServerSeq is the sequence number of the frame the message arrived
+	// in, for clients that negotiated CapabilityServerSeq; 0 otherwise
+	ServerSeq uint64 `json:"-"`
+
+	// raw is the JSON the payload was decoded from, for UnmarshalPayload
+	raw []byte
+}
+
+// RawPayload returns the JSON the message's payload was decoded from: nil
+// for MessagePack payloads and messages built in code. It must not be
+// modified.
+func (m *Message) RawPayload() []byte {
+	return m.raw
 }
 
 // EncodeMessage encodes a message to binary format
 // Format: [type:1 byte][timestamp:8 bytes][payload_len:4 bytes][payload:JSON bytes]
 func EncodeMessage(messageType string, payload map[string]interface{}, timestamp int64) ([]byte, error) {
-	// Get type code
-	typeCode, ok := typeNameToCode[messageType]
-	if !ok {
-		typeCode = ERROR
-	}
-
-	// Encode payload as JSON
-	payloadJSON, err := json.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %w", err)
-	}
-
-	payloadLen := uint32(len(payloadJSON))
-
-	// Create buffer: 1 (type) + 8 (timestamp) + 4 (length) + payload
-	buf := make([]byte, 13+payloadLen)
+	return EncodeMessageCompressed(messageType, payload, timestamp, 0)
+}
 
-	// Write type code
-	buf[0] = byte(typeCode)
+// EncodeMessageCompressed is EncodeMessage deflate-compressing payloads
+// whose JSON exceeds threshold bytes (0 never compresses), for clients that
+// asked for it
+func EncodeMessageCompressed(messageType string, payload map[string]interface{}, timestamp int64, threshold int) ([]byte, error) {
+	return EncodeMessageWith(messageType, payload, timestamp, EncodeOptions{CompressAbove: threshold})
+}
 
-	// Write timestamp (big-endian)
-	binary.BigEndian.PutUint64(buf[1:9], uint64(timestamp))
+// EncodeOptions are the payload options a client negotiated at auth
+type EncodeOptions struct {
+	// Encoding is the payload codec; empty means JSON
+	Encoding Encoding
+	// CompressAbove is the encoded payload size above which payloads are
+	// deflate-compressed (0 never compresses)
+	CompressAbove int
+	// Checksum adds a CRC32C of the payload, as sent, to the header
+	Checksum bool
+}
 
-	// Write payload length (big-endian)
-	binary.BigEndian.PutUint32(buf[9:13], payloadLen)
+// EncodeMessageWith encodes a message with the payload options a client
+// negotiated. Payloads that are not plain JSON set the top bit of
+// payload_len and add a flags byte after the header:
+// [type:1][timestamp:8][payload_len|0x80000000:4][flags:1][payload]
+// With FlagChecksum, the CRC32C (Castagnoli) of the payload follows the
+// flags byte and payload_len does not count it:
+// [type:1][timestamp:8][payload_len|0x80000000:4][flags:1][crc32c:4][payload]
+// FlagServerSeq is never set here: StampServerSeq adds it to encoded frames.
+func EncodeMessageWith(messageType string, payload map[string]interface{}, timestamp int64, opts EncodeOptions) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := EncodeMessageTo(buf, messageType, payload, timestamp, opts); err != nil {
+		return nil, err
+	}
+	// The buffer goes back to the pool; the caller owns the copy
+	return bytes.Clone(buf.Bytes()), nil
+}
 
-	// Write payload
-	copy(buf[13:], payloadJSON)
+// EncodePatchedMessageWith is EncodePatchedMessageTo returning the frame
+func EncodePatchedMessageWith(messageType string, raw []byte, set map[string]interface{}, drop []string, timestamp int64, opts EncodeOptions) ([]byte, bool, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if ok, err := EncodePatchedMessageTo(buf, messageType, raw, set, drop, timestamp, opts); !ok {
+		return nil, false, err
+	}
+	return bytes.Clone(buf.Bytes()), true, nil
+}
 
-	return buf, nil
+// ErrPayloadTooLarge is returned by Decoder for messages whose payload,
+// declared or actual, exceeds its MaxPayloadSize
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// ErrChecksumMismatch is returned by Decoder for messages whose payload does
+// not match the checksum in their header, as when a frame was corrupted in
+// transit
+var ErrChecksumMismatch = errors.New("payload checksum mismatch")
+
+// MaxHeaderSize is the most bytes a binary header adds to its payload: 13
+// for the type, timestamp and length, a flags byte, a checksum and a server
+// sequence number
+const MaxHeaderSize = 13 + 1 + 4 + 8
+
+// Decoder decodes messages from clients
+type Decoder struct {
+	// MaxPayloadSize bounds the payload of a message in bytes: a JSON
+	// message, the length a binary header declares and what a compressed
+	// payload inflates to (0 for no limit beyond MaxInflatedPayload)
+	MaxPayloadSize int
+
+	// Strict rejects a whole batch when any of its messages does not decode
+	Strict bool
 }
 
-// DecodeMessage decodes a binary or JSON message
+// DecodeMessage decodes a binary or JSON message without a payload limit
 func DecodeMessage(data []byte) (*Message, error) {
-	// Check if it's JSON (starts with '{' or '[')
-	if len(data) > 0 && (data[0] == '{' || data[0] == '[') {
+	return Decoder{}.Decode(data)
+}
+
+// Decode decodes a binary or JSON message. Binary payloads may be JSON or
+// MessagePack, deflated or not, as their flags say.
+func (d Decoder) Decode(data []byte) (*Message, error) {
+	if isJSONText(data) {
+		if d.MaxPayloadSize > 0 && len(data) > d.MaxPayloadSize {
+			return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrPayloadTooLarge, len(data), d.MaxPayloadSize)
+		}
 		// JSON text protocol
 		var msg map[string]interface{}
-		if err := json.Unmarshal(data, &msg); err != nil {
+		if err := codec.Unmarshal(data, &msg); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
 		}
 
 		message := &Message{
 			Payload: msg,
+			raw:     data,
 		}
 
 		if t, ok := msg["type"].(string); ok {
 			message.Type = t
 		}
-		if id, ok := msg["id"].(string); ok {
-			message.ID = id
-		}
+		message.ID, message.Origin = messageIDs(msg)
 		if ts, ok := msg["timestamp"].(float64); ok {
+			// Converting a float beyond int64 has no defined result
+			if ts != math.Trunc(ts) || ts < math.MinInt64 || ts >= math.MaxInt64 {
+				return nil, fmt.Errorf("invalid timestamp %v", ts)
+			}
 			message.Timestamp = int64(ts)
 		}
 
@@ -179,18 +338,65 @@ func DecodeMessage(data []byte) (*Message, error) {
 
 	// Parse header
 	typeCode := MessageTypeCode(data[0])
+	if typeCode == BATCH {
+		return nil, errors.New("batch envelopes are decoded with DecodeBatch")
+	}
 	timestamp := int64(binary.BigEndian.Uint64(data[1:9]))
 	payloadLen := binary.BigEndian.Uint32(data[9:13])
 
-	// Validate length
-	if uint32(len(data)) < 13+payloadLen {
-		return nil, fmt.Errorf("incomplete message: expected %d bytes, got %d", 13+payloadLen, len(data))
+	// An extended header adds a flags byte
+	headerLen := uint32(13)
+	extended := payloadLen&extendedHeader != 0
+	if extended {
+		payloadLen &^= extendedHeader
+		headerLen = 14
 	}
 
-	// Parse payload
-	payloadBytes := data[13 : 13+payloadLen]
+	// Validate length, refusing oversized declarations before anything else
+	if d.MaxPayloadSize > 0 && uint64(payloadLen) > uint64(d.MaxPayloadSize) {
+		return nil, fmt.Errorf("%w: declared %d bytes, limit %d", ErrPayloadTooLarge, payloadLen, d.MaxPayloadSize)
+	}
+	var flags byte
+	if extended {
+		if len(data) < 14 {
+			return nil, fmt.Errorf("message too short: %d bytes", len(data))
+		}
+		flags = data[13]
+		if flags&^knownFlags != 0 {
+			return nil, fmt.Errorf("unknown payload flags: %#x", flags)
+		}
+		if flags&FlagChecksum != 0 {
+			headerLen += 4
+		}
+		if flags&FlagServerSeq != 0 {
+			headerLen += 8
+		}
+	}
+	if uint32(len(data)) < headerLen+payloadLen {
+		return nil, fmt.Errorf("incomplete message: expected %d bytes, got %d", headerLen+payloadLen, len(data))
+	}
+
+	// Parse payload, checking it arrived intact before reading anything in it
+	payloadBytes := data[headerLen : headerLen+payloadLen]
+	if flags&FlagChecksum != 0 {
+		want := binary.BigEndian.Uint32(data[14:18])
+		if got := crc32.Checksum(payloadBytes, castagnoli); got != want {
+			return nil, fmt.Errorf("%w: header says %#08x, payload has %#08x", ErrChecksumMismatch, want, got)
+		}
+	}
+	if flags&FlagDeflate != 0 {
+		var err error
+		if payloadBytes, err = inflate(payloadBytes, d.inflateLimit()); err != nil {
+			return nil, err
+		}
+	}
 	var payload map[string]interface{}
-	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+	if flags&FlagMsgpack != 0 {
+		var err error
+		if payload, err = unmarshalMsgpack(payloadBytes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+		}
+	} else if err := codec.Unmarshal(payloadBytes, &payload); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
 	}
 
@@ -205,11 +411,48 @@ func DecodeMessage(data []byte) (*Message, error) {
 		Timestamp: timestamp,
 		Payload:   payload,
 	}
+	if flags&FlagServerSeq != 0 {
+		message.ServerSeq = binary.BigEndian.Uint64(data[headerLen-8 : headerLen])
+	}
+	if flags&FlagMsgpack == 0 {
+		message.raw = payloadBytes
+	}
 
 	// Extract common fields
-	if id, ok := payload["id"].(string); ok {
-		message.ID = id
-	}
+	message.ID, message.Origin = messageIDs(payload)
 
 	return message, nil
 }
+
+// messageIDs reads a payload's ID and origin, if they are strings
+func messageIDs(payload map[string]interface{}) (id, origin string) {
+	id, _ = payload["id"].(string)
+	origin, _ = payload["origin"].(string)
+	return id, origin
+}
+
+// isJSONText reports whether data is a JSON text message rather than a
+// binary one: a '{' or '[', possibly after JSON whitespace. No binary
+// message looks like that, because a whitespace type code (DELTA is 0x20)
+// is followed by the top byte of a timestamp, which is zero for millions
+// of years yet.
+func isJSONText(data []byte) bool {
+	for _, c := range data {
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '{', '[':
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// inflateLimit is the size compressed payloads may inflate to
+func (d Decoder) inflateLimit() int {
+	if d.MaxPayloadSize > 0 && d.MaxPayloadSize < MaxInflatedPayload {
+		return d.MaxPayloadSize
+	}
+	return MaxInflatedPayload
+}
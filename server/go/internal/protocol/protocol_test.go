@@ -1,12 +1,34 @@
 package protocol
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
 )
 
+// TestMain runs every test, round trips included, once with each JSON codec
+func TestMain(m *testing.M) {
+	for _, name := range codec.Names() {
+		if err := codec.Use(name); err != nil {
+			panic(err)
+		}
+		if code := m.Run(); code != 0 {
+			os.Exit(code)
+		}
+	}
+	os.Exit(0)
+}
+
 func TestMessageTypeCodes(t *testing.T) {
 	tests := []struct {
 		code MessageTypeCode
@@ -17,13 +39,25 @@ func TestMessageTypeCodes(t *testing.T) {
 		{AUTH_ERROR, 0x03},
 		{SUBSCRIBE, 0x10},
 		{UNSUBSCRIBE, 0x11},
+		{TOKEN_EXPIRING, 0x04},
 		{SYNC_REQUEST, 0x12},
 		{SYNC_RESPONSE, 0x13},
+		{SUBSCRIBE_PREFIX, 0x16},
+		{UNSUBSCRIBE_PREFIX, 0x17},
+		{PREFIX_DOCUMENTS, 0x18},
+		{SUBSCRIBE_LIST, 0x19},
+		{UNSUBSCRIBE_LIST, 0x1A},
+		{DOCUMENT_LIST, 0x1B},
+		{LIST_CHANGED, 0x1C},
+		{SYNC_REQUIRED, 0x1D},
 		{DELTA, 0x20},
 		{ACK, 0x21},
 		{PING, 0x30},
 		{PONG, 0x31},
+		{HEARTBEAT, 0x32},
 		{AWARENESS_UPDATE, 0x40},
+		{SERVER_SHUTDOWN, 0x50},
+		{MAINTENANCE, 0x51},
 		{ERROR, 0xFF},
 	}
 
@@ -47,6 +81,17 @@ func TestBidirectionalMapping(t *testing.T) {
 	}
 }
 
+func TestServerOnlyTypesAreProtocolTypes(t *testing.T) {
+	for name := range serverOnlyTypes {
+		if _, ok := typeNameToCode[name]; !ok {
+			t.Errorf("server-only type %q has no type code", name)
+		}
+	}
+	if len(TypeNames()) != len(typeNameToCode) {
+		t.Errorf("TypeNames() returned %d names, want %d", len(TypeNames()), len(typeNameToCode))
+	}
+}
+
 func TestEncodeMessage(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -167,6 +212,65 @@ func TestDecodeMessage_JSON(t *testing.T) {
 	}
 }
 
+func TestNewMessage(t *testing.T) {
+	ids := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		msg := NewMessage(TypeAck, map[string]interface{}{"origin": "forged", "docId": "d"})
+		if msg.ID == "" || ids[msg.ID] {
+			t.Fatalf("id %q is not unique", msg.ID)
+		}
+		ids[msg.ID] = true
+		if msg.Payload["id"] != msg.ID || msg.Payload["type"] != TypeAck || msg.Payload["timestamp"] != msg.Timestamp {
+			t.Fatalf("payload = %v", msg.Payload)
+		}
+		if _, ok := msg.Payload["origin"]; ok {
+			t.Fatalf("payload kept a forged origin: %v", msg.Payload)
+		}
+	}
+
+	// Forwarded payloads keep their own timestamp
+	msg := NewMessage(TypeDelta, map[string]interface{}{"timestamp": 5.0}).WithOrigin("d1")
+	if msg.Payload["timestamp"] != 5.0 {
+		t.Errorf("timestamp = %v, want 5", msg.Payload["timestamp"])
+	}
+
+	for _, opts := range []EncodeOptions{{}, {Encoding: EncodingMsgpack}} {
+		data, _ := EncodeMessageWith(msg.Type, msg.Payload, msg.Timestamp, opts)
+		decoded, err := DecodeMessage(data)
+		if err != nil || decoded.ID != msg.ID || decoded.Origin != "d1" {
+			t.Errorf("%+v: decoded %+v, %v; want id %q origin d1", opts, decoded, err, msg.ID)
+		}
+	}
+	if decoded, _ := DecodeMessage([]byte(`{"type":"ack","id":"a","origin":"d1"}`)); decoded.Origin != "d1" {
+		t.Errorf("JSON text origin = %q, want d1", decoded.Origin)
+	}
+}
+
+func TestDecodeMessage_JSONWithLeadingWhitespace(t *testing.T) {
+	result, err := DecodeMessage([]byte(" \r\n\t{\"type\":\"ping\",\"id\":\"p\"}"))
+	if err != nil {
+		t.Fatalf("DecodeMessage() error = %v", err)
+	}
+	if result.Type != TypePing || result.ID != "p" {
+		t.Errorf("DecodeMessage() = %s %q, want ping %q", result.Type, result.ID, "p")
+	}
+
+	// A binary DELTA starts with a space too, but never with one followed
+	// by JSON text
+	data, _ := EncodeMessage(TypeDelta, map[string]interface{}{"docId": "d"}, 1000)
+	if result, err := DecodeMessage(data); err != nil || result.Type != TypeDelta {
+		t.Errorf("binary delta decodes to %v, %v", result, err)
+	}
+}
+
+func TestDecodeMessage_RejectsBadJSONTimestamps(t *testing.T) {
+	for _, ts := range []string{"1.5", "1e300", "-1e19"} {
+		if _, err := DecodeMessage([]byte(`{"type":"ping","timestamp":` + ts + `}`)); err == nil {
+			t.Errorf("timestamp %s: DecodeMessage() accepted it", ts)
+		}
+	}
+}
+
 func TestDecodeMessage_RejectsShortMessage(t *testing.T) {
 	shortMessage := []byte{0x30, 0x00, 0x00} // Only 3 bytes
 
@@ -241,6 +345,83 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
+// largeDocument is a sync_response for a document of n similar fields
+func largeDocument(n int) map[string]interface{} {
+	state := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		state[fmt.Sprintf("block-%05d", i)] = map[string]interface{}{
+			"type": "paragraph",
+			"text": fmt.Sprintf("Paragraph %d of the synthetic document, repeated to look like prose.", i),
+		}
+	}
+	return map[string]interface{}{"type": TypeSyncResponse, "id": "sync-1", "docId": "doc-large", "state": state}
+}
+
+func TestEncodeMessageCompressed(t *testing.T) {
+	payload := largeDocument(5000)
+	plainJSON, _ := json.Marshal(payload)
+
+	plain, err := EncodeMessageCompressed(TypeSyncResponse, payload, 1000, 0)
+	if err != nil {
+		t.Fatalf("EncodeMessageCompressed() error = %v", err)
+	}
+	// Without compression the header stays 13 bytes
+	if len(plain) != 13+len(plainJSON) || binary.BigEndian.Uint32(plain[9:13]) != uint32(len(plainJSON)) {
+		t.Errorf("uncompressed length = %d, want 13+%d", len(plain), len(plainJSON))
+	}
+
+	compressed, err := EncodeMessageCompressed(TypeSyncResponse, payload, 1000, 1024)
+	if err != nil {
+		t.Fatalf("EncodeMessageCompressed() error = %v", err)
+	}
+	if compressed[13] != FlagDeflate || binary.BigEndian.Uint32(compressed[9:13])&extendedHeader == 0 {
+		t.Fatalf("header = %x, want the deflate flag", compressed[:14])
+	}
+	if len(compressed)*5 > len(plain) {
+		t.Errorf("compressed %d bytes to %d, want under a fifth", len(plain), len(compressed))
+	}
+
+	for name, data := range map[string][]byte{"plain": plain, "compressed": compressed} {
+		decoded, err := DecodeMessage(data)
+		if err != nil {
+			t.Fatalf("%s: DecodeMessage() error = %v", name, err)
+		}
+		if decoded.Type != TypeSyncResponse || decoded.Timestamp != 1000 || decoded.ID != "sync-1" {
+			t.Errorf("%s: decoded %s %d %q", name, decoded.Type, decoded.Timestamp, decoded.ID)
+		}
+		if got, _ := json.Marshal(decoded.Payload); string(got) != string(plainJSON) {
+			t.Errorf("%s: payload changed in the round trip", name)
+		}
+	}
+
+	// Payloads at or below the threshold are left alone
+	small := map[string]interface{}{"type": TypePing, "id": "p"}
+	smallJSON, _ := json.Marshal(small)
+	if data, _ := EncodeMessageCompressed(TypePing, small, 1000, len(smallJSON)); len(data) != 13+len(smallJSON) {
+		t.Errorf("small payload encoded to %d bytes, want it uncompressed", len(data))
+	}
+}
+
+func TestDecodeMessage_RejectsBadFlags(t *testing.T) {
+	data, _ := EncodeMessageCompressed(TypeSyncResponse, largeDocument(100), 1000, 1)
+
+	unknown := append([]byte(nil), data...)
+	unknown[13] |= 0x80
+	if _, err := DecodeMessage(unknown); err == nil {
+		t.Error("DecodeMessage() accepted an unknown flag")
+	}
+
+	corrupt := append([]byte(nil), data[:14]...)
+	corrupt = append(corrupt, make([]byte, len(data)-14)...)
+	if _, err := DecodeMessage(corrupt); err == nil {
+		t.Error("DecodeMessage() accepted a corrupt deflate payload")
+	}
+
+	if _, err := DecodeMessage(data[:13]); err == nil {
+		t.Error("DecodeMessage() accepted a header without its flags byte")
+	}
+}
+
 func TestRoundTrip_AllMessageTypes(t *testing.T) {
 	typesToTest := []struct {
 		typeName string
@@ -324,3 +505,694 @@ func TestEncodeMessage_PreservesPayloadData(t *testing.T) {
 		t.Errorf("array length = %d, want 3", len(arr))
 	}
 }
+
+func TestEncodeMessageWith_Msgpack(t *testing.T) {
+	for _, typeName := range TypeNames() {
+		t.Run(typeName, func(t *testing.T) {
+			payload := map[string]interface{}{
+				"type":   typeName,
+				"id":     "msg-1",
+				"docId":  "room:1",
+				"seq":    uint64(1) << 40,
+				"small":  -7,
+				"wide":   int64(-1) << 40,
+				"ratio":  0.25,
+				"ok":     true,
+				"none":   nil,
+				"long":   strings.Repeat("x", 70000),
+				"tags":   []string{"a", "b"},
+				"delta":  map[string]interface{}{"field": "title", "value": []interface{}{float64(1), "two", false}},
+				"labels": map[string]string{"k": "v"},
+				"at":     time.Unix(1700000000, 0).UTC(),
+			}
+			// Payloads come back as they would from JSON
+			want := roundTrip(t, EncodeOptions{}, typeName, payload)
+			for _, opts := range []EncodeOptions{
+				{Encoding: EncodingMsgpack},
+				{Encoding: EncodingMsgpack, CompressAbove: 1024},
+			} {
+				if got := roundTrip(t, opts, typeName, payload); !reflect.DeepEqual(got, want) {
+					t.Errorf("%+v: payload = %v, want %v", opts, got, want)
+				}
+			}
+		})
+	}
+
+	// MessagePack is smaller than JSON, and flagged as such
+	payload := largeDocument(100)
+	plain, _ := EncodeMessage(TypeSyncResponse, payload, 1000)
+	packed, err := EncodeMessageWith(TypeSyncResponse, payload, 1000, EncodeOptions{Encoding: EncodingMsgpack})
+	if err != nil {
+		t.Fatalf("EncodeMessageWith() error = %v", err)
+	}
+	if packed[13] != FlagMsgpack || len(packed) >= len(plain) {
+		t.Errorf("msgpack message is %d bytes with flags %#x, want FlagMsgpack and under %d", len(packed), packed[13], len(plain))
+	}
+}
+
+func roundTrip(t *testing.T, opts EncodeOptions, typeName string, payload map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := EncodeMessageWith(typeName, payload, 1000, opts)
+	if err != nil {
+		t.Fatalf("EncodeMessageWith(%+v) error = %v", opts, err)
+	}
+	decoded, err := DecodeMessage(data)
+	if err != nil {
+		t.Fatalf("DecodeMessage(%+v) error = %v", opts, err)
+	}
+	if decoded.Type != typeName || decoded.ID != "msg-1" || decoded.Timestamp != 1000 {
+		t.Errorf("%+v: decoded %s %q %d", opts, decoded.Type, decoded.ID, decoded.Timestamp)
+	}
+	return decoded.Payload
+}
+
+func TestDecodeMessage_RejectsBadMsgpack(t *testing.T) {
+	envelope := func(payload ...byte) []byte {
+		buf := make([]byte, 14, 14+len(payload))
+		buf[0] = byte(DELTA)
+		binary.BigEndian.PutUint32(buf[9:13], uint32(len(payload))|extendedHeader)
+		buf[13] = FlagMsgpack
+		return append(buf, payload...)
+	}
+	for name, data := range map[string][]byte{
+		"not a map":       envelope(0x91, 0x01),
+		"truncated":       envelope(0x81, 0xa1, 'k'),
+		"trailing bytes":  envelope(0x80, 0x00),
+		"binary value":    envelope(0x81, 0xa1, 'k', 0xc4, 0x01, 0x00),
+		"integer key":     envelope(0x81, 0x01, 0x01),
+		"oversized array": envelope(0x81, 0xa1, 'k', 0xdd, 0xff, 0xff, 0xff, 0xff),
+		"NaN float":       envelope(0x81, 0xa1, 'k', 0xcb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 0),
+		"infinite float":  envelope(0x81, 0xa1, 'k', 0xca, 0x7f, 0x80, 0, 0),
+		"invalid UTF-8":   envelope(0x81, 0xa1, 'k', 0xa1, 0xff),
+	} {
+		if _, err := DecodeMessage(data); err == nil {
+			t.Errorf("%s: DecodeMessage() accepted it", name)
+		}
+	}
+}
+
+func TestEncodeMessageWith_Checksum(t *testing.T) {
+	payload := map[string]interface{}{"id": "d", "docId": "room:1", "body": strings.Repeat("checksummed ", 50)}
+	for _, opts := range []EncodeOptions{
+		{},
+		{Checksum: true},
+		{Checksum: true, Encoding: EncodingMsgpack},
+		{Checksum: true, CompressAbove: 64},
+		{Checksum: true, Encoding: EncodingMsgpack, CompressAbove: 64},
+	} {
+		data, err := EncodeMessageWith(TypeDelta, payload, 1000, opts)
+		if err != nil {
+			t.Fatalf("%+v: EncodeMessageWith() error = %v", opts, err)
+		}
+		checksummed := len(data) > 13 && data[9]&0x80 != 0 && data[13]&FlagChecksum != 0
+		if checksummed != opts.Checksum {
+			t.Errorf("%+v: checksum flag = %v", opts, checksummed)
+		}
+		msg, err := DecodeMessage(data)
+		if err != nil || !reflect.DeepEqual(msg.Payload, payload) {
+			t.Fatalf("%+v: round trip = %v, %v", opts, msg, err)
+		}
+		if !opts.Checksum {
+			continue
+		}
+
+		// Any flipped payload or checksum byte is caught
+		for _, at := range []int{14, 17, 18, len(data) / 2, len(data) - 1} {
+			corrupt := append([]byte(nil), data...)
+			corrupt[at] ^= 0x20
+			if _, err := DecodeMessage(corrupt); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("%+v: byte %d flipped: error = %v, want ErrChecksumMismatch", opts, at, err)
+			}
+		}
+	}
+}
+
+func TestStampServerSeq(t *testing.T) {
+	payload := map[string]interface{}{"id": "d", "docId": "room:1", "body": strings.Repeat("stamped ", 50)}
+	for _, opts := range []EncodeOptions{
+		{},
+		{Checksum: true},
+		{Encoding: EncodingMsgpack},
+		{Checksum: true, Encoding: EncodingMsgpack, CompressAbove: 64},
+	} {
+		data, err := EncodeMessageWith(TypeDelta, payload, 1000, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := append([]byte(nil), data...)
+		stamped, err := StampServerSeq(data, 42)
+		if err != nil {
+			t.Fatalf("%+v: StampServerSeq() error = %v", opts, err)
+		}
+		if !bytes.Equal(data, original) {
+			t.Errorf("%+v: StampServerSeq() changed the shared frame", opts)
+		}
+		if seq, ok := ServerSeq(stamped); !ok || seq != 42 {
+			t.Errorf("%+v: ServerSeq() = %d, %v", opts, seq, ok)
+		}
+		if _, ok := ServerSeq(data); ok {
+			t.Errorf("%+v: ServerSeq() found a number in an unstamped frame", opts)
+		}
+		msg, err := DecodeMessage(stamped)
+		if err != nil || msg.ServerSeq != 42 || !reflect.DeepEqual(msg.Payload, payload) {
+			t.Fatalf("%+v: decoded %+v, %v", opts, msg, err)
+		}
+
+		// Restamping replaces the number
+		restamped, _ := StampServerSeq(stamped, 43)
+		if msg, err := DecodeMessage(restamped); err != nil || msg.ServerSeq != 43 || len(restamped) != len(stamped) {
+			t.Errorf("%+v: restamped decodes to %+v, %v", opts, msg, err)
+		}
+	}
+
+	// Batch envelopes carry one number for the frame
+	batch, _ := EncodeBatch([]Message{
+		{Type: TypePing, Payload: map[string]interface{}{"id": "a"}},
+		{Type: TypePing, Payload: map[string]interface{}{"id": "b"}},
+	}, 1000)
+	stamped, _ := StampServerSeq(batch, 7)
+	items, err := Decoder{}.DecodeBatch(stamped)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("DecodeBatch() = %v, %v", items, err)
+	}
+	for i, item := range items {
+		if item.Err != nil || item.Message.ServerSeq != 7 {
+			t.Errorf("message %d = %+v, %v; want sequence number 7", i, item.Message, item.Err)
+		}
+	}
+	flagged := append([]byte(nil), stamped...)
+	flagged[13] |= FlagChecksum
+	if _, err := (Decoder{}).DecodeBatch(flagged); err == nil {
+		t.Error("DecodeBatch() accepted an envelope with another flag")
+	}
+
+	if _, err := StampServerSeq(batch[:12], 1); err == nil {
+		t.Error("StampServerSeq() accepted a truncated header")
+	}
+}
+
+func TestDecoder_RejectsOversizedPayloads(t *testing.T) {
+	d := Decoder{MaxPayloadSize: 1024}
+
+	fits, _ := EncodeMessage(TypeDelta, map[string]interface{}{"id": "d", "pad": strings.Repeat("x", 900)}, 1000)
+	if _, err := d.Decode(fits); err != nil {
+		t.Fatalf("Decode() of a payload under the limit: %v", err)
+	}
+
+	oversized, _ := EncodeMessage(TypeDelta, map[string]interface{}{"pad": strings.Repeat("x", 1024)}, 1000)
+	declared := make([]byte, 13)
+	binary.BigEndian.PutUint32(declared[9:13], 0x7fffffff)
+	extended := make([]byte, 14)
+	binary.BigEndian.PutUint32(extended[9:13], 0xffffffff)
+	extended[13] = FlagDeflate
+	// A small compressed payload that inflates past the limit
+	bomb, _ := EncodeMessageCompressed(TypeDelta, map[string]interface{}{"pad": strings.Repeat("x", 100000)}, 1000, 1)
+	if len(bomb) > 1024 {
+		t.Fatalf("compressed payload is %d bytes, want it under the limit", len(bomb))
+	}
+
+	for name, data := range map[string][]byte{
+		"json":             []byte(`{"type":"ping","pad":"` + strings.Repeat("x", 1024) + `"}`),
+		"binary":           oversized,
+		"declared length":  declared,
+		"extended header":  extended,
+		"inflated payload": bomb,
+	} {
+		if _, err := d.Decode(data); !errors.Is(err, ErrPayloadTooLarge) {
+			t.Errorf("%s: Decode() error = %v, want ErrPayloadTooLarge", name, err)
+		}
+	}
+
+	// Without a limit only truncation is an error
+	if _, err := DecodeMessage(oversized); err != nil {
+		t.Errorf("DecodeMessage() error = %v", err)
+	}
+}
+
+// fuzzSeeds adds a seed corpus covering every type code, each payload
+// option, the JSON text path, truncated frames and giant declared lengths
+func fuzzSeeds(f *testing.F) {
+	payload := map[string]interface{}{"type": TypeDelta, "id": "d", "docId": "room:1", "changes": map[string]interface{}{"n": 1.5, "s": "x"}}
+	for _, name := range TypeNames() {
+		data, _ := EncodeMessage(name, map[string]interface{}{"type": name, "id": "m"}, 1000)
+		f.Add(data)
+	}
+	for _, opts := range []EncodeOptions{{}, {CompressAbove: 1}, {Encoding: EncodingMsgpack}, {Encoding: EncodingMsgpack, CompressAbove: 1}, {Checksum: true}} {
+		data, _ := EncodeMessageWith(TypeDelta, payload, 1000, opts)
+		f.Add(data)
+		for _, n := range []int{1, 12, 13, 14, len(data) - 1} {
+			f.Add(data[:n])
+		}
+	}
+	f.Add([]byte(`{"type":"ping","id":"p"}`))
+	f.Add([]byte(" \n\t{\"type\":\"ping\",\"timestamp\":1e300}"))
+	f.Add([]byte(`[1,2]`))
+	f.Add([]byte{0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0x02, 0xdd, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0x7f, 0xff, 0xff, 0xff})
+
+	batch, _ := EncodeBatch([]Message{{Type: TypePing, Payload: map[string]interface{}{"id": "p"}}, {Type: TypeDelta, Payload: payload}}, 1000)
+	f.Add(batch)
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	fuzzSeeds(f)
+
+	const limit = 4096
+	d := Decoder{MaxPayloadSize: limit}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if IsBatch(data) {
+			// Batches must not panic either, nor exceed MaxBatchMessages
+			items, err := d.DecodeBatch(data)
+			if err == nil && len(items) > MaxBatchMessages {
+				t.Fatalf("batch of %d messages", len(items))
+			}
+			return
+		}
+		msg, err := d.Decode(data)
+		if len(data) >= 13 && !isJSONText(data) {
+			if declared := binary.BigEndian.Uint32(data[9:13]) &^ extendedHeader; declared > limit && !errors.Is(err, ErrPayloadTooLarge) {
+				t.Fatalf("declared %d bytes: error = %v, want ErrPayloadTooLarge", declared, err)
+			}
+		}
+		if err != nil {
+			return
+		}
+		// What decodes fits the limit once encoded again, give or take the
+		// expansion of JSON over the most compact encodings
+		encoded, err := json.Marshal(msg.Payload)
+		if err != nil {
+			t.Fatalf("decoded payload does not encode: %v", err)
+		}
+		if len(encoded) > 8*limit {
+			t.Fatalf("decoded payload is %d bytes of JSON, limit %d", len(encoded), limit)
+		}
+
+		// And it can be sent on unchanged, in either encoding. Messages
+		// without a known type go out as errors.
+		wantType := msg.Type
+		if _, ok := typeNameToCode[wantType]; !ok {
+			wantType = TypeError
+		}
+		for _, opts := range []EncodeOptions{{}, {Encoding: EncodingMsgpack}} {
+			again, err := EncodeMessageWith(msg.Type, msg.Payload, msg.Timestamp, opts)
+			if err != nil {
+				t.Fatalf("%+v: decoded message does not encode: %v", opts, err)
+			}
+			back, err := DecodeMessage(again)
+			if err != nil || back.Type != wantType || back.Timestamp != msg.Timestamp || !reflect.DeepEqual(back.Payload, msg.Payload) {
+				t.Fatalf("%+v: re-encoded message decodes to %v, %v; want %v", opts, back, err, msg)
+			}
+		}
+	})
+}
+
+func FuzzEncodeDecodeRoundTrip(f *testing.F) {
+	names := TypeNames()
+	for i := range names {
+		f.Add(uint8(i), int64(1000), []byte(`{"id":"m","docId":"room:1","changes":{"n":1.5,"s":"x","l":[true,null]}}`), uint8(i%16))
+	}
+	f.Add(uint8(0), int64(-1), []byte(`{"big":1e300,"small":-5e-324,"int":9007199254740993,"neg":-0}`), uint8(15))
+	f.Add(uint8(1), int64(1<<62), []byte(`{"s":"\u2028 \ud800 <&> \u0000 \ufffd"}`), uint8(6))
+
+	f.Fuzz(func(t *testing.T, typeIndex uint8, timestamp int64, payloadJSON []byte, flags uint8) {
+		var payload map[string]interface{}
+		if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+			return
+		}
+		name := names[int(typeIndex)%len(names)]
+		opts := EncodeOptions{Checksum: flags&1 != 0}
+		if flags&2 != 0 {
+			opts.Encoding = EncodingMsgpack
+		}
+		if flags&4 != 0 {
+			opts.CompressAbove = int(flags >> 3)
+		}
+
+		data, err := EncodeMessageWith(name, payload, timestamp, opts)
+		if err != nil {
+			t.Fatalf("EncodeMessageWith(%+v) error = %v", opts, err)
+		}
+		msg, err := DecodeMessage(data)
+		if err != nil {
+			t.Fatalf("DecodeMessage() error = %v", err)
+		}
+		if msg.Type != name || msg.Timestamp != timestamp || !reflect.DeepEqual(msg.Payload, payload) {
+			t.Fatalf("round trip = %s at %d: %v; want %s at %d: %v", msg.Type, msg.Timestamp, msg.Payload, name, timestamp, payload)
+		}
+	})
+}
+
+func TestEncodeBatch_RoundTrip(t *testing.T) {
+	messages := []Message{
+		{Type: TypeSubscribe, Timestamp: 1, Payload: map[string]interface{}{"id": "s", "docId": "room:1"}},
+		{Type: TypeDelta, Timestamp: 2, Payload: map[string]interface{}{"id": "d", "docId": "room:1", "changes": map[string]interface{}{"title": "Hi"}}},
+		{Type: TypeAwarenessUpdate, Timestamp: 3, Payload: map[string]interface{}{"id": "a", "docId": "room:1", "state": largeDocument(200)["state"]}},
+		{Type: TypePing, Timestamp: 4, Payload: map[string]interface{}{"id": "p"}},
+	}
+	for _, opts := range []EncodeOptions{{}, {Encoding: EncodingMsgpack, CompressAbove: 256}} {
+		data, err := EncodeBatchWith(messages, 99, opts)
+		if err != nil {
+			t.Fatalf("EncodeBatchWith(%+v) error = %v", opts, err)
+		}
+		if !IsBatch(data) {
+			t.Fatalf("%+v: IsBatch() = false", opts)
+		}
+		if _, err := DecodeMessage(data); err == nil {
+			t.Errorf("%+v: DecodeMessage() accepted a batch", opts)
+		}
+
+		items, err := Decoder{}.DecodeBatch(data)
+		if err != nil {
+			t.Fatalf("%+v: DecodeBatch() error = %v", opts, err)
+		}
+		if len(items) != len(messages) {
+			t.Fatalf("%+v: %d messages, want %d", opts, len(items), len(messages))
+		}
+		for i, item := range items {
+			if item.Err != nil {
+				t.Fatalf("%+v: message %d: %v", opts, i, item.Err)
+			}
+			want := messages[i]
+			if item.Message.Type != want.Type || item.Message.Timestamp != want.Timestamp || item.Message.ID != want.Payload["id"] {
+				t.Errorf("%+v: message %d = %s %d %q, want %s %d %v", opts, i, item.Message.Type, item.Message.Timestamp, item.Message.ID, want.Type, want.Timestamp, want.Payload["id"])
+			}
+		}
+		if state, _ := items[2].Message.Payload["state"].(map[string]interface{}); len(state) != 200 {
+			t.Errorf("%+v: awareness state has %d fields, want 200", opts, len(state))
+		}
+	}
+}
+
+func TestDecodeBatch_BadMessages(t *testing.T) {
+	ping, _ := EncodeMessage(TypePing, map[string]interface{}{"id": "p"}, 1)
+	nested, _ := EncodeBatch([]Message{{Type: TypePing, Payload: map[string]interface{}{}}}, 1)
+	garbage := append([]byte(nil), ping...)
+	garbage[len(garbage)-1] = '!'
+	data := rawBatch(ping, garbage, nested, ping)
+
+	items, err := Decoder{}.DecodeBatch(data)
+	if err != nil {
+		t.Fatalf("DecodeBatch() error = %v", err)
+	}
+	for i, wantErr := range []bool{false, true, true, false} {
+		if (items[i].Err != nil) != wantErr {
+			t.Errorf("message %d error = %v, want error %v", i, items[i].Err, wantErr)
+		}
+	}
+
+	// Strict decoders refuse the lot
+	if _, err := (Decoder{Strict: true}).DecodeBatch(data); err == nil {
+		t.Error("strict DecodeBatch() accepted a bad message")
+	}
+	if _, err := (Decoder{Strict: true}).DecodeBatch(rawBatch(ping, ping)); err != nil {
+		t.Errorf("strict DecodeBatch() error = %v", err)
+	}
+	if _, err := (Decoder{MaxPayloadSize: 20}).DecodeBatch(rawBatch(ping, ping)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("DecodeBatch() over the limit error = %v, want ErrPayloadTooLarge", err)
+	}
+
+	// Broken envelopes refuse the whole batch
+	good := rawBatch(ping, ping)
+	overCount := append([]byte(nil), good...)
+	binary.BigEndian.PutUint32(overCount[13:17], 3)
+	overLength := append([]byte(nil), good...)
+	binary.BigEndian.PutUint32(overLength[17:21], uint32(len(ping)+1))
+	trailing := rawBatch(ping)
+	binary.BigEndian.PutUint32(trailing[9:13], binary.BigEndian.Uint32(trailing[9:13])+1)
+	trailing = append(trailing, 0)
+	for name, data := range map[string][]byte{
+		"count beyond messages": overCount,
+		"length beyond payload": overLength,
+		"trailing bytes":        trailing,
+		"truncated":             good[:len(good)-1],
+		"too many messages":     rawBatchCount(MaxBatchMessages + 1),
+	} {
+		if _, err := (Decoder{}).DecodeBatch(data); err == nil {
+			t.Errorf("%s: DecodeBatch() accepted it", name)
+		}
+	}
+}
+
+// rawBatch frames already encoded messages as a batch
+func rawBatch(messages ...[]byte) []byte {
+	payload := binary.BigEndian.AppendUint32(nil, uint32(len(messages)))
+	for _, msg := range messages {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(msg)))
+		payload = append(payload, msg...)
+	}
+	buf := make([]byte, 13, 13+len(payload))
+	buf[0] = byte(BATCH)
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+// rawBatchCount is a batch declaring count messages
+func rawBatchCount(count uint32) []byte {
+	data := rawBatch()
+	binary.BigEndian.PutUint32(data[13:17], count)
+	return data
+}
+
+func TestAppendJSON_MatchesMarshal(t *testing.T) {
+	values := []interface{}{
+		nil, true, 0, -42, int64(1) << 60, uint64(1<<64 - 1), int32(-7), uint32(7),
+		1.5, -0.0, 1e20, 1e21, 1e-6, 1e-7, 0.000001, 5e-324, -123.456e-30,
+		"", "plain", `quote " and \ backslash`, "new\nline\ttab\r", "<a & b>", "\x00\x1f\x7f\b\f",
+		"bad \xff utf-8", "line\u2028sep\u2029", "emoji 🎉 and ünïcode",
+		[]interface{}{}, []interface{}{1.0, "two", nil}, []string{"a", "b\n"}, []string(nil),
+		map[string]interface{}(nil), []interface{}(nil),
+		map[string]interface{}{"z": 1.0, "a": map[string]interface{}{"<k>": []interface{}{false}}, "m": "x"},
+		time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), struct{ A int }{1}, map[string]int{"b": 2, "a": 1},
+	}
+	for _, v := range values {
+		want, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("json.Marshal(%#v) error = %v", v, err)
+		}
+		got, err := appendJSON([]byte("prefix"), v)
+		if err != nil {
+			t.Errorf("appendJSON(%#v) error = %v", v, err)
+			continue
+		}
+		if string(got) != "prefix"+string(want) {
+			t.Errorf("appendJSON(%#v) = %s, want prefix%s", v, got, want)
+		}
+	}
+
+	for _, v := range []interface{}{math.NaN(), math.Inf(1), map[string]interface{}{"x": math.Inf(-1)}, make(chan int)} {
+		if _, err := appendJSON(nil, v); err == nil {
+			t.Errorf("appendJSON(%v) succeeded, want an error", v)
+		}
+	}
+}
+
+func TestUnmarshalPayload(t *testing.T) {
+	payload := map[string]interface{}{"docId": "room:1", "mode": "read", "resumeFrom": map[string]interface{}{"room:1": 4.0}}
+	binaryJSON, _ := EncodeMessage(TypeSubscribe, payload, 1000)
+	binaryMsgpack, _ := EncodeMessageWith(TypeSubscribe, payload, 1000, EncodeOptions{Encoding: EncodingMsgpack})
+	text, _ := json.Marshal(map[string]interface{}{"type": TypeSubscribe, "docId": "room:1", "mode": "read", "resumeFrom": map[string]interface{}{"room:1": 4}})
+
+	want := SubscribePayload{DocID: "room:1", Mode: "read", ResumeFrom: map[string]float64{"room:1": 4}}
+	for name, data := range map[string][]byte{"binary json": binaryJSON, "binary msgpack": binaryMsgpack, "json text": text} {
+		msg, err := DecodeMessage(data)
+		if err != nil {
+			t.Fatalf("%s: DecodeMessage() error = %v", name, err)
+		}
+		var got SubscribePayload
+		if err := UnmarshalPayload(msg, &got); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: UnmarshalPayload() = %+v, %v; want %+v", name, got, err, want)
+		}
+	}
+
+	// Messages built in code have no raw payload
+	var got SubscribePayload
+	if err := UnmarshalPayload(&Message{Type: TypeSubscribe, Payload: payload}, &got); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("built message: UnmarshalPayload() = %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func TestUnmarshalPayload_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		v       interface{}
+		want    PayloadError
+	}{
+		{"missing docId", map[string]interface{}{}, &DeltaPayload{}, PayloadError{Field: "docId"}},
+		{"empty docId", map[string]interface{}{"docId": ""}, &DocumentPayload{}, PayloadError{Field: "docId"}},
+		{"wrong docId type", map[string]interface{}{"docId": 1.0}, &SubscribePayload{}, PayloadError{Field: "docId", Problem: "must be a string"}},
+		{"wrong nested type", map[string]interface{}{"docId": "a", "resumeFrom": map[string]interface{}{"a": true}}, &SubscribePayload{}, PayloadError{Field: "resumeFrom.a", Problem: "must be a number"}},
+		{"missing deltas", map[string]interface{}{"docId": "a"}, &DeltaBatchPayload{}, PayloadError{Field: "deltas"}},
+		{"wrong deltas type", map[string]interface{}{"docId": "a", "deltas": "x"}, &DeltaBatchPayload{}, PayloadError{Field: "deltas", Problem: "must be an array"}},
+		{"negative deltaIndex", map[string]interface{}{"docId": "a", "deltas": []interface{}{}, "flushId": "f", "deltaIndex": -1.0}, &DeltaBatchPayload{}, PayloadError{Field: "deltaIndex", Problem: "must be 0 or more, with a flushId"}},
+		{"deltaIndex without flushId", map[string]interface{}{"docId": "a", "deltas": []interface{}{}, "deltaIndex": 2.0}, &DeltaBatchPayload{}, PayloadError{Field: "deltaIndex", Problem: "must be 0 or more, with a flushId"}},
+		{"missing state", map[string]interface{}{"docId": "a"}, &AwarenessPayload{}, PayloadError{Field: "state"}},
+		{"fractional limit", map[string]interface{}{"limit": 1.5}, &ListPayload{}, PayloadError{Field: "limit", Problem: "must be an integer"}},
+		{"wrong token type", map[string]interface{}{"token": []interface{}{}}, &AuthPayload{}, PayloadError{Field: "token", Problem: "must be a string"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := EncodeMessage(TypeDelta, tt.payload, 1000)
+			msg, err := DecodeMessage(data)
+			if err != nil {
+				t.Fatalf("DecodeMessage() error = %v", err)
+			}
+			err = UnmarshalPayload(msg, tt.v)
+			var payloadErr *PayloadError
+			if !errors.As(err, &payloadErr) || *payloadErr != tt.want {
+				t.Errorf("UnmarshalPayload() error = %v, want %v", err, &tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkEncodeMessage(b *testing.B) {
+	delta := map[string]interface{}{
+		"type":     TypeDelta,
+		"id":       "delta-1",
+		"docId":    "room:bench",
+		"seq":      int64(42),
+		"clientId": "client-1",
+		"changes":  map[string]interface{}{"title": "Quarterly planning", "body": strings.Repeat("lorem ipsum ", 40)},
+	}
+	for _, tt := range []struct {
+		name string
+		opts EncodeOptions
+	}{
+		{"json", EncodeOptions{}},
+		{"msgpack", EncodeOptions{Encoding: EncodingMsgpack}},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := EncodeMessageWith(TypeDelta, delta, 1000, tt.opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodePatchedMessage(t *testing.T) {
+	set := map[string]interface{}{"id": "server-1", "origin": "client-1", "seq": int64(7), "type": TypeDelta}
+	drop := []string{"timestamp"}
+	tests := []struct {
+		name string
+		raw  string
+		want map[string]interface{}
+	}{
+		{
+			"replaces and keeps members",
+			`{"type":"delta","id":"client-1","docId":"room:1","changes":{"a":[1,{"b":"}"}],"c":"x\\\"y"},"n":-1.5e3,"t":true}`,
+			map[string]interface{}{"type": "delta", "id": "server-1", "origin": "client-1", "seq": 7.0, "docId": "room:1", "changes": map[string]interface{}{"a": []interface{}{1.0, map[string]interface{}{"b": "}"}}, "c": "x\\\"y"}, "n": -1500.0, "t": true},
+		},
+		{
+			"drops members and tolerates whitespace",
+			" {\n \"timestamp\" : 5 , \"docId\" : \"room:1\" , \"v\":null }",
+			map[string]interface{}{"type": "delta", "id": "server-1", "origin": "client-1", "seq": 7.0, "docId": "room:1", "v": nil},
+		},
+		{"empty object", `{}`, map[string]interface{}{"type": "delta", "id": "server-1", "origin": "client-1", "seq": 7.0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, opts := range []EncodeOptions{{}, {Checksum: true}, {CompressAbove: 1}} {
+				data, ok, err := EncodePatchedMessageWith(TypeDelta, []byte(tt.raw), set, drop, 1000, opts)
+				if err != nil || !ok {
+					t.Fatalf("EncodePatchedMessageWith(%+v) = %v, %v", opts, ok, err)
+				}
+				msg, err := DecodeMessage(data)
+				if err != nil {
+					t.Fatalf("DecodeMessage() error = %v", err)
+				}
+				if msg.Type != TypeDelta || msg.Timestamp != 1000 || msg.ID != "server-1" {
+					t.Errorf("header = %s %d %s", msg.Type, msg.Timestamp, msg.ID)
+				}
+				if !reflect.DeepEqual(msg.Payload, tt.want) {
+					t.Errorf("opts %+v: payload = %v, want %v", opts, msg.Payload, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodePatchedMessage_FallsBack(t *testing.T) {
+	for _, raw := range []string{`{"i\u0064":"spelled with an escape"}`, `[1,2]`, `{"a":1`, ``} {
+		var buf bytes.Buffer
+		buf.WriteString("kept")
+		ok, err := EncodePatchedMessageTo(&buf, TypeDelta, []byte(raw), map[string]interface{}{"id": "x"}, nil, 1000, EncodeOptions{})
+		if ok || err != nil || buf.String() != "kept" {
+			t.Errorf("EncodePatchedMessageTo(%s) = %v, %v, buffer %q; want false and nothing appended", raw, ok, err, buf.String())
+		}
+	}
+	if ok, _ := EncodePatchedMessageTo(new(bytes.Buffer), TypeDelta, []byte(`{}`), nil, nil, 1000, EncodeOptions{Encoding: EncodingMsgpack}); ok {
+		t.Error("patched a payload for a MessagePack client")
+	}
+}
+
+// benchDelta is a typical forwarded delta
+func benchDelta() map[string]interface{} {
+	return map[string]interface{}{
+		"type":     TypeDelta,
+		"id":       "delta-1",
+		"docId":    "room:bench",
+		"clientId": "client-1",
+		"changes":  map[string]interface{}{"title": "Quarterly planning", "body": strings.Repeat("lorem ipsum ", 40), "done": false, "count": 12.0},
+	}
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	data, err := EncodeMessage(TypeDelta, benchDelta(), 1000)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, name := range codec.Names() {
+		b.Run(name, func(b *testing.B) {
+			if err := codec.Use(name); err != nil {
+				b.Fatal(err)
+			}
+			defer codec.Use(codec.Std)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := DecodeMessage(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncodePatchedMessage(b *testing.B) {
+	data, err := EncodeMessage(TypeDelta, benchDelta(), 1000)
+	if err != nil {
+		b.Fatal(err)
+	}
+	msg, err := DecodeMessage(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	set := map[string]interface{}{"id": "server-1", "origin": "delta-1", "seq": int64(42), "type": TypeDelta}
+	b.Run("reencoded", func(b *testing.B) {
+		payload := make(map[string]interface{}, len(msg.Payload)+3)
+		for k, v := range msg.Payload {
+			payload[k] = v
+		}
+		for k, v := range set {
+			payload[k] = v
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := EncodeMessageWith(TypeDelta, payload, 1000, EncodeOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("patched", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, ok, err := EncodePatchedMessageWith(TypeDelta, msg.RawPayload(), set, nil, 1000, EncodeOptions{}); !ok || err != nil {
+				b.Fatal(ok, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// StampServerSeq returns a copy of frame, a binary message or batch
+// envelope, carrying the sequence number seq under FlagServerSeq:
+// [type:1][timestamp:8][payload_len|0x80000000:4][flags:1][crc32c:4, with FlagChecksum][seq:8][payload]
+// Frames may be shared between clients, so frame itself is not changed. A
+// frame already stamped has its number replaced.
+func StampServerSeq(frame []byte, seq uint64) ([]byte, error) {
+	if len(frame) < 13 {
+		return nil, fmt.Errorf("frame too short: %d bytes", len(frame))
+	}
+	payloadLen := binary.BigEndian.Uint32(frame[9:13])
+	if payloadLen&extendedHeader == 0 {
+		stamped := make([]byte, len(frame)+9)
+		copy(stamped, frame[:13])
+		binary.BigEndian.PutUint32(stamped[9:13], payloadLen|extendedHeader)
+		stamped[13] = FlagServerSeq
+		binary.BigEndian.PutUint64(stamped[14:22], seq)
+		copy(stamped[22:], frame[13:])
+		return stamped, nil
+	}
+
+	at, ok := serverSeqOffset(frame)
+	if !ok {
+		return nil, fmt.Errorf("frame too short: %d bytes", len(frame))
+	}
+	if frame[13]&FlagServerSeq != 0 {
+		stamped := append([]byte(nil), frame...)
+		binary.BigEndian.PutUint64(stamped[at:], seq)
+		return stamped, nil
+	}
+	stamped := make([]byte, len(frame)+8)
+	copy(stamped, frame[:at])
+	stamped[13] |= FlagServerSeq
+	binary.BigEndian.PutUint64(stamped[at:at+8], seq)
+	copy(stamped[at+8:], frame[at:])
+	return stamped, nil
+}
+
+// ServerSeq returns the sequence number StampServerSeq gave frame, if it
+// has one
+func ServerSeq(frame []byte) (uint64, bool) {
+	if len(frame) < 14 || binary.BigEndian.Uint32(frame[9:13])&extendedHeader == 0 || frame[13]&FlagServerSeq == 0 {
+		return 0, false
+	}
+	at, ok := serverSeqOffset(frame)
+	if !ok || len(frame) < at+8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(frame[at : at+8]), true
+}
+
+// serverSeqOffset is where the sequence number of an extended frame goes:
+// after the flags byte and checksum
+func serverSeqOffset(frame []byte) (int, bool) {
+	if len(frame) < 14 {
+		return 0, false
+	}
+	at := 14
+	if frame[13]&FlagChecksum != 0 {
+		at += 4
+	}
+	return at, len(frame) >= at
+}
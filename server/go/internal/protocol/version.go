@@ -0,0 +1,22 @@
+package protocol
+
+// Protocol versions a client may ask for in its auth message. Version 1 is
+// the protocol before negotiation, assumed when a client does not say;
+// version 2 adds capability negotiation.
+const (
+	MinProtocolVersion = 1
+	ProtocolVersion    = 2
+)
+
+// Capabilities a client may list in its auth message. The server accepts
+// the ones it supports and reports them in auth_success.
+const (
+	CapabilityCompression   = "compression"   // Deflate payloads above the server's threshold
+	CapabilityMsgpack       = "msgpack"       // MessagePack payloads
+	CapabilityResume        = "resume"        // Sequence numbers, and resuming subscriptions from them
+	CapabilityBatching      = "batching"      // delta_batch messages
+	CapabilityChecksum      = "checksum"      // CRC32C payload checksums in binary envelopes
+	CapabilityServerSeq     = "serverSeq"     // Per-connection frame sequence numbers, revealing dropped frames
+	CapabilityAwarenessDiff = "awarenessDiff" // Awareness updates as the fields that changed
+	CapabilityHeartbeat     = "heartbeat"     // Periodic heartbeat messages with server status
+)
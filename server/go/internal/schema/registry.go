@@ -0,0 +1,148 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Validation modes
+const (
+	ModeState   = "state"   // Check the document as the delta would leave it
+	ModeChanges = "changes" // Check only the fields the delta changes
+)
+
+// Registry holds the schemas of document ID prefixes. A document is checked
+// against the schema of the longest prefix its ID starts with; documents
+// matching none are not checked. A nil Registry checks nothing. Safe for
+// concurrent use.
+type Registry struct {
+	mode    string
+	mu      sync.RWMutex
+	schemas map[string]*Schema // Prefix -> schema
+}
+
+// NewRegistry returns an empty registry validating in mode, ModeState or
+// ModeChanges
+func NewRegistry(mode string) (*Registry, error) {
+	if mode != ModeState && mode != ModeChanges {
+		return nil, fmt.Errorf("validation mode must be %s or %s (got %q)", ModeState, ModeChanges, mode)
+	}
+	return &Registry{mode: mode, schemas: make(map[string]*Schema)}, nil
+}
+
+// LoadFile reads a JSON object mapping document ID prefixes to schemas
+// into a registry validating in mode
+func LoadFile(path, mode string) (*Registry, error) {
+	r, err := NewRegistry(mode)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: must be an object of document ID prefixes to schemas: %w", path, err)
+	}
+	for prefix, data := range raw {
+		if prefix == "" {
+			return nil, fmt.Errorf("%s: prefixes must not be empty", path)
+		}
+		s, err := Compile(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: prefix %q: %w", path, prefix, err)
+		}
+		r.Set(prefix, s)
+	}
+	return r, nil
+}
+
+// Mode is how deltas are validated
+func (r *Registry) Mode() string {
+	return r.mode
+}
+
+// Set registers the schema of a prefix, replacing any it had
+func (r *Registry) Set(prefix string, s *Schema) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas[prefix] = s
+}
+
+// Remove drops the schema of a prefix, reporting whether it had one
+func (r *Registry) Remove(prefix string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.schemas[prefix]
+	delete(r.schemas, prefix)
+	return ok
+}
+
+// Get returns the schema registered for exactly prefix, or nil
+func (r *Registry) Get(prefix string) *Schema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.schemas[prefix]
+}
+
+// All returns the registered schemas by prefix
+func (r *Registry) All() map[string]*Schema {
+	if r == nil {
+		return map[string]*Schema{}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]*Schema, len(r.schemas))
+	for prefix, s := range r.schemas {
+		out[prefix] = s
+	}
+	return out
+}
+
+// Match returns the schema for a document and the prefix it is registered
+// under, or nil when none applies
+func (r *Registry) Match(docID string) (*Schema, string) {
+	if r == nil {
+		return nil, ""
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var best string
+	var match *Schema
+	for prefix, s := range r.schemas {
+		if strings.HasPrefix(docID, prefix) && len(prefix) > len(best) {
+			best, match = prefix, s
+		}
+	}
+	return match, best
+}
+
+// Validate checks a delta's changes to a document whose current state is
+// doc (nil if it does not exist yet). In ModeState the state the changes
+// would leave is checked, nil values removing fields; in ModeChanges only
+// the changed fields are.
+func (r *Registry) Validate(docID string, doc, changes map[string]interface{}) []FieldError {
+	s, _ := r.Match(docID)
+	if s == nil {
+		return nil
+	}
+	if r.mode == ModeChanges {
+		return s.ValidateChanges(changes)
+	}
+	candidate := make(map[string]interface{}, len(doc)+len(changes))
+	for k, v := range doc {
+		candidate[k] = v
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(candidate, k)
+		} else {
+			candidate[k] = v
+		}
+	}
+	return s.Validate(candidate)
+}
@@ -0,0 +1,527 @@
+// Package schema checks documents against JSON Schemas registered for
+// document ID prefixes. It supports the keywords documents are usually
+// described with: type, enum, const, required, properties,
+// additionalProperties, items, the length, size and range limits, and
+// pattern. Annotations such as title and description are ignored; any other
+// keyword, $ref and the combinators included, is refused when the schema is
+// compiled rather than silently skipped.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldError is one way a document breaks its schema
+type FieldError struct {
+	Field   string // Top-level field, empty for the document itself
+	Path    string // JSON pointer to the offending value, e.g. /tags/0
+	Keyword string // Schema keyword broken, e.g. type, enum or required
+	Message string
+}
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	raw  json.RawMessage
+	root *node
+}
+
+// node is one schema object
+type node struct {
+	types      []string
+	enum       []interface{}
+	constant   interface{}
+	hasConst   bool
+	required   []string
+	properties map[string]*node
+
+	// additionalProperties: allowed when both are unset, refused when
+	// noAdditional is set, checked against additional otherwise
+	additional   *node
+	noAdditional bool
+
+	items *node
+
+	minLength, maxLength *int
+	minItems, maxItems   *int
+	minimum, maximum     *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	pattern              *regexp.Regexp
+}
+
+// annotations are keywords that describe a schema without constraining it
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true,
+	"description": true, "default": true, "examples": true, "format": true,
+	"readOnly": true, "writeOnly": true, "deprecated": true,
+}
+
+var jsonTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Compile parses a JSON Schema, which must be an object
+func Compile(data []byte) (*Schema, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	root, err := compileNode(v, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{raw: append(json.RawMessage(nil), data...), root: root}, nil
+}
+
+// MarshalJSON returns the schema as it was compiled
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	return s.raw, nil
+}
+
+func compileNode(v interface{}, at string) (*node, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object", where(at))
+	}
+	n := &node{}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value := obj[k]
+		path := at + "/" + k
+		var err error
+		switch k {
+		case "type":
+			n.types, err = compileTypes(value)
+		case "enum":
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				err = fmt.Errorf("must be a non-empty array")
+			}
+			for _, item := range list {
+				n.enum = append(n.enum, normalize(item))
+			}
+		case "const":
+			n.constant, n.hasConst = normalize(value), true
+		case "required":
+			n.required, err = compileStrings(value)
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				err = fmt.Errorf("must be an object")
+				break
+			}
+			n.properties = make(map[string]*node, len(props))
+			for name, prop := range props {
+				if n.properties[name], err = compileNode(prop, path+"/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "additionalProperties":
+			if allowed, ok := value.(bool); ok {
+				n.noAdditional = !allowed
+			} else if n.additional, err = compileNode(value, path); err != nil {
+				return nil, err
+			}
+		case "items":
+			if n.items, err = compileNode(value, path); err != nil {
+				return nil, err
+			}
+		case "minLength":
+			n.minLength, err = compileCount(value)
+		case "maxLength":
+			n.maxLength, err = compileCount(value)
+		case "minItems":
+			n.minItems, err = compileCount(value)
+		case "maxItems":
+			n.maxItems, err = compileCount(value)
+		case "minimum":
+			n.minimum, err = compileNumber(value)
+		case "maximum":
+			n.maximum, err = compileNumber(value)
+		case "exclusiveMinimum":
+			n.exclusiveMinimum, err = compileNumber(value)
+		case "exclusiveMaximum":
+			n.exclusiveMaximum, err = compileNumber(value)
+		case "pattern":
+			s, ok := value.(string)
+			if !ok {
+				err = fmt.Errorf("must be a string")
+				break
+			}
+			n.pattern, err = regexp.Compile(s)
+		default:
+			if !annotations[k] {
+				err = fmt.Errorf("unsupported keyword")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", where(path), err)
+		}
+	}
+	return n, nil
+}
+
+// where names a position in a schema for compile errors
+func where(at string) string {
+	if at == "" {
+		return "schema"
+	}
+	return "schema " + at
+}
+
+func compileTypes(v interface{}) ([]string, error) {
+	names, err := compileStrings(v)
+	if s, ok := v.(string); ok {
+		names, err = []string{s}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if !jsonTypes[name] {
+			return nil, fmt.Errorf("unknown type %q", name)
+		}
+	}
+	return names, nil
+}
+
+func compileStrings(v interface{}) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+	out := make([]string, len(list))
+	for i, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+func compileCount(v interface{}) (*int, error) {
+	f, ok := number(normalize(v))
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return nil, fmt.Errorf("must be a non-negative integer")
+	}
+	n := int(f)
+	return &n, nil
+}
+
+func compileNumber(v interface{}) (*float64, error) {
+	f, ok := number(normalize(v))
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &f, nil
+}
+
+// normalize turns the json.Numbers of a decoded schema into float64, as
+// document values are decoded
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := strconv.ParseFloat(string(v), 64)
+		return f
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalize(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = normalize(item)
+		}
+		return out
+	}
+	return v
+}
+
+// Validate checks a whole document, returning its errors sorted by path
+func (s *Schema) Validate(doc map[string]interface{}) []FieldError {
+	var errs []FieldError
+	s.root.check(doc, "", &errs)
+	sortErrors(errs)
+	return errs
+}
+
+// ValidateChanges checks only the fields a delta changes: each value
+// against its property's schema, and each removal (a nil value) against
+// the document's required fields. Keywords about the document as a whole
+// are not checked.
+func (s *Schema) ValidateChanges(changes map[string]interface{}) []FieldError {
+	var errs []FieldError
+	required := make(map[string]bool, len(s.root.required))
+	for _, field := range s.root.required {
+		required[field] = true
+	}
+	for field, value := range changes {
+		path := "/" + escape(field)
+		if value == nil {
+			if required[field] {
+				errs = append(errs, FieldError{Field: field, Path: path, Keyword: "required", Message: "is required"})
+			}
+			continue
+		}
+		s.root.checkProperty(field, value, path, &errs)
+	}
+	sortErrors(errs)
+	return errs
+}
+
+func sortErrors(errs []FieldError) {
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Path != errs[j].Path {
+			return errs[i].Path < errs[j].Path
+		}
+		return errs[i].Keyword < errs[j].Keyword
+	})
+}
+
+// escape encodes a property name as a JSON pointer segment
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// fieldOf is the top-level field a JSON pointer is under
+func fieldOf(path string) string {
+	if path == "" {
+		return ""
+	}
+	segment := strings.SplitN(path[1:], "/", 2)[0]
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+}
+
+func fail(errs *[]FieldError, path, keyword, format string, args ...interface{}) {
+	*errs = append(*errs, FieldError{
+		Field:   fieldOf(path),
+		Path:    path,
+		Keyword: keyword,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// check validates v at path, appending what is wrong with it
+func (n *node) check(v interface{}, path string, errs *[]FieldError) {
+	if len(n.types) > 0 && !hasType(v, n.types) {
+		fail(errs, path, "type", "must be %s", describeTypes(n.types))
+		return
+	}
+	if n.hasConst && !equal(v, n.constant) {
+		fail(errs, path, "const", "must be %s", encode(n.constant))
+	}
+	if len(n.enum) > 0 {
+		found := false
+		for _, allowed := range n.enum {
+			if equal(v, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options := make([]string, len(n.enum))
+			for i, allowed := range n.enum {
+				options[i] = encode(allowed)
+			}
+			fail(errs, path, "enum", "must be one of %s", strings.Join(options, ", "))
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		length := len([]rune(v))
+		if n.minLength != nil && length < *n.minLength {
+			fail(errs, path, "minLength", "must be at least %d characters", *n.minLength)
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			fail(errs, path, "maxLength", "must be at most %d characters", *n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v) {
+			fail(errs, path, "pattern", "must match %s", n.pattern)
+		}
+
+	case []interface{}:
+		if n.minItems != nil && len(v) < *n.minItems {
+			fail(errs, path, "minItems", "must have at least %d items", *n.minItems)
+		}
+		if n.maxItems != nil && len(v) > *n.maxItems {
+			fail(errs, path, "maxItems", "must have at most %d items", *n.maxItems)
+		}
+		if n.items != nil {
+			for i, item := range v {
+				n.items.check(item, path+"/"+strconv.Itoa(i), errs)
+			}
+		}
+
+	case map[string]interface{}:
+		for _, field := range n.required {
+			if _, ok := v[field]; !ok {
+				fail(errs, path+"/"+escape(field), "required", "is required")
+			}
+		}
+		for field, value := range v {
+			n.checkProperty(field, value, path+"/"+escape(field), errs)
+		}
+
+	default:
+		if f, ok := number(v); ok {
+			if n.minimum != nil && f < *n.minimum {
+				fail(errs, path, "minimum", "must be at least %v", *n.minimum)
+			}
+			if n.maximum != nil && f > *n.maximum {
+				fail(errs, path, "maximum", "must be at most %v", *n.maximum)
+			}
+			if n.exclusiveMinimum != nil && f <= *n.exclusiveMinimum {
+				fail(errs, path, "exclusiveMinimum", "must be greater than %v", *n.exclusiveMinimum)
+			}
+			if n.exclusiveMaximum != nil && f >= *n.exclusiveMaximum {
+				fail(errs, path, "exclusiveMaximum", "must be less than %v", *n.exclusiveMaximum)
+			}
+		}
+	}
+}
+
+// checkProperty validates the value of an object's property
+func (n *node) checkProperty(field string, value interface{}, path string, errs *[]FieldError) {
+	if prop := n.properties[field]; prop != nil {
+		prop.check(value, path, errs)
+		return
+	}
+	switch {
+	case n.noAdditional:
+		fail(errs, path, "additionalProperties", "is not allowed")
+	case n.additional != nil:
+		n.additional.check(value, path, errs)
+	}
+}
+
+// hasType reports whether v is one of the JSON types
+func hasType(v interface{}, types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "object":
+			if _, ok := v.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "null":
+			if v == nil {
+				return true
+			}
+		case "number":
+			if _, ok := number(v); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := number(v); ok && f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func describeTypes(types []string) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		switch t {
+		case "object", "array", "integer":
+			names[i] = "an " + t
+		case "null":
+			names[i] = "null"
+		default:
+			names[i] = "a " + t
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+// number reads a numeric document value
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// equal compares JSON values, numbers by value
+func equal(a, b interface{}) bool {
+	if fa, ok := number(a); ok {
+		fb, ok := number(b)
+		return ok && fa == fb
+	}
+	switch a := a.(type) {
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			other, ok := b[k]
+			if !ok || !equal(v, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func encode(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
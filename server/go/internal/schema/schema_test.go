@@ -0,0 +1,177 @@
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const orderSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"title": "Order",
+	"type": "object",
+	"required": ["status", "total"],
+	"properties": {
+		"status": {"enum": ["open", "paid", "shipped"]},
+		"total": {"type": "number", "minimum": 0},
+		"note": {"type": "string", "maxLength": 5},
+		"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}},
+		"address": {
+			"type": "object",
+			"required": ["city"],
+			"properties": {"city": {"type": "string"}},
+			"additionalProperties": false
+		}
+	},
+	"additionalProperties": {"type": ["string", "integer"]}
+}`
+
+func compile(t *testing.T, data string) *Schema {
+	t.Helper()
+	s, err := Compile([]byte(data))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	return s
+}
+
+// describe renders errors as "path keyword" for comparison
+func describe(errs []FieldError) string {
+	out := make([]string, len(errs))
+	for i, err := range errs {
+		out[i] = err.Path + " " + err.Keyword
+	}
+	return strings.Join(out, ", ")
+}
+
+func TestValidate(t *testing.T) {
+	s := compile(t, orderSchema)
+	for _, tc := range []struct {
+		name string
+		doc  map[string]interface{}
+		want string
+	}{
+		{"valid", map[string]interface{}{"status": "open", "total": 12.5, "tags": []interface{}{"gift"}, "count": 3.0}, ""},
+		{"missing required", map[string]interface{}{"status": "open"}, "/total required"},
+		{"wrong type", map[string]interface{}{"status": "open", "total": "12"}, "/total type"},
+		{"not in enum", map[string]interface{}{"status": "lost", "total": 1.0}, "/status enum"},
+		{"below minimum", map[string]interface{}{"status": "paid", "total": -1.0}, "/total minimum"},
+		{"too long", map[string]interface{}{"status": "paid", "total": 1.0, "note": "fragile"}, "/note maxLength"},
+		{"array items", map[string]interface{}{"status": "paid", "total": 1.0, "tags": []interface{}{"ok", "Not OK", 3.0}}, "/tags/1 pattern, /tags/2 type"},
+		{"nested object", map[string]interface{}{"status": "paid", "total": 1.0, "address": map[string]interface{}{"zip": "123"}}, "/address/city required, /address/zip additionalProperties"},
+		{"additional properties", map[string]interface{}{"status": "paid", "total": 1.0, "count": 1.5}, "/count type"},
+	} {
+		if got := describe(s.Validate(tc.doc)); got != tc.want {
+			t.Errorf("%s: errors = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	errs := s.Validate(map[string]interface{}{"status": "lost", "total": 1.0, "address": map[string]interface{}{}})
+	if len(errs) != 2 {
+		t.Fatalf("errors = %+v, want two", errs)
+	}
+	if errs[0].Field != "address" || errs[0].Message != "is required" {
+		t.Errorf("nested error = %+v, want field address, is required", errs[0])
+	}
+	if errs[1].Field != "status" || errs[1].Message != `must be one of "open", "paid", "shipped"` {
+		t.Errorf("enum error = %+v", errs[1])
+	}
+}
+
+func TestValidateChanges(t *testing.T) {
+	s := compile(t, orderSchema)
+	for _, tc := range []struct {
+		name    string
+		changes map[string]interface{}
+		want    string
+	}{
+		// Other required fields are not looked at
+		{"valid", map[string]interface{}{"note": "hi"}, ""},
+		{"wrong type", map[string]interface{}{"total": true}, "/total type"},
+		{"removes a required field", map[string]interface{}{"status": nil, "note": nil}, "/status required"},
+		{"additional property", map[string]interface{}{"count": false}, "/count type"},
+	} {
+		if got := describe(s.ValidateChanges(tc.changes)); got != tc.want {
+			t.Errorf("%s: errors = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCompile_RefusesWhatItCannotCheck(t *testing.T) {
+	for _, tc := range []struct {
+		schema, want string
+	}{
+		{`[]`, "schema must be an object"},
+		{`{"type": "text"}`, `schema /type: unknown type "text"`},
+		{`{"anyOf": [{"type": "string"}]}`, "schema /anyOf: unsupported keyword"},
+		{`{"properties": {"a": {"$ref": "#/x"}}}`, "schema /properties/a/$ref: unsupported keyword"},
+		{`{"minLength": -1}`, "must be a non-negative integer"},
+		{`{"pattern": "("}`, "schema /pattern:"},
+		{`{"enum": []}`, "must be a non-empty array"},
+		{`{`, "invalid JSON"},
+	} {
+		if _, err := Compile([]byte(tc.schema)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Compile(%s) error = %v, want %q", tc.schema, err, tc.want)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	data := `{"orders:": ` + orderSchema + `, "orders:archive:": {"type": "object"}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := LoadFile(path, ModeState)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	// The longest prefix wins, and unmatched documents are not checked
+	if _, prefix := r.Match("orders:archive:1"); prefix != "orders:archive:" {
+		t.Errorf("Match prefix = %q, want orders:archive:", prefix)
+	}
+	if errs := r.Validate("orders:archive:1", nil, map[string]interface{}{"total": "x"}); len(errs) != 0 {
+		t.Errorf("archived order errors = %+v, want none", errs)
+	}
+	if errs := r.Validate("notes:1", nil, map[string]interface{}{"total": "x"}); len(errs) != 0 {
+		t.Errorf("unmatched document errors = %+v, want none", errs)
+	}
+	var nilRegistry *Registry
+	if errs := nilRegistry.Validate("orders:1", nil, map[string]interface{}{"total": "x"}); len(errs) != 0 {
+		t.Errorf("nil registry errors = %+v, want none", errs)
+	}
+
+	// In state mode the changes are checked on top of the document
+	doc := map[string]interface{}{"status": "open", "total": 3.0}
+	if errs := r.Validate("orders:1", doc, map[string]interface{}{"status": "paid"}); len(errs) != 0 {
+		t.Errorf("valid change errors = %+v, want none", errs)
+	}
+	if got := describe(r.Validate("orders:1", doc, map[string]interface{}{"total": nil})); got != "/total required" {
+		t.Errorf("removing total errors = %q, want /total required", got)
+	}
+	if got := describe(r.Validate("orders:2", nil, map[string]interface{}{"status": "open"})); got != "/total required" {
+		t.Errorf("new document errors = %q, want /total required", got)
+	}
+
+	// In changes mode a partial first delta is fine
+	changes, _ := NewRegistry(ModeChanges)
+	changes.Set("orders:", r.Get("orders:"))
+	if errs := changes.Validate("orders:2", nil, map[string]interface{}{"status": "open"}); len(errs) != 0 {
+		t.Errorf("changes mode errors = %+v, want none", errs)
+	}
+	if !changes.Remove("orders:") || changes.Remove("orders:") {
+		t.Error("Remove did not report the schema it removed")
+	}
+
+	if _, err := NewRegistry("full"); err == nil {
+		t.Error("NewRegistry accepted an unknown mode")
+	}
+	if err := os.WriteFile(path, []byte(`{"orders:": {"oneOf": []}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path, ModeState); err == nil || !strings.Contains(err.Error(), `prefix "orders:"`) {
+		t.Errorf("LoadFile error = %v, want the bad prefix named", err)
+	}
+}
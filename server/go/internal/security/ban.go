@@ -0,0 +1,217 @@
+package security
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BanOptions configures automatic bans
+type BanOptions struct {
+	// Threshold is how many rate-limit violations within Window ban an IP
+	// (default 100)
+	Threshold int
+	// Window is the period violations are counted over (default 1 minute)
+	Window time.Duration
+	// Duration is how long automatic bans last (default 15 minutes)
+	Duration time.Duration
+}
+
+func (o BanOptions) withDefaults() BanOptions {
+	if o.Threshold <= 0 {
+		o.Threshold = 100
+	}
+	if o.Window <= 0 {
+		o.Window = time.Minute
+	}
+	if o.Duration <= 0 {
+		o.Duration = 15 * time.Minute
+	}
+	return o
+}
+
+// Ban is a banned IP
+type Ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// BanStore persists bans so they survive restarts. Expired bans may be
+// returned by Load; the BanList ignores them.
+type BanStore interface {
+	Save(ban Ban) error
+	Delete(ip string) error
+	Load() ([]Ban, error)
+}
+
+// BanList tracks banned IPs. An IP is banned automatically after
+// Threshold rate-limit violations within Window, or manually by an
+// administrator.
+type BanList struct {
+	opts       BanOptions
+	bans       map[string]Ban
+	violations map[string][]time.Time
+	lastSweep  time.Time
+	store      BanStore // Nil when bans are kept in memory only
+	onBan      func(Ban)
+	now        func() time.Time
+	mu         sync.Mutex
+}
+
+// NewBanList creates a ban list, loading any bans saved in store. store may
+// be nil.
+func NewBanList(opts BanOptions, store BanStore) *BanList {
+	bl := &BanList{
+		opts:       opts.withDefaults(),
+		bans:       make(map[string]Ban),
+		violations: make(map[string][]time.Time),
+		store:      store,
+		now:        time.Now,
+	}
+
+	if store != nil {
+		bans, err := store.Load()
+		if err != nil {
+			slog.Warn("Failed to load saved bans", "err", err)
+		}
+		now := bl.now()
+		for _, ban := range bans {
+			if ban.Until.After(now) {
+				bl.bans[ban.IP] = ban
+			}
+		}
+	}
+	return bl
+}
+
+// OnBan registers fn to be called whenever an IP is banned, e.g. to close
+// its existing connections. fn must not call back into the BanList.
+func (bl *BanList) OnBan(fn func(Ban)) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.onBan = fn
+}
+
+// IsBanned reports whether ip is currently banned
+func (bl *BanList) IsBanned(ip string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	return bl.activeBan(ip, bl.now())
+}
+
+// activeBan reports whether ip is banned at now, forgetting an expired ban.
+// Must be called with mu held.
+func (bl *BanList) activeBan(ip string, now time.Time) bool {
+	ban, ok := bl.bans[ip]
+	if !ok {
+		return false
+	}
+	if !ban.Until.After(now) {
+		delete(bl.bans, ip)
+		return false
+	}
+	return true
+}
+
+// RecordViolation notes that ip hit a rate limit and reports whether this
+// violation got it banned
+func (bl *BanList) RecordViolation(ip string) bool {
+	bl.mu.Lock()
+	now := bl.now()
+	bl.sweep(now)
+	if bl.activeBan(ip, now) {
+		bl.mu.Unlock()
+		return false
+	}
+
+	recent := pruneBefore(bl.violations[ip], now.Add(-bl.opts.Window))
+	recent = append(recent, now)
+	if len(recent) < bl.opts.Threshold {
+		bl.violations[ip] = recent
+		bl.mu.Unlock()
+		return false
+	}
+	delete(bl.violations, ip)
+	bl.mu.Unlock()
+
+	bl.Ban(ip, bl.opts.Duration, "Rate limit exceeded repeatedly")
+	return true
+}
+
+// sweep forgets violations older than the window, at most once per window.
+// Must be called with mu held.
+func (bl *BanList) sweep(now time.Time) {
+	if now.Sub(bl.lastSweep) < bl.opts.Window {
+		return
+	}
+	bl.lastSweep = now
+	cutoff := now.Add(-bl.opts.Window)
+	for ip, times := range bl.violations {
+		if recent := pruneBefore(times, cutoff); len(recent) == 0 {
+			delete(bl.violations, ip)
+		} else {
+			bl.violations[ip] = recent
+		}
+	}
+}
+
+// pruneBefore drops the timestamps before cutoff from times, which is sorted
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := sort.Search(len(times), func(i int) bool { return times[i].After(cutoff) })
+	return times[i:]
+}
+
+// Ban bans ip for duration, replacing any existing ban
+func (bl *BanList) Ban(ip string, duration time.Duration, reason string) Ban {
+	bl.mu.Lock()
+	ban := Ban{IP: ip, Reason: reason, Until: bl.now().Add(duration)}
+	bl.bans[ip] = ban
+	delete(bl.violations, ip)
+	onBan := bl.onBan
+	bl.mu.Unlock()
+
+	slog.Warn("Banned IP", "ip", ip, "until", ban.Until.Format(time.RFC3339), "reason", reason)
+	if bl.store != nil {
+		if err := bl.store.Save(ban); err != nil {
+			slog.Warn("Failed to save ban", "ip", ip, "err", err)
+		}
+	}
+	if onBan != nil {
+		onBan(ban)
+	}
+	return ban
+}
+
+// Unban lifts a ban and reports whether ip was banned
+func (bl *BanList) Unban(ip string) bool {
+	bl.mu.Lock()
+	banned := bl.activeBan(ip, bl.now())
+	delete(bl.bans, ip)
+	delete(bl.violations, ip)
+	bl.mu.Unlock()
+
+	if bl.store != nil {
+		if err := bl.store.Delete(ip); err != nil {
+			slog.Warn("Failed to delete saved ban", "ip", ip, "err", err)
+		}
+	}
+	return banned
+}
+
+// List returns the active bans, sorted by IP
+func (bl *BanList) List() []Ban {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	now := bl.now()
+	bans := make([]Ban, 0, len(bl.bans))
+	for ip, ban := range bl.bans {
+		if bl.activeBan(ip, now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+	return bans
+}
@@ -0,0 +1,116 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBanList_BansAfterRepeatedViolations(t *testing.T) {
+	bl := NewBanList(BanOptions{Threshold: 3, Window: time.Minute, Duration: time.Hour}, nil)
+	var banned []Ban
+	bl.OnBan(func(ban Ban) { banned = append(banned, ban) })
+
+	ip := "10.4.0.1"
+	for i := 0; i < 2; i++ {
+		if bl.RecordViolation(ip) {
+			t.Fatalf("violation %d banned the IP, want ban on the 3rd", i+1)
+		}
+	}
+	if bl.IsBanned(ip) {
+		t.Fatal("Should not ban below the threshold")
+	}
+
+	if !bl.RecordViolation(ip) {
+		t.Fatal("3rd violation should ban the IP")
+	}
+	if !bl.IsBanned(ip) {
+		t.Error("IP should be banned")
+	}
+	if len(banned) != 1 || banned[0].IP != ip {
+		t.Errorf("OnBan calls = %v, want one for %s", banned, ip)
+	}
+	if bl.IsBanned("10.4.0.2") {
+		t.Error("Other IPs should be unaffected")
+	}
+}
+
+func TestBanList_ViolationsOutsideWindowDoNotCount(t *testing.T) {
+	bl := NewBanList(BanOptions{Threshold: 3, Window: time.Minute}, nil)
+	now := time.Now()
+	bl.now = func() time.Time { return now }
+
+	ip := "10.4.0.3"
+	bl.RecordViolation(ip)
+	bl.RecordViolation(ip)
+
+	now = now.Add(2 * time.Minute)
+	if bl.RecordViolation(ip) {
+		t.Error("Violations from an earlier window should not count")
+	}
+}
+
+func TestBanList_BansExpire(t *testing.T) {
+	bl := NewBanList(BanOptions{}, nil)
+	now := time.Now()
+	bl.now = func() time.Time { return now }
+
+	ip := "10.4.0.4"
+	bl.Ban(ip, time.Minute, "test")
+	if !bl.IsBanned(ip) {
+		t.Fatal("IP should be banned")
+	}
+
+	now = now.Add(61 * time.Second)
+	if bl.IsBanned(ip) {
+		t.Error("Ban should have expired")
+	}
+	if bans := bl.List(); len(bans) != 0 {
+		t.Errorf("List() = %v, want no bans", bans)
+	}
+}
+
+func TestBanList_Unban(t *testing.T) {
+	bl := NewBanList(BanOptions{}, nil)
+	bl.Ban("10.4.0.5", time.Hour, "test")
+	bl.Ban("10.4.0.6", time.Hour, "test")
+
+	if !bl.Unban("10.4.0.5") {
+		t.Error("Unban should report the IP was banned")
+	}
+	if bl.IsBanned("10.4.0.5") {
+		t.Error("IP should no longer be banned")
+	}
+	if bl.Unban("10.4.0.5") {
+		t.Error("Second Unban should report the IP was not banned")
+	}
+
+	bans := bl.List()
+	if len(bans) != 1 || bans[0].IP != "10.4.0.6" {
+		t.Errorf("List() = %v, want only 10.4.0.6", bans)
+	}
+}
+
+func TestBanList_PersistsToRedis(t *testing.T) {
+	mr, client := newRedis(t)
+	store := NewRedisBanStore(client(), RedisLimiterOptions{})
+
+	bl := NewBanList(BanOptions{}, store)
+	bl.Ban("10.4.0.7", time.Hour, "abuse")
+	bl.Ban("10.4.0.8", time.Hour, "abuse")
+	bl.Unban("10.4.0.8")
+
+	// A restarted server picks the ban up
+	restarted := NewBanList(BanOptions{}, NewRedisBanStore(client(), RedisLimiterOptions{}))
+	if !restarted.IsBanned("10.4.0.7") {
+		t.Error("Ban should survive a restart")
+	}
+	if restarted.IsBanned("10.4.0.8") {
+		t.Error("Lifted ban should not come back")
+	}
+
+	// Saved bans expire with the ban
+	mr.FastForward(2 * time.Hour)
+	if bans, err := store.Load(); err != nil || len(bans) != 0 {
+		t.Errorf("Load() after expiry = %v, %v; want none", bans, err)
+	}
+}
@@ -3,13 +3,18 @@
 package security
 
 import (
+	"fmt"
 	"regexp"
 	"sync"
 	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/metrics"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
 )
 
-// SecurityLimits matches TypeScript SECURITY_LIMITS
-var SecurityLimits = struct {
+// Limits are the security limits a server enforces. Matches TypeScript
+// SECURITY_LIMITS; DefaultLimits returns the same values.
+type Limits struct {
 	MaxConnectionsPerIP  int
 	MaxMessagesPerMinute int
 	MaxBlocksPerDoc      int
@@ -18,52 +23,150 @@ var SecurityLimits = struct {
 	MaxDocsPerIP         int
 	MaxDocsPerHour       int
 	MaxMessageSize       int
-	PlaygroundDocID      string
-}{
-	MaxConnectionsPerIP:  50,
-	MaxMessagesPerMinute: 500,
-	MaxBlocksPerDoc:      1000,
-	MaxBlockSize:         10_000,    // 10KB
-	MaxDocSize:           10_485_760, // 10MB
-	MaxDocsPerIP:         20,
-	MaxDocsPerHour:       10,
-	MaxMessageSize:       2_000_000, // 2MB
-	PlaygroundDocID:      "playground",
-}
-
-// ValidMessageTypes lists valid client-sendable message types (server-only types excluded)
-var ValidMessageTypes = map[string]bool{
-	"connect":             true,
-	"auth":                true,
-	"subscribe":           true,
-	"unsubscribe":         true,
-	"sync_request":        true,
-	"sync_step1":          true,
-	"delta":               true,
-	"delta_batch":         true,
-	"ack":                 true,
-	"awareness_update":    true,
-	"awareness_subscribe": true,
-	"snapshot_request":    true,
-	"snapshot_upload":     true,
-	"ping":                true,
-	"pong":                true,
+
+	// Connections per IP that have not authenticated yet; lower than
+	// MaxConnectionsPerIP so idle handshakes cannot use up every slot
+	MaxUnauthenticatedPerIP int
+
+	// Messages per minute from each IP's anonymous read-only connections;
+	// stricter than MaxMessagesPerMinute as they carry no identity
+	MaxAnonymousMessagesPerMinute int
+
+	MaxSubscriptionsPerConnection int
+	MaxSubscribersPerDocument     int
+	MaxPrefixSubscriptions        int
+	MaxListSubscriptions          int
+	MaxAwarenessStateSize         int
+	MaxAwarenessUpdatesPerSecond  int
+}
+
+// DefaultLimits returns the limits used when nothing is configured
+func DefaultLimits() Limits {
+	return Limits{
+		MaxConnectionsPerIP:  50,
+		MaxMessagesPerMinute: 500,
+		MaxBlocksPerDoc:      1000,
+		MaxBlockSize:         10_000,     // 10KB
+		MaxDocSize:           10_485_760, // 10MB
+		MaxDocsPerIP:         20,
+		MaxDocsPerHour:       10,
+		MaxMessageSize:       2_000_000, // 2MB
+
+		MaxUnauthenticatedPerIP:       10,
+		MaxAnonymousMessagesPerMinute: 60,
+
+		MaxSubscriptionsPerConnection: 100,
+		MaxSubscribersPerDocument:     1000,
+		MaxPrefixSubscriptions:        10,
+		MaxListSubscriptions:          10,
+		MaxAwarenessStateSize:         16_384, // 16KB
+		MaxAwarenessUpdatesPerSecond:  20,
+	}
+}
+
+type limitField struct {
+	name  string
+	value *int
+}
+
+func (l *Limits) fields() []limitField {
+	return []limitField{
+		{"MaxConnectionsPerIP", &l.MaxConnectionsPerIP},
+		{"MaxMessagesPerMinute", &l.MaxMessagesPerMinute},
+		{"MaxBlocksPerDoc", &l.MaxBlocksPerDoc},
+		{"MaxBlockSize", &l.MaxBlockSize},
+		{"MaxDocSize", &l.MaxDocSize},
+		{"MaxDocsPerIP", &l.MaxDocsPerIP},
+		{"MaxDocsPerHour", &l.MaxDocsPerHour},
+		{"MaxMessageSize", &l.MaxMessageSize},
+		{"MaxUnauthenticatedPerIP", &l.MaxUnauthenticatedPerIP},
+		{"MaxAnonymousMessagesPerMinute", &l.MaxAnonymousMessagesPerMinute},
+		{"MaxSubscriptionsPerConnection", &l.MaxSubscriptionsPerConnection},
+		{"MaxSubscribersPerDocument", &l.MaxSubscribersPerDocument},
+		{"MaxPrefixSubscriptions", &l.MaxPrefixSubscriptions},
+		{"MaxListSubscriptions", &l.MaxListSubscriptions},
+		{"MaxAwarenessStateSize", &l.MaxAwarenessStateSize},
+		{"MaxAwarenessUpdatesPerSecond", &l.MaxAwarenessUpdatesPerSecond},
+	}
+}
+
+// WithDefaults returns a copy of l with unset (zero) limits taken from
+// DefaultLimits
+func (l Limits) WithDefaults() Limits {
+	defaults := DefaultLimits()
+	fields, defaultFields := l.fields(), defaults.fields()
+	for i, f := range fields {
+		if *f.value == 0 {
+			*f.value = *defaultFields[i].value
+		}
+	}
+	return l
+}
+
+// Validate reports the first limit that is zero or negative
+func (l Limits) Validate() error {
+	for _, f := range l.fields() {
+		if *f.value <= 0 {
+			return fmt.Errorf("%s must be positive (got %d)", f.name, *f.value)
+		}
+	}
+	return nil
+}
+
+// PlaygroundDocID is the public playground document (and prefix)
+const PlaygroundDocID = "playground"
+
+// ValidMessageTypes lists valid client-sendable message types: every protocol
+// type except those only the server sends
+var ValidMessageTypes = clientMessageTypes()
+
+func clientMessageTypes() map[string]bool {
+	types := make(map[string]bool)
+	for _, name := range protocol.TypeNames() {
+		if !protocol.IsServerOnly(name) {
+			types[name] = true
+		}
+	}
+	return types
 }
 
 // DocumentIDPattern validates document IDs
 var DocumentIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_:-]+$`)
 
+// ConnectionCounter limits concurrent connections per IP. ConnectionLimiter
+// counts connections on this server; RedisConnectionLimiter shares the count
+// between servers.
+type ConnectionCounter interface {
+	CanConnect(ip string) bool
+	AddConnection(ip string)
+	RemoveConnection(ip string)
+	GetConnectionCount(ip string) int
+	Dispose()
+}
+
+// DocumentCounter limits document creation per IP. DocumentLimiter counts
+// documents created on this server; RedisDocumentLimiter shares the count
+// between servers.
+type DocumentCounter interface {
+	CanCreateDocument(ip string) (bool, string)
+	RecordDocument(ip string)
+	Dispose()
+}
+
 // ConnectionLimiter tracks connections per IP
 type ConnectionLimiter struct {
 	connections map[string]int
+	maxPerIP    int
 	mu          sync.RWMutex
 	stopCh      chan struct{}
 }
 
-// NewConnectionLimiter creates a new connection limiter
-func NewConnectionLimiter() *ConnectionLimiter {
+// NewConnectionLimiter creates a connection limiter allowing maxPerIP
+// concurrent connections from one IP
+func NewConnectionLimiter(maxPerIP int) *ConnectionLimiter {
 	cl := &ConnectionLimiter{
 		connections: make(map[string]int),
+		maxPerIP:    maxPerIP,
 		stopCh:      make(chan struct{}),
 	}
 	go cl.cleanupLoop()
@@ -101,7 +204,7 @@ func (cl *ConnectionLimiter) CanConnect(ip string) bool {
 	defer cl.mu.RUnlock()
 
 	count := cl.connections[ip]
-	return count < SecurityLimits.MaxConnectionsPerIP
+	return count < cl.maxPerIP
 }
 
 // AddConnection records a new connection from IP
@@ -135,17 +238,31 @@ func (cl *ConnectionLimiter) Dispose() {
 	close(cl.stopCh)
 }
 
-// ConnectionRateLimiter tracks messages per connection using sliding window
+// ConnectionRateLimiter limits messages per key (an IP or a user) with a
+// token bucket. A key may burst up to maxPerMinute messages; tokens refill
+// evenly at maxPerMinute per minute.
 type ConnectionRateLimiter struct {
-	messages map[string][]time.Time
-	mu       sync.RWMutex
+	buckets  map[string]*tokenBucket
+	capacity float64
+	rate     float64 // Tokens per second
+	now      func() time.Time
+	mu       sync.Mutex
 	stopCh   chan struct{}
 }
 
-// NewConnectionRateLimiter creates a new connection rate limiter
-func NewConnectionRateLimiter() *ConnectionRateLimiter {
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // When tokens was last brought up to date
+}
+
+// NewConnectionRateLimiter creates a rate limiter allowing maxPerMinute
+// messages per key
+func NewConnectionRateLimiter(maxPerMinute int) *ConnectionRateLimiter {
 	crl := &ConnectionRateLimiter{
-		messages: make(map[string][]time.Time),
+		buckets:  make(map[string]*tokenBucket),
+		capacity: float64(maxPerMinute),
+		rate:     float64(maxPerMinute) / 60,
+		now:      time.Now,
 		stopCh:   make(chan struct{}),
 	}
 	go crl.cleanupLoop()
@@ -166,57 +283,60 @@ func (crl *ConnectionRateLimiter) cleanupLoop() {
 	}
 }
 
+// cleanup drops buckets that have refilled completely; a new bucket starts
+// full, so forgetting them changes nothing
 func (crl *ConnectionRateLimiter) cleanup() {
 	crl.mu.Lock()
 	defer crl.mu.Unlock()
 
-	now := time.Now()
-	for connID, timestamps := range crl.messages {
-		recent := make([]time.Time, 0)
-		for _, ts := range timestamps {
-			if now.Sub(ts) < time.Minute {
-				recent = append(recent, ts)
-			}
-		}
-		if len(recent) == 0 {
-			delete(crl.messages, connID)
-		} else {
-			crl.messages[connID] = recent
+	now := crl.now()
+	for connID, b := range crl.buckets {
+		crl.refill(b, now)
+		if b.tokens >= crl.capacity {
+			delete(crl.buckets, connID)
 		}
 	}
 }
 
-// CanSendMessage checks if connection can send a message
-func (crl *ConnectionRateLimiter) CanSendMessage(connectionID string) bool {
-	crl.mu.RLock()
-	defer crl.mu.RUnlock()
-
-	now := time.Now()
-	timestamps := crl.messages[connectionID]
-
-	count := 0
-	for _, ts := range timestamps {
-		if now.Sub(ts) < time.Minute {
-			count++
+// refill adds the tokens earned since the bucket was last updated. Must be
+// called with mu held.
+func (crl *ConnectionRateLimiter) refill(b *tokenBucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * crl.rate
+		if b.tokens > crl.capacity {
+			b.tokens = crl.capacity
 		}
+		b.last = now
 	}
-
-	return count < SecurityLimits.MaxMessagesPerMinute
 }
 
-// RecordMessage records a message from connection
-func (crl *ConnectionRateLimiter) RecordMessage(connectionID string) {
+// Allow reports whether a connection may send another message, and if so
+// spends a token for it
+func (crl *ConnectionRateLimiter) Allow(connectionID string) bool {
 	crl.mu.Lock()
 	defer crl.mu.Unlock()
 
-	crl.messages[connectionID] = append(crl.messages[connectionID], time.Now())
+	now := crl.now()
+	b := crl.buckets[connectionID]
+	if b == nil {
+		b = &tokenBucket{tokens: crl.capacity, last: now}
+		crl.buckets[connectionID] = b
+	} else {
+		crl.refill(b, now)
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
 }
 
 // RemoveConnection removes connection tracking data
 func (crl *ConnectionRateLimiter) RemoveConnection(connectionID string) {
 	crl.mu.Lock()
 	defer crl.mu.Unlock()
-	delete(crl.messages, connectionID)
+	delete(crl.buckets, connectionID)
 }
 
 // Dispose cleans up resources
@@ -226,9 +346,11 @@ func (crl *ConnectionRateLimiter) Dispose() {
 
 // DocumentLimiter tracks document creation per IP
 type DocumentLimiter struct {
-	documents map[string]*documentData
-	mu        sync.RWMutex
-	stopCh    chan struct{}
+	documents  map[string]*documentData
+	maxPerIP   int
+	maxPerHour int
+	mu         sync.RWMutex
+	stopCh     chan struct{}
 }
 
 type documentData struct {
@@ -236,11 +358,14 @@ type documentData struct {
 	hourly []time.Time
 }
 
-// NewDocumentLimiter creates a new document limiter
-func NewDocumentLimiter() *DocumentLimiter {
+// NewDocumentLimiter creates a document limiter allowing maxPerIP documents
+// per IP in total and maxPerHour per hour
+func NewDocumentLimiter(maxPerIP, maxPerHour int) *DocumentLimiter {
 	dl := &DocumentLimiter{
-		documents: make(map[string]*documentData),
-		stopCh:    make(chan struct{}),
+		documents:  make(map[string]*documentData),
+		maxPerIP:   maxPerIP,
+		maxPerHour: maxPerHour,
+		stopCh:     make(chan struct{}),
 	}
 	go dl.cleanupLoop()
 	return dl
@@ -295,7 +420,7 @@ func (dl *DocumentLimiter) CanCreateDocument(ip string) (bool, string) {
 	}
 
 	// Check total limit
-	if data.total >= SecurityLimits.MaxDocsPerIP {
+	if data.total >= dl.maxPerIP {
 		return false, "Maximum documents per IP reached"
 	}
 
@@ -308,7 +433,7 @@ func (dl *DocumentLimiter) CanCreateDocument(ip string) (bool, string) {
 			count++
 		}
 	}
-	if count >= SecurityLimits.MaxDocsPerHour {
+	if count >= dl.maxPerHour {
 		return false, "Hourly document creation limit reached"
 	}
 
@@ -336,20 +461,131 @@ func (dl *DocumentLimiter) Dispose() {
 	close(dl.stopCh)
 }
 
-// SecurityManager centralizes all security components
+// SecurityManager centralizes all security components. Message rates and
+// document creation are limited per IP until a connection authenticates with
+// a token, then per user, so users behind one NAT do not share a budget and a
+// user cannot escape theirs by switching IPs.
 type SecurityManager struct {
-	ConnectionLimiter     *ConnectionLimiter
-	ConnectionRateLimiter *ConnectionRateLimiter
-	DocumentLimiter       *DocumentLimiter
+	Limits                Limits
+	ConnectionLimiter     ConnectionCounter
+	ConnectionRateLimiter *ConnectionRateLimiter // Keyed by IP
+	DocumentLimiter       DocumentCounter        // Keyed by IP
+	UserRateLimiter       *ConnectionRateLimiter // Keyed by verified user ID
+	UserDocumentLimiter   DocumentCounter        // Keyed by verified user ID
+	PendingAuthLimiter    *ConnectionLimiter     // Unauthenticated connections per IP
+	AnonymousRateLimiter  *ConnectionRateLimiter // Anonymous read-only connections, keyed by IP
+	Bans                  *BanList
+
+	rejections *metrics.CounterVec // By limit
+}
+
+// SecurityOptions selects the components a SecurityManager uses, e.g.
+// Redis-backed limiters shared by several servers. Nil fields get local
+// defaults.
+type SecurityOptions struct {
+	Connections   ConnectionCounter
+	Documents     DocumentCounter
+	UserDocuments DocumentCounter
+	Bans          *BanList
+	Metrics       *metrics.Registry // Receives limit rejection counts
+}
+
+// NewSecurityManager creates a security manager enforcing limits on this
+// server only. Unset limits use DefaultLimits.
+func NewSecurityManager(limits Limits) *SecurityManager {
+	return NewSecurityManagerWithOptions(limits, SecurityOptions{})
+}
+
+// NewSecurityManagerWithOptions creates a security manager enforcing limits
+// with the components in opts. Users get the same message and document
+// limits as IPs.
+func NewSecurityManagerWithOptions(limits Limits, opts SecurityOptions) *SecurityManager {
+	limits = limits.WithDefaults()
+	if opts.Connections == nil {
+		opts.Connections = NewConnectionLimiter(limits.MaxConnectionsPerIP)
+	}
+	if opts.Documents == nil {
+		opts.Documents = NewDocumentLimiter(limits.MaxDocsPerIP, limits.MaxDocsPerHour)
+	}
+	if opts.UserDocuments == nil {
+		opts.UserDocuments = NewDocumentLimiter(limits.MaxDocsPerIP, limits.MaxDocsPerHour)
+	}
+	if opts.Bans == nil {
+		opts.Bans = NewBanList(BanOptions{}, nil)
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.NewRegistry()
+	}
+	return &SecurityManager{
+		Limits:                limits,
+		ConnectionLimiter:     opts.Connections,
+		ConnectionRateLimiter: NewConnectionRateLimiter(limits.MaxMessagesPerMinute),
+		DocumentLimiter:       opts.Documents,
+		UserRateLimiter:       NewConnectionRateLimiter(limits.MaxMessagesPerMinute),
+		UserDocumentLimiter:   opts.UserDocuments,
+		PendingAuthLimiter:    NewConnectionLimiter(limits.MaxUnauthenticatedPerIP),
+		AnonymousRateLimiter:  NewConnectionRateLimiter(limits.MaxAnonymousMessagesPerMinute),
+		Bans:                  opts.Bans,
+		rejections: opts.Metrics.NewCounterVec("synckit_rate_limit_rejections_total",
+			"Connections, messages and documents refused by a limit, by limit.", "limit"),
+	}
 }
 
-// NewSecurityManager creates a new security manager
-func NewSecurityManager() *SecurityManager {
-	return &SecurityManager{
-		ConnectionLimiter:     NewConnectionLimiter(),
-		ConnectionRateLimiter: NewConnectionRateLimiter(),
-		DocumentLimiter:       NewDocumentLimiter(),
+// RecordRejection counts a request refused by the named limit, e.g.
+// "connections" for the per-IP connection cap checked at upgrade
+func (sm *SecurityManager) RecordRejection(limit string) {
+	sm.rejections.With(limit).Inc()
+}
+
+// AllowMessage spends a message from userID's budget, or from ip's when the
+// connection has no verified user
+func (sm *SecurityManager) AllowMessage(ip, userID string) bool {
+	var allowed bool
+	if userID != "" {
+		allowed = sm.UserRateLimiter.Allow(userID)
+	} else {
+		allowed = sm.ConnectionRateLimiter.Allow(ip)
+	}
+	if !allowed {
+		sm.RecordRejection("messages")
+	}
+	return allowed
+}
+
+// AllowAnonymousMessage spends a message from the budget ip's anonymous
+// read-only connections share
+func (sm *SecurityManager) AllowAnonymousMessage(ip string) bool {
+	if !sm.AnonymousRateLimiter.Allow(ip) {
+		sm.RecordRejection("anonymous_messages")
+		return false
 	}
+	return true
+}
+
+// CanCreateDocument checks userID's document quota, or ip's when the
+// connection has no verified user
+func (sm *SecurityManager) CanCreateDocument(ip, userID string) (bool, string) {
+	var allowed bool
+	var reason string
+	if userID != "" {
+		allowed, reason = sm.UserDocumentLimiter.CanCreateDocument(userID)
+	} else {
+		allowed, reason = sm.DocumentLimiter.CanCreateDocument(ip)
+	}
+	if !allowed {
+		sm.RecordRejection("documents")
+	}
+	return allowed, reason
+}
+
+// RecordDocument counts a document created by userID, or by ip when the
+// connection has no verified user
+func (sm *SecurityManager) RecordDocument(ip, userID string) {
+	if userID != "" {
+		sm.UserDocumentLimiter.RecordDocument(userID)
+		return
+	}
+	sm.DocumentLimiter.RecordDocument(ip)
 }
 
 // Dispose cleans up all resources
@@ -357,6 +593,10 @@ func (sm *SecurityManager) Dispose() {
 	sm.ConnectionLimiter.Dispose()
 	sm.ConnectionRateLimiter.Dispose()
 	sm.DocumentLimiter.Dispose()
+	sm.UserRateLimiter.Dispose()
+	sm.UserDocumentLimiter.Dispose()
+	sm.PendingAuthLimiter.Dispose()
+	sm.AnonymousRateLimiter.Dispose()
 }
 
 // ValidateMessage validates WebSocket message format
@@ -365,8 +605,14 @@ func ValidateMessage(message map[string]interface{}) (bool, string) {
 		return false, "Invalid message format"
 	}
 
-	msgType, ok := message["type"].(string)
-	if !ok || msgType == "" {
+	msgType, _ := message["type"].(string)
+	return ValidateMessageType(msgType)
+}
+
+// ValidateMessageType checks that a client may send a message type. Binary
+// messages carry their type in the envelope rather than the payload.
+func ValidateMessageType(msgType string) (bool, string) {
+	if msgType == "" {
 		return false, "Missing message type"
 	}
 
@@ -390,36 +636,3 @@ func ValidateDocumentID(docID string) (bool, string) {
 	}
 	return true, ""
 }
-
-// CanAccessDocument checks if document is publicly accessible
-func CanAccessDocument(docID string) bool {
-	// Playground documents
-	if docID == SecurityLimits.PlaygroundDocID {
-		return true
-	}
-	playgroundPrefix := SecurityLimits.PlaygroundDocID + ":"
-	if len(docID) > len(playgroundPrefix) && docID[:len(playgroundPrefix)] == playgroundPrefix {
-		return true
-	}
-
-	// Wordwall documents
-	if docID == "wordwall" {
-		return true
-	}
-	if len(docID) > 9 && docID[:9] == "wordwall:" {
-		return true
-	}
-
-	// Room documents
-	if len(docID) > 5 && docID[:5] == "room:" {
-		return true
-	}
-
-	// Page documents (timestamp IDs - 13+ digits)
-	timestampPattern := regexp.MustCompile(`^\d{13,}`)
-	if timestampPattern.MatchString(docID) {
-		return true
-	}
-
-	return false
-}
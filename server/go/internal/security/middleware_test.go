@@ -1,13 +1,25 @@
 package security
 
 import (
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// Small limits keep the tests fast and independent of the defaults
+const (
+	testMaxConnections = 3
+	testMaxMessages    = 5
 )
 
 // --- ConnectionLimiter ---
 
 func TestConnectionLimiter_AllowsWithinLimit(t *testing.T) {
-	cl := NewConnectionLimiter()
+	cl := NewConnectionLimiter(testMaxConnections)
 	defer cl.Dispose()
 
 	ip := "192.168.1.1"
@@ -22,11 +34,11 @@ func TestConnectionLimiter_AllowsWithinLimit(t *testing.T) {
 }
 
 func TestConnectionLimiter_BlocksAtLimit(t *testing.T) {
-	cl := NewConnectionLimiter()
+	cl := NewConnectionLimiter(testMaxConnections)
 	defer cl.Dispose()
 
 	ip := "192.168.1.2"
-	for i := 0; i < SecurityLimits.MaxConnectionsPerIP; i++ {
+	for i := 0; i < testMaxConnections; i++ {
 		cl.AddConnection(ip)
 	}
 
@@ -36,7 +48,7 @@ func TestConnectionLimiter_BlocksAtLimit(t *testing.T) {
 }
 
 func TestConnectionLimiter_RemoveConnection(t *testing.T) {
-	cl := NewConnectionLimiter()
+	cl := NewConnectionLimiter(testMaxConnections)
 	defer cl.Dispose()
 
 	ip := "192.168.1.3"
@@ -58,7 +70,7 @@ func TestConnectionLimiter_RemoveConnection(t *testing.T) {
 }
 
 func TestConnectionLimiter_MultipleIPs(t *testing.T) {
-	cl := NewConnectionLimiter()
+	cl := NewConnectionLimiter(testMaxConnections)
 	defer cl.Dispose()
 
 	cl.AddConnection("10.0.0.1")
@@ -75,69 +87,202 @@ func TestConnectionLimiter_MultipleIPs(t *testing.T) {
 
 // --- ConnectionRateLimiter ---
 
+// exhaust spends every token a connection has
+func exhaust(crl *ConnectionRateLimiter, connID string) {
+	for crl.Allow(connID) {
+	}
+}
+
 func TestConnectionRateLimiter_AllowsWithinLimit(t *testing.T) {
-	crl := NewConnectionRateLimiter()
+	crl := NewConnectionRateLimiter(testMaxMessages)
 	defer crl.Dispose()
 
-	connID := "conn-1"
-	if !crl.CanSendMessage(connID) {
-		t.Error("Should allow first message")
-	}
-
-	crl.RecordMessage(connID)
-	if !crl.CanSendMessage(connID) {
-		t.Error("Should allow messages within limit")
+	for i := 0; i < testMaxMessages; i++ {
+		if !crl.Allow("conn-1") {
+			t.Fatalf("Should allow message %d of %d", i+1, testMaxMessages)
+		}
 	}
 }
 
 func TestConnectionRateLimiter_BlocksAtLimit(t *testing.T) {
-	crl := NewConnectionRateLimiter()
+	crl := NewConnectionRateLimiter(testMaxMessages)
 	defer crl.Dispose()
 
-	connID := "conn-2"
-	for i := 0; i < SecurityLimits.MaxMessagesPerMinute; i++ {
-		crl.RecordMessage(connID)
+	for i := 0; i < testMaxMessages; i++ {
+		crl.Allow("conn-2")
 	}
-
-	if crl.CanSendMessage(connID) {
+	if crl.Allow("conn-2") {
 		t.Error("Should block messages at limit")
 	}
 }
 
 func TestConnectionRateLimiter_RemoveConnection(t *testing.T) {
-	crl := NewConnectionRateLimiter()
+	crl := NewConnectionRateLimiter(testMaxMessages)
 	defer crl.Dispose()
 
-	connID := "conn-3"
-	for i := 0; i < SecurityLimits.MaxMessagesPerMinute; i++ {
-		crl.RecordMessage(connID)
-	}
-
-	crl.RemoveConnection(connID)
-	if !crl.CanSendMessage(connID) {
+	exhaust(crl, "conn-3")
+	crl.RemoveConnection("conn-3")
+	if !crl.Allow("conn-3") {
 		t.Error("Should allow messages after connection removal")
 	}
 }
 
 func TestConnectionRateLimiter_IndependentConnections(t *testing.T) {
-	crl := NewConnectionRateLimiter()
+	crl := NewConnectionRateLimiter(testMaxMessages)
 	defer crl.Dispose()
 
-	// Fill up conn-a
-	for i := 0; i < SecurityLimits.MaxMessagesPerMinute; i++ {
-		crl.RecordMessage("conn-a")
-	}
+	exhaust(crl, "conn-a")
 
 	// conn-b should be unaffected
-	if !crl.CanSendMessage("conn-b") {
+	if !crl.Allow("conn-b") {
 		t.Error("Different connection should not be rate limited")
 	}
 }
 
+func TestConnectionRateLimiter_Refills(t *testing.T) {
+	crl := NewConnectionRateLimiter(60) // One token per second
+	defer crl.Dispose()
+	now := time.Unix(1_700_000_000, 0)
+	crl.now = func() time.Time { return now }
+
+	exhaust(crl, "conn-r")
+
+	now = now.Add(500 * time.Millisecond)
+	if crl.Allow("conn-r") {
+		t.Error("Should not refill a whole token in half a second")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if !crl.Allow("conn-r") {
+		t.Error("Should refill one token per second")
+	}
+	if crl.Allow("conn-r") {
+		t.Error("Should have spent the refilled token")
+	}
+
+	// Refill is capped at capacity
+	now = now.Add(time.Hour)
+	allowed := 0
+	for crl.Allow("conn-r") {
+		allowed++
+	}
+	if allowed != 60 {
+		t.Errorf("Allowed %d messages after an hour idle, want capacity 60", allowed)
+	}
+}
+
+func TestConnectionRateLimiter_CleanupDropsIdleConnections(t *testing.T) {
+	crl := NewConnectionRateLimiter(60)
+	defer crl.Dispose()
+	now := time.Unix(1_700_000_000, 0)
+	crl.now = func() time.Time { return now }
+
+	crl.Allow("idle")
+	exhaust(crl, "busy")
+	now = now.Add(2 * time.Second)
+	crl.Allow("idle")
+
+	// idle has refilled completely; busy is still 58 tokens short
+	now = now.Add(30 * time.Second)
+	crl.cleanup()
+	if _, ok := crl.buckets["idle"]; ok {
+		t.Error("Full bucket should be dropped")
+	}
+	if _, ok := crl.buckets["busy"]; !ok {
+		t.Error("Partially spent bucket should be kept")
+	}
+}
+
+func TestConnectionRateLimiter_ConcurrentAllowNeverOverspends(t *testing.T) {
+	crl := NewConnectionRateLimiter(testMaxMessages)
+	defer crl.Dispose()
+	now := time.Unix(1_700_000_000, 0)
+	crl.now = func() time.Time { return now } // No refill during the test
+
+	// With a separate check and record, several goroutines could all see a
+	// free slot before any of them recorded its message
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 10; j++ {
+				if crl.Allow("shared") {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := allowed.Load(); got != testMaxMessages {
+		t.Errorf("Allowed %d messages concurrently, want exactly %d", got, testMaxMessages)
+	}
+}
+
+// slidingWindowLimiter is the previous design, kept to benchmark against:
+// a timestamp per message, scanned on every check
+type slidingWindowLimiter struct {
+	messages map[string][]time.Time
+	max      int
+	mu       sync.RWMutex
+}
+
+func (l *slidingWindowLimiter) allow(connectionID string) bool {
+	l.mu.RLock()
+	now := time.Now()
+	count := 0
+	for _, ts := range l.messages[connectionID] {
+		if now.Sub(ts) < time.Minute {
+			count++
+		}
+	}
+	l.mu.RUnlock()
+	if count >= l.max {
+		return false
+	}
+
+	l.mu.Lock()
+	l.messages[connectionID] = append(l.messages[connectionID], now)
+	l.mu.Unlock()
+	return true
+}
+
+// Each connection sits near the default 500/minute limit, the worst case for
+// the sliding window
+func BenchmarkRateLimiter(b *testing.B) {
+	const conns = 1000
+	const perMinute = 500
+	ids := make([]string, conns)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("conn-%d", i)
+	}
+
+	b.Run("TokenBucket", func(b *testing.B) {
+		crl := NewConnectionRateLimiter(perMinute)
+		defer crl.Dispose()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			crl.Allow(ids[i%conns])
+		}
+	})
+
+	b.Run("SlidingWindow", func(b *testing.B) {
+		l := &slidingWindowLimiter{messages: make(map[string][]time.Time), max: perMinute}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.allow(ids[i%conns])
+		}
+	})
+}
+
 // --- DocumentLimiter ---
 
 func TestDocumentLimiter_AllowsWithinLimit(t *testing.T) {
-	dl := NewDocumentLimiter()
+	dl := NewDocumentLimiter(3, 3)
 	defer dl.Dispose()
 
 	allowed, reason := dl.CanCreateDocument("10.0.0.1")
@@ -147,11 +292,11 @@ func TestDocumentLimiter_AllowsWithinLimit(t *testing.T) {
 }
 
 func TestDocumentLimiter_BlocksAtTotalLimit(t *testing.T) {
-	dl := NewDocumentLimiter()
+	dl := NewDocumentLimiter(3, 100)
 	defer dl.Dispose()
 
 	ip := "10.0.0.2"
-	for i := 0; i < SecurityLimits.MaxDocsPerIP; i++ {
+	for i := 0; i < 3; i++ {
 		dl.RecordDocument(ip)
 	}
 
@@ -162,11 +307,11 @@ func TestDocumentLimiter_BlocksAtTotalLimit(t *testing.T) {
 }
 
 func TestDocumentLimiter_BlocksAtHourlyLimit(t *testing.T) {
-	dl := NewDocumentLimiter()
+	dl := NewDocumentLimiter(100, 3)
 	defer dl.Dispose()
 
 	ip := "10.0.0.3"
-	for i := 0; i < SecurityLimits.MaxDocsPerHour; i++ {
+	for i := 0; i < 3; i++ {
 		dl.RecordDocument(ip)
 	}
 
@@ -180,10 +325,10 @@ func TestDocumentLimiter_BlocksAtHourlyLimit(t *testing.T) {
 }
 
 func TestDocumentLimiter_IndependentIPs(t *testing.T) {
-	dl := NewDocumentLimiter()
+	dl := NewDocumentLimiter(100, 3)
 	defer dl.Dispose()
 
-	for i := 0; i < SecurityLimits.MaxDocsPerHour; i++ {
+	for i := 0; i < 3; i++ {
 		dl.RecordDocument("10.0.0.4")
 	}
 
@@ -196,7 +341,7 @@ func TestDocumentLimiter_IndependentIPs(t *testing.T) {
 // --- SecurityManager ---
 
 func TestSecurityManager_Creation(t *testing.T) {
-	sm := NewSecurityManager()
+	sm := NewSecurityManager(Limits{})
 	defer sm.Dispose()
 
 	if sm.ConnectionLimiter == nil {
@@ -208,6 +353,31 @@ func TestSecurityManager_Creation(t *testing.T) {
 	if sm.DocumentLimiter == nil {
 		t.Error("DocumentLimiter should not be nil")
 	}
+	if sm.Limits != DefaultLimits() {
+		t.Errorf("Limits = %+v, want defaults", sm.Limits)
+	}
+}
+
+func TestSecurityManager_EnforcesCustomLimits(t *testing.T) {
+	sm := NewSecurityManager(Limits{MaxConnectionsPerIP: 1, MaxMessagesPerMinute: 2})
+	defer sm.Dispose()
+
+	sm.ConnectionLimiter.AddConnection("10.0.0.9")
+	if sm.ConnectionLimiter.CanConnect("10.0.0.9") {
+		t.Error("Should block the second connection with MaxConnectionsPerIP = 1")
+	}
+
+	if !sm.ConnectionRateLimiter.Allow("conn-x") || !sm.ConnectionRateLimiter.Allow("conn-x") {
+		t.Error("Should allow two messages with MaxMessagesPerMinute = 2")
+	}
+	if sm.ConnectionRateLimiter.Allow("conn-x") {
+		t.Error("Should block the third message with MaxMessagesPerMinute = 2")
+	}
+
+	// Limits left unset fall back to the defaults
+	if sm.Limits.MaxDocsPerIP != DefaultLimits().MaxDocsPerIP {
+		t.Errorf("MaxDocsPerIP = %d, want default %d", sm.Limits.MaxDocsPerIP, DefaultLimits().MaxDocsPerIP)
+	}
 }
 
 // --- ValidateMessage ---
@@ -247,6 +417,41 @@ func TestValidateMessage_Invalid(t *testing.T) {
 	}
 }
 
+func TestValidateMessageType(t *testing.T) {
+	tests := []struct {
+		msgType string
+		valid   bool
+	}{
+		{"delta", true},
+		{"subscribe_list", true},
+		{"", false},
+		{"hack", false},
+		{"auth_success", false}, // Server-only
+		{"error", false},
+	}
+
+	for _, tt := range tests {
+		if valid, errMsg := ValidateMessageType(tt.msgType); valid != tt.valid {
+			t.Errorf("ValidateMessageType(%q) = %v (%s), want %v", tt.msgType, valid, errMsg, tt.valid)
+		}
+	}
+}
+
+func TestValidMessageTypes_MatchProtocol(t *testing.T) {
+	known := make(map[string]bool)
+	for _, name := range protocol.TypeNames() {
+		known[name] = true
+		if ValidMessageTypes[name] == protocol.IsServerOnly(name) {
+			t.Errorf("type %q: valid = %v, server-only = %v", name, ValidMessageTypes[name], protocol.IsServerOnly(name))
+		}
+	}
+	for name := range ValidMessageTypes {
+		if !known[name] {
+			t.Errorf("ValidMessageTypes has %q, which the protocol cannot encode", name)
+		}
+	}
+}
+
 // --- ValidateDocumentID ---
 
 func TestValidateDocumentID_Valid(t *testing.T) {
@@ -285,49 +490,92 @@ func TestValidateDocumentID_Invalid(t *testing.T) {
 	}
 }
 
-// --- CanAccessDocument ---
+// --- Per-user limits ---
 
-func TestCanAccessDocument(t *testing.T) {
-	tests := []struct {
-		docID string
-		want  bool
-	}{
-		{"playground", true},
-		{"playground:text:block-1", true},
-		{"wordwall", true},
-		{"wordwall:submissions", true},
-		{"room:abc123", true},
-		{"room:abc:text:block-1", true},
-		{"1769512101803", true},              // timestamp page ID
-		{"1769512101803:text:block-1", true}, // timestamp page child
-		{"private-doc", false},
-		{"secret", false},
+func TestSecurityManager_AllowMessageKeysByUserAfterAuth(t *testing.T) {
+	sm := NewSecurityManager(Limits{MaxMessagesPerMinute: 2})
+	defer sm.Dispose()
+
+	// Before authentication the IP's budget applies
+	sm.AllowMessage("10.0.0.1", "")
+	sm.AllowMessage("10.0.0.1", "")
+	if sm.AllowMessage("10.0.0.1", "") {
+		t.Error("IP budget should be spent")
 	}
 
-	for _, tt := range tests {
-		got := CanAccessDocument(tt.docID)
-		if got != tt.want {
-			t.Errorf("CanAccessDocument(%q) = %v, want %v", tt.docID, got, tt.want)
+	// Users behind that IP have their own budgets
+	for _, user := range []string{"alice", "bob"} {
+		if !sm.AllowMessage("10.0.0.1", user) {
+			t.Errorf("%s should not share the IP budget", user)
 		}
 	}
+
+	// A user's budget is shared across IPs
+	sm.AllowMessage("10.0.0.2", "alice")
+	if sm.AllowMessage("10.0.0.3", "alice") {
+		t.Error("alice's budget should be spent across IPs")
+	}
+}
+
+func TestSecurityManager_AnonymousMessagesHaveTheirOwnBudget(t *testing.T) {
+	sm := NewSecurityManager(Limits{MaxMessagesPerMinute: 5, MaxAnonymousMessagesPerMinute: 1})
+	defer sm.Dispose()
+
+	if !sm.AllowAnonymousMessage("10.0.0.1") {
+		t.Fatal("first anonymous message should be allowed")
+	}
+	if sm.AllowAnonymousMessage("10.0.0.1") {
+		t.Error("anonymous budget should be spent")
+	}
+	if !sm.AllowMessage("10.0.0.1", "") {
+		t.Error("connections that are not anonymous readers should keep the IP's budget")
+	}
 }
 
-// --- SecurityLimits defaults ---
+// --- Limits ---
 
-func TestSecurityLimits_Defaults(t *testing.T) {
-	if SecurityLimits.MaxConnectionsPerIP != 50 {
-		t.Errorf("MaxConnectionsPerIP = %d, want 50", SecurityLimits.MaxConnectionsPerIP)
+func TestDefaultLimits(t *testing.T) {
+	limits := DefaultLimits()
+	if limits.MaxConnectionsPerIP != 50 {
+		t.Errorf("MaxConnectionsPerIP = %d, want 50", limits.MaxConnectionsPerIP)
+	}
+	if limits.MaxMessagesPerMinute != 500 {
+		t.Errorf("MaxMessagesPerMinute = %d, want 500", limits.MaxMessagesPerMinute)
+	}
+	if limits.MaxDocsPerIP != 20 {
+		t.Errorf("MaxDocsPerIP = %d, want 20", limits.MaxDocsPerIP)
 	}
-	if SecurityLimits.MaxMessagesPerMinute != 500 {
-		t.Errorf("MaxMessagesPerMinute = %d, want 500", SecurityLimits.MaxMessagesPerMinute)
+	if limits.MaxDocsPerHour != 10 {
+		t.Errorf("MaxDocsPerHour = %d, want 10", limits.MaxDocsPerHour)
 	}
-	if SecurityLimits.MaxDocsPerIP != 20 {
-		t.Errorf("MaxDocsPerIP = %d, want 20", SecurityLimits.MaxDocsPerIP)
+	if limits.MaxMessageSize != 2_000_000 {
+		t.Errorf("MaxMessageSize = %d, want 2000000", limits.MaxMessageSize)
 	}
-	if SecurityLimits.MaxDocsPerHour != 10 {
-		t.Errorf("MaxDocsPerHour = %d, want 10", SecurityLimits.MaxDocsPerHour)
+	if err := limits.Validate(); err != nil {
+		t.Errorf("DefaultLimits().Validate() = %v", err)
+	}
+}
+
+func TestLimits_Validate(t *testing.T) {
+	zero := DefaultLimits()
+	zero.MaxDocSize = 0
+	negative := DefaultLimits()
+	negative.MaxConnectionsPerIP = -1
+
+	tests := []struct {
+		name    string
+		limits  Limits
+		wantErr bool
+	}{
+		{"defaults", DefaultLimits(), false},
+		{"zero", zero, true},
+		{"negative", negative, true},
+		{"unset", Limits{}, true},
 	}
-	if SecurityLimits.MaxMessageSize != 2_000_000 {
-		t.Errorf("MaxMessageSize = %d, want 2000000", SecurityLimits.MaxMessageSize)
+
+	for _, tt := range tests {
+		if err := tt.limits.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
 	}
 }
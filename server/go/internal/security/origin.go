@@ -0,0 +1,134 @@
+package security
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Origin policy modes
+const (
+	OriginPolicyPermissive = "permissive" // No Origin header is fine; an empty or "*" allowlist allows every origin
+	OriginPolicyStrict     = "strict"     // Only allowlisted origins; no Origin header only with AllowNonBrowser
+)
+
+// OriginRules describe which browser origins may open websockets
+type OriginRules struct {
+	Mode string // One of the OriginPolicy constants (default OriginPolicyPermissive)
+
+	// Allowed holds exact origins ("https://app.example.com") and wildcard
+	// subdomain patterns ("https://*.example.com"). "*" allows any origin
+	// and is only valid in permissive mode.
+	Allowed []string
+
+	// AllowNonBrowser lets strict mode accept requests without an Origin
+	// header, as sent by native and server-side clients
+	AllowNonBrowser bool
+}
+
+// OriginPolicy decides whether a websocket upgrade's Origin is acceptable
+type OriginPolicy struct {
+	mode            string
+	allowAny        bool
+	allowNonBrowser bool
+	exact           map[string]bool
+	wildcards       []originPattern
+}
+
+// originPattern matches scheme://<subdomain>.<suffix>
+type originPattern struct {
+	scheme string // e.g. "https://"
+	suffix string // e.g. ".example.com" or ".example.com:8443"
+}
+
+// NewOriginPolicy compiles rules, reporting an unknown mode, a malformed
+// pattern, or "*" in strict mode
+func NewOriginPolicy(rules OriginRules) (*OriginPolicy, error) {
+	p := &OriginPolicy{
+		mode:            rules.Mode,
+		allowNonBrowser: rules.AllowNonBrowser,
+		exact:           make(map[string]bool, len(rules.Allowed)),
+	}
+	switch p.mode {
+	case "":
+		p.mode = OriginPolicyPermissive
+	case OriginPolicyPermissive, OriginPolicyStrict:
+	default:
+		return nil, fmt.Errorf("unknown origin policy %q (want %s or %s)",
+			rules.Mode, OriginPolicyStrict, OriginPolicyPermissive)
+	}
+
+	for _, origin := range rules.Allowed {
+		origin = normalizeOrigin(origin)
+		switch {
+		case origin == "*":
+			if p.mode == OriginPolicyStrict {
+				return nil, fmt.Errorf("origin \"*\" is not allowed in strict mode; list origins explicitly")
+			}
+			p.allowAny = true
+		case strings.Contains(origin, "*"):
+			pattern, err := parseOriginPattern(origin)
+			if err != nil {
+				return nil, err
+			}
+			p.wildcards = append(p.wildcards, pattern)
+		default:
+			p.exact[origin] = true
+		}
+	}
+	if p.mode == OriginPolicyPermissive && len(rules.Allowed) == 0 {
+		p.allowAny = true
+	}
+	return p, nil
+}
+
+// parseOriginPattern accepts scheme://*.domain[:port] with a single leading
+// wildcard label
+func parseOriginPattern(origin string) (originPattern, error) {
+	scheme, host, found := strings.Cut(origin, "://")
+	if !found || scheme == "" || !strings.HasPrefix(host, "*.") || strings.Count(host, "*") != 1 || len(host) <= 2 {
+		return originPattern{}, fmt.Errorf("invalid origin pattern %q (want scheme://*.domain)", origin)
+	}
+	return originPattern{scheme: scheme + "://", suffix: host[1:]}, nil
+}
+
+// normalizeOrigin lower-cases an origin and drops a trailing slash
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
+
+// Mode returns the policy mode
+func (p *OriginPolicy) Mode() string {
+	return p.mode
+}
+
+// AllowsAnyOrigin reports whether every browser origin is accepted, either
+// via "*" or a permissive policy without an allowlist
+func (p *OriginPolicy) AllowsAnyOrigin() bool {
+	return p.allowAny
+}
+
+// Allows reports whether an upgrade carrying origin ("" when the header is
+// missing) is acceptable
+func (p *OriginPolicy) Allows(origin string) bool {
+	if origin == "" {
+		return p.mode == OriginPolicyPermissive || p.allowNonBrowser
+	}
+	if p.allowAny {
+		return true
+	}
+
+	origin = normalizeOrigin(origin)
+	if p.exact[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if !strings.HasPrefix(origin, w.scheme) || !strings.HasSuffix(origin, w.suffix) {
+			continue
+		}
+		label := origin[len(w.scheme) : len(origin)-len(w.suffix)]
+		if label != "" && !strings.ContainsAny(label, "/:@") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package security
+
+import "testing"
+
+func TestOriginPolicy_Allows(t *testing.T) {
+	allowlist := []string{"https://app.example.com", "https://*.example.org"}
+
+	tests := []struct {
+		name   string
+		rules  OriginRules
+		origin string
+		want   bool
+	}{
+		// Permissive
+		{"permissive no origin", OriginRules{Mode: OriginPolicyPermissive}, "", true},
+		{"permissive empty list", OriginRules{}, "https://evil.test", true},
+		{"permissive star", OriginRules{Allowed: []string{"*"}}, "https://evil.test", true},
+		{"permissive list match", OriginRules{Allowed: allowlist}, "https://app.example.com", true},
+		{"permissive list miss", OriginRules{Allowed: allowlist}, "https://evil.test", false},
+
+		// Strict
+		{"strict no origin", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "", false},
+		{"strict no origin non-browser", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist, AllowNonBrowser: true}, "", true},
+		{"strict empty list", OriginRules{Mode: OriginPolicyStrict}, "https://app.example.com", false},
+		{"strict exact", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "https://app.example.com", true},
+		{"strict exact case and slash", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "HTTPS://App.Example.com/", true},
+		{"strict other scheme", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "http://app.example.com", false},
+		{"strict other port", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "https://app.example.com:8443", false},
+
+		// Wildcard subdomains
+		{"wildcard subdomain", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "https://docs.example.org", true},
+		{"wildcard nested subdomain", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "https://a.b.example.org", true},
+		{"wildcard apex", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "https://example.org", false},
+		{"wildcard lookalike", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "https://evilexample.org", false},
+		{"wildcard suffix attack", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "https://example.org.evil.test", false},
+		{"wildcard scheme", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "http://docs.example.org", false},
+		{"wildcard port", OriginRules{Mode: OriginPolicyStrict, Allowed: allowlist}, "https://docs.example.org:8443", false},
+		{"wildcard with port", OriginRules{Mode: OriginPolicyStrict, Allowed: []string{"https://*.example.org:8443"}}, "https://docs.example.org:8443", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewOriginPolicy(tt.rules)
+			if err != nil {
+				t.Fatalf("NewOriginPolicy failed: %v", err)
+			}
+			if got := p.Allows(tt.origin); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOriginPolicy_RejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules OriginRules
+	}{
+		{"unknown mode", OriginRules{Mode: "lenient"}},
+		{"star in strict mode", OriginRules{Mode: OriginPolicyStrict, Allowed: []string{"*"}}},
+		{"wildcard without scheme", OriginRules{Allowed: []string{"*.example.com"}}},
+		{"wildcard in the middle", OriginRules{Allowed: []string{"https://app.*.example.com"}}},
+		{"bare wildcard host", OriginRules{Allowed: []string{"https://*."}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewOriginPolicy(tt.rules); err == nil {
+				t.Error("NewOriginPolicy should fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package security
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Public document policy modes
+const (
+	PolicyModeRules    = "rules"     // Allow documents matching IDs, Prefixes or Patterns
+	PolicyModeAllowAll = "allow_all" // Allow every document
+	PolicyModeDenyAll  = "deny_all"  // Allow no document
+)
+
+// PublicDocumentRules describe which documents clients may access at all.
+// Token permissions are checked on top of the policy.
+type PublicDocumentRules struct {
+	Mode     string   // One of the PolicyMode constants (default PolicyModeRules)
+	IDs      []string // Exact document IDs
+	Prefixes []string // Documents starting with, and longer than, a prefix
+	Patterns []string // Regular expressions matched against the document ID
+}
+
+// DefaultPublicDocumentRules returns the rules of the demo deployment:
+// playground and wordwall documents, rooms, and timestamp page IDs. Matches
+// TypeScript canAccessDocument.
+func DefaultPublicDocumentRules() PublicDocumentRules {
+	return PublicDocumentRules{
+		Mode:     PolicyModeRules,
+		IDs:      []string{PlaygroundDocID, "wordwall"},
+		Prefixes: []string{PlaygroundDocID + ":", "wordwall:", "room:"},
+		Patterns: []string{`^\d{13,}`},
+	}
+}
+
+// PublicDocumentPolicy decides whether a document is publicly accessible
+type PublicDocumentPolicy struct {
+	mode     string
+	ids      map[string]bool
+	prefixes []string
+	patterns []*regexp.Regexp
+}
+
+// NewPublicDocumentPolicy compiles rules, reporting an unknown mode or an
+// invalid pattern
+func NewPublicDocumentPolicy(rules PublicDocumentRules) (*PublicDocumentPolicy, error) {
+	p := &PublicDocumentPolicy{
+		mode:     rules.Mode,
+		ids:      make(map[string]bool, len(rules.IDs)),
+		prefixes: rules.Prefixes,
+	}
+	switch p.mode {
+	case "":
+		p.mode = PolicyModeRules
+	case PolicyModeRules, PolicyModeAllowAll, PolicyModeDenyAll:
+	default:
+		return nil, fmt.Errorf("unknown public document mode %q (want %s, %s or %s)",
+			rules.Mode, PolicyModeRules, PolicyModeAllowAll, PolicyModeDenyAll)
+	}
+
+	for _, id := range rules.IDs {
+		p.ids[id] = true
+	}
+	for _, pattern := range rules.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public document pattern %q: %w", pattern, err)
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	return p, nil
+}
+
+// DefaultPublicDocumentPolicy returns the policy for DefaultPublicDocumentRules
+func DefaultPublicDocumentPolicy() *PublicDocumentPolicy {
+	p, err := NewPublicDocumentPolicy(DefaultPublicDocumentRules())
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Allows reports whether docID is publicly accessible. The allow_all and
+// deny_all modes override the rules; otherwise an exact ID, prefix or pattern
+// match allows the document.
+func (p *PublicDocumentPolicy) Allows(docID string) bool {
+	switch p.mode {
+	case PolicyModeAllowAll:
+		return true
+	case PolicyModeDenyAll:
+		return false
+	}
+
+	if p.ids[docID] {
+		return true
+	}
+	for _, prefix := range p.prefixes {
+		if len(docID) > len(prefix) && strings.HasPrefix(docID, prefix) {
+			return true
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(docID) {
+			return true
+		}
+	}
+	return false
+}
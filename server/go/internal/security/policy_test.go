@@ -0,0 +1,82 @@
+package security
+
+import "testing"
+
+func TestDefaultPublicDocumentPolicy(t *testing.T) {
+	policy := DefaultPublicDocumentPolicy()
+
+	tests := []struct {
+		docID string
+		want  bool
+	}{
+		{"playground", true},
+		{"playground:text:block-1", true},
+		{"wordwall", true},
+		{"wordwall:submissions", true},
+		{"room:abc123", true},
+		{"room:abc:text:block-1", true},
+		{"1769512101803", true},              // timestamp page ID
+		{"1769512101803:text:block-1", true}, // timestamp page child
+		{"room:", false},                     // prefix alone
+		{"private-doc", false},
+		{"secret", false},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Allows(tt.docID); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.docID, got, tt.want)
+		}
+	}
+}
+
+func TestPublicDocumentPolicy_Precedence(t *testing.T) {
+	rules := PublicDocumentRules{
+		IDs:      []string{"lobby"},
+		Prefixes: []string{"team:"},
+		Patterns: []string{`^pub-[a-z]+$`},
+	}
+
+	tests := []struct {
+		mode  string
+		docID string
+		want  bool
+	}{
+		{PolicyModeRules, "lobby", true},
+		{PolicyModeRules, "team:docs", true},
+		{PolicyModeRules, "pub-notes", true},
+		{PolicyModeRules, "pub-123", false},
+		{PolicyModeRules, "playground", false}, // Custom rules replace the defaults
+		{"", "lobby", true},                    // Rules mode is the default
+		{PolicyModeAllowAll, "anything", true},
+		{PolicyModeDenyAll, "lobby", false}, // deny_all overrides matching rules
+	}
+
+	for _, tt := range tests {
+		rules.Mode = tt.mode
+		policy, err := NewPublicDocumentPolicy(rules)
+		if err != nil {
+			t.Fatalf("NewPublicDocumentPolicy(mode %q) error = %v", tt.mode, err)
+		}
+		if got := policy.Allows(tt.docID); got != tt.want {
+			t.Errorf("mode %q: Allows(%q) = %v, want %v", tt.mode, tt.docID, got, tt.want)
+		}
+	}
+}
+
+func TestNewPublicDocumentPolicy_RejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules PublicDocumentRules
+	}{
+		{"invalid pattern", PublicDocumentRules{Patterns: []string{`^room:(`}}},
+		{"unknown mode", PublicDocumentRules{Mode: "allow_some"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPublicDocumentPolicy(tt.rules); err == nil {
+				t.Error("NewPublicDocumentPolicy() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package security
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisLimiterOptions configures the Redis-backed limiters
+type RedisLimiterOptions struct {
+	// Prefix namespaces the limiter keys (default "synckit")
+	Prefix string
+	// ConnectionTTL is how long a connection stays counted once its server
+	// stops refreshing it, e.g. after a crash (default 1 minute)
+	ConnectionTTL time.Duration
+	// DocumentTTL is how long an IP's document total is kept after its last
+	// document (default 24 hours)
+	DocumentTTL time.Duration
+	// Timeout bounds each Redis round trip (default 500ms)
+	Timeout time.Duration
+}
+
+func (o RedisLimiterOptions) withDefaults() RedisLimiterOptions {
+	if o.Prefix == "" {
+		o.Prefix = "synckit"
+	}
+	if o.ConnectionTTL <= 0 {
+		o.ConnectionTTL = time.Minute
+	}
+	if o.DocumentTTL <= 0 {
+		o.DocumentTTL = 24 * time.Hour
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 500 * time.Millisecond
+	}
+	return o
+}
+
+// redisHealth logs when a limiter starts and stops falling back to local
+// counting, rather than once per failed command
+type redisHealth struct {
+	name string
+	down atomic.Bool
+}
+
+// ok reports whether err is nil, logging the transitions
+func (h *redisHealth) ok(err error) bool {
+	if err != nil {
+		if !h.down.Swap(true) {
+			slog.Warn("Redis unavailable, counting locally", "limiter", h.name, "err", err)
+		}
+		return false
+	}
+	if h.down.Swap(false) {
+		slog.Info("Redis available again", "limiter", h.name)
+	}
+	return true
+}
+
+// newInstanceID names this server's entries in shared sorted sets
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func unixMillis(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// RedisConnectionLimiter counts connections per IP across every server
+// sharing a Redis instance. Each connection is a member of a sorted set per
+// IP, scored by when it expires; the owning server refreshes its members, so
+// a crashed server's connections stop counting after ConnectionTTL.
+//
+// If Redis fails, connections are counted locally until it recovers.
+type RedisConnectionLimiter struct {
+	client   *redis.Client
+	opts     RedisLimiterOptions
+	maxPerIP int
+	instance string
+	seq      atomic.Uint64
+	held     map[string][]string // IP -> members this server added to Redis
+	mu       sync.Mutex
+	local    *ConnectionLimiter // Connections added while Redis was unavailable
+	health   redisHealth
+	now      func() time.Time
+	stopCh   chan struct{}
+}
+
+// NewRedisConnectionLimiter creates a connection limiter allowing maxPerIP
+// concurrent connections from one IP across all servers using client
+func NewRedisConnectionLimiter(client *redis.Client, maxPerIP int, opts RedisLimiterOptions) *RedisConnectionLimiter {
+	cl := &RedisConnectionLimiter{
+		client:   client,
+		opts:     opts.withDefaults(),
+		maxPerIP: maxPerIP,
+		instance: newInstanceID(),
+		held:     make(map[string][]string),
+		local:    NewConnectionLimiter(maxPerIP),
+		health:   redisHealth{name: "connection limiter"},
+		now:      time.Now,
+		stopCh:   make(chan struct{}),
+	}
+	go cl.refreshLoop()
+	return cl
+}
+
+func (cl *RedisConnectionLimiter) key(ip string) string {
+	return cl.opts.Prefix + ":limits:conn:" + ip
+}
+
+func (cl *RedisConnectionLimiter) refreshLoop() {
+	ticker := time.NewTicker(cl.opts.ConnectionTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cl.refresh()
+		case <-cl.stopCh:
+			return
+		}
+	}
+}
+
+// refresh pushes back the expiry of every connection this server holds
+func (cl *RedisConnectionLimiter) refresh() {
+	cl.mu.Lock()
+	held := make(map[string][]string, len(cl.held))
+	for ip, members := range cl.held {
+		held[ip] = append([]string(nil), members...)
+	}
+	cl.mu.Unlock()
+	if len(held) == 0 {
+		return
+	}
+
+	expires := float64(cl.now().Add(cl.opts.ConnectionTTL).UnixMilli())
+	ctx, cancel := context.WithTimeout(context.Background(), cl.opts.Timeout)
+	defer cancel()
+	_, err := cl.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for ip, members := range held {
+			zs := make([]redis.Z, len(members))
+			for i, m := range members {
+				zs[i] = redis.Z{Score: expires, Member: m}
+			}
+			pipe.ZAddXX(ctx, cl.key(ip), zs...)
+			pipe.PExpire(ctx, cl.key(ip), cl.opts.ConnectionTTL)
+		}
+		return nil
+	})
+	cl.health.ok(err)
+}
+
+// count returns the live connections from ip across all servers
+func (cl *RedisConnectionLimiter) count(ip string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cl.opts.Timeout)
+	defer cancel()
+
+	var card *redis.IntCmd
+	_, err := cl.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, cl.key(ip), "-inf", unixMillis(cl.now()))
+		card = pipe.ZCard(ctx, cl.key(ip))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(card.Val()), nil
+}
+
+// CanConnect checks if IP can create a new connection
+func (cl *RedisConnectionLimiter) CanConnect(ip string) bool {
+	return cl.GetConnectionCount(ip) < cl.maxPerIP
+}
+
+// AddConnection records a new connection from IP
+func (cl *RedisConnectionLimiter) AddConnection(ip string) {
+	member := fmt.Sprintf("%s:%d", cl.instance, cl.seq.Add(1))
+	expires := float64(cl.now().Add(cl.opts.ConnectionTTL).UnixMilli())
+
+	ctx, cancel := context.WithTimeout(context.Background(), cl.opts.Timeout)
+	defer cancel()
+	_, err := cl.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, cl.key(ip), redis.Z{Score: expires, Member: member})
+		pipe.PExpire(ctx, cl.key(ip), cl.opts.ConnectionTTL)
+		return nil
+	})
+	if !cl.health.ok(err) {
+		cl.local.AddConnection(ip)
+		return
+	}
+
+	cl.mu.Lock()
+	cl.held[ip] = append(cl.held[ip], member)
+	cl.mu.Unlock()
+}
+
+// RemoveConnection removes a connection from IP
+func (cl *RedisConnectionLimiter) RemoveConnection(ip string) {
+	cl.mu.Lock()
+	members := cl.held[ip]
+	if len(members) == 0 {
+		cl.mu.Unlock()
+		cl.local.RemoveConnection(ip)
+		return
+	}
+	member := members[len(members)-1]
+	if len(members) == 1 {
+		delete(cl.held, ip)
+	} else {
+		cl.held[ip] = members[:len(members)-1]
+	}
+	cl.mu.Unlock()
+
+	// If this fails the member expires on its own once no longer refreshed
+	ctx, cancel := context.WithTimeout(context.Background(), cl.opts.Timeout)
+	defer cancel()
+	cl.health.ok(cl.client.ZRem(ctx, cl.key(ip), member).Err())
+}
+
+// GetConnectionCount returns current connection count for IP across all
+// servers, plus any connections this server counted locally
+func (cl *RedisConnectionLimiter) GetConnectionCount(ip string) int {
+	n, err := cl.count(ip)
+	if !cl.health.ok(err) {
+		n = 0
+	}
+	return n + cl.local.GetConnectionCount(ip)
+}
+
+// Dispose stops refreshing and releases this server's connections. The
+// client is left open for its owner to close.
+func (cl *RedisConnectionLimiter) Dispose() {
+	close(cl.stopCh)
+	cl.local.Dispose()
+
+	cl.mu.Lock()
+	held := cl.held
+	cl.held = make(map[string][]string)
+	cl.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cl.opts.Timeout)
+	defer cancel()
+	cl.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for ip, members := range held {
+			for _, m := range members {
+				pipe.ZRem(ctx, cl.key(ip), m)
+			}
+		}
+		return nil
+	})
+}
+
+// RedisDocumentLimiter counts document creation per IP across every server
+// sharing a Redis instance: a total kept for DocumentTTL after the IP's last
+// document, and a sorted set of creation times for the hourly limit.
+//
+// If Redis fails, documents are counted locally until it recovers.
+type RedisDocumentLimiter struct {
+	client     *redis.Client
+	opts       RedisLimiterOptions
+	maxPerIP   int
+	maxPerHour int
+	instance   string
+	seq        atomic.Uint64
+	local      *DocumentLimiter
+	health     redisHealth
+	now        func() time.Time
+}
+
+// NewRedisDocumentLimiter creates a document limiter allowing maxPerIP
+// documents per IP in total and maxPerHour per hour across all servers using
+// client
+func NewRedisDocumentLimiter(client *redis.Client, maxPerIP, maxPerHour int, opts RedisLimiterOptions) *RedisDocumentLimiter {
+	return &RedisDocumentLimiter{
+		client:     client,
+		opts:       opts.withDefaults(),
+		maxPerIP:   maxPerIP,
+		maxPerHour: maxPerHour,
+		instance:   newInstanceID(),
+		local:      NewDocumentLimiter(maxPerIP, maxPerHour),
+		health:     redisHealth{name: "document limiter"},
+		now:        time.Now,
+	}
+}
+
+func (dl *RedisDocumentLimiter) totalKey(ip string) string {
+	return dl.opts.Prefix + ":limits:docs:" + ip
+}
+
+func (dl *RedisDocumentLimiter) hourlyKey(ip string) string {
+	return dl.opts.Prefix + ":limits:docs-hourly:" + ip
+}
+
+// CanCreateDocument checks if IP can create a document
+func (dl *RedisDocumentLimiter) CanCreateDocument(ip string) (bool, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), dl.opts.Timeout)
+	defer cancel()
+
+	var total *redis.StringCmd
+	var hourly *redis.IntCmd
+	_, err := dl.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = pipe.Get(ctx, dl.totalKey(ip))
+		pipe.ZRemRangeByScore(ctx, dl.hourlyKey(ip), "-inf", unixMillis(dl.now().Add(-time.Hour)))
+		hourly = pipe.ZCard(ctx, dl.hourlyKey(ip))
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		err = nil // No documents yet
+	}
+	if !dl.health.ok(err) {
+		return dl.local.CanCreateDocument(ip)
+	}
+
+	if n, _ := total.Int(); n >= dl.maxPerIP {
+		return false, "Maximum documents per IP reached"
+	}
+	if int(hourly.Val()) >= dl.maxPerHour {
+		return false, "Hourly document creation limit reached"
+	}
+	return true, ""
+}
+
+// RecordDocument records a document creation from IP
+func (dl *RedisDocumentLimiter) RecordDocument(ip string) {
+	now := dl.now()
+	member := fmt.Sprintf("%s:%d", dl.instance, dl.seq.Add(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), dl.opts.Timeout)
+	defer cancel()
+	_, err := dl.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, dl.totalKey(ip))
+		pipe.PExpire(ctx, dl.totalKey(ip), dl.opts.DocumentTTL)
+		pipe.ZAdd(ctx, dl.hourlyKey(ip), redis.Z{Score: float64(now.UnixMilli()), Member: member})
+		pipe.PExpire(ctx, dl.hourlyKey(ip), time.Hour)
+		return nil
+	})
+	if !dl.health.ok(err) {
+		dl.local.RecordDocument(ip)
+	}
+}
+
+// Dispose cleans up resources. The client is left open for its owner to
+// close.
+func (dl *RedisDocumentLimiter) Dispose() {
+	dl.local.Dispose()
+}
+
+// RedisBanStore saves bans in Redis, one key per IP expiring with the ban
+type RedisBanStore struct {
+	client *redis.Client
+	opts   RedisLimiterOptions
+}
+
+// NewRedisBanStore creates a ban store using client
+func NewRedisBanStore(client *redis.Client, opts RedisLimiterOptions) *RedisBanStore {
+	return &RedisBanStore{client: client, opts: opts.withDefaults()}
+}
+
+func (s *RedisBanStore) key(ip string) string {
+	return s.opts.Prefix + ":bans:" + ip
+}
+
+// Save stores a ban until it expires
+func (s *RedisBanStore) Save(ban Ban) error {
+	ttl := time.Until(ban.Until)
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	return s.client.Set(ctx, s.key(ban.IP), data, ttl).Err()
+}
+
+// Delete removes a saved ban
+func (s *RedisBanStore) Delete(ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	return s.client.Del(ctx, s.key(ip)).Err()
+}
+
+// Load returns every saved ban
+func (s *RedisBanStore) Load() ([]Ban, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*s.opts.Timeout)
+	defer cancel()
+
+	var bans []Ban
+	iter := s.client.Scan(ctx, 0, s.key("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		data, err := s.client.Get(ctx, iter.Val()).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue // Expired since the scan
+		}
+		if err != nil {
+			return bans, err
+		}
+		var ban Ban
+		if err := json.Unmarshal(data, &ban); err != nil {
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	return bans, iter.Err()
+}
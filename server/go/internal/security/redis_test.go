@@ -0,0 +1,189 @@
+package security
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedis starts a miniredis server and returns a client factory for it;
+// each client stands in for a separate server process
+func newRedis(t *testing.T) (*miniredis.Miniredis, func() *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return mr, func() *redis.Client {
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+}
+
+func TestRedisConnectionLimiter_CountsAcrossServers(t *testing.T) {
+	_, client := newRedis(t)
+	a := NewRedisConnectionLimiter(client(), testMaxConnections, RedisLimiterOptions{})
+	defer a.Dispose()
+	b := NewRedisConnectionLimiter(client(), testMaxConnections, RedisLimiterOptions{})
+	defer b.Dispose()
+
+	ip := "10.1.0.1"
+	a.AddConnection(ip)
+	a.AddConnection(ip)
+	b.AddConnection(ip)
+
+	if got := b.GetConnectionCount(ip); got != testMaxConnections {
+		t.Errorf("count = %d, want %d", got, testMaxConnections)
+	}
+	if a.CanConnect(ip) || b.CanConnect(ip) {
+		t.Error("Should block connections at the limit shared by both servers")
+	}
+
+	b.RemoveConnection(ip)
+	if !a.CanConnect(ip) {
+		t.Error("Should allow a connection after another server releases one")
+	}
+	if b.CanConnect("10.1.0.2") == false {
+		t.Error("Other IPs should be unaffected")
+	}
+}
+
+func TestRedisConnectionLimiter_ExpiresUnrefreshedConnections(t *testing.T) {
+	_, client := newRedis(t)
+	opts := RedisLimiterOptions{ConnectionTTL: time.Minute}
+	crashed := NewRedisConnectionLimiter(client(), testMaxConnections, opts)
+	defer crashed.Dispose()
+	live := NewRedisConnectionLimiter(client(), testMaxConnections, opts)
+	defer live.Dispose()
+
+	ip := "10.1.0.3"
+	for i := 0; i < testMaxConnections; i++ {
+		crashed.AddConnection(ip)
+	}
+	if live.CanConnect(ip) {
+		t.Fatal("Should block connections at the limit")
+	}
+
+	// The crashed server never refreshes its entries
+	live.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if got := live.GetConnectionCount(ip); got != 0 {
+		t.Errorf("count after TTL = %d, want 0", got)
+	}
+}
+
+func TestRedisConnectionLimiter_RefreshKeepsConnections(t *testing.T) {
+	_, client := newRedis(t)
+	opts := RedisLimiterOptions{ConnectionTTL: time.Minute}
+	cl := NewRedisConnectionLimiter(client(), testMaxConnections, opts)
+	defer cl.Dispose()
+
+	ip := "10.1.0.4"
+	cl.AddConnection(ip)
+
+	later := time.Now().Add(50 * time.Second)
+	cl.now = func() time.Time { return later }
+	cl.refresh()
+
+	cl.now = func() time.Time { return later.Add(50 * time.Second) }
+	if got := cl.GetConnectionCount(ip); got != 1 {
+		t.Errorf("count after refresh = %d, want 1", got)
+	}
+}
+
+func TestRedisConnectionLimiter_FallsBackWhenRedisFails(t *testing.T) {
+	mr, client := newRedis(t)
+	cl := NewRedisConnectionLimiter(client(), testMaxConnections, RedisLimiterOptions{Timeout: 100 * time.Millisecond})
+	defer cl.Dispose()
+
+	ip := "10.1.0.5"
+	cl.AddConnection(ip) // Counted in Redis
+	mr.Close()
+
+	if !cl.CanConnect(ip) {
+		t.Fatal("Should fall back to local counting and allow the connection")
+	}
+	for i := 0; i < testMaxConnections; i++ {
+		cl.AddConnection(ip)
+	}
+	if cl.CanConnect(ip) {
+		t.Error("Should enforce the limit locally while Redis is down")
+	}
+
+	for i := 0; i < testMaxConnections+1; i++ {
+		cl.RemoveConnection(ip)
+	}
+	if got := cl.local.GetConnectionCount(ip); got != 0 {
+		t.Errorf("local count after removals = %d, want 0", got)
+	}
+}
+
+func TestRedisDocumentLimiter_CountsAcrossServers(t *testing.T) {
+	_, client := newRedis(t)
+	a := NewRedisDocumentLimiter(client(), 3, 100, RedisLimiterOptions{})
+	defer a.Dispose()
+	b := NewRedisDocumentLimiter(client(), 3, 100, RedisLimiterOptions{})
+	defer b.Dispose()
+
+	ip := "10.2.0.1"
+	if allowed, reason := a.CanCreateDocument(ip); !allowed {
+		t.Fatalf("Should allow the first document: %s", reason)
+	}
+	a.RecordDocument(ip)
+	a.RecordDocument(ip)
+	b.RecordDocument(ip)
+
+	allowed, reason := b.CanCreateDocument(ip)
+	if allowed || reason != "Maximum documents per IP reached" {
+		t.Errorf("CanCreateDocument = %v, %q; want total limit", allowed, reason)
+	}
+}
+
+func TestRedisDocumentLimiter_HourlyLimit(t *testing.T) {
+	_, client := newRedis(t)
+	dl := NewRedisDocumentLimiter(client(), 100, 2, RedisLimiterOptions{})
+	defer dl.Dispose()
+
+	ip := "10.2.0.2"
+	dl.RecordDocument(ip)
+	dl.RecordDocument(ip)
+
+	allowed, reason := dl.CanCreateDocument(ip)
+	if allowed || reason != "Hourly document creation limit reached" {
+		t.Errorf("CanCreateDocument = %v, %q; want hourly limit", allowed, reason)
+	}
+
+	dl.now = func() time.Time { return time.Now().Add(61 * time.Minute) }
+	if allowed, reason := dl.CanCreateDocument(ip); !allowed {
+		t.Errorf("Should allow documents an hour later: %s", reason)
+	}
+}
+
+func TestRedisDocumentLimiter_FallsBackWhenRedisFails(t *testing.T) {
+	mr, client := newRedis(t)
+	dl := NewRedisDocumentLimiter(client(), 2, 100, RedisLimiterOptions{Timeout: 100 * time.Millisecond})
+	defer dl.Dispose()
+	mr.Close()
+
+	ip := "10.2.0.3"
+	dl.RecordDocument(ip)
+	dl.RecordDocument(ip)
+
+	if allowed, _ := dl.CanCreateDocument(ip); allowed {
+		t.Error("Should enforce the limit locally while Redis is down")
+	}
+}
+
+func TestNewSecurityManagerWithOptions(t *testing.T) {
+	_, client := newRedis(t)
+	connections := NewRedisConnectionLimiter(client(), testMaxConnections, RedisLimiterOptions{})
+
+	sm := NewSecurityManagerWithOptions(Limits{}, SecurityOptions{Connections: connections})
+	defer sm.Dispose()
+
+	if sm.ConnectionLimiter != connections {
+		t.Error("Should use the given connection limiter")
+	}
+	if _, ok := sm.DocumentLimiter.(*DocumentLimiter); !ok {
+		t.Errorf("DocumentLimiter = %T, want local *DocumentLimiter", sm.DocumentLimiter)
+	}
+}
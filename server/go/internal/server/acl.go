@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Dancode-188/synckit/server/go/internal/acl"
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// newDocumentACL keeps document grants in the database, or in memory
+// without one
+func newDocumentACL(cfg *config.Config, db storage.StorageAdapter) *acl.Evaluator {
+	var store acl.DocumentACLStore = &acl.MemoryStore{}
+	if grants, ok := db.(storage.DocumentACLStorage); ok {
+		store = storageACL{grants}
+	}
+	return acl.NewEvaluator(store, cfg.ACLCacheTTL)
+}
+
+// handleDocumentPermissions serves GET and POST
+// /api/documents/{id}/permissions, which list and add grants, and DELETE
+// /api/documents/{id}/permissions/{principal}. Only the document's admins
+// and server admins may use them.
+func (s *Server) handleDocumentPermissions(w http.ResponseWriter, r *http.Request) {
+	docID, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/documents/"), "/")
+	principal, hasPrincipal := strings.CutPrefix(rest, "permissions/")
+	if valid, errMsg := security.ValidateDocumentID(docID); !valid {
+		writeError(w, http.StatusBadRequest, errMsg, "INVALID_DOCUMENT_ID")
+		return
+	}
+	if hasPrincipal && principal == "" {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+		return
+	}
+	allowed := r.Method == http.MethodGet || r.Method == http.MethodPost
+	if hasPrincipal {
+		allowed = r.Method == http.MethodDelete
+	}
+	if !allowed {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+
+	payload := tokenPayload(r)
+	if !s.acl.CanManage(r.Context(), payload, docID) {
+		s.audit.Log(audit.Event{
+			Type:       audit.EventPermissionDenied,
+			Actor:      payload.UserID,
+			DocumentID: docID,
+			IP:         s.getClientIP(r),
+			Details:    map[string]interface{}{"action": "manage_permissions", "code": "PERMISSION_DENIED"},
+		})
+		writeError(w, http.StatusForbidden, "Permission denied", "PERMISSION_DENIED")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		grants, err := s.acl.Grants(r.Context(), docID)
+		var owner string
+		if err == nil {
+			owner, err = s.acl.Owner(r.Context(), docID)
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to list document grants", "doc_id", docID, "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to list document permissions", "INTERNAL_ERROR")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"documentId": docID,
+			"owner":      owner,
+			"grants":     grants,
+			"count":      len(grants),
+		})
+
+	case http.MethodPost:
+		var body struct {
+			Principal string   `json:"principal"`
+			Role      string   `json:"role"`
+			Fields    []string `json:"fields"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Principal == "" {
+			writeBodyError(w, err, "principal and role are required")
+			return
+		}
+		role, err := acl.ParseRole(body.Role)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "role must be read, write or admin", "INVALID_REQUEST")
+			return
+		}
+		if err := auth.ValidateFieldPatterns(body.Fields); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error(), "INVALID_REQUEST")
+			return
+		}
+		grant := &acl.Grant{DocumentID: docID, Principal: body.Principal, Role: role, Fields: body.Fields, GrantedBy: payload.UserID}
+		if err := s.acl.Grant(r.Context(), grant); err != nil {
+			logging.FromContext(r.Context()).Error("Failed to grant document access", "doc_id", docID, "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to grant document access", "INTERNAL_ERROR")
+			return
+		}
+		// A lower role than before takes write access away
+		affected := s.hub.RecheckAccess(r.Context(), docID, grant.Principal)
+		details := map[string]interface{}{"role": role, "affected": affected}
+		if grant.Fields != nil {
+			details["fields"] = grant.Fields
+		}
+		s.auditGrant(r, docID, "grant", grant.Principal, details)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"grant": grant, "affected": affected})
+
+	case http.MethodDelete:
+		revoked, err := s.acl.Revoke(r.Context(), docID, principal)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to revoke document access", "doc_id", docID, "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to revoke document access", "INTERNAL_ERROR")
+			return
+		}
+		if !revoked {
+			writeError(w, http.StatusNotFound, "Grant not found", "GRANT_NOT_FOUND")
+			return
+		}
+		affected := s.hub.RecheckAccess(r.Context(), docID, principal)
+		s.auditGrant(r, docID, "revoke", principal, map[string]interface{}{"affected": affected})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"revoked": true, "affected": affected})
+	}
+}
+
+// handleListDocuments serves GET /api/documents?owner={userID}, which lists
+// the documents a user owns within the caller's tenant. owner=me is the
+// caller; other users' documents are for admins only.
+func (s *Server) handleListDocuments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	payload := tokenPayload(r)
+	owner := r.URL.Query().Get("owner")
+	switch owner {
+	case "":
+		writeError(w, http.StatusBadRequest, "owner is required", "INVALID_REQUEST")
+		return
+	case "me":
+		owner = payload.UserID
+	}
+	if owner != payload.UserID && !payload.Permissions.IsAdmin {
+		writeError(w, http.StatusForbidden, "Permission denied", "PERMISSION_DENIED")
+		return
+	}
+
+	docIDs, err := s.acl.OwnedDocuments(r.Context(), owner)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to list owned documents", "owner", owner, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to list documents", "INTERNAL_ERROR")
+		return
+	}
+	docIDs = tenantDocuments(payload, docIDs)
+	if docIDs == nil {
+		docIDs = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"owner":       owner,
+		"documentIds": docIDs,
+		"count":       len(docIDs),
+	})
+}
+
+func (s *Server) auditGrant(r *http.Request, docID, action, principal string, details map[string]interface{}) {
+	details["action"] = action
+	details["principal"] = principal
+	s.audit.Log(audit.Event{
+		Type:       audit.EventDocumentGrant,
+		Actor:      adminID(r),
+		DocumentID: docID,
+		IP:         s.getClientIP(r),
+		Details:    details,
+	})
+}
+
+// storageACL keeps document grants in a storage adapter
+type storageACL struct {
+	store storage.DocumentACLStorage
+}
+
+func (a storageACL) SaveGrant(ctx context.Context, grant *acl.Grant) error {
+	entry := &storage.DocumentGrantEntry{
+		DocumentID: grant.DocumentID,
+		Principal:  grant.Principal,
+		Role:       string(grant.Role),
+		Fields:     grant.Fields,
+		GrantedBy:  grant.GrantedBy,
+	}
+	if err := a.store.SaveDocumentGrant(ctx, entry); err != nil {
+		return err
+	}
+	grant.CreatedAt = entry.CreatedAt
+	return nil
+}
+
+func (a storageACL) DeleteGrant(ctx context.Context, docID, principal string) (bool, error) {
+	return a.store.DeleteDocumentGrant(ctx, docID, principal)
+}
+
+func (a storageACL) DocumentGrants(ctx context.Context, docID string) ([]*acl.Grant, error) {
+	return grantsFromEntries(a.store.ListDocumentGrants(ctx, docID))
+}
+
+func (a storageACL) PrincipalGrants(ctx context.Context, principal string) ([]*acl.Grant, error) {
+	return grantsFromEntries(a.store.ListPrincipalGrants(ctx, principal))
+}
+
+func (a storageACL) ClaimDocument(ctx context.Context, docID, principal string) (string, error) {
+	return a.store.ClaimDocument(ctx, docID, principal)
+}
+
+func (a storageACL) DocumentOwner(ctx context.Context, docID string) (string, error) {
+	return a.store.GetDocumentOwner(ctx, docID)
+}
+
+func (a storageACL) OwnedDocuments(ctx context.Context, principal string) ([]string, error) {
+	return a.store.ListOwnedDocuments(ctx, principal)
+}
+
+func grantsFromEntries(entries []*storage.DocumentGrantEntry, err error) ([]*acl.Grant, error) {
+	if err != nil {
+		return nil, err
+	}
+	grants := make([]*acl.Grant, len(entries))
+	for i, entry := range entries {
+		grants[i] = &acl.Grant{
+			DocumentID: entry.DocumentID,
+			Principal:  entry.Principal,
+			Role:       acl.Role(entry.Role),
+			Fields:     entry.Fields,
+			GrantedBy:  entry.GrantedBy,
+			CreatedAt:  entry.CreatedAt,
+		}
+	}
+	return grants, nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+func TestDocumentPermissions_GrantAndRevoke(t *testing.T) {
+	_, ts := newTestServer(t)
+	owner := tokenFor(t, "owner", auth.CreateUserPermissions(nil, nil))
+	bob := tokenFor(t, "bob", auth.CreateUserPermissions(nil, nil))
+
+	// Only admins may grant the first owner
+	if resp, _ := adminRequest(t, ts, http.MethodPost, "/api/documents/room:doc-1/permissions", owner, map[string]string{"principal": "owner", "role": "admin"}); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("grant without access = %d, want 403", resp.StatusCode)
+	}
+	if resp, body := adminRequest(t, ts, http.MethodPost, "/api/documents/room:doc-1/permissions", adminToken(t), map[string]string{"principal": "owner", "role": "admin"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin grant = %d %v, want 200", resp.StatusCode, body)
+	}
+
+	// Without a grant bob is refused
+	ws := dialWithToken(t, ts, bob, "")
+	subscribe(ws, "room:doc-1")
+	if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "PERMISSION_DENIED" {
+		t.Fatalf("subscribe without grant = %v, want PERMISSION_DENIED", msg.Payload)
+	}
+
+	// The owner shares the document with bob
+	if resp, body := adminRequest(t, ts, http.MethodPost, "/api/documents/room:doc-1/permissions", owner, map[string]string{"principal": "bob", "role": "write"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("owner grant = %d %v, want 200", resp.StatusCode, body)
+	}
+	subscribe(ws, "room:doc-1")
+	readMessage(t, ws, protocol.TypeSyncResponse)
+
+	_, body := adminRequest(t, ts, http.MethodGet, "/api/documents/room:doc-1/permissions", owner, nil)
+	if body["count"] != float64(2) {
+		t.Errorf("grants = %v, want owner and bob", body)
+	}
+	// Write access does not let bob manage the document
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/api/documents/room:doc-1/permissions", bob, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("bob listing grants = %d, want 403", resp.StatusCode)
+	}
+
+	// Revoking drops bob's open subscription
+	resp, body := adminRequest(t, ts, http.MethodDelete, "/api/documents/room:doc-1/permissions/bob", owner, nil)
+	if resp.StatusCode != http.StatusOK || body["affected"] != float64(1) {
+		t.Fatalf("revoke = %d %v, want one connection affected", resp.StatusCode, body)
+	}
+	msg := readMessage(t, ws, protocol.TypeError)
+	if msg.Payload["code"] != "ACCESS_REVOKED" || msg.Payload["docId"] != "room:doc-1" {
+		t.Errorf("subscriber got %v, want ACCESS_REVOKED for room:doc-1", msg.Payload)
+	}
+	subscribe(ws, "room:doc-1")
+	if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "PERMISSION_DENIED" {
+		t.Errorf("subscribe after revoke = %v, want PERMISSION_DENIED", msg.Payload)
+	}
+
+	if resp, _ := adminRequest(t, ts, http.MethodDelete, "/api/documents/room:doc-1/permissions/bob", owner, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("revoking twice = %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := adminRequest(t, ts, http.MethodPost, "/api/documents/room:doc-1/permissions", owner, map[string]string{"principal": "bob", "role": "owner"}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown role = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestDocumentPermissions_DowngradeMakesSubscriptionReadOnly(t *testing.T) {
+	_, ts := newTestServer(t)
+	admin := adminToken(t)
+	adminRequest(t, ts, http.MethodPost, "/api/documents/room:doc-1/permissions", admin, map[string]string{"principal": "bob", "role": "write"})
+
+	ws := dialWithToken(t, ts, tokenFor(t, "bob", auth.CreateUserPermissions(nil, nil)), "")
+	subscribe(ws, "room:doc-1")
+	readMessage(t, ws, protocol.TypeSyncResponse)
+
+	adminRequest(t, ts, http.MethodPost, "/api/documents/room:doc-1/permissions", admin, map[string]string{"principal": "bob", "role": "read"})
+	if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "WRITE_ACCESS_REVOKED" {
+		t.Errorf("subscriber got %v, want WRITE_ACCESS_REVOKED", msg.Payload)
+	}
+}
+
+func TestDocumentOwnership_CreatorKeepsAccess(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{OpenDocumentCreation: true})
+	alice := tokenFor(t, "alice", auth.CreateUserPermissions(nil, nil))
+	bob := tokenFor(t, "bob", auth.CreateUserPermissions(nil, nil))
+
+	// Alice's token lists no documents, but she may create one
+	ws := dialWithToken(t, ts, alice, "")
+	writeDelta(t, ws, "room:alice-notes", "title", "draft")
+	ws.Close()
+
+	// She owns it, so she keeps writing after reconnecting
+	ws = dialWithToken(t, ts, alice, "")
+	subscribe(ws, "room:alice-notes")
+	readMessage(t, ws, protocol.TypeSyncResponse)
+	writeDelta(t, ws, "room:alice-notes", "title", "final")
+
+	// Bob cannot use it now that it exists
+	other := dialWithToken(t, ts, bob, "")
+	sendMessage(t, other, protocol.TypeDelta, map[string]interface{}{
+		"id":      "bob-delta",
+		"docId":   "room:alice-notes",
+		"changes": map[string]interface{}{"title": "mine"},
+	})
+	if msg := readMessage(t, other, protocol.TypeError); msg.Payload["code"] != "PERMISSION_DENIED" {
+		t.Errorf("bob writing = %v, want PERMISSION_DENIED", msg.Payload)
+	}
+	subscribe(other, "room:alice-notes")
+	if msg := readMessage(t, other, protocol.TypeError); msg.Payload["code"] != "PERMISSION_DENIED" {
+		t.Errorf("bob subscribing = %v, want PERMISSION_DENIED", msg.Payload)
+	}
+
+	_, body := adminRequest(t, ts, http.MethodGet, "/api/documents?owner=me", alice, nil)
+	if ids, _ := body["documentIds"].([]interface{}); len(ids) != 1 || ids[0] != "room:alice-notes" {
+		t.Errorf("alice's documents = %v, want room:alice-notes", body)
+	}
+	_, body = adminRequest(t, ts, http.MethodGet, "/api/documents/room:alice-notes/permissions", alice, nil)
+	if body["owner"] != "alice" {
+		t.Errorf("permissions = %v, want owner alice", body)
+	}
+	if _, body := adminRequest(t, ts, http.MethodGet, "/api/documents?owner=me", bob, nil); body["count"] != float64(0) {
+		t.Errorf("bob's documents = %v, want none", body)
+	}
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/api/documents?owner=alice", bob, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("bob listing alice's documents = %d, want 403", resp.StatusCode)
+	}
+}
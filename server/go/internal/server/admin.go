@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+)
+
+// requireAuth only lets requests through that carry a valid JWT in the
+// Authorization header, or an API key in X-API-Key. Handlers read it with
+// tokenPayload.
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if payload, ok := s.checkAPIKey(w, r); ok {
+			if payload != nil {
+				if payload = s.scopeTenant(w, r, payload); payload != nil {
+					next(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, payload)))
+				}
+			}
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, "Missing token", "NOT_AUTHENTICATED")
+			return
+		}
+
+		payload, err := s.verifyToken(r.Context(), token)
+		if errors.Is(err, errTokenRevoked) {
+			writeError(w, http.StatusUnauthorized, "Token has been revoked", "TOKEN_REVOKED")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "Invalid token", "NOT_AUTHENTICATED")
+			return
+		}
+		if payload = s.scopeTenant(w, r, payload); payload == nil {
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, payload)))
+	}
+}
+
+// Default admin requests per minute per IP, when AdminRateLimit is unset
+const defaultAdminRateLimit = 60
+
+// adminAPIKeyActor is the audit actor of requests authenticated with
+// AdminAPIKey
+const adminAPIKeyActor = "admin-api-key"
+
+// adminRoutes builds the /admin/ route group. Every route shares
+// requireAdmin; unknown paths get a JSON 404.
+func (s *Server) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/connections", s.handleAdminConnections)
+	mux.HandleFunc("/admin/connections/", s.handleAdminDisconnectConnection)
+	mux.HandleFunc("/admin/users/", s.handleAdminDisconnectUser)
+	mux.HandleFunc("/admin/bans", s.handleAdminBans)
+	mux.HandleFunc("/admin/bans/", s.handleAdminUnban)
+	mux.HandleFunc("/admin/documents/", s.handleAdminDocument)
+	mux.HandleFunc("/admin/cleanup", s.handleAdminCleanup)
+	mux.HandleFunc("/admin/backup", s.handleAdminBackup)
+	mux.HandleFunc("/admin/config", s.handleAdminConfig)
+	mux.HandleFunc("/admin/maintenance", s.handleAdminMaintenance)
+	mux.HandleFunc("/admin/tokens/revoke", s.handleAdminRevokeTokens)
+	mux.HandleFunc("/admin/apikeys", s.handleAdminAPIKeys)
+	mux.HandleFunc("/admin/apikeys/", s.handleAdminRevokeAPIKey)
+	mux.HandleFunc("/admin/schemas", s.handleAdminSchemas)
+	mux.HandleFunc("/admin/schemas/", s.handleAdminSchema)
+	mux.HandleFunc("/admin/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+	})
+	return s.requireAdmin(mux)
+}
+
+// requireAdmin rate limits requests per IP and only lets through those
+// carrying AdminAPIKey or an admin JWT as a bearer token, or an admin API
+// key in X-API-Key. Rejections are audited.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := s.getClientIP(r)
+		if !s.adminLimiter.Allow(ip) {
+			s.securityManager.RecordRejection("admin")
+			s.audit.Log(audit.Event{
+				Type:    audit.EventRateLimited,
+				IP:      ip,
+				Details: map[string]interface{}{"limit": "admin", "path": r.URL.Path},
+			})
+			writeError(w, http.StatusTooManyRequests, "Too many admin requests", "RATE_LIMITED")
+			return
+		}
+
+		reject := func(status int, eventType, message, code string) {
+			s.audit.Log(audit.Event{
+				Type:    eventType,
+				IP:      ip,
+				Details: map[string]interface{}{"path": r.URL.Path, "reason": message},
+			})
+			writeError(w, status, message, code)
+		}
+
+		payload, ok := s.checkAPIKey(w, r)
+		if ok {
+			if payload != nil {
+				s.serveAdmin(w, r, payload, next)
+			}
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			reject(http.StatusUnauthorized, audit.EventAuthFailure, "Missing token", "NOT_AUTHENTICATED")
+			return
+		}
+		if key := s.config.AdminAPIKey; key != "" && subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			payload := &auth.TokenPayload{UserID: adminAPIKeyActor, Permissions: auth.CreateAdminPermissions()}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, payload)))
+			return
+		}
+
+		payload, err := s.verifyToken(r.Context(), token)
+		if errors.Is(err, errTokenRevoked) {
+			reject(http.StatusUnauthorized, audit.EventAuthFailure, "Token has been revoked", "TOKEN_REVOKED")
+			return
+		}
+		if err != nil {
+			reject(http.StatusUnauthorized, audit.EventAuthFailure, "Invalid token", "NOT_AUTHENTICATED")
+			return
+		}
+		s.serveAdmin(w, r, payload, next)
+	})
+}
+
+// serveAdmin passes an authenticated request to next if payload is an
+// admin's, and audits it otherwise
+func (s *Server) serveAdmin(w http.ResponseWriter, r *http.Request, payload *auth.TokenPayload, next http.Handler) {
+	if !payload.Permissions.IsAdmin {
+		s.audit.Log(audit.Event{
+			Type:    audit.EventPermissionDenied,
+			Actor:   payload.UserID,
+			IP:      s.getClientIP(r),
+			Details: map[string]interface{}{"path": r.URL.Path},
+		})
+		writeError(w, http.StatusForbidden, "Admin permission required", "PERMISSION_DENIED")
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, payload)))
+}
+
+// tokenKey is the request context key of the token requireAuth verified
+type tokenKey struct{}
+
+// tokenPayload returns the token requireAuth verified
+func tokenPayload(r *http.Request) *auth.TokenPayload {
+	payload, _ := r.Context().Value(tokenKey{}).(*auth.TokenPayload)
+	return payload
+}
+
+// adminID returns the user ID of the admin token requireAdmin verified, or
+// adminAPIKeyActor for the API key
+func adminID(r *http.Request) string {
+	if payload := tokenPayload(r); payload != nil {
+		return payload.UserID
+	}
+	return ""
+}
+
+func (s *Server) handleAdminConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+
+	conns := s.hub.ListConnections()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"connections": conns,
+		"count":       len(conns),
+	})
+}
+
+// handleAdminDisconnectConnection serves POST /admin/connections/{id}/disconnect
+func (s *Server) handleAdminDisconnectConnection(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminAction(w, r, "/admin/connections/", "disconnect")
+	if !ok {
+		return
+	}
+
+	reason := disconnectReason(r)
+	if !s.hub.Disconnect(id, reason) {
+		writeError(w, http.StatusNotFound, "Connection not found", "CONNECTION_NOT_FOUND")
+		return
+	}
+	s.audit.Log(audit.Event{
+		Type:         audit.EventAdminDisconnect,
+		Actor:        adminID(r),
+		ConnectionID: id,
+		Details:      map[string]interface{}{"reason": reason},
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"connectionId": id,
+		"disconnected": 1,
+	})
+}
+
+// handleAdminDisconnectUser serves POST /admin/users/{id}/disconnect
+func (s *Server) handleAdminDisconnectUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminAction(w, r, "/admin/users/", "disconnect")
+	if !ok {
+		return
+	}
+
+	reason := disconnectReason(r)
+	count := s.hub.DisconnectUser(id, reason)
+	if count == 0 {
+		writeError(w, http.StatusNotFound, "User has no connections", "USER_NOT_CONNECTED")
+		return
+	}
+	s.audit.Log(audit.Event{
+		Type:    audit.EventAdminDisconnect,
+		Actor:   adminID(r),
+		Details: map[string]interface{}{"userId": id, "reason": reason, "disconnected": count},
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"userId":       id,
+		"disconnected": count,
+	})
+}
+
+// handleAdminBans serves GET /admin/bans (list) and POST /admin/bans, which
+// bans {"ip", "durationSeconds", "reason"} and closes the IP's connections
+func (s *Server) handleAdminBans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		bans := s.securityManager.Bans.List()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"bans":  bans,
+			"count": len(bans),
+		})
+
+	case http.MethodPost:
+		var body struct {
+			IP              string `json:"ip"`
+			DurationSeconds int    `json:"durationSeconds"`
+			Reason          string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.IP == "" || body.DurationSeconds <= 0 {
+			writeBodyError(w, err, "ip and a positive durationSeconds are required")
+			return
+		}
+		if body.Reason == "" {
+			body.Reason = "Banned by administrator"
+		}
+		ban := s.securityManager.Bans.Ban(body.IP, time.Duration(body.DurationSeconds)*time.Second, body.Reason)
+		s.audit.Log(audit.Event{
+			Type:    audit.EventBan,
+			Actor:   adminID(r),
+			IP:      ban.IP,
+			Details: map[string]interface{}{"reason": ban.Reason, "until": ban.Until},
+		})
+		writeJSON(w, http.StatusCreated, ban)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+	}
+}
+
+// handleAdminUnban serves DELETE /admin/bans/{ip}
+func (s *Server) handleAdminUnban(w http.ResponseWriter, r *http.Request) {
+	ip := strings.TrimPrefix(r.URL.Path, "/admin/bans/")
+	if ip == "" || strings.Contains(ip, "/") {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+
+	if !s.securityManager.Bans.Unban(ip) {
+		writeError(w, http.StatusNotFound, "IP is not banned", "BAN_NOT_FOUND")
+		return
+	}
+	s.audit.Log(audit.Event{
+		Type:  audit.EventUnban,
+		Actor: adminID(r),
+		IP:    ip,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ip":       ip,
+		"unbanned": true,
+	})
+}
+
+// adminAction matches POST {prefix}{id}/{action} and returns the id, writing
+// an error response when the request does not match
+func adminAction(w http.ResponseWriter, r *http.Request, prefix, action string) (string, bool) {
+	id, rest, found := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if !found || id == "" || rest != action {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+		return "", false
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return "", false
+	}
+	return id, true
+}
+
+// disconnectReason reads the optional {"reason": "..."} request body
+func disconnectReason(r *http.Request) string {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	if body.Reason == "" {
+		return "Disconnected by administrator"
+	}
+	return body.Reason
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message, code string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": message,
+		"code":  code,
+	})
+}
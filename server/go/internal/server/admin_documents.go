@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// Snapshots kept by a compaction unless the request says otherwise
+const defaultCompactKeepSnapshots = 10
+
+// handleAdminDocument serves POST /admin/documents/{id}/compact and
+// POST /admin/documents/{id}/restore
+func (s *Server) handleAdminDocument(w http.ResponseWriter, r *http.Request) {
+	docID, action, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/documents/"), "/")
+	if !found || docID == "" || (action != "compact" && action != "restore") {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	if s.refuseDuringMaintenance(w) {
+		return
+	}
+	if s.storage == nil {
+		writeError(w, http.StatusServiceUnavailable, "Storage is not configured", "STORAGE_UNAVAILABLE")
+		return
+	}
+
+	if action == "compact" {
+		s.compactDocument(w, r, docID)
+	} else {
+		s.restoreDocument(w, r, docID)
+	}
+}
+
+// compactDocument snapshots a document's stored state, deletes the deltas
+// the snapshot covers and prunes all but the newest {"keepSnapshots"}
+// snapshots
+func (s *Server) compactDocument(w http.ResponseWriter, r *http.Request, docID string) {
+	body := struct {
+		KeepSnapshots int `json:"keepSnapshots"`
+	}{KeepSnapshots: defaultCompactKeepSnapshots}
+	if !decodeOptionalBody(w, r, &body) {
+		return
+	}
+	if body.KeepSnapshots < 1 {
+		writeError(w, http.StatusBadRequest, "keepSnapshots must be at least 1", "INVALID_REQUEST")
+		return
+	}
+
+	ctx := r.Context()
+	logger := logging.FromContext(ctx).With("doc_id", docID)
+	storageError := func(msg string, err error) {
+		logger.Error(msg, "err", err)
+		writeError(w, http.StatusInternalServerError, msg, "STORAGE_ERROR")
+	}
+
+	doc, err := s.storage.GetDocument(ctx, docID)
+	if err != nil {
+		storageError("Failed to load document", err)
+		return
+	}
+	if doc == nil {
+		writeError(w, http.StatusNotFound, "Document not found", "DOCUMENT_NOT_FOUND")
+		return
+	}
+	clock, err := s.storage.GetVectorClock(ctx, docID)
+	if err != nil {
+		storageError("Failed to load vector clock", err)
+		return
+	}
+
+	encoded, _ := json.Marshal(doc.State)
+	snapshot, err := s.storage.SaveSnapshot(ctx, &storage.SnapshotEntry{
+		DocumentID: docID,
+		State:      doc.State,
+		Version:    clock,
+		SizeBytes:  len(encoded),
+	})
+	if err != nil {
+		storageError("Failed to save snapshot", err)
+		return
+	}
+	deltas, err := s.storage.DeleteDeltasBefore(ctx, docID, snapshot.CreatedAt)
+	if err != nil {
+		storageError("Failed to delete compacted deltas", err)
+		return
+	}
+	snapshots, err := s.pruneSnapshots(r, docID, body.KeepSnapshots)
+	if err != nil {
+		storageError("Failed to prune snapshots", err)
+		return
+	}
+
+	s.audit.Log(audit.Event{
+		Type:       audit.EventAdminCompact,
+		Actor:      adminID(r),
+		DocumentID: docID,
+		Details: map[string]interface{}{
+			"snapshotId":       snapshot.ID,
+			"deltasDeleted":    deltas,
+			"snapshotsDeleted": snapshots,
+		},
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"documentId":       docID,
+		"snapshotId":       snapshot.ID,
+		"deltasDeleted":    deltas,
+		"snapshotsDeleted": snapshots,
+	})
+}
+
+// pruneSnapshots deletes every snapshot of docID but the newest keep and
+// returns how many it deleted
+func (s *Server) pruneSnapshots(r *http.Request, docID string, keep int) (int, error) {
+	seen, deleted := 0, 0
+	var before *storage.PageKey
+	for {
+		page, err := s.storage.QuerySnapshots(r.Context(), docID, storage.SnapshotQuery{Before: before, Limit: defaultSnapshotPageSize})
+		if err != nil {
+			return deleted, err
+		}
+		for _, snapshot := range page {
+			seen++
+			if seen <= keep {
+				continue
+			}
+			ok, err := s.storage.DeleteSnapshot(r.Context(), snapshot.ID)
+			if err != nil {
+				return deleted, err
+			}
+			if ok {
+				deleted++
+			}
+		}
+		if len(page) < defaultSnapshotPageSize {
+			return deleted, nil
+		}
+		last := page[len(page)-1]
+		before = &storage.PageKey{Timestamp: last.CreatedAt, ID: last.ID}
+	}
+}
+
+// restoreDocument replaces a document's state with snapshot {"snapshotId"}.
+// Connected clients are told to resync.
+func (s *Server) restoreDocument(w http.ResponseWriter, r *http.Request, docID string) {
+	var body struct {
+		SnapshotID string `json:"snapshotId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.SnapshotID == "" {
+		writeBodyError(w, err, "snapshotId is required")
+		return
+	}
+
+	ctx := r.Context()
+	logger := logging.FromContext(ctx).With("doc_id", docID, "snapshot_id", body.SnapshotID)
+	snapshot, err := s.storage.GetSnapshot(ctx, body.SnapshotID)
+	if err != nil {
+		logger.Error("Failed to load snapshot", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to load snapshot", "STORAGE_ERROR")
+		return
+	}
+	if snapshot == nil || snapshot.DocumentID != docID {
+		writeError(w, http.StatusNotFound, "Snapshot not found", "SNAPSHOT_NOT_FOUND")
+		return
+	}
+	state, err := storage.SnapshotState(snapshot)
+	if err != nil {
+		logger.Error("Failed to decode snapshot", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to decode snapshot", "STORAGE_ERROR")
+		return
+	}
+
+	if err := s.hub.RestoreDocument(ctx, docID, state); err != nil {
+		logger.Error("Failed to persist restored document", "err", err)
+		writeError(w, http.StatusInternalServerError, "Restored in memory but failed to persist", "STORAGE_ERROR")
+		return
+	}
+
+	s.audit.Log(audit.Event{
+		Type:       audit.EventAdminRestore,
+		Actor:      adminID(r),
+		DocumentID: docID,
+		Details:    map[string]interface{}{"snapshotId": snapshot.ID, "snapshotCreatedAt": snapshot.CreatedAt},
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"documentId": docID,
+		"snapshotId": snapshot.ID,
+		"restored":   true,
+	})
+}
+
+// handleAdminCleanup serves POST /admin/cleanup, running storage cleanup
+// with the optional storage.CleanupOptions body (the storage defaults
+// without one)
+func (s *Server) handleAdminCleanup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	if s.refuseDuringMaintenance(w) {
+		return
+	}
+	if s.storage == nil {
+		writeError(w, http.StatusServiceUnavailable, "Storage is not configured", "STORAGE_UNAVAILABLE")
+		return
+	}
+
+	var options *storage.CleanupOptions
+	if r.ContentLength != 0 {
+		options = &storage.CleanupOptions{}
+		if !decodeOptionalBody(w, r, options) {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	result, err := s.storage.Cleanup(ctx, options)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Cleanup failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "Cleanup failed", "STORAGE_ERROR")
+		return
+	}
+
+	s.audit.Log(audit.Event{
+		Type:    audit.EventAdminCleanup,
+		Actor:   adminID(r),
+		Details: map[string]interface{}{"options": options, "result": result},
+	})
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleAdminConfig serves GET /admin/config: the running configuration
+// with secrets redacted
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.config.Redacted())
+}
+
+// decodeOptionalBody decodes a JSON request body into v when there is one,
+// writing a 400 when it is malformed (413 when it is too large)
+func decodeOptionalBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil && err != io.EOF {
+		writeBodyError(w, err, "Invalid request body")
+		return false
+	}
+	return true
+}
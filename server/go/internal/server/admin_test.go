@@ -0,0 +1,545 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	gorilla "github.com/gorilla/websocket"
+)
+
+const testSecret = "this-is-a-test-secret-that-is-at-least-32-chars"
+
+// --- Helpers ---
+
+// newTestServer serves the full route set against a running hub
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	s := New(&config.Config{JWTSecret: testSecret})
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		s.hub.Stop(ctx)
+		ts.Close()
+	})
+	return s, ts
+}
+
+func tokenFor(t *testing.T, userID string, perms auth.DocumentPermissions) string {
+	t.Helper()
+	token, err := auth.GenerateAccessToken(userID, "", perms, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken failed: %v", err)
+	}
+	return token
+}
+
+func adminToken(t *testing.T) string {
+	return tokenFor(t, "admin", auth.CreateAdminPermissions())
+}
+
+// dialClient opens a websocket to the test server and authenticates as userID
+func dialClient(t *testing.T, ts *httptest.Server, userID string) *gorilla.Conn {
+	t.Helper()
+	return dialClientFrom(t, ts, userID, "")
+}
+
+// dialClientFrom is dialClient with the client IP set via X-Forwarded-For
+func dialClientFrom(t *testing.T, ts *httptest.Server, userID, ip string) *gorilla.Conn {
+	t.Helper()
+	return dialWithToken(t, ts, tokenFor(t, userID, auth.CreateUserPermissions([]string{"*"}, nil)), ip)
+}
+
+// dialWithToken opens a websocket from ip ("" for the default) and
+// authenticates with token
+func dialWithToken(t *testing.T, ts *httptest.Server, token, ip string) *gorilla.Conn {
+	t.Helper()
+	header := http.Header{}
+	if ip != "" {
+		header.Set("X-Forwarded-For", ip)
+	}
+	ws, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	data, _ := protocol.EncodeMessage(protocol.TypeAuth, map[string]interface{}{
+		"type":  protocol.TypeAuth,
+		"id":    "auth",
+		"token": token,
+	}, time.Now().UnixMilli())
+	if err := ws.WriteMessage(gorilla.BinaryMessage, data); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	readMessage(t, ws, protocol.TypeAuthSuccess)
+	return ws
+}
+
+// readMessage reads until a message of the given type arrives ("" for any)
+func readMessage(t *testing.T, ws *gorilla.Conn, msgType string) *protocol.Message {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", msgType, err)
+		}
+		msg, err := protocol.DecodeMessage(data)
+		if err != nil {
+			t.Fatalf("DecodeMessage failed: %v", err)
+		}
+		if msgType == "" || msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+// expectKicked asserts the client gets DISCONNECTED_BY_ADMIN and then a close frame
+func expectKicked(t *testing.T, ws *gorilla.Conn, reason string) {
+	t.Helper()
+	msg := readMessage(t, ws, protocol.TypeError)
+	if msg.Payload["code"] != "DISCONNECTED_BY_ADMIN" || msg.Payload["reason"] != reason {
+		t.Errorf("error payload = %v, want DISCONNECTED_BY_ADMIN with reason %q", msg.Payload, reason)
+	}
+	if _, _, err := ws.ReadMessage(); !gorilla.IsCloseError(err, gorilla.CloseNoStatusReceived, gorilla.CloseNormalClosure) {
+		t.Errorf("ReadMessage after kick error = %v, want close frame", err)
+	}
+}
+
+func adminRequest(t *testing.T, ts *httptest.Server, method, path, token string, body interface{}) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, ts.URL+path, &buf)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var decoded map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp, decoded
+}
+
+func connectionIDsFor(t *testing.T, ts *httptest.Server, userID string) []string {
+	t.Helper()
+	_, body := adminRequest(t, ts, http.MethodGet, "/admin/connections", adminToken(t), nil)
+	conns, _ := body["connections"].([]interface{})
+	ids := make([]string, 0)
+	for _, raw := range conns {
+		info, _ := raw.(map[string]interface{})
+		if info["userId"] == userID {
+			ids = append(ids, info["id"].(string))
+		}
+	}
+	return ids
+}
+
+// --- Tests ---
+
+func TestAdmin_RequiresAdminToken(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"invalid token", "not-a-jwt", http.StatusUnauthorized},
+		{"non-admin token", tokenFor(t, "alice", auth.CreateUserPermissions([]string{"*"}, []string{"*"})), http.StatusForbidden},
+		{"admin token", adminToken(t), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/connections", tt.token, nil)
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdmin_DisconnectConnection(t *testing.T) {
+	_, ts := newTestServer(t)
+	target := dialClient(t, ts, "mallory")
+	bystander := dialClient(t, ts, "bob")
+
+	ids := connectionIDsFor(t, ts, "mallory")
+	if len(ids) != 1 {
+		t.Fatalf("mallory has %d connections, want 1", len(ids))
+	}
+
+	resp, body := adminRequest(t, ts, http.MethodPost, "/admin/connections/"+ids[0]+"/disconnect", adminToken(t),
+		map[string]string{"reason": "spam"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", resp.StatusCode, body)
+	}
+	expectKicked(t, target, "spam")
+
+	// Other connections are untouched
+	if ids := connectionIDsFor(t, ts, "bob"); len(ids) != 1 {
+		t.Errorf("bob has %d connections after kicking mallory, want 1", len(ids))
+	}
+	data, _ := protocol.EncodeMessage(protocol.TypePing, map[string]interface{}{"type": protocol.TypePing, "id": "p"}, 0)
+	bystander.WriteMessage(gorilla.BinaryMessage, data)
+	readMessage(t, bystander, protocol.TypePong)
+
+	resp, _ = adminRequest(t, ts, http.MethodPost, "/admin/connections/"+ids[0]+"/disconnect", adminToken(t), nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second disconnect status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+// recordingAudit collects audit events for assertions
+type recordingAudit struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (r *recordingAudit) Log(event audit.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingAudit) all() []audit.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]audit.Event(nil), r.events...)
+}
+
+func (r *recordingAudit) ofType(eventType string) []audit.Event {
+	var matched []audit.Event
+	for _, event := range r.all() {
+		if event.Type == eventType {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+func TestAdmin_DisconnectIsAudited(t *testing.T) {
+	s, ts := newTestServer(t)
+	rec := &recordingAudit{}
+	s.audit = rec
+	target := dialClient(t, ts, "mallory")
+	id := connectionIDsFor(t, ts, "mallory")[0]
+
+	resp, body := adminRequest(t, ts, http.MethodPost, "/admin/connections/"+id+"/disconnect", adminToken(t),
+		map[string]string{"reason": "spam"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", resp.StatusCode, body)
+	}
+	expectKicked(t, target, "spam")
+
+	events := rec.all()
+	if len(events) != 1 {
+		t.Fatalf("audit events = %v, want 1", events)
+	}
+	e := events[0]
+	if e.Type != audit.EventAdminDisconnect || e.Actor != "admin" || e.ConnectionID != id || e.Details["reason"] != "spam" {
+		t.Errorf("event = %+v, want admin_disconnect of %s by admin for spam", e, id)
+	}
+}
+
+func TestAdmin_DisconnectUser(t *testing.T) {
+	_, ts := newTestServer(t)
+	tabs := []*gorilla.Conn{dialClient(t, ts, "mallory"), dialClient(t, ts, "mallory")}
+	dialClient(t, ts, "bob")
+
+	resp, body := adminRequest(t, ts, http.MethodPost, "/admin/users/mallory/disconnect", adminToken(t), nil)
+	if resp.StatusCode != http.StatusOK || body["disconnected"] != float64(2) {
+		t.Fatalf("status = %d, body = %v, want 2 disconnected", resp.StatusCode, body)
+	}
+	for _, ws := range tabs {
+		expectKicked(t, ws, "Disconnected by administrator")
+	}
+
+	if ids := connectionIDsFor(t, ts, "mallory"); len(ids) != 0 {
+		t.Errorf("mallory still has connections %v", ids)
+	}
+	if ids := connectionIDsFor(t, ts, "bob"); len(ids) != 1 {
+		t.Errorf("bob has %d connections, want 1", len(ids))
+	}
+}
+
+func TestAdmin_DisconnectRejectsWrongMethodAndPath(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/users/mallory/disconnect", adminToken(t), nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+	resp, _ = adminRequest(t, ts, http.MethodPost, "/admin/users/mallory/explode", adminToken(t), nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown action status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAdmin_Bans(t *testing.T) {
+	_, ts := newTestServer(t)
+	target := dialClientFrom(t, ts, "mallory", "203.0.113.7")
+	dialClientFrom(t, ts, "bob", "203.0.113.8")
+
+	resp, body := adminRequest(t, ts, http.MethodPost, "/admin/bans", adminToken(t),
+		map[string]interface{}{"ip": "203.0.113.7", "durationSeconds": 60, "reason": "abuse"})
+	if resp.StatusCode != http.StatusCreated || body["ip"] != "203.0.113.7" {
+		t.Fatalf("status = %d, body = %v", resp.StatusCode, body)
+	}
+
+	msg := readMessage(t, target, protocol.TypeError)
+	if msg.Payload["code"] != "IP_BANNED" || msg.Payload["reason"] != "abuse" {
+		t.Errorf("error payload = %v, want IP_BANNED with reason abuse", msg.Payload)
+	}
+	if ids := connectionIDsFor(t, ts, "bob"); len(ids) != 1 {
+		t.Errorf("bob has %d connections, want 1", len(ids))
+	}
+
+	_, body = adminRequest(t, ts, http.MethodGet, "/admin/bans", adminToken(t), nil)
+	if body["count"] != float64(1) {
+		t.Errorf("GET /admin/bans = %v, want 1 ban", body)
+	}
+	expectUpgradeStatus(t, ts, "203.0.113.7", http.StatusForbidden)
+
+	resp, _ = adminRequest(t, ts, http.MethodDelete, "/admin/bans/203.0.113.7", adminToken(t), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	dialClientFrom(t, ts, "mallory", "203.0.113.7")
+
+	resp, _ = adminRequest(t, ts, http.MethodDelete, "/admin/bans/203.0.113.7", adminToken(t), nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second DELETE status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	resp, _ = adminRequest(t, ts, http.MethodPost, "/admin/bans", adminToken(t), map[string]interface{}{"ip": "203.0.113.9"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("POST without duration status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+// expectUpgradeStatus asserts that a websocket upgrade from ip is refused with status
+func expectUpgradeStatus(t *testing.T, ts *httptest.Server, ip string, status int) {
+	t.Helper()
+	header := http.Header{"X-Forwarded-For": []string{ip}}
+	_, resp, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", header)
+	if err == nil {
+		t.Fatalf("Dial from %s succeeded, want %d", ip, status)
+	}
+	if resp == nil || resp.StatusCode != status {
+		t.Errorf("upgrade response = %v, want %d", resp, status)
+	}
+}
+
+func TestAdmin_RejectionsAreAudited(t *testing.T) {
+	s, ts := newTestServer(t)
+	rec := &recordingAudit{}
+	s.audit = rec
+
+	adminRequest(t, ts, http.MethodGet, "/admin/config", "not-a-jwt", nil)
+	adminRequest(t, ts, http.MethodGet, "/admin/config", tokenFor(t, "alice", auth.CreateUserPermissions([]string{"*"}, nil)), nil)
+
+	events := rec.all()
+	if len(events) != 2 {
+		t.Fatalf("audit events = %+v, want 2", events)
+	}
+	if e := events[0]; e.Type != audit.EventAuthFailure || e.IP == "" || e.Details["path"] != "/admin/config" {
+		t.Errorf("invalid token event = %+v, want auth_failure for /admin/config", e)
+	}
+	if e := events[1]; e.Type != audit.EventPermissionDenied || e.Actor != "alice" {
+		t.Errorf("non-admin event = %+v, want permission_denied for alice", e)
+	}
+}
+
+func TestAdmin_APIKey(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.config.AdminAPIKey = "an-admin-api-key-of-at-least-32-characters"
+
+	resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/connections", s.config.AdminAPIKey, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("API key status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	resp, body := adminRequest(t, ts, http.MethodGet, "/admin/connections", "an-admin-api-key-of-at-least-32-characterz", nil)
+	if resp.StatusCode != http.StatusUnauthorized || body["code"] != "NOT_AUTHENTICATED" {
+		t.Errorf("wrong key = %d %v, want 401 NOT_AUTHENTICATED", resp.StatusCode, body)
+	}
+	resp, body = adminRequest(t, ts, http.MethodGet, "/admin/nothing-here", s.config.AdminAPIKey, nil)
+	if resp.StatusCode != http.StatusNotFound || body["code"] != "NOT_FOUND" {
+		t.Errorf("unknown route = %d %v, want 404 NOT_FOUND", resp.StatusCode, body)
+	}
+}
+
+func TestAdmin_RateLimited(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.adminLimiter.Dispose()
+	s.adminLimiter = security.NewConnectionRateLimiter(2)
+	rec := &recordingAudit{}
+	s.audit = rec
+
+	for i := 0; i < 2; i++ {
+		adminRequest(t, ts, http.MethodGet, "/admin/connections", adminToken(t), nil)
+	}
+	resp, body := adminRequest(t, ts, http.MethodGet, "/admin/connections", adminToken(t), nil)
+	if resp.StatusCode != http.StatusTooManyRequests || body["code"] != "RATE_LIMITED" {
+		t.Errorf("third request = %d %v, want 429 RATE_LIMITED", resp.StatusCode, body)
+	}
+	if events := rec.all(); len(events) != 1 || events[0].Type != audit.EventRateLimited || events[0].Details["limit"] != "admin" {
+		t.Errorf("audit events = %+v, want one admin rate_limited", events)
+	}
+}
+
+func TestAdmin_ConfigIsRedacted(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.config.AdminAPIKey = "an-admin-api-key-of-at-least-32-characters"
+	s.config.DatabaseURL = "postgres://synckit:hunter2@db:5432/synckit"
+	s.config.AuthDevUsers = []string{"alice@example.com:hunter2:admin"}
+	s.config.Port = 8080
+
+	resp, body := adminRequest(t, ts, http.MethodGet, "/admin/config", adminToken(t), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", resp.StatusCode, body)
+	}
+	if body["Port"] != float64(8080) || body["JWTSecret"] != "[redacted]" || body["AdminAPIKey"] != "[redacted]" {
+		t.Errorf("config = %v, want the port with secrets redacted", body)
+	}
+	if url, _ := body["DatabaseURL"].(string); strings.Contains(url, "hunter2") || !strings.Contains(url, "@db:5432") {
+		t.Errorf("DatabaseURL = %q, want the password masked", url)
+	}
+	if users, _ := body["AuthDevUsers"].([]interface{}); len(users) != 1 || users[0] != "alice@example.com:[redacted]" {
+		t.Errorf("AuthDevUsers = %v, want the password masked", body["AuthDevUsers"])
+	}
+	if s.config.JWTSecret != testSecret {
+		t.Error("redacting changed the running config")
+	}
+}
+
+// adminStorage adds the writes compaction needs to historyStorage
+type adminStorage struct {
+	*historyStorage
+}
+
+func (f adminStorage) GetVectorClock(ctx context.Context, documentID string) (map[string]int64, error) {
+	return map[string]int64{"client-1": 5}, nil
+}
+
+func (f adminStorage) SaveSnapshot(ctx context.Context, snapshot *storage.SnapshotEntry) (*storage.SnapshotEntry, error) {
+	snapshot.ID = "snap-compacted"
+	snapshot.CreatedAt = time.Now()
+	f.snapshots = append(f.snapshots, *snapshot)
+	return snapshot, nil
+}
+
+func (f adminStorage) DeleteDeltasBefore(ctx context.Context, documentID string, before time.Time) (int, error) {
+	kept, deleted := f.deltas[:0], 0
+	for _, d := range f.deltas {
+		if d.DocumentID == documentID && d.Timestamp.Before(before) {
+			deleted++
+		} else {
+			kept = append(kept, d)
+		}
+	}
+	f.deltas = kept
+	return deleted, nil
+}
+
+func (f adminStorage) DeleteSnapshot(ctx context.Context, snapshotID string) (bool, error) {
+	for i, s := range f.snapshots {
+		if s.ID == snapshotID {
+			f.snapshots = append(f.snapshots[:i], f.snapshots[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// newAdminStorageServer returns a test server backed by historyFixture as
+// adminStorage, recording audit events
+func newAdminStorageServer(t *testing.T) (*Server, *httptest.Server, *historyStorage, *recordingAudit) {
+	t.Helper()
+	s, ts := newTestServer(t)
+	store := historyFixture()
+	s.storage = adminStorage{store}
+	rec := &recordingAudit{}
+	s.audit = rec
+	return s, ts, store, rec
+}
+
+func TestAdmin_CompactDocument(t *testing.T) {
+	_, ts, store, rec := newAdminStorageServer(t)
+
+	resp, body := adminRequest(t, ts, http.MethodPost, "/admin/documents/room:history/compact", adminToken(t),
+		map[string]int{"keepSnapshots": 2})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", resp.StatusCode, body)
+	}
+	if body["snapshotId"] != "snap-compacted" || body["deltasDeleted"] != float64(5) || body["snapshotsDeleted"] != float64(2) {
+		t.Errorf("body = %v, want snap-compacted with 5 deltas and 2 snapshots deleted", body)
+	}
+	if len(store.deltas) != 0 || len(store.snapshots) != 2 {
+		t.Errorf("store has %d deltas and %d snapshots, want 0 and 2", len(store.deltas), len(store.snapshots))
+	}
+
+	events := rec.all()
+	if len(events) != 1 || events[0].Type != audit.EventAdminCompact || events[0].Actor != "admin" || events[0].DocumentID != "room:history" {
+		t.Errorf("audit events = %+v, want admin_compact of room:history by admin", events)
+	}
+
+	resp, body = adminRequest(t, ts, http.MethodPost, "/admin/documents/room:missing/compact", adminToken(t), nil)
+	if resp.StatusCode != http.StatusNotFound || body["code"] != "DOCUMENT_NOT_FOUND" {
+		t.Errorf("missing document = %d %v, want 404 DOCUMENT_NOT_FOUND", resp.StatusCode, body)
+	}
+}
+
+func TestAdmin_RestoreDocument(t *testing.T) {
+	s, ts, _, rec := newAdminStorageServer(t)
+	s.config.AdminAPIKey = "an-admin-api-key-of-at-least-32-characters"
+	ws := dialClient(t, ts, "alice")
+	data, _ := protocol.EncodeMessage(protocol.TypeSubscribe, map[string]interface{}{
+		"type": protocol.TypeSubscribe, "id": "sub", "docId": "room:history",
+	}, 0)
+	ws.WriteMessage(gorilla.BinaryMessage, data)
+	readMessage(t, ws, protocol.TypeSyncResponse)
+
+	resp, body := adminRequest(t, ts, http.MethodPost, "/admin/documents/room:history/restore", s.config.AdminAPIKey,
+		map[string]string{"snapshotId": "snap-2"})
+	if resp.StatusCode != http.StatusOK || body["restored"] != true {
+		t.Fatalf("status = %d, body = %v", resp.StatusCode, body)
+	}
+	msg := readMessage(t, ws, protocol.TypeSyncRequired)
+	if msg.Payload["docId"] != "room:history" || msg.Payload["reason"] != "restored" {
+		t.Errorf("sync_required = %v, want room:history restored", msg.Payload)
+	}
+
+	events := rec.all()
+	if len(events) != 1 || events[0].Type != audit.EventAdminRestore || events[0].Actor != adminAPIKeyActor ||
+		events[0].Details["snapshotId"] != "snap-2" {
+		t.Errorf("audit events = %+v, want admin_restore of snap-2 by the API key", events)
+	}
+
+	resp, body = adminRequest(t, ts, http.MethodPost, "/admin/documents/room:empty/restore", adminToken(t),
+		map[string]string{"snapshotId": "snap-2"})
+	if resp.StatusCode != http.StatusNotFound || body["code"] != "SNAPSHOT_NOT_FOUND" {
+		t.Errorf("snapshot of another document = %d %v, want 404 SNAPSHOT_NOT_FOUND", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// apiKeyHeader carries API keys on REST requests
+const apiKeyHeader = "X-API-Key"
+
+// newAPIKeys accepts the keys of API_KEYS and keeps keys created at runtime
+// in the database, or in memory without one
+func newAPIKeys(cfg *config.Config, db storage.StorageAdapter) *auth.APIKeys {
+	var store auth.APIKeyStore = &auth.MemoryAPIKeys{}
+	if keys, ok := db.(storage.APIKeyStorage); ok {
+		store = storageAPIKeys{keys}
+	}
+	keys, err := auth.NewAPIKeys(cfg.APIKeys, store)
+	if err != nil {
+		slog.Error("Invalid API_KEYS, ignoring them", "err", err)
+		keys, _ = auth.NewAPIKeys(nil, store)
+	}
+	return keys
+}
+
+// checkAPIKey authenticates a request's X-API-Key header. Without the
+// header it returns nil and false. A key that is refused is audited and
+// answered, and nil and true returned.
+func (s *Server) checkAPIKey(w http.ResponseWriter, r *http.Request) (*auth.TokenPayload, bool) {
+	key := r.Header.Get(apiKeyHeader)
+	if key == "" {
+		return nil, false
+	}
+	payload, err := s.apiKeys.Authenticate(r.Context(), key)
+	if err == nil {
+		return payload, true
+	}
+	if !errors.Is(err, auth.ErrInvalidAPIKey) && !errors.Is(err, auth.ErrRevokedAPIKey) {
+		logging.FromContext(r.Context()).Error("Failed to check API key", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to check API key", "INTERNAL_ERROR")
+		return nil, true
+	}
+	s.audit.Log(audit.Event{
+		Type:    audit.EventAuthFailure,
+		IP:      s.getClientIP(r),
+		Details: map[string]interface{}{"path": r.URL.Path, "reason": err.Error()},
+	})
+	writeError(w, http.StatusUnauthorized, "Invalid or revoked API key", "INVALID_API_KEY")
+	return nil, true
+}
+
+// handleAdminAPIKeys serves GET /admin/apikeys (list) and POST
+// /admin/apikeys, which creates a key from {"name", "permissions"}. The key
+// itself is only in the creation response.
+func (s *Server) handleAdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.apiKeys.List(r.Context())
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to list API keys", "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to list API keys", "INTERNAL_ERROR")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"apiKeys": keys,
+			"count":   len(keys),
+		})
+
+	case http.MethodPost:
+		var body struct {
+			Name        string                    `json:"name"`
+			Permissions *auth.DocumentPermissions `json:"permissions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" || body.Permissions == nil {
+			writeBodyError(w, err, "name and permissions are required")
+			return
+		}
+		secret, key, err := auth.NewAPIKey(body.Name, *body.Permissions)
+		if errors.Is(err, auth.ErrInvalidPermissions) {
+			writeError(w, http.StatusBadRequest, err.Error(), "INVALID_REQUEST")
+			return
+		}
+		if err == nil {
+			key.CreatedBy = adminID(r)
+			err = s.apiKeys.Store().CreateAPIKey(r.Context(), key)
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to create API key", "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to create API key", "INTERNAL_ERROR")
+			return
+		}
+		s.audit.Log(audit.Event{
+			Type:    audit.EventAdminAPIKey,
+			Actor:   adminID(r),
+			Details: map[string]interface{}{"action": "create", "keyId": key.ID, "name": key.Name},
+		})
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"apiKey": key,
+			"key":    secret,
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+	}
+}
+
+// handleAdminRevokeAPIKey serves DELETE /admin/apikeys/{id}, which revokes
+// the key and closes the connections using it
+func (s *Server) handleAdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/admin/apikeys/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	if s.apiKeys.IsConfigured(id) {
+		writeError(w, http.StatusConflict, "Configured API keys can only be removed from API_KEYS", "API_KEY_CONFIGURED")
+		return
+	}
+
+	ctx := r.Context()
+	revoked, err := s.apiKeys.Store().RevokeAPIKey(ctx, id)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to revoke API key", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to revoke API key", "INTERNAL_ERROR")
+		return
+	}
+	if !revoked {
+		writeError(w, http.StatusNotFound, "API key not found", "API_KEY_NOT_FOUND")
+		return
+	}
+	// Other servers close their connections at the next delta when
+	// TOKEN_REVOCATION_CHECK_WRITES is set
+	now := time.Now()
+	if err := s.revocations.RevokeUser(ctx, auth.APIKeyUserPrefix+id, now, now.Add(maxTokenLifetime)); err != nil {
+		logging.FromContext(ctx).Warn("Failed to record API key revocation", "err", err)
+	}
+	disconnected := s.hub.DisconnectAPIKey(id, "API key revoked")
+
+	s.audit.Log(audit.Event{
+		Type:    audit.EventAdminAPIKey,
+		Actor:   adminID(r),
+		Details: map[string]interface{}{"action": "revoke", "keyId": id, "disconnected": disconnected},
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"revoked": true, "disconnected": disconnected})
+}
+
+// storageAPIKeys keeps API keys in a storage adapter
+type storageAPIKeys struct {
+	store storage.APIKeyStorage
+}
+
+func (k storageAPIKeys) CreateAPIKey(ctx context.Context, key *auth.APIKey) error {
+	_, err := k.store.SaveAPIKey(ctx, &storage.APIKeyEntry{
+		ID:          key.ID,
+		Name:        key.Name,
+		Prefix:      key.Prefix,
+		KeyHash:     key.Hash,
+		Permissions: permissionsMap(key.Permissions),
+		CreatedBy:   key.CreatedBy,
+		CreatedAt:   key.CreatedAt,
+	})
+	return err
+}
+
+func (k storageAPIKeys) ListAPIKeys(ctx context.Context) ([]*auth.APIKey, error) {
+	entries, err := k.store.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*auth.APIKey, len(entries))
+	for i, entry := range entries {
+		keys[i] = apiKeyFromEntry(entry)
+	}
+	return keys, nil
+}
+
+func (k storageAPIKeys) RevokeAPIKey(ctx context.Context, id string) (bool, error) {
+	return k.store.RevokeAPIKey(ctx, id)
+}
+
+func (k storageAPIKeys) FindAPIKey(ctx context.Context, hash string) (*auth.APIKey, error) {
+	entry, err := k.store.GetAPIKeyByHash(ctx, hash)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return apiKeyFromEntry(entry), nil
+}
+
+func apiKeyFromEntry(entry *storage.APIKeyEntry) *auth.APIKey {
+	var perms auth.DocumentPermissions
+	if data, err := json.Marshal(entry.Permissions); err == nil {
+		json.Unmarshal(data, &perms)
+	}
+	return &auth.APIKey{
+		ID:          entry.ID,
+		Name:        entry.Name,
+		Prefix:      entry.Prefix,
+		Hash:        entry.KeyHash,
+		Permissions: perms,
+		CreatedBy:   entry.CreatedBy,
+		CreatedAt:   entry.CreatedAt,
+		RevokedAt:   entry.RevokedAt,
+	}
+}
+
+// permissionsMap is perms as stored in JSONB
+func permissionsMap(perms auth.DocumentPermissions) map[string]interface{} {
+	stored := map[string]interface{}{
+		"canRead":  perms.CanRead,
+		"canWrite": perms.CanWrite,
+		"isAdmin":  perms.IsAdmin,
+	}
+	if len(perms.CanWriteFields) > 0 {
+		stored["canWriteFields"] = perms.CanWriteFields
+	}
+	return stored
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// apiKeyRequest is adminRequest authenticated with X-API-Key
+func apiKeyRequest(t *testing.T, ts *httptest.Server, method, path, key string, body interface{}) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, ts.URL+path, &buf)
+	req.Header.Set("X-API-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	var decoded map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp, decoded
+}
+
+// sendAPIKeyAuth opens a websocket and authenticates with an API key
+func sendAPIKeyAuth(t *testing.T, ts *httptest.Server, key string) *gorilla.Conn {
+	t.Helper()
+	ws, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	data, _ := protocol.EncodeMessage(protocol.TypeAuth, map[string]interface{}{
+		"type": protocol.TypeAuth, "id": "auth", "apiKey": key,
+	}, time.Now().UnixMilli())
+	ws.WriteMessage(gorilla.BinaryMessage, data)
+	return ws
+}
+
+func subscribe(ws *gorilla.Conn, docID string) {
+	data, _ := protocol.EncodeMessage(protocol.TypeSubscribe, map[string]interface{}{
+		"type": protocol.TypeSubscribe, "id": "sub-" + docID, "docId": docID,
+	}, 0)
+	ws.WriteMessage(gorilla.BinaryMessage, data)
+}
+
+func TestAPIKeys_CreateUseAndRevoke(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{
+		StatsAuthRequired: true,
+		APIKeys:           []string{"ops:" + auth.HashAPIKey("ops-secret") + ":admin"},
+	})
+
+	// A configured admin key manages keys
+	resp, body := apiKeyRequest(t, ts, http.MethodPost, "/admin/apikeys", "ops-secret", map[string]interface{}{
+		"name":        "ingest",
+		"permissions": map[string]interface{}{"canRead": []string{"room:a"}, "canWrite": []string{"room:a"}},
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create = %d %v, want 201", resp.StatusCode, body)
+	}
+	secret, _ := body["key"].(string)
+	created, _ := body["apiKey"].(map[string]interface{})
+	id, _ := created["id"].(string)
+	if secret == "" || id == "" || created["createdBy"] != "apikey:ops" {
+		t.Fatalf("create body = %v, want the key, its ID and creator", body)
+	}
+
+	_, body = apiKeyRequest(t, ts, http.MethodGet, "/admin/apikeys", "ops-secret", nil)
+	if body["count"] != float64(2) {
+		t.Errorf("list = %v, want the configured and created keys", body)
+	}
+	if data, _ := json.Marshal(body); strings.Contains(string(data), auth.HashAPIKey(secret)) {
+		t.Error("list exposes key hashes")
+	}
+
+	// The new key authenticates REST requests but is not an admin
+	if resp, body := apiKeyRequest(t, ts, http.MethodGet, "/stats", secret, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("stats with API key = %d %v, want 200", resp.StatusCode, body)
+	}
+	if resp, body := apiKeyRequest(t, ts, http.MethodGet, "/stats", "sk_wrong", nil); resp.StatusCode != http.StatusUnauthorized || body["code"] != "INVALID_API_KEY" {
+		t.Errorf("stats with a wrong key = %d %v, want 401 INVALID_API_KEY", resp.StatusCode, body)
+	}
+	if resp, _ := apiKeyRequest(t, ts, http.MethodGet, "/admin/apikeys", secret, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("admin with a non-admin key = %d, want 403", resp.StatusCode)
+	}
+
+	// Over the websocket its permissions are scoped to room:a
+	ws := sendAPIKeyAuth(t, ts, secret)
+	if msg := readMessage(t, ws, protocol.TypeAuthSuccess); msg.Payload["userId"] != "apikey:"+id {
+		t.Errorf("auth_success = %v, want apikey:%s", msg.Payload, id)
+	}
+	subscribe(ws, "room:a")
+	readMessage(t, ws, protocol.TypeSyncResponse)
+	subscribe(ws, "room:b")
+	if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "PERMISSION_DENIED" {
+		t.Errorf("subscribe to room:b = %v, want PERMISSION_DENIED", msg.Payload)
+	}
+
+	// Revoking closes the connection and refuses the key
+	resp, body = apiKeyRequest(t, ts, http.MethodDelete, "/admin/apikeys/"+id, "ops-secret", nil)
+	if resp.StatusCode != http.StatusOK || body["disconnected"] != float64(1) {
+		t.Fatalf("revoke = %d %v, want one connection closed", resp.StatusCode, body)
+	}
+	if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "TOKEN_REVOKED" {
+		t.Errorf("revoked connection got %v, want TOKEN_REVOKED", msg.Payload)
+	}
+	if resp, _ := apiKeyRequest(t, ts, http.MethodGet, "/stats", secret, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("stats with a revoked key = %d, want 401", resp.StatusCode)
+	}
+	if msg := readMessage(t, sendAPIKeyAuth(t, ts, secret), protocol.TypeAuthError); msg.Payload["code"] != "INVALID_API_KEY" {
+		t.Errorf("websocket auth with a revoked key = %v, want INVALID_API_KEY", msg.Payload)
+	}
+
+	if resp, body := apiKeyRequest(t, ts, http.MethodDelete, "/admin/apikeys/"+id, "ops-secret", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("revoking twice = %d %v, want 404", resp.StatusCode, body)
+	}
+	if resp, body := apiKeyRequest(t, ts, http.MethodDelete, "/admin/apikeys/ops", "ops-secret", nil); resp.StatusCode != http.StatusConflict || body["code"] != "API_KEY_CONFIGURED" {
+		t.Errorf("revoking a configured key = %d %v, want 409 API_KEY_CONFIGURED", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+)
+
+// Default /auth requests per minute per IP, when AuthRateLimit is unset
+const defaultAuthRateLimit = 10
+
+// SetCredentialVerifier sets what POST /auth/token checks credentials
+// against, replacing AUTH_DEV_USERS. A nil verifier disables the endpoint.
+// Call it before serving.
+func (s *Server) SetCredentialVerifier(v auth.CredentialVerifier) {
+	s.credentials = v
+}
+
+// setupAuth prepares the token endpoints: the rate limiter, where exchanged
+// refresh tokens are remembered, and the dev users from the config
+func (s *Server) setupAuth(cfg *config.Config) {
+	rate := cfg.AuthRateLimit
+	if rate <= 0 {
+		rate = defaultAuthRateLimit
+	}
+	s.authLimiter = security.NewConnectionRateLimiter(rate)
+
+	if s.redis != nil {
+		s.refreshTokens = auth.NewRedisRefreshTokens(s.redis, cfg.RedisChannelPrefix)
+	} else {
+		s.refreshTokens = &auth.MemoryRefreshTokens{}
+	}
+
+	if len(cfg.AuthDevUsers) == 0 {
+		return
+	}
+	if cfg.JWTAlgorithm != "" && cfg.JWTAlgorithm != auth.AlgorithmHS256 {
+		slog.Warn("Ignoring AUTH_DEV_USERS; the tokens it issues are HS256", "jwt_algorithm", cfg.JWTAlgorithm)
+		return
+	}
+	if cfg.Environment == "production" && !cfg.AuthDevUsersInProduction {
+		slog.Warn("Ignoring AUTH_DEV_USERS in production; set AUTH_DEV_USERS_IN_PRODUCTION to use them")
+		return
+	}
+	users, err := auth.NewStaticUsers(cfg.AuthDevUsers)
+	if err != nil {
+		slog.Warn("Invalid AUTH_DEV_USERS, token issuance disabled", "err", err)
+		return
+	}
+	s.credentials = users
+}
+
+// newTokenVerifier builds the verifier of clients' access tokens from
+// JWT_ALGORITHM and its key settings. A configuration that cannot be used
+// is logged and every token refused.
+func newTokenVerifier(cfg *config.Config) auth.TokenVerifier {
+	vc := auth.VerifierConfig{Algorithm: cfg.JWTAlgorithm, Secret: cfg.JWTSecret, Rules: claimRules(cfg)}
+	var err error
+	switch {
+	case cfg.JWTJWKSURL != "":
+		vc.JWKS = auth.NewJWKS(cfg.JWTJWKSURL, auth.JWKSOptions{RefreshInterval: cfg.JWTJWKSRefresh})
+	case cfg.JWTPublicKeyFile != "":
+		vc.PublicKey, err = auth.LoadPublicKey(cfg.JWTPublicKeyFile, cfg.JWTAlgorithm)
+	}
+	var verifier *auth.Verifier
+	if err == nil {
+		verifier, err = auth.NewVerifier(vc)
+	}
+	if err != nil {
+		slog.Error("Invalid JWT verification settings, refusing all tokens", "err", err)
+		return refuseTokens{}
+	}
+	return verifier
+}
+
+// claimRules are the issuer, audience and leeway of JWT_ISSUER,
+// JWT_AUDIENCE and JWT_LEEWAY
+func claimRules(cfg *config.Config) auth.ClaimRules {
+	return auth.ClaimRules{Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience, Leeway: cfg.JWTLeeway}
+}
+
+// refuseTokens is a TokenVerifier rejecting every token
+type refuseTokens struct{}
+
+func (refuseTokens) VerifyToken(string) (*auth.TokenPayload, error) {
+	return nil, auth.ErrInvalidToken
+}
+
+// authRoutes builds the /auth/ route group, rate limited per IP
+func (s *Server) authRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/token", s.handleAuthToken)
+	mux.HandleFunc("/auth/refresh", s.handleAuthRefresh)
+	mux.HandleFunc("/auth/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+	})
+	return s.limitAuth(mux)
+}
+
+// limitAuth rejects requests from IPs over AuthRateLimit, auditing them
+func (s *Server) limitAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := s.getClientIP(r)
+		if !s.authLimiter.Allow(ip) {
+			s.securityManager.RecordRejection("auth")
+			s.audit.Log(audit.Event{
+				Type:    audit.EventRateLimited,
+				IP:      ip,
+				Details: map[string]interface{}{"limit": "auth", "path": r.URL.Path},
+			})
+			writeError(w, http.StatusTooManyRequests, "Too many authentication requests", "RATE_LIMITED")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAuthToken serves POST /auth/token, exchanging an email and password
+// for an access token and a refresh token
+func (s *Server) handleAuthToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	if s.credentials == nil {
+		writeError(w, http.StatusNotFound, "Token issuance is not enabled", "NOT_FOUND")
+		return
+	}
+
+	var body struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" || body.Password == "" {
+		writeBodyError(w, err, "Email and password required")
+		return
+	}
+
+	user, err := s.credentials.VerifyCredentials(r.Context(), body.Email, body.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		s.audit.Log(audit.Event{
+			Type:    audit.EventAuthFailure,
+			Actor:   body.Email,
+			IP:      s.getClientIP(r),
+			Details: map[string]interface{}{"path": r.URL.Path},
+		})
+		writeError(w, http.StatusUnauthorized, "Invalid email or password", "NOT_AUTHENTICATED")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to verify credentials", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to verify credentials", "INTERNAL_ERROR")
+		return
+	}
+
+	accessToken, refreshToken, err := s.issueTokens(user.UserID, user.Email, user.TenantID, user.Permissions)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to issue tokens", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to issue tokens", "INTERNAL_ERROR")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"userId":       user.UserID,
+		"email":        user.Email,
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+		"permissions":  user.Permissions,
+	})
+}
+
+// handleAuthRefresh serves POST /auth/refresh, exchanging a refresh token
+// for a new access token with the same permissions and a new refresh token.
+// Each refresh token is accepted once.
+func (s *Server) handleAuthRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		writeBodyError(w, err, "Refresh token required")
+		return
+	}
+
+	reject := func(reason string) {
+		s.audit.Log(audit.Event{
+			Type:    audit.EventAuthFailure,
+			IP:      s.getClientIP(r),
+			Details: map[string]interface{}{"path": r.URL.Path, "reason": reason},
+		})
+		writeError(w, http.StatusUnauthorized, "Invalid or expired refresh token", "NOT_AUTHENTICATED")
+	}
+	claims, err := auth.VerifyRefreshToken(body.RefreshToken, s.config.JWTSecret, claimRules(s.config))
+	if err != nil {
+		reject("invalid")
+		return
+	}
+	fresh, err := s.refreshTokens.Use(r.Context(), claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to record refresh token", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to record refresh token", "INTERNAL_ERROR")
+		return
+	}
+	if !fresh {
+		reject("reused")
+		return
+	}
+
+	accessToken, refreshToken, err := s.issueTokens(claims.UserID, claims.Email, claims.TenantID, claims.Permissions)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to issue tokens", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to issue tokens", "INTERNAL_ERROR")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+	})
+}
+
+func (s *Server) issueTokens(userID, email, tenantID string, permissions auth.DocumentPermissions) (string, string, error) {
+	rules := claimRules(s.config)
+	accessToken, err := auth.IssueTenantAccessToken(userID, email, tenantID, permissions, s.config.JWTSecret, auth.DefaultAccessTokenTTL, rules)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := auth.IssueTenantRefreshToken(userID, email, tenantID, permissions, s.config.JWTSecret, auth.DefaultRefreshTokenTTL, rules)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
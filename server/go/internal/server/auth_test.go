@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newAuthServer is newTestServer with the given dev users accepted at
+// /auth/token
+func newAuthServer(t *testing.T, users ...string) (*Server, *httptest.Server) {
+	t.Helper()
+	s, ts := newTestServer(t)
+	verifier, err := auth.NewStaticUsers(users)
+	if err != nil {
+		t.Fatalf("NewStaticUsers: %v", err)
+	}
+	s.SetCredentialVerifier(verifier)
+	return s, ts
+}
+
+func authRequest(t *testing.T, ts *httptest.Server, path string, body interface{}) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	return adminRequest(t, ts, http.MethodPost, path, "", body)
+}
+
+func TestAuthToken_IssuesTokensForValidCredentials(t *testing.T) {
+	_, ts := newAuthServer(t, "alice@example.com:hunter2", "root@example.com:s3cret:admin")
+
+	resp, body := authRequest(t, ts, "/auth/token", map[string]string{"email": "alice@example.com", "password": "hunter2"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d %v, want 200", resp.StatusCode, body)
+	}
+	if body["userId"] != "alice@example.com" || body["email"] != "alice@example.com" || body["refreshToken"] == "" {
+		t.Errorf("body = %v, want alice's user ID, email and tokens", body)
+	}
+	perms, _ := body["permissions"].(map[string]interface{})
+	if perms["isAdmin"] != false {
+		t.Errorf("permissions = %v, want a non-admin writer", perms)
+	}
+
+	payload, err := auth.VerifyToken(body["accessToken"].(string), testSecret)
+	if err != nil || payload.UserID != "alice@example.com" || !auth.CanWriteDocument(payload, "doc-1") {
+		t.Errorf("access token = %+v, %v; want alice with write access", payload, err)
+	}
+	if _, err := auth.VerifyToken(body["refreshToken"].(string), testSecret); err == nil {
+		t.Error("refresh token accepted as an access token")
+	}
+
+	_, body = authRequest(t, ts, "/auth/token", map[string]string{"email": "root@example.com", "password": "s3cret"})
+	if perms, _ := body["permissions"].(map[string]interface{}); perms["isAdmin"] != true {
+		t.Errorf("admin permissions = %v, want isAdmin", body["permissions"])
+	}
+}
+
+func TestAuthToken_RejectsBadRequests(t *testing.T) {
+	s, ts := newAuthServer(t, "alice@example.com:hunter2")
+	rec := &recordingAudit{}
+	s.audit = rec
+
+	resp, body := authRequest(t, ts, "/auth/token", map[string]string{"email": "alice@example.com", "password": "wrong"})
+	if resp.StatusCode != http.StatusUnauthorized || body["code"] != "NOT_AUTHENTICATED" {
+		t.Errorf("wrong password = %d %v, want 401 NOT_AUTHENTICATED", resp.StatusCode, body)
+	}
+	if events := rec.ofType(audit.EventAuthFailure); len(events) != 1 || events[0].Actor != "alice@example.com" {
+		t.Errorf("auth_failure events = %+v, want one for alice", events)
+	}
+
+	resp, body = authRequest(t, ts, "/auth/token", map[string]string{"email": "alice@example.com"})
+	if resp.StatusCode != http.StatusBadRequest || body["error"] != "Email and password required" {
+		t.Errorf("missing password = %d %v, want 400", resp.StatusCode, body)
+	}
+
+	resp, _ = adminRequest(t, ts, http.MethodGet, "/auth/token", "", nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestAuthToken_DisabledWithoutVerifier(t *testing.T) {
+	_, ts := newTestServer(t)
+	resp, body := authRequest(t, ts, "/auth/token", map[string]string{"email": "alice@example.com", "password": "hunter2"})
+	if resp.StatusCode != http.StatusNotFound || body["code"] != "NOT_FOUND" {
+		t.Errorf("status = %d %v, want 404 NOT_FOUND", resp.StatusCode, body)
+	}
+}
+
+func TestSetupAuth_IgnoresDevUsersInProduction(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cfg     config.Config
+		enabled bool
+	}{
+		{"development", config.Config{Environment: "development"}, true},
+		{"production", config.Config{Environment: "production"}, false},
+		{"production opted in", config.Config{Environment: "production", AuthDevUsersInProduction: true}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.JWTSecret = testSecret
+			cfg.AuthDevUsers = []string{"alice@example.com:hunter2"}
+			s := New(&cfg)
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				s.Shutdown(ctx)
+			}()
+			if enabled := s.credentials != nil; enabled != tc.enabled {
+				t.Errorf("token issuance enabled = %v, want %v", enabled, tc.enabled)
+			}
+		})
+	}
+}
+
+func TestAuthRefresh_RotatesRefreshToken(t *testing.T) {
+	s, ts := newAuthServer(t, "alice@example.com:hunter2:reader")
+	rec := &recordingAudit{}
+	s.audit = rec
+
+	_, issued := authRequest(t, ts, "/auth/token", map[string]string{"email": "alice@example.com", "password": "hunter2"})
+	first := issued["refreshToken"].(string)
+
+	resp, body := authRequest(t, ts, "/auth/refresh", map[string]string{"refreshToken": first})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("refresh = %d %v, want 200", resp.StatusCode, body)
+	}
+	second, _ := body["refreshToken"].(string)
+	if second == "" || second == first {
+		t.Errorf("refresh token was not rotated: %v", body)
+	}
+	payload, err := auth.VerifyToken(body["accessToken"].(string), testSecret)
+	if err != nil || payload.UserID != "alice@example.com" || payload.Email != "alice@example.com" {
+		t.Fatalf("access token = %+v, %v; want alice", payload, err)
+	}
+	if !auth.CanReadDocument(payload, "doc-1") || auth.CanWriteDocument(payload, "doc-1") {
+		t.Errorf("permissions = %+v, want the reader's", payload.Permissions)
+	}
+
+	// The exchanged token is spent; its replacement still works once
+	resp, body = authRequest(t, ts, "/auth/refresh", map[string]string{"refreshToken": first})
+	if resp.StatusCode != http.StatusUnauthorized || body["error"] != "Invalid or expired refresh token" {
+		t.Errorf("reused refresh token = %d %v, want 401", resp.StatusCode, body)
+	}
+	if events := rec.ofType(audit.EventAuthFailure); len(events) != 1 || events[0].Details["reason"] != "reused" {
+		t.Errorf("auth_failure events = %+v, want one for the reuse", events)
+	}
+	if resp, body := authRequest(t, ts, "/auth/refresh", map[string]string{"refreshToken": second}); resp.StatusCode != http.StatusOK {
+		t.Errorf("rotated refresh token = %d %v, want 200", resp.StatusCode, body)
+	}
+}
+
+func TestAuthRefresh_RejectsInvalidTokens(t *testing.T) {
+	_, ts := newAuthServer(t)
+	perms := auth.CreateUserPermissions([]string{"*"}, nil)
+
+	expired, err := auth.IssueRefreshToken("alice", "", perms, testSecret, -time.Minute, auth.ClaimRules{})
+	if err != nil {
+		t.Fatalf("IssueRefreshToken: %v", err)
+	}
+	otherSecret, _ := auth.IssueRefreshToken("alice", "", perms, "another-secret-that-is-at-least-32-characters", time.Hour, auth.ClaimRules{})
+	for name, token := range map[string]string{
+		"expired":        expired,
+		"access token":   tokenFor(t, "alice", perms),
+		"wrong secret":   otherSecret,
+		"legacy refresh": mustLegacyRefreshToken(t),
+		"not a token":    "not-a-token",
+	} {
+		resp, body := authRequest(t, ts, "/auth/refresh", map[string]string{"refreshToken": token})
+		if resp.StatusCode != http.StatusUnauthorized || body["code"] != "NOT_AUTHENTICATED" {
+			t.Errorf("%s: %d %v, want 401 NOT_AUTHENTICATED", name, resp.StatusCode, body)
+		}
+	}
+
+	resp, body := authRequest(t, ts, "/auth/refresh", map[string]string{})
+	if resp.StatusCode != http.StatusBadRequest || body["error"] != "Refresh token required" {
+		t.Errorf("missing token = %d %v, want 400", resp.StatusCode, body)
+	}
+}
+
+func TestAuth_RateLimitedPerIP(t *testing.T) {
+	s, ts := newAuthServer(t, "alice@example.com:hunter2")
+	s.authLimiter.Dispose()
+	s.authLimiter = security.NewConnectionRateLimiter(2)
+	rec := &recordingAudit{}
+	s.audit = rec
+
+	authRequest(t, ts, "/auth/token", map[string]string{"email": "alice@example.com", "password": "wrong"})
+	authRequest(t, ts, "/auth/refresh", map[string]string{"refreshToken": "not-a-token"})
+	resp, body := authRequest(t, ts, "/auth/token", map[string]string{"email": "alice@example.com", "password": "hunter2"})
+	if resp.StatusCode != http.StatusTooManyRequests || body["code"] != "RATE_LIMITED" {
+		t.Errorf("third request = %d %v, want 429 RATE_LIMITED", resp.StatusCode, body)
+	}
+	if events := rec.ofType(audit.EventRateLimited); len(events) != 1 || events[0].Details["limit"] != "auth" {
+		t.Errorf("rate_limited events = %+v, want one for auth", events)
+	}
+}
+
+// mustLegacyRefreshToken makes a refresh token in the old format, which
+// carries no permissions or ID
+func mustLegacyRefreshToken(t *testing.T) string {
+	t.Helper()
+	token, err := auth.GenerateRefreshToken("alice", testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	return token
+}
+
+func TestServer_VerifiesRS256TokensFromPublicKey(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+
+	ts := newServerWithConfig(t, &config.Config{JWTAlgorithm: auth.AlgorithmRS256, JWTPublicKeyFile: path})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &auth.TokenPayload{
+		UserID:      "admin",
+		Permissions: auth.CreateAdminPermissions(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString failed: %v", err)
+	}
+
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/connections", signed, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("RS256 admin token: status %d, want 200", resp.StatusCode)
+	}
+	dialWithToken(t, ts, signed, "")
+
+	// HS256 tokens signed with the old secret are no longer accepted
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/connections", adminToken(t), nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("HS256 admin token: status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestServer_IssuesAndRequiresIssuerAndAudience(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{
+		JWTIssuer:    "https://synckit.example.com",
+		JWTAudience:  "synckit",
+		AuthDevUsers: []string{"root@example.com:s3cret:admin"},
+	})
+
+	_, body := authRequest(t, ts, "/auth/token", map[string]string{"email": "root@example.com", "password": "s3cret"})
+	accessToken, _ := body["accessToken"].(string)
+	payload, err := auth.VerifyTokenWithRules(accessToken, testSecret, auth.ClaimRules{Issuer: "https://synckit.example.com", Audience: "synckit"})
+	if err != nil {
+		t.Fatalf("issued token does not carry the issuer and audience: %v", err)
+	}
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/connections", accessToken, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("issued token: status %d, want 200", resp.StatusCode)
+	}
+	if resp, body := authRequest(t, ts, "/auth/refresh", map[string]string{"refreshToken": body["refreshToken"].(string)}); resp.StatusCode != http.StatusOK {
+		t.Errorf("refresh: status %d %v, want 200", resp.StatusCode, body)
+	}
+
+	// A token without them, as for another service, is refused
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/connections", adminToken(t), nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("token without issuer and audience: status %d, want 401", resp.StatusCode)
+	}
+	if payload.UserID != "root@example.com" {
+		t.Errorf("UserID = %q, want root@example.com", payload.UserID)
+	}
+}
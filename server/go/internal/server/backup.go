@@ -0,0 +1,294 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// Backup destinations
+const (
+	backupToFile = "file"
+	backupToS3   = "s3"
+)
+
+// backupTimeout bounds one backup, which reads every document
+const backupTimeout = 30 * time.Minute
+
+// backupRequest is the optional body of POST /admin/backup
+type backupRequest struct {
+	Destination string `json:"destination"` // file or s3; file when BACKUP_DIR is set
+	Name        string `json:"name"`        // File name in BACKUP_DIR or object key
+}
+
+// backupResponse reports where a backup went and what it holds
+type backupResponse struct {
+	Destination string                `json:"destination"`
+	Location    string                `json:"location"`
+	Bytes       int64                 `json:"bytes"`
+	Counts      *storage.BackupCounts `json:"counts"`
+}
+
+// handleAdminBackup serves POST /admin/backup: it writes a backup of every
+// document to a file in BACKUP_DIR or uploads it to BACKUP_S3_BUCKET
+func (s *Server) handleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	if s.storage == nil {
+		writeError(w, http.StatusServiceUnavailable, "Storage is not configured", "STORAGE_UNAVAILABLE")
+		return
+	}
+
+	var req backupRequest
+	if !decodeOptionalBody(w, r, &req) {
+		return
+	}
+	if req.Destination == "" {
+		req.Destination = backupToFile
+		if s.config.BackupDir == "" {
+			req.Destination = backupToS3
+		}
+	}
+	switch {
+	case req.Destination == backupToFile && s.config.BackupDir == "",
+		req.Destination == backupToS3 && s.config.BackupS3Bucket == "":
+		writeError(w, http.StatusBadRequest, "No backup destination is configured", "BACKUP_NOT_CONFIGURED")
+		return
+	case req.Destination != backupToFile && req.Destination != backupToS3:
+		writeError(w, http.StatusBadRequest, "destination must be file or s3", "INVALID_REQUEST")
+		return
+	}
+	if req.Name == "" {
+		req.Name = "synckit-backup-" + time.Now().UTC().Format("20060102T150405Z") + ".jsonl"
+	}
+	if !validBackupName(req.Destination, req.Name) {
+		writeError(w, http.StatusBadRequest, "Invalid backup name", "INVALID_REQUEST")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), backupTimeout)
+	defer cancel()
+	var res *backupResponse
+	var err error
+	if req.Destination == backupToFile {
+		res, err = s.backupToFile(ctx, req.Name)
+	} else {
+		res, err = s.backupToS3(ctx, req.Name)
+	}
+	switch {
+	case errors.Is(err, storage.ErrNotSupported):
+		writeError(w, http.StatusNotImplemented, "Storage does not support backups", "NOT_SUPPORTED")
+		return
+	case errors.Is(err, os.ErrExist):
+		writeError(w, http.StatusConflict, "A backup with that name already exists", "BACKUP_EXISTS")
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).Error("Backup failed", "destination", req.Destination, "name", req.Name, "err", err)
+		writeError(w, http.StatusInternalServerError, "Backup failed", "BACKUP_FAILED")
+		return
+	}
+
+	s.audit.Log(audit.Event{
+		Type:    audit.EventAdminBackup,
+		Actor:   adminID(r),
+		Details: map[string]interface{}{"destination": res.Destination, "location": res.Location, "counts": res.Counts},
+	})
+	writeJSON(w, http.StatusOK, res)
+}
+
+// validBackupName reports whether name is a plain file name for file
+// backups, so requests cannot write outside BACKUP_DIR, or a clean relative
+// object key for S3
+func validBackupName(destination, name string) bool {
+	if destination == backupToFile {
+		return name == filepath.Base(name) && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+	}
+	return len(name) <= 1024 && path.Clean(name) == name && !strings.HasPrefix(name, "/") &&
+		name != "." && name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// writeBackup writes a backup to a new temporary file in dir, returning the
+// file positioned at its end with the counts and the SHA-256 of its content
+func (s *Server) writeBackup(ctx context.Context, dir string) (*os.File, *storage.BackupCounts, string, error) {
+	f, err := os.CreateTemp(dir, ".synckit-backup-*")
+	if err != nil {
+		return nil, nil, "", err
+	}
+	hash := sha256.New()
+	buf := bufio.NewWriter(io.MultiWriter(f, hash))
+	counts, err := storage.Backup(ctx, s.storage, buf)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, nil, "", err
+	}
+	return f, counts, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// backupToFile writes the backup to name in BACKUP_DIR, linking it into
+// place once complete so a failed backup never leaves a partial archive.
+// Linking fails if name exists, even when it was created after the check
+// made before the backup was written, so archives are never replaced.
+func (s *Server) backupToFile(ctx context.Context, name string) (*backupResponse, error) {
+	target := filepath.Join(s.config.BackupDir, name)
+	if _, err := os.Stat(target); err == nil {
+		return nil, os.ErrExist
+	}
+	f, counts, _, err := s.writeBackup(ctx, s.config.BackupDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	size, _ := f.Seek(0, io.SeekCurrent)
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Link(f.Name(), target); err != nil {
+		return nil, err
+	}
+	return &backupResponse{Destination: backupToFile, Location: target, Bytes: size, Counts: counts}, nil
+}
+
+// backupToS3 writes the backup to a temporary file, which S3 needs to know
+// the length and hash of the upload before it starts, then uploads it
+func (s *Server) backupToS3(ctx context.Context, key string) (*backupResponse, error) {
+	f, counts, hash, err := s.writeBackup(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, err
+	}
+	location, err := s.uploadToS3(ctx, key, f, size, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &backupResponse{Destination: backupToS3, Location: location, Bytes: size, Counts: counts}, nil
+}
+
+// uploadToS3 PUTs body to key in BACKUP_S3_BUCKET, addressing the bucket
+// by path so it works with S3-compatible services too
+func (s *Server) uploadToS3(ctx context.Context, key string, body io.Reader, size int64, payloadHash string) (string, error) {
+	endpoint := strings.TrimSuffix(s.config.BackupS3Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://s3." + s.config.BackupS3Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/"+s3Escape(s.config.BackupS3Bucket+"/"+key), body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	signS3(req, payloadHash, s.config.BackupS3AccessKey, s.config.BackupS3SecretKey, s.config.BackupS3Region, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("S3 returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return "s3://" + s.config.BackupS3Bucket + "/" + key, nil
+}
+
+// signS3 signs req for S3 with AWS Signature Version 4, covering the host
+// and every header already set on req
+func signS3(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{day, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		",SignedHeaders="+signedHeaders+",Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes an object path the way Signature Version 4
+// expects: everything but unreserved characters and slashes
+func s3Escape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
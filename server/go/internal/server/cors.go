@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// corsMaxAge is how long browsers may cache a preflight result
+const corsMaxAge = 10 * 60
+
+// corsMiddleware answers CORS using the origin policy that guards websocket
+// upgrades. An allowlisted origin is echoed back with credentials allowed;
+// a fully open policy sends "*", which browsers refuse to combine with
+// credentials, so that header is left out. Other origins get no CORS headers,
+// and their preflights are refused.
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		header := w.Header()
+		header.Add("Vary", "Origin")
+
+		allowed := origin != "" && s.origins.Allows(origin)
+		if allowed {
+			if s.origins.AllowsAnyOrigin() {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			header.Set("Access-Control-Expose-Headers", RequestIDHeader)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				writeError(w, http.StatusForbidden, "Origin not allowed", "ORIGIN_NOT_ALLOWED")
+				return
+			}
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			header.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+apiKeyHeader+", "+RequestIDHeader)
+			header.Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	allowlist := security.OriginRules{
+		Mode:    security.OriginPolicyStrict,
+		Allowed: []string{"https://app.example.com", "https://*.example.org"},
+	}
+	open := security.OriginRules{}
+
+	tests := []struct {
+		name        string
+		rules       security.OriginRules
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantOrigin  string
+		wantCreds   string
+		wantMethods bool
+	}{
+		{"allowed origin", allowlist, "GET", "https://app.example.com", false, http.StatusOK, "https://app.example.com", "true", false},
+		{"allowed wildcard subdomain", allowlist, "GET", "https://docs.example.org", false, http.StatusOK, "https://docs.example.org", "true", false},
+		{"disallowed origin", allowlist, "GET", "https://evil.example.net", false, http.StatusOK, "", "", false},
+		{"no origin", allowlist, "GET", "", false, http.StatusOK, "", "", false},
+		{"allowed preflight", allowlist, "OPTIONS", "https://app.example.com", true, http.StatusNoContent, "https://app.example.com", "true", true},
+		{"disallowed preflight", allowlist, "OPTIONS", "https://evil.example.net", true, http.StatusForbidden, "", "", false},
+		{"plain OPTIONS reaches handler", allowlist, "OPTIONS", "https://app.example.com", false, http.StatusOK, "https://app.example.com", "true", false},
+		{"open policy", open, "GET", "https://anywhere.test", false, http.StatusOK, "*", "", false},
+		{"open policy preflight", open, "OPTIONS", "https://anywhere.test", true, http.StatusNoContent, "*", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := security.NewOriginPolicy(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{origins: policy}
+			handler := s.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(tt.method, "/api/documents/room:a/deltas", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", "GET")
+				req.Header.Set("Access-Control-Request-Headers", "Authorization")
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			h := rec.Header()
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Errorf("Allow-Credentials = %q, want %q", got, tt.wantCreds)
+			}
+			if got := h.Get("Access-Control-Allow-Methods") != ""; got != tt.wantMethods {
+				t.Errorf("Allow-Methods present = %v, want %v", got, tt.wantMethods)
+			}
+			if h.Values("Vary")[0] != "Origin" {
+				t.Errorf("Vary = %v, want Origin first", h.Values("Vary"))
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/websocket"
+)
+
+// eventStreamHeartbeat is how often an event stream gets a comment line, so
+// proxies do not time out idle streams
+var eventStreamHeartbeat = 15 * time.Second
+
+// handleDocuments sends GET /api/documents/{id}/events to the event stream,
+// which authenticates itself, permission changes to the ACL endpoints and
+// everything else to the history endpoints
+func (s *Server) handleDocuments(w http.ResponseWriter, r *http.Request) {
+	docID, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/documents/"), "/")
+	if docID != "" && resource == "events" {
+		s.handleDocumentEvents(w, r, docID)
+		return
+	}
+	if docID != "" && resource == "share" {
+		s.requireAuth(func(w http.ResponseWriter, r *http.Request) { s.handleDocumentShare(w, r, docID) })(w, r)
+		return
+	}
+	if docID != "" && (resource == "permissions" || strings.HasPrefix(resource, "permissions/")) {
+		s.requireAuth(s.handleDocumentPermissions)(w, r)
+		return
+	}
+	s.requireAuth(s.handleDocumentHistory)(w, r)
+}
+
+// handleDocumentEvents serves a read-only Server-Sent Events stream of a
+// document for clients that cannot open a websocket. The token comes from
+// the Authorization header or, since EventSource cannot set headers, the
+// access_token query parameter. The first event is the sync_response; each
+// event carrying a sequence number uses it as the event ID, so a reconnect
+// with Last-Event-ID resumes from there.
+func (s *Server) handleDocumentEvents(w http.ResponseWriter, r *http.Request, docID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	if s.shuttingDown.Load() {
+		writeError(w, http.StatusServiceUnavailable, "Server is shutting down", "SHUTTING_DOWN")
+		return
+	}
+
+	payload, ok := s.checkAPIKey(w, r)
+	if ok && payload == nil {
+		return
+	}
+	if !ok {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("access_token")
+		}
+		var err error
+		payload, err = s.verifyToken(r.Context(), token)
+		if token == "" || err != nil {
+			writeError(w, http.StatusUnauthorized, "Missing or invalid token", "NOT_AUTHENTICATED")
+			return
+		}
+	}
+	if payload = s.scopeTenant(w, r, payload); payload == nil {
+		return
+	}
+	if !s.canReadDocument(r.Context(), payload, docID) {
+		writeError(w, http.StatusForbidden, "Permission denied", "PERMISSION_DENIED")
+		return
+	}
+
+	opts := websocket.EventStreamOptions{Token: payload, ClientIP: s.getClientIP(r)}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	if lastEventID != "" {
+		seq, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || seq < 0 {
+			writeError(w, http.StatusBadRequest, "Last-Event-ID must be a sequence number", "INVALID_REQUEST")
+			return
+		}
+		opts.ResumeFrom, opts.Resume = seq, true
+	}
+
+	// Streams hold a connection slot like websockets do
+	if s.securityManager.Bans.IsBanned(opts.ClientIP) {
+		writeError(w, http.StatusForbidden, "Your IP is banned", "IP_BANNED")
+		return
+	}
+	if !s.securityManager.ConnectionLimiter.CanConnect(opts.ClientIP) {
+		s.securityManager.RecordRejection("connections")
+		s.audit.Log(audit.Event{
+			Type:    audit.EventRateLimited,
+			IP:      opts.ClientIP,
+			Details: map[string]interface{}{"limit": "connections"},
+		})
+		writeError(w, http.StatusTooManyRequests, "Too many connections from your IP", "RATE_LIMITED")
+		return
+	}
+	s.securityManager.ConnectionLimiter.AddConnection(opts.ClientIP)
+	defer s.securityManager.ConnectionLimiter.RemoveConnection(opts.ClientIP)
+
+	ctx := r.Context()
+	stream, err := s.hub.OpenEventStream(ctx, docID, opts)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "Server is shutting down", "SHUTTING_DOWN")
+		return
+	}
+	defer stream.Close()
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	messages := make(chan *protocol.Message)
+	go func() {
+		defer close(messages)
+		for {
+			msg, err := stream.Next(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	logger := logging.FromContext(ctx).With("doc_id", docID, "user_id", payload.UserID)
+	logger.Debug("Event stream opened", "resume", opts.Resume)
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, msg); err != nil {
+				return
+			}
+			rc.Flush()
+			// A refused subscription leaves nothing to stream
+			if msg.Type == protocol.TypeError {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			rc.Flush()
+		case <-ctx.Done():
+			logger.Debug("Event stream closed")
+			return
+		}
+	}
+}
+
+// writeEvent writes msg as a Server-Sent Event named after its type, with its
+// sequence number, if any, as the event ID
+func writeEvent(w io.Writer, msg *protocol.Message) error {
+	data, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return err
+	}
+	var event strings.Builder
+	if seq, ok := msg.Payload["seq"].(float64); ok {
+		fmt.Fprintf(&event, "id: %d\n", int64(seq))
+	}
+	fmt.Fprintf(&event, "event: %s\ndata: %s\n\n", msg.Type, data)
+	_, err = io.WriteString(w, event.String())
+	return err
+}
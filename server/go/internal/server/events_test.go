@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// sseEvent is one Server-Sent Event as read off the wire
+type sseEvent struct {
+	id, event string
+	data      map[string]interface{}
+}
+
+// openEvents opens the event stream of docID, sending lastEventID when set
+func openEvents(t *testing.T, ts *httptest.Server, docID, token, lastEventID string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/documents/"+docID+"/events", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET events failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// readEvent reads the next event, skipping comments
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var ev sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && ev.event != "":
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.data); err != nil {
+				t.Fatalf("event data %q: %v", line, err)
+			}
+		}
+	}
+}
+
+// writeDelta sends a delta over ws and waits for its ACK
+func writeDelta(t *testing.T, ws *gorilla.Conn, docID, key string, value interface{}) {
+	t.Helper()
+	data, _ := protocol.EncodeMessage(protocol.TypeDelta, map[string]interface{}{
+		"type":    protocol.TypeDelta,
+		"id":      "delta-" + key,
+		"docId":   docID,
+		"changes": map[string]interface{}{key: value},
+	}, time.Now().UnixMilli())
+	if err := ws.WriteMessage(gorilla.BinaryMessage, data); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	readMessage(t, ws, protocol.TypeAck)
+}
+
+func TestEvents_StreamsDeltasAndResumes(t *testing.T) {
+	_, ts := newTestServer(t)
+	writer := dialWithToken(t, ts, tokenFor(t, "writer", auth.CreateUserPermissions([]string{"*"}, []string{"*"})), "")
+	writeDelta(t, writer, "room:sse", "a", 1.0)
+	reader := tokenFor(t, "reader", auth.CreateUserPermissions([]string{"room:sse"}, nil))
+
+	resp, events := openEvents(t, ts, "room:sse", reader, "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, Content-Type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	first := readEvent(t, events)
+	state, _ := first.data["state"].(map[string]interface{})
+	if first.event != protocol.TypeSyncResponse || first.id != "1" || state["a"] != 1.0 {
+		t.Fatalf("first event = %+v, want sync_response at seq 1 with the current state", first)
+	}
+
+	writeDelta(t, writer, "room:sse", "b", 2.0)
+	writeDelta(t, writer, "room:sse", "c", 3.0)
+	for _, want := range []string{"2", "3"} {
+		if ev := readEvent(t, events); ev.event != protocol.TypeDelta || ev.id != want {
+			t.Errorf("event = %+v, want delta %s", ev, want)
+		}
+	}
+	resp.Body.Close()
+
+	writeDelta(t, writer, "room:sse", "d", 4.0)
+	_, events = openEvents(t, ts, "room:sse", reader, "3")
+	resumed := readEvent(t, events)
+	deltas, _ := resumed.data["deltas"].([]interface{})
+	if resumed.event != protocol.TypeSyncResponse || resumed.data["resumed"] != true || len(deltas) != 1 || resumed.id != "4" {
+		t.Errorf("resumed event = %+v, want sync_response at seq 4 replaying one delta", resumed)
+	}
+}
+
+func TestEvents_Authentication(t *testing.T) {
+	_, ts := newTestServer(t)
+	reader := tokenFor(t, "reader", auth.CreateUserPermissions([]string{"room:sse"}, nil))
+
+	if resp, _ := openEvents(t, ts, "room:sse", "", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a token status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if resp, _ := openEvents(t, ts, "room:other", reader, ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("without read permission status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if resp, _ := openEvents(t, ts, "room:sse", reader, "yesterday"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed Last-Event-ID status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	// EventSource cannot set headers, so the token may come as a query parameter
+	resp, err := http.Get(ts.URL + "/api/documents/room:sse/events?access_token=" + reader)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ev := readEvent(t, bufio.NewReader(resp.Body)); resp.StatusCode != http.StatusOK || ev.event != protocol.TypeSyncResponse {
+		t.Errorf("query token = %d %+v, want 200 and a sync_response", resp.StatusCode, ev)
+	}
+}
+
+func TestEvents_Heartbeat(t *testing.T) {
+	defer func(d time.Duration) { eventStreamHeartbeat = d }(eventStreamHeartbeat)
+	eventStreamHeartbeat = 20 * time.Millisecond
+	_, ts := newTestServer(t)
+
+	_, events := openEvents(t, ts, "room:sse", adminToken(t), "")
+	readEvent(t, events)
+	if line, err := events.ReadString('\n'); err != nil || line != ": heartbeat\n" {
+		t.Errorf("after the first event read %q, %v; want a heartbeat comment", line, err)
+	}
+}
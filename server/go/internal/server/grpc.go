@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+
+	"github.com/Dancode-188/synckit/server/go/internal/grpc"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// newGRPCServer builds the DocumentService server, sharing the hub, storage
+// and policies of the HTTP server and its TLS settings when configured
+func (s *Server) newGRPCServer() (*grpclib.Server, error) {
+	var opts []grpclib.ServerOption
+	if s.config.TLSEnabled() {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		// gRPC needs HTTP/2, which the websocket listener does not offer
+		tlsConfig.NextProtos = []string{"h2"}
+		opts = append(opts, grpclib.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	return grpc.NewServer(grpc.Options{
+		Hub:             s.hub,
+		Storage:         s.storage,
+		JWTSecret:       s.config.JWTSecret,
+		Verifier:        s.verifier,
+		Revocations:     s.revocations,
+		PublicDocuments: s.publicDocs,
+		ACL:             s.acl,
+		SecurityManager: s.securityManager,
+		MultiTenancy:    s.config.MultiTenancy,
+		Audit:           s.audit,
+		Logger:          s.logger,
+	}, opts...), nil
+}
+
+// stopGRPC lets in-flight calls finish, cutting them off when ctx ends.
+// Watch streams end on their own once the hub has stopped.
+func stopGRPC(ctx context.Context, server *grpclib.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
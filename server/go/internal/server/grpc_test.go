@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/grpc/documentpb"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	gorilla "github.com/gorilla/websocket"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// dialGRPC serves s's DocumentService in process and connects to it
+func dialGRPC(t *testing.T, s *Server) documentpb.DocumentServiceClient {
+	t.Helper()
+	srv, err := s.newGRPCServer()
+	if err != nil {
+		t.Fatalf("newGRPCServer failed: %v", err)
+	}
+	ln := bufconn.Listen(1 << 20)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpclib.NewClient("passthrough:///bufconn",
+		grpclib.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpclib.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return documentpb.NewDocumentServiceClient(conn)
+}
+
+// withToken returns a context sending token as gRPC metadata
+func withToken(t *testing.T, token string) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func mustStruct(t *testing.T, m map[string]interface{}) *structpb.Struct {
+	t.Helper()
+	s, err := structpb.NewStruct(m)
+	if err != nil {
+		t.Fatalf("NewStruct failed: %v", err)
+	}
+	return s
+}
+
+func TestGRPC_PatchIsBroadcastToWebsockets(t *testing.T) {
+	s, ts := newTestServer(t)
+	client := dialGRPC(t, s)
+	ws := dialClient(t, ts, "alice")
+	data, _ := protocol.EncodeMessage(protocol.TypeSubscribe, map[string]interface{}{
+		"type": protocol.TypeSubscribe, "id": "sub", "docId": "room:grpc",
+	}, 0)
+	ws.WriteMessage(gorilla.BinaryMessage, data)
+	readMessage(t, ws, protocol.TypeSyncResponse)
+
+	ctx := withToken(t, tokenFor(t, "backend", auth.CreateUserPermissions([]string{"*"}, []string{"*"})))
+	resp, err := client.Patch(ctx, &documentpb.PatchRequest{
+		DocId:   "room:grpc",
+		Changes: mustStruct(t, map[string]interface{}{"title": "From gRPC"}),
+	})
+	if err != nil || !resp.Applied || resp.Seq != 1 {
+		t.Fatalf("Patch = %v, %v; want applied at seq 1", resp, err)
+	}
+
+	delta := readMessage(t, ws, protocol.TypeDelta)
+	changes, _ := delta.Payload["changes"].(map[string]interface{})
+	if delta.Payload["docId"] != "room:grpc" || changes["title"] != "From gRPC" {
+		t.Errorf("websocket delta = %v, want the patched title", delta.Payload)
+	}
+
+	doc, err := client.Get(ctx, &documentpb.GetRequest{DocId: "room:grpc"})
+	if err != nil || doc.State.AsMap()["title"] != "From gRPC" || doc.Seq != 1 {
+		t.Errorf("Get = %v, %v; want the patched state at seq 1", doc, err)
+	}
+}
+
+func TestGRPC_Documents(t *testing.T) {
+	s, _ := newTestServer(t)
+	client := dialGRPC(t, s)
+	ctx := withToken(t, adminToken(t))
+
+	for _, docID := range []string{"room:list-a", "room:list-b", "room:c"} {
+		_, err := client.Put(ctx, &documentpb.PutRequest{DocId: docID, State: mustStruct(t, map[string]interface{}{"n": 1.0})})
+		if err != nil {
+			t.Fatalf("Put %s failed: %v", docID, err)
+		}
+	}
+	page, err := client.List(ctx, &documentpb.ListRequest{Prefix: "room:list-", Limit: 1})
+	if err != nil || len(page.DocIds) != 1 || page.DocIds[0] != "room:list-a" || page.NextCursor != "room:list-a" {
+		t.Fatalf("first page = %v, %v; want [room:list-a] with a cursor", page, err)
+	}
+	page, err = client.List(ctx, &documentpb.ListRequest{Prefix: "room:list-", Cursor: page.NextCursor})
+	if err != nil || len(page.DocIds) != 1 || page.DocIds[0] != "room:list-b" || page.NextCursor != "" {
+		t.Fatalf("second page = %v, %v; want [room:list-b] and no cursor", page, err)
+	}
+
+	deleted, err := client.Delete(ctx, &documentpb.DeleteRequest{DocId: "room:c"})
+	if err != nil || !deleted.Deleted {
+		t.Fatalf("Delete = %v, %v", deleted, err)
+	}
+	if deleted, err := client.Delete(ctx, &documentpb.DeleteRequest{DocId: "room:c"}); err != nil || deleted.Deleted {
+		t.Errorf("deleting twice = %v, %v; want no deletion", deleted, err)
+	}
+	doc, err := client.Get(ctx, &documentpb.GetRequest{DocId: "room:c"})
+	if err != nil || len(doc.State.AsMap()) != 0 {
+		t.Errorf("Get after Delete = %v, %v; want an empty document", doc, err)
+	}
+}
+
+func TestGRPC_Authorization(t *testing.T) {
+	s, _ := newTestServer(t)
+	client := dialGRPC(t, s)
+	reader := withToken(t, tokenFor(t, "reader", auth.CreateUserPermissions([]string{"room:grpc"}, nil)))
+	changes := mustStruct(t, map[string]interface{}{"a": 1.0})
+
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{"no token", func() error {
+			_, err := client.Get(context.Background(), &documentpb.GetRequest{DocId: "room:grpc"})
+			return err
+		}, codes.Unauthenticated},
+		{"bad token", func() error {
+			_, err := client.Get(withToken(t, "not-a-jwt"), &documentpb.GetRequest{DocId: "room:grpc"})
+			return err
+		}, codes.Unauthenticated},
+		{"read elsewhere", func() error {
+			_, err := client.Get(reader, &documentpb.GetRequest{DocId: "room:other"})
+			return err
+		}, codes.PermissionDenied},
+		{"patch read-only", func() error {
+			_, err := client.Patch(reader, &documentpb.PatchRequest{DocId: "room:grpc", Changes: changes})
+			return err
+		}, codes.PermissionDenied},
+		{"put read-only", func() error {
+			_, err := client.Put(reader, &documentpb.PutRequest{DocId: "room:grpc", State: changes})
+			return err
+		}, codes.PermissionDenied},
+		{"delete read-only", func() error {
+			_, err := client.Delete(reader, &documentpb.DeleteRequest{DocId: "room:grpc"})
+			return err
+		}, codes.PermissionDenied},
+		{"invalid document ID", func() error {
+			_, err := client.Put(withToken(t, adminToken(t)), &documentpb.PutRequest{DocId: "", State: changes})
+			return err
+		}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		if got := status.Code(tt.call()); got != tt.want {
+			t.Errorf("%s: code = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGRPC_WatchDocument(t *testing.T) {
+	s, ts := newTestServer(t)
+	client := dialGRPC(t, s)
+	writer := dialWithToken(t, ts, tokenFor(t, "writer", auth.CreateUserPermissions([]string{"*"}, []string{"*"})), "")
+	writeDelta(t, writer, "room:watch", "a", 1.0)
+
+	ctx := withToken(t, tokenFor(t, "reader", auth.CreateUserPermissions([]string{"room:watch"}, nil)))
+	stream, err := client.WatchDocument(ctx, &documentpb.WatchRequest{DocId: "room:watch"})
+	if err != nil {
+		t.Fatalf("WatchDocument failed: %v", err)
+	}
+	ev, err := stream.Recv()
+	if err != nil || ev.GetDocument().GetSeq() != 1 || ev.GetDocument().GetState().AsMap()["a"] != 1.0 {
+		t.Fatalf("first event = %v, %v; want the state at seq 1", ev, err)
+	}
+
+	writeDelta(t, writer, "room:watch", "b", 2.0)
+	ev, err = stream.Recv()
+	if err != nil || ev.GetDelta().GetSeq() != 2 || ev.GetDelta().GetChanges().AsMap()["b"] != 2.0 {
+		t.Fatalf("second event = %v, %v; want delta 2", ev, err)
+	}
+
+	// Resuming replays the deltas after the given sequence number
+	resumeFrom := int64(1)
+	resumed, err := client.WatchDocument(ctx, &documentpb.WatchRequest{DocId: "room:watch", ResumeFrom: &resumeFrom})
+	if err != nil {
+		t.Fatalf("WatchDocument failed: %v", err)
+	}
+	if ev, err := resumed.Recv(); err != nil || ev.GetDelta().GetSeq() != 2 {
+		t.Errorf("resumed event = %v, %v; want delta 2 replayed", ev, err)
+	}
+
+	// Replacing the document sends the whole state again
+	admin := withToken(t, adminToken(t))
+	if _, err := client.Put(admin, &documentpb.PutRequest{DocId: "room:watch", State: mustStruct(t, map[string]interface{}{"c": 3.0})}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	ev, err = stream.Recv()
+	if state := ev.GetDocument().GetState().AsMap(); err != nil || state["c"] != 3.0 || state["a"] != nil {
+		t.Errorf("after Put event = %v, %v; want the replaced state", ev, err)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/Dancode-188/synckit/server/go/internal/version"
+	"github.com/Dancode-188/synckit/server/go/internal/websocket"
+)
+
+// readinessTimeout bounds all dependency checks of one readiness probe, so
+// a hung database cannot outlast the orchestrator's probe timeout
+const readinessTimeout = time.Second
+
+// Dependency states reported by the readiness endpoint
+const (
+	dependencyOK   = "ok"
+	dependencyDown = "down"
+)
+
+// handleLive serves GET /health/live: the process is up and serving HTTP.
+// It checks nothing else, so a dependency outage does not get the pod
+// restarted.
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "alive",
+		"timestamp": time.Now().Format(time.RFC3339),
+		"version":   version.Version,
+	})
+}
+
+// handleReady serves GET /health/ready (and /health): 200 when every
+// configured dependency answers, 503 with the failing ones marked down
+// otherwise, or while the server is shutting down. Storage being down does
+// not fail readiness while the hub is degraded, serving from memory and
+// buffering writes; the response then says degraded.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := s.checkDependencies(ctx)
+	degraded := s.hub.Degraded()
+	ready := !s.shuttingDown.Load()
+	for name, state := range checks {
+		if state != dependencyOK && !(name == "storage" && degraded) {
+			ready = false
+		}
+	}
+
+	status, code := "healthy", http.StatusOK
+	if s.shuttingDown.Load() {
+		status, code = "shutting_down", http.StatusServiceUnavailable
+	} else if !ready {
+		status, code = "unhealthy", http.StatusServiceUnavailable
+	} else if degraded {
+		status = "degraded"
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"status":        status,
+		"degraded":      degraded,
+		"checks":        checks,
+		"connections":   s.hub.ConnectionCount(),
+		"uptimeSeconds": int64(time.Since(s.startedAt).Seconds()),
+		"timestamp":     time.Now().Format(time.RFC3339),
+		"version":       version.Version,
+	})
+}
+
+// checkDependencies pings storage and Redis, whichever are configured
+func (s *Server) checkDependencies(ctx context.Context) map[string]string {
+	checks := map[string]string{}
+	if s.storage != nil {
+		checks["storage"] = dependencyState(ctx, "storage", s.checkStorage(ctx))
+	}
+	if s.redis != nil {
+		checks["redis"] = dependencyState(ctx, "redis", s.redis.Ping(ctx).Err())
+	}
+	return checks
+}
+
+func (s *Server) checkStorage(ctx context.Context) error {
+	return storageHealth(s.storage)(ctx)
+}
+
+// storageHealth probes a storage adapter for the hub's degraded mode
+func storageHealth(store storage.StorageAdapter) websocket.HealthFunc {
+	return func(ctx context.Context) error {
+		ok, err := store.HealthCheck(ctx)
+		if err == nil && !ok {
+			err = errors.New("health check failed")
+		}
+		return err
+	}
+}
+
+// degradedHealth is the hub's storage probe when degraded mode is on, nil
+// otherwise
+func degradedHealth(cfg *config.Config, store storage.StorageAdapter) websocket.HealthFunc {
+	if !cfg.DegradedMode || store == nil {
+		return nil
+	}
+	return storageHealth(store)
+}
+
+func dependencyState(ctx context.Context, name string, err error) string {
+	if err != nil {
+		logging.FromContext(ctx).Warn("Dependency check failed", "dependency", name, "err", err)
+		return dependencyDown
+	}
+	return dependencyOK
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/pkg/storagefake"
+)
+
+func healthGet(t *testing.T, ts *httptest.Server, path string) (int, map[string]interface{}) {
+	t.Helper()
+	resp, body := adminRequest(t, ts, http.MethodGet, path, "", nil)
+	return resp.StatusCode, body
+}
+
+func TestReady_ReflectsDependencyHealth(t *testing.T) {
+	s, ts := newTestServer(t)
+	store := storagefake.New()
+	if err := store.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.storage = store
+	mr := miniredis.RunT(t)
+	s.redis = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { s.redis.Close() })
+
+	for _, path := range []string{"/health/ready", "/health"} {
+		status, body := healthGet(t, ts, path)
+		checks, _ := body["checks"].(map[string]interface{})
+		if status != http.StatusOK || body["status"] != "healthy" || checks["storage"] != "ok" || checks["redis"] != "ok" {
+			t.Errorf("GET %s = %d %v, want 200 with both dependencies ok", path, status, body)
+		}
+		if _, ok := body["connections"].(float64); !ok {
+			t.Errorf("GET %s has no connection count: %v", path, body)
+		}
+		if _, ok := body["uptimeSeconds"].(float64); !ok {
+			t.Errorf("GET %s has no uptime: %v", path, body)
+		}
+	}
+
+	store.Fail("HealthCheck", errors.New("connection refused"))
+	status, body := healthGet(t, ts, "/health/ready")
+	checks, _ := body["checks"].(map[string]interface{})
+	if status != http.StatusServiceUnavailable || checks["storage"] != "down" || checks["redis"] != "ok" {
+		t.Errorf("with storage down = %d %v, want 503 with storage down", status, body)
+	}
+
+	store.Reset()
+	mr.Close()
+	status, body = healthGet(t, ts, "/health/ready")
+	checks, _ = body["checks"].(map[string]interface{})
+	if status != http.StatusServiceUnavailable || checks["storage"] != "ok" || checks["redis"] != "down" {
+		t.Errorf("with redis down = %d %v, want 503 with redis down", status, body)
+	}
+
+	// Liveness ignores dependencies
+	if status, body := healthGet(t, ts, "/health/live"); status != http.StatusOK || body["status"] != "alive" {
+		t.Errorf("GET /health/live = %d %v, want 200 alive", status, body)
+	}
+}
+
+func TestReady_NoDependenciesConfigured(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	status, body := healthGet(t, ts, "/health/ready")
+	checks, _ := body["checks"].(map[string]interface{})
+	if status != http.StatusOK || len(checks) != 0 {
+		t.Errorf("GET /health/ready = %d %v, want 200 with no checks", status, body)
+	}
+}
+
+func TestReady_UnavailableWhileShuttingDown(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.shuttingDown.Store(true)
+
+	if status, body := healthGet(t, ts, "/health/ready"); status != http.StatusServiceUnavailable || body["status"] != "shutting_down" {
+		t.Errorf("GET /health/ready = %d %v, want 503 shutting_down", status, body)
+	}
+	if status, _ := healthGet(t, ts, "/health/live"); status != http.StatusOK {
+		t.Errorf("GET /health/live = %d, want 200", status)
+	}
+}
+
+func TestReady_DegradedWhileStorageIsDown(t *testing.T) {
+	store := storagefake.New()
+	if err := store.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := NewWithOptions(&config.Config{
+		JWTSecret:             testSecret,
+		DegradedMode:          true,
+		StorageHealthInterval: 10 * time.Millisecond,
+	}, Options{Storage: store})
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		s.hub.Stop(ctx)
+		ts.Close()
+	})
+
+	store.Fail("HealthCheck", errors.New("connection refused"))
+	deadline := time.Now().Add(3 * time.Second)
+	for !s.hub.Degraded() {
+		if time.Now().After(deadline) {
+			t.Fatal("hub never entered degraded mode")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Still ready: the hub serves from memory while storage is down
+	status, body := healthGet(t, ts, "/health/ready")
+	checks, _ := body["checks"].(map[string]interface{})
+	if status != http.StatusOK || body["status"] != "degraded" || body["degraded"] != true || checks["storage"] != "down" {
+		t.Errorf("GET /health/ready = %d %v, want 200 degraded with storage down", status, body)
+	}
+	if _, body := healthGet(t, ts, "/stats"); body["degraded"] != true {
+		t.Errorf("GET /stats = %v, want degraded", body)
+	}
+
+	store.Reset()
+	for s.hub.Degraded() {
+		if time.Now().After(deadline) {
+			t.Fatal("hub never left degraded mode")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status, body := healthGet(t, ts, "/health/ready"); status != http.StatusOK || body["status"] != "healthy" || body["degraded"] != false {
+		t.Errorf("after recovery = %d %v, want 200 healthy", status, body)
+	}
+}
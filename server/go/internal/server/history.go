@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// Page sizes for the history endpoints
+const (
+	defaultDeltaPageSize    = 100
+	defaultSnapshotPageSize = 10
+	maxHistoryPageSize      = 1000
+)
+
+// handleDocumentHistory serves GET /api/documents/{id}/deltas and
+// GET /api/documents/{id}/snapshots
+func (s *Server) handleDocumentHistory(w http.ResponseWriter, r *http.Request) {
+	docID, resource, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/documents/"), "/")
+	if !found || docID == "" || (resource != "deltas" && resource != "snapshots") {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+		return
+	}
+	if !s.historyRequest(w, r) {
+		return
+	}
+	if !s.canReadDocument(r.Context(), tokenPayload(r), docID) {
+		writeError(w, http.StatusForbidden, "Permission denied", "PERMISSION_DENIED")
+		return
+	}
+
+	// A missing document is a 404; an existing one without history is an
+	// empty page
+	doc, err := s.storage.GetDocument(r.Context(), docID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to load document", "doc_id", docID, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to load document", "STORAGE_ERROR")
+		return
+	}
+	if doc == nil {
+		writeError(w, http.StatusNotFound, "Document not found", "DOCUMENT_NOT_FOUND")
+		return
+	}
+
+	if resource == "deltas" {
+		s.writeDeltaPage(w, r, docID)
+	} else {
+		s.writeSnapshotPage(w, r, docID)
+	}
+}
+
+func (s *Server) writeDeltaPage(w http.ResponseWriter, r *http.Request, docID string) {
+	query := r.URL.Query()
+	limit, ok := pageLimit(w, query.Get("limit"), defaultDeltaPageSize)
+	if !ok {
+		return
+	}
+	q := storage.DeltaQuery{Limit: limit + 1}
+	if since := query.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be RFC 3339 or Unix milliseconds", "INVALID_REQUEST")
+			return
+		}
+		q.Since = t
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		key, err := decodeCursor(cursor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid cursor", "INVALID_REQUEST")
+			return
+		}
+		q.After = key
+	}
+
+	deltas, err := s.storage.QueryDeltas(r.Context(), docID, q)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to load deltas", "doc_id", docID, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to load deltas", "STORAGE_ERROR")
+		return
+	}
+
+	// One extra row tells whether another page follows
+	hasMore := len(deltas) > limit
+	nextCursor := ""
+	if hasMore {
+		deltas = deltas[:limit]
+		last := deltas[limit-1]
+		nextCursor = encodeCursor(storage.PageKey{Timestamp: last.Timestamp, ID: last.ID})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"documentId": docID,
+		"deltas":     deltas,
+		"count":      len(deltas),
+		"hasMore":    hasMore,
+		"nextCursor": nextCursor,
+	})
+}
+
+func (s *Server) writeSnapshotPage(w http.ResponseWriter, r *http.Request, docID string) {
+	query := r.URL.Query()
+	limit, ok := pageLimit(w, query.Get("limit"), defaultSnapshotPageSize)
+	if !ok {
+		return
+	}
+	q := storage.SnapshotQuery{Limit: limit + 1}
+	if cursor := query.Get("cursor"); cursor != "" {
+		key, err := decodeCursor(cursor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid cursor", "INVALID_REQUEST")
+			return
+		}
+		q.Before = key
+	}
+
+	snapshots, err := s.storage.QuerySnapshots(r.Context(), docID, q)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to load snapshots", "doc_id", docID, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to load snapshots", "STORAGE_ERROR")
+		return
+	}
+
+	hasMore := len(snapshots) > limit
+	nextCursor := ""
+	if hasMore {
+		snapshots = snapshots[:limit]
+		last := snapshots[limit-1]
+		nextCursor = encodeCursor(storage.PageKey{Timestamp: last.CreatedAt, ID: last.ID})
+	}
+
+	// Listings leave out state; fetch a snapshot by ID for its contents
+	summaries := make([]map[string]interface{}, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		summaries = append(summaries, map[string]interface{}{
+			"id":         snapshot.ID,
+			"documentId": snapshot.DocumentID,
+			"version":    snapshot.Version,
+			"sizeBytes":  snapshot.SizeBytes,
+			"compressed": snapshot.Compressed,
+			"createdAt":  snapshot.CreatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"documentId": docID,
+		"snapshots":  summaries,
+		"count":      len(summaries),
+		"hasMore":    hasMore,
+		"nextCursor": nextCursor,
+	})
+}
+
+// handleSnapshot serves GET /api/snapshots/{snapshotId} with the snapshot's
+// state, decompressed if it was stored compressed
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshotID := strings.TrimPrefix(r.URL.Path, "/api/snapshots/")
+	if snapshotID == "" || strings.Contains(snapshotID, "/") {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+		return
+	}
+	if !s.historyRequest(w, r) {
+		return
+	}
+
+	snapshot, err := s.storage.GetSnapshot(r.Context(), snapshotID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to load snapshot", "snapshot_id", snapshotID, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to load snapshot", "STORAGE_ERROR")
+		return
+	}
+	if snapshot == nil {
+		writeError(w, http.StatusNotFound, "Snapshot not found", "SNAPSHOT_NOT_FOUND")
+		return
+	}
+	if !s.canReadDocument(r.Context(), tokenPayload(r), snapshot.DocumentID) {
+		writeError(w, http.StatusForbidden, "Permission denied", "PERMISSION_DENIED")
+		return
+	}
+
+	state, err := storage.SnapshotState(snapshot)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to decompress snapshot", "snapshot_id", snapshotID, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to read snapshot", "STORAGE_ERROR")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":         snapshot.ID,
+		"documentId": snapshot.DocumentID,
+		"state":      state,
+		"version":    snapshot.Version,
+		"sizeBytes":  snapshot.SizeBytes,
+		"compressed": snapshot.Compressed,
+		"createdAt":  snapshot.CreatedAt,
+	})
+}
+
+// historyRequest checks the method and that storage is configured, writing
+// an error response otherwise
+func (s *Server) historyRequest(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return false
+	}
+	if s.storage == nil {
+		writeError(w, http.StatusServiceUnavailable, "Document storage not configured", "STORAGE_UNAVAILABLE")
+		return false
+	}
+	return true
+}
+
+// canReadDocument applies the websocket subscribe checks: the public
+// document policy, then the token's read permission or a grant
+func (s *Server) canReadDocument(ctx context.Context, payload *auth.TokenPayload, docID string) bool {
+	return s.publicDocs.Allows(docID) && s.acl.CanRead(ctx, payload, docID)
+}
+
+// pageLimit parses the limit query parameter, capped at maxHistoryPageSize
+func pageLimit(w http.ResponseWriter, value string, fallback int) (int, bool) {
+	if value == "" {
+		return fallback, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer", "INVALID_REQUEST")
+		return 0, false
+	}
+	if limit > maxHistoryPageSize {
+		limit = maxHistoryPageSize
+	}
+	return limit, true
+}
+
+// parseSince accepts an RFC 3339 time or Unix milliseconds
+func parseSince(value string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// encodeCursor turns a page key into an opaque cursor
+func encodeCursor(key storage.PageKey) string {
+	data, _ := json.Marshal(map[string]string{
+		"t":  key.Timestamp.Format(time.RFC3339Nano),
+		"id": key.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor reverses encodeCursor
+func decodeCursor(cursor string) (*storage.PageKey, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw["t"])
+	if err != nil {
+		return nil, err
+	}
+	return &storage.PageKey{Timestamp: t, ID: raw["id"]}, nil
+}
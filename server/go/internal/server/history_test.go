@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// historyStorage serves documents, deltas and snapshots from memory. Methods
+// the history endpoints do not use fall through to the nil embedded adapter.
+type historyStorage struct {
+	storage.StorageAdapter
+	documents map[string]bool
+	deltas    []storage.DeltaEntry
+	snapshots []storage.SnapshotEntry
+}
+
+func (f *historyStorage) GetDocument(ctx context.Context, id string) (*storage.DocumentState, error) {
+	if !f.documents[id] {
+		return nil, nil
+	}
+	return &storage.DocumentState{ID: id}, nil
+}
+
+func (f *historyStorage) QueryDeltas(ctx context.Context, documentID string, q storage.DeltaQuery) ([]*storage.DeltaEntry, error) {
+	result := []*storage.DeltaEntry{}
+	for i := range f.deltas {
+		d := &f.deltas[i]
+		if d.DocumentID != documentID || d.Timestamp.Before(q.Since) {
+			continue
+		}
+		if q.After != nil && !pageKeyLess(*q.After, storage.PageKey{Timestamp: d.Timestamp, ID: d.ID}) {
+			continue
+		}
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return pageKeyLess(storage.PageKey{Timestamp: result[i].Timestamp, ID: result[i].ID},
+			storage.PageKey{Timestamp: result[j].Timestamp, ID: result[j].ID})
+	})
+	if len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result, nil
+}
+
+func (f *historyStorage) QuerySnapshots(ctx context.Context, documentID string, q storage.SnapshotQuery) ([]*storage.SnapshotEntry, error) {
+	result := []*storage.SnapshotEntry{}
+	for i := range f.snapshots {
+		s := &f.snapshots[i]
+		if s.DocumentID != documentID {
+			continue
+		}
+		if q.Before != nil && !pageKeyLess(storage.PageKey{Timestamp: s.CreatedAt, ID: s.ID}, *q.Before) {
+			continue
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return pageKeyLess(storage.PageKey{Timestamp: result[j].CreatedAt, ID: result[j].ID},
+			storage.PageKey{Timestamp: result[i].CreatedAt, ID: result[i].ID})
+	})
+	if len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result, nil
+}
+
+func (f *historyStorage) GetSnapshot(ctx context.Context, snapshotID string) (*storage.SnapshotEntry, error) {
+	for _, s := range f.snapshots {
+		if s.ID == snapshotID {
+			return &s, nil
+		}
+	}
+	return nil, nil
+}
+
+func pageKeyLess(a, b storage.PageKey) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	return a.ID < b.ID
+}
+
+// newHistoryServer returns a test server backed by historyFixture
+func newHistoryServer(t *testing.T) (*historyStorage, *httptest.Server) {
+	t.Helper()
+	s, ts := newTestServer(t)
+	store := historyFixture()
+	s.storage = store
+	return store, ts
+}
+
+// historyFixture holds room:history with five deltas (two sharing a
+// timestamp) and three snapshots, plus room:empty with no history
+func historyFixture() *historyStorage {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &historyStorage{documents: map[string]bool{"room:history": true, "room:empty": true}}
+	for i, offset := range []int{0, 1, 1, 2, 3} {
+		store.deltas = append(store.deltas, storage.DeltaEntry{
+			ID:         string(rune('a' + i)),
+			DocumentID: "room:history",
+			FieldPath:  "field",
+			Timestamp:  base.Add(time.Duration(offset) * time.Second),
+		})
+	}
+	for i := 0; i < 3; i++ {
+		store.snapshots = append(store.snapshots, storage.SnapshotEntry{
+			ID:         "snap-" + string(rune('1'+i)),
+			DocumentID: "room:history",
+			State:      map[string]interface{}{"n": float64(i)},
+			CreatedAt:  base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	return store
+}
+
+func historyGet(t *testing.T, ts *httptest.Server, path, token string) (int, map[string]interface{}) {
+	t.Helper()
+	resp, body := adminRequest(t, ts, http.MethodGet, path, token, nil)
+	return resp.StatusCode, body
+}
+
+func TestHistory_DeltaPagination(t *testing.T) {
+	_, ts := newHistoryServer(t)
+	token := tokenFor(t, "reader", auth.DocumentPermissions{CanRead: []string{"room:history"}})
+
+	var ids []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination did not terminate")
+		}
+		path := "/api/documents/room:history/deltas?limit=2"
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+		status, body := historyGet(t, ts, path, token)
+		if status != http.StatusOK {
+			t.Fatalf("GET %s = %d %v", path, status, body)
+		}
+		for _, d := range body["deltas"].([]interface{}) {
+			ids = append(ids, d.(map[string]interface{})["id"].(string))
+		}
+		if body["hasMore"] != true {
+			if body["nextCursor"] != "" {
+				t.Errorf("last page nextCursor = %v, want empty", body["nextCursor"])
+			}
+			break
+		}
+		cursor = body["nextCursor"].(string)
+	}
+
+	// Deltas sharing a timestamp straddle a page boundary without loss
+	want := []string{"a", "b", "c", "d", "e"}
+	if len(ids) != len(want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("ids = %v, want %v", ids, want)
+		}
+	}
+}
+
+func TestHistory_DeltasSince(t *testing.T) {
+	_, ts := newHistoryServer(t)
+	token := tokenFor(t, "reader", auth.DocumentPermissions{CanRead: []string{"room:history"}})
+
+	since := time.Date(2026, 1, 1, 0, 0, 2, 0, time.UTC)
+	for _, value := range []string{since.Format(time.RFC3339), "1767225602000"} {
+		status, body := historyGet(t, ts, "/api/documents/room:history/deltas?since="+url.QueryEscape(value), token)
+		if status != http.StatusOK || body["count"] != float64(2) {
+			t.Errorf("since=%s: %d count=%v, want 200 with 2 deltas", value, status, body["count"])
+		}
+	}
+
+	status, body := historyGet(t, ts, "/api/documents/room:history/deltas?since=yesterday", token)
+	if status != http.StatusBadRequest || body["code"] != "INVALID_REQUEST" {
+		t.Errorf("bad since = %d %v, want 400 INVALID_REQUEST", status, body)
+	}
+	status, body = historyGet(t, ts, "/api/documents/room:history/deltas?cursor=garbage", token)
+	if status != http.StatusBadRequest || body["code"] != "INVALID_REQUEST" {
+		t.Errorf("bad cursor = %d %v, want 400 INVALID_REQUEST", status, body)
+	}
+}
+
+func TestHistory_MissingDocumentVersusEmptyHistory(t *testing.T) {
+	_, ts := newHistoryServer(t)
+	token := adminToken(t)
+
+	status, body := historyGet(t, ts, "/api/documents/room:missing/deltas", token)
+	if status != http.StatusNotFound || body["code"] != "DOCUMENT_NOT_FOUND" {
+		t.Errorf("missing document = %d %v, want 404 DOCUMENT_NOT_FOUND", status, body)
+	}
+
+	status, body = historyGet(t, ts, "/api/documents/room:empty/deltas", token)
+	if status != http.StatusOK || body["count"] != float64(0) || body["hasMore"] != false {
+		t.Errorf("empty document = %d %v, want 200 with no deltas", status, body)
+	}
+	if deltas, ok := body["deltas"].([]interface{}); !ok || len(deltas) != 0 {
+		t.Errorf("deltas = %v, want empty list", body["deltas"])
+	}
+}
+
+func TestHistory_RequiresReadPermission(t *testing.T) {
+	_, ts := newHistoryServer(t)
+	other := tokenFor(t, "other", auth.DocumentPermissions{CanRead: []string{"room:elsewhere"}})
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+		code   string
+	}{
+		{"no token", "/api/documents/room:history/deltas", "", http.StatusUnauthorized, "NOT_AUTHENTICATED"},
+		{"bad token", "/api/documents/room:history/deltas", "not-a-jwt", http.StatusUnauthorized, "NOT_AUTHENTICATED"},
+		{"deltas", "/api/documents/room:history/deltas", other, http.StatusForbidden, "PERMISSION_DENIED"},
+		{"snapshots", "/api/documents/room:history/snapshots", other, http.StatusForbidden, "PERMISSION_DENIED"},
+		{"snapshot", "/api/snapshots/snap-1", other, http.StatusForbidden, "PERMISSION_DENIED"},
+		{"denied before existence", "/api/documents/room:missing/deltas", other, http.StatusForbidden, "PERMISSION_DENIED"},
+		{"non-public document", "/api/documents/private-doc/deltas", adminToken(t), http.StatusForbidden, "PERMISSION_DENIED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := historyGet(t, ts, tt.path, tt.token)
+			if status != tt.status || body["code"] != tt.code {
+				t.Errorf("GET %s = %d %v, want %d %s", tt.path, status, body, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestHistory_SnapshotsNewestFirst(t *testing.T) {
+	_, ts := newHistoryServer(t)
+	token := adminToken(t)
+
+	status, body := historyGet(t, ts, "/api/documents/room:history/snapshots?limit=2", token)
+	if status != http.StatusOK || body["hasMore"] != true {
+		t.Fatalf("first page = %d %v", status, body)
+	}
+	first := body["snapshots"].([]interface{})
+	if len(first) != 2 || first[0].(map[string]interface{})["id"] != "snap-3" {
+		t.Fatalf("first page = %v, want snap-3 then snap-2", first)
+	}
+	if _, ok := first[0].(map[string]interface{})["state"]; ok {
+		t.Error("snapshot listing should not include state")
+	}
+
+	status, body = historyGet(t, ts,
+		"/api/documents/room:history/snapshots?limit=2&cursor="+url.QueryEscape(body["nextCursor"].(string)), token)
+	rest := body["snapshots"].([]interface{})
+	if status != http.StatusOK || len(rest) != 1 || rest[0].(map[string]interface{})["id"] != "snap-1" || body["hasMore"] != false {
+		t.Errorf("second page = %d %v, want only snap-1", status, body)
+	}
+}
+
+func TestHistory_GetSnapshotDecompressesState(t *testing.T) {
+	store, ts := newHistoryServer(t)
+	token := tokenFor(t, "reader", auth.DocumentPermissions{CanRead: []string{"room:history"}})
+
+	state := map[string]interface{}{"title": "Notes"}
+	packed, err := storage.CompressSnapshotState(state)
+	if err != nil {
+		t.Fatalf("CompressSnapshotState failed: %v", err)
+	}
+	store.snapshots = append(store.snapshots, storage.SnapshotEntry{
+		ID: "snap-gz", DocumentID: "room:history", State: packed, Compressed: true,
+	})
+
+	status, body := historyGet(t, ts, "/api/snapshots/snap-gz", token)
+	if status != http.StatusOK {
+		t.Fatalf("GET snapshot = %d %v", status, body)
+	}
+	if got := body["state"].(map[string]interface{}); got["title"] != "Notes" {
+		t.Errorf("state = %v, want %v", got, state)
+	}
+
+	status, body = historyGet(t, ts, "/api/snapshots/snap-missing", token)
+	if status != http.StatusNotFound || body["code"] != "SNAPSHOT_NOT_FOUND" {
+		t.Errorf("missing snapshot = %d %v, want 404 SNAPSHOT_NOT_FOUND", status, body)
+	}
+}
+
+func TestHistory_WithoutStorage(t *testing.T) {
+	_, ts := newTestServer(t)
+	resp, body := adminRequest(t, ts, http.MethodGet, "/api/documents/room:history/deltas", adminToken(t), nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || body["code"] != "STORAGE_UNAVAILABLE" {
+		t.Errorf("GET without storage = %d %v, want 503 STORAGE_UNAVAILABLE", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Start listens on addr, serving TLS when a certificate is configured, and
+// on HealthPort and GRPCPort when set. It blocks until the main listener
+// stops.
+func (s *Server) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.config.HealthPort > 0 {
+		healthAddr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.HealthPort))
+		healthLn, err := net.Listen("tcp", healthAddr)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		s.healthServer = s.newHTTPServer(s.healthRoutes())
+		go func() {
+			if err := s.healthServer.Serve(healthLn); err != nil && err != http.ErrServerClosed {
+				slog.Error("Health listener stopped", "addr", healthAddr, "err", err)
+			}
+		}()
+		slog.Info("Health checks listening", "addr", healthAddr)
+	}
+	if s.config.GRPCPort > 0 {
+		grpcAddr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.GRPCPort))
+		grpcServer, err := s.newGRPCServer()
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		grpcLn, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		s.grpcServer = grpcServer
+		go func() {
+			if err := s.grpcServer.Serve(grpcLn); err != nil {
+				slog.Error("gRPC listener stopped", "addr", grpcAddr, "err", err)
+			}
+		}()
+		slog.Info("gRPC listening", "addr", grpcAddr)
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves the full API on ln until it is closed
+func (s *Server) Serve(ln net.Listener) error {
+	s.server = s.newHTTPServer(s.routes())
+	if !s.config.TLSEnabled() {
+		return s.server.Serve(ln)
+	}
+
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	s.server.TLSConfig = tlsConfig
+	return s.server.ServeTLS(ln, "", "")
+}
+
+func (s *Server) newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:      handler,
+		ReadTimeout:  s.config.ReadTimeout,
+		WriteTimeout: s.config.WriteTimeout,
+		IdleTimeout:  s.config.IdleTimeout,
+	}
+}
+
+// healthRoutes builds the handler for the plaintext health listener, which
+// serves nothing but the health endpoints
+func (s *Server) healthRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleReady)
+	mux.HandleFunc("/health/live", s.handleLive)
+	mux.HandleFunc("/health/ready", s.handleReady)
+	return s.logRequests(mux)
+}
+
+// tlsConfig loads the configured certificate and, for mTLS, the client CA.
+// Only TLS 1.2+ with forward-secret AEAD suites is offered; TLS 1.3 suites
+// are not configurable and are all modern.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(s.config.TLSCertFile, s.config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		// Websocket upgrades need HTTP/1.1
+		NextProtos: []string{"http/1.1"},
+	}
+
+	if s.config.TLSClientCA != "" {
+		pem, err := os.ReadFile(s.config.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("TLS client CA contains no certificates")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
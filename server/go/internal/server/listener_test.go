@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// testCert is a certificate and key, written to PEM files in a temp dir
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	tls      tls.Certificate
+	certFile string
+	keyFile  string
+}
+
+// issueCert creates a certificate signed by parent, or self-signed when
+// parent is nil
+func issueCert(t *testing.T, name string, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	c := &testCert{key: key}
+	c.cert, _ = x509.ParseCertificate(der)
+	c.tls, _ = tls.X509KeyPair(certPEM, keyPEM)
+	dir := t.TempDir()
+	c.certFile = filepath.Join(dir, name+".crt")
+	c.keyFile = filepath.Join(dir, name+".key")
+	os.WriteFile(c.certFile, certPEM, 0o600)
+	os.WriteFile(c.keyFile, keyPEM, 0o600)
+	return c
+}
+
+func issueServerCert(t *testing.T) *testCert {
+	return issueCert(t, "server", &x509.Certificate{
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+}
+
+// serveTLS starts the server's own listener on a free port and returns its
+// address
+func serveTLS(t *testing.T, cfg *config.Config) string {
+	t.Helper()
+	s := New(cfg)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		s.hub.Stop(ctx)
+		ln.Close()
+	})
+	return ln.Addr().String()
+}
+
+func dialTLS(addr string, tlsConfig *tls.Config) (*gorilla.Conn, error) {
+	dialer := gorilla.Dialer{TLSClientConfig: tlsConfig, HandshakeTimeout: 2 * time.Second}
+	ws, _, err := dialer.Dial("wss://"+addr+"/ws", nil)
+	return ws, err
+}
+
+func TestServe_TLSWebsocketHandshake(t *testing.T) {
+	serverCert := issueServerCert(t)
+	addr := serveTLS(t, &config.Config{
+		JWTSecret:   testSecret,
+		TLSCertFile: serverCert.certFile,
+		TLSKeyFile:  serverCert.keyFile,
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.cert)
+
+	ws, err := dialTLS(addr, &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("wss dial failed: %v", err)
+	}
+	defer ws.Close()
+	data, _ := protocol.EncodeMessage(protocol.TypeAuth, map[string]interface{}{
+		"type":  protocol.TypeAuth,
+		"id":    "auth-alice",
+		"token": tokenFor(t, "alice", auth.CreateUserPermissions([]string{"*"}, nil)),
+	}, time.Now().UnixMilli())
+	ws.WriteMessage(gorilla.BinaryMessage, data)
+	readMessage(t, ws, protocol.TypeAuthSuccess)
+
+	// Legacy protocol versions are refused
+	_, err = dialTLS(addr, &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS11})
+	if err == nil {
+		t.Error("TLS 1.1 handshake succeeded")
+	}
+}
+
+func TestServe_MutualTLS(t *testing.T) {
+	ca := issueCert(t, "ca", &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	client := issueCert(t, "client", &x509.Certificate{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	serverCert := issueServerCert(t)
+	addr := serveTLS(t, &config.Config{
+		JWTSecret:   testSecret,
+		TLSCertFile: serverCert.certFile,
+		TLSKeyFile:  serverCert.keyFile,
+		TLSClientCA: ca.certFile,
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.cert)
+
+	if ws, err := dialTLS(addr, &tls.Config{RootCAs: roots}); err == nil {
+		ws.Close()
+		t.Error("handshake without a client certificate succeeded")
+	}
+	ws, err := dialTLS(addr, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client.tls}})
+	if err != nil {
+		t.Fatalf("handshake with a client certificate failed: %v", err)
+	}
+	ws.Close()
+}
+
+func TestServe_RejectsUnreadableCertificate(t *testing.T) {
+	s := New(&config.Config{JWTSecret: testSecret, TLSCertFile: "/nonexistent.crt", TLSKeyFile: "/nonexistent.key"})
+	defer s.hub.Stop(context.Background())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(ln); err == nil {
+		t.Error("Serve succeeded without a readable certificate")
+	}
+}
+
+func TestHealthRoutes_ServeOnlyHealth(t *testing.T) {
+	s, _ := newTestServer(t)
+	ts := httptest.NewServer(s.healthRoutes())
+	defer ts.Close()
+
+	for path, want := range map[string]int{
+		"/health/live":       http.StatusOK,
+		"/health/ready":      http.StatusOK,
+		"/health":            http.StatusOK,
+		"/ws":                http.StatusNotFound,
+		"/admin/connections": http.StatusNotFound,
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
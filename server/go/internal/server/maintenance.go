@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/websocket"
+)
+
+// handleAdminMaintenance serves GET /admin/maintenance and POST
+// /admin/maintenance, which enters or leaves maintenance mode with
+// {"enabled", "message", "retryAfterSeconds"}
+func (s *Server) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, maintenanceJSON(s.hub.Maintenance()))
+
+	case http.MethodPost:
+		var body struct {
+			Enabled           *bool  `json:"enabled"`
+			Message           string `json:"message"`
+			RetryAfterSeconds int    `json:"retryAfterSeconds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil || body.RetryAfterSeconds < 0 {
+			writeBodyError(w, err, "enabled is required and retryAfterSeconds must not be negative")
+			return
+		}
+		m := s.hub.SetMaintenance(websocket.Maintenance{
+			Enabled:    *body.Enabled,
+			Message:    body.Message,
+			RetryAfter: time.Duration(body.RetryAfterSeconds) * time.Second,
+		})
+		s.audit.Log(audit.Event{
+			Type:    audit.EventAdminMaintenance,
+			Actor:   adminID(r),
+			Details: map[string]interface{}{"enabled": m.Enabled, "message": m.Message},
+		})
+		writeJSON(w, http.StatusOK, maintenanceJSON(m))
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+	}
+}
+
+func maintenanceJSON(m websocket.Maintenance) map[string]interface{} {
+	body := map[string]interface{}{"enabled": m.Enabled}
+	if m.Enabled {
+		body["since"] = m.Since
+		body["message"] = m.Message
+		body["retryAfterSeconds"] = retryAfterSeconds(m.RetryAfter)
+	}
+	return body
+}
+
+// refuseDuringMaintenance answers a write request with 503 MAINTENANCE_MODE
+// and reports true if maintenance mode is on. The retry hint is sent as
+// Retry-After.
+func (s *Server) refuseDuringMaintenance(w http.ResponseWriter) bool {
+	m := s.hub.Maintenance()
+	if !m.Enabled {
+		return false
+	}
+	body := map[string]interface{}{
+		"error": websocket.DefaultMaintenanceError,
+		"code":  "MAINTENANCE_MODE",
+	}
+	if m.Message != "" {
+		body["message"] = m.Message
+	}
+	if m.RetryAfter > 0 {
+		seconds := retryAfterSeconds(m.RetryAfter)
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		body["retryAfterSeconds"] = seconds
+	}
+	writeJSON(w, http.StatusServiceUnavailable, body)
+	return true
+}
+
+// retryAfterSeconds rounds d up to whole seconds
+func retryAfterSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
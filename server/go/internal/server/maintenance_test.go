@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/grpc/documentpb"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	gorilla "github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sendMessage writes a client message to ws
+func sendMessage(t *testing.T, ws *gorilla.Conn, msgType string, payload map[string]interface{}) {
+	t.Helper()
+	payload["type"] = msgType
+	data, _ := protocol.EncodeMessage(msgType, payload, time.Now().UnixMilli())
+	if err := ws.WriteMessage(gorilla.BinaryMessage, data); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+}
+
+func TestMaintenance_RefusesWritesAndServesReads(t *testing.T) {
+	s, ts := newTestServer(t)
+	writer := dialWithToken(t, ts, tokenFor(t, "writer", auth.CreateUserPermissions([]string{"*"}, []string{"*"})), "")
+	writeDelta(t, writer, "room:maint", "a", 1.0)
+
+	resp, body := adminRequest(t, ts, http.MethodPost, "/admin/maintenance", adminToken(t), map[string]interface{}{
+		"enabled": true, "message": "Migrating storage", "retryAfterSeconds": 30,
+	})
+	if resp.StatusCode != http.StatusOK || body["enabled"] != true || body["retryAfterSeconds"] != 30.0 {
+		t.Fatalf("enter maintenance = %d %v", resp.StatusCode, body)
+	}
+	notice := readMessage(t, writer, protocol.TypeMaintenance)
+	if notice.Payload["enabled"] != true || notice.Payload["message"] != "Migrating storage" || notice.Payload["retryAfter"] != 30000.0 {
+		t.Errorf("notice = %v, want enabled with the message and retry hint", notice.Payload)
+	}
+
+	// Reads still work
+	sendMessage(t, writer, protocol.TypeSubscribe, map[string]interface{}{"id": "sub", "docId": "room:maint"})
+	if sync := readMessage(t, writer, protocol.TypeSyncResponse); sync.Payload["docId"] != "room:maint" {
+		t.Errorf("sync_response = %v", sync.Payload)
+	}
+
+	// Writes do not, over any transport
+	for _, write := range []struct {
+		msgType string
+		payload map[string]interface{}
+	}{
+		{protocol.TypeDelta, map[string]interface{}{"id": "d", "docId": "room:maint", "changes": map[string]interface{}{"b": 2.0}}},
+		{protocol.TypeDeltaBatch, map[string]interface{}{"id": "b", "docId": "room:maint", "deltas": []interface{}{map[string]interface{}{"changes": map[string]interface{}{"b": 2.0}}}}},
+	} {
+		sendMessage(t, writer, write.msgType, write.payload)
+		refused := readMessage(t, writer, protocol.TypeError)
+		if refused.Payload["code"] != "MAINTENANCE_MODE" || refused.Payload["message"] != "Migrating storage" || refused.Payload["retryAfter"] != 30000.0 {
+			t.Errorf("%s error = %v, want MAINTENANCE_MODE with the message and retry hint", write.msgType, refused.Payload)
+		}
+	}
+	resp, body = adminRequest(t, ts, http.MethodPost, "/admin/documents/room:maint/restore", adminToken(t), map[string]interface{}{"snapshotId": "s"})
+	if resp.StatusCode != http.StatusServiceUnavailable || body["code"] != "MAINTENANCE_MODE" || resp.Header.Get("Retry-After") != "30" {
+		t.Errorf("REST write = %d %v (Retry-After %q), want 503 MAINTENANCE_MODE", resp.StatusCode, body, resp.Header.Get("Retry-After"))
+	}
+	client := dialGRPC(t, s)
+	_, err := client.Patch(withToken(t, adminToken(t)), &documentpb.PatchRequest{DocId: "room:maint", Changes: mustStruct(t, map[string]interface{}{"b": 2.0})})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("gRPC Patch error = %v, want Unavailable", err)
+	}
+	if doc, err := client.Get(withToken(t, adminToken(t)), &documentpb.GetRequest{DocId: "room:maint"}); err != nil || doc.Seq != 1 {
+		t.Errorf("gRPC Get = %v, %v; want the document unchanged at seq 1", doc, err)
+	}
+
+	resp, body = adminRequest(t, ts, http.MethodPost, "/admin/maintenance", adminToken(t), map[string]interface{}{"enabled": false})
+	if resp.StatusCode != http.StatusOK || body["enabled"] != false {
+		t.Fatalf("leave maintenance = %d %v", resp.StatusCode, body)
+	}
+	if notice := readMessage(t, writer, protocol.TypeMaintenance); notice.Payload["enabled"] != false {
+		t.Errorf("notice = %v, want disabled", notice.Payload)
+	}
+	writeDelta(t, writer, "room:maint", "b", 2.0)
+}
+
+func TestMaintenance_EnabledAtStartup(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{MaintenanceMode: true})
+	writer := dialWithToken(t, ts, tokenFor(t, "writer", auth.CreateUserPermissions([]string{"*"}, []string{"*"})), "")
+
+	sendMessage(t, writer, protocol.TypeDelta, map[string]interface{}{"id": "d", "docId": "room:maint", "changes": map[string]interface{}{"a": 1.0}})
+	refused := readMessage(t, writer, protocol.TypeError)
+	if refused.Payload["code"] != "MAINTENANCE_MODE" || refused.Payload["message"] != nil {
+		t.Errorf("error = %v, want MAINTENANCE_MODE without an operator message", refused.Payload)
+	}
+
+	resp, body := adminRequest(t, ts, http.MethodGet, "/admin/maintenance", adminToken(t), nil)
+	if resp.StatusCode != http.StatusOK || body["enabled"] != true || body["since"] == nil {
+		t.Errorf("GET /admin/maintenance = %d %v, want enabled", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+)
+
+// Default limit on request bodies, when MaxRequestBodyBytes is unset
+const defaultMaxRequestBodyBytes = 2 << 20
+
+// middleware wraps the API mux in the shared stack: panics become JSON 500s
+// (inside logRequests, so they are still logged with their request ID),
+// CORS, request body limits, then gzip for JSON responses
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return s.logRequests(s.recoverPanics(s.corsMiddleware(s.limitRequestBodies(compressJSON(next)))))
+}
+
+// recoverPanics turns a panicking handler into a logged error and, if the
+// handler had not started its response, a JSON 500. http.ErrAbortHandler
+// is passed on, since it is how handlers deliberately abort a response.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			logging.FromContext(r.Context()).Error("Handler panicked",
+				"err", fmt.Sprint(v),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"stack", string(debug.Stack()),
+			)
+			if rec.status == 0 {
+				writeError(rec, http.StatusInternalServerError, "Internal server error", "INTERNAL_ERROR")
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// limitRequestBodies caps request bodies at MaxRequestBodyBytes. Requests
+// declaring a larger body are refused up front; handlers reading past the
+// limit get an *http.MaxBytesError, which writeBodyError reports as 413.
+func (s *Server) limitRequestBodies(next http.Handler) http.Handler {
+	limit := s.config.MaxRequestBodyBytes
+	if limit == 0 {
+		limit = defaultMaxRequestBodyBytes
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			if r.ContentLength > limit {
+				writeError(w, http.StatusRequestEntityTooLarge, requestTooLargeMessage(limit), "REQUEST_TOO_LARGE")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requestTooLargeMessage(limit int64) string {
+	return fmt.Sprintf("Request body exceeds %d bytes", limit)
+}
+
+// writeBodyError reports a request body that could not be read or decoded:
+// 413 when it was over the size limit, otherwise 400 with msg
+func writeBodyError(w http.ResponseWriter, err error, msg string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, requestTooLargeMessage(tooLarge.Limit), "REQUEST_TOO_LARGE")
+		return
+	}
+	writeError(w, http.StatusBadRequest, msg, "INVALID_REQUEST")
+}
+
+// upgradeError answers a failed websocket handshake with the JSON error
+// envelope instead of gorilla's plain text
+func upgradeError(w http.ResponseWriter, _ *http.Request, status int, reason error) {
+	code := "UPGRADE_FAILED"
+	if status == http.StatusForbidden {
+		code = "ORIGIN_NOT_ALLOWED"
+	}
+	writeError(w, status, reason.Error(), code)
+}
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// compressJSON gzips JSON responses for clients that accept it. Anything
+// else, such as event streams, metrics and websocket upgrades, passes
+// through untouched.
+func compressJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether Accept-Encoding lists gzip without q=0
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			q := strings.ReplaceAll(params, " ", "")
+			return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter decides on the first write whether to compress: only
+// JSON responses not already encoded are
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer // Nil unless compressing
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.Header()
+	contentType := header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/json") && header.Get("Content-Encoding") == "" &&
+		status != http.StatusNoContent && status != http.StatusNotModified {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// close flushes the compressed stream and returns the writer to the pool
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+)
+
+func TestMiddleware_RecoversPanics(t *testing.T) {
+	s := New(&config.Config{JWTSecret: testSecret})
+	logs := captureLogs(s)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) { panic("deliberate") })
+	ts := httptest.NewServer(s.middleware(mux))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/boom")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusInternalServerError || body["code"] != "INTERNAL_ERROR" {
+		t.Errorf("panic = %d %v, want 500 INTERNAL_ERROR", resp.StatusCode, body)
+	}
+
+	var panicked, logged map[string]interface{}
+	for _, record := range logs.records(t) {
+		switch record["msg"] {
+		case "Handler panicked":
+			panicked = record
+		case "HTTP request":
+			logged = record
+		}
+	}
+	stack, _ := panicked["stack"].(string)
+	if panicked["err"] != "deliberate" || !strings.Contains(stack, "middleware_test.go") {
+		t.Errorf("panic log = %v, want the panic value and a stack through the handler", panicked)
+	}
+	if logged["status"] != 500.0 || panicked["request_id"] != logged["request_id"] {
+		t.Errorf("request log = %v, want status 500 under the panic's request ID", logged)
+	}
+}
+
+func TestMiddleware_LimitsRequestBodies(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.config.AdminAPIKey = "an-admin-api-key-of-at-least-32-characters"
+	oversized := `{"ip": "10.0.0.1", "durationSeconds": 60, "reason": "` + strings.Repeat("x", defaultMaxRequestBodyBytes) + `"}`
+
+	// Declared too large: refused before the handler runs
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/admin/bans", strings.NewReader(oversized))
+	req.Header.Set("Authorization", "Bearer "+s.config.AdminAPIKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("declared oversized body status = %d, want 413", resp.StatusCode)
+	}
+
+	// Streamed without a length: cut off while the handler decodes it
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/admin/bans", io.MultiReader(strings.NewReader(oversized)))
+	req.Header.Set("Authorization", "Bearer "+s.config.AdminAPIKey)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || body["code"] != "REQUEST_TOO_LARGE" {
+		t.Errorf("streamed oversized body = %d %v, want 413 REQUEST_TOO_LARGE", resp.StatusCode, body)
+	}
+	if len(s.securityManager.Bans.List()) != 0 {
+		t.Error("an oversized request took effect")
+	}
+}
+
+func TestMiddleware_GzipNegotiation(t *testing.T) {
+	_, ts := newTestServer(t)
+	get := func(path, acceptEncoding string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		// Setting the header stops the client decompressing transparently
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := get("/stats", "br, gzip")
+	vary := resp.Header.Values("Vary")
+	if resp.Header.Get("Content-Encoding") != "gzip" || !strings.Contains(strings.Join(vary, ","), "Accept-Encoding") {
+		t.Fatalf("Content-Encoding = %q, Vary = %q; want gzip varying on Accept-Encoding", resp.Header.Get("Content-Encoding"), vary)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader failed: %v", err)
+	}
+	var stats map[string]interface{}
+	if err := json.NewDecoder(gz).Decode(&stats); err != nil || stats["hub"] == nil {
+		t.Errorf("decompressed body = %v, %v; want the stats", stats, err)
+	}
+
+	for _, acceptEncoding := range []string{"identity", "gzip;q=0"} {
+		if resp := get("/stats", acceptEncoding); resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("Accept-Encoding %q got Content-Encoding %q", acceptEncoding, resp.Header.Get("Content-Encoding"))
+		}
+	}
+	if resp := get("/health/live", "gzip"); resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Error("JSON health response was not compressed")
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	gorilla "github.com/gorilla/websocket"
+)
+
+func TestAdmin_RevokeTokenDisconnectsAndRefuses(t *testing.T) {
+	s, ts := newTestServer(t)
+	rec := &recordingAudit{}
+	s.audit = rec
+	perms := auth.CreateUserPermissions([]string{"*"}, []string{"*"})
+	token := tokenFor(t, "mallory", perms)
+	claims, _ := auth.DecodeTokenWithoutVerification(token)
+	mallory := dialWithToken(t, ts, token, "")
+	alice := dialClient(t, ts, "alice")
+
+	resp, body := adminRequest(t, ts, http.MethodPost, "/admin/tokens/revoke", adminToken(t),
+		map[string]string{"jti": claims.ID, "reason": "Laptop stolen"})
+	if resp.StatusCode != http.StatusOK || body["disconnected"] != float64(1) {
+		t.Fatalf("revoke = %d %v, want 200 with one disconnected", resp.StatusCode, body)
+	}
+	msg := readMessage(t, mallory, protocol.TypeError)
+	if msg.Payload["code"] != "TOKEN_REVOKED" || msg.Payload["reason"] != "Laptop stolen" {
+		t.Errorf("error payload = %v, want TOKEN_REVOKED with the reason", msg.Payload)
+	}
+	if events := rec.ofType(audit.EventAdminRevoke); len(events) != 1 || events[0].Details["jti"] != claims.ID {
+		t.Errorf("admin_revoke events = %+v, want one for the token", events)
+	}
+	if ids := connectionIDsFor(t, ts, "alice"); len(ids) != 1 {
+		t.Errorf("alice's connections = %v, want hers untouched", ids)
+	}
+	alice.Close()
+
+	// The revoked token can no longer authenticate
+	ws, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer ws.Close()
+	data, _ := protocol.EncodeMessage(protocol.TypeAuth, map[string]interface{}{
+		"type":  protocol.TypeAuth,
+		"id":    "auth",
+		"token": token,
+	}, time.Now().UnixMilli())
+	ws.WriteMessage(gorilla.BinaryMessage, data)
+	if msg := readMessage(t, ws, protocol.TypeAuthError); msg.Payload["code"] != "TOKEN_REVOKED" {
+		t.Errorf("auth error code = %v, want TOKEN_REVOKED", msg.Payload["code"])
+	}
+}
+
+func TestAdmin_RevokeUserTokensIssuedBefore(t *testing.T) {
+	_, ts := newTestServer(t)
+	opsToken := tokenFor(t, "ops", auth.CreateAdminPermissions())
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/connections", opsToken, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status before revoking = %d, want 200", resp.StatusCode)
+	}
+
+	resp, body := adminRequest(t, ts, http.MethodPost, "/admin/tokens/revoke", adminToken(t), map[string]string{"userId": "ops"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("revoke = %d %v, want 200", resp.StatusCode, body)
+	}
+	resp, body = adminRequest(t, ts, http.MethodGet, "/admin/connections", opsToken, nil)
+	if resp.StatusCode != http.StatusUnauthorized || body["code"] != "TOKEN_REVOKED" {
+		t.Errorf("revoked admin token = %d %v, want 401 TOKEN_REVOKED", resp.StatusCode, body)
+	}
+
+	// Tokens issued after the cutoff are unaffected
+	cutoff := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	adminRequest(t, ts, http.MethodPost, "/admin/tokens/revoke", adminToken(t), map[string]string{"userId": "dev", "issuedBefore": cutoff})
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/connections", tokenFor(t, "dev", auth.CreateAdminPermissions()), nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("token issued after the cutoff = %d, want 200", resp.StatusCode)
+	}
+
+	for _, bad := range []map[string]string{{}, {"jti": "abc", "userId": "ops"}} {
+		if resp, _ := adminRequest(t, ts, http.MethodPost, "/admin/tokens/revoke", adminToken(t), bad); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("body %v = %d, want 400", bad, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/schema"
+)
+
+// newSchemas loads SCHEMA_FILE. Schemas set through /admin/schemas are kept
+// in memory by each server, on top of the file's.
+func newSchemas(cfg *config.Config) *schema.Registry {
+	mode := cfg.SchemaValidation
+	if mode == "" {
+		mode = schema.ModeState
+	}
+	if cfg.SchemaFile != "" {
+		schemas, err := schema.LoadFile(cfg.SchemaFile, mode)
+		if err == nil {
+			return schemas
+		}
+		slog.Error("Invalid SCHEMA_FILE, validating no documents", "err", err)
+	}
+	schemas, err := schema.NewRegistry(mode)
+	if err != nil {
+		slog.Error("Invalid SCHEMA_VALIDATION, validating whole documents", "err", err)
+		schemas, _ = schema.NewRegistry(schema.ModeState)
+	}
+	return schemas
+}
+
+// handleAdminSchemas serves GET /admin/schemas, which lists the schemas by
+// document ID prefix
+func (s *Server) handleAdminSchemas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"mode":    s.schemas.Mode(),
+		"schemas": s.schemas.All(),
+	})
+}
+
+// handleAdminSchema serves GET, PUT and DELETE /admin/schemas/{prefix},
+// which read, set and remove the schema of documents whose IDs start with
+// prefix. PUT takes the schema itself as its body.
+func (s *Server) handleAdminSchema(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimPrefix(r.URL.Path, "/admin/schemas/")
+	if prefix == "" {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		compiled := s.schemas.Get(prefix)
+		if compiled == nil {
+			writeError(w, http.StatusNotFound, "Schema not found", "SCHEMA_NOT_FOUND")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"prefix": prefix, "schema": compiled})
+
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, err, "Failed to read schema")
+			return
+		}
+		compiled, err := schema.Compile(data)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid schema: "+err.Error(), "INVALID_SCHEMA")
+			return
+		}
+		s.schemas.Set(prefix, compiled)
+		s.audit.Log(audit.Event{
+			Type:    audit.EventAdminSchema,
+			Actor:   adminID(r),
+			Details: map[string]interface{}{"action": "set", "prefix": prefix},
+		})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"prefix": prefix, "schema": compiled})
+
+	case http.MethodDelete:
+		if !s.schemas.Remove(prefix) {
+			writeError(w, http.StatusNotFound, "Schema not found", "SCHEMA_NOT_FOUND")
+			return
+		}
+		s.audit.Log(audit.Event{
+			Type:    audit.EventAdminSchema,
+			Actor:   adminID(r),
+			Details: map[string]interface{}{"action": "remove", "prefix": prefix},
+		})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"removed": true})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/grpc/documentpb"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminSchemas_ValidateDeltas(t *testing.T) {
+	s, ts := newTestServer(t)
+	admin := adminToken(t)
+	writer := dialWithToken(t, ts, tokenFor(t, "writer", auth.CreateUserPermissions([]string{"*"}, []string{"*"})), "")
+
+	resp, body := adminRequest(t, ts, http.MethodPut, "/admin/schemas/room:orders:", admin, map[string]interface{}{
+		"properties": map[string]interface{}{"total": map[string]interface{}{"type": "number"}},
+	})
+	if resp.StatusCode != http.StatusOK || body["prefix"] != "room:orders:" {
+		t.Fatalf("PUT schema = %d %v", resp.StatusCode, body)
+	}
+	resp, body = adminRequest(t, ts, http.MethodGet, "/admin/schemas", admin, nil)
+	schemas, _ := body["schemas"].(map[string]interface{})
+	if resp.StatusCode != http.StatusOK || body["mode"] != "state" || schemas["room:orders:"] == nil {
+		t.Errorf("GET schemas = %d %v", resp.StatusCode, body)
+	}
+
+	sendMessage(t, writer, protocol.TypeDelta, map[string]interface{}{
+		"id": "d1", "docId": "room:orders:1", "changes": map[string]interface{}{"total": "12"},
+	})
+	ack := readMessage(t, writer, protocol.TypeAck)
+	if ack.Payload["status"] != "rejected" || ack.Payload["code"] != "SCHEMA_VIOLATION" {
+		t.Errorf("ack = %v, want rejected with SCHEMA_VIOLATION", ack.Payload)
+	}
+
+	// gRPC Put replaces the whole document, which is checked too
+	client := dialGRPC(t, s)
+	_, err := client.Put(withToken(t, admin), &documentpb.PutRequest{DocId: "room:orders:2", State: mustStruct(t, map[string]interface{}{"total": "12"})})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Put error = %v, want InvalidArgument", err)
+	}
+
+	resp, body = adminRequest(t, ts, http.MethodPut, "/admin/schemas/room:orders:", admin, map[string]interface{}{"oneOf": []interface{}{}})
+	if resp.StatusCode != http.StatusBadRequest || body["code"] != "INVALID_SCHEMA" {
+		t.Errorf("PUT unsupported schema = %d %v, want 400 INVALID_SCHEMA", resp.StatusCode, body)
+	}
+
+	// Once removed the document is no longer checked
+	if resp, body := adminRequest(t, ts, http.MethodDelete, "/admin/schemas/room:orders:", admin, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE schema = %d %v", resp.StatusCode, body)
+	}
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/schemas/room:orders:", admin, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET removed schema = %d, want 404", resp.StatusCode)
+	}
+	sendMessage(t, writer, protocol.TypeDelta, map[string]interface{}{
+		"id": "d2", "docId": "room:orders:1", "changes": map[string]interface{}{"total": "12"},
+	})
+	if ack := readMessage(t, writer, protocol.TypeAck); ack.Payload["status"] != "applied" {
+		t.Errorf("ack = %v, want applied", ack.Payload)
+	}
+
+	// Only admins manage schemas
+	writerToken := tokenFor(t, "writer", auth.CreateUserPermissions([]string{"*"}, []string{"*"}))
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/admin/schemas", writerToken, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET schemas as a writer = %d, want 403", resp.StatusCode)
+	}
+}
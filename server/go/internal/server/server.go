@@ -3,101 +3,612 @@ package server
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/Dancode-188/synckit/server/go/internal/acl"
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/broker"
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
 	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/metrics"
+	"github.com/Dancode-188/synckit/server/go/internal/schema"
 	"github.com/Dancode-188/synckit/server/go/internal/security"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/Dancode-188/synckit/server/go/internal/version"
 	"github.com/Dancode-188/synckit/server/go/internal/websocket"
 	gorilla "github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	grpclib "google.golang.org/grpc"
 )
 
-var upgrader = gorilla.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		// Allow connections with no origin (non-browser clients)
-		if origin == "" {
-			return true
-		}
-		// In development, allow all origins
-		env := os.Getenv("ENVIRONMENT")
-		if env != "production" {
-			return true
-		}
-		// In production, check against allowed origins
-		allowed := os.Getenv("CORS_ORIGINS")
-		if allowed == "" || allowed == "*" {
-			return true
-		}
-		for _, o := range strings.Split(allowed, ",") {
-			if strings.TrimSpace(o) == origin {
-				return true
-			}
-		}
-		return false
-	},
-}
-
 // Server represents the HTTP server
 type Server struct {
 	config          *config.Config
 	hub             *websocket.Hub
 	server          *http.Server
+	healthServer    *http.Server    // Plaintext health listener; nil without HealthPort
+	grpcServer      *grpclib.Server // DocumentService listener; nil without GRPCPort
+	upgrader        gorilla.Upgrader
+	origins         *security.OriginPolicy
+	publicDocs      *security.PublicDocumentPolicy
 	securityManager *security.SecurityManager
+	adminLimiter    *security.ConnectionRateLimiter // Admin requests per IP
+	authLimiter     *security.ConnectionRateLimiter // /auth requests per IP
+	credentials     auth.CredentialVerifier         // Checks POST /auth/token; nil disables it
+	refreshTokens   auth.RefreshTokenStore          // Refresh tokens already exchanged
+	revocations     auth.RevocationStore            // Tokens revoked before they expire
+	verifier        auth.TokenVerifier              // Checks clients' access tokens
+	apiKeys         *auth.APIKeys                   // Checks server-to-server clients' API keys
+	acl             *acl.Evaluator                  // Per-document grants on top of token permissions
+	schemas         *schema.Registry                // Schemas deltas are validated against
+	storage         storage.StorageAdapter          // Nil when documents are kept in memory only
+	redis           *redis.Client                   // Shared limiter counts; nil when limits are per server
+	broker          broker.Broker                   // Cross-server messaging over Redis or NATS; nil when not connected
+	pubsub          *storage.RedisPubSub            // The broker when it is Redis; nil otherwise
+	registry        *storage.ServerRegistry         // Servers sharing Redis; nil when not connected
+	streams         *storage.RedisStreams           // Delta transport with REDIS_TRANSPORT=streams; nil otherwise
+	shuttingDown    atomic.Bool                     // Set once Shutdown begins; new upgrades get 503
+	audit           audit.AuditLogger
+	auditStore      *audit.StorageLogger // Nil without storage
+	stopCleanup     context.CancelFunc   // Stops the cleanup loop; nil when it is not running
+	metrics         *metrics.Registry
+	logger          *slog.Logger                // Base of each request's logger
+	onConnect       func(r *http.Request) error // Vets websocket upgrades; nil allows all
+	startedAt       time.Time
+}
+
+// Options replace parts of what New builds from the config, for programs
+// embedding the server. Zero values keep the config's behaviour.
+type Options struct {
+	// Storage is a connected adapter used instead of DATABASE_URL. The
+	// server disconnects it on Shutdown.
+	Storage storage.StorageAdapter
+
+	// Broker is used instead of connecting to Redis or NATS
+	Broker broker.Broker
+
+	// Logger is the base of the hub's and requests' loggers (nil uses
+	// slog.Default)
+	Logger *slog.Logger
+
+	// Hooks are passed to the hub (see websocket.Hooks)
+	Hooks websocket.Hooks
+
+	// OnConnect runs before each websocket upgrade; an error refuses it
+	// with 403, using the code and message of a *websocket.HookError
+	OnConnect func(r *http.Request) error
 }
 
 // New creates a new server
 func New(cfg *config.Config) *Server {
-	hub := websocket.NewHub(cfg.JWTSecret)
+	return NewWithOptions(cfg, Options{})
+}
+
+// NewWithOptions creates a server from cfg with parts of it replaced
+func NewWithOptions(cfg *config.Config, opts Options) *Server {
+	reg := metrics.NewRegistry()
+	reg.RegisterRuntime()
+	reg.SetConstLabels(map[string]string{"version": version.Version})
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	// The codec is shared by every server in the process
+	if err := codec.Use(cfg.JSONCodec); err != nil {
+		logger.Warn("Keeping the current JSON codec", "err", err)
+	}
+
+	var store storage.StorageAdapter
+	var persist websocket.PersistFunc
+	var load websocket.LoadFunc
+	if opts.Storage != nil {
+		store = storage.Instrument(opts.Storage, reg)
+		persist, load = storageFuncs(store)
+	} else if cfg.DatabaseURL != "" {
+		store, persist, load = connectStorage(cfg.DatabaseURL, reg)
+	}
+	auditLog, auditStore := newAuditLogger(cfg, store, logger)
+
+	serverID := cfg.ServerID
+	if serverID == "" {
+		serverID = generateConnID()
+	}
+	var pubsub *storage.RedisPubSub
+	var msgBroker broker.Broker
+	relayKind := "nats"
+	switch {
+	case opts.Broker != nil:
+		msgBroker = opts.Broker
+		pubsub, _ = opts.Broker.(*storage.RedisPubSub)
+		if pubsub == nil {
+			relayKind = "broker"
+		}
+	case cfg.Broker == "nats":
+		if n := connectNATS(cfg, serverID); n != nil {
+			msgBroker = n
+		}
+	case cfg.RelayRedisConfigured():
+		if pubsub = connectRelay(cfg); pubsub != nil {
+			msgBroker = pubsub
+		}
+	}
+	var streams *storage.RedisStreams
+	var relay websocket.Relay = msgBroker
+	var sharedAwareness websocket.AwarenessStore
+	if pubsub != nil {
+		if cfg.RedisTransport == "streams" {
+			streams = storage.NewRedisStreams(pubsub, storage.RedisStreamsConfig{ServerID: serverID, MaxLen: int64(cfg.RedisStreamMaxLen)})
+			relay = streams
+		}
+		sharedAwareness = storage.NewRedisAwareness(pubsub, 0)
+	}
+
+	var redisClient *redis.Client
+	var secOpts security.SecurityOptions
+	if cfg.RedisURL != "" {
+		redisClient, secOpts = connectLimiters(cfg)
+	}
+	var revocations auth.RevocationStore = &auth.MemoryRevocations{}
+	if redisClient != nil {
+		revocations = auth.NewRedisRevocations(redisClient, cfg.RedisChannelPrefix)
+	}
+
+	verifier := newTokenVerifier(cfg)
+	apiKeys := newAPIKeys(cfg, store)
+	documentACL := newDocumentACL(cfg, store)
+	schemas := newSchemas(cfg)
+
+	hub := websocket.NewHubWithOptions(websocket.AuthConfig{
+		JWTSecret:     cfg.JWTSecret,
+		Required:      cfg.AuthRequired,
+		AnonymousRead: cfg.AnonymousRead,
+	}, websocket.HubOptions{
+		KickDuplicateClients:   cfg.KickDuplicateClients,
+		CompressionThreshold:   cfg.CompressionThreshold,
+		StrictBatches:          cfg.StrictBatches,
+		ResumeBufferSize:       cfg.ResumeBufferSize,
+		ResumeRetention:        cfg.ResumeRetention,
+		ShutdownReconnectDelay: cfg.ShutdownReconnectDelay,
+		Workers:                cfg.HubWorkers,
+		HeartbeatInterval:      cfg.HeartbeatInterval,
+		SyncRequiredThreshold:  cfg.SyncRequiredThreshold,
+		Persist:                persist,
+		DurableAcks:            cfg.DurableAcks,
+		StorageHealth:          degradedHealth(cfg, store),
+		HealthCheckInterval:    cfg.StorageHealthInterval,
+		WriteBufferSize:        cfg.WriteBufferSize,
+		MaxDocuments:           cfg.DocumentCacheSize,
+		MaxDocumentBytes:       cfg.DocumentCacheBytes,
+		DocumentIdleTime:       cfg.DocumentCacheIdle,
+		Load:                   load,
+		MessageTimeout:         cfg.MessageTimeout,
+		Relay:                  relay,
+		ServerID:               serverID,
+		SharedAwareness:        sharedAwareness,
+		FlushWatermarks:        flushWatermarks(store),
+		ChecksumInterval:       cfg.RelayChecksumInterval,
+		Limits:                 cfg.Limits,
+		PublicDocuments:        cfg.PublicDocuments,
+		Audit:                  auditLog,
+		AuthTimeout:            cfg.AuthTimeout,
+		TokenExpiryWarning:     cfg.TokenExpiryWarning,
+		TokenExpiryGrace:       cfg.TokenExpiryGrace,
+		Verifier:               verifier,
+		APIKeys:                apiKeys,
+		ACL:                    documentACL,
+		Schemas:                schemas,
+		OpenDocumentCreation:   cfg.OpenDocumentCreation,
+		MultiTenancy:           cfg.MultiTenancy,
+		Revocations:            revocations,
+		CheckRevocationOnWrite: cfg.TokenRevocationOnWrite,
+		Metrics:                reg,
+		Logger:                 logger,
+		Hooks:                  opts.Hooks,
+		Maintenance: websocket.Maintenance{
+			Enabled:    cfg.MaintenanceMode,
+			Message:    cfg.MaintenanceMessage,
+			RetryAfter: cfg.MaintenanceRetryAfter,
+		},
+	})
 	go hub.Run()
+	if pubsub != nil {
+		watchRelay(pubsub, "redis", hub, reg)
+	} else if msgBroker != nil {
+		watchRelay(msgBroker, relayKind, hub, reg)
+	}
 
-	sm := security.NewSecurityManager()
+	if secOpts.Bans == nil {
+		secOpts.Bans = security.NewBanList(cfg.Bans, nil)
+	}
+	secOpts.Metrics = reg
+	sm := security.NewSecurityManagerWithOptions(cfg.Limits, secOpts)
+	sm.Bans.OnBan(func(ban security.Ban) {
+		hub.DisconnectIP(ban.IP, ban.Reason)
+	})
 
-	return &Server{
+	s := &Server{
 		config:          cfg,
 		hub:             hub,
 		securityManager: sm,
+		storage:         store,
+		redis:           redisClient,
+		revocations:     revocations,
+		verifier:        verifier,
+		apiKeys:         apiKeys,
+		acl:             documentACL,
+		schemas:         schemas,
+		broker:          msgBroker,
+		pubsub:          pubsub,
+		streams:         streams,
+		audit:           auditLog,
+		auditStore:      auditStore,
+		origins:         cfg.Origins,
+		publicDocs:      cfg.PublicDocuments,
+		metrics:         reg,
+		logger:          logger,
+		onConnect:       opts.OnConnect,
+		startedAt:       time.Now(),
+	}
+	if s.publicDocs == nil {
+		s.publicDocs = security.DefaultPublicDocumentPolicy()
+	}
+	if s.origins == nil {
+		s.origins, _ = security.NewOriginPolicy(security.OriginRules{})
+	}
+	s.upgrader = gorilla.Upgrader{CheckOrigin: s.checkOrigin, Error: upgradeError, EnableCompression: cfg.WebsocketCompression}
+	adminRate := cfg.AdminRateLimit
+	if adminRate <= 0 {
+		adminRate = defaultAdminRateLimit
+	}
+	s.adminLimiter = security.NewConnectionRateLimiter(adminRate)
+	s.setupAuth(cfg)
+	if pubsub != nil {
+		s.registry = s.startRegistry(pubsub)
+	}
+	if store != nil && cfg.AuditRetentionDays > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopCleanup = cancel
+		go s.runCleanup(ctx)
+	}
+	return s
+}
+
+// newAuditLogger builds the audit logger from the config: log lines unless
+// AuditLog is off, plus stored events when storage is connected
+func newAuditLogger(cfg *config.Config, store storage.StorageAdapter, logger *slog.Logger) (audit.AuditLogger, *audit.StorageLogger) {
+	var loggers audit.Multi
+	if cfg.AuditLog {
+		loggers = append(loggers, audit.NewLogLogger(logger))
+	}
+	var stored *audit.StorageLogger
+	if store != nil {
+		stored = audit.NewStorageLogger(store)
+		loggers = append(loggers, stored)
+	}
+	return loggers, stored
+}
+
+// cleanupInterval is how often expired stored data is removed
+const cleanupInterval = time.Hour
+
+// runCleanup removes audit events older than the retention period every
+// cleanupInterval until ctx is cancelled
+func (s *Server) runCleanup(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.cleanup(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) cleanup(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	result, err := s.storage.Cleanup(ctx, &storage.CleanupOptions{
+		OldAuditEventsDays: s.config.AuditRetentionDays,
+	})
+	if err != nil {
+		slog.Warn("Cleanup failed", "err", err)
+		return
+	}
+	if result.AuditEventsDeleted > 0 {
+		slog.Info("Removed old audit events", "count", result.AuditEventsDeleted, "retention_days", s.config.AuditRetentionDays)
 	}
 }
 
-// Start starts the HTTP server
-func (s *Server) Start(addr string) error {
+// connectLimiters connects to Redis so per-IP connection and document limits
+// are shared by every server and bans survive restarts. If Redis is
+// unreachable each server enforces the limits on its own.
+func connectLimiters(cfg *config.Config) (*redis.Client, security.SecurityOptions) {
+	opt, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		slog.Warn("Invalid REDIS_URL, enforcing limits per server", "err", err)
+		return nil, security.SecurityOptions{}
+	}
+	client := redis.NewClient(opt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		slog.Warn("Redis unavailable, enforcing limits per server", "err", err)
+		client.Close()
+		return nil, security.SecurityOptions{}
+	}
+
+	limits := cfg.Limits.WithDefaults()
+	opts := security.RedisLimiterOptions{Prefix: cfg.RedisChannelPrefix}
+	userOpts := security.RedisLimiterOptions{Prefix: cfg.RedisChannelPrefix + ":user"}
+	return client, security.SecurityOptions{
+		Connections:   security.NewRedisConnectionLimiter(client, limits.MaxConnectionsPerIP, opts),
+		Documents:     security.NewRedisDocumentLimiter(client, limits.MaxDocsPerIP, limits.MaxDocsPerHour, opts),
+		UserDocuments: security.NewRedisDocumentLimiter(client, limits.MaxDocsPerIP, limits.MaxDocsPerHour, userOpts),
+		Bans:          security.NewBanList(cfg.Bans, security.NewRedisBanStore(client, opts)),
+	}
+}
+
+// connectRelay connects the Redis pub/sub the hub uses to share deltas and
+// awareness with other servers. If Redis is unreachable each server only
+// sees its own clients' changes.
+func connectRelay(cfg *config.Config) *storage.RedisPubSub {
+	pubsub, err := storage.NewRedisPubSub(&storage.RedisPubSubConfig{
+		URL:                   cfg.RedisURL,
+		ChannelPrefix:         cfg.RedisChannelPrefix + ":",
+		MaxRetries:            3,
+		HandlerWorkers:        cfg.RedisHandlerWorkers,
+		Mode:                  cfg.RedisMode,
+		SentinelMaster:        cfg.RedisSentinelMaster,
+		SentinelAddrs:         cfg.RedisSentinelAddrs,
+		ClusterAddrs:          cfg.RedisClusterAddrs,
+		TLSCAFile:             cfg.RedisTLSCAFile,
+		TLSInsecureSkipVerify: cfg.RedisTLSInsecureSkipVerify,
+	})
+	if err != nil {
+		slog.Warn("Invalid Redis settings, not relaying between servers", "mode", cfg.RedisMode, "err", err)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pubsub.Connect(ctx); err != nil {
+		slog.Warn("Redis unavailable, not relaying between servers", "mode", cfg.RedisMode, "err", err)
+		pubsub.Disconnect(context.Background())
+		return nil
+	}
+	return pubsub
+}
+
+// connectNATS connects the NATS broker the hub uses to share deltas and
+// awareness with other servers. Subjects take the Redis channel prefix. If
+// NATS is unreachable each server only sees its own clients' changes.
+func connectNATS(cfg *config.Config, serverID string) *broker.NATS {
+	n := broker.NewNATS(broker.NATSConfig{
+		URL:           cfg.NATSURL,
+		SubjectPrefix: cfg.RedisChannelPrefix + ".",
+		Name:          "synckit-" + serverID,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Connect(ctx); err != nil {
+		slog.Warn("NATS unavailable, not relaying between servers", "err", err)
+		return nil
+	}
+	return n
+}
+
+// watchRelay exports the broker's connection state, under metric names for
+// its kind ("redis" or "nats"), and resyncs documents whose relayed deltas
+// may have been missed while it was unreachable
+func watchRelay(b broker.Broker, kind string, hub *websocket.Hub, reg *metrics.Registry) {
+	reg.NewGaugeFunc("synckit_"+kind+"_connected", "Whether the "+kind+" broker is reachable (1) or not (0).", func() float64 {
+		if b.IsConnected() {
+			return 1
+		}
+		return 0
+	})
+	reg.NewCounterFunc("synckit_"+kind+"_reconnects_total", "Broker subscriptions restored after a connection loss.", func() float64 {
+		return float64(b.GetStats().Reconnects)
+	})
+	reg.NewCounterFunc("synckit_"+kind+"_handler_panics_total", "Relayed messages whose handler panicked.", func() float64 {
+		return float64(b.GetStats().HandlerPanics)
+	})
+	b.OnReconnect(func(docIDs []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := hub.ResyncDocuments(ctx, docIDs); err != nil {
+			slog.Warn("Resync after broker reconnect failed", "broker", kind, "documents", len(docIDs), "err", err)
+		}
+	})
+}
+
+// startRegistry joins the registry of servers sharing Redis, heartbeating
+// the version and connection count. A server the registry finds offline is
+// only logged; its clients reconnect elsewhere.
+func (s *Server) startRegistry(pubsub *storage.RedisPubSub) *storage.ServerRegistry {
+	registry := storage.NewServerRegistry(pubsub, storage.ServerRegistryOptions{
+		ServerID: s.hub.ServerID(),
+		Metadata: func() map[string]interface{} {
+			return map[string]interface{}{
+				"version":     version.Version,
+				"connections": s.hub.Stats().Connections,
+			}
+		},
+		OnOffline: func(serverID string) {
+			slog.Warn("Server went offline", "server_id", serverID)
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := registry.Start(ctx); err != nil {
+		slog.Warn("Server registry unavailable", "err", err)
+		return nil
+	}
+	return registry
+}
+
+// connectStorage connects to PostgreSQL for document persistence, recording
+// operation latencies in reg. If the database is unreachable the server keeps
+// documents in memory only.
+func connectStorage(url string, reg *metrics.Registry) (storage.StorageAdapter, websocket.PersistFunc, websocket.LoadFunc) {
+	storageConfig := storage.DefaultStorageConfig()
+	storageConfig.ConnectionString = url
+	adapter := storage.Instrument(storage.NewPostgresAdapter(storageConfig), reg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), storageConfig.ConnectionTimeout)
+	defer cancel()
+	if err := adapter.Connect(ctx); err != nil {
+		slog.Warn("Storage unavailable, keeping documents in memory", "err", err)
+		return nil, nil, nil
+	}
+	persist, load := storageFuncs(adapter)
+	return adapter, persist, load
+}
+
+// flushWatermarks returns adapter as the hub's flush watermark store, or
+// nil if the adapter it instruments cannot keep watermarks
+func flushWatermarks(adapter storage.StorageAdapter) storage.FlushWatermarkStorage {
+	if instrumented, ok := adapter.(*storage.Instrumented); ok {
+		if _, ok := instrumented.StorageAdapter.(storage.FlushWatermarkStorage); !ok {
+			return nil
+		}
+	}
+	watermarks, _ := adapter.(storage.FlushWatermarkStorage)
+	return watermarks
+}
+
+// storageFuncs persists and loads the hub's documents through adapter
+func storageFuncs(adapter storage.StorageAdapter) (websocket.PersistFunc, websocket.LoadFunc) {
+	persist := func(ctx context.Context, docID string, state map[string]interface{}) error {
+		_, err := adapter.SaveDocument(ctx, docID, state)
+		return err
+	}
+	load := func(ctx context.Context, docID string) (map[string]interface{}, error) {
+		doc, err := adapter.GetDocument(ctx, docID)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		return doc.State, nil
+	}
+	return persist, load
+}
+
+// Handler is the full HTTP API, for serving from a server of the caller's.
+// Shutdown still closes websocket connections and storage.
+func (s *Server) Handler() http.Handler {
+	return s.routes()
+}
+
+// Subscribe returns a channel of the document events the hub applies that
+// match filter, until ctx is done or the server shuts down
+func (s *Server) Subscribe(ctx context.Context, filter websocket.EventFilter) (<-chan websocket.DocumentEvent, error) {
+	return s.hub.Subscribe(ctx, filter)
+}
+
+// routes builds the HTTP handler
+func (s *Server) routes() http.Handler {
 	mux := http.NewServeMux()
 
 	// Routes
 	mux.HandleFunc("/", s.handleRoot)
-	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/health", s.handleReady)
+	mux.HandleFunc("/health/live", s.handleLive)
+	mux.HandleFunc("/health/ready", s.handleReady)
 	mux.HandleFunc("/ws", s.handleWebSocket)
-
-	s.server = &http.Server{
-		Addr:         addr,
-		Handler:      s.corsMiddleware(mux),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	mux.Handle("/admin/", s.adminRoutes())
+	mux.Handle("/auth/", s.authRoutes())
+	mux.Handle("/share/", s.limitAuth(http.HandlerFunc(s.handleShareExchange)))
+	mux.HandleFunc("/api/documents", s.requireAuth(s.handleListDocuments))
+	mux.HandleFunc("/api/documents/", s.handleDocuments)
+	mux.HandleFunc("/api/snapshots/", s.requireAuth(s.handleSnapshot))
+	stats := s.handleStats
+	if s.config.StatsAuthRequired {
+		stats = s.requireAuth(stats)
+	}
+	mux.HandleFunc("/stats", stats)
+	mux.HandleFunc("/version", s.handleVersion)
+	if s.config.MetricsEnabled {
+		mux.HandleFunc("/metrics", s.handleMetrics)
 	}
 
-	return s.server.ListenAndServe()
+	return s.middleware(mux)
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server. WebSocket connections are
+// hijacked and invisible to http.Server, so the hub closes them first: new
+// upgrades are refused with 503, clients are told to reconnect elsewhere,
+// queued messages are applied, and sockets close with 1001 (going away).
 func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	if s.registry != nil {
+		s.registry.Stop(ctx)
+	}
+	if err := s.hub.Stop(ctx); err != nil {
+		slog.Warn("Hub did not shut down cleanly", "err", err)
+	}
+	if s.stopCleanup != nil {
+		s.stopCleanup()
+	}
+	if s.auditStore != nil {
+		s.auditStore.Close()
+	}
+	if s.storage != nil {
+		s.storage.Disconnect(ctx)
+	}
+	s.securityManager.Dispose()
+	s.adminLimiter.Dispose()
+	s.authLimiter.Dispose()
+	if s.redis != nil {
+		s.redis.Close()
+	}
+	if s.streams != nil {
+		s.streams.Close()
+	}
+	if s.broker != nil {
+		s.broker.Disconnect(ctx)
+	}
+	if s.grpcServer != nil {
+		stopGRPC(ctx, s.grpcServer)
+	}
+	if s.healthServer != nil {
+		s.healthServer.Shutdown(ctx)
+	}
+	if s.server == nil {
+		return nil
+	}
 	return s.server.Shutdown(ctx)
 }
 
 func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	build := version.Get()
 	response := map[string]interface{}{
 		"name":        "SyncKit Server",
-		"version":     "0.3.0",
+		"version":     build.Version,
+		"commit":      build.Commit,
 		"description": "Production-ready WebSocket sync server",
 		"endpoints": map[string]string{
-			"health": "/health",
-			"ws":     "/ws",
+			"health":  "/health",
+			"live":    "/health/live",
+			"ready":   "/health/ready",
+			"stats":   "/stats",
+			"version": "/version",
+			"ws":      "/ws",
 		},
 		"features": map[string]string{
 			"websocket": "Real-time sync via WebSocket",
@@ -111,31 +622,84 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	response := map[string]interface{}{
-		"status":    "healthy",
-		"timestamp": time.Now().Format(time.RFC3339),
-		"version":   "0.3.0",
-	}
+// handleVersion serves GET /version: the build information
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, version.Get())
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// handleMetrics serves the metrics registry, requiring MetricsToken as a
+// bearer token when one is configured
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if token := s.config.MetricsToken; token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "Invalid metrics token", "NOT_AUTHENTICATED")
+			return
+		}
+	}
+	s.metrics.Handler().ServeHTTP(w, r)
 }
 
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if s.shuttingDown.Load() {
+		writeError(w, http.StatusServiceUnavailable, "Server is shutting down", "SHUTTING_DOWN")
+		return
+	}
+
 	// Extract client IP
 	clientIP := s.getClientIP(r)
+	logger := logging.FromContext(r.Context()).With("ip", clientIP)
+
+	// Banned IPs are turned away before any websocket work
+	if s.securityManager.Bans.IsBanned(clientIP) {
+		logger.Warn("Rejected connection from banned IP")
+		writeError(w, http.StatusForbidden, "Your IP is banned", "IP_BANNED")
+		return
+	}
 
 	// Check per-IP connection limit
 	if !s.securityManager.ConnectionLimiter.CanConnect(clientIP) {
-		log.Printf("[SECURITY] Connection limit exceeded for IP: %s", clientIP)
-		http.Error(w, "Too many connections from your IP", http.StatusTooManyRequests)
+		logger.Warn("Connection limit exceeded")
+		s.securityManager.RecordRejection("connections")
+		s.audit.Log(audit.Event{
+			Type:    audit.EventRateLimited,
+			IP:      clientIP,
+			Details: map[string]interface{}{"limit": "connections"},
+		})
+		writeError(w, http.StatusTooManyRequests, "Too many connections from your IP", "RATE_LIMITED")
+		return
+	}
+
+	// Unauthenticated connections have a lower per-IP cap, so idle
+	// handshakes cannot hold every slot
+	if !s.securityManager.PendingAuthLimiter.CanConnect(clientIP) {
+		logger.Warn("Unauthenticated connection limit exceeded")
+		s.securityManager.RecordRejection("unauthenticated")
+		s.audit.Log(audit.Event{
+			Type:    audit.EventRateLimited,
+			IP:      clientIP,
+			Details: map[string]interface{}{"limit": "unauthenticated"},
+		})
+		writeError(w, http.StatusTooManyRequests, "Too many unauthenticated connections from your IP", "RATE_LIMITED")
 		return
 	}
 
-	ws, err := upgrader.Upgrade(w, r, nil)
+	if s.onConnect != nil {
+		if err := s.onConnect(r); err != nil {
+			code, message := "CONNECTION_DENIED", "Connection refused by server policy"
+			var hookErr *websocket.HookError
+			if errors.As(err, &hookErr) {
+				code, message = hookErr.Code, hookErr.Message
+			}
+			logger.Warn("Connection denied by hook", "code", code, "err", err)
+			writeError(w, http.StatusForbidden, message, code)
+			return
+		}
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		logger.Warn("WebSocket upgrade failed", "err", err)
 		return
 	}
 
@@ -144,14 +708,36 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	conn := websocket.NewConnection(generateConnID(), ws, s.hub)
 	conn.ClientIP = clientIP
+	conn.SetLogger(logger)
 	conn.SecurityManager = s.securityManager
-	s.hub.Register <- conn
+	select {
+	case s.hub.Register <- conn:
+	case <-s.hub.Done():
+		s.securityManager.ConnectionLimiter.RemoveConnection(clientIP)
+		ws.Close()
+		return
+	}
 
 	// Start pumps
 	go conn.WritePump()
 	go conn.ReadPump()
 }
 
+// checkOrigin applies the origin policy to a websocket upgrade, logging and
+// counting rejections
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if s.origins.Allows(origin) {
+		return true
+	}
+	if origin == "" {
+		origin = "(none)"
+	}
+	logging.FromContext(r.Context()).Warn("Rejected websocket origin", "origin", origin, "ip", s.getClientIP(r))
+	s.hub.RecordOriginRejection()
+	return false
+}
+
 func (s *Server) getClientIP(r *http.Request) string {
 	// Check X-Forwarded-For (reverse proxy)
 	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
@@ -173,22 +759,6 @@ func (s *Server) getClientIP(r *http.Request) string {
 	return r.RemoteAddr
 }
 
-func (s *Server) corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
 func generateConnID() string {
 	b := make([]byte, 16)
 	rand.Read(b)
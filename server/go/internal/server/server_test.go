@@ -0,0 +1,675 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+	"github.com/Dancode-188/synckit/server/go/internal/version"
+	"github.com/alicebob/miniredis/v2"
+	gorilla "github.com/gorilla/websocket"
+)
+
+func TestShutdown_NotifiesClientsAndRefusesUpgrades(t *testing.T) {
+	s, ts := newTestServer(t)
+	ws := dialClient(t, ts, "alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	msg := readMessage(t, ws, protocol.TypeServerShutdown)
+	if _, ok := msg.Payload["reconnectAfter"].(float64); !ok {
+		t.Errorf("server_shutdown payload = %v, want reconnectAfter", msg.Payload)
+	}
+	_, _, err := ws.ReadMessage()
+	if !gorilla.IsCloseError(err, gorilla.CloseGoingAway) {
+		t.Errorf("ReadMessage after shutdown error = %v, want close 1001", err)
+	}
+
+	_, resp, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err == nil {
+		t.Fatal("Dial after shutdown succeeded, want rejection")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("upgrade response = %v, want 503", resp)
+	}
+}
+
+func TestReadPump_RejectsInvalidMessageTypes(t *testing.T) {
+	_, ts := newTestServer(t)
+	ws := dialClient(t, ts, "alice")
+
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"unknown type", `{"type":"hack","id":"m1"}`, "Invalid message type: hack"},
+		{"missing type", `{"id":"m2"}`, "Missing message type"},
+		{"server-only type", `{"type":"sync_response","id":"m3"}`, "Invalid message type: sync_response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ws.WriteMessage(gorilla.TextMessage, []byte(tt.message)); err != nil {
+				t.Fatalf("WriteMessage failed: %v", err)
+			}
+			msg := readMessage(t, ws, protocol.TypeError)
+			if msg.Payload["code"] != "INVALID_MESSAGE_TYPE" || msg.Payload["error"] != tt.want {
+				t.Errorf("error payload = %v, want INVALID_MESSAGE_TYPE %q", msg.Payload, tt.want)
+			}
+		})
+	}
+
+	// The connection stays usable
+	ws.WriteMessage(gorilla.TextMessage, []byte(`{"type":"ping","id":"p"}`))
+	readMessage(t, ws, protocol.TypePong)
+}
+
+func TestNew_SharesLimitsThroughRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := &config.Config{JWTSecret: testSecret, RedisURL: "redis://" + mr.Addr(), RedisChannelPrefix: "test"}
+
+	a, b := New(cfg), New(cfg)
+	for _, s := range []*Server{a, b} {
+		defer s.Shutdown(context.Background())
+		if _, ok := s.securityManager.ConnectionLimiter.(*security.RedisConnectionLimiter); !ok {
+			t.Fatalf("ConnectionLimiter = %T, want Redis-backed", s.securityManager.ConnectionLimiter)
+		}
+	}
+
+	a.securityManager.ConnectionLimiter.AddConnection("10.3.0.1")
+	if got := b.securityManager.ConnectionLimiter.GetConnectionCount("10.3.0.1"); got != 1 {
+		t.Errorf("count on second server = %d, want 1", got)
+	}
+}
+
+func TestNew_FallsBackToLocalLimitsWithoutRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	s := New(&config.Config{JWTSecret: testSecret, RedisURL: "redis://" + addr})
+	defer s.Shutdown(context.Background())
+
+	if _, ok := s.securityManager.ConnectionLimiter.(*security.ConnectionLimiter); !ok {
+		t.Errorf("ConnectionLimiter = %T, want local", s.securityManager.ConnectionLimiter)
+	}
+	if s.redis != nil {
+		t.Error("Redis client should not be kept when Redis is unreachable")
+	}
+}
+
+// newServerWithConfig is newTestServer for a custom configuration
+func newServerWithConfig(t *testing.T, cfg *config.Config) *httptest.Server {
+	t.Helper()
+	cfg.JWTSecret = testSecret
+	s := New(cfg)
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		s.hub.Stop(ctx)
+		ts.Close()
+	})
+	return ts
+}
+
+func sendPing(ws *gorilla.Conn) {
+	ws.WriteMessage(gorilla.TextMessage, []byte(`{"type":"ping","id":"p"}`))
+}
+
+func TestReadPump_BansIPAfterRepeatedRateLimiting(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{
+		Limits: security.Limits{MaxMessagesPerMinute: 3},
+		Bans:   security.BanOptions{Threshold: 3, Duration: time.Minute},
+	})
+	ws := dialClientFrom(t, ts, "alice", "198.51.100.1")
+
+	// Three messages fit alice's budget; the next three are violations
+	for i := 0; i < 6; i++ {
+		sendPing(ws)
+	}
+
+	for {
+		msg := readMessage(t, ws, protocol.TypeError)
+		if msg.Payload["code"] == "IP_BANNED" {
+			break
+		}
+		if msg.Payload["code"] != "RATE_LIMIT_EXCEEDED" {
+			t.Fatalf("error payload = %v, want RATE_LIMIT_EXCEEDED then IP_BANNED", msg.Payload)
+		}
+	}
+	expectUpgradeStatus(t, ts, "198.51.100.1", http.StatusForbidden)
+}
+
+func TestReadPump_RateLimitsPerUserAfterAuth(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{Limits: security.Limits{MaxMessagesPerMinute: 3}})
+
+	t.Run("same user shares a budget across IPs", func(t *testing.T) {
+		laptop := dialClientFrom(t, ts, "alice", "198.51.100.10")
+		phone := dialClientFrom(t, ts, "alice", "198.51.100.11")
+
+		for i := 0; i < 3; i++ {
+			sendPing(laptop)
+			readMessage(t, laptop, protocol.TypePong)
+		}
+		sendPing(phone)
+		if msg := readMessage(t, phone, protocol.TypeError); msg.Payload["code"] != "RATE_LIMIT_EXCEEDED" {
+			t.Errorf("error payload = %v, want RATE_LIMIT_EXCEEDED", msg.Payload)
+		}
+	})
+
+	t.Run("users behind one IP have separate budgets", func(t *testing.T) {
+		bob := dialClientFrom(t, ts, "bob", "198.51.100.20")
+		carol := dialClientFrom(t, ts, "carol", "198.51.100.20")
+
+		for _, ws := range []*gorilla.Conn{bob, carol} {
+			for i := 0; i < 3; i++ {
+				sendPing(ws)
+				readMessage(t, ws, protocol.TypePong)
+			}
+		}
+	})
+}
+
+func TestReadPump_RejectsOversizedMessages(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{Limits: security.Limits{MaxMessageSize: 1024}})
+	ws := dialClientFrom(t, ts, "alice", "")
+
+	// Over the limit but within the frame allowance for a binary header
+	ws.WriteMessage(gorilla.TextMessage, []byte(`{"type":"ping","id":"p","pad":"`+strings.Repeat("x", 1000)+`"}`))
+	if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "MESSAGE_TOO_LARGE" {
+		t.Errorf("error payload = %v, want MESSAGE_TOO_LARGE", msg.Payload)
+	}
+
+	// A binary header declaring far more than the limit is refused unread
+	header := make([]byte, 13)
+	header[0] = byte(protocol.DELTA)
+	binary.BigEndian.PutUint32(header[9:13], 0x7fffffff)
+	ws.WriteMessage(gorilla.BinaryMessage, header)
+	if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "MESSAGE_TOO_LARGE" {
+		t.Errorf("error payload = %v, want MESSAGE_TOO_LARGE", msg.Payload)
+	}
+
+	// The connection carries on
+	sendPing(ws)
+	readMessage(t, ws, protocol.TypePong)
+}
+
+func TestReadPump_ClosesOnOversizedFrames(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{Limits: security.Limits{MaxMessageSize: 1024}})
+	ws := dialClientFrom(t, ts, "alice", "")
+
+	// A frame beyond the limit and any header is never read whole, let alone
+	// decoded, so there is no MESSAGE_TOO_LARGE, just the close
+	ws.WriteMessage(gorilla.TextMessage, []byte(`{"type":"ping","id":"p","pad":"`+strings.Repeat("x", 4096)+`"}`))
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			// The close frame may lose the race with the socket being reset
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				t.Fatal("connection still open after an oversized frame")
+			}
+			return
+		}
+		if msg, err := protocol.DecodeMessage(data); err == nil && msg.Type == protocol.TypeError {
+			t.Fatalf("got error %v, want the connection closed", msg.Payload)
+		}
+	}
+}
+
+func TestReadPump_RejectsCorruptedMessages(t *testing.T) {
+	s, ts := newTestServer(t)
+	ws := dialWithToken(t, ts, tokenFor(t, "alice", auth.CreateUserPermissions([]string{"*"}, []string{"*"})), "")
+	sendMessage(t, ws, protocol.TypeSubscribe, map[string]interface{}{"id": "s", "docId": "room:crc"})
+	readMessage(t, ws, protocol.TypeSyncResponse)
+
+	delta, err := protocol.EncodeMessageWith(protocol.TypeDelta, map[string]interface{}{
+		"type":    protocol.TypeDelta,
+		"id":      "d",
+		"docId":   "room:crc",
+		"changes": map[string]interface{}{"title": "Intact"},
+	}, time.Now().UnixMilli(), protocol.EncodeOptions{Checksum: true})
+	if err != nil {
+		t.Fatalf("EncodeMessageWith failed: %v", err)
+	}
+
+	// A frame garbled on the way is refused rather than applied
+	corrupt := append([]byte(nil), delta...)
+	corrupt[len(corrupt)-4] = 'X'
+	ws.WriteMessage(gorilla.BinaryMessage, corrupt)
+	if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "CHECKSUM_MISMATCH" {
+		t.Errorf("error payload = %v, want CHECKSUM_MISMATCH", msg.Payload)
+	}
+	if got := s.hub.Metrics().ChecksumFailures; got != 1 {
+		t.Errorf("ChecksumFailures = %d, want 1", got)
+	}
+
+	// The intact frame goes through as the document's first delta
+	ws.WriteMessage(gorilla.BinaryMessage, delta)
+	if ack := readMessage(t, ws, protocol.TypeAck); ack.Payload["seq"] != 1.0 {
+		t.Errorf("ack = %v, want seq 1", ack.Payload)
+	}
+}
+
+// encodeBatch frames messages as a batch envelope for the server
+func encodeBatch(t *testing.T, messages ...protocol.Message) []byte {
+	t.Helper()
+	data, err := protocol.EncodeBatch(messages, time.Now().UnixMilli())
+	if err != nil {
+		t.Fatalf("EncodeBatch failed: %v", err)
+	}
+	return data
+}
+
+func TestReadPump_HandlesBatchesInOrder(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{Limits: security.Limits{MaxMessagesPerMinute: 5}})
+	ws := dialWithToken(t, ts, tokenFor(t, "alice", auth.CreateUserPermissions([]string{"*"}, []string{"*"})), "198.51.100.30")
+
+	// The hub answers a batch's messages in order; a bad one gets an error
+	// of its own, and each counts against the rate limit
+	batch := encodeBatch(t,
+		protocol.Message{Type: protocol.TypeSubscribe, Payload: map[string]interface{}{"type": protocol.TypeSubscribe, "id": "s", "docId": "room:batch"}},
+		protocol.Message{Type: protocol.TypeDelta, Payload: map[string]interface{}{"type": protocol.TypeDelta, "id": "d", "docId": "room:batch", "changes": map[string]interface{}{"title": "Batched"}}},
+		protocol.Message{Type: protocol.TypeSyncResponse, Payload: map[string]interface{}{"type": protocol.TypeSyncResponse, "id": "x"}},
+		protocol.Message{Type: protocol.TypePing, Payload: map[string]interface{}{"type": protocol.TypePing, "id": "p1"}},
+		protocol.Message{Type: protocol.TypePing, Payload: map[string]interface{}{"type": protocol.TypePing, "id": "p2"}},
+		protocol.Message{Type: protocol.TypePing, Payload: map[string]interface{}{"type": protocol.TypePing, "id": "p3"}},
+	)
+	ws.WriteMessage(gorilla.BinaryMessage, batch)
+
+	// Errors from reading come straight back, so only the hub's replies
+	// are ordered relative to each other
+	var replies, codes []string
+	for len(replies)+len(codes) < 6 {
+		msg := readMessage(t, ws, "")
+		if msg.Type == protocol.TypeError {
+			codes = append(codes, msg.Payload["code"].(string))
+		} else {
+			replies = append(replies, msg.Type+" "+msg.Origin)
+		}
+	}
+	if want := []string{"sync_response s", "ack d", "pong p1", "pong p2"}; !reflect.DeepEqual(replies, want) {
+		t.Errorf("replies = %v, want %v", replies, want)
+	}
+	if want := []string{"INVALID_MESSAGE_TYPE", "RATE_LIMIT_EXCEEDED"}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("error codes = %v, want %v", codes, want)
+	}
+}
+
+func TestReadPump_BatchMessagesThatDoNotDecode(t *testing.T) {
+	ping, _ := protocol.EncodeMessage(protocol.TypePing, map[string]interface{}{"type": protocol.TypePing, "id": "p"}, 1)
+	corrupt := append([]byte(nil), ping...)
+	corrupt[len(corrupt)-1] = '!'
+	// A batch of a ping and a ping whose JSON is cut short
+	payload := binary.BigEndian.AppendUint32(nil, 2)
+	for _, msg := range [][]byte{ping, corrupt} {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(msg)))
+		payload = append(payload, msg...)
+	}
+	framed := make([]byte, 13, 13+len(payload))
+	framed[0] = byte(protocol.BATCH)
+	binary.BigEndian.PutUint32(framed[9:13], uint32(len(payload)))
+	framed = append(framed, payload...)
+
+	for _, strict := range []bool{false, true} {
+		ts := newServerWithConfig(t, &config.Config{StrictBatches: strict})
+		ws := dialClientFrom(t, ts, "alice", "")
+		ws.WriteMessage(gorilla.BinaryMessage, framed)
+
+		if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "INVALID_MESSAGE" {
+			t.Errorf("strict=%v: error payload = %v, want INVALID_MESSAGE", strict, msg.Payload)
+		}
+		// Strict mode handles none of the batch, so its first pong is this one's
+		ws.WriteMessage(gorilla.TextMessage, []byte(`{"type":"ping","id":"after"}`))
+		wantID := "p"
+		if strict {
+			wantID = "after"
+		}
+		if msg := readMessage(t, ws, protocol.TypePong); msg.Origin != wantID {
+			t.Errorf("strict=%v: first pong answers %q, want %q", strict, msg.Origin, wantID)
+		}
+	}
+}
+
+func TestHandleWebSocket_StrictOriginPolicy(t *testing.T) {
+	origins, err := security.NewOriginPolicy(security.OriginRules{
+		Mode:    security.OriginPolicyStrict,
+		Allowed: []string{"https://*.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("NewOriginPolicy failed: %v", err)
+	}
+	s := New(&config.Config{JWTSecret: testSecret, Origins: origins})
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		s.hub.Stop(ctx)
+		ts.Close()
+	})
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	tests := []struct {
+		origin string
+		status int
+	}{
+		{"https://app.example.com", http.StatusSwitchingProtocols},
+		{"https://evil.test", http.StatusForbidden},
+		{"", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		ws, resp, _ := gorilla.DefaultDialer.Dial(url, header)
+		if ws != nil {
+			ws.Close()
+		}
+		if resp == nil || resp.StatusCode != tt.status {
+			t.Errorf("origin %q: response = %v, want %d", tt.origin, resp, tt.status)
+		}
+	}
+
+	if got := s.hub.Metrics().OriginRejections; got != 2 {
+		t.Errorf("OriginRejections = %d, want 2", got)
+	}
+}
+
+// dialUnauthenticated opens a websocket from ip without sending auth
+func dialUnauthenticated(t *testing.T, ts *httptest.Server, ip string) *gorilla.Conn {
+	t.Helper()
+	header := http.Header{"X-Forwarded-For": []string{ip}}
+	ws, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func TestHandleWebSocket_LimitsUnauthenticatedConnectionsPerIP(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{
+		Limits: security.Limits{MaxConnectionsPerIP: 10, MaxUnauthenticatedPerIP: 2},
+	})
+	ip := "198.51.100.20"
+
+	dialUnauthenticated(t, ts, ip)
+	pending := dialUnauthenticated(t, ts, ip)
+	time.Sleep(50 * time.Millisecond) // Let the hub register both
+	expectUpgradeStatus(t, ts, ip, http.StatusTooManyRequests)
+
+	// Other IPs are unaffected
+	dialUnauthenticated(t, ts, "198.51.100.21")
+
+	// Authenticating frees the slot
+	data, _ := protocol.EncodeMessage(protocol.TypeAuth, map[string]interface{}{
+		"type":  protocol.TypeAuth,
+		"id":    "auth",
+		"token": tokenFor(t, "alice", auth.CreateUserPermissions([]string{"*"}, nil)),
+	}, time.Now().UnixMilli())
+	pending.WriteMessage(gorilla.BinaryMessage, data)
+	readMessage(t, pending, protocol.TypeAuthSuccess)
+	dialUnauthenticated(t, ts, ip)
+}
+
+func TestHandleWebSocket_ClosesConnectionsThatNeverAuthenticate(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{AuthTimeout: time.Second})
+	ws := dialUnauthenticated(t, ts, "198.51.100.30")
+
+	msg := readMessage(t, ws, protocol.TypeError)
+	if msg.Payload["code"] != "AUTH_TIMEOUT" {
+		t.Errorf("error code = %v, want AUTH_TIMEOUT", msg.Payload["code"])
+	}
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Error("Connection should be closed after AUTH_TIMEOUT")
+	}
+}
+
+func TestHandleWebSocket_NegotiatesPerMessageDeflate(t *testing.T) {
+	dialer := gorilla.Dialer{EnableCompression: true}
+	for _, enabled := range []bool{false, true} {
+		ts := newServerWithConfig(t, &config.Config{WebsocketCompression: enabled})
+		ws, resp, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer ws.Close()
+		if got := strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate"); got != enabled {
+			t.Errorf("WebsocketCompression=%v: permessage-deflate negotiated = %v", enabled, got)
+		}
+
+		sendMessage(t, ws, protocol.TypeAuth, map[string]interface{}{"id": "auth", "token": tokenFor(t, "alice", auth.CreateUserPermissions([]string{"*"}, []string{"*"}))})
+		readMessage(t, ws, protocol.TypeAuthSuccess)
+		writeDelta(t, ws, "room:deflate", "title", strings.Repeat("compressible ", 1000))
+	}
+}
+
+func scrapeMetrics(t *testing.T, ts *httptest.Server, token string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestMetrics_ExposesServerSeries(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{
+		MetricsEnabled: true,
+		MetricsToken:   "scrape-secret",
+		Limits:         security.Limits{MaxConnectionsPerIP: 1},
+	})
+
+	ws := dialClientFrom(t, ts, "alice", "10.0.0.1")
+	sendPing(ws)
+	readMessage(t, ws, protocol.TypePong)
+	expectUpgradeStatus(t, ts, "10.0.0.1", http.StatusTooManyRequests)
+
+	if status, _ := scrapeMetrics(t, ts, ""); status != http.StatusUnauthorized {
+		t.Errorf("scrape without token = %d, want 401", status)
+	}
+	if status, _ := scrapeMetrics(t, ts, "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("scrape with wrong token = %d, want 401", status)
+	}
+
+	status, body := scrapeMetrics(t, ts, "scrape-secret")
+	if status != http.StatusOK {
+		t.Fatalf("scrape = %d", status)
+	}
+	// Every sample carries the build version
+	v := `version="` + version.Version + `"`
+	for _, line := range []string{
+		"synckit_connections_active{" + v + "} 1",
+		"synckit_connections_per_ip_bucket{" + v + `,le="1"} 1`,
+		"synckit_messages_received_total{" + v + `,type="auth"} 1`,
+		"synckit_messages_received_total{" + v + `,type="ping"} 1`,
+		"synckit_messages_sent_total{" + v + `,type="pong"} 1`,
+		"synckit_rate_limit_rejections_total{" + v + `,limit="connections"} 1`,
+		"synckit_subscriptions{" + v + "} 0",
+		"synckit_auth_failures_total{" + v + "} 0",
+		"# TYPE synckit_message_handling_seconds histogram",
+		"# TYPE synckit_broadcast_fanout histogram",
+		"# TYPE go_goroutines gauge",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q", line)
+		}
+	}
+}
+
+func TestMetrics_DisabledByDefault(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{})
+	if _, body := scrapeMetrics(t, ts, ""); strings.Contains(body, "synckit_") {
+		t.Error("/metrics should not be served unless METRICS_ENABLED is set")
+	}
+}
+
+func TestVersion_EchoesBuildInfo(t *testing.T) {
+	defer func(v, c, b string) { version.Version, version.Commit, version.BuildTime = v, c, b }(version.Version, version.Commit, version.BuildTime)
+	version.Version, version.Commit, version.BuildTime = "9.8.7", "0123abcd", "2026-01-02T03:04:05Z"
+	ts := newServerWithConfig(t, &config.Config{MetricsEnabled: true})
+
+	get := func(path string) map[string]interface{} {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return body
+	}
+
+	info := get("/version")
+	if info["version"] != "9.8.7" || info["commit"] != "0123abcd" || info["buildTime"] != "2026-01-02T03:04:05Z" || info["goVersion"] == "" {
+		t.Errorf("/version = %v, want the injected build information", info)
+	}
+	if root := get("/"); root["version"] != "9.8.7" || root["commit"] != "0123abcd" {
+		t.Errorf("root = %v, want the injected version and commit", root)
+	}
+	if live := get("/health/live"); live["version"] != "9.8.7" {
+		t.Errorf("/health/live version = %v, want 9.8.7", live["version"])
+	}
+	if _, body := scrapeMetrics(t, ts, ""); !strings.Contains(body, `synckit_subscriptions{version="9.8.7"} 0`) {
+		t.Error("metrics are not labelled with the injected version")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for a logger writing from handler
+// goroutines while the test reads
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records decodes the JSON log lines written so far
+func (b *syncBuffer) records(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func captureLogs(s *Server) *syncBuffer {
+	buf := &syncBuffer{}
+	s.logger = slog.New(slog.NewJSONHandler(buf, nil))
+	return buf
+}
+
+func TestLogRequests_AssignsRequestIDAndLogsOutcome(t *testing.T) {
+	s, ts := newTestServer(t)
+	logs := captureLogs(s)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/admin/connections", nil)
+	req.Header.Set(RequestIDHeader, "req-from-proxy")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get(RequestIDHeader); got != "req-from-proxy" {
+		t.Errorf("%s = %q, want the incoming ID", RequestIDHeader, got)
+	}
+
+	resp, err = http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	generated := resp.Header.Get(RequestIDHeader)
+	if generated == "" {
+		t.Fatalf("no %s on response", RequestIDHeader)
+	}
+
+	var sawAdmin, sawHealth bool
+	for _, record := range logs.records(t) {
+		if record["msg"] != "HTTP request" {
+			continue
+		}
+		if _, ok := record["duration_ms"].(float64); !ok {
+			t.Errorf("record without duration_ms: %v", record)
+		}
+		switch record["path"] {
+		case "/admin/connections":
+			sawAdmin = record["request_id"] == "req-from-proxy" && record["method"] == "GET" && record["status"] == float64(401)
+		case "/health":
+			sawHealth = record["request_id"] == generated && record["status"] == float64(200)
+		}
+	}
+	if !sawAdmin || !sawHealth {
+		t.Errorf("missing request records (admin %v, health %v) in %v", sawAdmin, sawHealth, logs.records(t))
+	}
+}
+
+func TestLogRequests_ConnectionLogsCarryRequestID(t *testing.T) {
+	s, ts := newTestServer(t)
+	logs := captureLogs(s)
+
+	dialClientFrom(t, ts, "alice", "10.0.0.7")
+
+	var upgrade, authed map[string]interface{}
+	for _, record := range logs.records(t) {
+		switch record["msg"] {
+		case "HTTP request":
+			if record["path"] == "/ws" {
+				upgrade = record
+			}
+		case "Authenticated":
+			authed = record
+		}
+	}
+	if upgrade == nil || upgrade["status"] != float64(http.StatusSwitchingProtocols) {
+		t.Fatalf("upgrade record = %v, want status 101", upgrade)
+	}
+	if authed == nil || authed["request_id"] != upgrade["request_id"] || authed["user_id"] != "alice" ||
+		authed["ip"] != "10.0.0.7" || authed["conn_id"] == nil {
+		t.Errorf("auth record = %v, want request_id %v, user_id, ip and conn_id", authed, upgrade["request_id"])
+	}
+}
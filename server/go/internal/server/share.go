@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+)
+
+// defaultShareLifetime is how long share links last when the request does
+// not say. They may last up to maxTokenLifetime, so revocations outlive them.
+const defaultShareLifetime = 24 * time.Hour
+
+// sharesEnabled reports whether share links can be used: they are HS256
+// tokens, which clients' tokens are only verified as with JWT_ALGORITHM
+// HS256
+func (s *Server) sharesEnabled() bool {
+	return s.config.JWTAlgorithm == "" || s.config.JWTAlgorithm == auth.AlgorithmHS256
+}
+
+// handleDocumentShare serves POST /api/documents/{id}/share, which creates
+// a link giving whoever holds it read or write access to the document for
+// {"expiresInHours"}, and DELETE, which revokes the share {"token"}. Only
+// the document's admins and server admins may use them.
+func (s *Server) handleDocumentShare(w http.ResponseWriter, r *http.Request, docID string) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	if valid, errMsg := security.ValidateDocumentID(docID); !valid {
+		writeError(w, http.StatusBadRequest, errMsg, "INVALID_DOCUMENT_ID")
+		return
+	}
+	if !s.sharesEnabled() {
+		writeError(w, http.StatusNotFound, "Share links need JWT_ALGORITHM=HS256", "NOT_FOUND")
+		return
+	}
+
+	payload := tokenPayload(r)
+	if !s.acl.CanManage(r.Context(), payload, docID) {
+		s.audit.Log(audit.Event{
+			Type:       audit.EventPermissionDenied,
+			Actor:      payload.UserID,
+			DocumentID: docID,
+			IP:         s.getClientIP(r),
+			Details:    map[string]interface{}{"action": "share", "code": "PERMISSION_DENIED"},
+		})
+		writeError(w, http.StatusForbidden, "Permission denied", "PERMISSION_DENIED")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		s.revokeShare(w, r, docID)
+		return
+	}
+
+	var body struct {
+		Access         string   `json:"access"`
+		ExpiresInHours *float64 `json:"expiresInHours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeBodyError(w, err, "Invalid share request")
+		return
+	}
+	if body.Access == "" {
+		body.Access = "read"
+	}
+	if body.Access != "read" && body.Access != "write" {
+		writeError(w, http.StatusBadRequest, "access must be read or write", "INVALID_REQUEST")
+		return
+	}
+	lifetime := defaultShareLifetime
+	if body.ExpiresInHours != nil {
+		lifetime = time.Duration(*body.ExpiresInHours * float64(time.Hour))
+	}
+	if lifetime <= 0 || lifetime > maxTokenLifetime {
+		writeError(w, http.StatusBadRequest, "expiresInHours must be positive and at most "+maxTokenLifetime.String(), "INVALID_REQUEST")
+		return
+	}
+
+	token, share, err := auth.IssueTenantShareToken(docID, body.Access == "write", payload.TenantID, s.config.JWTSecret, lifetime, claimRules(s.config))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to issue share token", "doc_id", docID, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create share link", "INTERNAL_ERROR")
+		return
+	}
+	s.auditShare(r, docID, "create", map[string]interface{}{"jti": share.ID, "access": body.Access, "expiresAt": share.ExpiresAt.Time})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":         share.ID,
+		"documentId": docID,
+		"access":     body.Access,
+		"token":      token,
+		"url":        "/share/" + token,
+		"expiresAt":  share.ExpiresAt.Time,
+	})
+}
+
+// revokeShare denies a share token of docID and the access tokens
+// exchanged for it, and disconnects the connections using them
+func (s *Server) revokeShare(w http.ResponseWriter, r *http.Request, docID string) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		writeBodyError(w, err, "token is required")
+		return
+	}
+	share, err := auth.VerifyShareToken(body.Token, s.config.JWTSecret, claimRules(s.config))
+	if err != nil || auth.SharedDocument(share) != docID {
+		writeError(w, http.StatusBadRequest, "Not a valid share token for this document", "INVALID_REQUEST")
+		return
+	}
+
+	if err := s.revocations.RevokeToken(r.Context(), share.ID, share.ExpiresAt.Time); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to revoke share token", "doc_id", docID, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to revoke share link", "INTERNAL_ERROR")
+		return
+	}
+	disconnected := s.hub.DisconnectToken(share.ID, "Share link revoked")
+	s.auditShare(r, docID, "revoke", map[string]interface{}{"jti": share.ID, "disconnected": disconnected})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"revoked": true, "disconnected": disconnected})
+}
+
+// handleShareExchange serves GET /share/{token}, exchanging a share token
+// for an access token to the shared document. It expires with the share and
+// is revoked along with it.
+func (s *Server) handleShareExchange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+	if !s.sharesEnabled() {
+		writeError(w, http.StatusNotFound, "Not found", "NOT_FOUND")
+		return
+	}
+
+	reject := func(reason, message, code string) {
+		s.audit.Log(audit.Event{
+			Type:    audit.EventAuthFailure,
+			IP:      s.getClientIP(r),
+			Details: map[string]interface{}{"path": "/share", "reason": reason},
+		})
+		writeError(w, http.StatusUnauthorized, message, code)
+	}
+	token := strings.TrimPrefix(r.URL.Path, "/share/")
+	share, err := s.verifyToken(r.Context(), token)
+	if errors.Is(err, errTokenRevoked) {
+		reject("revoked", "Share link has been revoked", "TOKEN_REVOKED")
+		return
+	}
+	if err != nil || share.Type != auth.TokenTypeShare {
+		reject("invalid", "Invalid or expired share link", "NOT_AUTHENTICATED")
+		return
+	}
+
+	accessToken, err := auth.IssueShareAccessToken(share, s.config.JWTSecret, claimRules(s.config))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to issue tokens", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to issue tokens", "INTERNAL_ERROR")
+		return
+	}
+	access := "read"
+	if len(share.Permissions.CanWrite) > 0 {
+		access = "write"
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"userId":      share.UserID,
+		"documentId":  auth.SharedDocument(share),
+		"access":      access,
+		"accessToken": accessToken,
+		"expiresAt":   share.ExpiresAt.Time,
+	})
+}
+
+func (s *Server) auditShare(r *http.Request, docID, action string, details map[string]interface{}) {
+	details["action"] = action
+	s.audit.Log(audit.Event{
+		Type:       audit.EventDocumentShare,
+		Actor:      adminID(r),
+		DocumentID: docID,
+		IP:         s.getClientIP(r),
+		Details:    details,
+	})
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// createShare has the owner of room:shared, who creates it, share it
+func createShare(t *testing.T, ts *httptest.Server, access string) (owner, token string) {
+	t.Helper()
+	owner = tokenFor(t, "owner", auth.CreateUserPermissions([]string{"room:shared"}, []string{"room:shared"}))
+	writeDelta(t, dialWithToken(t, ts, owner, ""), "room:shared", "title", "Shared")
+
+	resp, body := adminRequest(t, ts, http.MethodPost, "/api/documents/room:shared/share", owner, map[string]interface{}{"access": access, "expiresInHours": 2})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("share = %d %v, want 200", resp.StatusCode, body)
+	}
+	token, _ = body["token"].(string)
+	if body["url"] != "/share/"+token || body["documentId"] != "room:shared" {
+		t.Fatalf("share = %v, want a link to room:shared", body)
+	}
+	return owner, token
+}
+
+// dialRejected authenticates a websocket with token and returns the
+// auth_error code
+func dialRejected(t *testing.T, ts *httptest.Server, token string) interface{} {
+	t.Helper()
+	ws, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer ws.Close()
+	sendMessage(t, ws, protocol.TypeAuth, map[string]interface{}{"id": "auth", "token": token})
+	return readMessage(t, ws, protocol.TypeAuthError).Payload["code"]
+}
+
+func TestShareLinks_ExchangeForAccessToOneDocument(t *testing.T) {
+	_, ts := newTestServer(t)
+	bob := tokenFor(t, "bob", auth.CreateUserPermissions([]string{"*"}, []string{"*"}))
+	if resp, _ := adminRequest(t, ts, http.MethodPost, "/api/documents/room:shared/share", bob, map[string]interface{}{}); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("share by a non-owner = %d, want 403", resp.StatusCode)
+	}
+	owner, share := createShare(t, ts, "write")
+
+	resp, body := adminRequest(t, ts, http.MethodGet, "/share/"+share, "", nil)
+	if resp.StatusCode != http.StatusOK || body["documentId"] != "room:shared" || body["access"] != "write" {
+		t.Fatalf("exchange = %d %v, want write access to room:shared", resp.StatusCode, body)
+	}
+	accessToken, _ := body["accessToken"].(string)
+
+	ws := dialWithToken(t, ts, accessToken, "")
+	subscribe(ws, "room:shared")
+	if msg := readMessage(t, ws, protocol.TypeSyncResponse); msg.Payload["state"] == nil {
+		t.Errorf("sync_response = %v, want the shared document", msg.Payload)
+	}
+	writeDelta(t, ws, "room:shared", "note", "from the link")
+
+	// The link is for room:shared only
+	subscribe(ws, "room:other")
+	if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "PERMISSION_DENIED" {
+		t.Errorf("subscribe to another document = %v, want PERMISSION_DENIED", msg.Payload)
+	}
+	// The share token itself authenticates websockets too
+	direct := dialWithToken(t, ts, share, "")
+	subscribe(direct, "room:shared")
+	readMessage(t, direct, protocol.TypeSyncResponse)
+
+	// Only share tokens are exchanged
+	if resp, body := adminRequest(t, ts, http.MethodGet, "/share/"+owner, "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("exchanging an access token = %d %v, want 401", resp.StatusCode, body)
+	}
+
+	for _, req := range []map[string]interface{}{
+		{"access": "admin"},
+		{"expiresInHours": 0},
+		{"expiresInHours": 24 * 8},
+	} {
+		if resp, _ := adminRequest(t, ts, http.MethodPost, "/api/documents/room:shared/share", owner, req); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("share %v = %d, want 400", req, resp.StatusCode)
+		}
+	}
+}
+
+func TestShareLinks_Expire(t *testing.T) {
+	_, ts := newTestServer(t)
+	expired, _, err := auth.IssueShareToken("room:shared", true, testSecret, -time.Minute, auth.ClaimRules{})
+	if err != nil {
+		t.Fatalf("IssueShareToken failed: %v", err)
+	}
+	if resp, body := adminRequest(t, ts, http.MethodGet, "/share/"+expired, "", nil); resp.StatusCode != http.StatusUnauthorized || body["code"] != "NOT_AUTHENTICATED" {
+		t.Errorf("exchanging an expired share = %d %v, want 401 NOT_AUTHENTICATED", resp.StatusCode, body)
+	}
+	if code := dialRejected(t, ts, expired); code != "INVALID_TOKEN" {
+		t.Errorf("auth with an expired share = %v, want INVALID_TOKEN", code)
+	}
+}
+
+func TestShareLinks_Revoke(t *testing.T) {
+	_, ts := newTestServer(t)
+	owner, share := createShare(t, ts, "read")
+	_, body := adminRequest(t, ts, http.MethodGet, "/share/"+share, "", nil)
+	accessToken, _ := body["accessToken"].(string)
+	ws := dialWithToken(t, ts, accessToken, "")
+
+	// A share of another document is not revoked through this one
+	other, _, _ := auth.IssueShareToken("room:other", false, testSecret, time.Hour, auth.ClaimRules{})
+	if resp, _ := adminRequest(t, ts, http.MethodDelete, "/api/documents/room:shared/share", owner, map[string]string{"token": other}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("revoking another document's share = %d, want 400", resp.StatusCode)
+	}
+
+	resp, body := adminRequest(t, ts, http.MethodDelete, "/api/documents/room:shared/share", owner, map[string]string{"token": share})
+	if resp.StatusCode != http.StatusOK || body["disconnected"] != float64(1) {
+		t.Fatalf("revoke = %d %v, want one connection disconnected", resp.StatusCode, body)
+	}
+	// Access tokens exchanged for the share go with it
+	if msg := readMessage(t, ws, protocol.TypeError); msg.Payload["code"] != "TOKEN_REVOKED" {
+		t.Errorf("holder got %v, want TOKEN_REVOKED", msg.Payload)
+	}
+	if resp, body := adminRequest(t, ts, http.MethodGet, "/share/"+share, "", nil); resp.StatusCode != http.StatusUnauthorized || body["code"] != "TOKEN_REVOKED" {
+		t.Errorf("exchange after revoke = %d %v, want 401 TOKEN_REVOKED", resp.StatusCode, body)
+	}
+	for _, token := range []string{share, accessToken} {
+		if code := dialRejected(t, ts, token); code != "TOKEN_REVOKED" {
+			t.Errorf("auth after revoke = %v, want TOKEN_REVOKED", code)
+		}
+	}
+}
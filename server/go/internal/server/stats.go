@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/logging"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// handleStats serves GET /stats: hub statistics, plus storage and broker
+// (Redis or NATS) statistics and the servers sharing Redis when those are configured
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED")
+		return
+	}
+
+	response := map[string]interface{}{
+		"timestamp":     time.Now().UnixMilli(),
+		"uptimeSeconds": int64(time.Since(s.startedAt).Seconds()),
+		"hub":           s.hub.Stats(),
+		"degraded":      s.hub.Degraded(),
+	}
+	if s.storage != nil {
+		stats := map[string]interface{}{"connected": s.storage.IsConnected()}
+		if statter, ok := s.storage.(storage.PoolStatter); ok {
+			if pool, ok := statter.PoolStats(); ok {
+				stats["pool"] = pool
+			}
+		}
+		response["storage"] = stats
+	}
+	if s.pubsub != nil {
+		response["redis"] = s.pubsub.GetStats()
+	} else if s.broker != nil {
+		response["nats"] = s.broker.GetStats()
+	}
+	if s.registry != nil {
+		cluster := map[string]interface{}{"serverId": s.hub.ServerID()}
+		if servers, err := s.registry.ListServers(r.Context()); err == nil {
+			cluster["servers"] = servers
+		} else {
+			logging.FromContext(r.Context()).Warn("Listing servers failed", "err", err)
+		}
+		response["cluster"] = cluster
+	}
+	writeJSON(w, http.StatusOK, response)
+}
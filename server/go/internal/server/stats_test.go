@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/Dancode-188/synckit/server/go/internal/version"
+)
+
+func TestStats_MergesHubStorageAndRedis(t *testing.T) {
+	s, ts := newTestServer(t)
+
+	status, body := healthGet(t, ts, "/stats")
+	hub, _ := body["hub"].(map[string]interface{})
+	if status != http.StatusOK || hub == nil {
+		t.Fatalf("GET /stats = %d %v, want 200 with hub stats", status, body)
+	}
+	for _, key := range []string{"connections", "authenticatedConnections", "documents", "subscriptions", "messagesHandled", "broadcastsSent", "sendsDropped"} {
+		if _, ok := hub[key].(float64); !ok {
+			t.Errorf("hub stats have no %s: %v", key, hub)
+		}
+	}
+	if _, ok := body["timestamp"].(float64); !ok {
+		t.Errorf("GET /stats has no timestamp: %v", body)
+	}
+	if body["storage"] != nil || body["redis"] != nil {
+		t.Errorf("GET /stats reports unconfigured dependencies: %v", body)
+	}
+	handledBefore := hub["messagesHandled"].(float64)
+
+	dialClient(t, ts, "alice")
+	s.storage = &storage.PostgresAdapter{}
+	mr := miniredis.RunT(t)
+	pubsub, err := storage.NewRedisPubSub(&storage.RedisPubSubConfig{URL: "redis://" + mr.Addr(), ChannelPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("NewRedisPubSub failed: %v", err)
+	}
+	s.pubsub = pubsub
+
+	_, body = healthGet(t, ts, "/stats")
+	hub, _ = body["hub"].(map[string]interface{})
+	if hub["connections"] != float64(1) || hub["authenticatedConnections"] != float64(1) || hub["messagesHandled"].(float64) <= handledBefore {
+		t.Errorf("hub stats after a client authenticated = %v", hub)
+	}
+	if st, _ := body["storage"].(map[string]interface{}); st == nil || st["connected"] != false {
+		t.Errorf("storage stats = %v, want a disconnected adapter", body["storage"])
+	}
+	if rs, _ := body["redis"].(map[string]interface{}); rs == nil || rs["connected"] != false || rs["subscribedChannels"] != float64(0) {
+		t.Errorf("redis stats = %v, want a disconnected pub/sub", body["redis"])
+	}
+}
+
+func TestStats_AuthRequired(t *testing.T) {
+	ts := newServerWithConfig(t, &config.Config{JWTSecret: testSecret, StatsAuthRequired: true})
+
+	if status, _ := healthGet(t, ts, "/stats"); status != http.StatusUnauthorized {
+		t.Errorf("anonymous GET /stats = %d, want %d", status, http.StatusUnauthorized)
+	}
+	resp, _ := adminRequest(t, ts, http.MethodGet, "/stats", adminToken(t), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("authenticated GET /stats = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestStats_ListsServersSharingRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := &config.Config{JWTSecret: testSecret, RedisURL: "redis://" + mr.Addr(), RedisChannelPrefix: "test"}
+	a, b := New(cfg), New(cfg)
+	defer a.Shutdown(context.Background())
+	ts := httptest.NewServer(a.routes())
+	defer ts.Close()
+
+	serverIDs := func() []string {
+		_, body := healthGet(t, ts, "/stats")
+		cluster, _ := body["cluster"].(map[string]interface{})
+		if cluster["serverId"] != a.hub.ServerID() {
+			t.Errorf("cluster serverId = %v, want %s", cluster["serverId"], a.hub.ServerID())
+		}
+		servers, _ := cluster["servers"].([]interface{})
+		ids := make([]string, 0, len(servers))
+		for _, raw := range servers {
+			server, _ := raw.(map[string]interface{})
+			if meta, _ := server["metadata"].(map[string]interface{}); meta["version"] != version.Version {
+				t.Errorf("server metadata = %v, want the version", server["metadata"])
+			}
+			id, _ := server["id"].(string)
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	want := []string{a.hub.ServerID(), b.hub.ServerID()}
+	sort.Strings(want)
+	if got := serverIDs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("servers = %v, want %v", got, want)
+	}
+
+	b.Shutdown(context.Background())
+	if got := serverIDs(); !reflect.DeepEqual(got, []string{a.hub.ServerID()}) {
+		t.Errorf("servers after shutdown = %v, want only %s", got, a.hub.ServerID())
+	}
+}
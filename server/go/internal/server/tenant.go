@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+)
+
+// tenantHeader names the tenant an admin's request acts for
+const tenantHeader = "X-Tenant-ID"
+
+// scopeTenant applies tenancy to an authenticated request: an admin may act
+// for the tenant named by the X-Tenant-ID header, and with MULTI_TENANCY
+// everyone else needs a token with a tenant. It returns the token the
+// request acts with, or writes the refusal and returns nil.
+func (s *Server) scopeTenant(w http.ResponseWriter, r *http.Request, payload *auth.TokenPayload) *auth.TokenPayload {
+	scoped, err := auth.OverrideTenant(payload, r.Header.Get(tenantHeader))
+	switch {
+	case errors.Is(err, auth.ErrInvalidTenant):
+		writeError(w, http.StatusBadRequest, err.Error(), "INVALID_REQUEST")
+		return nil
+	case err != nil:
+		s.refuseTenant(w, r, payload, "TENANT_OVERRIDE_DENIED", "Only admins may act for another tenant")
+		return nil
+	}
+	if s.config.MultiTenancy && auth.RequireTenant(scoped) != nil {
+		s.refuseTenant(w, r, payload, "TENANT_REQUIRED", "A token with a tenant is required")
+		return nil
+	}
+	return scoped
+}
+
+func (s *Server) refuseTenant(w http.ResponseWriter, r *http.Request, payload *auth.TokenPayload, code, message string) {
+	s.audit.Log(audit.Event{
+		Type:    audit.EventPermissionDenied,
+		Actor:   payload.UserID,
+		IP:      s.getClientIP(r),
+		Details: map[string]interface{}{"path": r.URL.Path, "code": code},
+	})
+	writeError(w, http.StatusForbidden, message, code)
+}
+
+// tenantDocuments keeps the documents of a token's tenant, or all of them
+// for a token without one
+func tenantDocuments(payload *auth.TokenPayload, docIDs []string) []string {
+	if payload.TenantID == "" {
+		return docIDs
+	}
+	kept := docIDs[:0]
+	for _, docID := range docIDs {
+		if auth.InTenant(payload.TenantID, docID) {
+			kept = append(kept, docID)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+)
+
+func TestMultiTenancy_ScopesRESTRequests(t *testing.T) {
+	public, err := security.NewPublicDocumentPolicy(security.PublicDocumentRules{Mode: security.PolicyModeAllowAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newServerWithConfig(t, &config.Config{MultiTenancy: true, OpenDocumentCreation: true, PublicDocuments: public})
+	tenantToken := func(tenant string) string {
+		token, err := auth.IssueTenantAccessToken("alice", "", tenant, auth.CreateUserPermissions(nil, nil), testSecret, time.Hour, auth.ClaimRules{})
+		if err != nil {
+			t.Fatalf("IssueTenantAccessToken failed: %v", err)
+		}
+		return token
+	}
+	acme, globex := tenantToken("acme"), tenantToken("globex")
+
+	// The same user creates a document in each tenant
+	ws := dialWithToken(t, ts, acme, "")
+	writeDelta(t, ws, "acme:notes", "title", "Acme")
+	ws.Close()
+	ws = dialWithToken(t, ts, globex, "")
+	writeDelta(t, ws, "globex:notes", "title", "Globex")
+	ws.Close()
+
+	_, body := adminRequest(t, ts, http.MethodGet, "/api/documents?owner=me", acme, nil)
+	if ids, _ := body["documentIds"].([]interface{}); len(ids) != 1 || ids[0] != "acme:notes" {
+		t.Errorf("acme's documents = %v, want only acme:notes", body)
+	}
+	if resp, _ := adminRequest(t, ts, http.MethodGet, "/api/documents/globex:notes/permissions", acme, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("acme reading globex's grants = %d, want 403", resp.StatusCode)
+	}
+
+	// Tokens without a tenant are refused, save admins'
+	resp, body := adminRequest(t, ts, http.MethodGet, "/api/documents?owner=me", tokenFor(t, "bob", auth.CreateUserPermissions(nil, nil)), nil)
+	if resp.StatusCode != http.StatusForbidden || body["code"] != "TENANT_REQUIRED" {
+		t.Errorf("tenantless token = %d %v, want 403 TENANT_REQUIRED", resp.StatusCode, body)
+	}
+
+	withTenant := func(token, tenant string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/documents?owner=alice", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(tenantHeader, tenant)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	// An admin acting for globex sees its documents only
+	status, body := withTenant(adminToken(t), "globex")
+	if ids, _ := body["documentIds"].([]interface{}); status != http.StatusOK || len(ids) != 1 || ids[0] != "globex:notes" {
+		t.Errorf("admin acting for globex = %d %v, want only globex:notes", status, body)
+	}
+	if status, body := withTenant(acme, "globex"); status != http.StatusForbidden || body["code"] != "TENANT_OVERRIDE_DENIED" {
+		t.Errorf("user acting for globex = %d %v, want 403 TENANT_OVERRIDE_DENIED", status, body)
+	}
+	if status, _ := withTenant(adminToken(t), "a b"); status != http.StatusBadRequest {
+		t.Errorf("invalid tenant header = %d, want 400", status)
+	}
+}
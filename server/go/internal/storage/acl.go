@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DocumentGrantEntry gives a principal (a user ID) a role on a document
+type DocumentGrantEntry struct {
+	DocumentID string    `json:"documentId"`
+	Principal  string    `json:"principal"`
+	Role       string    `json:"role"`             // read, write or admin
+	Fields     []string  `json:"fields,omitempty"` // Field patterns a write grant is limited to, nil for every field
+	GrantedBy  string    `json:"grantedBy,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// DocumentACLStorage is implemented by adapters that can keep document
+// access grants and owners
+type DocumentACLStorage interface {
+	// SaveDocumentGrant adds a grant or replaces the principal's role and
+	// fields
+	SaveDocumentGrant(ctx context.Context, grant *DocumentGrantEntry) error
+	// DeleteDocumentGrant reports false if the principal had no grant
+	DeleteDocumentGrant(ctx context.Context, documentID, principal string) (bool, error)
+	ListDocumentGrants(ctx context.Context, documentID string) ([]*DocumentGrantEntry, error)
+	ListPrincipalGrants(ctx context.Context, principal string) ([]*DocumentGrantEntry, error)
+
+	// ClaimDocument records ownerID as the document's owner unless it
+	// already has one, and returns the owner
+	ClaimDocument(ctx context.Context, documentID, ownerID string) (string, error)
+	// GetDocumentOwner returns "" for documents without an owner
+	GetDocumentOwner(ctx context.Context, documentID string) (string, error)
+	ListOwnedDocuments(ctx context.Context, ownerID string) ([]string, error)
+}
+
+// SaveDocumentGrant stores a grant, replacing any earlier role and fields
+func (p *PostgresAdapter) SaveDocumentGrant(ctx context.Context, grant *DocumentGrantEntry) error {
+	if !p.IsConnected() {
+		return ErrNotConnected
+	}
+
+	query := `
+		INSERT INTO document_acl (document_id, principal, role, fields, granted_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (document_id, principal)
+		DO UPDATE SET role = EXCLUDED.role, fields = EXCLUDED.fields, granted_by = EXCLUDED.granted_by, created_at = NOW()
+		RETURNING created_at
+	`
+	row := p.pool.QueryRow(ctx, query, grant.DocumentID, grant.Principal, grant.Role, grant.Fields, grant.GrantedBy)
+	if err := row.Scan(&grant.CreatedAt); err != nil {
+		return NewQueryError("failed to save document grant", err)
+	}
+	return nil
+}
+
+// DeleteDocumentGrant removes a principal's grant on a document
+func (p *PostgresAdapter) DeleteDocumentGrant(ctx context.Context, documentID, principal string) (bool, error) {
+	if !p.IsConnected() {
+		return false, ErrNotConnected
+	}
+
+	result, err := p.pool.Exec(ctx, `DELETE FROM document_acl WHERE document_id = $1 AND principal = $2`, documentID, principal)
+	if err != nil {
+		return false, NewQueryError("failed to delete document grant", err)
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// ListDocumentGrants returns a document's grants, oldest first
+func (p *PostgresAdapter) ListDocumentGrants(ctx context.Context, documentID string) ([]*DocumentGrantEntry, error) {
+	return p.queryDocumentGrants(ctx, `
+		SELECT document_id, principal, role, fields, granted_by, created_at
+		FROM document_acl
+		WHERE document_id = $1
+		ORDER BY created_at, principal
+	`, documentID)
+}
+
+// ListPrincipalGrants returns every grant a principal holds
+func (p *PostgresAdapter) ListPrincipalGrants(ctx context.Context, principal string) ([]*DocumentGrantEntry, error) {
+	return p.queryDocumentGrants(ctx, `
+		SELECT document_id, principal, role, fields, granted_by, created_at
+		FROM document_acl
+		WHERE principal = $1
+		ORDER BY document_id
+	`, principal)
+}
+
+func (p *PostgresAdapter) queryDocumentGrants(ctx context.Context, query string, arg string) ([]*DocumentGrantEntry, error) {
+	if !p.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	rows, err := p.pool.Query(ctx, query, arg)
+	if err != nil {
+		return nil, NewQueryError("failed to list document grants", err)
+	}
+	defer rows.Close()
+
+	var grants []*DocumentGrantEntry
+	for rows.Next() {
+		grant, err := scanDocumentGrant(rows)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, nil
+}
+
+// ClaimDocument sets a document's owner if it has none. A document not
+// stored yet is stored empty, so the claim outlives the first write.
+func (p *PostgresAdapter) ClaimDocument(ctx context.Context, documentID, ownerID string) (string, error) {
+	if !p.IsConnected() {
+		return "", ErrNotConnected
+	}
+
+	query := `
+		INSERT INTO documents (id, owner_id)
+		VALUES ($1, $2)
+		ON CONFLICT (id)
+		DO UPDATE SET owner_id = COALESCE(documents.owner_id, EXCLUDED.owner_id)
+		RETURNING owner_id
+	`
+	var owner string
+	if err := p.pool.QueryRow(ctx, query, documentID, ownerID).Scan(&owner); err != nil {
+		return "", NewQueryError("failed to claim document", err)
+	}
+	return owner, nil
+}
+
+// GetDocumentOwner returns a document's owner
+func (p *PostgresAdapter) GetDocumentOwner(ctx context.Context, documentID string) (string, error) {
+	if !p.IsConnected() {
+		return "", ErrNotConnected
+	}
+
+	var owner *string
+	err := p.pool.QueryRow(ctx, `SELECT owner_id FROM documents WHERE id = $1`, documentID).Scan(&owner)
+	if err == pgx.ErrNoRows || (err == nil && owner == nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", NewQueryError("failed to get document owner", err)
+	}
+	return *owner, nil
+}
+
+// ListOwnedDocuments returns the IDs of the documents ownerID owns
+func (p *PostgresAdapter) ListOwnedDocuments(ctx context.Context, ownerID string) ([]string, error) {
+	if !p.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	rows, err := p.pool.Query(ctx, `SELECT id FROM documents WHERE owner_id = $1 ORDER BY id`, ownerID)
+	if err != nil {
+		return nil, NewQueryError("failed to list owned documents", err)
+	}
+	defer rows.Close()
+
+	var docIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, NewQueryError("failed to scan owned document", err)
+		}
+		docIDs = append(docIDs, id)
+	}
+	return docIDs, nil
+}
+
+// scanDocumentGrant scans a document_acl row
+func scanDocumentGrant(row pgx.Row) (*DocumentGrantEntry, error) {
+	var grant DocumentGrantEntry
+	var grantedBy *string
+	if err := row.Scan(&grant.DocumentID, &grant.Principal, &grant.Role, &grant.Fields, &grantedBy, &grant.CreatedAt); err != nil {
+		return nil, NewQueryError("failed to scan document grant", err)
+	}
+	if grantedBy != nil {
+		grant.GrantedBy = *grantedBy
+	}
+	return &grant, nil
+}
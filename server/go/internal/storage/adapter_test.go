@@ -0,0 +1,36 @@
+package storage_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/metrics"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/Dancode-188/synckit/server/go/pkg/storagetest"
+)
+
+func TestMemoryAdapter_Contract(t *testing.T) {
+	storagetest.RunAdapterTests(t, func() storage.StorageAdapter {
+		return storage.NewMemoryAdapter()
+	})
+}
+
+func TestInstrumented_Contract(t *testing.T) {
+	storagetest.RunAdapterTests(t, func() storage.StorageAdapter {
+		return storage.Instrument(storage.NewMemoryAdapter(), metrics.NewRegistry())
+	})
+}
+
+// The Postgres run needs a database with the schema applied, e.g.
+// SYNCKIT_TEST_DATABASE_URL=postgres://localhost/synckit_test
+func TestPostgresAdapter_Contract(t *testing.T) {
+	url := os.Getenv("SYNCKIT_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("SYNCKIT_TEST_DATABASE_URL not set")
+	}
+	storagetest.RunAdapterTests(t, func() storage.StorageAdapter {
+		cfg := storage.DefaultStorageConfig()
+		cfg.ConnectionString = url
+		return storage.NewPostgresAdapter(cfg)
+	})
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// APIKeyEntry represents an API key created at runtime. Only the hash of
+// the key is stored.
+type APIKeyEntry struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Prefix      string                 `json:"prefix"`
+	KeyHash     string                 `json:"-"`
+	Permissions map[string]interface{} `json:"permissions"`
+	CreatedBy   string                 `json:"createdBy,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt"`
+	RevokedAt   *time.Time             `json:"revokedAt,omitempty"`
+}
+
+// APIKeyStorage is implemented by adapters that can keep API keys
+type APIKeyStorage interface {
+	SaveAPIKey(ctx context.Context, key *APIKeyEntry) (*APIKeyEntry, error)
+	ListAPIKeys(ctx context.Context) ([]*APIKeyEntry, error)
+	// RevokeAPIKey reports false if no unrevoked key has the ID
+	RevokeAPIKey(ctx context.Context, id string) (bool, error)
+	// GetAPIKeyByHash returns the key with the hash, revoked or not, or nil
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKeyEntry, error)
+}
+
+// SaveAPIKey stores a new API key
+func (p *PostgresAdapter) SaveAPIKey(ctx context.Context, key *APIKeyEntry) (*APIKeyEntry, error) {
+	if !p.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	permissionsJSON, err := json.Marshal(key.Permissions)
+	if err != nil {
+		return nil, NewQueryError("failed to marshal API key permissions", err)
+	}
+	createdAt := key.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO api_keys (id, name, prefix, key_hash, permissions, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at
+	`
+	row := p.pool.QueryRow(ctx, query, key.ID, key.Name, key.Prefix, key.KeyHash, permissionsJSON, key.CreatedBy, createdAt)
+	if err := row.Scan(&key.CreatedAt); err != nil {
+		return nil, NewQueryError("failed to save API key", err)
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns every stored API key, oldest first
+func (p *PostgresAdapter) ListAPIKeys(ctx context.Context) ([]*APIKeyEntry, error) {
+	if !p.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	query := `
+		SELECT id, name, prefix, key_hash, permissions, created_by, created_at, revoked_at
+		FROM api_keys
+		ORDER BY created_at, id
+	`
+	rows, err := p.pool.Query(ctx, query)
+	if err != nil {
+		return nil, NewQueryError("failed to list API keys", err)
+	}
+	defer rows.Close()
+
+	var keys []*APIKeyEntry
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey marks an API key revoked
+func (p *PostgresAdapter) RevokeAPIKey(ctx context.Context, id string) (bool, error) {
+	if !p.IsConnected() {
+		return false, ErrNotConnected
+	}
+
+	result, err := p.pool.Exec(ctx, `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, NewQueryError("failed to revoke API key", err)
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// GetAPIKeyByHash finds the API key with the given hash
+func (p *PostgresAdapter) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKeyEntry, error) {
+	if !p.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	query := `
+		SELECT id, name, prefix, key_hash, permissions, created_by, created_at, revoked_at
+		FROM api_keys
+		WHERE key_hash = $1
+	`
+	key, err := scanAPIKey(p.pool.QueryRow(ctx, query, keyHash))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// scanAPIKey scans an api_keys row. pgx.ErrNoRows is returned as is.
+func scanAPIKey(row pgx.Row) (*APIKeyEntry, error) {
+	var key APIKeyEntry
+	var permissionsJSON []byte
+	var createdBy *string
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, &permissionsJSON, &createdBy, &key.CreatedAt, &key.RevokedAt)
+	if err == pgx.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, NewQueryError("failed to scan API key", err)
+	}
+	if createdBy != nil {
+		key.CreatedBy = *createdBy
+	}
+	if permissionsJSON != nil {
+		if err := json.Unmarshal(permissionsJSON, &key.Permissions); err != nil {
+			return nil, NewQueryError("failed to unmarshal API key permissions", err)
+		}
+	}
+	return &key, nil
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultAwarenessTTL is how long a document's shared awareness outlives
+// its last update
+const DefaultAwarenessTTL = time.Minute
+
+// expireAwarenessScript removes the clients of a document last updated at
+// or before ARGV[1] and returns them. Running it atomically means each
+// entry is expired by exactly one server.
+var expireAwarenessScript = redis.NewScript(`
+local stale = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for _, client in ipairs(stale) do
+	redis.call('HDEL', KEYS[1], client)
+	redis.call('ZREM', KEYS[2], client)
+end
+return stale
+`)
+
+// RedisAwareness shares awareness states between servers. Each document
+// has a hash of client states and a sorted set of when each client was
+// last updated; both expire after TTL without updates.
+type RedisAwareness struct {
+	pubsub *RedisPubSub
+	ttl    time.Duration
+}
+
+// NewRedisAwareness creates a shared awareness store using pubsub's
+// connections. A ttl of zero means DefaultAwarenessTTL.
+func NewRedisAwareness(pubsub *RedisPubSub, ttl time.Duration) *RedisAwareness {
+	if ttl <= 0 {
+		ttl = DefaultAwarenessTTL
+	}
+	return &RedisAwareness{pubsub: pubsub, ttl: ttl}
+}
+
+// SetAwareness stores a client's state for a document
+func (a *RedisAwareness) SetAwareness(ctx context.Context, documentID, clientID string, state map[string]interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal awareness: %w", err)
+	}
+	states, seen := a.keys(documentID)
+	pipe := a.pubsub.publisher.TxPipeline()
+	pipe.HSet(ctx, states, clientID, data)
+	pipe.ZAdd(ctx, seen, redis.Z{Score: float64(time.Now().UnixMilli()), Member: clientID})
+	pipe.Expire(ctx, states, a.ttl)
+	pipe.Expire(ctx, seen, a.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store awareness: %w", err)
+	}
+	return nil
+}
+
+// RemoveAwareness deletes a client's state for a document
+func (a *RedisAwareness) RemoveAwareness(ctx context.Context, documentID, clientID string) error {
+	states, seen := a.keys(documentID)
+	pipe := a.pubsub.publisher.TxPipeline()
+	pipe.HDel(ctx, states, clientID)
+	pipe.ZRem(ctx, seen, clientID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to remove awareness: %w", err)
+	}
+	return nil
+}
+
+// GetAwareness returns the states of every client of a document, keyed by
+// client ID
+func (a *RedisAwareness) GetAwareness(ctx context.Context, documentID string) (map[string]map[string]interface{}, error) {
+	states, _ := a.keys(documentID)
+	values, err := a.pubsub.publisher.HGetAll(ctx, states).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read awareness: %w", err)
+	}
+	result := make(map[string]map[string]interface{}, len(values))
+	for clientID, data := range values {
+		var state map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &state); err == nil {
+			result[clientID] = state
+		}
+	}
+	return result, nil
+}
+
+// ExpireAwareness removes the clients of a document not updated since
+// cutoff and returns their IDs. Servers racing to expire the same clients
+// each get back only the ones they removed.
+func (a *RedisAwareness) ExpireAwareness(ctx context.Context, documentID string, cutoff time.Time) ([]string, error) {
+	states, seen := a.keys(documentID)
+	expired, err := expireAwarenessScript.Run(ctx, a.pubsub.publisher, []string{states, seen}, cutoff.UnixMilli()).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire awareness: %w", err)
+	}
+	return expired, nil
+}
+
+// keys returns a document's hash of states and set of update times. The
+// document ID is a hash tag, so in cluster mode both share a slot and can be
+// updated together.
+func (a *RedisAwareness) keys(documentID string) (states, seen string) {
+	base := a.pubsub.channelPrefix + "awareness:{" + documentID + "}"
+	return base, base + ":seen"
+}
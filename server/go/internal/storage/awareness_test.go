@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisAwareness_ExpiresEachClientOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	var stores []*RedisAwareness
+	for i := 0; i < 2; i++ {
+		pubsub, err := NewRedisPubSub(&RedisPubSubConfig{URL: "redis://" + mr.Addr(), ChannelPrefix: "test:"})
+		if err != nil {
+			t.Fatalf("NewRedisPubSub: %v", err)
+		}
+		t.Cleanup(func() { pubsub.Disconnect(context.Background()) })
+		stores = append(stores, NewRedisAwareness(pubsub, time.Minute))
+	}
+	a, b := stores[0], stores[1]
+
+	a.SetAwareness(ctx, "doc-1", "alice", map[string]interface{}{"cursor": 1})
+	b.SetAwareness(ctx, "doc-1", "bob", map[string]interface{}{"cursor": 2})
+	states, err := b.GetAwareness(ctx, "doc-1")
+	if err != nil {
+		t.Fatalf("GetAwareness: %v", err)
+	}
+	if len(states) != 2 || states["alice"]["cursor"] != float64(1) {
+		t.Errorf("states = %v, want alice's and bob's", states)
+	}
+	if ttl := mr.TTL("test:awareness:{doc-1}"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL = %v, want up to a minute", ttl)
+	}
+
+	if err := a.RemoveAwareness(ctx, "doc-1", "bob"); err != nil {
+		t.Fatalf("RemoveAwareness: %v", err)
+	}
+
+	// Both servers find alice stale; only one expires her
+	cutoff := time.Now().Add(time.Second)
+	first, err := a.ExpireAwareness(ctx, "doc-1", cutoff)
+	if err != nil {
+		t.Fatalf("ExpireAwareness: %v", err)
+	}
+	second, _ := b.ExpireAwareness(ctx, "doc-1", cutoff)
+	if len(first) != 1 || first[0] != "alice" || len(second) != 0 {
+		t.Errorf("expired %v then %v, want alice once", first, second)
+	}
+	if states, _ := a.GetAwareness(ctx, "doc-1"); len(states) != 0 {
+		t.Errorf("states after expiry = %v, want none", states)
+	}
+}
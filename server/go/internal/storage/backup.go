@@ -0,0 +1,516 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Backup archive format: JSON lines, starting with a header record and
+// ending with an end record that counts what came before it
+const (
+	BackupFormat  = "synckit-backup"
+	BackupVersion = 1
+)
+
+// Archive record types
+const (
+	recordHeader       = "header"
+	recordDocument     = "document"
+	recordTextDocument = "text_document"
+	recordDelta        = "delta"
+	recordSnapshot     = "snapshot"
+	recordEnd          = "end"
+)
+
+// Archiver is implemented by adapters Backup and Restore work with. It
+// lists documents in a stable order, so a backup taken while the server
+// writes sees each document once, and writes rows back with the IDs and
+// times they were backed up with.
+type Archiver interface {
+	// ListDocumentIDs returns up to limit document IDs greater than
+	// after, in order
+	ListDocumentIDs(ctx context.Context, after string, limit int) ([]string, error)
+
+	// RestoreDocument writes a document with its version and times and
+	// replaces its vector clock. An existing document is replaced when
+	// overwrite is set and otherwise left alone, reporting false. So are
+	// existing deltas and snapshots, by ID. A replaced document may take
+	// the version and update time the store gives any update, as the
+	// PostgreSQL schema's triggers do.
+	RestoreDocument(ctx context.Context, doc *DocumentState, clock map[string]int64, overwrite bool) (bool, error)
+	RestoreDelta(ctx context.Context, delta *DeltaEntry, overwrite bool) (bool, error)
+	RestoreSnapshot(ctx context.Context, snapshot *SnapshotEntry, overwrite bool) (bool, error)
+}
+
+// BackupHeader starts an archive
+type BackupHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BackupCounts counts the records of an archive, or the rows a restore
+// wrote or skipped
+type BackupCounts struct {
+	Documents     int `json:"documents"`
+	TextDocuments int `json:"textDocuments"`
+	Deltas        int `json:"deltas"`
+	Snapshots     int `json:"snapshots"`
+	Grants        int `json:"grants"`
+}
+
+// backupRecord is one line of an archive. Type says which fields are set.
+type backupRecord struct {
+	Type string `json:"type"`
+
+	Header *BackupHeader `json:"header,omitempty"`
+
+	// Documents and text documents carry their clock, owner and grants
+	Document     *DocumentState        `json:"document,omitempty"`
+	TextDocument *TextDocumentState    `json:"textDocument,omitempty"`
+	Version      int64                 `json:"version,omitempty"` // Of a text document
+	Clock        map[string]int64      `json:"clock,omitempty"`
+	Owner        string                `json:"owner,omitempty"`
+	Grants       []*DocumentGrantEntry `json:"grants,omitempty"`
+
+	Delta    *DeltaEntry    `json:"delta,omitempty"`
+	Snapshot *SnapshotEntry `json:"snapshot,omitempty"`
+
+	Counts *BackupCounts `json:"counts,omitempty"`
+}
+
+// Backup writes every document to w, with its vector clock, owner and
+// grants, deltas and snapshots, as a versioned JSON lines archive. Text
+// documents are written as such. It streams the archive, so writes made
+// while it runs may or may not be in it. Sessions, audit events and API
+// keys are not backed up.
+func Backup(ctx context.Context, adapter StorageAdapter, w io.Writer) (*BackupCounts, error) {
+	archiver, ok := adapter.(Archiver)
+	if !ok {
+		return nil, fmt.Errorf("backup: %w", ErrNotSupported)
+	}
+	acl, _ := adapter.(DocumentACLStorage)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	counts := &BackupCounts{}
+	err := enc.Encode(backupRecord{Type: recordHeader, Header: &BackupHeader{
+		Format:    BackupFormat,
+		Version:   BackupVersion,
+		CreatedAt: time.Now().UTC(),
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	after := ""
+	for {
+		ids, err := archiver.ListDocumentIDs(ctx, after, 100)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if err := backupDocument(ctx, adapter, acl, enc, id, counts); err != nil {
+				return nil, fmt.Errorf("backing up %s: %w", id, err)
+			}
+		}
+		if len(ids) < 100 {
+			break
+		}
+		after = ids[len(ids)-1]
+	}
+
+	if err := enc.Encode(backupRecord{Type: recordEnd, Counts: counts}); err != nil {
+		return nil, err
+	}
+	return counts, bw.Flush()
+}
+
+// backupDocument writes one document's records
+func backupDocument(ctx context.Context, adapter StorageAdapter, acl DocumentACLStorage, enc *json.Encoder, id string, counts *BackupCounts) error {
+	doc, err := adapter.GetDocument(ctx, id)
+	if err != nil || doc == nil {
+		return err // Deleted since it was listed
+	}
+	record := backupRecord{Type: recordDocument, Document: doc}
+	if doc.State["type"] == "text" {
+		text, err := adapter.GetTextDocument(ctx, id)
+		if err != nil {
+			return err
+		}
+		if text != nil {
+			record = backupRecord{Type: recordTextDocument, TextDocument: text, Version: doc.Version}
+		}
+	}
+	if record.Clock, err = adapter.GetVectorClock(ctx, id); err != nil {
+		return err
+	}
+	if acl != nil {
+		owner, err := acl.GetDocumentOwner(ctx, id)
+		if err != nil && !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+		record.Owner = owner
+		grants, err := acl.ListDocumentGrants(ctx, id)
+		if err != nil && !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+		record.Grants = grants
+	}
+	if err := enc.Encode(record); err != nil {
+		return err
+	}
+	if record.TextDocument != nil {
+		counts.TextDocuments++
+	} else {
+		counts.Documents++
+	}
+	counts.Grants += len(record.Grants)
+
+	deltaQuery := DeltaQuery{Limit: 500}
+	for {
+		deltas, err := adapter.QueryDeltas(ctx, id, deltaQuery)
+		if err != nil {
+			return err
+		}
+		for _, delta := range deltas {
+			if err := enc.Encode(backupRecord{Type: recordDelta, Delta: delta}); err != nil {
+				return err
+			}
+		}
+		counts.Deltas += len(deltas)
+		if len(deltas) < deltaQuery.Limit {
+			break
+		}
+		last := deltas[len(deltas)-1]
+		deltaQuery.After = &PageKey{Timestamp: last.Timestamp, ID: last.ID}
+	}
+
+	snapshotQuery := SnapshotQuery{Limit: 50}
+	for {
+		snapshots, err := adapter.QuerySnapshots(ctx, id, snapshotQuery)
+		if err != nil {
+			return err
+		}
+		for _, snapshot := range snapshots {
+			if err := enc.Encode(backupRecord{Type: recordSnapshot, Snapshot: snapshot}); err != nil {
+				return err
+			}
+		}
+		counts.Snapshots += len(snapshots)
+		if len(snapshots) < snapshotQuery.Limit {
+			return nil
+		}
+		last := snapshots[len(snapshots)-1]
+		snapshotQuery.Before = &PageKey{Timestamp: last.CreatedAt, ID: last.ID}
+	}
+}
+
+// RestoreOptions control what Restore does with rows that already exist
+type RestoreOptions struct {
+	// Overwrite replaces existing documents, deltas and snapshots with
+	// the archive's. Without it they are skipped, merging the archive
+	// into what is stored.
+	Overwrite bool
+}
+
+// RestoreResult counts what a restore wrote and skipped. Grants are
+// skipped with their document, or when the adapter cannot store them.
+type RestoreResult struct {
+	Restored BackupCounts `json:"restored"`
+	Skipped  BackupCounts `json:"skipped"`
+}
+
+// ErrBadArchive is returned by Restore for input that is not a complete
+// backup archive. Records before the problem have been restored.
+var ErrBadArchive = errors.New("not a valid backup archive")
+
+// Restore writes an archive made by Backup to adapter. Owners are only
+// set on documents without one.
+func Restore(ctx context.Context, adapter StorageAdapter, r io.Reader, opts RestoreOptions) (*RestoreResult, error) {
+	archiver, ok := adapter.(Archiver)
+	if !ok {
+		return nil, fmt.Errorf("restore: %w", ErrNotSupported)
+	}
+	acl, _ := adapter.(DocumentACLStorage)
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header backupRecord
+	if err := dec.Decode(&header); err != nil || header.Type != recordHeader || header.Header == nil || header.Header.Format != BackupFormat {
+		return nil, fmt.Errorf("%w: no %s header", ErrBadArchive, BackupFormat)
+	}
+	if header.Header.Version > BackupVersion {
+		return nil, fmt.Errorf("%w: version %d is newer than this server's %d", ErrBadArchive, header.Header.Version, BackupVersion)
+	}
+
+	result := &RestoreResult{}
+	var seen BackupCounts
+	for {
+		var record backupRecord
+		if err := dec.Decode(&record); err != nil {
+			if err == io.EOF {
+				err = errors.New("it ends before its end record")
+			}
+			return result, fmt.Errorf("%w: %v", ErrBadArchive, err)
+		}
+		var err error
+		switch record.Type {
+		case recordDocument, recordTextDocument:
+			err = restoreDocument(ctx, adapter, archiver, acl, &record, opts, result, &seen)
+		case recordDelta:
+			if record.Delta == nil {
+				return result, fmt.Errorf("%w: delta record without a delta", ErrBadArchive)
+			}
+			seen.Deltas++
+			var restored bool
+			restored, err = archiver.RestoreDelta(ctx, record.Delta, opts.Overwrite)
+			count(restored, &result.Restored.Deltas, &result.Skipped.Deltas)
+		case recordSnapshot:
+			if record.Snapshot == nil {
+				return result, fmt.Errorf("%w: snapshot record without a snapshot", ErrBadArchive)
+			}
+			seen.Snapshots++
+			var restored bool
+			restored, err = archiver.RestoreSnapshot(ctx, record.Snapshot, opts.Overwrite)
+			count(restored, &result.Restored.Snapshots, &result.Skipped.Snapshots)
+		case recordEnd:
+			if record.Counts == nil || *record.Counts != seen {
+				return result, fmt.Errorf("%w: its records do not add up to the counts at its end", ErrBadArchive)
+			}
+			return result, nil
+		default:
+			return result, fmt.Errorf("%w: unknown record type %q", ErrBadArchive, record.Type)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+}
+
+// restoreDocument restores a document or text document record with its
+// clock, owner and grants
+func restoreDocument(ctx context.Context, adapter StorageAdapter, archiver Archiver, acl DocumentACLStorage, record *backupRecord, opts RestoreOptions, result *RestoreResult, seen *BackupCounts) error {
+	var id string
+	var restored bool
+	var err error
+	switch {
+	case record.Type == recordDocument && record.Document != nil:
+		id = record.Document.ID
+		seen.Documents++
+		restored, err = archiver.RestoreDocument(ctx, record.Document, record.Clock, opts.Overwrite)
+		count(restored, &result.Restored.Documents, &result.Skipped.Documents)
+	case record.Type == recordTextDocument && record.TextDocument != nil:
+		text := record.TextDocument
+		id = text.ID
+		seen.TextDocuments++
+		restored, err = restoreTextDocument(ctx, archiver, text, record.Version, record.Clock, opts.Overwrite)
+		count(restored, &result.Restored.TextDocuments, &result.Skipped.TextDocuments)
+	default:
+		return fmt.Errorf("%w: %s record without its document", ErrBadArchive, record.Type)
+	}
+	seen.Grants += len(record.Grants)
+	if err != nil {
+		return fmt.Errorf("restoring %s: %w", id, err)
+	}
+
+	if !restored || acl == nil {
+		result.Skipped.Grants += len(record.Grants)
+		return nil
+	}
+	if record.Owner != "" {
+		if _, err := acl.ClaimDocument(ctx, id, record.Owner); err != nil && !errors.Is(err, ErrNotSupported) {
+			return fmt.Errorf("restoring the owner of %s: %w", id, err)
+		}
+	}
+	for _, grant := range record.Grants {
+		err := acl.SaveDocumentGrant(ctx, grant)
+		if errors.Is(err, ErrNotSupported) {
+			result.Skipped.Grants++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("restoring a grant on %s: %w", id, err)
+		}
+		result.Restored.Grants++
+	}
+	return nil
+}
+
+// restoreTextDocument writes a text document back as SaveTextDocument
+// stores it, a document whose state has type "text", with its times
+func restoreTextDocument(ctx context.Context, archiver Archiver, text *TextDocumentState, version int64, clock map[string]int64, overwrite bool) (bool, error) {
+	doc := &DocumentState{
+		ID: text.ID,
+		State: map[string]interface{}{
+			"type":    "text",
+			"content": text.Content,
+			"crdt":    text.CRDTState,
+			"clock":   text.Clock,
+		},
+		Version:   max(version, 1),
+		CreatedAt: text.CreatedAt,
+		UpdatedAt: text.UpdatedAt,
+	}
+	return archiver.RestoreDocument(ctx, doc, clock, overwrite)
+}
+
+func count(restored bool, restoredCount, skippedCount *int) {
+	if restored {
+		*restoredCount++
+	} else {
+		*skippedCount++
+	}
+}
+
+// ListDocumentIDs returns up to limit document IDs greater than after, in
+// order
+func (p *PostgresAdapter) ListDocumentIDs(ctx context.Context, after string, limit int) ([]string, error) {
+	if !p.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	rows, err := p.pool.Query(ctx, `SELECT id FROM documents WHERE id > $1 ORDER BY id LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, NewQueryError("failed to list document IDs", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, NewQueryError("failed to scan document ID", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RestoreDocument writes a backed up document and replaces its vector
+// clock, in one transaction
+func (p *PostgresAdapter) RestoreDocument(ctx context.Context, doc *DocumentState, clock map[string]int64, overwrite bool) (bool, error) {
+	if !p.IsConnected() {
+		return false, ErrNotConnected
+	}
+
+	stateJSON, err := json.Marshal(doc.State)
+	if err != nil {
+		return false, NewQueryError("failed to marshal state", err)
+	}
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return false, NewQueryError("failed to begin transaction", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO documents (id, state, version, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO NOTHING
+	`
+	if overwrite {
+		query = `
+			INSERT INTO documents (id, state, version, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (id) DO UPDATE
+			SET state = $2, version = $3, created_at = $4, updated_at = $5
+		`
+	}
+	tag, err := tx.Exec(ctx, query, doc.ID, stateJSON, doc.Version, doc.CreatedAt, doc.UpdatedAt)
+	if err != nil {
+		return false, NewQueryError("failed to restore document", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM vector_clocks WHERE document_id = $1`, doc.ID); err != nil {
+		return false, NewQueryError("failed to clear vector clock", err)
+	}
+	for clientID, clockValue := range clock {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO vector_clocks (document_id, client_id, clock_value) VALUES ($1, $2, $3)`,
+			doc.ID, clientID, clockValue)
+		if err != nil {
+			return false, NewQueryError("failed to restore vector clock", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, NewQueryError("failed to commit document restore", err)
+	}
+	return true, nil
+}
+
+// RestoreDelta writes a backed up delta with its ID and timestamp
+func (p *PostgresAdapter) RestoreDelta(ctx context.Context, delta *DeltaEntry, overwrite bool) (bool, error) {
+	if !p.IsConnected() {
+		return false, ErrNotConnected
+	}
+
+	valueJSON, err := json.Marshal(delta.Value)
+	if err != nil {
+		return false, NewQueryError("failed to marshal delta value", err)
+	}
+	conflict := `DO NOTHING`
+	if overwrite {
+		conflict = `DO UPDATE SET document_id = $2, client_id = $3, operation_type = $4,
+			field_path = $5, value = $6, clock_value = $7, timestamp = $8`
+	}
+	query := `
+		INSERT INTO deltas (id, document_id, client_id, operation_type, field_path, value, clock_value, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) ` + conflict
+
+	tag, err := p.pool.Exec(ctx, query, delta.ID, delta.DocumentID, delta.ClientID, delta.OperationType,
+		delta.FieldPath, valueJSON, delta.ClockValue, delta.Timestamp)
+	if err != nil {
+		return false, NewQueryError("failed to restore delta", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RestoreSnapshot writes a backed up snapshot with its ID and creation time
+func (p *PostgresAdapter) RestoreSnapshot(ctx context.Context, snapshot *SnapshotEntry, overwrite bool) (bool, error) {
+	if !p.IsConnected() {
+		return false, ErrNotConnected
+	}
+
+	stateJSON, err := json.Marshal(snapshot.State)
+	if err != nil {
+		return false, NewQueryError("failed to marshal state", err)
+	}
+	versionJSON, err := json.Marshal(snapshot.Version)
+	if err != nil {
+		return false, NewQueryError("failed to marshal version", err)
+	}
+	conflict := `DO NOTHING`
+	if overwrite {
+		conflict = `DO UPDATE SET document_id = $2, state = $3, version = $4,
+			size_bytes = $5, compressed = $6, created_at = $7`
+	}
+	query := `
+		INSERT INTO snapshots (id, document_id, state, version, size_bytes, compressed, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) ` + conflict
+
+	tag, err := p.pool.Exec(ctx, query, snapshot.ID, snapshot.DocumentID, stateJSON, versionJSON,
+		snapshot.SizeBytes, snapshot.Compressed, snapshot.CreatedAt)
+	if err != nil {
+		return false, NewQueryError("failed to restore snapshot", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Compile-time checks that both adapters can be archived
+var (
+	_ Archiver = (*PostgresAdapter)(nil)
+	_ Archiver = (*MemoryAdapter)(nil)
+	_ Archiver = (*Instrumented)(nil)
+)
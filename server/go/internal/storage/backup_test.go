@@ -0,0 +1,233 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/metrics"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// seedBackup fills a memory adapter with documents, a text document and
+// their history, and returns it with the document IDs
+func seedBackup(t *testing.T) (*storage.MemoryAdapter, []string) {
+	t.Helper()
+	ctx := context.Background()
+	source := storage.NewMemoryAdapter()
+	if err := source.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	prefix := "backup-" + protocol.NewID()[:8] + ":"
+	docs := []string{prefix + "a", prefix + "b"}
+	for i, id := range docs {
+		state := map[string]interface{}{}
+		for n, delta := range []*storage.DeltaEntry{
+			{OperationType: storage.OpSet, FieldPath: "title", Value: map[string]interface{}{"text": id}},
+			{OperationType: storage.OpSet, FieldPath: "owner.name", Value: map[string]interface{}{"first": "Ada", "n": float64(i)}},
+			{OperationType: storage.OpDelete, FieldPath: "title"},
+		} {
+			delta.DocumentID = id
+			delta.ClientID = "client-1"
+			delta.ClockValue = int64(n + 1)
+			saved, err := source.SaveDelta(ctx, delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.ApplyDelta(state, saved); err != nil {
+				t.Fatal(err)
+			}
+			if n == 1 {
+				if _, err := source.SaveDocument(ctx, id, state); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := source.SaveSnapshot(ctx, &storage.SnapshotEntry{DocumentID: id, State: state, Version: map[string]int64{"client-1": 2}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if _, err := source.SaveDocument(ctx, id, state); err != nil {
+			t.Fatal(err)
+		}
+		if err := source.MergeVectorClock(ctx, id, map[string]int64{"client-1": 3, "client-2": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	text := prefix + "text"
+	if _, err := source.SaveTextDocument(ctx, text, "hello", `{"fugue":true}`, 7); err != nil {
+		t.Fatal(err)
+	}
+	return source, append(docs, text)
+}
+
+// backupOf backs up adapter and returns the archive
+func backupOf(t *testing.T, adapter storage.StorageAdapter) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := storage.Backup(context.Background(), adapter, &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// assertSameDocuments fails unless both adapters hold the same documents,
+// clocks, deltas and snapshots under ids, times compared to the
+// microsecond PostgreSQL keeps. Unless exact is set, documents may differ
+// in version and update time, which stores may stamp on replaced ones.
+func assertSameDocuments(t *testing.T, want, got storage.StorageAdapter, ids []string, exact bool) {
+	t.Helper()
+	ctx := context.Background()
+	sameTime := func(a, b time.Time) bool { return a.Sub(b).Abs() < time.Microsecond }
+	for _, id := range ids {
+		w, _ := want.GetDocument(ctx, id)
+		g, err := got.GetDocument(ctx, id)
+		if err != nil || g == nil {
+			t.Errorf("%s: GetDocument = %v, %v", id, g, err)
+			continue
+		}
+		if !reflect.DeepEqual(g.State, w.State) || !sameTime(g.CreatedAt, w.CreatedAt) ||
+			exact && (g.Version != w.Version || !sameTime(g.UpdatedAt, w.UpdatedAt)) {
+			t.Errorf("%s: restored %+v, want %+v", id, g, w)
+		}
+
+		wc, _ := want.GetVectorClock(ctx, id)
+		gc, _ := got.GetVectorClock(ctx, id)
+		if !reflect.DeepEqual(gc, wc) {
+			t.Errorf("%s: clock %v, want %v", id, gc, wc)
+		}
+
+		wd, _ := want.QueryDeltas(ctx, id, storage.DeltaQuery{Limit: 100})
+		gd, _ := got.QueryDeltas(ctx, id, storage.DeltaQuery{Limit: 100})
+		if len(gd) != len(wd) {
+			t.Errorf("%s: %d deltas, want %d", id, len(gd), len(wd))
+			continue
+		}
+		for i := range wd {
+			if gd[i].ID != wd[i].ID || gd[i].OperationType != wd[i].OperationType || !reflect.DeepEqual(gd[i].Value, wd[i].Value) ||
+				gd[i].ClockValue != wd[i].ClockValue || !sameTime(gd[i].Timestamp, wd[i].Timestamp) {
+				t.Errorf("%s: delta %d is %+v, want %+v", id, i, gd[i], wd[i])
+			}
+		}
+
+		ws, _ := want.QuerySnapshots(ctx, id, storage.SnapshotQuery{Limit: 10})
+		gs, _ := got.QuerySnapshots(ctx, id, storage.SnapshotQuery{Limit: 10})
+		if len(gs) != len(ws) {
+			t.Errorf("%s: %d snapshots, want %d", id, len(gs), len(ws))
+			continue
+		}
+		for i := range ws {
+			if gs[i].ID != ws[i].ID || !reflect.DeepEqual(gs[i].State, ws[i].State) || !reflect.DeepEqual(gs[i].Version, ws[i].Version) ||
+				!sameTime(gs[i].CreatedAt, ws[i].CreatedAt) {
+				t.Errorf("%s: snapshot %d is %+v, want %+v", id, i, gs[i], ws[i])
+			}
+		}
+	}
+}
+
+func testBackupRoundTrip(t *testing.T, target storage.StorageAdapter) {
+	ctx := context.Background()
+	if err := target.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer target.Disconnect(ctx)
+
+	source, ids := seedBackup(t)
+	defer func() {
+		for _, id := range ids {
+			target.DeleteDocument(ctx, id)
+		}
+	}()
+	archive := backupOf(t, source)
+
+	result, err := storage.Restore(ctx, target, bytes.NewReader(archive), storage.RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := storage.BackupCounts{Documents: 2, TextDocuments: 1, Deltas: 6, Snapshots: 2}
+	if result.Restored != want || result.Skipped != (storage.BackupCounts{}) {
+		t.Errorf("Restore = %+v, want %+v restored", result, want)
+	}
+	assertSameDocuments(t, source, target, ids, true)
+	text, err := target.GetTextDocument(ctx, ids[2])
+	if err != nil || text == nil || text.Content != "hello" || text.CRDTState != `{"fugue":true}` || text.Clock != 7 {
+		t.Errorf("text document = %+v, %v", text, err)
+	}
+
+	// A second restore merges: everything already exists
+	if _, err := target.SaveDocument(ctx, ids[0], map[string]interface{}{"changed": true}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = storage.Restore(ctx, target, bytes.NewReader(archive), storage.RestoreOptions{})
+	if err != nil || result.Restored != (storage.BackupCounts{}) || result.Skipped != want {
+		t.Errorf("merge restore = %+v, %v, want everything skipped", result, err)
+	}
+	if doc, _ := target.GetDocument(ctx, ids[0]); doc == nil || doc.State["changed"] != true {
+		t.Errorf("merge restore replaced %s: %+v", ids[0], doc)
+	}
+
+	// Overwriting puts the backed up document back
+	result, err = storage.Restore(ctx, target, bytes.NewReader(archive), storage.RestoreOptions{Overwrite: true})
+	if err != nil || result.Restored != want {
+		t.Errorf("overwrite restore = %+v, %v", result, err)
+	}
+	assertSameDocuments(t, source, target, ids, false)
+}
+
+func TestBackupRoundTrip_Memory(t *testing.T) {
+	testBackupRoundTrip(t, storage.NewMemoryAdapter())
+}
+
+func TestBackupRoundTrip_Instrumented(t *testing.T) {
+	testBackupRoundTrip(t, storage.Instrument(storage.NewMemoryAdapter(), metrics.NewRegistry()))
+}
+
+// Memory to PostgreSQL: see TestPostgresAdapter_Contract for the database
+func TestBackupRoundTrip_Postgres(t *testing.T) {
+	url := os.Getenv("SYNCKIT_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("SYNCKIT_TEST_DATABASE_URL not set")
+	}
+	cfg := storage.DefaultStorageConfig()
+	cfg.ConnectionString = url
+	testBackupRoundTrip(t, storage.NewPostgresAdapter(cfg))
+}
+
+func TestRestore_RejectsBadArchives(t *testing.T) {
+	source, _ := seedBackup(t)
+	archive := backupOf(t, source)
+	lines := bytes.SplitAfter(archive, []byte("\n"))
+
+	for name, input := range map[string][]byte{
+		"empty":      nil,
+		"not json":   []byte("hello\n"),
+		"no header":  bytes.Join(lines[1:], nil),
+		"truncated":  bytes.Join(lines[:len(lines)-3], nil),
+		"miscounted": append(bytes.Join(lines[:3], nil), lines[len(lines)-2]...),
+		"newer":      bytes.Replace(archive, []byte(`"version":1`), []byte(`"version":99`), 1),
+	} {
+		target := storage.NewMemoryAdapter()
+		target.Connect(context.Background())
+		if _, err := storage.Restore(context.Background(), target, bytes.NewReader(input), storage.RestoreOptions{}); !errors.Is(err, storage.ErrBadArchive) {
+			t.Errorf("%s: Restore error = %v, want ErrBadArchive", name, err)
+		}
+	}
+}
+
+// plainAdapter hides every optional interface of the adapter it wraps
+type plainAdapter struct{ storage.StorageAdapter }
+
+func TestBackup_NeedsArchiver(t *testing.T) {
+	adapter := plainAdapter{storage.NewMemoryAdapter()}
+	if _, err := storage.Backup(context.Background(), adapter, &bytes.Buffer{}); !errors.Is(err, storage.ErrNotSupported) {
+		t.Errorf("Backup error = %v, want ErrNotSupported", err)
+	}
+	if _, err := storage.Restore(context.Background(), adapter, strings.NewReader(""), storage.RestoreOptions{}); !errors.Is(err, storage.ErrNotSupported) {
+		t.Errorf("Restore error = %v, want ErrNotSupported", err)
+	}
+}
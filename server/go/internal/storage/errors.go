@@ -10,6 +10,7 @@ var (
 	ErrNotConnected = errors.New("storage not connected")
 	ErrNotFound     = errors.New("resource not found")
 	ErrConflict     = errors.New("resource conflict")
+	ErrNotSupported = errors.New("operation not supported by this storage")
 )
 
 // StorageError represents a storage operation error
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// FlushWatermarkEntry records how far the server has handled a client's
+// latest flush of offline deltas to a document: every delta of the flush
+// numbered up to Watermark was applied or rejected
+type FlushWatermarkEntry struct {
+	ClientID   string    `json:"clientId"`
+	DocumentID string    `json:"documentId"`
+	FlushID    string    `json:"flushId"`
+	Watermark  int64     `json:"watermark"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// FlushWatermarkStorage is implemented by adapters that can keep flush
+// watermarks, so a client re-flushing to another server or after a restart
+// resumes where it left off
+type FlushWatermarkStorage interface {
+	// GetFlushWatermark returns the client's watermark for the document,
+	// or nil if it has none
+	GetFlushWatermark(ctx context.Context, clientID, documentID string) (*FlushWatermarkEntry, error)
+	// SaveFlushWatermark replaces the client's watermark for the document.
+	// A save for the same flush never moves the watermark back.
+	SaveFlushWatermark(ctx context.Context, entry *FlushWatermarkEntry) error
+}
+
+// GetFlushWatermark reads a client's watermark for a document
+func (p *PostgresAdapter) GetFlushWatermark(ctx context.Context, clientID, documentID string) (*FlushWatermarkEntry, error) {
+	if !p.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	query := `
+		SELECT client_id, document_id, flush_id, watermark, updated_at
+		FROM flush_watermarks
+		WHERE client_id = $1 AND document_id = $2
+	`
+	var entry FlushWatermarkEntry
+	err := p.pool.QueryRow(ctx, query, clientID, documentID).Scan(
+		&entry.ClientID, &entry.DocumentID, &entry.FlushID, &entry.Watermark, &entry.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewQueryError("failed to get flush watermark", err)
+	}
+	return &entry, nil
+}
+
+// SaveFlushWatermark stores a client's watermark for a document. Saves that
+// arrive out of order for one flush leave the higher watermark in place.
+func (p *PostgresAdapter) SaveFlushWatermark(ctx context.Context, entry *FlushWatermarkEntry) error {
+	if !p.IsConnected() {
+		return ErrNotConnected
+	}
+
+	query := `
+		INSERT INTO flush_watermarks (client_id, document_id, flush_id, watermark, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (client_id, document_id)
+		DO UPDATE SET flush_id = EXCLUDED.flush_id, watermark = EXCLUDED.watermark, updated_at = NOW()
+		WHERE flush_watermarks.flush_id <> EXCLUDED.flush_id OR flush_watermarks.watermark < EXCLUDED.watermark
+	`
+	if _, err := p.pool.Exec(ctx, query, entry.ClientID, entry.DocumentID, entry.FlushID, entry.Watermark); err != nil {
+		return NewQueryError("failed to save flush watermark", err)
+	}
+	return nil
+}
+
+var (
+	_ FlushWatermarkStorage = (*PostgresAdapter)(nil)
+	_ FlushWatermarkStorage = (*Instrumented)(nil)
+)
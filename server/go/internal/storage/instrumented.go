@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/metrics"
+)
+
+// StorageLatencyBuckets are the upper bounds, in seconds, of the storage
+// operation latency histogram
+var StorageLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Instrumented wraps a StorageAdapter and records how long each operation
+// takes, by operation and result ("ok" or "error")
+type Instrumented struct {
+	StorageAdapter
+	latency *metrics.HistogramVec
+}
+
+// Instrument wraps adapter so its operation latencies are recorded in reg
+func Instrument(adapter StorageAdapter, reg *metrics.Registry) *Instrumented {
+	return &Instrumented{
+		StorageAdapter: adapter,
+		latency: reg.NewHistogramVec("synckit_storage_operation_seconds",
+			"Storage operation latency, by operation and result.", StorageLatencyBuckets, "operation", "result"),
+	}
+}
+
+// observe records an operation that started at start and returned *err. It
+// takes a pointer so a deferred call sees the named result.
+func (s *Instrumented) observe(op string, start time.Time, err *error) {
+	result := "ok"
+	if *err != nil {
+		result = "error"
+	}
+	s.latency.With(op, result).Observe(time.Since(start).Seconds())
+}
+
+// PoolStats reports the wrapped adapter's pool, if it has one
+func (s *Instrumented) PoolStats() (PoolStats, bool) {
+	if statter, ok := s.StorageAdapter.(PoolStatter); ok {
+		return statter.PoolStats()
+	}
+	return PoolStats{}, false
+}
+
+// apiKeys is the wrapped adapter's APIKeyStorage
+func (s *Instrumented) apiKeys() (APIKeyStorage, error) {
+	if keys, ok := s.StorageAdapter.(APIKeyStorage); ok {
+		return keys, nil
+	}
+	return nil, ErrNotSupported
+}
+
+// SaveAPIKey implements APIKeyStorage, returning ErrNotSupported if the
+// wrapped adapter does not. So do the other APIKeyStorage methods.
+func (s *Instrumented) SaveAPIKey(ctx context.Context, key *APIKeyEntry) (saved *APIKeyEntry, err error) {
+	defer s.observe("save_api_key", time.Now(), &err)
+	keys, err := s.apiKeys()
+	if err != nil {
+		return nil, err
+	}
+	return keys.SaveAPIKey(ctx, key)
+}
+
+func (s *Instrumented) ListAPIKeys(ctx context.Context) (list []*APIKeyEntry, err error) {
+	defer s.observe("list_api_keys", time.Now(), &err)
+	keys, err := s.apiKeys()
+	if err != nil {
+		return nil, err
+	}
+	return keys.ListAPIKeys(ctx)
+}
+
+func (s *Instrumented) RevokeAPIKey(ctx context.Context, id string) (revoked bool, err error) {
+	defer s.observe("revoke_api_key", time.Now(), &err)
+	keys, err := s.apiKeys()
+	if err != nil {
+		return false, err
+	}
+	return keys.RevokeAPIKey(ctx, id)
+}
+
+func (s *Instrumented) GetAPIKeyByHash(ctx context.Context, keyHash string) (key *APIKeyEntry, err error) {
+	defer s.observe("get_api_key", time.Now(), &err)
+	keys, err := s.apiKeys()
+	if err != nil {
+		return nil, err
+	}
+	return keys.GetAPIKeyByHash(ctx, keyHash)
+}
+
+// documentACL is the wrapped adapter's DocumentACLStorage
+func (s *Instrumented) documentACL() (DocumentACLStorage, error) {
+	if acl, ok := s.StorageAdapter.(DocumentACLStorage); ok {
+		return acl, nil
+	}
+	return nil, ErrNotSupported
+}
+
+// SaveDocumentGrant implements DocumentACLStorage, returning ErrNotSupported
+// if the wrapped adapter does not. So do the other DocumentACLStorage
+// methods.
+func (s *Instrumented) SaveDocumentGrant(ctx context.Context, grant *DocumentGrantEntry) (err error) {
+	defer s.observe("save_document_grant", time.Now(), &err)
+	acl, err := s.documentACL()
+	if err != nil {
+		return err
+	}
+	return acl.SaveDocumentGrant(ctx, grant)
+}
+
+func (s *Instrumented) DeleteDocumentGrant(ctx context.Context, documentID, principal string) (deleted bool, err error) {
+	defer s.observe("delete_document_grant", time.Now(), &err)
+	acl, err := s.documentACL()
+	if err != nil {
+		return false, err
+	}
+	return acl.DeleteDocumentGrant(ctx, documentID, principal)
+}
+
+func (s *Instrumented) ListDocumentGrants(ctx context.Context, documentID string) (grants []*DocumentGrantEntry, err error) {
+	defer s.observe("list_document_grants", time.Now(), &err)
+	acl, err := s.documentACL()
+	if err != nil {
+		return nil, err
+	}
+	return acl.ListDocumentGrants(ctx, documentID)
+}
+
+func (s *Instrumented) ListPrincipalGrants(ctx context.Context, principal string) (grants []*DocumentGrantEntry, err error) {
+	defer s.observe("list_principal_grants", time.Now(), &err)
+	acl, err := s.documentACL()
+	if err != nil {
+		return nil, err
+	}
+	return acl.ListPrincipalGrants(ctx, principal)
+}
+
+func (s *Instrumented) flushWatermarks() (FlushWatermarkStorage, error) {
+	if watermarks, ok := s.StorageAdapter.(FlushWatermarkStorage); ok {
+		return watermarks, nil
+	}
+	return nil, ErrNotSupported
+}
+
+// GetFlushWatermark implements FlushWatermarkStorage, returning
+// ErrNotSupported if the wrapped adapter does not. So does
+// SaveFlushWatermark.
+func (s *Instrumented) GetFlushWatermark(ctx context.Context, clientID, documentID string) (entry *FlushWatermarkEntry, err error) {
+	defer s.observe("get_flush_watermark", time.Now(), &err)
+	watermarks, err := s.flushWatermarks()
+	if err != nil {
+		return nil, err
+	}
+	return watermarks.GetFlushWatermark(ctx, clientID, documentID)
+}
+
+func (s *Instrumented) SaveFlushWatermark(ctx context.Context, entry *FlushWatermarkEntry) (err error) {
+	defer s.observe("save_flush_watermark", time.Now(), &err)
+	watermarks, err := s.flushWatermarks()
+	if err != nil {
+		return err
+	}
+	return watermarks.SaveFlushWatermark(ctx, entry)
+}
+
+func (s *Instrumented) ClaimDocument(ctx context.Context, documentID, ownerID string) (owner string, err error) {
+	defer s.observe("claim_document", time.Now(), &err)
+	acl, err := s.documentACL()
+	if err != nil {
+		return "", err
+	}
+	return acl.ClaimDocument(ctx, documentID, ownerID)
+}
+
+func (s *Instrumented) GetDocumentOwner(ctx context.Context, documentID string) (owner string, err error) {
+	defer s.observe("get_document_owner", time.Now(), &err)
+	acl, err := s.documentACL()
+	if err != nil {
+		return "", err
+	}
+	return acl.GetDocumentOwner(ctx, documentID)
+}
+
+func (s *Instrumented) ListOwnedDocuments(ctx context.Context, ownerID string) (docIDs []string, err error) {
+	defer s.observe("list_owned_documents", time.Now(), &err)
+	acl, err := s.documentACL()
+	if err != nil {
+		return nil, err
+	}
+	return acl.ListOwnedDocuments(ctx, ownerID)
+}
+
+// archiver is the wrapped adapter's Archiver
+func (s *Instrumented) archiver() (Archiver, error) {
+	if archiver, ok := s.StorageAdapter.(Archiver); ok {
+		return archiver, nil
+	}
+	return nil, ErrNotSupported
+}
+
+// ListDocumentIDs implements Archiver, returning ErrNotSupported if the
+// wrapped adapter does not. So do the other Archiver methods.
+func (s *Instrumented) ListDocumentIDs(ctx context.Context, after string, limit int) (ids []string, err error) {
+	defer s.observe("list_document_ids", time.Now(), &err)
+	archiver, err := s.archiver()
+	if err != nil {
+		return nil, err
+	}
+	return archiver.ListDocumentIDs(ctx, after, limit)
+}
+
+func (s *Instrumented) RestoreDocument(ctx context.Context, doc *DocumentState, clock map[string]int64, overwrite bool) (restored bool, err error) {
+	defer s.observe("restore_document", time.Now(), &err)
+	archiver, err := s.archiver()
+	if err != nil {
+		return false, err
+	}
+	return archiver.RestoreDocument(ctx, doc, clock, overwrite)
+}
+
+func (s *Instrumented) RestoreDelta(ctx context.Context, delta *DeltaEntry, overwrite bool) (restored bool, err error) {
+	defer s.observe("restore_delta", time.Now(), &err)
+	archiver, err := s.archiver()
+	if err != nil {
+		return false, err
+	}
+	return archiver.RestoreDelta(ctx, delta, overwrite)
+}
+
+func (s *Instrumented) RestoreSnapshot(ctx context.Context, snapshot *SnapshotEntry, overwrite bool) (restored bool, err error) {
+	defer s.observe("restore_snapshot", time.Now(), &err)
+	archiver, err := s.archiver()
+	if err != nil {
+		return false, err
+	}
+	return archiver.RestoreSnapshot(ctx, snapshot, overwrite)
+}
+
+func (s *Instrumented) Connect(ctx context.Context) (err error) {
+	defer s.observe("connect", time.Now(), &err)
+	return s.StorageAdapter.Connect(ctx)
+}
+
+func (s *Instrumented) HealthCheck(ctx context.Context) (ok bool, err error) {
+	defer s.observe("health_check", time.Now(), &err)
+	return s.StorageAdapter.HealthCheck(ctx)
+}
+
+func (s *Instrumented) GetDocument(ctx context.Context, id string) (doc *DocumentState, err error) {
+	defer s.observe("get_document", time.Now(), &err)
+	return s.StorageAdapter.GetDocument(ctx, id)
+}
+
+func (s *Instrumented) SaveDocument(ctx context.Context, id string, state map[string]interface{}) (doc *DocumentState, err error) {
+	defer s.observe("save_document", time.Now(), &err)
+	return s.StorageAdapter.SaveDocument(ctx, id, state)
+}
+
+func (s *Instrumented) UpdateDocument(ctx context.Context, id string, state map[string]interface{}) (doc *DocumentState, err error) {
+	defer s.observe("update_document", time.Now(), &err)
+	return s.StorageAdapter.UpdateDocument(ctx, id, state)
+}
+
+func (s *Instrumented) DeleteDocument(ctx context.Context, id string) (deleted bool, err error) {
+	defer s.observe("delete_document", time.Now(), &err)
+	return s.StorageAdapter.DeleteDocument(ctx, id)
+}
+
+func (s *Instrumented) ListDocuments(ctx context.Context, limit, offset int) (docs []*DocumentState, err error) {
+	defer s.observe("list_documents", time.Now(), &err)
+	return s.StorageAdapter.ListDocuments(ctx, limit, offset)
+}
+
+func (s *Instrumented) GetVectorClock(ctx context.Context, documentID string) (clock map[string]int64, err error) {
+	defer s.observe("get_vector_clock", time.Now(), &err)
+	return s.StorageAdapter.GetVectorClock(ctx, documentID)
+}
+
+func (s *Instrumented) UpdateVectorClock(ctx context.Context, documentID, clientID string, clockValue int64) (err error) {
+	defer s.observe("update_vector_clock", time.Now(), &err)
+	return s.StorageAdapter.UpdateVectorClock(ctx, documentID, clientID, clockValue)
+}
+
+func (s *Instrumented) MergeVectorClock(ctx context.Context, documentID string, clock map[string]int64) (err error) {
+	defer s.observe("merge_vector_clock", time.Now(), &err)
+	return s.StorageAdapter.MergeVectorClock(ctx, documentID, clock)
+}
+
+func (s *Instrumented) SaveDelta(ctx context.Context, delta *DeltaEntry) (saved *DeltaEntry, err error) {
+	defer s.observe("save_delta", time.Now(), &err)
+	return s.StorageAdapter.SaveDelta(ctx, delta)
+}
+
+func (s *Instrumented) GetDeltas(ctx context.Context, documentID string, limit int) (deltas []*DeltaEntry, err error) {
+	defer s.observe("get_deltas", time.Now(), &err)
+	return s.StorageAdapter.GetDeltas(ctx, documentID, limit)
+}
+
+func (s *Instrumented) QueryDeltas(ctx context.Context, documentID string, query DeltaQuery) (deltas []*DeltaEntry, err error) {
+	defer s.observe("query_deltas", time.Now(), &err)
+	return s.StorageAdapter.QueryDeltas(ctx, documentID, query)
+}
+
+func (s *Instrumented) DeleteDeltasBefore(ctx context.Context, documentID string, before time.Time) (deleted int, err error) {
+	defer s.observe("delete_deltas", time.Now(), &err)
+	return s.StorageAdapter.DeleteDeltasBefore(ctx, documentID, before)
+}
+
+func (s *Instrumented) SaveSession(ctx context.Context, session *SessionEntry) (saved *SessionEntry, err error) {
+	defer s.observe("save_session", time.Now(), &err)
+	return s.StorageAdapter.SaveSession(ctx, session)
+}
+
+func (s *Instrumented) UpdateSession(ctx context.Context, sessionID string, lastSeen time.Time, metadata map[string]interface{}) (err error) {
+	defer s.observe("update_session", time.Now(), &err)
+	return s.StorageAdapter.UpdateSession(ctx, sessionID, lastSeen, metadata)
+}
+
+func (s *Instrumented) DeleteSession(ctx context.Context, sessionID string) (deleted bool, err error) {
+	defer s.observe("delete_session", time.Now(), &err)
+	return s.StorageAdapter.DeleteSession(ctx, sessionID)
+}
+
+func (s *Instrumented) GetSessions(ctx context.Context, userID string) (sessions []*SessionEntry, err error) {
+	defer s.observe("get_sessions", time.Now(), &err)
+	return s.StorageAdapter.GetSessions(ctx, userID)
+}
+
+func (s *Instrumented) SaveSnapshot(ctx context.Context, snapshot *SnapshotEntry) (saved *SnapshotEntry, err error) {
+	defer s.observe("save_snapshot", time.Now(), &err)
+	return s.StorageAdapter.SaveSnapshot(ctx, snapshot)
+}
+
+func (s *Instrumented) GetSnapshot(ctx context.Context, snapshotID string) (snapshot *SnapshotEntry, err error) {
+	defer s.observe("get_snapshot", time.Now(), &err)
+	return s.StorageAdapter.GetSnapshot(ctx, snapshotID)
+}
+
+func (s *Instrumented) GetLatestSnapshot(ctx context.Context, documentID string) (snapshot *SnapshotEntry, err error) {
+	defer s.observe("get_latest_snapshot", time.Now(), &err)
+	return s.StorageAdapter.GetLatestSnapshot(ctx, documentID)
+}
+
+func (s *Instrumented) ListSnapshots(ctx context.Context, documentID string, limit int) (snapshots []*SnapshotEntry, err error) {
+	defer s.observe("list_snapshots", time.Now(), &err)
+	return s.StorageAdapter.ListSnapshots(ctx, documentID, limit)
+}
+
+func (s *Instrumented) QuerySnapshots(ctx context.Context, documentID string, query SnapshotQuery) (snapshots []*SnapshotEntry, err error) {
+	defer s.observe("query_snapshots", time.Now(), &err)
+	return s.StorageAdapter.QuerySnapshots(ctx, documentID, query)
+}
+
+func (s *Instrumented) DeleteSnapshot(ctx context.Context, snapshotID string) (deleted bool, err error) {
+	defer s.observe("delete_snapshot", time.Now(), &err)
+	return s.StorageAdapter.DeleteSnapshot(ctx, snapshotID)
+}
+
+func (s *Instrumented) SaveAuditEvent(ctx context.Context, event *AuditEventEntry) (saved *AuditEventEntry, err error) {
+	defer s.observe("save_audit_event", time.Now(), &err)
+	return s.StorageAdapter.SaveAuditEvent(ctx, event)
+}
+
+func (s *Instrumented) SaveTextDocument(ctx context.Context, id, content, crdtState string, clock int64) (doc *TextDocumentState, err error) {
+	defer s.observe("save_text_document", time.Now(), &err)
+	return s.StorageAdapter.SaveTextDocument(ctx, id, content, crdtState, clock)
+}
+
+func (s *Instrumented) GetTextDocument(ctx context.Context, id string) (doc *TextDocumentState, err error) {
+	defer s.observe("get_text_document", time.Now(), &err)
+	return s.StorageAdapter.GetTextDocument(ctx, id)
+}
+
+func (s *Instrumented) Cleanup(ctx context.Context, options *CleanupOptions) (result *CleanupResult, err error) {
+	defer s.observe("cleanup", time.Now(), &err)
+	return s.StorageAdapter.Cleanup(ctx, options)
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/metrics"
+)
+
+type failingGet struct {
+	StorageAdapter
+}
+
+func (failingGet) GetDocument(ctx context.Context, id string) (*DocumentState, error) {
+	if id == "broken" {
+		return nil, errors.New("boom")
+	}
+	return &DocumentState{ID: id}, nil
+}
+
+func TestInstrumented_RecordsOperationResults(t *testing.T) {
+	reg := metrics.NewRegistry()
+	store := Instrument(failingGet{}, reg)
+
+	store.GetDocument(context.Background(), "doc")
+	store.GetDocument(context.Background(), "doc")
+	if _, err := store.GetDocument(context.Background(), "broken"); err == nil {
+		t.Fatal("error should pass through")
+	}
+
+	var b strings.Builder
+	reg.WriteText(&b)
+	for _, line := range []string{
+		`synckit_storage_operation_seconds_count{operation="get_document",result="ok"} 2`,
+		`synckit_storage_operation_seconds_count{operation="get_document",result="error"} 1`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, b.String())
+		}
+	}
+}
@@ -67,19 +67,53 @@ type TextDocumentState struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// AuditEventEntry represents a recorded security event
+type AuditEventEntry struct {
+	ID           string                 `json:"id"`
+	EventType    string                 `json:"eventType"`
+	Actor        string                 `json:"actor,omitempty"`
+	DocumentID   string                 `json:"documentId,omitempty"`
+	ConnectionID string                 `json:"connectionId,omitempty"`
+	IP           string                 `json:"ip,omitempty"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+	CreatedAt    time.Time              `json:"createdAt"`
+}
+
+// PageKey is a row's position in a listing ordered by time, then ID. Pages
+// continue from the last row's key.
+type PageKey struct {
+	Timestamp time.Time
+	ID        string
+}
+
+// DeltaQuery selects a page of a document's deltas, oldest first
+type DeltaQuery struct {
+	Since time.Time // Skip deltas before Since (zero for all)
+	After *PageKey  // Start after this delta (nil for the first page)
+	Limit int       // Page size (default 100)
+}
+
+// SnapshotQuery selects a page of a document's snapshots, newest first
+type SnapshotQuery struct {
+	Before *PageKey // Start after this snapshot (nil for the first page)
+	Limit  int      // Page size (default 10)
+}
+
 // CleanupOptions specifies what to clean up
 type CleanupOptions struct {
 	OldSessionsHours        int
 	OldDeltasDays           int
 	OldSnapshotsDays        int
 	MaxSnapshotsPerDocument int
+	OldAuditEventsDays      int
 }
 
 // CleanupResult contains cleanup statistics
 type CleanupResult struct {
-	SessionsDeleted  int `json:"sessionsDeleted"`
-	DeltasDeleted    int `json:"deltasDeleted"`
-	SnapshotsDeleted int `json:"snapshotsDeleted"`
+	SessionsDeleted    int `json:"sessionsDeleted"`
+	DeltasDeleted      int `json:"deltasDeleted"`
+	SnapshotsDeleted   int `json:"snapshotsDeleted"`
+	AuditEventsDeleted int `json:"auditEventsDeleted"`
 }
 
 // StorageAdapter defines the interface for document persistence
@@ -105,6 +139,8 @@ type StorageAdapter interface {
 	// Delta operations (for audit trail)
 	SaveDelta(ctx context.Context, delta *DeltaEntry) (*DeltaEntry, error)
 	GetDeltas(ctx context.Context, documentID string, limit int) ([]*DeltaEntry, error)
+	QueryDeltas(ctx context.Context, documentID string, query DeltaQuery) ([]*DeltaEntry, error)
+	DeleteDeltasBefore(ctx context.Context, documentID string, before time.Time) (int, error)
 
 	// Session operations (for connection tracking)
 	SaveSession(ctx context.Context, session *SessionEntry) (*SessionEntry, error)
@@ -117,8 +153,12 @@ type StorageAdapter interface {
 	GetSnapshot(ctx context.Context, snapshotID string) (*SnapshotEntry, error)
 	GetLatestSnapshot(ctx context.Context, documentID string) (*SnapshotEntry, error)
 	ListSnapshots(ctx context.Context, documentID string, limit int) ([]*SnapshotEntry, error)
+	QuerySnapshots(ctx context.Context, documentID string, query SnapshotQuery) ([]*SnapshotEntry, error)
 	DeleteSnapshot(ctx context.Context, snapshotID string) (bool, error)
 
+	// Audit operations
+	SaveAuditEvent(ctx context.Context, event *AuditEventEntry) (*AuditEventEntry, error)
+
 	// Text document operations (for SyncText/Fugue CRDT)
 	SaveTextDocument(ctx context.Context, id, content, crdtState string, clock int64) (*TextDocumentState, error)
 	GetTextDocument(ctx context.Context, id string) (*TextDocumentState, error)
@@ -127,6 +167,21 @@ type StorageAdapter interface {
 	Cleanup(ctx context.Context, options *CleanupOptions) (*CleanupResult, error)
 }
 
+// PoolStats describes an adapter's connection pool
+type PoolStats struct {
+	TotalConns    int32 `json:"totalConns"`
+	IdleConns     int32 `json:"idleConns"`
+	AcquiredConns int32 `json:"acquiredConns"`
+	MaxConns      int32 `json:"maxConns"`
+	AcquireCount  int64 `json:"acquireCount"` // Connections handed out since connecting
+}
+
+// PoolStatter is implemented by adapters that pool connections. ok is false
+// while there is no pool to report on.
+type PoolStatter interface {
+	PoolStats() (stats PoolStats, ok bool)
+}
+
 // StorageConfig holds configuration for storage adapters
 type StorageConfig struct {
 	ConnectionString  string
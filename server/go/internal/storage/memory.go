@@ -0,0 +1,822 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
+)
+
+// MemoryAdapter implements StorageAdapter in process memory, with the
+// semantics of PostgresAdapter: JSON values come back as they would from
+// JSONB, deleting a document deletes its clock, deltas and snapshots, and
+// the storage assigns IDs and timestamps. It suits tests and single-server
+// deployments that can lose their data on restart.
+type MemoryAdapter struct {
+	mu        sync.Mutex
+	connected bool
+	last      time.Time // Latest timestamp handed out, so each is unique
+
+	documents map[string]*DocumentState
+	clocks    map[string]map[string]int64 // Document ID -> client ID -> clock
+	deltas    []*DeltaEntry
+	sessions  map[string]*SessionEntry
+	snapshots []*SnapshotEntry
+	audit     []*AuditEventEntry
+}
+
+// NewMemoryAdapter creates an empty in-memory storage adapter
+func NewMemoryAdapter() *MemoryAdapter {
+	return &MemoryAdapter{
+		documents: make(map[string]*DocumentState),
+		clocks:    make(map[string]map[string]int64),
+		sessions:  make(map[string]*SessionEntry),
+	}
+}
+
+// Connect marks the adapter connected; its data survives reconnecting
+func (m *MemoryAdapter) Connect(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connected = true
+	return nil
+}
+
+// Disconnect marks the adapter disconnected
+func (m *MemoryAdapter) Disconnect(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connected = false
+	return nil
+}
+
+// IsConnected returns connection status
+func (m *MemoryAdapter) IsConnected() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.connected
+}
+
+// HealthCheck reports whether the adapter is connected
+func (m *MemoryAdapter) HealthCheck(ctx context.Context) (bool, error) {
+	if !m.IsConnected() {
+		return false, ErrNotConnected
+	}
+	return true, nil
+}
+
+// lock locks the adapter if it is connected
+func (m *MemoryAdapter) lock() error {
+	m.mu.Lock()
+	if !m.connected {
+		m.mu.Unlock()
+		return ErrNotConnected
+	}
+	return nil
+}
+
+// now returns the current time, later than any it returned before.
+// Must be called with mu held.
+func (m *MemoryAdapter) now() time.Time {
+	now := time.Now()
+	if !now.After(m.last) {
+		now = m.last.Add(time.Microsecond)
+	}
+	m.last = now
+	return now
+}
+
+// GetDocument retrieves a document by ID, or nil if there is none
+func (m *MemoryAdapter) GetDocument(ctx context.Context, id string) (*DocumentState, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+	doc := m.documents[id]
+	if doc == nil {
+		return nil, nil
+	}
+	return copyDocument(doc), nil
+}
+
+// SaveDocument creates or updates a document
+func (m *MemoryAdapter) SaveDocument(ctx context.Context, id string, state map[string]interface{}) (*DocumentState, error) {
+	stored, err := jsonCopy(state)
+	if err != nil {
+		return nil, NewQueryError("failed to marshal state", err)
+	}
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	now := m.now()
+	doc := m.documents[id]
+	if doc == nil {
+		doc = &DocumentState{ID: id, Version: 1, CreatedAt: now}
+		m.documents[id] = doc
+	}
+	doc.State = stored
+	doc.UpdatedAt = now
+	return copyDocument(doc), nil
+}
+
+// UpdateDocument updates an existing document, returning a NotFoundError
+// if there is none
+func (m *MemoryAdapter) UpdateDocument(ctx context.Context, id string, state map[string]interface{}) (*DocumentState, error) {
+	stored, err := jsonCopy(state)
+	if err != nil {
+		return nil, NewQueryError("failed to marshal state", err)
+	}
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	doc := m.documents[id]
+	if doc == nil {
+		return nil, NewNotFoundError("document", id)
+	}
+	doc.State = stored
+	doc.UpdatedAt = m.now()
+	return copyDocument(doc), nil
+}
+
+// DeleteDocument removes a document with its vector clock, deltas and
+// snapshots
+func (m *MemoryAdapter) DeleteDocument(ctx context.Context, id string) (bool, error) {
+	if err := m.lock(); err != nil {
+		return false, err
+	}
+	defer m.mu.Unlock()
+
+	if m.documents[id] == nil {
+		return false, nil
+	}
+	delete(m.documents, id)
+	delete(m.clocks, id)
+	m.deltas = removeWhere(m.deltas, func(d *DeltaEntry) bool { return d.DocumentID == id })
+	m.snapshots = removeWhere(m.snapshots, func(s *SnapshotEntry) bool { return s.DocumentID == id })
+	return true, nil
+}
+
+// ListDocuments retrieves documents, most recently updated first
+func (m *MemoryAdapter) ListDocuments(ctx context.Context, limit, offset int) ([]*DocumentState, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	if limit <= 0 {
+		limit = 100
+	}
+	docs := make([]*DocumentState, 0, len(m.documents))
+	for _, doc := range m.documents {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].UpdatedAt.After(docs[j].UpdatedAt) })
+
+	var page []*DocumentState
+	for _, doc := range pageOf(docs, limit, offset) {
+		page = append(page, copyDocument(doc))
+	}
+	return page, nil
+}
+
+// GetVectorClock retrieves the vector clock for a document, empty if it has
+// none
+func (m *MemoryAdapter) GetVectorClock(ctx context.Context, documentID string) (map[string]int64, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	clock := make(map[string]int64, len(m.clocks[documentID]))
+	for clientID, value := range m.clocks[documentID] {
+		clock[clientID] = value
+	}
+	return clock, nil
+}
+
+// UpdateVectorClock sets a single vector clock entry
+func (m *MemoryAdapter) UpdateVectorClock(ctx context.Context, documentID, clientID string, clockValue int64) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+	m.clockOf(documentID)[clientID] = clockValue
+	return nil
+}
+
+// MergeVectorClock raises each entry to the merged value when it is higher
+func (m *MemoryAdapter) MergeVectorClock(ctx context.Context, documentID string, clock map[string]int64) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+	stored := m.clockOf(documentID)
+	for clientID, value := range clock {
+		if current, ok := stored[clientID]; !ok || value > current {
+			stored[clientID] = value
+		}
+	}
+	return nil
+}
+
+// clockOf returns a document's stored clock, creating it.
+// Must be called with mu held.
+func (m *MemoryAdapter) clockOf(documentID string) map[string]int64 {
+	clock := m.clocks[documentID]
+	if clock == nil {
+		clock = make(map[string]int64)
+		m.clocks[documentID] = clock
+	}
+	return clock
+}
+
+// SaveDelta saves an operation to the audit trail, setting its ID and
+// timestamp
+func (m *MemoryAdapter) SaveDelta(ctx context.Context, delta *DeltaEntry) (*DeltaEntry, error) {
+	value, err := jsonCopy(delta.Value)
+	if err != nil {
+		return nil, NewQueryError("failed to marshal delta value", err)
+	}
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	delta.ID = newMemoryID()
+	delta.Timestamp = m.now()
+	stored := *delta
+	stored.Value = value
+	m.deltas = append(m.deltas, &stored)
+	return delta, nil
+}
+
+// GetDeltas retrieves a document's deltas, newest first
+func (m *MemoryAdapter) GetDeltas(ctx context.Context, documentID string, limit int) ([]*DeltaEntry, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	if limit <= 0 {
+		limit = 100
+	}
+	var deltas []*DeltaEntry
+	for i := len(m.deltas) - 1; i >= 0 && len(deltas) < limit; i-- {
+		if m.deltas[i].DocumentID == documentID {
+			deltas = append(deltas, copyDelta(m.deltas[i]))
+		}
+	}
+	return deltas, nil
+}
+
+// QueryDeltas retrieves a page of a document's deltas in timestamp order
+func (m *MemoryAdapter) QueryDeltas(ctx context.Context, documentID string, q DeltaQuery) ([]*DeltaEntry, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	if q.Limit <= 0 {
+		q.Limit = 100
+	}
+	deltas := make([]*DeltaEntry, 0)
+	for _, d := range m.deltas {
+		if len(deltas) == q.Limit {
+			break
+		}
+		if d.DocumentID != documentID || d.Timestamp.Before(q.Since) {
+			continue
+		}
+		if q.After != nil && !pageKeyLess(*q.After, PageKey{Timestamp: d.Timestamp, ID: d.ID}) {
+			continue
+		}
+		deltas = append(deltas, copyDelta(d))
+	}
+	return deltas, nil
+}
+
+// DeleteDeltasBefore removes a document's deltas recorded before a time,
+// returning how many were deleted
+func (m *MemoryAdapter) DeleteDeltasBefore(ctx context.Context, documentID string, before time.Time) (int, error) {
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	n := len(m.deltas)
+	m.deltas = removeWhere(m.deltas, func(d *DeltaEntry) bool {
+		return d.DocumentID == documentID && d.Timestamp.Before(before)
+	})
+	return n - len(m.deltas), nil
+}
+
+// SaveSession saves a new connection session, setting when it connected
+func (m *MemoryAdapter) SaveSession(ctx context.Context, session *SessionEntry) (*SessionEntry, error) {
+	metadata, err := jsonCopy(session.Metadata)
+	if err != nil {
+		return nil, NewQueryError("failed to marshal metadata", err)
+	}
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	if m.sessions[session.ID] != nil {
+		return nil, NewQueryError("failed to save session", NewConflictError("session "+session.ID+" already exists"))
+	}
+	now := m.now()
+	session.ConnectedAt = now
+	session.LastSeen = now
+	stored := *session
+	stored.Metadata = metadata
+	m.sessions[session.ID] = &stored
+	return session, nil
+}
+
+// UpdateSession updates a session's last seen time, and its metadata unless
+// metadata is nil
+func (m *MemoryAdapter) UpdateSession(ctx context.Context, sessionID string, lastSeen time.Time, metadata map[string]interface{}) error {
+	stored, err := jsonCopy(metadata)
+	if err != nil {
+		return NewQueryError("failed to marshal metadata", err)
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	session := m.sessions[sessionID]
+	if session == nil {
+		return nil
+	}
+	session.LastSeen = lastSeen
+	if metadata != nil {
+		session.Metadata = stored
+	}
+	return nil
+}
+
+// DeleteSession removes a session
+func (m *MemoryAdapter) DeleteSession(ctx context.Context, sessionID string) (bool, error) {
+	if err := m.lock(); err != nil {
+		return false, err
+	}
+	defer m.mu.Unlock()
+
+	if m.sessions[sessionID] == nil {
+		return false, nil
+	}
+	delete(m.sessions, sessionID)
+	return true, nil
+}
+
+// GetSessions retrieves a user's sessions, most recently seen first
+func (m *MemoryAdapter) GetSessions(ctx context.Context, userID string) ([]*SessionEntry, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	var sessions []*SessionEntry
+	for _, session := range m.sessions {
+		if session.UserID == userID {
+			copied := *session
+			copied.Metadata, _ = jsonCopy(session.Metadata)
+			sessions = append(sessions, &copied)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeen.After(sessions[j].LastSeen) })
+	return sessions, nil
+}
+
+// SaveSnapshot saves a document snapshot, setting its ID and creation time
+func (m *MemoryAdapter) SaveSnapshot(ctx context.Context, snapshot *SnapshotEntry) (*SnapshotEntry, error) {
+	state, err := jsonCopy(snapshot.State)
+	if err != nil {
+		return nil, NewQueryError("failed to marshal state", err)
+	}
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	snapshot.ID = newMemoryID()
+	snapshot.CreatedAt = m.now()
+	stored := *snapshot
+	stored.State = state
+	stored.Version = copyClock(snapshot.Version)
+	m.snapshots = append(m.snapshots, &stored)
+	return snapshot, nil
+}
+
+// GetSnapshot retrieves a snapshot by ID, or nil if there is none
+func (m *MemoryAdapter) GetSnapshot(ctx context.Context, snapshotID string) (*SnapshotEntry, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	for _, s := range m.snapshots {
+		if s.ID == snapshotID {
+			return copySnapshot(s), nil
+		}
+	}
+	return nil, nil
+}
+
+// GetLatestSnapshot retrieves a document's most recent snapshot, or nil if
+// it has none
+func (m *MemoryAdapter) GetLatestSnapshot(ctx context.Context, documentID string) (*SnapshotEntry, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	for i := len(m.snapshots) - 1; i >= 0; i-- {
+		if m.snapshots[i].DocumentID == documentID {
+			return copySnapshot(m.snapshots[i]), nil
+		}
+	}
+	return nil, nil
+}
+
+// ListSnapshots retrieves a document's snapshots, newest first
+func (m *MemoryAdapter) ListSnapshots(ctx context.Context, documentID string, limit int) ([]*SnapshotEntry, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	if limit <= 0 {
+		limit = 10
+	}
+	var snapshots []*SnapshotEntry
+	for i := len(m.snapshots) - 1; i >= 0 && len(snapshots) < limit; i-- {
+		if m.snapshots[i].DocumentID == documentID {
+			snapshots = append(snapshots, copySnapshot(m.snapshots[i]))
+		}
+	}
+	return snapshots, nil
+}
+
+// QuerySnapshots retrieves a page of a document's snapshots, newest first
+func (m *MemoryAdapter) QuerySnapshots(ctx context.Context, documentID string, q SnapshotQuery) ([]*SnapshotEntry, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	if q.Limit <= 0 {
+		q.Limit = 10
+	}
+	snapshots := make([]*SnapshotEntry, 0)
+	for i := len(m.snapshots) - 1; i >= 0 && len(snapshots) < q.Limit; i-- {
+		s := m.snapshots[i]
+		if s.DocumentID != documentID {
+			continue
+		}
+		if q.Before != nil && !pageKeyLess(PageKey{Timestamp: s.CreatedAt, ID: s.ID}, *q.Before) {
+			continue
+		}
+		snapshots = append(snapshots, copySnapshot(s))
+	}
+	return snapshots, nil
+}
+
+// DeleteSnapshot removes a snapshot
+func (m *MemoryAdapter) DeleteSnapshot(ctx context.Context, snapshotID string) (bool, error) {
+	if err := m.lock(); err != nil {
+		return false, err
+	}
+	defer m.mu.Unlock()
+
+	n := len(m.snapshots)
+	m.snapshots = removeWhere(m.snapshots, func(s *SnapshotEntry) bool { return s.ID == snapshotID })
+	return len(m.snapshots) < n, nil
+}
+
+// SaveAuditEvent records a security event, setting its ID and, when it has
+// none, its time
+func (m *MemoryAdapter) SaveAuditEvent(ctx context.Context, event *AuditEventEntry) (*AuditEventEntry, error) {
+	details, err := jsonCopy(event.Details)
+	if err != nil {
+		return nil, NewQueryError("failed to marshal audit details", err)
+	}
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	event.ID = newMemoryID()
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = m.now()
+	}
+	stored := *event
+	stored.Details = details
+	m.audit = append(m.audit, &stored)
+	return event, nil
+}
+
+// SaveTextDocument saves a SyncText (Fugue CRDT) document as a document
+// whose state has type "text", as PostgresAdapter does
+func (m *MemoryAdapter) SaveTextDocument(ctx context.Context, id, content, crdtState string, clock int64) (*TextDocumentState, error) {
+	doc, err := m.SaveDocument(ctx, id, map[string]interface{}{
+		"type":    "text",
+		"content": content,
+		"crdt":    crdtState,
+		"clock":   clock,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TextDocumentState{
+		ID:        id,
+		Content:   content,
+		CRDTState: crdtState,
+		Clock:     clock,
+		CreatedAt: doc.CreatedAt,
+		UpdatedAt: doc.UpdatedAt,
+	}, nil
+}
+
+// GetTextDocument retrieves a SyncText document, or nil if there is none or
+// the document is not a text document
+func (m *MemoryAdapter) GetTextDocument(ctx context.Context, id string) (*TextDocumentState, error) {
+	doc, err := m.GetDocument(ctx, id)
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	if doc.State["type"] != "text" || doc.State["crdt"] == nil {
+		return nil, nil
+	}
+
+	textDoc := &TextDocumentState{ID: id, CreatedAt: doc.CreatedAt, UpdatedAt: doc.UpdatedAt}
+	textDoc.Content, _ = doc.State["content"].(string)
+	textDoc.CRDTState, _ = doc.State["crdt"].(string)
+	if clock, ok := doc.State["clock"].(float64); ok {
+		textDoc.Clock = int64(clock)
+	}
+	return textDoc, nil
+}
+
+// Cleanup removes old data based on options, with PostgresAdapter's
+// defaults when options is nil
+func (m *MemoryAdapter) Cleanup(ctx context.Context, options *CleanupOptions) (*CleanupResult, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	if options == nil {
+		options = &CleanupOptions{
+			OldSessionsHours:        24,
+			OldDeltasDays:           30,
+			MaxSnapshotsPerDocument: 10,
+		}
+	}
+	now := time.Now()
+	result := &CleanupResult{}
+
+	if options.OldSessionsHours > 0 {
+		cutoff := now.Add(-time.Duration(options.OldSessionsHours) * time.Hour)
+		for id, session := range m.sessions {
+			if session.LastSeen.Before(cutoff) {
+				delete(m.sessions, id)
+				result.SessionsDeleted++
+			}
+		}
+	}
+
+	if options.OldDeltasDays > 0 {
+		cutoff := now.AddDate(0, 0, -options.OldDeltasDays)
+		n := len(m.deltas)
+		m.deltas = removeWhere(m.deltas, func(d *DeltaEntry) bool { return d.Timestamp.Before(cutoff) })
+		result.DeltasDeleted = n - len(m.deltas)
+	}
+
+	if options.MaxSnapshotsPerDocument > 0 {
+		// Snapshots are kept oldest first, so count from the newest
+		kept := make(map[string]int)
+		keep := make(map[*SnapshotEntry]bool, len(m.snapshots))
+		for i := len(m.snapshots) - 1; i >= 0; i-- {
+			s := m.snapshots[i]
+			if kept[s.DocumentID] < options.MaxSnapshotsPerDocument {
+				kept[s.DocumentID]++
+				keep[s] = true
+			}
+		}
+		n := len(m.snapshots)
+		m.snapshots = removeWhere(m.snapshots, func(s *SnapshotEntry) bool { return !keep[s] })
+		result.SnapshotsDeleted = n - len(m.snapshots)
+	}
+
+	if options.OldAuditEventsDays > 0 {
+		cutoff := now.AddDate(0, 0, -options.OldAuditEventsDays)
+		n := len(m.audit)
+		m.audit = removeWhere(m.audit, func(e *AuditEventEntry) bool { return e.CreatedAt.Before(cutoff) })
+		result.AuditEventsDeleted = n - len(m.audit)
+	}
+
+	return result, nil
+}
+
+// ListDocumentIDs returns up to limit document IDs greater than after, in
+// order
+func (m *MemoryAdapter) ListDocumentIDs(ctx context.Context, after string, limit int) ([]string, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	ids := make([]string, 0)
+	for id := range m.documents {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids[:min(limit, len(ids))], nil
+}
+
+// RestoreDocument writes a backed up document and replaces its vector
+// clock, leaving an existing document alone unless overwrite is set
+func (m *MemoryAdapter) RestoreDocument(ctx context.Context, doc *DocumentState, clock map[string]int64, overwrite bool) (bool, error) {
+	state, err := jsonCopy(doc.State)
+	if err != nil {
+		return false, NewQueryError("failed to marshal state", err)
+	}
+	if err := m.lock(); err != nil {
+		return false, err
+	}
+	defer m.mu.Unlock()
+
+	if m.documents[doc.ID] != nil && !overwrite {
+		return false, nil
+	}
+	restored := *doc
+	restored.State = state
+	m.documents[doc.ID] = &restored
+	m.clocks[doc.ID] = copyClock(clock)
+	return true, nil
+}
+
+// RestoreDelta writes a backed up delta with its ID and timestamp, in time
+// order among the others
+func (m *MemoryAdapter) RestoreDelta(ctx context.Context, delta *DeltaEntry, overwrite bool) (bool, error) {
+	value, err := jsonCopy(delta.Value)
+	if err != nil {
+		return false, NewQueryError("failed to marshal delta value", err)
+	}
+	if err := m.lock(); err != nil {
+		return false, err
+	}
+	defer m.mu.Unlock()
+
+	key := func(d *DeltaEntry) PageKey { return PageKey{Timestamp: d.Timestamp, ID: d.ID} }
+	stored := *delta
+	stored.Value = value
+	var ok bool
+	m.deltas, ok = restoreSorted(m.deltas, &stored, key, overwrite)
+	m.last = latest(m.last, delta.Timestamp)
+	return ok, nil
+}
+
+// RestoreSnapshot writes a backed up snapshot with its ID and creation
+// time, in time order among the others
+func (m *MemoryAdapter) RestoreSnapshot(ctx context.Context, snapshot *SnapshotEntry, overwrite bool) (bool, error) {
+	state, err := jsonCopy(snapshot.State)
+	if err != nil {
+		return false, NewQueryError("failed to marshal state", err)
+	}
+	if err := m.lock(); err != nil {
+		return false, err
+	}
+	defer m.mu.Unlock()
+
+	key := func(s *SnapshotEntry) PageKey { return PageKey{Timestamp: s.CreatedAt, ID: s.ID} }
+	stored := *snapshot
+	stored.State = state
+	stored.Version = copyClock(snapshot.Version)
+	var ok bool
+	m.snapshots, ok = restoreSorted(m.snapshots, &stored, key, overwrite)
+	m.last = latest(m.last, snapshot.CreatedAt)
+	return ok, nil
+}
+
+// restoreSorted puts item into items, which are ordered by key, replacing
+// the item with the same ID only when overwrite is set
+func restoreSorted[T any](items []T, item T, key func(T) PageKey, overwrite bool) ([]T, bool) {
+	id := key(item).ID
+	for i, existing := range items {
+		if key(existing).ID == id {
+			if !overwrite {
+				return items, false
+			}
+			items = append(items[:i], items[i+1:]...)
+			break
+		}
+	}
+	i := sort.Search(len(items), func(i int) bool { return pageKeyLess(key(item), key(items[i])) })
+	items = append(items, item)
+	copy(items[i+1:], items[i:])
+	items[i] = item
+	return items, true
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// newMemoryID returns a random UUID, the form PostgreSQL gives IDs
+func newMemoryID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	s := hex.EncodeToString(b[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// pageKeyLess orders page keys by time, then ID
+func pageKeyLess(a, b PageKey) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	return a.ID < b.ID
+}
+
+// jsonCopy copies v through JSON, so numbers come back as float64 as they
+// do from a JSONB column
+func jsonCopy(v map[string]interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var copied map[string]interface{}
+	err = codec.Unmarshal(data, &copied)
+	return copied, err
+}
+
+func copyDocument(doc *DocumentState) *DocumentState {
+	copied := *doc
+	copied.State, _ = jsonCopy(doc.State)
+	return &copied
+}
+
+func copyDelta(delta *DeltaEntry) *DeltaEntry {
+	copied := *delta
+	copied.Value, _ = jsonCopy(delta.Value)
+	return &copied
+}
+
+func copySnapshot(snapshot *SnapshotEntry) *SnapshotEntry {
+	copied := *snapshot
+	copied.State, _ = jsonCopy(snapshot.State)
+	copied.Version = copyClock(snapshot.Version)
+	return &copied
+}
+
+func copyClock(clock map[string]int64) map[string]int64 {
+	if clock == nil {
+		return nil
+	}
+	copied := make(map[string]int64, len(clock))
+	for k, v := range clock {
+		copied[k] = v
+	}
+	return copied
+}
+
+// removeWhere removes the elements for which drop is true, in place
+func removeWhere[T any](items []T, drop func(T) bool) []T {
+	kept := items[:0]
+	for _, item := range items {
+		if !drop(item) {
+			kept = append(kept, item)
+		}
+	}
+	clear(items[len(kept):])
+	return kept
+}
+
+// pageOf returns items[offset:offset+limit], clamped to the slice
+func pageOf[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	return items[offset:min(len(items), offset+limit)]
+}
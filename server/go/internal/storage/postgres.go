@@ -2,10 +2,10 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -68,6 +68,21 @@ func (p *PostgresAdapter) IsConnected() bool {
 	return p.connected && p.pool != nil
 }
 
+// PoolStats reports the connection pool, once connected
+func (p *PostgresAdapter) PoolStats() (PoolStats, bool) {
+	if p.pool == nil {
+		return PoolStats{}, false
+	}
+	stat := p.pool.Stat()
+	return PoolStats{
+		TotalConns:    stat.TotalConns(),
+		IdleConns:     stat.IdleConns(),
+		AcquiredConns: stat.AcquiredConns(),
+		MaxConns:      stat.MaxConns(),
+		AcquireCount:  stat.AcquireCount(),
+	}, true
+}
+
 // HealthCheck verifies database connectivity
 func (p *PostgresAdapter) HealthCheck(ctx context.Context) (bool, error) {
 	if !p.IsConnected() {
@@ -97,7 +112,7 @@ func (p *PostgresAdapter) GetDocument(ctx context.Context, id string) (*Document
 		return nil, NewQueryError("failed to get document", err)
 	}
 
-	if err := json.Unmarshal(stateJSON, &doc.State); err != nil {
+	if err := codec.Unmarshal(stateJSON, &doc.State); err != nil {
 		return nil, NewQueryError("failed to unmarshal state", err)
 	}
 
@@ -110,7 +125,7 @@ func (p *PostgresAdapter) SaveDocument(ctx context.Context, id string, state map
 		return nil, ErrNotConnected
 	}
 
-	stateJSON, err := json.Marshal(state)
+	stateJSON, err := codec.Marshal(state)
 	if err != nil {
 		return nil, NewQueryError("failed to marshal state", err)
 	}
@@ -133,7 +148,7 @@ func (p *PostgresAdapter) SaveDocument(ctx context.Context, id string, state map
 		return nil, NewQueryError("failed to save document", err)
 	}
 
-	if err := json.Unmarshal(returnedStateJSON, &doc.State); err != nil {
+	if err := codec.Unmarshal(returnedStateJSON, &doc.State); err != nil {
 		return nil, NewQueryError("failed to unmarshal state", err)
 	}
 
@@ -146,7 +161,7 @@ func (p *PostgresAdapter) UpdateDocument(ctx context.Context, id string, state m
 		return nil, ErrNotConnected
 	}
 
-	stateJSON, err := json.Marshal(state)
+	stateJSON, err := codec.Marshal(state)
 	if err != nil {
 		return nil, NewQueryError("failed to marshal state", err)
 	}
@@ -171,7 +186,7 @@ func (p *PostgresAdapter) UpdateDocument(ctx context.Context, id string, state m
 		return nil, NewQueryError("failed to update document", err)
 	}
 
-	if err := json.Unmarshal(returnedStateJSON, &doc.State); err != nil {
+	if err := codec.Unmarshal(returnedStateJSON, &doc.State); err != nil {
 		return nil, NewQueryError("failed to unmarshal state", err)
 	}
 
@@ -223,7 +238,7 @@ func (p *PostgresAdapter) ListDocuments(ctx context.Context, limit, offset int)
 			return nil, NewQueryError("failed to scan document", err)
 		}
 
-		if err := json.Unmarshal(stateJSON, &doc.State); err != nil {
+		if err := codec.Unmarshal(stateJSON, &doc.State); err != nil {
 			return nil, NewQueryError("failed to unmarshal state", err)
 		}
 
@@ -317,7 +332,7 @@ func (p *PostgresAdapter) SaveDelta(ctx context.Context, delta *DeltaEntry) (*De
 		return nil, ErrNotConnected
 	}
 
-	valueJSON, err := json.Marshal(delta.Value)
+	valueJSON, err := codec.Marshal(delta.Value)
 	if err != nil {
 		return nil, NewQueryError("failed to marshal delta value", err)
 	}
@@ -372,7 +387,7 @@ func (p *PostgresAdapter) GetDeltas(ctx context.Context, documentID string, limi
 		}
 
 		if valueJSON != nil {
-			if err := json.Unmarshal(valueJSON, &delta.Value); err != nil {
+			if err := codec.Unmarshal(valueJSON, &delta.Value); err != nil {
 				return nil, NewQueryError("failed to unmarshal delta value", err)
 			}
 		}
@@ -383,13 +398,67 @@ func (p *PostgresAdapter) GetDeltas(ctx context.Context, documentID string, limi
 	return deltas, nil
 }
 
+// QueryDeltas retrieves a page of a document's deltas in timestamp order
+func (p *PostgresAdapter) QueryDeltas(ctx context.Context, documentID string, q DeltaQuery) ([]*DeltaEntry, error) {
+	if !p.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = 100
+	}
+
+	query := `
+		SELECT id, document_id, client_id, operation_type, field_path, value, clock_value, timestamp
+		FROM deltas
+		WHERE document_id = $1
+	`
+	args := []interface{}{documentID}
+	if !q.Since.IsZero() {
+		args = append(args, q.Since)
+		query += fmt.Sprintf(" AND timestamp >= $%d", len(args))
+	}
+	if q.After != nil {
+		args = append(args, q.After.Timestamp, q.After.ID)
+		query += fmt.Sprintf(" AND (timestamp, id) > ($%d, $%d::uuid)", len(args)-1, len(args))
+	}
+	args = append(args, q.Limit)
+	query += fmt.Sprintf(" ORDER BY timestamp ASC, id ASC LIMIT $%d", len(args))
+
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, NewQueryError("failed to query deltas", err)
+	}
+	defer rows.Close()
+
+	deltas := make([]*DeltaEntry, 0)
+	for rows.Next() {
+		var delta DeltaEntry
+		var valueJSON []byte
+
+		if err := rows.Scan(&delta.ID, &delta.DocumentID, &delta.ClientID, &delta.OperationType, &delta.FieldPath, &valueJSON, &delta.ClockValue, &delta.Timestamp); err != nil {
+			return nil, NewQueryError("failed to scan delta", err)
+		}
+
+		if valueJSON != nil {
+			if err := codec.Unmarshal(valueJSON, &delta.Value); err != nil {
+				return nil, NewQueryError("failed to unmarshal delta value", err)
+			}
+		}
+
+		deltas = append(deltas, &delta)
+	}
+
+	return deltas, rows.Err()
+}
+
 // SaveSession saves a connection session
 func (p *PostgresAdapter) SaveSession(ctx context.Context, session *SessionEntry) (*SessionEntry, error) {
 	if !p.IsConnected() {
 		return nil, ErrNotConnected
 	}
 
-	metadataJSON, err := json.Marshal(session.Metadata)
+	metadataJSON, err := codec.Marshal(session.Metadata)
 	if err != nil {
 		return nil, NewQueryError("failed to marshal metadata", err)
 	}
@@ -410,6 +479,37 @@ func (p *PostgresAdapter) SaveSession(ctx context.Context, session *SessionEntry
 	return session, nil
 }
 
+// SaveAuditEvent records a security event
+func (p *PostgresAdapter) SaveAuditEvent(ctx context.Context, event *AuditEventEntry) (*AuditEventEntry, error) {
+	if !p.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	detailsJSON, err := codec.Marshal(event.Details)
+	if err != nil {
+		return nil, NewQueryError("failed to marshal audit details", err)
+	}
+	createdAt := event.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO audit_events (event_type, actor, document_id, connection_id, ip, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+
+	row := p.pool.QueryRow(ctx, query, event.EventType, event.Actor, event.DocumentID, event.ConnectionID, event.IP, detailsJSON, createdAt)
+
+	err = row.Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return nil, NewQueryError("failed to save audit event", err)
+	}
+
+	return event, nil
+}
+
 // UpdateSession updates a session's last seen time
 func (p *PostgresAdapter) UpdateSession(ctx context.Context, sessionID string, lastSeen time.Time, metadata map[string]interface{}) error {
 	if !p.IsConnected() {
@@ -420,7 +520,7 @@ func (p *PostgresAdapter) UpdateSession(ctx context.Context, sessionID string, l
 	var args []interface{}
 
 	if metadata != nil {
-		metadataJSON, err := json.Marshal(metadata)
+		metadataJSON, err := codec.Marshal(metadata)
 		if err != nil {
 			return NewQueryError("failed to marshal metadata", err)
 		}
@@ -481,7 +581,7 @@ func (p *PostgresAdapter) GetSessions(ctx context.Context, userID string) ([]*Se
 		}
 
 		if metadataJSON != nil {
-			if err := json.Unmarshal(metadataJSON, &session.Metadata); err != nil {
+			if err := codec.Unmarshal(metadataJSON, &session.Metadata); err != nil {
 				return nil, NewQueryError("failed to unmarshal metadata", err)
 			}
 		}
@@ -498,12 +598,12 @@ func (p *PostgresAdapter) SaveSnapshot(ctx context.Context, snapshot *SnapshotEn
 		return nil, ErrNotConnected
 	}
 
-	stateJSON, err := json.Marshal(snapshot.State)
+	stateJSON, err := codec.Marshal(snapshot.State)
 	if err != nil {
 		return nil, NewQueryError("failed to marshal state", err)
 	}
 
-	versionJSON, err := json.Marshal(snapshot.Version)
+	versionJSON, err := codec.Marshal(snapshot.Version)
 	if err != nil {
 		return nil, NewQueryError("failed to marshal version", err)
 	}
@@ -591,11 +691,11 @@ func (p *PostgresAdapter) ListSnapshots(ctx context.Context, documentID string,
 			return nil, NewQueryError("failed to scan snapshot", err)
 		}
 
-		if err := json.Unmarshal(stateJSON, &snapshot.State); err != nil {
+		if err := codec.Unmarshal(stateJSON, &snapshot.State); err != nil {
 			return nil, NewQueryError("failed to unmarshal state", err)
 		}
 
-		if err := json.Unmarshal(versionJSON, &snapshot.Version); err != nil {
+		if err := codec.Unmarshal(versionJSON, &snapshot.Version); err != nil {
 			return nil, NewQueryError("failed to unmarshal version", err)
 		}
 
@@ -605,6 +705,61 @@ func (p *PostgresAdapter) ListSnapshots(ctx context.Context, documentID string,
 	return snapshots, nil
 }
 
+// QuerySnapshots retrieves a page of a document's snapshots, newest first
+func (p *PostgresAdapter) QuerySnapshots(ctx context.Context, documentID string, q SnapshotQuery) ([]*SnapshotEntry, error) {
+	if !p.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = 10
+	}
+
+	query := `
+		SELECT id, document_id, state, version, size_bytes, compressed, created_at
+		FROM snapshots
+		WHERE document_id = $1
+	`
+	args := []interface{}{documentID}
+	if q.Before != nil {
+		args = append(args, q.Before.Timestamp, q.Before.ID)
+		query += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d::uuid)", len(args)-1, len(args))
+	}
+	args = append(args, q.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, NewQueryError("failed to query snapshots", err)
+	}
+	defer rows.Close()
+
+	snapshots := make([]*SnapshotEntry, 0)
+	for rows.Next() {
+		snapshot, err := p.scanSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+// DeleteDeltasBefore removes a document's deltas recorded before a time,
+// returning how many were deleted
+func (p *PostgresAdapter) DeleteDeltasBefore(ctx context.Context, documentID string, before time.Time) (int, error) {
+	if !p.IsConnected() {
+		return 0, ErrNotConnected
+	}
+
+	result, err := p.pool.Exec(ctx, "DELETE FROM deltas WHERE document_id = $1 AND timestamp < $2", documentID, before)
+	if err != nil {
+		return 0, NewQueryError("failed to delete deltas", err)
+	}
+	return int(result.RowsAffected()), nil
+}
+
 // DeleteSnapshot removes a snapshot
 func (p *PostgresAdapter) DeleteSnapshot(ctx context.Context, snapshotID string) (bool, error) {
 	if !p.IsConnected() {
@@ -632,7 +787,7 @@ func (p *PostgresAdapter) SaveTextDocument(ctx context.Context, id, content, crd
 		"clock":   clock,
 	}
 
-	stateJSON, err := json.Marshal(state)
+	stateJSON, err := codec.Marshal(state)
 	if err != nil {
 		return nil, NewQueryError("failed to marshal text state", err)
 	}
@@ -683,7 +838,7 @@ func (p *PostgresAdapter) GetTextDocument(ctx context.Context, id string) (*Text
 	}
 
 	var state map[string]interface{}
-	if err := json.Unmarshal(stateJSON, &state); err != nil {
+	if err := codec.Unmarshal(stateJSON, &state); err != nil {
 		return nil, NewQueryError("failed to unmarshal state", err)
 	}
 
@@ -770,6 +925,18 @@ func (p *PostgresAdapter) Cleanup(ctx context.Context, options *CleanupOptions)
 		}
 	}
 
+	// Clean old audit events
+	if options.OldAuditEventsDays > 0 {
+		auditQuery := fmt.Sprintf(
+			`DELETE FROM audit_events WHERE created_at < NOW() - INTERVAL '%d days'`,
+			options.OldAuditEventsDays,
+		)
+		r, err := p.pool.Exec(ctx, auditQuery)
+		if err == nil {
+			result.AuditEventsDeleted = int(r.RowsAffected())
+		}
+	}
+
 	return result, nil
 }
 
@@ -786,11 +953,11 @@ func (p *PostgresAdapter) scanSnapshot(row pgx.Row) (*SnapshotEntry, error) {
 		return nil, NewQueryError("failed to scan snapshot", err)
 	}
 
-	if err := json.Unmarshal(stateJSON, &snapshot.State); err != nil {
+	if err := codec.Unmarshal(stateJSON, &snapshot.State); err != nil {
 		return nil, NewQueryError("failed to unmarshal state", err)
 	}
 
-	if err := json.Unmarshal(versionJSON, &snapshot.Version); err != nil {
+	if err := codec.Unmarshal(versionJSON, &snapshot.Version); err != nil {
 		return nil, NewQueryError("failed to unmarshal version", err)
 	}
 
@@ -2,32 +2,66 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
 	"github.com/redis/go-redis/v9"
 )
 
 // RedisPubSub implements multi-server coordination via Redis pub/sub.
 // Matches TypeScript reference: server/typescript/src/storage/redis.ts
 type RedisPubSub struct {
-	publisher     *redis.Client
-	subscriber    *redis.Client
-	connected     bool
+	config        *RedisPubSubConfig
+	publisher     redis.UniversalClient
+	subscriber    redis.UniversalClient
+	connected     atomic.Bool // Last known state of the Redis connections
+	reconnects    atomic.Int64
 	channelPrefix string
 	handlers      map[string][]func([]byte)
 	handlersMu    sync.RWMutex
 	pubsubs       map[string]*redis.PubSub // Track active subscriptions
+	docPubSub     *redis.PubSub            // Pattern subscription serving every document channel; nil until the first
 	pubsubsMu     sync.RWMutex
+	workers       map[string][]*handlerWorker // channel -> workers running its handlers
+	workersMu     sync.Mutex
+	handlerPanics atomic.Int64
+	onReconnect   atomic.Pointer[func(documentIDs []string)]
 }
 
+// Backoff between attempts to restore a lost subscription
+const (
+	reconnectMinDelay = 100 * time.Millisecond
+	reconnectMaxDelay = 10 * time.Second
+)
+
 // RedisPubSubConfig holds Redis connection configuration
 type RedisPubSubConfig struct {
 	URL           string
 	ChannelPrefix string
 	MaxRetries    int
+
+	// HandlerWorkers caps how many handlers of one channel run at once;
+	// zero means DefaultHandlerWorkers
+	HandlerWorkers int
+
+	// Mode is RedisModeSingle (the default), RedisModeSentinel or
+	// RedisModeCluster. With sentinel or cluster, URL is optional and only
+	// supplies credentials, the database and TLS.
+	Mode           string
+	SentinelMaster string
+	SentinelAddrs  []string
+	ClusterAddrs   []string
+
+	// TLSCAFile is a PEM file of CAs to trust. Setting it, or
+	// TLSInsecureSkipVerify, enables TLS without a rediss:// URL.
+	TLSCAFile             string
+	TLSInsecureSkipVerify bool
 }
 
 // DefaultRedisPubSubConfig returns sensible defaults
@@ -44,19 +78,20 @@ func NewRedisPubSub(config *RedisPubSubConfig) (*RedisPubSub, error) {
 		config = DefaultRedisPubSubConfig()
 	}
 
-	opt, err := redis.ParseURL(config.URL)
+	publisher, err := newRedisClient(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+		return nil, err
 	}
-
-	opt.MaxRetries = config.MaxRetries
+	subscriber, _ := newRedisClient(config)
 
 	return &RedisPubSub{
-		publisher:     redis.NewClient(opt),
-		subscriber:    redis.NewClient(opt),
+		config:        config,
+		publisher:     publisher,
+		subscriber:    subscriber,
 		channelPrefix: config.ChannelPrefix,
 		handlers:      make(map[string][]func([]byte)),
 		pubsubs:       make(map[string]*redis.PubSub),
+		workers:       make(map[string][]*handlerWorker),
 	}, nil
 }
 
@@ -68,13 +103,13 @@ func (r *RedisPubSub) Connect(ctx context.Context) error {
 	if err := r.subscriber.Ping(ctx).Err(); err != nil {
 		return fmt.Errorf("failed to connect subscriber: %w", err)
 	}
-	r.connected = true
+	r.connected.Store(true)
 	return nil
 }
 
 // Disconnect closes Redis connections
 func (r *RedisPubSub) Disconnect(ctx context.Context) error {
-	r.connected = false
+	r.connected.Store(false)
 
 	// Close all pubsub subscriptions
 	r.pubsubsMu.Lock()
@@ -82,6 +117,10 @@ func (r *RedisPubSub) Disconnect(ctx context.Context) error {
 		ps.Close()
 	}
 	r.pubsubs = make(map[string]*redis.PubSub)
+	if r.docPubSub != nil {
+		r.docPubSub.Close()
+		r.docPubSub = nil
+	}
 	r.pubsubsMu.Unlock()
 
 	// Close client connections
@@ -90,14 +129,32 @@ func (r *RedisPubSub) Disconnect(ctx context.Context) error {
 	return nil
 }
 
-// IsConnected returns connection status
+// IsConnected reports whether Redis was reachable on the last publish,
+// health check or subscription read
 func (r *RedisPubSub) IsConnected() bool {
-	return r.connected
+	return r.connected.Load()
+}
+
+// OnReconnect sets fn to be called when subscriptions to document channels
+// are restored after Redis was unreachable, with the documents whose
+// messages may have been missed meanwhile. fn runs on the subscription's
+// goroutine before any further message for those documents is delivered.
+func (r *RedisPubSub) OnReconnect(fn func(documentIDs []string)) {
+	r.onReconnect.Store(&fn)
 }
 
-// HealthCheck verifies Redis connectivity
+// HealthCheck verifies Redis connectivity; in cluster mode every shard
+// must answer
 func (r *RedisPubSub) HealthCheck(ctx context.Context) (bool, error) {
-	err := r.publisher.Ping(ctx).Err()
+	var err error
+	if cluster, ok := r.publisher.(*redis.ClusterClient); ok {
+		err = cluster.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+			return shard.Ping(ctx).Err()
+		})
+	} else {
+		err = r.publisher.Ping(ctx).Err()
+	}
+	r.connected.Store(err == nil)
 	return err == nil, err
 }
 
@@ -111,16 +168,54 @@ func (r *RedisPubSub) PublishDelta(ctx context.Context, documentID string, delta
 	return r.publish(ctx, channel, delta)
 }
 
-// SubscribeToDocument subscribes to document updates
+// PublishAwareness publishes an awareness update to a document channel
+func (r *RedisPubSub) PublishAwareness(ctx context.Context, documentID string, state interface{}) error {
+	return r.publish(ctx, r.getDocumentChannel(documentID), state)
+}
+
+// SubscribeToDocument subscribes to document updates. All document channels
+// share one pattern subscription and goroutine; messages are handed to the
+// handlers of the channel they were published on.
 func (r *RedisPubSub) SubscribeToDocument(ctx context.Context, documentID string, handler func([]byte)) error {
+	if err := r.subscribeDocuments(ctx); err != nil {
+		return err
+	}
 	channel := r.getDocumentChannel(documentID)
-	return r.subscribe(ctx, channel, handler)
+	r.handlersMu.Lock()
+	r.handlers[channel] = append(r.handlers[channel], handler)
+	r.handlersMu.Unlock()
+	return nil
 }
 
-// UnsubscribeFromDocument unsubscribes from document updates
+// UnsubscribeFromDocument unsubscribes from document updates. The pattern
+// subscription stays for the other documents.
 func (r *RedisPubSub) UnsubscribeFromDocument(ctx context.Context, documentID string) error {
 	channel := r.getDocumentChannel(documentID)
-	return r.unsubscribe(ctx, channel)
+	r.handlersMu.Lock()
+	delete(r.handlers, channel)
+	r.handlersMu.Unlock()
+	r.stopWorkers(channel)
+	return nil
+}
+
+// subscribeDocuments makes the pattern subscription to every document
+// channel, once
+func (r *RedisPubSub) subscribeDocuments(ctx context.Context) error {
+	r.pubsubsMu.Lock()
+	defer r.pubsubsMu.Unlock()
+	if r.docPubSub != nil {
+		return nil
+	}
+
+	pattern := r.getDocumentChannel("*")
+	pubsub := r.subscriber.PSubscribe(ctx, pattern)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", pattern, err)
+	}
+	r.docPubSub = pubsub
+	go r.handleMessages(pubsub, r.documentsReconnected)
+	return nil
 }
 
 // ==========================================================================
@@ -148,7 +243,7 @@ func (r *RedisPubSub) SubscribeToBroadcast(ctx context.Context, handler func(eve
 	channel := r.getBroadcastChannel()
 	return r.subscribe(ctx, channel, func(data []byte) {
 		var evt BroadcastEvent
-		if err := json.Unmarshal(data, &evt); err == nil {
+		if err := codec.Unmarshal(data, &evt); err == nil {
 			handler(evt.Event, evt.Data)
 		}
 	})
@@ -194,7 +289,7 @@ func (r *RedisPubSub) SubscribeToPresence(ctx context.Context, handler func(even
 	channel := r.getPresenceChannel()
 	return r.subscribe(ctx, channel, func(data []byte) {
 		var evt PresenceEvent
-		if err := json.Unmarshal(data, &evt); err == nil {
+		if err := codec.Unmarshal(data, &evt); err == nil {
 			var eventType string
 			if evt.Type == "server_online" {
 				eventType = "online"
@@ -214,11 +309,15 @@ func (r *RedisPubSub) SubscribeToPresence(ctx context.Context, handler func(even
 
 // publish sends data to a channel
 func (r *RedisPubSub) publish(ctx context.Context, channel string, data interface{}) error {
-	jsonData, err := json.Marshal(data)
+	jsonData, err := codec.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal data: %w", err)
 	}
-	return r.publisher.Publish(ctx, channel, jsonData).Err()
+	err = r.publisher.Publish(ctx, channel, jsonData).Err()
+	if err == nil || isNetworkError(err) {
+		r.connected.Store(err == nil)
+	}
+	return err
 }
 
 // subscribe registers a handler for a channel
@@ -232,12 +331,22 @@ func (r *RedisPubSub) subscribe(ctx context.Context, channel string, handler fun
 	if isFirstHandler {
 		pubsub := r.subscriber.Subscribe(ctx, channel)
 
+		// Wait for Redis to confirm, so nothing published after we return
+		// is missed
+		if _, err := pubsub.Receive(ctx); err != nil {
+			pubsub.Close()
+			r.handlersMu.Lock()
+			delete(r.handlers, channel)
+			r.handlersMu.Unlock()
+			return fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+		}
+
 		r.pubsubsMu.Lock()
 		r.pubsubs[channel] = pubsub
 		r.pubsubsMu.Unlock()
 
 		// Start message handler goroutine
-		go r.handleMessages(channel, pubsub)
+		go r.handleMessages(pubsub, func() {})
 	}
 
 	return nil
@@ -248,6 +357,7 @@ func (r *RedisPubSub) unsubscribe(ctx context.Context, channel string) error {
 	r.handlersMu.Lock()
 	delete(r.handlers, channel)
 	r.handlersMu.Unlock()
+	r.stopWorkers(channel)
 
 	r.pubsubsMu.Lock()
 	if ps, ok := r.pubsubs[channel]; ok {
@@ -260,25 +370,78 @@ func (r *RedisPubSub) unsubscribe(ctx context.Context, channel string) error {
 	return nil
 }
 
-// handleMessages processes incoming messages for a channel
-func (r *RedisPubSub) handleMessages(channel string, pubsub *redis.PubSub) {
-	ch := pubsub.Channel()
-	for msg := range ch {
-		r.handlersMu.RLock()
-		handlers := r.handlers[channel]
-		r.handlersMu.RUnlock()
-
-		for _, handler := range handlers {
-			go func(h func([]byte)) {
-				defer func() {
-					if r := recover(); r != nil {
-						// Log panic but don't crash
-					}
-				}()
-				h([]byte(msg.Payload))
-			}(handler)
+// handleMessages processes incoming messages for a subscription, handing
+// each to the workers of the channel it was published on. If the
+// connection is lost it is retried with exponential backoff; go-redis
+// resubscribes on the new connection, after which recovered is called.
+func (r *RedisPubSub) handleMessages(pubsub *redis.PubSub, recovered func()) {
+	delay := reconnectMinDelay
+	lost := false
+	for {
+		msg, err := pubsub.Receive(context.Background())
+		if err != nil {
+			if errors.Is(err, redis.ErrClosed) {
+				// Unsubscribed or disconnected
+				return
+			}
+			if !lost {
+				lost = true
+				r.connected.Store(false)
+			}
+			time.Sleep(delay)
+			delay = min(delay*2, reconnectMaxDelay)
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			// The confirmation of a resubscription
+			if lost {
+				lost = false
+				delay = reconnectMinDelay
+				r.connected.Store(true)
+				r.reconnects.Add(1)
+				recovered()
+			}
+		case *redis.Message:
+			r.handlersMu.RLock()
+			handlers := r.handlers[msg.Channel]
+			r.handlersMu.RUnlock()
+
+			if len(handlers) > 0 {
+				r.dispatch(msg.Channel, handlers, []byte(msg.Payload))
+			}
+		}
+	}
+}
+
+// documentsReconnected tells the OnReconnect callback about every document
+// with handlers, since any of them may have missed messages
+func (r *RedisPubSub) documentsReconnected() {
+	fn := r.onReconnect.Load()
+	if fn == nil {
+		return
+	}
+	docPrefix := r.getDocumentChannel("")
+	var documentIDs []string
+	r.handlersMu.RLock()
+	for channel := range r.handlers {
+		if strings.HasPrefix(channel, docPrefix) {
+			documentIDs = append(documentIDs, strings.TrimPrefix(channel, docPrefix))
 		}
 	}
+	r.handlersMu.RUnlock()
+	if len(documentIDs) > 0 {
+		sort.Strings(documentIDs)
+		(*fn)(documentIDs)
+	}
+}
+
+// isNetworkError reports whether err means Redis could not be reached, as
+// opposed to Redis rejecting the command
+func isNetworkError(err error) bool {
+	var redisErr redis.Error
+	return !errors.As(err, &redisErr)
 }
 
 // ==========================================================================
@@ -303,9 +466,12 @@ func (r *RedisPubSub) getPresenceChannel() string {
 
 // Stats holds pub/sub statistics
 type Stats struct {
-	Connected          bool `json:"connected"`
-	SubscribedChannels int  `json:"subscribedChannels"`
-	TotalHandlers      int  `json:"totalHandlers"`
+	Connected          bool   `json:"connected"`
+	SubscribedChannels int    `json:"subscribedChannels"`
+	TotalHandlers      int    `json:"totalHandlers"`
+	Reconnects         int64  `json:"reconnects"`     // Subscriptions restored after Redis was unreachable
+	Mode               string `json:"mode,omitempty"` // Redis deployment; empty for other brokers
+	HandlerPanics      int64  `json:"handlerPanics"`  // Handler calls that panicked and were recovered
 }
 
 // GetStats returns pub/sub statistics
@@ -319,8 +485,18 @@ func (r *RedisPubSub) GetStats() Stats {
 	}
 
 	return Stats{
-		Connected:          r.connected,
+		Connected:          r.connected.Load(),
 		SubscribedChannels: len(r.handlers),
 		TotalHandlers:      totalHandlers,
+		Reconnects:         r.reconnects.Load(),
+		Mode:               r.mode(),
+		HandlerPanics:      r.handlerPanics.Load(),
+	}
+}
+
+func (r *RedisPubSub) mode() string {
+	if r.config.Mode == "" {
+		return RedisModeSingle
 	}
+	return r.config.Mode
 }
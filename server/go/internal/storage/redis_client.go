@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis deployments RedisPubSub can connect to
+const (
+	RedisModeSingle   = "single"
+	RedisModeSentinel = "sentinel"
+	RedisModeCluster  = "cluster"
+)
+
+// newRedisClient connects to the deployment config describes: a single
+// node from URL, a Sentinel-managed master, or a cluster. With sentinel or
+// cluster, URL is optional and supplies credentials, the database and TLS.
+func newRedisClient(config *RedisPubSubConfig) (redis.UniversalClient, error) {
+	base := &redis.Options{}
+	if config.URL != "" {
+		opt, err := redis.ParseURL(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+		}
+		base = opt
+	} else if config.Mode == "" || config.Mode == RedisModeSingle {
+		return nil, errors.New("Redis URL is required")
+	}
+	base.MaxRetries = config.MaxRetries
+
+	tlsConfig, err := redisTLSConfig(config, base.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil && config.Mode != "" && config.Mode != RedisModeSingle {
+		// Verify each node against its own address, not the URL's host
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = ""
+	}
+
+	switch config.Mode {
+	case "", RedisModeSingle:
+		base.TLSConfig = tlsConfig
+		return redis.NewClient(base), nil
+
+	case RedisModeSentinel:
+		if config.SentinelMaster == "" || len(config.SentinelAddrs) == 0 {
+			return nil, errors.New("sentinel mode requires a master name and sentinel addresses")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    config.SentinelMaster,
+			SentinelAddrs: config.SentinelAddrs,
+			Username:      base.Username,
+			Password:      base.Password,
+			DB:            base.DB,
+			MaxRetries:    base.MaxRetries,
+			TLSConfig:     tlsConfig,
+		}), nil
+
+	case RedisModeCluster:
+		if len(config.ClusterAddrs) == 0 {
+			return nil, errors.New("cluster mode requires cluster addresses")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:      config.ClusterAddrs,
+			Username:   base.Username,
+			Password:   base.Password,
+			MaxRetries: base.MaxRetries,
+			TLSConfig:  tlsConfig,
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown Redis mode %q", config.Mode)
+}
+
+// redisTLSConfig applies the TLS options to the TLS config from a rediss://
+// URL, if any. It returns nil when TLS is not wanted.
+func redisTLSConfig(config *RedisPubSubConfig, fromURL *tls.Config) (*tls.Config, error) {
+	if config.TLSCAFile == "" && !config.TLSInsecureSkipVerify {
+		return fromURL, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if fromURL != nil {
+		tlsConfig = fromURL.Clone()
+	}
+	if config.TLSCAFile != "" {
+		pem, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in Redis CA file %s", config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	tlsConfig.InsecureSkipVerify = config.TLSInsecureSkipVerify
+	return tlsConfig, nil
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewRedisClient_Modes(t *testing.T) {
+	sentinel, err := newRedisClient(&RedisPubSubConfig{
+		URL:            "redis://:secret@ignored:6379/2",
+		Mode:           RedisModeSentinel,
+		SentinelMaster: "mymaster",
+		SentinelAddrs:  []string{"sentinel-1:26379", "sentinel-2:26379"},
+	})
+	if err != nil {
+		t.Fatalf("sentinel: %v", err)
+	}
+	defer sentinel.Close()
+	if opt := sentinel.(*redis.Client).Options(); opt.Password != "secret" || opt.DB != 2 {
+		t.Errorf("sentinel password %q, db %d; want the URL's", opt.Password, opt.DB)
+	}
+
+	cluster, err := newRedisClient(&RedisPubSubConfig{
+		Mode:         RedisModeCluster,
+		ClusterAddrs: []string{"node-1:6379", "node-2:6379"},
+	})
+	if err != nil {
+		t.Fatalf("cluster: %v", err)
+	}
+	defer cluster.Close()
+	if addrs := cluster.(*redis.ClusterClient).Options().Addrs; len(addrs) != 2 {
+		t.Errorf("cluster addrs = %v, want both nodes", addrs)
+	}
+
+	for name, config := range map[string]*RedisPubSubConfig{
+		"single without URL":      {},
+		"sentinel without master": {Mode: RedisModeSentinel, SentinelAddrs: []string{"sentinel-1:26379"}},
+		"cluster without addrs":   {Mode: RedisModeCluster},
+		"unknown mode":            {URL: "redis://localhost:6379", Mode: "ring"},
+		"missing CA file":         {URL: "redis://localhost:6379", TLSCAFile: filepath.Join(t.TempDir(), "ca.pem")},
+	} {
+		if client, err := newRedisClient(config); err == nil {
+			client.Close()
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestNewRedisClient_VerifiesTLSAgainstCAFile(t *testing.T) {
+	caFile, serverCert := issueRedisCert(t)
+	mr, err := miniredis.RunTLS(&tls.Config{Certificates: []tls.Certificate{serverCert}})
+	if err != nil {
+		t.Fatalf("RunTLS: %v", err)
+	}
+	defer mr.Close()
+
+	ctx := context.Background()
+	client, err := newRedisClient(&RedisPubSubConfig{URL: "rediss://" + mr.Addr(), TLSCAFile: caFile})
+	if err != nil {
+		t.Fatalf("newRedisClient: %v", err)
+	}
+	defer client.Close()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Errorf("PING with the CA file: %v", err)
+	}
+
+	untrusted, _ := newRedisClient(&RedisPubSubConfig{URL: "rediss://" + mr.Addr(), MaxRetries: -1})
+	defer untrusted.Close()
+	if err := untrusted.Ping(ctx).Err(); err == nil {
+		t.Error("PING without the CA file succeeded, want a verification error")
+	}
+}
+
+// issueRedisCert writes a self-signed certificate for 127.0.0.1 to a CA
+// file and returns it with the server's key pair
+func issueRedisCert(t *testing.T) (string, tls.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "redis"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, certPEM, 0o600); err != nil {
+		t.Fatalf("write CA file: %v", err)
+	}
+	return caFile, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
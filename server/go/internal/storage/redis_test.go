@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisPubSub_ResubscribesAfterRedisRestarts(t *testing.T) {
+	mr := miniredis.RunT(t)
+	pubsub, err := NewRedisPubSub(&RedisPubSubConfig{URL: "redis://" + mr.Addr(), ChannelPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("NewRedisPubSub: %v", err)
+	}
+	ctx := context.Background()
+	if err := pubsub.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer pubsub.Disconnect(ctx)
+
+	received := make(chan string, 4)
+	gaps := make(chan []string, 4)
+	pubsub.OnReconnect(func(documentIDs []string) { gaps <- documentIDs })
+	if err := pubsub.SubscribeToDocument(ctx, "doc-1", func(data []byte) { received <- string(data) }); err != nil {
+		t.Fatalf("SubscribeToDocument: %v", err)
+	}
+
+	mr.Close()
+	waitFor(t, "disconnect to be noticed", func() bool { return !pubsub.IsConnected() })
+
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	select {
+	case docs := <-gaps:
+		if len(docs) != 1 || docs[0] != "doc-1" {
+			t.Errorf("reconnect documents = %v, want [doc-1]", docs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnReconnect was not called")
+	}
+	if !pubsub.IsConnected() {
+		t.Error("IsConnected = false after the subscription recovered")
+	}
+	if n := pubsub.GetStats().Reconnects; n != 1 {
+		t.Errorf("Reconnects = %d, want 1", n)
+	}
+
+	if err := pubsub.PublishDelta(ctx, "doc-1", map[string]string{"after": "restart"}); err != nil {
+		t.Fatalf("PublishDelta: %v", err)
+	}
+	select {
+	case data := <-received:
+		if data != `{"after":"restart"}` {
+			t.Errorf("received %s", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler received nothing after Redis restarted")
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisPubSub_DocumentChannelsShareOneSubscription(t *testing.T) {
+	mr := miniredis.RunT(t)
+	pubsub, err := NewRedisPubSub(&RedisPubSubConfig{URL: "redis://" + mr.Addr(), ChannelPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("NewRedisPubSub: %v", err)
+	}
+	ctx := context.Background()
+	if err := pubsub.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer pubsub.Disconnect(ctx)
+
+	const docs = 200
+	received := make(chan string, docs)
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < docs; i++ {
+		docID := fmt.Sprintf("doc-%d", i)
+		if err := pubsub.SubscribeToDocument(ctx, docID, func([]byte) { received <- docID }); err != nil {
+			t.Fatalf("SubscribeToDocument(%s): %v", docID, err)
+		}
+	}
+	if added := runtime.NumGoroutine() - goroutines; added > 5 {
+		t.Errorf("%d document subscriptions started %d goroutines, want a handful", docs, added)
+	}
+	if n := len(pubsub.pubsubs); n != 0 {
+		t.Errorf("per-channel subscriptions = %d, want 0", n)
+	}
+	if n := pubsub.GetStats().SubscribedChannels; n != docs {
+		t.Errorf("SubscribedChannels = %d, want %d", n, docs)
+	}
+
+	// Unsubscribing one document leaves the others delivering
+	pubsub.UnsubscribeFromDocument(ctx, "doc-0")
+	pubsub.PublishDelta(ctx, "doc-0", "dropped")
+	pubsub.PublishDelta(ctx, "doc-1", "kept")
+	select {
+	case docID := <-received:
+		if docID != "doc-1" {
+			t.Errorf("message delivered to %s, want doc-1", docID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("doc-1 received nothing after doc-0 unsubscribed")
+	}
+}
+
+func TestRedisPubSub_FloodRunsOnBoundedWorkersInOrder(t *testing.T) {
+	mr := miniredis.RunT(t)
+	pubsub, err := NewRedisPubSub(&RedisPubSubConfig{URL: "redis://" + mr.Addr(), ChannelPrefix: "test:", HandlerWorkers: 2})
+	if err != nil {
+		t.Fatalf("NewRedisPubSub: %v", err)
+	}
+	ctx := context.Background()
+	if err := pubsub.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer pubsub.Disconnect(ctx)
+
+	const messages = 5000
+	var running, maxRunning, calls atomic.Int64
+	var order []int
+	track := func(data []byte) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			max := maxRunning.Load()
+			if n <= max || maxRunning.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		runtime.Gosched()
+		calls.Add(1)
+	}
+	pubsub.SubscribeToDocument(ctx, "doc-1", func(data []byte) {
+		var seq int
+		json.Unmarshal(data, &seq)
+		order = append(order, seq)
+		track(data)
+	})
+	for i := 0; i < 3; i++ {
+		pubsub.SubscribeToDocument(ctx, "doc-1", track)
+	}
+	pubsub.SubscribeToDocument(ctx, "doc-1", func(data []byte) {
+		if string(data) == "10" {
+			panic("bad message")
+		}
+	})
+
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < messages; i++ {
+		if err := pubsub.PublishDelta(ctx, "doc-1", i); err != nil {
+			t.Fatalf("PublishDelta: %v", err)
+		}
+	}
+	waitFor(t, "every handler call", func() bool { return calls.Load() == 4*messages })
+
+	if max := maxRunning.Load(); max > 2 {
+		t.Errorf("%d handlers ran at once, want at most 2", max)
+	}
+	for i, seq := range order {
+		if seq != i {
+			t.Fatalf("message %d delivered as %d, want publish order", seq, i)
+		}
+	}
+	if n := pubsub.GetStats().HandlerPanics; n != 1 {
+		t.Errorf("HandlerPanics = %d, want 1", n)
+	}
+	waitFor(t, "idle workers to exit", func() bool { return runtime.NumGoroutine() <= goroutines })
+}
+
+func BenchmarkRedisPubSub_DocumentFanIn(b *testing.B) {
+	mr := miniredis.RunT(b)
+	pubsub, err := NewRedisPubSub(&RedisPubSubConfig{URL: "redis://" + mr.Addr(), ChannelPrefix: "bench:"})
+	if err != nil {
+		b.Fatalf("NewRedisPubSub: %v", err)
+	}
+	ctx := context.Background()
+	if err := pubsub.Connect(ctx); err != nil {
+		b.Fatalf("Connect: %v", err)
+	}
+	defer pubsub.Disconnect(ctx)
+
+	const docs = 1000
+	received := make(chan struct{}, 1024)
+	for i := 0; i < docs; i++ {
+		pubsub.SubscribeToDocument(ctx, fmt.Sprintf("doc-%d", i), func([]byte) { received <- struct{}{} })
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pubsub.PublishDelta(ctx, fmt.Sprintf("doc-%d", i%docs), i)
+		<-received
+	}
+}
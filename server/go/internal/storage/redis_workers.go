@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+)
+
+// DefaultHandlerWorkers is how many handlers of one channel may run at
+// once when RedisPubSubConfig.HandlerWorkers is not set
+const DefaultHandlerWorkers = 4
+
+// handlerQueueSize is how many messages a worker holds before the
+// subscription stops reading and waits for it
+const handlerQueueSize = 1024
+
+// handlerWorker runs queued handler calls one at a time, in the order they
+// were queued. Its goroutine exists only while calls are waiting.
+type handlerWorker struct {
+	jobs    chan func()
+	mu      sync.Mutex
+	running bool
+}
+
+func newHandlerWorker() *handlerWorker {
+	return &handlerWorker{jobs: make(chan func(), handlerQueueSize)}
+}
+
+// push queues job, blocking while the queue is full
+func (w *handlerWorker) push(job func()) {
+	w.jobs <- job
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+}
+
+func (w *handlerWorker) run() {
+	for {
+		select {
+		case job := <-w.jobs:
+			job()
+		default:
+			w.mu.Lock()
+			if len(w.jobs) == 0 {
+				w.running = false
+				w.mu.Unlock()
+				return
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// dispatch hands a message to the handlers of its channel. Each channel has
+// up to HandlerWorkers workers and handler i always runs on worker i modulo
+// that, so every handler sees the channel's messages in publish order while
+// a slow channel does not hold up the others.
+func (r *RedisPubSub) dispatch(channel string, handlers []func([]byte), payload []byte) {
+	r.workersMu.Lock()
+	workers := r.workers[channel]
+	for len(workers) < min(len(handlers), r.handlerWorkers()) {
+		workers = append(workers, newHandlerWorker())
+	}
+	r.workers[channel] = workers
+	r.workersMu.Unlock()
+
+	for i, handler := range handlers {
+		workers[i%len(workers)].push(func() {
+			r.runHandler(channel, handler, payload)
+		})
+	}
+}
+
+// stopWorkers forgets a channel's workers once it has no handlers; they
+// exit after finishing the messages already queued
+func (r *RedisPubSub) stopWorkers(channel string) {
+	r.workersMu.Lock()
+	delete(r.workers, channel)
+	r.workersMu.Unlock()
+}
+
+func (r *RedisPubSub) handlerWorkers() int {
+	if r.config.HandlerWorkers > 0 {
+		return r.config.HandlerWorkers
+	}
+	return DefaultHandlerWorkers
+}
+
+// runHandler calls handler, logging and counting a panic so one bad message
+// does not stop the channel
+func (r *RedisPubSub) runHandler(channel string, handler func([]byte), payload []byte) {
+	defer func() {
+		if v := recover(); v != nil {
+			r.handlerPanics.Add(1)
+			slog.Error("Relay handler panicked",
+				"err", fmt.Sprint(v),
+				"channel", channel,
+				"stack", string(debug.Stack()),
+			)
+		}
+	}()
+	handler(payload)
+}
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultHeartbeatInterval is how often servers renew their heartbeat
+const DefaultHeartbeatInterval = 5 * time.Second
+
+// ServerInfo describes one live server in the registry
+type ServerInfo struct {
+	ID       string                 `json:"id"`
+	LastSeen int64                  `json:"lastSeen"` // Unix milliseconds of the last heartbeat
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// ServerRegistryOptions configures a ServerRegistry
+type ServerRegistryOptions struct {
+	// ServerID identifies this server
+	ServerID string
+
+	// Interval is how often the heartbeat is written and the other servers
+	// are checked (default DefaultHeartbeatInterval)
+	Interval time.Duration
+
+	// TTL is how long a heartbeat stays without being renewed; a server
+	// whose heartbeat expires is considered offline (default three
+	// intervals)
+	TTL time.Duration
+
+	// Metadata is called for each heartbeat, e.g. for the version and
+	// connection count (nil for none)
+	Metadata func() map[string]interface{}
+
+	// OnOffline is called once for each other server that shuts down or
+	// stops heartbeating (nil to ignore)
+	OnOffline func(serverID string)
+}
+
+// ServerRegistry keeps track of the servers sharing a Redis instance. Each
+// server writes a heartbeat key that expires after TTL and announces itself
+// on the presence channel. Servers that shut down announce it; servers that
+// crash are noticed when their heartbeat expires.
+type ServerRegistry struct {
+	pubsub *RedisPubSub
+	opts   ServerRegistryOptions
+
+	mu     sync.Mutex
+	known  map[string]bool // Other servers seen online
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewServerRegistry creates a registry using pubsub's connections
+func NewServerRegistry(pubsub *RedisPubSub, opts ServerRegistryOptions) *ServerRegistry {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultHeartbeatInterval
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 3 * opts.Interval
+	}
+	return &ServerRegistry{
+		pubsub: pubsub,
+		opts:   opts,
+		known:  make(map[string]bool),
+	}
+}
+
+// Start writes the first heartbeat, announces the server and keeps the
+// heartbeat going until Stop is called. ctx bounds the first heartbeat and
+// announcement only.
+func (r *ServerRegistry) Start(ctx context.Context) error {
+	if err := r.heartbeat(ctx); err != nil {
+		return err
+	}
+	if err := r.pubsub.SubscribeToPresence(ctx, r.handlePresence); err != nil {
+		return err
+	}
+	if err := r.pubsub.AnnouncePresence(ctx, r.opts.ServerID, r.metadata()); err != nil {
+		return err
+	}
+	r.check(ctx)
+
+	loopCtx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	done := r.done
+	r.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(r.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-loopCtx.Done():
+				return
+			case <-ticker.C:
+				tickCtx, cancel := context.WithTimeout(loopCtx, r.opts.Interval)
+				r.heartbeat(tickCtx)
+				r.check(tickCtx)
+				cancel()
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends the heartbeat, removes this server from the registry and
+// announces the shutdown
+func (r *ServerRegistry) Stop(ctx context.Context) error {
+	if !r.halt() {
+		return nil
+	}
+
+	if err := r.pubsub.publisher.Del(ctx, r.key(r.opts.ServerID)).Err(); err != nil {
+		return fmt.Errorf("failed to remove heartbeat: %w", err)
+	}
+	return r.pubsub.AnnounceShutdown(ctx, r.opts.ServerID)
+}
+
+// halt ends the heartbeat, leaving the key to expire. It reports whether
+// the heartbeat was running.
+func (r *ServerRegistry) halt() bool {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel = nil
+	r.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	<-done
+	return true
+}
+
+// ListServers returns the servers with a live heartbeat, this one included,
+// sorted by ID
+func (r *ServerRegistry) ListServers(ctx context.Context) ([]ServerInfo, error) {
+	keys, err := r.heartbeatKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	if len(keys) == 0 {
+		return []ServerInfo{}, nil
+	}
+
+	// One GET per key rather than MGET, which cluster refuses for keys
+	// in different slots
+	pipe := r.pubsub.publisher.Pipeline()
+	gets := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		gets[i] = pipe.Get(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to read heartbeats: %w", err)
+	}
+	servers := make([]ServerInfo, 0, len(keys))
+	for _, get := range gets {
+		// Expired between SCAN and GET
+		data, err := get.Bytes()
+		if err != nil {
+			continue
+		}
+		var info ServerInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			continue
+		}
+		servers = append(servers, info)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].ID < servers[j].ID })
+	return servers, nil
+}
+
+// heartbeatKeys scans for every server's heartbeat key, on each master in
+// cluster mode
+func (r *ServerRegistry) heartbeatKeys(ctx context.Context) ([]string, error) {
+	var mu sync.Mutex
+	var keys []string
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, r.key("*"), 100).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+	if cluster, ok := r.pubsub.publisher.(*redis.ClusterClient); ok {
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			return scan(ctx, master)
+		})
+		return keys, err
+	}
+	return keys, scan(ctx, r.pubsub.publisher)
+}
+
+// heartbeat writes this server's key with a fresh TTL
+func (r *ServerRegistry) heartbeat(ctx context.Context) error {
+	data, err := json.Marshal(ServerInfo{
+		ID:       r.opts.ServerID,
+		LastSeen: time.Now().UnixMilli(),
+		Metadata: r.metadata(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat: %w", err)
+	}
+	if err := r.pubsub.publisher.Set(ctx, r.key(r.opts.ServerID), data, r.opts.TTL).Err(); err != nil {
+		return fmt.Errorf("failed to write heartbeat: %w", err)
+	}
+	return nil
+}
+
+// check compares the live servers with those seen before, reporting the
+// ones whose heartbeat expired
+func (r *ServerRegistry) check(ctx context.Context) {
+	servers, err := r.ListServers(ctx)
+	if err != nil {
+		return
+	}
+	live := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		if server.ID != r.opts.ServerID {
+			live[server.ID] = true
+		}
+	}
+
+	r.mu.Lock()
+	var gone []string
+	for id := range r.known {
+		if !live[id] {
+			gone = append(gone, id)
+		}
+	}
+	r.known = live
+	r.mu.Unlock()
+
+	for _, id := range gone {
+		r.offline(id)
+	}
+}
+
+// handlePresence tracks servers announcing themselves or their shutdown
+func (r *ServerRegistry) handlePresence(event, serverID string, _ map[string]interface{}) {
+	if serverID == r.opts.ServerID {
+		return
+	}
+	r.mu.Lock()
+	wasKnown := r.known[serverID]
+	if event == "online" {
+		r.known[serverID] = true
+	} else {
+		delete(r.known, serverID)
+	}
+	r.mu.Unlock()
+
+	if event == "offline" && wasKnown {
+		r.offline(serverID)
+	}
+}
+
+func (r *ServerRegistry) offline(serverID string) {
+	if r.opts.OnOffline != nil {
+		r.opts.OnOffline(serverID)
+	}
+}
+
+func (r *ServerRegistry) metadata() map[string]interface{} {
+	if r.opts.Metadata == nil {
+		return nil
+	}
+	return r.opts.Metadata()
+}
+
+func (r *ServerRegistry) key(serverID string) string {
+	return r.pubsub.channelPrefix + "server:" + serverID
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func startRegistry(t *testing.T, addr, id string, offline chan<- string) *ServerRegistry {
+	t.Helper()
+	pubsub, err := NewRedisPubSub(&RedisPubSubConfig{URL: "redis://" + addr, ChannelPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("NewRedisPubSub: %v", err)
+	}
+	if err := pubsub.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { pubsub.Disconnect(context.Background()) })
+
+	registry := NewServerRegistry(pubsub, ServerRegistryOptions{
+		ServerID: id,
+		Interval: 20 * time.Millisecond,
+		TTL:      time.Second,
+		Metadata: func() map[string]interface{} {
+			return map[string]interface{}{"version": "test", "connections": 2}
+		},
+		OnOffline: func(serverID string) { offline <- serverID },
+	})
+	if err := registry.Start(context.Background()); err != nil {
+		t.Fatalf("Start %s: %v", id, err)
+	}
+	return registry
+}
+
+func expectOffline(t *testing.T, offline <-chan string, want string) {
+	t.Helper()
+	select {
+	case id := <-offline:
+		if id != want {
+			t.Errorf("offline server = %s, want %s", id, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no offline event for %s", want)
+	}
+}
+
+func TestServerRegistry_ListsServersAndDetectsExpiredHeartbeats(t *testing.T) {
+	mr := miniredis.RunT(t)
+	offline := make(chan string, 4)
+
+	a := startRegistry(t, mr.Addr(), "server-a", offline)
+	defer a.Stop(context.Background())
+	b := startRegistry(t, mr.Addr(), "server-b", make(chan string, 4))
+
+	servers, err := a.ListServers(context.Background())
+	if err != nil {
+		t.Fatalf("ListServers: %v", err)
+	}
+	if len(servers) != 2 || servers[0].ID != "server-a" || servers[1].ID != "server-b" {
+		t.Fatalf("servers = %+v, want server-a and server-b", servers)
+	}
+	if servers[1].Metadata["version"] != "test" || servers[1].Metadata["connections"] != float64(2) || servers[1].LastSeen == 0 {
+		t.Errorf("server-b = %+v, want its heartbeat metadata", servers[1])
+	}
+
+	// server-b stops heartbeating without deregistering
+	b.halt()
+	time.Sleep(50 * time.Millisecond)
+	mr.FastForward(2 * time.Second)
+	expectOffline(t, offline, "server-b")
+
+	servers, _ = a.ListServers(context.Background())
+	if len(servers) != 1 || servers[0].ID != "server-a" {
+		t.Errorf("servers after server-b expired = %+v, want only server-a", servers)
+	}
+}
+
+func TestServerRegistry_StopAnnouncesShutdown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	offline := make(chan string, 4)
+
+	a := startRegistry(t, mr.Addr(), "server-a", offline)
+	defer a.Stop(context.Background())
+	b := startRegistry(t, mr.Addr(), "server-b", make(chan string, 4))
+
+	if err := b.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	expectOffline(t, offline, "server-b")
+	servers, _ := a.ListServers(context.Background())
+	if len(servers) != 1 {
+		t.Errorf("servers after server-b stopped = %+v, want only server-a", servers)
+	}
+
+	// Reported once, not again when the next check misses its heartbeat
+	select {
+	case id := <-offline:
+		t.Errorf("second offline event for %s", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Delta log operations
+const (
+	OpSet    = "set"    // Value replaces the field
+	OpDelete = "delete" // The field is removed
+	OpMerge  = "merge"  // Value's keys are written into the field's object, nil removing them
+)
+
+// Replay is a document state rebuilt from its delta log
+type Replay struct {
+	DocumentID string
+	State      map[string]interface{}
+
+	// Snapshot is where the replay started, nil when it started from an
+	// empty document
+	Snapshot *SnapshotEntry
+	// Deltas is how many deltas were applied after the snapshot, and Last
+	// the time of the last of them
+	Deltas int
+	Last   time.Time
+
+	// wrapped is set when the stored states nest fields under "fields", as
+	// the TypeScript server stores them
+	wrapped bool
+}
+
+// ReplayDocument rebuilds a document from its latest snapshot taken at or
+// before at, plus the deltas recorded after the snapshot up to and
+// including at. A zero at replays everything. Deltas apply in the order
+// they were recorded, so the last write to each field wins, as
+// last-writer-wins resolved it when they were applied.
+func ReplayDocument(ctx context.Context, adapter StorageAdapter, docID string, at time.Time) (*Replay, error) {
+	replay := &Replay{DocumentID: docID, State: map[string]interface{}{}}
+
+	snapshot, err := snapshotAt(ctx, adapter, docID, at)
+	if err != nil {
+		return nil, err
+	}
+	var since time.Time
+	if snapshot != nil {
+		state, err := SnapshotState(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		replay.Snapshot = snapshot
+		replay.State, replay.wrapped = documentFields(jsonClone(state))
+		since = snapshot.CreatedAt
+	}
+
+	query := DeltaQuery{Since: since, Limit: 500}
+	for {
+		deltas, err := adapter.QueryDeltas(ctx, docID, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, delta := range deltas {
+			// Deltas at the snapshot's time are in it
+			if snapshot != nil && !delta.Timestamp.After(since) {
+				continue
+			}
+			if !at.IsZero() && delta.Timestamp.After(at) {
+				return replay, nil
+			}
+			if err := ApplyDelta(replay.State, delta); err != nil {
+				return nil, err
+			}
+			replay.Deltas++
+			replay.Last = delta.Timestamp
+		}
+		if len(deltas) < query.Limit {
+			return replay, nil
+		}
+		last := deltas[len(deltas)-1]
+		query.After = &PageKey{Timestamp: last.Timestamp, ID: last.ID}
+	}
+}
+
+// snapshotAt returns the latest snapshot of a document taken at or before
+// at, or the latest of all for a zero at
+func snapshotAt(ctx context.Context, adapter StorageAdapter, docID string, at time.Time) (*SnapshotEntry, error) {
+	if at.IsZero() {
+		return adapter.GetLatestSnapshot(ctx, docID)
+	}
+	query := SnapshotQuery{Limit: 50}
+	for {
+		snapshots, err := adapter.QuerySnapshots(ctx, docID, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, snapshot := range snapshots {
+			if !snapshot.CreatedAt.After(at) {
+				return snapshot, nil
+			}
+		}
+		if len(snapshots) < query.Limit {
+			return nil, nil
+		}
+		last := snapshots[len(snapshots)-1]
+		query.Before = &PageKey{Timestamp: last.CreatedAt, ID: last.ID}
+	}
+}
+
+// ApplyDelta applies one logged delta to a document's fields
+func ApplyDelta(state map[string]interface{}, delta *DeltaEntry) error {
+	switch delta.OperationType {
+	case OpSet:
+		if delta.Value == nil {
+			delete(state, delta.FieldPath)
+		} else {
+			state[delta.FieldPath] = jsonClone(delta.Value)
+		}
+	case OpDelete:
+		delete(state, delta.FieldPath)
+	case OpMerge:
+		field, _ := state[delta.FieldPath].(map[string]interface{})
+		merged := make(map[string]interface{}, len(field)+len(delta.Value))
+		for k, v := range field {
+			merged[k] = v
+		}
+		for k, v := range delta.Value {
+			if v == nil {
+				delete(merged, k)
+			} else {
+				merged[k] = cloneValue(v)
+			}
+		}
+		state[delta.FieldPath] = merged
+	default:
+		return fmt.Errorf("delta %s has unknown operation %q", delta.ID, delta.OperationType)
+	}
+	return nil
+}
+
+// FieldDiff is a field whose stored and replayed values differ. A value
+// is nil where the field is unset.
+type FieldDiff struct {
+	Field    string      `json:"field"`
+	Stored   interface{} `json:"stored"`
+	Replayed interface{} `json:"replayed"`
+}
+
+// Diff compares the replay with a document's stored state, sorted by field.
+// A field set to null reads as unset to clients, so the two compare equal.
+func (r *Replay) Diff(stored map[string]interface{}) []FieldDiff {
+	fields, _ := documentFields(stored)
+	var diffs []FieldDiff
+	seen := make(map[string]bool, len(fields))
+	for k, v := range fields {
+		seen[k] = true
+		if !reflect.DeepEqual(v, r.State[k]) {
+			diffs = append(diffs, FieldDiff{Field: k, Stored: v, Replayed: r.State[k]})
+		}
+	}
+	for k, v := range r.State {
+		if !seen[k] && v != nil {
+			diffs = append(diffs, FieldDiff{Field: k, Replayed: v})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Field < diffs[j].Field })
+	return diffs
+}
+
+// StoredState is the replayed state in the shape documents are stored in
+func (r *Replay) StoredState() map[string]interface{} {
+	if r.wrapped {
+		return map[string]interface{}{"id": r.DocumentID, "fields": r.State}
+	}
+	return r.State
+}
+
+// documentFields returns a stored state's fields. The TypeScript server
+// stores them under "fields", next to the document ID.
+func documentFields(state map[string]interface{}) (map[string]interface{}, bool) {
+	fields, ok := state["fields"].(map[string]interface{})
+	if _, hasID := state["id"]; ok && len(state) <= 2 && (hasID || len(state) == 1) {
+		return fields, true
+	}
+	if state == nil {
+		return map[string]interface{}{}, false
+	}
+	return state, false
+}
+
+// jsonClone deep-copies a value decoded from JSON, so replays never share
+// maps and slices with what the adapter returned
+func jsonClone(v interface{}) map[string]interface{} {
+	m, _ := cloneValue(v).(map[string]interface{})
+	return m
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = cloneValue(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = cloneValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// history writes a document's deltas and snapshots the way a server
+// would, keeping the document itself in step
+type history struct {
+	t       *testing.T
+	adapter *MemoryAdapter
+	docID   string
+	state   map[string]interface{}
+}
+
+func newHistory(t *testing.T, docID string) *history {
+	adapter := NewMemoryAdapter()
+	if err := adapter.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return &history{t: t, adapter: adapter, docID: docID, state: map[string]interface{}{}}
+}
+
+// apply logs a delta and saves the document it leaves, returning the
+// delta's timestamp
+func (h *history) apply(op, field string, value map[string]interface{}) time.Time {
+	h.t.Helper()
+	ctx := context.Background()
+	delta, err := h.adapter.SaveDelta(ctx, &DeltaEntry{
+		DocumentID:    h.docID,
+		ClientID:      "client-1",
+		OperationType: op,
+		FieldPath:     field,
+		Value:         value,
+	})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if err := ApplyDelta(h.state, delta); err != nil {
+		h.t.Fatal(err)
+	}
+	if _, err := h.adapter.SaveDocument(ctx, h.docID, h.state); err != nil {
+		h.t.Fatal(err)
+	}
+	return delta.Timestamp
+}
+
+func (h *history) snapshot(compressed bool) {
+	h.t.Helper()
+	snapshot := &SnapshotEntry{DocumentID: h.docID, State: jsonClone(h.state)}
+	if compressed {
+		state, err := CompressSnapshotState(snapshot.State)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		snapshot.State, snapshot.Compressed = state, true
+	}
+	if _, err := h.adapter.SaveSnapshot(context.Background(), snapshot); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+func (h *history) replay(at time.Time) *Replay {
+	h.t.Helper()
+	replay, err := ReplayDocument(context.Background(), h.adapter, h.docID, at)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return replay
+}
+
+func (h *history) stored() map[string]interface{} {
+	h.t.Helper()
+	doc, err := h.adapter.GetDocument(context.Background(), h.docID)
+	if err != nil || doc == nil {
+		h.t.Fatalf("GetDocument = %v, %v", doc, err)
+	}
+	return doc.State
+}
+
+func TestReplayDocument_ReproducesStoredState(t *testing.T) {
+	h := newHistory(t, "room:replay")
+	h.apply(OpSet, "title", map[string]interface{}{"text": "Standup"})
+	h.apply(OpSet, "owner.profile", map[string]interface{}{"name": "Ada", "tags": []interface{}{"a", "b"}})
+	h.apply(OpSet, "scratch", map[string]interface{}{"n": 1.0})
+	h.snapshot(false)
+	early := h.apply(OpDelete, "scratch", nil)
+	h.apply(OpMerge, "owner.profile", map[string]interface{}{"name": nil, "role": "lead"})
+	h.snapshot(true)
+	h.apply(OpSet, "title", map[string]interface{}{"text": "Retro"})
+	h.apply(OpSet, "owner.settings.theme", map[string]interface{}{"dark": true})
+	h.apply(OpDelete, "owner.settings.theme", nil)
+	h.apply(OpSet, "scratch", map[string]interface{}{"n": 2.0})
+
+	replay := h.replay(time.Time{})
+	if diffs := replay.Diff(h.stored()); len(diffs) != 0 {
+		t.Errorf("replay diverges from the stored document: %+v", diffs)
+	}
+	if replay.Snapshot == nil || !replay.Snapshot.Compressed || replay.Deltas != 4 {
+		t.Errorf("replayed %d deltas from %+v, want 4 from the compressed snapshot", replay.Deltas, replay.Snapshot)
+	}
+	want := map[string]interface{}{
+		"title":         map[string]interface{}{"text": "Retro"},
+		"owner.profile": map[string]interface{}{"tags": []interface{}{"a", "b"}, "role": "lead"},
+		"scratch":       map[string]interface{}{"n": 2.0},
+	}
+	if !reflect.DeepEqual(replay.State, want) {
+		t.Errorf("State = %v, want %v", replay.State, want)
+	}
+
+	// Materialized at the first delete: the first snapshot and that delete
+	past := h.replay(early)
+	want = map[string]interface{}{
+		"title":         map[string]interface{}{"text": "Standup"},
+		"owner.profile": map[string]interface{}{"name": "Ada", "tags": []interface{}{"a", "b"}},
+	}
+	if !reflect.DeepEqual(past.State, want) || past.Deltas != 1 || !past.Last.Equal(early) {
+		t.Errorf("at %v: %d deltas to %v, want 1 to %v", early, past.Deltas, past.State, want)
+	}
+}
+
+func TestReplayDocument_WithoutSnapshots(t *testing.T) {
+	h := newHistory(t, "room:replay")
+	first := h.apply(OpSet, "a", map[string]interface{}{"v": 1.0})
+	h.apply(OpSet, "b", map[string]interface{}{"v": 2.0})
+	h.apply(OpDelete, "a", nil)
+
+	if diffs := h.replay(time.Time{}).Diff(h.stored()); len(diffs) != 0 {
+		t.Errorf("replay diverges from the stored document: %+v", diffs)
+	}
+	if past := h.replay(first); past.Snapshot != nil || !reflect.DeepEqual(past.State, map[string]interface{}{"a": map[string]interface{}{"v": 1.0}}) {
+		t.Errorf("at the first delta: %v from %v", past.State, past.Snapshot)
+	}
+	if before := h.replay(first.Add(-time.Second)); len(before.State) != 0 || before.Deltas != 0 {
+		t.Errorf("before any delta: %d deltas to %v", before.Deltas, before.State)
+	}
+}
+
+func TestReplay_Diff(t *testing.T) {
+	replay := &Replay{State: map[string]interface{}{
+		"same":    "x",
+		"changed": "new",
+		"missing": "here",
+	}}
+	stored := map[string]interface{}{
+		"same":    "x",
+		"changed": "old",
+		"extra":   "stale",
+		"deleted": nil, // The hub keeps deleted fields as null
+	}
+	want := []FieldDiff{
+		{Field: "changed", Stored: "old", Replayed: "new"},
+		{Field: "extra", Stored: "stale"},
+		{Field: "missing", Replayed: "here"},
+	}
+	if got := replay.Diff(stored); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %+v, want %+v", got, want)
+	}
+}
+
+func TestReplay_WrappedState(t *testing.T) {
+	// The TypeScript server stores {"id": ..., "fields": {...}}
+	h := newHistory(t, "room:wrapped")
+	h.snapshot(false)
+	h.adapter.snapshots[0].State = map[string]interface{}{"id": "room:wrapped", "fields": map[string]interface{}{"a": "1"}}
+	h.apply(OpSet, "b", map[string]interface{}{"v": 2.0})
+
+	replay := h.replay(time.Time{})
+	stored := map[string]interface{}{"id": "room:wrapped", "fields": map[string]interface{}{"a": "1", "b": map[string]interface{}{"v": 2.0}}}
+	if diffs := replay.Diff(stored); len(diffs) != 0 {
+		t.Errorf("replay diverges from the wrapped document: %+v", diffs)
+	}
+	if got := replay.StoredState(); !reflect.DeepEqual(got, stored) {
+		t.Errorf("StoredState = %v, want %v", got, stored)
+	}
+}
+
+func TestApplyDelta_RejectsUnknownOperations(t *testing.T) {
+	if err := ApplyDelta(map[string]interface{}{}, &DeltaEntry{ID: "d1", OperationType: "splice"}); err == nil {
+		t.Error("ApplyDelta accepted an unknown operation")
+	}
+}
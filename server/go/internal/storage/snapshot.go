@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
+)
+
+// CompressedStateKey holds the state of a compressed snapshot: the JSON
+// state, gzipped and base64-encoded, as the only key of SnapshotEntry.State
+const CompressedStateKey = "gzip"
+
+// CompressSnapshotState packs state for a snapshot saved with Compressed set
+func CompressSnapshotState(state map[string]interface{}) (map[string]interface{}, error) {
+	data, err := codec.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		CompressedStateKey: base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// SnapshotState returns a snapshot's document state, decompressing it if the
+// snapshot is compressed
+func SnapshotState(snapshot *SnapshotEntry) (map[string]interface{}, error) {
+	if !snapshot.Compressed {
+		return snapshot.State, nil
+	}
+
+	encoded, ok := snapshot.State[CompressedStateKey].(string)
+	if !ok {
+		return nil, fmt.Errorf("compressed snapshot %s has no %q state", snapshot.ID, CompressedStateKey)
+	}
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("compressed snapshot %s: %w", snapshot.ID, err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("compressed snapshot %s: %w", snapshot.ID, err)
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("compressed snapshot %s: %w", snapshot.ID, err)
+	}
+
+	var state map[string]interface{}
+	if err := codec.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("compressed snapshot %s: %w", snapshot.ID, err)
+	}
+	return state, nil
+}
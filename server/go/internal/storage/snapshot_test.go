@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotState_RoundTripsCompressedState(t *testing.T) {
+	state := map[string]interface{}{"title": "Notes", "nested": map[string]interface{}{"n": 1.0}}
+
+	packed, err := CompressSnapshotState(state)
+	if err != nil {
+		t.Fatalf("CompressSnapshotState failed: %v", err)
+	}
+	if _, ok := packed[CompressedStateKey].(string); !ok || len(packed) != 1 {
+		t.Fatalf("packed state = %v, want only %q", packed, CompressedStateKey)
+	}
+
+	got, err := SnapshotState(&SnapshotEntry{ID: "s1", State: packed, Compressed: true})
+	if err != nil {
+		t.Fatalf("SnapshotState failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, state) {
+		t.Errorf("SnapshotState = %v, want %v", got, state)
+	}
+}
+
+func TestSnapshotState_UncompressedIsReturnedAsIs(t *testing.T) {
+	state := map[string]interface{}{"a": "b"}
+	got, err := SnapshotState(&SnapshotEntry{State: state})
+	if err != nil || !reflect.DeepEqual(got, state) {
+		t.Errorf("SnapshotState = %v, %v; want %v", got, err, state)
+	}
+}
+
+func TestSnapshotState_RejectsCorruptData(t *testing.T) {
+	for name, state := range map[string]map[string]interface{}{
+		"missing key": {"other": "x"},
+		"not base64":  {CompressedStateKey: "!!!"},
+		"not gzip":    {CompressedStateKey: "aGVsbG8="},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := SnapshotState(&SnapshotEntry{ID: "s1", State: state, Compressed: true}); err == nil {
+				t.Error("SnapshotState should fail")
+			}
+		})
+	}
+}
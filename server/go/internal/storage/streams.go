@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/codec"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultStreamMaxLen is roughly how many deltas each document stream keeps
+const DefaultStreamMaxLen = 1000
+
+// streamBlock is how long one read waits for new entries. Documents
+// subscribed meanwhile are read from the next call on.
+const streamBlock = 200 * time.Millisecond
+
+// streamReadGrace is how long past streamBlock a read may take before the
+// connection is considered lost
+const streamReadGrace = time.Second
+
+// RedisStreams relays document deltas through one Redis stream per document,
+// so a server cut off from Redis for a while catches up on the deltas it
+// missed instead of losing them. Every server reads the streams it follows
+// through its own consumer group, named after its server ID, and
+// acknowledges each entry once its handlers have run. Awareness is
+// ephemeral and stays on pub/sub.
+type RedisStreams struct {
+	pubsub   *RedisPubSub
+	reader   redis.UniversalClient // Blocking reads; kept off the publisher's pool
+	group    string
+	consumer string
+	maxLen   int64
+
+	mu       sync.Mutex
+	handlers map[string][]func([]byte) // stream key -> handlers
+	started  bool
+	closed   bool
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// RedisStreamsConfig configures a RedisStreams
+type RedisStreamsConfig struct {
+	// ServerID names this server's consumer groups. A server restarted
+	// with the same ID resumes where it left off.
+	ServerID string
+
+	// MaxLen caps each document stream, approximately (default
+	// DefaultStreamMaxLen). A server away for longer than the stream
+	// covers misses the trimmed deltas.
+	MaxLen int64
+}
+
+// NewRedisStreams creates a streams transport sharing pubsub's connection
+// settings; awareness goes through pubsub itself
+func NewRedisStreams(pubsub *RedisPubSub, config RedisStreamsConfig) *RedisStreams {
+	if config.MaxLen <= 0 {
+		config.MaxLen = DefaultStreamMaxLen
+	}
+	// NewRedisPubSub already built a client from the same config
+	reader, _ := newRedisClient(pubsub.config)
+	return &RedisStreams{
+		pubsub:   pubsub,
+		reader:   reader,
+		group:    pubsub.channelPrefix + "server:" + config.ServerID,
+		consumer: config.ServerID,
+		maxLen:   config.MaxLen,
+		handlers: make(map[string][]func([]byte)),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// PublishDelta appends a delta to the document's stream
+func (s *RedisStreams) PublishDelta(ctx context.Context, documentID string, delta interface{}) error {
+	data, err := codec.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %w", err)
+	}
+	err = s.pubsub.publisher.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.streamKey(documentID),
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"data": data},
+	}).Err()
+	if err == nil || isNetworkError(err) {
+		s.pubsub.connected.Store(err == nil)
+	}
+	return err
+}
+
+// PublishAwareness publishes an awareness update over pub/sub
+func (s *RedisStreams) PublishAwareness(ctx context.Context, documentID string, state interface{}) error {
+	return s.pubsub.PublishAwareness(ctx, documentID, state)
+}
+
+// SubscribeToDocument delivers the document's deltas appended from now on,
+// and its awareness updates, to handler
+func (s *RedisStreams) SubscribeToDocument(ctx context.Context, documentID string, handler func([]byte)) error {
+	key := s.streamKey(documentID)
+	if err := s.createGroup(ctx, key); err != nil {
+		return err
+	}
+	if err := s.pubsub.SubscribeToDocument(ctx, documentID, handler); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.handlers[key] = append(s.handlers[key], handler)
+	if !s.started {
+		s.started = true
+		go s.consume()
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// UnsubscribeFromDocument stops delivering the document and drops this
+// server's consumer group on its stream
+func (s *RedisStreams) UnsubscribeFromDocument(ctx context.Context, documentID string) error {
+	key := s.streamKey(documentID)
+	s.mu.Lock()
+	delete(s.handlers, key)
+	s.mu.Unlock()
+
+	s.pubsub.UnsubscribeFromDocument(ctx, documentID)
+	return s.pubsub.publisher.XGroupDestroy(ctx, key, s.group).Err()
+}
+
+// Close stops reading. The pub/sub it was created with is left open.
+func (s *RedisStreams) Close() error {
+	s.mu.Lock()
+	started, closed := s.started, s.closed
+	s.closed = true
+	s.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	close(s.stop)
+	err := s.reader.Close()
+	if started {
+		<-s.done
+	}
+	return err
+}
+
+// consume reads every followed stream, handing entries to their handlers in
+// stream order and acknowledging them. When Redis is unreachable it retries
+// with exponential backoff; the consumer groups remember what was read, so
+// the next read resumes after the last delivered entry.
+func (s *RedisStreams) consume() {
+	defer close(s.done)
+	ctx := context.Background()
+	delay := reconnectMinDelay
+	lost := false
+
+	for {
+		keys := s.keys()
+		if len(keys) == 0 {
+			select {
+			case <-s.stop:
+				return
+			case <-s.wake:
+				continue
+			}
+		}
+
+		streams := make([]string, 0, 2*len(keys))
+		streams = append(streams, keys...)
+		for range keys {
+			streams = append(streams, ">")
+		}
+		// go-redis would otherwise wait 10s past the block for a reply
+		readCtx, cancel := context.WithTimeout(ctx, streamBlock+streamReadGrace)
+		res, err := s.reader.XReadGroup(readCtx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  streams,
+			Count:    100,
+			Block:    streamBlock,
+		}).Result()
+		cancel()
+
+		switch {
+		case errors.Is(err, redis.ErrClosed):
+			return
+		case err != nil && strings.HasPrefix(err.Error(), "NOGROUP"):
+			// Unsubscribed meanwhile, or Redis lost its data
+			s.recreateGroups(ctx, keys)
+			continue
+		case err != nil && !errors.Is(err, redis.Nil):
+			if !lost {
+				lost = true
+				s.pubsub.connected.Store(false)
+			}
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, reconnectMaxDelay)
+			continue
+		}
+
+		if lost {
+			lost = false
+			delay = reconnectMinDelay
+			s.pubsub.connected.Store(true)
+			s.pubsub.reconnects.Add(1)
+		}
+		for _, stream := range res {
+			s.mu.Lock()
+			handlers := s.handlers[stream.Stream]
+			s.mu.Unlock()
+			for _, msg := range stream.Messages {
+				if data, ok := msg.Values["data"].(string); ok {
+					for _, handler := range handlers {
+						s.pubsub.runHandler(stream.Stream, handler, []byte(data))
+					}
+				}
+				s.reader.XAck(ctx, stream.Stream, s.group, msg.ID)
+			}
+		}
+	}
+}
+
+// recreateGroups restores the consumer groups of followed streams that no
+// longer have one. Their documents may have missed deltas, so they are
+// reported to the pub/sub's OnReconnect callback.
+func (s *RedisStreams) recreateGroups(ctx context.Context, keys []string) {
+	var lostIDs []string
+	for _, key := range keys {
+		s.mu.Lock()
+		_, followed := s.handlers[key]
+		s.mu.Unlock()
+		if !followed {
+			continue
+		}
+		created, err := s.pubsub.publisher.XGroupCreateMkStream(ctx, key, s.group, "$").Result()
+		if err == nil && created == "OK" {
+			lostIDs = append(lostIDs, strings.TrimPrefix(key, s.streamKey("")))
+		}
+	}
+	if fn := s.pubsub.onReconnect.Load(); fn != nil && len(lostIDs) > 0 {
+		sort.Strings(lostIDs)
+		(*fn)(lostIDs)
+	}
+}
+
+// createGroup makes this server's consumer group on a stream, starting
+// after its current last entry
+func (s *RedisStreams) createGroup(ctx context.Context, key string) error {
+	err := s.pubsub.publisher.XGroupCreateMkStream(ctx, key, s.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group on %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *RedisStreams) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.handlers))
+	for key := range s.handlers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *RedisStreams) streamKey(documentID string) string {
+	return s.pubsub.channelPrefix + "stream:doc:" + documentID
+}
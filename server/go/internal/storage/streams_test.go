@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newStreams(t *testing.T, addr, serverID string) *RedisStreams {
+	t.Helper()
+	pubsub, err := NewRedisPubSub(&RedisPubSubConfig{URL: "redis://" + addr, ChannelPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("NewRedisPubSub: %v", err)
+	}
+	if err := pubsub.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	streams := NewRedisStreams(pubsub, RedisStreamsConfig{ServerID: serverID})
+	t.Cleanup(func() {
+		streams.Close()
+		pubsub.Disconnect(context.Background())
+	})
+	return streams
+}
+
+func expectEntry(t *testing.T, received <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("received %s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", want)
+	}
+}
+
+func TestRedisStreams_ConsumerCatchesUpAfterRestart(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	a := newStreams(t, mr.Addr(), "server-a")
+	b := newStreams(t, mr.Addr(), "server-b")
+
+	received := make(chan string, 8)
+	handler := func(data []byte) { received <- string(data) }
+	if err := a.SubscribeToDocument(ctx, "doc-1", handler); err != nil {
+		t.Fatalf("SubscribeToDocument: %v", err)
+	}
+	if err := b.PublishDelta(ctx, "doc-1", 1); err != nil {
+		t.Fatalf("PublishDelta: %v", err)
+	}
+	expectEntry(t, received, "1")
+
+	// server-a goes away; deltas published meanwhile are read once it is
+	// back under the same ID
+	a.Close()
+	for _, value := range []int{2, 3} {
+		if err := b.PublishDelta(ctx, "doc-1", value); err != nil {
+			t.Fatalf("PublishDelta: %v", err)
+		}
+	}
+	a = newStreams(t, mr.Addr(), "server-a")
+	if err := a.SubscribeToDocument(ctx, "doc-1", handler); err != nil {
+		t.Fatalf("SubscribeToDocument after restart: %v", err)
+	}
+	expectEntry(t, received, "2")
+	expectEntry(t, received, "3")
+
+	// Every delivered entry was acknowledged
+	waitFor(t, "pending entries to be acknowledged", func() bool {
+		pending, err := a.pubsub.publisher.XPending(ctx, a.streamKey("doc-1"), a.group).Result()
+		return err == nil && pending.Count == 0
+	})
+}
+
+func TestRedisStreams_AwarenessUsesPubSubAndUnsubscribeDropsGroup(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	a := newStreams(t, mr.Addr(), "server-a")
+	b := newStreams(t, mr.Addr(), "server-b")
+
+	received := make(chan string, 8)
+	a.SubscribeToDocument(ctx, "doc-1", func(data []byte) { received <- string(data) })
+	if err := b.PublishAwareness(ctx, "doc-1", "cursor"); err != nil {
+		t.Fatalf("PublishAwareness: %v", err)
+	}
+	expectEntry(t, received, `"cursor"`)
+	if n, _ := a.pubsub.publisher.XLen(ctx, a.streamKey("doc-1")).Result(); n != 0 {
+		t.Errorf("stream length = %d, want awareness kept off the stream", n)
+	}
+
+	if err := a.UnsubscribeFromDocument(ctx, "doc-1"); err != nil {
+		t.Fatalf("UnsubscribeFromDocument: %v", err)
+	}
+	groups, err := a.pubsub.publisher.XInfoGroups(ctx, a.streamKey("doc-1")).Result()
+	if err != nil || len(groups) != 0 {
+		t.Errorf("consumer groups after unsubscribe = %v (%v), want none", groups, err)
+	}
+}
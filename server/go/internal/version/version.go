@@ -0,0 +1,58 @@
+// Package version describes the running build. The variables are set at
+// link time:
+//
+//	go build -ldflags "-X github.com/Dancode-188/synckit/server/go/internal/version.Version=1.0.0 \
+//	  -X github.com/Dancode-188/synckit/server/go/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/Dancode-188/synckit/server/go/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them Commit falls back to the VCS revision the Go toolchain stamps
+// into binaries built from a checkout.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, overridden with -ldflags "-X ..."
+var (
+	Version   = "0.3.0"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info is the build information reported by /version
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build information, "unknown" for anything not recorded
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" && info.Commit == "" {
+				info.Commit = setting.Value
+				if len(info.Commit) > 12 {
+					info.Commit = info.Commit[:12]
+				}
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
+
+// String formats the information for --version and the startup log
+func (i Info) String() string {
+	return fmt.Sprintf("synckit-server %s (commit %s, built %s, %s)", i.Version, i.Commit, i.BuildTime, i.GoVersion)
+}
@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"context"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// RecheckAccess applies a change to a user's access to a document to the
+// user's open subscriptions on this server. Connections that may no longer
+// read the document are unsubscribed with an ACCESS_REVOKED error; those
+// that may no longer write to it become read-only with a
+// WRITE_ACCESS_REVOKED error. Access gained is not applied: clients
+// subscribe again for it. It returns how many connections were affected.
+func (h *Hub) RecheckAccess(ctx context.Context, docID, userID string) int {
+	h.mu.RLock()
+	conns := make([]*Connection, 0)
+	for _, conn := range h.connections {
+		if conn.Authenticated && conn.VerifiedUserID() == userID {
+			conns = append(conns, conn)
+		}
+	}
+	h.mu.RUnlock()
+
+	count := 0
+	for _, conn := range conns {
+		if h.recheckAccess(ctx, conn, docID) {
+			count++
+		}
+	}
+	if count > 0 {
+		h.syncRelay(docID)
+	}
+	return count
+}
+
+// recheckAccess is RecheckAccess for one connection, reporting whether it
+// lost access
+func (h *Hub) recheckAccess(ctx context.Context, conn *Connection, docID string) bool {
+	conn.handleMu.Lock()
+	defer conn.handleMu.Unlock()
+	return h.revokeLostAccess(ctx, conn, docID)
+}
+
+// revokeLostAccess drops conn's subscription to a document it may no longer
+// read, or makes it read-only if it may no longer write, and reports whether
+// it did either. Must be called with conn.handleMu held.
+func (h *Hub) revokeLostAccess(ctx context.Context, conn *Connection, docID string) bool {
+	if !conn.Subscriptions[docID] && !conn.AwarenessSubscriptions[docID] {
+		return false
+	}
+	if !h.opts.ACL.CanRead(ctx, conn.TokenPayload, docID) {
+		h.unsubscribe(conn, docID)
+		conn.Logger().Info("Document access revoked", "doc_id", docID)
+		sendAccessRevoked(conn, docID, "Access to this document has been revoked", "ACCESS_REVOKED")
+		return true
+	}
+	if conn.Subscriptions[docID] && !conn.ReadOnly[docID] && !h.opts.ACL.CanWrite(ctx, conn.TokenPayload, docID) {
+		conn.ReadOnly[docID] = true
+		conn.Logger().Info("Document write access revoked", "doc_id", docID)
+		sendAccessRevoked(conn, docID, "Write access to this document has been revoked", "WRITE_ACCESS_REVOKED")
+		return true
+	}
+	return false
+}
+
+func sendAccessRevoked(conn *Connection, docID, errMsg, code string) {
+	conn.Send(protocol.NewMessage(protocol.TypeError, map[string]interface{}{
+		"error": errMsg,
+		"code":  code,
+		"docId": docID,
+	}))
+}
+
+// claimDocument makes conn's user the owner of docID if the document does
+// not exist yet and the user's token lets them write to it, so they keep
+// access to what they create. Access from grants does not make owners.
+func (h *Hub) claimDocument(ctx context.Context, conn *Connection, docID string) {
+	if auth.CanWriteDocument(conn.TokenPayload, docID) {
+		h.recordOwner(ctx, conn, docID)
+	}
+}
+
+// recordOwner makes conn's user the owner of docID if the document does not
+// exist yet, and reports whether the user now owns it. Documents that exist
+// are left alone, even without an owner.
+func (h *Hub) recordOwner(ctx context.Context, conn *Connection, docID string) bool {
+	userID := conn.VerifiedUserID()
+	if h.opts.ACL == nil || userID == "" {
+		return false
+	}
+	h.docsMu.RLock()
+	_, exists := h.documents[docID]
+	h.docsMu.RUnlock()
+	if exists {
+		return false
+	}
+
+	owner, err := h.opts.ACL.ClaimDocument(ctx, docID, userID)
+	if err != nil {
+		conn.Logger().Warn("Failed to record document owner", "doc_id", docID, "err", err)
+		return false
+	}
+	if owner == userID {
+		conn.Logger().Debug("Document owner recorded", "doc_id", docID)
+	}
+	return owner == userID
+}
+
+// createOwnDocument reports whether OpenDocumentCreation lets conn use a
+// document its token does not cover because the document does not exist
+// yet and is in conn's tenant; conn's user becomes its owner. Documents the
+// user already owns pass the ACL check without it.
+func (h *Hub) createOwnDocument(ctx context.Context, conn *Connection, docID string) bool {
+	if !h.opts.OpenDocumentCreation || !auth.WithinTenant(conn.TokenPayload, docID) {
+		return false
+	}
+	if !h.loadDocument(ctx, conn, docID) {
+		return false
+	}
+	return h.recordOwner(ctx, conn, docID)
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/acl"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// stuckACLStore holds principal lookups while stuck is set, until release
+// is closed
+type stuckACLStore struct {
+	acl.MemoryStore
+	stuck   atomic.Bool
+	release chan struct{}
+}
+
+func (s *stuckACLStore) PrincipalGrants(ctx context.Context, principal string) ([]*acl.Grant, error) {
+	if s.stuck.Load() {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return s.MemoryStore.PrincipalGrants(ctx, principal)
+}
+
+// joinWithToken authenticates a new connection with a token for userID
+// holding perms
+func joinWithToken(t *testing.T, hub *Hub, id, userID string, perms auth.DocumentPermissions) *Connection {
+	t.Helper()
+	token, err := auth.GenerateAccessToken(userID, "", perms, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken failed: %v", err)
+	}
+	conn := newTestConnection(hub, id)
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": token})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	return conn
+}
+
+func TestHub_PrefixBroadcastDoesNotWaitOnACLStore(t *testing.T) {
+	ctx := context.Background()
+	store := &stuckACLStore{release: make(chan struct{})}
+	defer close(store.release)
+	evaluator := acl.NewEvaluator(store, time.Minute)
+	evaluator.Grant(ctx, &acl.Grant{DocumentID: "room:granted:1", Principal: "bob", Role: acl.RoleRead})
+	evaluator.Grant(ctx, &acl.Grant{DocumentID: "room:granted:1", Principal: "carol", Role: acl.RoleRead})
+	hub := NewHubWithOptions(testAuth, HubOptions{ACL: evaluator})
+
+	writer := joinDirect(t, hub, "writer")
+	none := auth.CreateUserPermissions(nil, nil)
+	bob := joinWithToken(t, hub, "bob", "bob", none)
+	carol := joinWithToken(t, hub, "carol", "carol", none)
+	subscribePrefix(t, hub, bob, "room:granted:")
+	subscribePrefix(t, hub, carol, "room:granted:")
+
+	// Subscribing loaded the grants, so broadcasts find them cached
+	sendDelta(hub, writer, "room:granted:1", "title", "First")
+	expectMessage(t, writer, protocol.TypeAck)
+	expectMessage(t, bob, protocol.TypeDelta)
+	expectMessage(t, carol, protocol.TypeDelta)
+
+	// With the store stuck, carol's grants are no longer cached: the
+	// broadcast goes on without her instead of waiting for the store
+	store.stuck.Store(true)
+	evaluator.Invalidate("carol")
+	start := time.Now()
+	sendDelta(hub, writer, "room:granted:1", "title", "Second")
+	expectMessage(t, writer, protocol.TypeAck)
+	if msg := expectMessage(t, bob, protocol.TypeDelta); msg.Payload["changes"].(map[string]interface{})["title"] != "Second" {
+		t.Errorf("bob got %v, want the second delta", msg.Payload)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("broadcast took %v with the ACL store stuck", elapsed)
+	}
+	if len(carol.send) != 0 {
+		t.Error("carol received a delta without cached grants")
+	}
+}
+
+func TestHub_PrefixSubscriptionsResolveAccessOffTheRunGoroutine(t *testing.T) {
+	ctx := context.Background()
+	store := &stuckACLStore{release: make(chan struct{})}
+	var once sync.Once
+	release := func() { once.Do(func() { close(store.release) }) }
+	t.Cleanup(release)
+	evaluator := acl.NewEvaluator(store, time.Minute)
+	evaluator.Grant(ctx, &acl.Grant{DocumentID: "room:slow:1", Principal: "user-carol", Role: acl.RoleRead})
+	hub := startHub(t, HubOptions{ACL: evaluator})
+
+	writer := connectAnonymous(t, hub, "writer")
+	dispatch(hub, writer, protocol.TypeDelta, map[string]interface{}{"docId": "room:slow:1", "changes": map[string]interface{}{"a": 1.0}})
+	expectMessage(t, writer, protocol.TypeAck)
+	carol := connectWithToken(t, hub, "carol", auth.CreateUserPermissions(nil, nil))
+
+	store.stuck.Store(true)
+	evaluator.Invalidate("user-carol")
+	dispatch(hub, carol, protocol.TypeSubscribePrefix, map[string]interface{}{"prefix": "room:slow:"})
+	dispatch(hub, carol, protocol.TypeSubscribeList, map[string]interface{}{"prefix": "room:slow:"})
+
+	// Other clients carry on while carol's grants are read
+	dispatch(hub, writer, protocol.TypePing, nil)
+	expectMessage(t, writer, protocol.TypePong)
+
+	release()
+	for _, msgType := range []string{protocol.TypePrefixDocuments, protocol.TypeDocumentList} {
+		msg := expectMessage(t, carol, msgType)
+		if docIDs, _ := msg.Payload["docIds"].([]interface{}); len(docIDs) != 1 || docIDs[0] != "room:slow:1" {
+			t.Errorf("%s = %v, want the granted document", msgType, msg.Payload)
+		}
+	}
+}
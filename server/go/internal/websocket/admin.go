@@ -0,0 +1,164 @@
+package websocket
+
+import (
+	"context"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/schema"
+)
+
+// Disconnect closes a connection on behalf of an administrator. The client
+// receives a DISCONNECTED_BY_ADMIN error carrying the reason, then a close
+// frame. It reports whether the connection was found. Safe to call while the
+// hub is processing messages.
+func (h *Hub) Disconnect(connectionID, reason string) bool {
+	found := false
+	h.exec(func() {
+		h.mu.RLock()
+		conn := h.connections[connectionID]
+		h.mu.RUnlock()
+
+		if conn != nil {
+			h.kick(conn, "Disconnected by administrator", "DISCONNECTED_BY_ADMIN", reason)
+			found = true
+		}
+	})
+	return found
+}
+
+// DisconnectUser closes every connection authenticated as userID, like
+// Disconnect, and returns how many were closed
+func (h *Hub) DisconnectUser(userID, reason string) int {
+	count := 0
+	h.exec(func() {
+		h.mu.RLock()
+		conns := make([]*Connection, 0)
+		for _, conn := range h.connections {
+			if conn.Authenticated && conn.UserID == userID {
+				conns = append(conns, conn)
+			}
+		}
+		h.mu.RUnlock()
+
+		for _, conn := range conns {
+			h.kick(conn, "Disconnected by administrator", "DISCONNECTED_BY_ADMIN", reason)
+		}
+		count = len(conns)
+	})
+	return count
+}
+
+// DisconnectIP closes every connection from a banned IP. Clients receive an
+// IP_BANNED error carrying the reason. It returns how many were closed.
+func (h *Hub) DisconnectIP(ip, reason string) int {
+	count := 0
+	h.exec(func() {
+		h.mu.RLock()
+		conns := make([]*Connection, 0)
+		for _, conn := range h.connections {
+			if conn.ClientIP == ip {
+				conns = append(conns, conn)
+			}
+		}
+		h.mu.RUnlock()
+
+		for _, conn := range conns {
+			h.kick(conn, "IP address banned", "IP_BANNED", reason)
+		}
+		count = len(conns)
+	})
+	return count
+}
+
+// ValidateDocument checks a whole document state against the schema of
+// docID, for writers that replace documents instead of sending deltas.
+// RestoreDocument itself does not check, so administrators can restore
+// documents written before a schema was set.
+func (h *Hub) ValidateDocument(docID string, state map[string]interface{}) []schema.FieldError {
+	s, _ := h.opts.Schemas.Match(docID)
+	if s == nil {
+		return nil
+	}
+	return s.Validate(state)
+}
+
+// RestoreDocument replaces a document's state on behalf of an administrator,
+// e.g. with a snapshot. Conflict metadata and buffered broadcasts are
+// dropped, so clients resuming from before the restore fall back to a full
+// sync, and current subscribers are sent sync_required. With persistence
+// configured the new state is written through the document's write queue,
+// after any earlier writes, and RestoreDocument waits for it or ctx.
+func (h *Hub) RestoreDocument(ctx context.Context, docID string, state map[string]interface{}) error {
+	snapshot, existed := h.replaceDocument(docID, state)
+
+	var err error
+	if h.writer != nil {
+		written := make(chan error, 1)
+		h.enqueueWrite(docID, snapshot, func(err error) { written <- err })
+		select {
+		case err = <-written:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	event := DocumentEvent{Type: EventDocumentUpdated, DocID: docID, Changes: snapshot, Replaced: true}
+	if !existed {
+		event.Type = EventDocumentCreated
+		h.notifyListChanged(docID, ListAdded)
+	}
+	h.publishEvent(event)
+	h.requireSync(docID, DivergenceRestored)
+	return err
+}
+
+// replaceDocument swaps in a copy of state as a document's state, dropping
+// its conflict metadata and buffered broadcasts. It returns a snapshot of
+// the new state and whether the document existed before.
+func (h *Hub) replaceDocument(docID string, state map[string]interface{}) (map[string]interface{}, bool) {
+	doc := make(map[string]interface{}, len(state))
+	for k, v := range state {
+		doc[k] = v
+	}
+
+	h.docsMu.Lock()
+	defer h.docsMu.Unlock()
+	_, existed := h.documents[docID]
+	h.documents[docID] = doc
+	h.loaded[docID] = true
+	h.cache.reset(docID, documentSize(doc))
+	delete(h.meta, docID)
+	if hist := h.history[docID]; hist != nil {
+		hist.clear()
+	}
+	return h.documentSnapshot(docID), existed
+}
+
+// requireSync tells every subscriber of a document to re-sync it
+func (h *Hub) requireSync(docID, reason string) {
+	for _, conn := range h.deltaRecipients(docID, "") {
+		conn.divergence.reset(docID)
+		conn.Send(protocol.NewMessage(protocol.TypeSyncRequired, map[string]interface{}{
+			"docId":  docID,
+			"reason": reason,
+		}))
+	}
+}
+
+// kick tells the client why it is being dropped and unregisters it. Closing
+// lets WritePump flush the error before sending the close frame. Must be
+// called on the Run goroutine.
+func (h *Hub) kick(conn *Connection, errMsg, code, reason string) {
+	h.sendKick(conn, errMsg, code, reason)
+	h.unregister(conn)
+}
+
+// sendKick sends the error kick closes a connection with
+func (h *Hub) sendKick(conn *Connection, errMsg, code, reason string) {
+	conn.Logger().Info("Connection kicked", "code", code, "reason", reason)
+	conn.Send(protocol.NewMessage(protocol.TypeError, map[string]interface{}{
+		"error":  errMsg,
+		"code":   code,
+		"reason": reason,
+	}))
+}
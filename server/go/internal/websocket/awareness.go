@@ -0,0 +1,287 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// AwarenessStore shares awareness states between servers, so a client
+// subscribing on one server sees the clients on the others.
+// storage.RedisAwareness implements it.
+type AwarenessStore interface {
+	SetAwareness(ctx context.Context, documentID, clientID string, state map[string]interface{}) error
+	RemoveAwareness(ctx context.Context, documentID, clientID string) error
+	GetAwareness(ctx context.Context, documentID string) (map[string]map[string]interface{}, error)
+
+	// ExpireAwareness removes the clients not updated since cutoff and
+	// returns the ones this call removed, so each is expired once
+	ExpireAwareness(ctx context.Context, documentID string, cutoff time.Time) ([]string, error)
+}
+
+// awarenessThrottle limits how many awareness updates a connection fans out
+// per second. Updates over the budget are coalesced rather than rejected: only
+// the latest state per document is kept and sent when the window rolls over.
+//
+// The zero value is ready to use.
+type awarenessThrottle struct {
+	mu      sync.Mutex
+	window  time.Time                         // Start of the current one-second window
+	count   int                               // Updates sent in the current window
+	pending map[string]map[string]interface{} // docId -> latest held-back state
+	timer   *time.Timer
+}
+
+// admit reports whether an update may be sent now. Otherwise the state is
+// held as pending and flush is scheduled for the start of the next window.
+// A limit of 0 or less disables throttling.
+func (t *awarenessThrottle) admit(docID string, state map[string]interface{}, now time.Time, limit int, flush func()) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.window) >= time.Second {
+		t.window = now
+		t.count = 0
+	}
+
+	// A document with a held-back state keeps coalescing so updates never
+	// overtake each other
+	if t.count < limit && t.pending[docID] == nil {
+		t.count++
+		return true
+	}
+
+	if t.pending == nil {
+		t.pending = make(map[string]map[string]interface{})
+	}
+	t.pending[docID] = state
+	if t.timer == nil {
+		t.timer = time.AfterFunc(t.window.Add(time.Second).Sub(now), flush)
+	}
+	return false
+}
+
+// drain returns the held-back states and starts a new window that counts them
+func (t *awarenessThrottle) drain(now time.Time) map[string]map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending := t.pending
+	t.pending = nil
+	t.timer = nil
+	t.window = now
+	t.count = len(pending)
+	return pending
+}
+
+// stop cancels any scheduled flush and discards held-back states
+func (t *awarenessThrottle) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.pending = nil
+}
+
+// awarenessBases tracks the documents for which a connection was sent every
+// client's awareness state and has missed none since. Only those get
+// awareness updates as diffs; the rest get full states.
+//
+// The zero value is ready to use.
+type awarenessBases struct {
+	mu   sync.Mutex
+	docs map[string]bool
+}
+
+func (b *awarenessBases) has(docID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.docs[docID]
+}
+
+func (b *awarenessBases) add(docID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.docs == nil {
+		b.docs = make(map[string]bool)
+	}
+	b.docs[docID] = true
+}
+
+func (b *awarenessBases) forget(docID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.docs, docID)
+}
+
+// awarenessDiff returns the fields of next that prev lacks or holds a
+// different value for, and the fields prev has that next lacks. The
+// lastUpdate cleanup timestamp is left out: it changes every time and
+// means nothing to clients.
+func awarenessDiff(prev, next map[string]interface{}) (changed map[string]interface{}, removed []string) {
+	changed = make(map[string]interface{})
+	for k, v := range next {
+		if old, ok := prev[k]; k != "lastUpdate" && (!ok || !reflect.DeepEqual(old, v)) {
+			changed[k] = v
+		}
+	}
+	removed = []string{}
+	for k := range prev {
+		if _, ok := next[k]; !ok && k != "lastUpdate" {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// storeAwareness sets a client's awareness state for a document and returns
+// the one it replaces, nil if there was none
+func (h *Hub) storeAwareness(docID, clientID string, state map[string]interface{}) map[string]interface{} {
+	h.awareMu.Lock()
+	defer h.awareMu.Unlock()
+	if h.awareness[docID] == nil {
+		h.awareness[docID] = make(map[string]interface{})
+	}
+	prev, _ := h.awareness[docID][clientID].(map[string]interface{})
+	h.awareness[docID][clientID] = state
+	return prev
+}
+
+// awarenessSize returns the encoded size of an awareness state in bytes
+func awarenessSize(state map[string]interface{}) int {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// publishAwareness stores a client's awareness state and fans it out to the
+// other subscribers of the document. origin is the ID of the update that set
+// it, empty for states a throttle held back and coalesced.
+func (h *Hub) publishAwareness(conn *Connection, docID string, state map[string]interface{}, origin string) {
+	// Add lastUpdate timestamp for cleanup tracking
+	state["lastUpdate"] = float64(time.Now().UnixMilli())
+
+	prev := h.storeAwareness(docID, conn.ClientID, state)
+
+	// Broadcast to other subscribers
+	h.broadcastAwareness(docID, conn.ClientID, prev, state, conn.ID, origin)
+	h.relayAwareness(docID, conn.ClientID, state)
+
+	if store := h.opts.SharedAwareness; store != nil {
+		ctx, cancel := context.WithTimeout(h.handlingCtx, h.opts.MessageTimeout)
+		defer cancel()
+		if err := store.SetAwareness(ctx, docID, conn.ClientID, state); err != nil {
+			h.opts.Logger.Warn("Shared awareness update failed", "doc_id", docID, "err", err)
+		}
+	}
+}
+
+// deleteAwareness drops a client's state for a document, reporting whether
+// there was one
+func (h *Hub) deleteAwareness(docID, clientID string) bool {
+	h.awareMu.Lock()
+	defer h.awareMu.Unlock()
+	states, exists := h.awareness[docID]
+	if !exists {
+		return false
+	}
+	_, had := states[clientID]
+	delete(states, clientID)
+	if len(states) == 0 {
+		delete(h.awareness, docID)
+	}
+	return had
+}
+
+// announceAwarenessRemoved tells the document's subscribers, here and on
+// the other servers, that a client left, and removes its shared state.
+// Subscribers get its awareness_state with a null state.
+func (h *Hub) announceAwarenessRemoved(docID, clientID string) {
+	h.broadcastAwareness(docID, clientID, nil, nil, "", "")
+	h.relayAwarenessRemoved(docID, clientID)
+
+	if store := h.opts.SharedAwareness; store != nil {
+		ctx, cancel := context.WithTimeout(h.handlingCtx, h.opts.MessageTimeout)
+		defer cancel()
+		if err := store.RemoveAwareness(ctx, docID, clientID); err != nil {
+			h.opts.Logger.Warn("Shared awareness removal failed", "doc_id", docID, "err", err)
+		}
+	}
+}
+
+// awarenessStates returns the current states of a document's clients,
+// sorted by client ID. With a shared store, the states of clients on other
+// servers are fetched and kept, so they expire here like local ones.
+func (h *Hub) awarenessStates(ctx context.Context, docID string) []map[string]interface{} {
+	var shared map[string]map[string]interface{}
+	if store := h.opts.SharedAwareness; store != nil {
+		var err error
+		if shared, err = store.GetAwareness(ctx, docID); err != nil {
+			h.opts.Logger.Warn("Reading shared awareness failed", "doc_id", docID, "err", err)
+		}
+	}
+
+	cutoff := float64(time.Now().Add(-AwarenessTimeout).UnixMilli())
+	h.awareMu.Lock()
+	for clientID, state := range shared {
+		if lastUpdate, _ := state["lastUpdate"].(float64); lastUpdate <= cutoff {
+			continue
+		}
+		if h.awareness[docID] == nil {
+			h.awareness[docID] = make(map[string]interface{})
+		}
+		if _, local := h.awareness[docID][clientID]; !local {
+			h.awareness[docID][clientID] = state
+		}
+	}
+	states := make([]map[string]interface{}, 0, len(h.awareness[docID]))
+	for clientID, state := range h.awareness[docID] {
+		states = append(states, map[string]interface{}{"clientId": clientID, "state": state})
+	}
+	h.awareMu.Unlock()
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i]["clientId"].(string) < states[j]["clientId"].(string)
+	})
+	return states
+}
+
+// sendAwarenessStates answers awareness_subscribe with every client's
+// current state. Clients that negotiated awarenessDiff are sent later
+// updates to the document as diffs from here on.
+func (h *Hub) sendAwarenessStates(ctx context.Context, conn *Connection, docID string) {
+	err := conn.Send(protocol.NewMessage(protocol.TypeAwarenessState, map[string]interface{}{
+		"docId":  docID,
+		"states": h.awarenessStates(ctx, docID),
+	}).WithOrigin(conn.handling()))
+	if err == nil && conn.can(capAwarenessDiff) {
+		conn.awarenessBases.add(docID)
+	}
+}
+
+// flushAwareness sends the awareness states a connection's throttle held back.
+// It runs on the throttle's timer goroutine.
+func (h *Hub) flushAwareness(conn *Connection) {
+	pending := conn.awarenessThrottle.drain(time.Now())
+	if conn.IsClosed() {
+		return
+	}
+	for docID, state := range pending {
+		h.publishAwareness(conn, docID, state, "")
+	}
+}
@@ -0,0 +1,223 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType says what a DocumentEvent reports
+type EventType string
+
+// Document event types
+const (
+	EventDocumentCreated  EventType = "document.created"  // The first delta or restore of a document
+	EventDocumentUpdated  EventType = "document.updated"  // A later delta or restore
+	EventDocumentDeleted  EventType = "document.deleted"  // The document was deleted
+	EventSubscriberJoined EventType = "subscriber.joined" // A connection subscribed
+	EventSubscriberLeft   EventType = "subscriber.left"   // A connection unsubscribed or closed
+	EventAwareness        EventType = "awareness.changed" // A client's awareness state was set or removed
+)
+
+// DefaultEventBuffer is how many events wait for a subscriber of Subscribe
+// when EventFilter.Buffer is not set
+const DefaultEventBuffer = 256
+
+// EventFilter chooses the events a subscriber of Subscribe is sent
+type EventFilter struct {
+	Prefixes []string    // Documents whose IDs start with one of these; empty for every document
+	Types    []EventType // Empty for every type
+	Buffer   int         // Events held for a slow reader; DefaultEventBuffer if 0
+}
+
+// DocumentEvent is a change to a document applied on this server, including
+// deltas relayed from other servers. Its maps are shared with other
+// subscribers and must not be modified.
+type DocumentEvent struct {
+	Type  EventType
+	DocID string
+	Time  time.Time
+
+	// Created and updated: the fields the delta set, nil values deleting
+	// them, and its sequence number. For a restored document Replaced is
+	// set, Changes is its whole new state and Seq is 0.
+	Changes  map[string]interface{}
+	Seq      int64
+	Replaced bool
+
+	// Who caused the event: the connection and its user, empty for changes
+	// from other servers or the admin API. Awareness events name the client
+	// in ClientID, with State nil once it left.
+	ConnID   string
+	UserID   string
+	ClientID string
+	State    map[string]interface{}
+
+	// Dropped counts the events this subscriber missed just before this
+	// one because its buffer was full
+	Dropped uint64
+}
+
+// eventBus fans document events out to the subscribers of Subscribe. Events
+// are never waited on: one that finds a subscriber's buffer full is dropped
+// for that subscriber, which learns of it from the next event's Dropped.
+// The zero value is ready to use.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[*eventSubscriber]bool
+	closed bool
+}
+
+type eventSubscriber struct {
+	filter  EventFilter
+	ch      chan DocumentEvent
+	dropped uint64 // Guarded by eventBus.mu
+}
+
+// matches reports whether the subscriber wants event
+func (s *eventSubscriber) matches(event *DocumentEvent) bool {
+	if len(s.filter.Types) > 0 {
+		wanted := false
+		for _, t := range s.filter.Types {
+			if t == event.Type {
+				wanted = true
+				break
+			}
+		}
+		if !wanted {
+			return false
+		}
+	}
+	if len(s.filter.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.filter.Prefixes {
+		if strings.HasPrefix(event.DocID, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe returns a channel of the document events matching filter. The
+// channel is closed when ctx is done or the hub stops. Events are sent
+// without waiting: when a subscriber falls Buffer events behind, newer
+// events are dropped for it until it catches up, and the next event it is
+// sent counts them in Dropped. A subscriber that sees Dropped should re-read
+// the documents it follows.
+func (h *Hub) Subscribe(ctx context.Context, filter EventFilter) (<-chan DocumentEvent, error) {
+	for _, t := range filter.Types {
+		switch t {
+		case EventDocumentCreated, EventDocumentUpdated, EventDocumentDeleted,
+			EventSubscriberJoined, EventSubscriberLeft, EventAwareness:
+		default:
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	if filter.Buffer <= 0 {
+		filter.Buffer = DefaultEventBuffer
+	}
+	sub := &eventSubscriber{filter: filter, ch: make(chan DocumentEvent, filter.Buffer)}
+
+	h.events.mu.Lock()
+	if h.events.closed {
+		h.events.mu.Unlock()
+		return nil, errHubStopped
+	}
+	if h.events.subs == nil {
+		h.events.subs = make(map[*eventSubscriber]bool)
+	}
+	h.events.subs[sub] = true
+	h.events.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-h.Done():
+		}
+		h.events.remove(sub)
+	}()
+	return sub.ch, nil
+}
+
+// remove unsubscribes sub and closes its channel
+func (b *eventBus) remove(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// close closes every subscriber's channel and refuses new subscribers
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.ch)
+	}
+	b.subs = nil
+}
+
+// publish sends event to the subscribers that want it, returning how many
+// it was dropped for
+func (b *eventBus) publish(event DocumentEvent) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dropped := 0
+	for sub := range b.subs {
+		if !sub.matches(&event) {
+			continue
+		}
+		sent := event
+		sent.Dropped = sub.dropped
+		select {
+		case sub.ch <- sent:
+			sub.dropped = 0
+		default:
+			sub.dropped++
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// publishEvent stamps event and hands it to the subscribers of Subscribe
+func (h *Hub) publishEvent(event DocumentEvent) {
+	event.Time = time.Now()
+	if dropped := h.events.publish(event); dropped > 0 {
+		h.metrics.eventsDropped.Add(uint64(dropped))
+	}
+}
+
+// publishChanges reports applied deltas numbered first, first+1, ... sent
+// by sender (nil for another server's). created says the first of them
+// created the document.
+func (h *Hub) publishChanges(docID string, first int64, deltas []map[string]interface{}, created bool, sender *Connection) {
+	for i, delta := range deltas {
+		event := DocumentEvent{Type: EventDocumentUpdated, DocID: docID, Seq: first + int64(i)}
+		if created && i == 0 {
+			event.Type = EventDocumentCreated
+		}
+		event.Changes, _ = delta["changes"].(map[string]interface{})
+		if sender != nil {
+			event.ConnID, event.UserID, event.ClientID = sender.ID, sender.UserID, sender.ClientID
+		}
+		h.publishEvent(event)
+	}
+}
+
+// publishSubscriber reports a connection joining or leaving a document
+func (h *Hub) publishSubscriber(eventType EventType, conn *Connection, docID string) {
+	h.publishEvent(DocumentEvent{
+		Type:     eventType,
+		DocID:    docID,
+		ConnID:   conn.ID,
+		UserID:   conn.UserID,
+		ClientID: conn.ClientID,
+	})
+}
@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// nextEvent waits for the next event on events
+func nextEvent(t *testing.T, events <-chan DocumentEvent) DocumentEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("event channel closed")
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return DocumentEvent{}
+}
+
+// expectNoEvent checks that nothing is waiting on events
+func expectNoEvent(t *testing.T, events <-chan DocumentEvent) {
+	t.Helper()
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %s %s", event.Type, event.DocID)
+	default:
+	}
+}
+
+func TestHub_SubscribeRoutesByPrefixAndType(t *testing.T) {
+	hub := NewHub(testAuth)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// One reader follows everything under room:a:, the other documents
+	// coming and going anywhere
+	rooms, err := hub.Subscribe(ctx, EventFilter{Prefixes: []string{"room:a:"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lifecycle, err := hub.Subscribe(ctx, EventFilter{Types: []EventType{EventDocumentCreated, EventDocumentDeleted}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := joinDirect(t, hub, "writer", "room:a:1")
+	sendDelta(hub, conn, "room:a:1", "title", "First")
+	expectMessage(t, conn, protocol.TypeAck)
+	sendDelta(hub, conn, "room:a:1", "title", "Second")
+	expectMessage(t, conn, protocol.TypeAck)
+	sendDelta(hub, conn, "room:b:1", "title", "Elsewhere")
+	expectMessage(t, conn, protocol.TypeAck)
+	handleDirect(hub, conn, protocol.TypeAwarenessUpdate, map[string]interface{}{"docId": "room:a:1", "state": map[string]interface{}{"cursor": 3.0}})
+	handleDirect(hub, conn, protocol.TypeUnsubscribe, map[string]interface{}{"docId": "room:a:1"})
+	hub.DeleteDocument("room:a:1")
+
+	type seen struct {
+		Type  EventType
+		DocID string
+	}
+	for _, want := range []seen{
+		{EventSubscriberJoined, "room:a:1"},
+		{EventDocumentCreated, "room:a:1"},
+		{EventDocumentUpdated, "room:a:1"},
+		{EventAwareness, "room:a:1"},
+		{EventSubscriberLeft, "room:a:1"},
+		{EventDocumentDeleted, "room:a:1"},
+	} {
+		event := nextEvent(t, rooms)
+		if (seen{event.Type, event.DocID}) != want {
+			t.Fatalf("room:a: reader got %s %s, want %s %s", event.Type, event.DocID, want.Type, want.DocID)
+		}
+		switch event.Type {
+		case EventDocumentUpdated:
+			if event.Changes["title"] != "Second" || event.Seq != 2 || event.UserID != "user-writer" {
+				t.Errorf("update = %+v, want title Second at seq 2 by user-writer", event)
+			}
+		case EventSubscriberJoined:
+			if event.ConnID != conn.ID {
+				t.Errorf("joined connection = %q, want %q", event.ConnID, conn.ID)
+			}
+		case EventSubscriberLeft:
+			// Leaving removes the connection's awareness state
+			if event := nextEvent(t, rooms); event.Type != EventAwareness || event.State != nil || event.ClientID != conn.ClientID {
+				t.Errorf("after leaving got %+v, want its awareness state removed", event)
+			}
+		}
+	}
+	expectNoEvent(t, rooms)
+
+	for _, want := range []seen{
+		{EventDocumentCreated, "room:a:1"},
+		{EventDocumentCreated, "room:b:1"},
+		{EventDocumentDeleted, "room:a:1"},
+	} {
+		if event := nextEvent(t, lifecycle); (seen{event.Type, event.DocID}) != want {
+			t.Fatalf("lifecycle reader got %s %s, want %s %s", event.Type, event.DocID, want.Type, want.DocID)
+		}
+	}
+	expectNoEvent(t, lifecycle)
+
+	if _, err := hub.Subscribe(ctx, EventFilter{Types: []EventType{"document.touched"}}); err == nil {
+		t.Error("Subscribe accepted an unknown event type")
+	}
+}
+
+func TestHub_SubscribeDropsForSlowReaders(t *testing.T) {
+	hub := NewHub(testAuth)
+	ctx, cancel := context.WithCancel(context.Background())
+	slow, err := hub.Subscribe(ctx, EventFilter{Types: []EventType{EventDocumentCreated, EventDocumentUpdated}, Buffer: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := hub.Subscribe(ctx, EventFilter{Types: []EventType{EventDocumentCreated, EventDocumentUpdated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deltas go on while the slow reader reads nothing
+	conn := joinDirect(t, hub, "writer")
+	for i := 1; i <= 5; i++ {
+		sendDelta(hub, conn, "room:busy", "n", float64(i))
+		expectMessage(t, conn, protocol.TypeAck)
+	}
+	for seq := int64(1); seq <= 2; seq++ {
+		if event := nextEvent(t, slow); event.Seq != seq || event.Dropped != 0 {
+			t.Errorf("buffered event = seq %d dropped %d, want seq %d", event.Seq, event.Dropped, seq)
+		}
+	}
+	expectNoEvent(t, slow)
+
+	// Once it has room again the next event counts what it missed
+	sendDelta(hub, conn, "room:busy", "n", 6.0)
+	expectMessage(t, conn, protocol.TypeAck)
+	if event := nextEvent(t, slow); event.Seq != 6 || event.Dropped != 3 {
+		t.Errorf("event after the gap = seq %d dropped %d, want seq 6 dropped 3", event.Seq, event.Dropped)
+	}
+	if dropped := hub.metrics.eventsDropped.Value(); dropped != 3 {
+		t.Errorf("eventsDropped = %d, want 3", dropped)
+	}
+
+	// The other reader was not held up
+	for seq := int64(1); seq <= 6; seq++ {
+		if event := nextEvent(t, fast); event.Seq != seq || event.Dropped != 0 {
+			t.Errorf("fast reader event = seq %d dropped %d, want seq %d", event.Seq, event.Dropped, seq)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-slow:
+		if ok {
+			t.Error("event after the context was cancelled")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("channel not closed after the context was cancelled")
+	}
+}
+
+func TestHub_SubscribeClosesOnStop(t *testing.T) {
+	hub := startHub(t, HubOptions{})
+	events, err := hub.Subscribe(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	hub.Stop(ctx)
+
+	if _, ok := <-events; ok {
+		t.Error("event channel still open after Stop")
+	}
+	if _, err := hub.Subscribe(context.Background(), EventFilter{}); err == nil {
+		t.Error("Subscribe succeeded after Stop")
+	}
+}
@@ -0,0 +1,368 @@
+package websocket
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDocumentIdleTime is how long after its last change a document
+// nobody is subscribed to may be evicted
+const DefaultDocumentIdleTime = 30 * time.Second
+
+// minEvictionInterval bounds how often eviction is retried while the cache
+// stays over its limits
+const minEvictionInterval = 100 * time.Millisecond
+
+// documentCache orders the hub's in-memory documents by last use and tracks
+// their approximate size and unwritten changes. With limits set, the least
+// recently used documents are evicted once the hub holds more than
+// HubOptions.MaxDocuments documents or MaxDocumentBytes bytes.
+//
+// Safe for concurrent use. When both are held, docsMu is taken first.
+type documentCache struct {
+	maxDocs  int
+	maxBytes int64
+	idle     time.Duration
+
+	mu      sync.Mutex
+	order   *list.List               // *cacheEntry, most recently used first
+	entries map[string]*list.Element // docId -> element of order
+	bytes   int64
+
+	over chan struct{} // Signalled when the cache may have room to reclaim
+}
+
+type cacheEntry struct {
+	docID   string
+	size    int64     // Approximate bytes held by the document's fields
+	changed time.Time // Last change, local or relayed
+	pending int       // Writes enqueued and not yet finished
+	failed  bool      // The last finished write failed
+}
+
+func newDocumentCache(maxDocs int, maxBytes int64, idle time.Duration) *documentCache {
+	return &documentCache{
+		maxDocs:  maxDocs,
+		maxBytes: maxBytes,
+		idle:     idle,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		over:     make(chan struct{}, 1),
+	}
+}
+
+// limited reports whether the cache has a limit to enforce
+func (c *documentCache) limited() bool {
+	return c.maxDocs > 0 || c.maxBytes > 0
+}
+
+// entry returns a document's entry, creating it at the front. Must be
+// called with c.mu held.
+func (c *documentCache) entry(docID string) *cacheEntry {
+	if el := c.entries[docID]; el != nil {
+		c.order.MoveToFront(el)
+		return el.Value.(*cacheEntry)
+	}
+	e := &cacheEntry{docID: docID}
+	c.entries[docID] = c.order.PushFront(e)
+	return e
+}
+
+// use marks a document as the most recently used, growing its size by
+// grow. changed records that its state changed.
+func (c *documentCache) use(docID string, grow int64, changed bool) {
+	c.mu.Lock()
+	e := c.entry(docID)
+	e.size += grow
+	c.bytes += grow
+	if changed {
+		e.changed = time.Now()
+	}
+	full := c.exceeded()
+	c.mu.Unlock()
+	if full {
+		c.signal()
+	}
+}
+
+// reset replaces a document's size after its whole state was swapped in
+func (c *documentCache) reset(docID string, size int64) {
+	c.mu.Lock()
+	e := c.entry(docID)
+	c.bytes += size - e.size
+	e.size = size
+	e.changed = time.Now()
+	full := c.exceeded()
+	c.mu.Unlock()
+	if full {
+		c.signal()
+	}
+}
+
+// remove forgets a document that is no longer in memory
+func (c *documentCache) remove(docID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el := c.entries[docID]; el != nil {
+		c.bytes -= el.Value.(*cacheEntry).size
+		c.order.Remove(el)
+		delete(c.entries, docID)
+	}
+}
+
+// writeStarted records a write of a document's state being enqueued
+func (c *documentCache) writeStarted(docID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el := c.entries[docID]; el != nil {
+		el.Value.(*cacheEntry).pending++
+	}
+}
+
+// writeFinished records the outcome of an enqueued write. Every write
+// carries the whole state, so a success covers all earlier changes.
+func (c *documentCache) writeFinished(docID string, err error) {
+	c.mu.Lock()
+	full := false
+	if el := c.entries[docID]; el != nil {
+		e := el.Value.(*cacheEntry)
+		if e.pending > 0 {
+			e.pending--
+		}
+		e.failed = err != nil
+		full = e.pending == 0 && c.exceeded()
+	}
+	c.mu.Unlock()
+	if full {
+		c.signal()
+	}
+}
+
+// evictable reports whether a document's changes have all been written and
+// it has been idle long enough to drop
+func (c *documentCache) evictable(docID string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el := c.entries[docID]
+	if el == nil {
+		return false
+	}
+	e := el.Value.(*cacheEntry)
+	return e.pending == 0 && !e.failed && now.Sub(e.changed) >= c.idle
+}
+
+// unwritten reports whether a document's last write failed with no other
+// write enqueued since, so its changes exist only in memory
+func (c *documentCache) unwritten(docID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el := c.entries[docID]
+	return el != nil && el.Value.(*cacheEntry).pending == 0 && el.Value.(*cacheEntry).failed
+}
+
+// idleEntries returns copies of the entries not changed within the idle
+// time nor being written, least recently used first, along with how many
+// documents the cache holds and their size. Nothing is returned while the
+// cache is within its limits.
+func (c *documentCache) idleEntries(now time.Time) ([]cacheEntry, int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.exceeded() {
+		return nil, len(c.entries), c.bytes
+	}
+	var idle []cacheEntry
+	for el := c.order.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*cacheEntry)
+		if e.pending == 0 && now.Sub(e.changed) >= c.idle {
+			idle = append(idle, *e)
+		}
+	}
+	return idle, len(c.entries), c.bytes
+}
+
+// stats returns how many documents the cache holds and their approximate
+// size in bytes
+func (c *documentCache) stats() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.bytes
+}
+
+// exceeded reports whether the cache is over a limit. Must be called with
+// c.mu held.
+func (c *documentCache) exceeded() bool {
+	return c.exceeds(len(c.entries), c.bytes)
+}
+
+func (c *documentCache) exceeds(count int, bytes int64) bool {
+	return (c.maxDocs > 0 && count > c.maxDocs) || (c.maxBytes > 0 && bytes > c.maxBytes)
+}
+
+// signal wakes the eviction loop without waiting
+func (c *documentCache) signal() {
+	select {
+	case c.over <- struct{}{}:
+	default:
+	}
+}
+
+// approxSize estimates the memory a decoded JSON value holds
+func approxSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case string:
+		return 16 + int64(len(v))
+	case map[string]interface{}:
+		return documentSize(v)
+	case []interface{}:
+		n := int64(24)
+		for _, item := range v {
+			n += approxSize(item)
+		}
+		return n
+	default:
+		return 16
+	}
+}
+
+// documentSize estimates the memory held by a document's fields
+func documentSize(doc map[string]interface{}) int64 {
+	n := int64(48)
+	for k, v := range doc {
+		n += 16 + int64(len(k)) + approxSize(v)
+	}
+	return n
+}
+
+// fieldGrowth is how much setting field k of doc to v changes the
+// document's approximate size
+func fieldGrowth(doc map[string]interface{}, k string, v interface{}) int64 {
+	old, exists := doc[k]
+	if !exists {
+		return 16 + int64(len(k)) + approxSize(v)
+	}
+	return approxSize(v) - approxSize(old)
+}
+
+// evicts reports whether the hub evicts documents: it needs limits, and
+// storage to write documents to and read them back from
+func (h *Hub) evicts() bool {
+	return h.cache.limited() && h.writer != nil && h.opts.Load != nil
+}
+
+// runEviction evicts documents whenever the cache grows past its limits,
+// and retries periodically while it stays there
+func (h *Hub) runEviction() {
+	interval := h.cache.idle
+	if interval < minEvictionInterval {
+		interval = minEvictionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopChan:
+			return
+		case <-h.cache.over:
+		case <-ticker.C:
+		}
+		h.exec(h.scheduleEvictions)
+	}
+}
+
+// scheduleEvictions picks the least recently used idle documents nobody is
+// subscribed to that bring the cache back within its limits, and hands each
+// to the worker owning it, where no message for the document can be half
+// handled. Must be called on the Run goroutine.
+func (h *Hub) scheduleEvictions() {
+	idle, count, bytes := h.cache.idleEntries(time.Now())
+	for _, e := range idle {
+		if !h.cache.exceeds(count, bytes) {
+			return
+		}
+		if h.pinned(e.docID) {
+			continue
+		}
+		docID := e.docID
+		h.runOnWorker(docID, func() { h.evictDocument(docID) })
+		count--
+		bytes -= e.size
+	}
+}
+
+// pinned reports whether a document must stay in memory because it has
+// subscribers here, directly or through a prefix subscription, or deltas
+// relayed from other servers
+func (h *Hub) pinned(docID string) bool {
+	h.mu.RLock()
+	subscribed := len(h.subscribers[docID]) > 0
+	for prefix, subs := range h.prefixSubscribers {
+		if len(subs) > 0 && strings.HasPrefix(docID, prefix) {
+			subscribed = true
+			break
+		}
+	}
+	h.mu.RUnlock()
+	h.relaySubs.mu.Lock()
+	defer h.relaySubs.mu.Unlock()
+	return subscribed || h.relaySubs.docs[docID]
+}
+
+// evictDocument drops a document from memory if it is not pinned and all
+// its changes have been written; the next use loads it again. A document
+// whose last write failed is written again instead, to be evicted once that
+// succeeds. Must be called on the document's worker.
+func (h *Hub) evictDocument(docID string) {
+	if h.pinned(docID) {
+		return
+	}
+
+	h.docsMu.Lock()
+	if h.cache.unwritten(docID) {
+		if h.Degraded() {
+			// Saved in full once storage is back
+			h.docsMu.Unlock()
+			return
+		}
+		state := h.documentSnapshot(docID)
+		h.docsMu.Unlock()
+		h.enqueueWrite(docID, state, func(err error) {
+			if err != nil {
+				h.metrics.persistFailures.Add(1)
+				h.opts.Logger.Error("Document write failed", "doc_id", docID, "err", err)
+			}
+		})
+		return
+	}
+	if !h.cache.evictable(docID, time.Now()) {
+		h.docsMu.Unlock()
+		return
+	}
+	// Sequence numbers, conflict metadata and relay versions stay, so
+	// numbering and last-writer-wins carry on once the document is loaded
+	// again; only the state and the resume buffer are freed
+	delete(h.documents, docID)
+	delete(h.loaded, docID)
+	if hist := h.history[docID]; hist != nil {
+		hist.release()
+	}
+	h.cache.remove(docID)
+	h.docsMu.Unlock()
+
+	h.metrics.documentEvictions.Add(1)
+	h.opts.Logger.Debug("Document evicted", "doc_id", docID)
+}
+
+// enqueueWrite hands a document's state to the writer, tracking the write
+// so the document is not evicted before its changes are stored
+func (h *Hub) enqueueWrite(docID string, state map[string]interface{}, done func(error)) {
+	h.cache.writeStarted(docID)
+	h.writer.Enqueue(docID, state, func(err error) {
+		h.cache.writeFinished(docID, err)
+		if done != nil {
+			done(err)
+		}
+	})
+}
@@ -0,0 +1,297 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// mapStore is a PersistFunc and LoadFunc backend kept in a map. Writes to
+// documents in failing return an error.
+type mapStore struct {
+	mu      sync.Mutex
+	docs    map[string]map[string]interface{}
+	failing map[string]bool
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{docs: make(map[string]map[string]interface{}), failing: make(map[string]bool)}
+}
+
+func (s *mapStore) persist(ctx context.Context, docID string, state map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing[docID] {
+		return errors.New("storage unavailable")
+	}
+	s.docs[docID] = state
+	return nil
+}
+
+func (s *mapStore) load(ctx context.Context, docID string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.docs[docID]
+	if !ok {
+		return nil, nil
+	}
+	copied := make(map[string]interface{}, len(state))
+	for k, v := range state {
+		copied[k] = v
+	}
+	return copied, nil
+}
+
+func (s *mapStore) setFailing(docID string, failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing[docID] = failing
+}
+
+// startCachedHub runs a hub persisting to store that evicts documents as
+// soon as they are written once it holds more than maxDocs
+func startCachedHub(t *testing.T, store *mapStore, maxDocs int, maxBytes int64) *Hub {
+	t.Helper()
+	return startHub(t, HubOptions{
+		Persist:          store.persist,
+		Load:             store.load,
+		MaxDocuments:     maxDocs,
+		MaxDocumentBytes: maxBytes,
+		DocumentIdleTime: time.Millisecond,
+		Workers:          4,
+	})
+}
+
+// writeDocuments sends one delta to each of docs first to last-1, setting
+// "n" to the document's number, and waits for every ACK
+func writeDocuments(t *testing.T, hub *Hub, conn *Connection, first, last int) {
+	t.Helper()
+	for i := first; i < last; i++ {
+		dispatch(hub, conn, protocol.TypeDelta, map[string]interface{}{
+			"docId":   fmt.Sprintf("room:%d", i),
+			"changes": map[string]interface{}{"n": float64(i)},
+		})
+		expectMessage(t, conn, protocol.TypeAck)
+	}
+}
+
+// waitForCache waits until the hub holds at most maxDocs documents
+func waitForCache(t *testing.T, hub *Hub, maxDocs int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		m := hub.Metrics()
+		if m.DocumentsCached <= maxDocs {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cache holds %d documents, want at most %d", m.DocumentsCached, maxDocs)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func documentHeld(hub *Hub, docID string) bool {
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	_, held := hub.documents[docID]
+	return held
+}
+
+func TestHub_EvictsLeastRecentlyUsedDocumentsAndReloadsThem(t *testing.T) {
+	store := newMapStore()
+	hub := startCachedHub(t, store, 10, 0)
+	writer := connectAnonymous(t, hub, "writer")
+
+	writeDocuments(t, hub, writer, 0, 100)
+	waitForCache(t, hub, 10)
+
+	hub.docsMu.RLock()
+	held := len(hub.documents)
+	hub.docsMu.RUnlock()
+	if held > 10 {
+		t.Errorf("hub holds %d documents, want at most 10", held)
+	}
+	m := hub.Metrics()
+	if m.DocumentEvictions < 90 {
+		t.Errorf("DocumentEvictions = %d, want at least 90", m.DocumentEvictions)
+	}
+	if documentHeld(hub, "room:0") {
+		t.Error("least recently used document was kept")
+	}
+
+	// Evicted documents come back from storage as they were written
+	reader := connectAnonymous(t, hub, "reader")
+	for _, i := range []int{0, 42, 99} {
+		dispatch(hub, reader, protocol.TypeSubscribe, map[string]interface{}{"docId": fmt.Sprintf("room:%d", i)})
+		sync := expectMessage(t, reader, protocol.TypeSyncResponse)
+		if state, _ := sync.Payload["state"].(map[string]interface{}); state["n"] != float64(i) {
+			t.Errorf("room:%d state = %v, want n=%d", i, sync.Payload["state"], i)
+		}
+	}
+	if reloads := hub.Metrics().DocumentReloads; reloads < 2 {
+		t.Errorf("DocumentReloads = %d, want at least 2", reloads)
+	}
+
+	// A delta to an evicted document applies on top of its stored state
+	dispatch(hub, writer, protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:1",
+		"changes": map[string]interface{}{"extra": true},
+	})
+	expectMessage(t, writer, protocol.TypeAck)
+	flushHub(t, hub)
+	hub.docsMu.RLock()
+	doc := hub.documentSnapshot("room:1")
+	hub.docsMu.RUnlock()
+	if doc["n"] != float64(1) || doc["extra"] != true {
+		t.Errorf("room:1 = %v, want its stored fields and the new one", doc)
+	}
+}
+
+func TestHub_EvictsPastMaxDocumentBytes(t *testing.T) {
+	store := newMapStore()
+	const maxBytes = 4096
+	hub := startCachedHub(t, store, 0, maxBytes)
+	writer := connectAnonymous(t, hub, "writer")
+
+	writeDocuments(t, hub, writer, 0, 200)
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.Metrics().DocumentBytes > maxBytes {
+		if time.Now().After(deadline) {
+			t.Fatalf("cache holds %d bytes, want at most %d", hub.Metrics().DocumentBytes, maxBytes)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if m := hub.Metrics(); m.DocumentEvictions == 0 || m.DocumentsCached == 0 {
+		t.Errorf("metrics = %+v, want some documents evicted and some kept", m)
+	}
+}
+
+func TestHub_KeepsSubscribedDocumentsInMemory(t *testing.T) {
+	store := newMapStore()
+	hub := startCachedHub(t, store, 5, 0)
+	watcher := connectAnonymous(t, hub, "watcher")
+	subscribe(t, hub, watcher, "room:0")
+	writer := connectAnonymous(t, hub, "writer")
+
+	writeDocuments(t, hub, writer, 0, 50)
+	waitForCache(t, hub, 5)
+	if !documentHeld(hub, "room:0") {
+		t.Error("document with a subscriber was evicted")
+	}
+
+	// Once nobody is subscribed it may go too
+	dispatch(hub, watcher, protocol.TypeUnsubscribe, map[string]interface{}{"docId": "room:0"})
+	flushHub(t, hub)
+	writeDocuments(t, hub, writer, 50, 100)
+	waitForCache(t, hub, 5)
+	if documentHeld(hub, "room:0") {
+		t.Error("unsubscribed document was kept past newer ones")
+	}
+}
+
+func TestHub_KeepsPrefixSubscribedDocumentsInMemory(t *testing.T) {
+	store := newMapStore()
+	hub := startCachedHub(t, store, 5, 0)
+	watcher := connectAnonymous(t, hub, "watcher")
+	dispatch(hub, watcher, protocol.TypeSubscribePrefix, map[string]interface{}{"prefix": "room:42"})
+	expectMessage(t, watcher, protocol.TypePrefixDocuments)
+	writer := connectAnonymous(t, hub, "writer")
+
+	writeDocuments(t, hub, writer, 0, 50)
+	waitForCache(t, hub, 5)
+	if !documentHeld(hub, "room:42") {
+		t.Error("document watched through a prefix was evicted")
+	}
+}
+
+func TestHub_EvictionKeepsSequenceAndFieldTimes(t *testing.T) {
+	store := newMapStore()
+	hub := startCachedHub(t, store, 5, 0)
+	writer := connectAnonymous(t, hub, "writer")
+
+	now := float64(time.Now().UnixMilli())
+	for i, ts := range []float64{now - 2000, now} {
+		dispatch(hub, writer, protocol.TypeDelta, map[string]interface{}{
+			"docId":     "room:kept",
+			"timestamp": ts,
+			"changes":   map[string]interface{}{"title": fmt.Sprintf("v%d", i+1)},
+		})
+		expectMessage(t, writer, protocol.TypeAck)
+	}
+	writeDocuments(t, hub, writer, 0, 50)
+	waitForCache(t, hub, 5)
+	if documentHeld(hub, "room:kept") {
+		t.Fatal("room:kept was not evicted")
+	}
+
+	// A late write older than the stored one still loses after the reload
+	dispatch(hub, writer, protocol.TypeDelta, map[string]interface{}{
+		"docId":     "room:kept",
+		"timestamp": now - 1000,
+		"changes":   map[string]interface{}{"title": "late"},
+	})
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["reason"] != RejectStale {
+		t.Errorf("late delta ack = %v, want rejected as stale", ack.Payload)
+	}
+
+	// Numbering carries on where it stopped
+	dispatch(hub, writer, protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:kept",
+		"changes": map[string]interface{}{"title": "v3"},
+	})
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["seq"] != 3.0 {
+		t.Errorf("seq after reload = %v, want 3", ack.Payload["seq"])
+	}
+	hub.docsMu.RLock()
+	doc := hub.documentSnapshot("room:kept")
+	hub.docsMu.RUnlock()
+	if doc["title"] != "v3" {
+		t.Errorf("room:kept = %v, want title v3", doc)
+	}
+}
+
+func TestHub_NeverEvictsUnwrittenChanges(t *testing.T) {
+	store := newMapStore()
+	store.setFailing("room:0", true)
+	hub := startCachedHub(t, store, 5, 0)
+	writer := connectAnonymous(t, hub, "writer")
+
+	// The failed document holds its place, leaving one over the limit
+	writeDocuments(t, hub, writer, 0, 50)
+	waitForCache(t, hub, 6)
+	if !documentHeld(hub, "room:0") {
+		t.Fatal("document whose write failed was evicted")
+	}
+
+	// Once storage is back the document is written again and evicted when
+	// newer documents need the room
+	store.setFailing("room:0", false)
+	deadline := time.Now().Add(2 * time.Second)
+	for next := 50; documentHeld(hub, "room:0"); next += 10 {
+		if time.Now().After(deadline) {
+			t.Fatal("document was not evicted after storage recovered")
+		}
+		writeDocuments(t, hub, writer, next, next+10)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if state, _ := store.load(context.Background(), "room:0"); state["n"] != float64(0) {
+		t.Errorf("stored room:0 = %v, want n=0", state)
+	}
+}
+
+func TestHub_NoEvictionWithoutStorage(t *testing.T) {
+	hub := startHub(t, HubOptions{MaxDocuments: 5, DocumentIdleTime: time.Millisecond})
+	writer := connectAnonymous(t, hub, "writer")
+
+	writeDocuments(t, hub, writer, 0, 20)
+	time.Sleep(50 * time.Millisecond)
+	if m := hub.Metrics(); m.DocumentsCached != 20 || m.DocumentEvictions != 0 {
+		t.Errorf("cached %d, evicted %d; want every document kept in memory", m.DocumentsCached, m.DocumentEvictions)
+	}
+}
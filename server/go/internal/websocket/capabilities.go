@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// capabilities is the set of protocol capabilities negotiated with a client
+type capabilities uint32
+
+const (
+	capCompression capabilities = 1 << iota
+	capMsgpack
+	capResume
+	capBatching
+	capChecksum
+	capServerSeq
+	capAwarenessDiff
+	capHeartbeat
+)
+
+// capabilityNames lists capabilities in the order auth_success reports them
+var capabilityNames = []struct {
+	name string
+	cap  capabilities
+}{
+	{protocol.CapabilityCompression, capCompression},
+	{protocol.CapabilityMsgpack, capMsgpack},
+	{protocol.CapabilityResume, capResume},
+	{protocol.CapabilityBatching, capBatching},
+	{protocol.CapabilityChecksum, capChecksum},
+	{protocol.CapabilityServerSeq, capServerSeq},
+	{protocol.CapabilityAwarenessDiff, capAwarenessDiff},
+	{protocol.CapabilityHeartbeat, capHeartbeat},
+}
+
+// legacyCapabilities are what clients had before negotiation, and keep
+// when they do not ask for a protocol version
+const legacyCapabilities = capResume | capBatching
+
+// names returns the names of the capabilities in caps
+func (caps capabilities) names() []string {
+	names := make([]string, 0, len(capabilityNames))
+	for _, c := range capabilityNames {
+		if caps&c.cap != 0 {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// negotiate reads the protocol version and capabilities an auth message asks
+// for. Without protocolVersion the client speaks version 1 and keeps
+// legacyCapabilities; from version 2 it gets only the capabilities it lists
+// that this server supports. The older compression and encoding fields are
+// honoured at every version. ok is false for versions this server does not
+// speak.
+func (h *Hub) negotiate(payload *protocol.AuthPayload) (version int, caps capabilities, ok bool) {
+	version = protocol.MinProtocolVersion
+	if payload.ProtocolVersion != nil {
+		f, isNumber := payload.ProtocolVersion.(float64)
+		if !isNumber || f != float64(int(f)) || int(f) < protocol.MinProtocolVersion || int(f) > protocol.ProtocolVersion {
+			return 0, 0, false
+		}
+		version = int(f)
+	}
+
+	requested := capabilities(0)
+	if version == 1 {
+		requested = legacyCapabilities
+	} else {
+		for _, item := range payload.Capabilities {
+			name, _ := item.(string)
+			for _, c := range capabilityNames {
+				if c.name == name {
+					requested |= c.cap
+				}
+			}
+		}
+	}
+	if payload.Compression == "deflate" {
+		requested |= capCompression
+	}
+	if payload.Encoding == string(protocol.EncodingMsgpack) {
+		requested |= capMsgpack
+	}
+
+	// Compression needs a threshold to compress above
+	if h.opts.CompressionThreshold <= 0 {
+		requested &^= capCompression
+	}
+	return version, requested, true
+}
+
+// withoutSeq returns payload without its sequence number, for clients that
+// did not negotiate resume. payload may be shared with other subscribers,
+// so it is copied rather than changed.
+func withoutSeq(payload map[string]interface{}) map[string]interface{} {
+	if _, ok := payload["seq"]; !ok {
+		return payload
+	}
+	stripped := make(map[string]interface{}, len(payload))
+	for k, v := range payload {
+		if k != "seq" {
+			stripped[k] = v
+		}
+	}
+	return stripped
+}
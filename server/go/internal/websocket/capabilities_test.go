@@ -0,0 +1,206 @@
+package websocket
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+func TestHub_NegotiatesProtocolVersionAndCapabilities(t *testing.T) {
+	all := []interface{}{"compression", "msgpack", "resume", "batching", "checksum", "serverSeq", "teleport"}
+	tests := []struct {
+		name      string
+		threshold int
+		payload   map[string]interface{}
+		version   int
+		want      []string
+	}{
+		{"legacy client", 1024, map[string]interface{}{}, 1, []string{"resume", "batching"}},
+		{"legacy opt-ins", 1024, map[string]interface{}{"compression": "deflate", "encoding": "msgpack"}, 1, []string{"compression", "msgpack", "resume", "batching"}},
+		{"version 1", 1024, map[string]interface{}{"protocolVersion": 1.0, "capabilities": []interface{}{}}, 1, []string{"resume", "batching"}},
+		{"everything", 1024, map[string]interface{}{"protocolVersion": 2.0, "capabilities": all}, 2, []string{"compression", "msgpack", "resume", "batching", "checksum", "serverSeq"}},
+		{"no compression threshold", 0, map[string]interface{}{"protocolVersion": 2.0, "capabilities": all}, 2, []string{"msgpack", "resume", "batching", "checksum", "serverSeq"}},
+		{"nothing", 1024, map[string]interface{}{"protocolVersion": 2.0}, 2, []string{}},
+		{"some", 1024, map[string]interface{}{"protocolVersion": 2.0, "capabilities": []interface{}{"resume", 7.0}}, 2, []string{"resume"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewHubWithOptions(testAuth, HubOptions{CompressionThreshold: tt.threshold})
+			conn := newTestConnection(hub, "conn")
+			hub.register(conn)
+			tt.payload["userId"] = "alice"
+			handleDirect(hub, conn, protocol.TypeAuth, tt.payload)
+
+			msg := expectMessage(t, conn, protocol.TypeAuthSuccess)
+			if msg.Payload["protocolVersion"] != float64(tt.version) || conn.ProtocolVersion() != tt.version {
+				t.Errorf("protocolVersion = %v (connection %d), want %d", msg.Payload["protocolVersion"], conn.ProtocolVersion(), tt.version)
+			}
+			got := make([]string, 0)
+			for _, name := range msg.Payload["capabilities"].([]interface{}) {
+				got = append(got, name.(string))
+			}
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(capabilities(conn.capabilities.Load()).names(), tt.want) {
+				t.Errorf("capabilities = %v (connection %v), want %v", got, capabilities(conn.capabilities.Load()).names(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHub_GatesBehaviorOnCapabilities(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer", "room:caps")
+	sendDelta(hub, writer, "room:caps", "title", "Hello")
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["seq"] != 1.0 {
+		t.Errorf("legacy ack = %v, want seq 1", ack.Payload)
+	}
+
+	join := func(caps ...interface{}) *Connection {
+		conn := newTestConnection(hub, "negotiated")
+		hub.register(conn)
+		handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "bob", "protocolVersion": 2.0, "capabilities": caps})
+		expectMessage(t, conn, protocol.TypeAuthSuccess)
+		return conn
+	}
+
+	// Without resume, resumeFrom is ignored and no sequence numbers are sent
+	plain := join()
+	handleDirect(hub, plain, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:caps", "resumeFrom": map[string]interface{}{"room:caps": 1.0}})
+	msg := expectMessage(t, plain, protocol.TypeSyncResponse)
+	if _, hasSeq := msg.Payload["seq"]; hasSeq || msg.Payload["resumed"] != nil || msg.Payload["state"] == nil {
+		t.Errorf("sync_response without resume = %v, want full state and no seq", msg.Payload)
+	}
+	handleDirect(hub, plain, protocol.TypeDeltaBatch, map[string]interface{}{"docId": "room:caps", "deltas": []interface{}{}})
+	expectError(t, plain, "CAPABILITY_NOT_NEGOTIATED")
+
+	sendDelta(hub, writer, "room:caps", "title", "Hi")
+	if delta := expectMessage(t, plain, protocol.TypeDelta); delta.Payload["seq"] != nil {
+		t.Errorf("delta without resume = %v, want no seq", delta.Payload)
+	}
+
+	// With them, both work
+	full := join("resume", "batching")
+	handleDirect(hub, full, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:caps", "resumeFrom": map[string]interface{}{"room:caps": 1.0}})
+	if msg := expectMessage(t, full, protocol.TypeSyncResponse); msg.Payload["resumed"] != true || msg.Payload["seq"] != 2.0 {
+		t.Errorf("sync_response with resume = %v, want resumed at seq 2", msg.Payload)
+	}
+	handleDirect(hub, full, protocol.TypeDeltaBatch, map[string]interface{}{"docId": "room:caps", "deltas": []interface{}{
+		map[string]interface{}{"changes": map[string]interface{}{"title": "Batched"}},
+	}})
+	if ack := expectMessage(t, full, protocol.TypeAck); ack.Payload["seq"] != 3.0 {
+		t.Errorf("batch ack = %v, want seq 3", ack.Payload)
+	}
+}
+
+func TestHub_ServerSeqRevealsDroppedFrames(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer", "room:seq")
+	reader := newTestConnection(hub, "reader")
+	hub.register(reader)
+	handleDirect(hub, reader, protocol.TypeAuth, map[string]interface{}{"userId": "bob", "protocolVersion": 2.0, "capabilities": []interface{}{"serverSeq"}})
+	// Numbering starts after auth_success, like every negotiated option
+	if msg := expectMessage(t, reader, protocol.TypeAuthSuccess); msg.ServerSeq != 0 {
+		t.Errorf("auth_success serverSeq = %d, want none", msg.ServerSeq)
+	}
+	handleDirect(hub, reader, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:seq"})
+	if msg := expectMessage(t, reader, protocol.TypeSyncResponse); msg.ServerSeq != 1 {
+		t.Errorf("sync_response serverSeq = %d, want 1", msg.ServerSeq)
+	}
+
+	// Fill the send queue, then drop two frames
+	queued := 0
+	for dropped := 0; dropped < 2; {
+		if err := reader.SendMessage(protocol.TypePong, map[string]interface{}{}); err == ErrSendQueueFull {
+			dropped++
+		} else if err != nil {
+			t.Fatal(err)
+		} else {
+			queued++
+		}
+	}
+	for i := 0; i < queued; i++ {
+		msg, err := protocol.DecodeMessage(<-reader.send)
+		if err != nil || msg.ServerSeq != uint64(2+i) {
+			t.Fatalf("queued frame %d = %+v, %v; want serverSeq %d", i, msg, err, 2+i)
+		}
+	}
+
+	// The next frame delivered skips the dropped ones
+	sendDelta(hub, writer, "room:seq", "title", "After the drop")
+	msg := expectMessage(t, reader, protocol.TypeDelta)
+	if want := uint64(2 + queued + 2); msg.ServerSeq != want {
+		t.Errorf("delta after drops serverSeq = %d, want %d", msg.ServerSeq, want)
+	}
+	if sent, _ := reader.ServerSeqs(); sent != msg.ServerSeq {
+		t.Errorf("ServerSeqs() sent = %d, want %d", sent, msg.ServerSeq)
+	}
+
+	// Clients that did not negotiate it get unstamped frames
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.ServerSeq != 0 {
+		t.Errorf("legacy ack serverSeq = %d, want none", ack.ServerSeq)
+	}
+	if sent, _ := writer.ServerSeqs(); sent != 0 {
+		t.Errorf("legacy ServerSeqs() sent = %d, want 0", sent)
+	}
+}
+
+func TestHub_RejectsUnsupportedProtocolVersions(t *testing.T) {
+	hub := NewHub(testAuth)
+	for _, version := range []interface{}{float64(protocol.ProtocolVersion + 1), 0.0, 1.5, "2"} {
+		conn := newTestConnection(hub, "conn")
+		hub.register(conn)
+		handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "alice", "protocolVersion": version})
+
+		msg := expectMessage(t, conn, protocol.TypeAuthError)
+		if msg.Payload["code"] != "UNSUPPORTED_PROTOCOL" || msg.Payload["maxVersion"] != float64(protocol.ProtocolVersion) {
+			t.Errorf("protocolVersion %v: auth_error = %v, want UNSUPPORTED_PROTOCOL", version, msg.Payload)
+		}
+		if conn.Authenticated {
+			t.Errorf("protocolVersion %v: connection authenticated", version)
+		}
+	}
+}
+
+func TestHub_SendsBatchesToClientsThatNegotiatedThem(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer", "room:batch")
+	legacy := joinDirect(t, hub, "legacy", "room:batch")
+
+	batching := newTestConnection(hub, "batching")
+	hub.register(batching)
+	handleDirect(hub, batching, protocol.TypeAuth, map[string]interface{}{"userId": "bob", "protocolVersion": 2.0, "capabilities": []interface{}{"batching", "resume"}})
+	expectMessage(t, batching, protocol.TypeAuthSuccess)
+	handleDirect(hub, batching, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:batch"})
+	expectMessage(t, batching, protocol.TypeSyncResponse)
+
+	deltas := []interface{}{}
+	for _, title := range []string{"a", "b", "c"} {
+		deltas = append(deltas, map[string]interface{}{"changes": map[string]interface{}{"title": title}})
+	}
+	handleDirect(hub, writer, protocol.TypeDeltaBatch, map[string]interface{}{"docId": "room:batch", "deltas": deltas})
+	expectMessage(t, writer, protocol.TypeAck)
+
+	// One frame for the client that takes batches
+	data := <-batching.send
+	items, err := protocol.Decoder{}.DecodeBatch(data)
+	if err != nil {
+		t.Fatalf("DecodeBatch() error = %v", err)
+	}
+	for i, item := range items {
+		changes, _ := item.Message.Payload["changes"].(map[string]interface{})
+		if item.Message.Type != protocol.TypeDelta || changes["title"] != []string{"a", "b", "c"}[i] || item.Message.Payload["seq"] != float64(i+1) {
+			t.Errorf("batched message %d = %s %v", i, item.Message.Type, item.Message.Payload)
+		}
+	}
+	if len(items) != 3 {
+		t.Errorf("batch of %d messages, want 3", len(items))
+	}
+
+	// One frame per delta for the rest
+	for _, title := range []string{"a", "b", "c"} {
+		msg := expectMessage(t, legacy, protocol.TypeDelta)
+		if changes, _ := msg.Payload["changes"].(map[string]interface{}); changes["title"] != title {
+			t.Errorf("legacy delta = %v, want title %q", msg.Payload, title)
+		}
+	}
+}
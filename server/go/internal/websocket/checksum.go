@@ -0,0 +1,188 @@
+package websocket
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"time"
+)
+
+// DefaultChecksumInterval is how often relayed documents' checksums are
+// published
+const DefaultChecksumInterval = 30 * time.Second
+
+// DivergenceChecksum is the sync_required reason sent after a document was
+// reloaded because its copy here differed from another server's
+const DivergenceChecksum = "checksum"
+
+// Causes counted by synckit_relay_divergences_total
+const (
+	divergenceMissed   = "missed"   // A server's checksum covered deltas that never arrived
+	divergenceMismatch = "mismatch" // Both copies had applied the same deltas but differed
+)
+
+// relayVersions holds, for one document, the sequence number of the last
+// delta applied from each server, this hub's own included. A delta's
+// sequence number is the one its origin assigned.
+type relayVersions struct {
+	servers   map[string]int64
+	published int64 // This hub's last local delta handed to the relay
+}
+
+// noteLocalVersion records a delta applied on this server, before it is
+// relayed. Must be called with docsMu held.
+func (h *Hub) noteLocalVersion(docID string, seq int64) {
+	if h.opts.Relay == nil {
+		return
+	}
+	h.noteVersion(docID, h.opts.ServerID, seq)
+}
+
+// noteVersion records the sequence number of a server's delta applied here.
+// Must be called with docsMu held.
+func (h *Hub) noteVersion(docID, serverID string, seq int64) {
+	v := h.versions[docID]
+	if v.servers == nil {
+		v.servers = make(map[string]int64)
+	}
+	v.servers[serverID] = seq
+	h.versions[docID] = v
+}
+
+// notePublished records that this server's deltas up to seq have been
+// handed to the relay, whether or not publishing succeeded; other servers
+// notice the ones they missed from the next checksum
+func (h *Hub) notePublished(docID string, seq int64) {
+	h.docsMu.Lock()
+	defer h.docsMu.Unlock()
+	v := h.versions[docID]
+	v.published = seq
+	h.versions[docID] = v
+}
+
+// forgetVersions drops what is known about a document's relayed deltas
+// once the hub stops listening to its channel
+func (h *Hub) forgetVersions(docID string) {
+	h.docsMu.Lock()
+	defer h.docsMu.Unlock()
+	delete(h.versions, docID)
+}
+
+// runChecksums periodically publishes checksums of relayed documents
+func (h *Hub) runChecksums() {
+	ticker := time.NewTicker(h.opts.ChecksumInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopChan:
+			return
+		case <-ticker.C:
+			h.publishChecksums()
+		}
+	}
+}
+
+// publishChecksums publishes, for each document this server relays and
+// holds, a checksum of its state and the deltas it has applied from every
+// server. A document with local deltas still being relayed is skipped, as
+// others could not have them yet.
+func (h *Hub) publishChecksums() {
+	h.relaySubs.mu.Lock()
+	docIDs := make([]string, 0, len(h.relaySubs.docs))
+	for docID := range h.relaySubs.docs {
+		docIDs = append(docIDs, docID)
+	}
+	h.relaySubs.mu.Unlock()
+
+	for _, docID := range docIDs {
+		h.docsMu.RLock()
+		doc, held := h.documents[docID]
+		v := h.versions[docID]
+		own := v.servers[h.opts.ServerID]
+		msg := relayMessage{
+			Kind:     relayChecksum,
+			DocID:    docID,
+			Seq:      own,
+			Checksum: documentChecksum(doc),
+			Versions: make(map[string]int64, len(v.servers)),
+		}
+		for serverID, seq := range v.servers {
+			msg.Versions[serverID] = seq
+		}
+		h.docsMu.RUnlock()
+
+		if held && own == v.published {
+			h.publishRelayed(msg)
+		}
+	}
+}
+
+// receiveChecksum compares another server's checksum of a document with
+// this server's copy. The copy has diverged if the checksum covers deltas
+// from its sender that never arrived here, or if both servers have applied
+// the same deltas and the checksums differ; a diverged copy is reloaded
+// from storage. Checksums sent while other deltas were still in flight are
+// not compared.
+func (h *Hub) receiveChecksum(msg relayMessage) {
+	h.docsMu.Lock()
+	doc, held := h.documents[msg.DocID]
+	if !held {
+		h.docsMu.Unlock()
+		return
+	}
+	v := h.versions[msg.DocID]
+	if v.servers == nil {
+		v.servers = make(map[string]int64)
+	}
+	last, known := v.servers[msg.ServerID]
+	missed := known && last < msg.Seq
+	comparable := !known || last == msg.Seq
+	for serverID, seq := range v.servers {
+		if serverID != msg.ServerID && msg.Versions[serverID] != seq {
+			comparable = false
+		}
+	}
+	// Servers this one has heard nothing from are taken as the sender sees
+	// them; their deltas reached this copy through storage, if at all
+	for serverID, seq := range msg.Versions {
+		if _, ok := v.servers[serverID]; !ok && serverID != h.opts.ServerID {
+			v.servers[serverID] = seq
+		}
+	}
+	v.servers[msg.ServerID] = msg.Seq
+	h.versions[msg.DocID] = v
+	mismatch := !missed && comparable && documentChecksum(doc) != msg.Checksum
+	h.docsMu.Unlock()
+
+	cause := divergenceMissed
+	switch {
+	case mismatch:
+		cause = divergenceMismatch
+	case !missed:
+		return
+	}
+	h.metrics.relayDivergences.With(cause).Inc()
+	h.opts.Logger.Warn("Document diverged from another server", "doc_id", msg.DocID, "server_id", msg.ServerID, "cause", cause)
+	if h.opts.Load == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(h.handlingCtx, h.opts.MessageTimeout)
+	defer cancel()
+	if err := h.reloadDocuments(ctx, []string{msg.DocID}, DivergenceChecksum); err != nil {
+		h.opts.Logger.Warn("Reloading diverged document failed", "doc_id", msg.DocID, "err", err)
+	}
+}
+
+// documentChecksum hashes a document's state. encoding/json sorts map keys,
+// so equal states hash the same on every server.
+func documentChecksum(doc map[string]interface{}) string {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return ""
+	}
+	hash := fnv.New64a()
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -1,75 +1,302 @@
 package websocket
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
 	"github.com/Dancode-188/synckit/server/go/internal/auth"
 	"github.com/Dancode-188/synckit/server/go/internal/protocol"
 	"github.com/Dancode-188/synckit/server/go/internal/security"
 	"github.com/gorilla/websocket"
 )
 
+// Subscription modes requested in the subscribe payload
+const (
+	ModeWrite = "write"
+	ModeRead  = "read"
+)
+
 // Connection represents a single WebSocket connection
 type Connection struct {
-	ID            string
-	UserID        string
-	ClientID      string
-	ClientIP      string
-	Authenticated bool
-	TokenPayload  *auth.TokenPayload // Verified token payload for RBAC
-	Subscriptions map[string]bool    // docId -> subscribed
+	ID                     string
+	UserID                 string
+	ClientID               string
+	ClientIP               string
+	Authenticated          bool
+	TokenPayload           *auth.TokenPayload // Verified token payload for RBAC
+	Subscriptions          map[string]bool    // docId -> subscribed
+	ReadOnly               map[string]bool    // docId -> subscribed in read mode
+	PrefixSubscriptions    map[string]bool    // docId prefix -> subscribed
+	ListSubscriptions      map[string]bool    // docId prefix -> watching the document list
 	AwarenessSubscriptions map[string]bool
-	ConnectedAt   time.Time
-	SecurityManager *security.SecurityManager
+	ConnectedAt            time.Time
+	SecurityManager        *security.SecurityManager
+
+	ws           Transport
+	send         chan []byte
+	done         chan struct{} // Closed when the connection is shut down
+	closed       bool          // Guarded by mu; set once done is closed
+	closeFrame   []byte        // Guarded by mu; payload of the close frame WritePump sends
+	serverSeq    uint64        // Guarded by mu; sequence number of the last frame queued or dropped
+	deliveredSeq atomic.Uint64 // Sequence number of the last frame written to the socket
+	hub          *Hub
+	mu           sync.Mutex
 
-	ws   *websocket.Conn
-	send chan []byte
-	hub  *Hub
-	mu   sync.Mutex
+	lastMessageAt   atomic.Int64  // Unix nanoseconds of the last message read
+	verifiedUser    atomic.Value  // string; user ID once a token authenticates, keys rate limits
+	tenant          atomic.Value  // string; tenant of the token, which scopes quotas and metrics
+	handlingID      atomic.Value  // string; ID of the message the hub is handling, which replyError answers
+	anonymousRead   atomic.Bool   // Authenticated without a token as a read-only reader
+	compressAbove   atomic.Int64  // Payload size above which messages are compressed (0 never)
+	capabilities    atomic.Uint32 // Protocol capabilities negotiated at auth
+	protocolVersion atomic.Int32  // Protocol version negotiated at auth
+	rtt             rttTracker    // Smoothed websocket ping round-trip time
+	authDeadline    authDeadline  // Closes the connection if it does not authenticate in time
+	tokenExpiry     tokenExpiry   // Warns before and closes after the token expires
+	log             connLogger
+
+	handleMu sync.Mutex     // Held while the hub handles one of this connection's messages
+	inflight sync.WaitGroup // Messages handed to workers; touched only by Run and workers
+
+	// Touched only by Run: set while a message is prepared off the Run
+	// goroutine, with the messages held until it is handled
+	preparing bool
+	held      []*MessageEvent
+
+	awarenessThrottle awarenessThrottle // Coalesces awareness bursts
+	awarenessBases    awarenessBases    // Documents whose awareness states it holds, so it can be sent diffs
+	divergence        divergenceTracker // Rejected and dropped deltas per document
 }
 
-// NewConnection creates a new connection
-func NewConnection(id string, ws *websocket.Conn, hub *Hub) *Connection {
-	return &Connection{
-		ID:            id,
-		Subscriptions: make(map[string]bool),
+// NewConnection creates a new connection over ws, which is nil for
+// connections without a socket
+func NewConnection(id string, ws Transport, hub *Hub) *Connection {
+	c := &Connection{
+		ID:                     id,
+		Subscriptions:          make(map[string]bool),
+		ReadOnly:               make(map[string]bool),
+		PrefixSubscriptions:    make(map[string]bool),
+		ListSubscriptions:      make(map[string]bool),
 		AwarenessSubscriptions: make(map[string]bool),
-		ConnectedAt:   time.Time{},
-		ws:            ws,
-		send:          make(chan []byte, 256),
-		hub:           hub,
+		ConnectedAt:            time.Now(),
+		ws:                     ws,
+		send:                   make(chan []byte, 256),
+		done:                   make(chan struct{}),
+		hub:                    hub,
+	}
+	logger := slog.Default()
+	if hub != nil {
+		logger = hub.opts.Logger
 	}
+	c.SetLogger(logger)
+	c.capabilities.Store(uint32(legacyCapabilities))
+	c.protocolVersion.Store(protocol.MinProtocolVersion)
+	return c
 }
 
 // SendMessage sends a message to the client
 func (c *Connection) SendMessage(messageType string, payload map[string]interface{}) error {
 	timestamp := time.Now().UnixMilli()
-	data, err := protocol.EncodeMessage(messageType, payload, timestamp)
+	if !c.can(capResume) {
+		payload = withoutSeq(payload)
+	}
+	data, err := protocol.EncodeMessageWith(messageType, payload, timestamp, c.encodeOptions())
+	if err != nil {
+		return err
+	}
+	return c.enqueue(data, messageType)
+}
+
+// Send sends a message built with protocol.NewMessage to the client
+func (c *Connection) Send(msg *protocol.Message) error {
+	return c.SendMessage(msg.Type, msg.Payload)
+}
+
+// SendBatch sends messages in order, in one frame to clients that
+// negotiated batching at protocol version 2 or later and one by one to the
+// rest. It returns the first error.
+func (c *Connection) SendBatch(messages []protocol.Message) error {
+	if len(messages) < 2 || !c.takesBatches() {
+		var first error
+		for _, msg := range messages {
+			if err := c.SendMessage(msg.Type, msg.Payload); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+
+	timestamp := time.Now().UnixMilli()
+	types := make([]string, len(messages))
+	batch := make([]protocol.Message, len(messages))
+	for i, msg := range messages {
+		types[i] = msg.Type
+		batch[i] = protocol.Message{Type: msg.Type, Timestamp: timestamp, Payload: msg.Payload}
+		if !c.can(capResume) {
+			batch[i].Payload = withoutSeq(msg.Payload)
+		}
+	}
+	data, err := protocol.EncodeBatchWith(batch, timestamp, c.encodeOptions())
 	if err != nil {
 		return err
 	}
+	return c.enqueue(data, types...)
+}
 
+// takesBatches reports whether the client can be sent batch envelopes:
+// batching before protocol version 2 only meant delta_batch
+func (c *Connection) takesBatches() bool {
+	return c.ProtocolVersion() >= 2 && c.can(capBatching)
+}
+
+// enqueue queues an encoded frame carrying messages of messageTypes. The
+// frame may be queued to other clients too, so it is never modified. Clients
+// that negotiated serverSeq get a copy stamped with the next sequence
+// number, which a dropped frame uses up too, so the next one delivered
+// reveals the gap.
+func (c *Connection) enqueue(data []byte, messageTypes ...string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Checked under the same lock as Close so a send never races the shutdown
+	if c.closed {
+		return ErrConnectionClosed
+	}
+
+	if c.can(capServerSeq) {
+		stamped, err := protocol.StampServerSeq(data, c.serverSeq+1)
+		if err != nil {
+			return err
+		}
+		c.serverSeq++
+		data = stamped
+	}
+
 	select {
 	case c.send <- data:
+		if c.hub != nil {
+			for _, messageType := range messageTypes {
+				c.hub.metrics.messagesOut.With(messageType).Inc()
+			}
+		}
 		return nil
 	default:
+		if c.hub != nil {
+			c.hub.metrics.sendsDropped.Add(1)
+		}
 		return ErrSendQueueFull
 	}
 }
 
+// encodeOptions are the payload options the client negotiated at auth
+func (c *Connection) encodeOptions() protocol.EncodeOptions {
+	opts := protocol.EncodeOptions{CompressAbove: int(c.compressAbove.Load())}
+	if c.can(capMsgpack) {
+		opts.Encoding = protocol.EncodingMsgpack
+	}
+	opts.Checksum = c.can(capChecksum)
+	return opts
+}
+
+// can reports whether the client negotiated a capability
+func (c *Connection) can(capability capabilities) bool {
+	return capabilities(c.capabilities.Load())&capability != 0
+}
+
+// ProtocolVersion returns the protocol version negotiated at auth
+func (c *Connection) ProtocolVersion() int {
+	return int(c.protocolVersion.Load())
+}
+
+// Close marks the connection as closed and signals WritePump to exit.
+// The send channel is never closed, so concurrent SendMessage calls are safe.
+// Close is idempotent.
+func (c *Connection) Close() {
+	c.closeWith(nil)
+}
+
+// CloseWithCode is Close with a status code and reason in the close frame.
+// Only the first close of a connection takes effect.
+func (c *Connection) CloseWithCode(code int, text string) {
+	c.closeWith(websocket.FormatCloseMessage(code, text))
+}
+
+func (c *Connection) closeWith(frame []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeFrame = frame
+	close(c.done)
+}
+
+// IsClosed reports whether Close has been called
+func (c *Connection) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// LastMessageAt returns when the client last sent a message, or the zero
+// time if it has not sent any
+func (c *Connection) LastMessageAt() time.Time {
+	ns := c.lastMessageAt.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// VerifiedUserID returns the user ID proven by the connection's token, or ""
+// before token authentication or for anonymous connections. Safe to call from
+// any goroutine.
+func (c *Connection) VerifiedUserID() string {
+	userID, _ := c.verifiedUser.Load().(string)
+	return userID
+}
+
+// Tenant returns the tenant the connection's token is confined to, or ""
+// if it has none. Safe to call from any goroutine.
+func (c *Connection) Tenant() string {
+	tenant, _ := c.tenant.Load().(string)
+	return tenant
+}
+
+// RTT returns the smoothed websocket ping round-trip time, and false until
+// the first pong has arrived
+func (c *Connection) RTT() (time.Duration, bool) {
+	return c.rtt.rtt()
+}
+
 // SendError sends an error message
 func (c *Connection) SendError(errorMsg, errorCode string) error {
-	return c.SendMessage(protocol.TypeError, map[string]interface{}{
-		"type":      protocol.TypeError,
-		"id":        generateID(),
-		"timestamp": time.Now().UnixMilli(),
-		"error":     errorMsg,
-		"code":      errorCode,
-	})
+	return c.Send(protocol.NewMessage(protocol.TypeError, map[string]interface{}{
+		"error": errorMsg,
+		"code":  errorCode,
+	}))
+}
+
+// replyError sends an error answering the message the hub is handling for
+// the client, carrying its ID as origin
+func (c *Connection) replyError(errorMsg, errorCode string) error {
+	return c.Send(protocol.NewMessage(protocol.TypeError, map[string]interface{}{
+		"error": errorMsg,
+		"code":  errorCode,
+	}).WithOrigin(c.handling()))
+}
+
+// handling returns the ID of the message the hub is handling for the client
+func (c *Connection) handling() string {
+	id, _ := c.handlingID.Load().(string)
+	return id
 }
 
 // ReadPump pumps messages from the WebSocket connection to the hub
@@ -77,15 +304,20 @@ func (c *Connection) ReadPump() {
 	defer func() {
 		// Clean up rate limiter on disconnect
 		if c.SecurityManager != nil {
-			c.SecurityManager.ConnectionRateLimiter.RemoveConnection(c.ID)
 			c.SecurityManager.ConnectionLimiter.RemoveConnection(c.ClientIP)
 		}
-		c.hub.Unregister <- c
+		c.leaveHub()
 		c.ws.Close()
 	}()
 
+	// Frames beyond the largest message allowed are refused as they arrive,
+	// closing the connection, instead of read whole and then rejected
+	if c.SecurityManager != nil && c.SecurityManager.Limits.MaxMessageSize > 0 {
+		c.ws.SetReadLimit(int64(c.SecurityManager.Limits.MaxMessageSize + protocol.MaxHeaderSize))
+	}
 	c.ws.SetReadDeadline(time.Now().Add(pongWait))
 	c.ws.SetPongHandler(func(string) error {
+		c.rtt.pongReceived(time.Now())
 		c.ws.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
@@ -94,38 +326,133 @@ func (c *Connection) ReadPump() {
 		_, message, err := c.ws.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				// Log error
+				c.Logger().Warn("Unexpected websocket close", "err", err)
 			}
 			break
 		}
+		c.lastMessageAt.Store(time.Now().UnixNano())
 
-		// Per-connection rate limiting
-		if c.SecurityManager != nil {
-			if !c.SecurityManager.ConnectionRateLimiter.CanSendMessage(c.ID) {
-				c.SendError("Too many messages. Please slow down.", "RATE_LIMIT_EXCEEDED")
+		// A batch is handled as the messages it carries, in order
+		if protocol.IsBatch(message) {
+			items, err := c.decoder().DecodeBatch(message)
+			if err != nil {
+				c.rejectMessage(err)
 				continue
 			}
-			c.SecurityManager.ConnectionRateLimiter.RecordMessage(c.ID)
+			for _, item := range items {
+				if !c.allowMessage() {
+					continue
+				}
+				if item.Err != nil {
+					c.rejectMessage(item.Err)
+					continue
+				}
+				if !c.dispatch(item.Message) {
+					return
+				}
+			}
+			continue
 		}
 
-		// Decode message
-		msg, err := protocol.DecodeMessage(message)
+		if !c.allowMessage() {
+			continue
+		}
+		msg, err := c.decoder().Decode(message)
 		if err != nil {
-			c.SendError("Invalid message: "+err.Error(), "INVALID_MESSAGE")
+			c.rejectMessage(err)
 			continue
 		}
-
-		// Handle message
-		c.hub.HandleMessage <- &MessageEvent{
-			Connection: c,
-			Message:    msg,
+		if !c.dispatch(msg) {
+			return
 		}
 	}
 }
 
+// allowMessage charges a message to the rate limits, per IP until a token
+// proves who the user is, then per user so a user's connections share one
+// budget wherever they come from. Anonymous readers share a stricter budget
+// per IP.
+func (c *Connection) allowMessage() bool {
+	if c.SecurityManager == nil {
+		return true
+	}
+	allowed := false
+	if c.anonymousRead.Load() {
+		allowed = c.SecurityManager.AllowAnonymousMessage(c.ClientIP)
+	} else {
+		allowed = c.SecurityManager.AllowMessage(c.ClientIP, c.quotaUser())
+	}
+	if allowed {
+		return true
+	}
+	c.Logger().Warn("Message rate limit exceeded")
+	c.hub.audit(c, audit.EventRateLimited, "", map[string]interface{}{"limit": "messages"})
+	// A ban closes this connection along with the IP's others
+	if c.SecurityManager.Bans.RecordViolation(c.ClientIP) {
+		c.hub.audit(c, audit.EventBan, "", map[string]interface{}{"automatic": true})
+		return false
+	}
+	c.SendError("Too many messages. Please slow down.", "RATE_LIMIT_EXCEEDED")
+	return false
+}
+
+// rejectMessage tells the client why a message it sent did not decode
+func (c *Connection) rejectMessage(err error) {
+	if errors.Is(err, protocol.ErrPayloadTooLarge) {
+		c.SendError("Message too large: "+err.Error(), "MESSAGE_TOO_LARGE")
+		return
+	}
+	// A corrupted frame is never applied; the client may resend it
+	if errors.Is(err, protocol.ErrChecksumMismatch) {
+		c.hub.metrics.checksumFailures.Add(1)
+		c.Logger().Warn("Message failed its checksum", "err", err)
+		c.SendError("Message failed its checksum: "+err.Error(), "CHECKSUM_MISMATCH")
+		return
+	}
+	c.SendError("Invalid message: "+err.Error(), "INVALID_MESSAGE")
+}
+
+// dispatch hands a decoded message to the hub. It returns false once the
+// hub has stopped.
+func (c *Connection) dispatch(msg *protocol.Message) bool {
+	// Unknown and server-only types would be dropped silently by the hub
+	if valid, errMsg := security.ValidateMessageType(msg.Type); !valid {
+		c.SendError(errMsg, "INVALID_MESSAGE_TYPE")
+		return true
+	}
+
+	select {
+	case c.hub.HandleMessage <- c.hub.newEvent(context.Background(), c, msg):
+		return true
+	case <-c.hub.Done():
+		return false
+	}
+}
+
+// decoder decodes the client's messages within the configured message size
+func (c *Connection) decoder() protocol.Decoder {
+	d := protocol.Decoder{Strict: c.hub.opts.StrictBatches}
+	if c.SecurityManager != nil {
+		d.MaxPayloadSize = c.SecurityManager.Limits.MaxMessageSize
+	}
+	return d
+}
+
+// leaveHub asks the hub to unregister the connection. The hub may already
+// have stopped, in which case nothing is draining Unregister.
+func (c *Connection) leaveHub() {
+	select {
+	case c.hub.Unregister <- c:
+	case <-c.hub.Done():
+	}
+}
+
 // WritePump pumps messages from the hub to the WebSocket connection
 func (c *Connection) WritePump() {
-	ticker := time.NewTicker(pingPeriod)
+	// One ticker sends pings and, when negotiated, heartbeats
+	tick := c.tickPeriod()
+	ticker := time.NewTicker(tick)
+	lastPing, lastHeartbeat := time.Now(), time.Now()
 	defer func() {
 		ticker.Stop()
 		c.ws.Close()
@@ -133,20 +460,33 @@ func (c *Connection) WritePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
+		case message := <-c.send:
 			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// Hub closed the channel
-				c.ws.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
 			if err := c.ws.WriteMessage(websocket.BinaryMessage, message); err != nil {
 				return
 			}
+			c.delivered(message)
+
+		case <-c.done:
+			// Flush anything queued before the hub closed us, then say goodbye
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			c.flushQueued()
+			c.mu.Lock()
+			frame := c.closeFrame
+			c.mu.Unlock()
+			c.ws.WriteMessage(websocket.CloseMessage, frame)
+			return
 
-		case <-ticker.C:
+		case now := <-ticker.C:
+			if c.heartbeatDue(now, lastHeartbeat, tick) && c.hub.sendHeartbeat(c, now) {
+				lastHeartbeat = now
+			}
+			if now.Sub(lastPing) < pingPeriod-tick/2 {
+				continue
+			}
+			lastPing = now
 			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			c.rtt.pingSent(time.Now())
 			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -154,13 +494,50 @@ func (c *Connection) WritePump() {
 	}
 }
 
+// flushQueued writes any messages still buffered in the send channel
+func (c *Connection) flushQueued() {
+	for {
+		select {
+		case message := <-c.send:
+			if err := c.ws.WriteMessage(websocket.BinaryMessage, message); err != nil {
+				return
+			}
+			c.delivered(message)
+		default:
+			return
+		}
+	}
+}
+
+// delivered records that a frame was written to the socket
+func (c *Connection) delivered(frame []byte) {
+	if seq, ok := protocol.ServerSeq(frame); ok {
+		c.deliveredSeq.Store(seq)
+	}
+}
+
+// ServerSeqs returns the sequence number of the last frame queued or
+// dropped for the client and of the last one written to its socket. Both
+// are 0 for clients that did not negotiate serverSeq.
+func (c *Connection) ServerSeqs() (sent, delivered uint64) {
+	c.mu.Lock()
+	sent = c.serverSeq
+	c.mu.Unlock()
+	return sent, c.deliveredSeq.Load()
+}
+
 const (
 	writeWait  = 10 * time.Second
 	pongWait   = 60 * time.Second
 	pingPeriod = (pongWait * 9) / 10
 )
 
-var ErrSendQueueFull = NewError("send queue is full")
+var (
+	ErrSendQueueFull    = NewError("send queue is full")
+	ErrConnectionClosed = NewError("connection is closed")
+
+	errHubStopped = NewError("hub is stopped")
+)
 
 func NewError(msg string) error {
 	return &ErrorType{Message: msg}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"context"
+	"time"
+)
+
+// Degraded mode defaults
+const (
+	DefaultHealthCheckInterval = 5 * time.Second
+	DefaultWriteBufferSize     = 10000
+)
+
+// HealthFunc reports whether storage is reachable
+type HealthFunc func(ctx context.Context) error
+
+// degrades reports whether the hub keeps serving from memory while storage
+// is down: it needs storage to write to and a way to tell it is back
+func (h *Hub) degrades() bool {
+	return h.writer != nil && h.opts.StorageHealth != nil
+}
+
+// Degraded reports whether storage is down and document writes are being
+// buffered in memory until it recovers
+func (h *Hub) Degraded() bool {
+	return h.writer != nil && h.writer.Paused() != nil
+}
+
+// writerOptions configures the writer for degraded mode when the hub has it
+func (h *Hub) writerOptions() WriterOptions {
+	if h.opts.StorageHealth == nil {
+		return WriterOptions{}
+	}
+	return WriterOptions{
+		BufferSize: h.opts.WriteBufferSize,
+		OnPause: func(err error) {
+			h.opts.Logger.Warn("Storage write failed; buffering writes until storage recovers", "err", err)
+		},
+		OnOverflow: func(docID string) {
+			h.metrics.bufferOverflows.Add(1)
+			h.opts.Logger.Error("Write buffer full; document will be saved in full when storage recovers",
+				"doc_id", docID, "buffer_size", h.opts.WriteBufferSize)
+		},
+	}
+}
+
+// runHealthChecks probes storage until the hub stops, entering degraded
+// mode when a probe fails and leaving it when one succeeds
+func (h *Hub) runHealthChecks() {
+	ticker := time.NewTicker(h.opts.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopChan:
+			return
+		case <-ticker.C:
+			h.checkStorage()
+		}
+	}
+}
+
+// checkStorage runs one storage probe
+func (h *Hub) checkStorage() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.writer.opts.Timeout)
+	err := h.opts.StorageHealth(ctx)
+	cancel()
+	if err != nil {
+		if h.writer.Pause(err) {
+			h.opts.Logger.Warn("Storage unavailable; buffering writes until it recovers", "err", err)
+		}
+		return
+	}
+	if h.writer.Paused() == nil {
+		return
+	}
+	buffered := h.writer.Pending()
+	dirty := h.writer.Resume()
+	h.opts.Logger.Info("Storage recovered; writing buffered documents", "buffered", buffered, "dirty", len(dirty))
+	h.resave(dirty)
+}
+
+// resave enqueues the full state of documents whose writes were refused
+// while the buffer was full. The snapshot is taken and enqueued under
+// docsMu, so it cannot overtake a newer state enqueued by a delta.
+func (h *Hub) resave(docIDs []string) {
+	for _, docID := range docIDs {
+		h.docsMu.RLock()
+		if _, held := h.documents[docID]; held {
+			h.enqueueWrite(docID, h.documentSnapshot(docID), func(err error) {
+				if err != nil {
+					h.metrics.persistFailures.Add(1)
+					h.opts.Logger.Error("Document write failed", "doc_id", docID, "err", err)
+				}
+			})
+		}
+		h.docsMu.RUnlock()
+	}
+}
@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/pkg/storagefake"
+)
+
+var errStorageDown = errors.New("connection refused")
+
+// startDegradableHub runs a hub persisting to store, with degraded mode
+// probing it every interval
+func startDegradableHub(t *testing.T, store *storagefake.Fake, interval time.Duration, bufferSize int) *Hub {
+	t.Helper()
+	if err := store.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return startHub(t, HubOptions{
+		Persist: func(ctx context.Context, docID string, state map[string]interface{}) error {
+			_, err := store.SaveDocument(ctx, docID, state)
+			return err
+		},
+		StorageHealth: func(ctx context.Context) error {
+			_, err := store.HealthCheck(ctx)
+			return err
+		},
+		HealthCheckInterval: interval,
+		WriteBufferSize:     bufferSize,
+	})
+}
+
+// waitFor polls cond until it holds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// storedField reads a field of a document from the fake's memory
+func storedField(t *testing.T, store *storagefake.Fake, docID, field string) interface{} {
+	t.Helper()
+	doc, err := store.Memory().GetDocument(context.Background(), docID)
+	if err != nil || doc == nil {
+		return nil
+	}
+	return doc.State[field]
+}
+
+func TestHub_BuffersWritesWhileStorageIsDown(t *testing.T) {
+	store := storagefake.New()
+	hub := startDegradableHub(t, store, 10*time.Millisecond, 5)
+	writer := connectAnonymous(t, hub, "writer")
+
+	store.Fail("HealthCheck", errStorageDown)
+	store.Fail("SaveDocument", errStorageDown)
+	waitFor(t, "degraded mode", hub.Degraded)
+
+	// Five documents fit in the buffer; the rest overflow it
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 8; i++ {
+			dispatch(hub, writer, protocol.TypeDelta, map[string]interface{}{
+				"docId":   fmt.Sprintf("room:%d", i),
+				"changes": map[string]interface{}{fmt.Sprintf("round%d", round): float64(i)},
+			})
+			if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["status"] == "rejected" {
+				t.Fatalf("delta rejected while degraded: %v", ack.Payload)
+			}
+		}
+	}
+	m := hub.Metrics()
+	if !m.StorageDegraded || m.BufferedWrites != 5 || m.BufferOverflows == 0 {
+		t.Errorf("metrics = degraded %v, buffered %d, overflows %d; want degraded with 5 buffered and some overflows",
+			m.StorageDegraded, m.BufferedWrites, m.BufferOverflows)
+	}
+
+	// Once storage answers again every document is written, overflowed
+	// ones in full, and no delta is lost
+	store.Reset()
+	waitFor(t, "storage to recover", func() bool { return !hub.Degraded() })
+	waitFor(t, "buffered writes", func() bool { return hub.Metrics().BufferedWrites == 0 })
+	for i := 0; i < 8; i++ {
+		docID := fmt.Sprintf("room:%d", i)
+		for round := 0; round < 3; round++ {
+			if got := storedField(t, store, docID, fmt.Sprintf("round%d", round)); got != float64(i) {
+				t.Errorf("%s round%d = %v, want %d", docID, round, got, i)
+			}
+		}
+	}
+}
+
+func TestHub_FailedWriteEntersDegradedMode(t *testing.T) {
+	store := storagefake.New()
+	// Probes never run on their own; the test triggers them
+	hub := startDegradableHub(t, store, time.Hour, 0)
+	writer := connectAnonymous(t, hub, "writer")
+
+	store.Fail("SaveDocument", errStorageDown)
+	sendDelta(hub, writer, "room:outage", "title", "Kept")
+	expectMessage(t, writer, protocol.TypeAck)
+	waitFor(t, "degraded mode", hub.Degraded)
+	if failures := hub.Metrics().PersistFailures; failures != 0 {
+		t.Errorf("PersistFailures = %d, want the held write not reported as failed", failures)
+	}
+
+	// A probe that fails keeps the hub degraded
+	store.Fail("HealthCheck", errStorageDown)
+	hub.checkStorage()
+	if !hub.Degraded() {
+		t.Fatal("hub left degraded mode while storage was down")
+	}
+
+	store.Reset()
+	hub.checkStorage()
+	if hub.Degraded() {
+		t.Fatal("hub still degraded after storage recovered")
+	}
+	waitFor(t, "the held write", func() bool { return storedField(t, store, "room:outage", "title") == "Kept" })
+}
+
+func TestHub_DurableAckWaitsForStorageToRecover(t *testing.T) {
+	store := storagefake.New()
+	if err := store.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	hub := startHub(t, HubOptions{
+		Persist: func(ctx context.Context, docID string, state map[string]interface{}) error {
+			_, err := store.SaveDocument(ctx, docID, state)
+			return err
+		},
+		StorageHealth: func(ctx context.Context) error {
+			_, err := store.HealthCheck(ctx)
+			return err
+		},
+		HealthCheckInterval: time.Hour,
+		DurableAcks:         true,
+	})
+	writer := connectAnonymous(t, hub, "writer")
+
+	store.Fail("SaveDocument", errStorageDown)
+	sendDelta(hub, writer, "room:durable", "title", "Later")
+	waitFor(t, "degraded mode", hub.Degraded)
+	select {
+	case data := <-writer.send:
+		t.Fatalf("sent %x before the write finished", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	store.Reset()
+	hub.checkStorage()
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["durable"] != true {
+		t.Errorf("ACK = %v, want durable", ack.Payload)
+	}
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"sync"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// DefaultSyncRequiredThreshold is how many rejected or dropped deltas a
+// connection may accumulate for a document before it is told to re-sync
+const DefaultSyncRequiredThreshold = 5
+
+// Reasons reported in sync_required besides the delta rejection reasons
+const (
+	DivergenceDropped  = "dropped"   // A broadcast delta did not fit in the send queue
+	DivergenceRestored = "restored"  // An administrator replaced the document's state
+	DivergenceRelayGap = "relay_gap" // Deltas relayed from other servers may have been missed
+)
+
+// divergenceTracker counts, per document, deltas a connection's client got
+// wrong: its own deltas the server rejected and broadcasts it never received.
+// A full sync of the document starts the count over.
+//
+// The zero value is ready to use.
+type divergenceTracker struct {
+	mu       sync.Mutex
+	counts   map[string]int    // docId -> rejected or dropped deltas since the last full sync
+	reasons  map[string]string // docId -> most recent reason
+	notified map[string]bool   // docId -> sync_required already sent
+}
+
+// add records n more divergent deltas for a document (n may be 0 to only
+// re-check) and reports whether sync_required is now due. A due notice is
+// marked as sent; call retry if sending it fails.
+func (d *divergenceTracker) add(docID, reason string, n, threshold int) (int, string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if n > 0 {
+		if d.counts == nil {
+			d.counts = make(map[string]int)
+			d.reasons = make(map[string]string)
+			d.notified = make(map[string]bool)
+		}
+		d.counts[docID] += n
+		d.reasons[docID] = reason
+	}
+
+	count := d.counts[docID]
+	if count < threshold || d.notified[docID] {
+		return count, d.reasons[docID], false
+	}
+	d.notified[docID] = true
+	return count, d.reasons[docID], true
+}
+
+// retry re-arms a notice that could not be delivered
+func (d *divergenceTracker) retry(docID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.notified, docID)
+}
+
+// reset forgets a document after a full sync or unsubscribe
+func (d *divergenceTracker) reset(docID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.counts, docID)
+	delete(d.reasons, docID)
+	delete(d.notified, docID)
+}
+
+// noteDivergence records n rejected or dropped deltas for a connection and,
+// once the count reaches the threshold, asks the client to re-sync the
+// document. The notice is sent once per threshold crossing; a full sync
+// starts counting again.
+func (h *Hub) noteDivergence(conn *Connection, docID, reason string, n int) {
+	count, reason, due := conn.divergence.add(docID, reason, n, h.opts.SyncRequiredThreshold)
+	if !due {
+		return
+	}
+
+	err := conn.Send(protocol.NewMessage(protocol.TypeSyncRequired, map[string]interface{}{
+		"docId":  docID,
+		"reason": reason,
+		"count":  count,
+	}))
+	if err != nil {
+		// Most likely the send queue is still full; try again on the next
+		// delta that does get through
+		conn.divergence.retry(docID)
+	}
+}
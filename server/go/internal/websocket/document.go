@@ -0,0 +1,357 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// Delta rejection reasons reported in ACKs
+const (
+	RejectInvalid    = "invalid"          // Delta is not an object
+	RejectStale      = "stale"            // Every change lost last-writer-wins to a newer write
+	RejectBlockSize  = "block_too_large"  // Every change exceeded MaxBlockSize
+	RejectBlockLimit = "block_limit"      // Delta would take the document past MaxBlocksPerDoc fields
+	RejectFields     = "field_permission" // Every change was to a field the writer may not write
+	RejectHook       = "hook"             // A HubOptions.Hooks.OnDelta hook denied it
+	RejectSchema     = "schema"           // The delta breaks its document's schema
+)
+
+// Error codes reported in ACKs for deltas that break content limits
+const (
+	CodeBlockTooLarge      = "BLOCK_TOO_LARGE"
+	CodeBlockLimitExceeded = "BLOCK_LIMIT_EXCEEDED"
+	CodeFieldPermission    = "FIELD_PERMISSION_DENIED"
+	CodeSchemaViolation    = "SCHEMA_VIOLATION"
+)
+
+// MaxClockSkew is how far ahead of the server's clock a client's delta
+// timestamp may be. Later timestamps are moved back to it, so a client with
+// a fast clock cannot keep its writes winning last-writer-wins for longer.
+const MaxClockSkew = 5 * time.Second
+
+// fieldFilter is the fields a writer may change in a document. The zero
+// value allows every field.
+type fieldFilter struct {
+	restricted bool
+	patterns   []string
+}
+
+// writableFields is the fieldFilter of conn's writes to a document
+func (h *Hub) writableFields(ctx context.Context, conn *Connection, docID string) fieldFilter {
+	patterns, restricted := h.opts.ACL.WritableFields(ctx, conn.TokenPayload, docID)
+	return fieldFilter{restricted: restricted, patterns: patterns}
+}
+
+func (f fieldFilter) allows(field string) bool {
+	if !f.restricted {
+		return true
+	}
+	for _, pattern := range f.patterns {
+		if auth.MatchesField(pattern, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropForbidden removes the changes to fields f does not allow, describing
+// each in a field error sorted by field. Deletes are changes too: a field
+// set to null or to a {"__deleted": true} tombstone is checked the same way.
+func (f fieldFilter) dropForbidden(changes map[string]interface{}) (map[string]interface{}, []map[string]interface{}) {
+	if !f.restricted {
+		return changes, nil
+	}
+	kept := make(map[string]interface{}, len(changes))
+	var fieldErrors []map[string]interface{}
+	for k, v := range changes {
+		if f.allows(k) {
+			kept[k] = v
+			continue
+		}
+		fieldErrors = append(fieldErrors, map[string]interface{}{
+			"field": k,
+			"code":  CodeFieldPermission,
+		})
+	}
+	sortFieldErrors(fieldErrors)
+	return kept, fieldErrors
+}
+
+// documentMeta tracks conflict-resolution metadata for an in-memory document.
+//
+// Not safe for concurrent use; the hub guards it with docsMu.
+type documentMeta struct {
+	clock      map[string]int64 // Vector clock: clientId -> counter
+	fieldTimes map[string]int64 // Field -> timestamp (ms) of the winning write
+}
+
+func newDocumentMeta() *documentMeta {
+	return &documentMeta{
+		clock:      make(map[string]int64),
+		fieldTimes: make(map[string]int64),
+	}
+}
+
+// mergeClock takes the per-client maximum of the document clock and a clock
+// sent by a client. SDK clients send "clock"; older clients send "vectorClock".
+func (m *documentMeta) mergeClock(delta map[string]interface{}) bool {
+	raw, ok := delta["clock"].(map[string]interface{})
+	if !ok {
+		raw, ok = delta["vectorClock"].(map[string]interface{})
+	}
+	if !ok {
+		return false
+	}
+
+	for clientID, v := range raw {
+		value, ok := v.(float64)
+		if !ok {
+			continue
+		}
+		if int64(value) > m.clock[clientID] {
+			m.clock[clientID] = int64(value)
+		}
+	}
+	return true
+}
+
+// tick advances the clock entry for a client
+func (m *documentMeta) tick(clientID string) {
+	m.clock[clientID]++
+}
+
+// clockSnapshot returns a copy of the vector clock safe to hand to encoders
+func (m *documentMeta) clockSnapshot() map[string]int64 {
+	clock := make(map[string]int64, len(m.clock))
+	for k, v := range m.clock {
+		clock[k] = v
+	}
+	return clock
+}
+
+// deltaResult is the outcome of applying one delta
+type deltaResult struct {
+	seq     int64                  // Sequence number assigned, 0 if rejected
+	delta   map[string]interface{} // Stamped delta to broadcast, nil if rejected
+	reason  string                 // Why the delta was rejected
+	code    string                 // Error code for limit rejections
+	created bool                   // The delta created the document
+	trimmed bool                   // Some of the delta's changes were dropped
+
+	// Changes dropped for exceeding MaxBlockSize or for fields the writer
+	// may not write, reported even when the rest of the delta applied, and
+	// the schema violations of a refused delta
+	fieldErrors []map[string]interface{}
+}
+
+func (r deltaResult) applied() bool {
+	return r.delta != nil
+}
+
+// ackStatus describes the result for an ACK payload
+func (r deltaResult) ackStatus() map[string]interface{} {
+	status := map[string]interface{}{"status": "applied", "seq": r.seq}
+	if !r.applied() {
+		status = map[string]interface{}{"status": "rejected", "reason": r.reason}
+		if r.code != "" {
+			status["code"] = r.code
+		}
+	}
+	if len(r.fieldErrors) > 0 {
+		status["fieldErrors"] = r.fieldErrors
+	}
+	return status
+}
+
+// documentMeta returns the metadata for a document, creating it on first use.
+// Must be called with docsMu held.
+func (h *Hub) documentMeta(docID string) *documentMeta {
+	meta := h.meta[docID]
+	if meta == nil {
+		meta = newDocumentMeta()
+		h.meta[docID] = meta
+	}
+	return meta
+}
+
+// forwardDelta copies a client's delta as the server sends it on: with a
+// fresh ID, and origin, the ID of the client message that carried it, as its
+// origin. The copy is what gets recorded, so resumes and other servers
+// forward the delta under the same ID.
+func forwardDelta(delta map[string]interface{}, origin string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(delta)+3)
+	for k, v := range delta {
+		copied[k] = v
+	}
+	return protocol.NewMessage(protocol.TypeDelta, copied).WithOrigin(origin).Payload
+}
+
+// reusablePayload returns the JSON a client's delta arrived as when the
+// broadcast copy differs from it only in serverFields, so it goes out
+// without its changes being encoded again. It is nil when changes were
+// dropped or an OnDelta hook may have rewritten them.
+func (h *Hub) reusablePayload(msg *protocol.Message, result deltaResult) []byte {
+	if !result.applied() || result.trimmed || h.opts.Hooks.OnDelta != nil {
+		return nil
+	}
+	return msg.RawPayload()
+}
+
+// applyDelta applies a delta's changes with last-writer-wins per field,
+// advances the document's vector clock and records the delta for resume.
+// Changes that lose to a newer write, or to fields the writer may not
+// write, are dropped from the broadcast copy. fallbackTs is used when the
+// delta carries no timestamp of its own. validate checks the changes
+// against the document's schema and holds the timestamp to MaxClockSkew;
+// deltas relayed from other servers were checked where they were written.
+// Must be called with docsMu held.
+func (h *Hub) applyDelta(docID, clientID string, delta map[string]interface{}, fallbackTs int64, fields fieldFilter, validate bool) deltaResult {
+	changes, hasChanges := delta["changes"].(map[string]interface{})
+
+	// Field permissions and content limits are checked before anything is
+	// touched, so a rejected delta never creates the document
+	changes, forbidden := fields.dropForbidden(changes)
+	if hasChanges && len(changes) == 0 && len(forbidden) > 0 {
+		return deltaResult{reason: RejectFields, code: CodeFieldPermission, fieldErrors: forbidden}
+	}
+	changes, oversized := h.dropOversizedChanges(changes)
+	fieldErrors := append(forbidden, oversized...)
+	sortFieldErrors(fieldErrors)
+	if hasChanges && len(changes) == 0 && len(oversized) > 0 {
+		return deltaResult{reason: RejectBlockSize, code: CodeBlockTooLarge, fieldErrors: fieldErrors}
+	}
+	if h.exceedsBlockLimit(h.documents[docID], changes) {
+		return deltaResult{reason: RejectBlockLimit, code: CodeBlockLimitExceeded, fieldErrors: fieldErrors}
+	}
+	if validate && len(changes) > 0 {
+		if violations := h.schemaViolations(docID, changes); len(violations) > 0 {
+			fieldErrors = append(fieldErrors, violations...)
+			sortFieldErrors(fieldErrors)
+			return deltaResult{reason: RejectSchema, code: CodeSchemaViolation, fieldErrors: fieldErrors}
+		}
+	}
+
+	created := false
+	var grow int64
+	if h.documents[docID] == nil {
+		h.documents[docID] = make(map[string]interface{})
+		created = true
+		grow = documentSize(nil)
+	}
+	doc := h.documents[docID]
+	meta := h.documentMeta(docID)
+
+	ts := fallbackTs
+	if t, ok := delta["timestamp"].(float64); ok && t > 0 {
+		ts = int64(t)
+	}
+	if ts <= 0 {
+		ts = time.Now().UnixMilli()
+	}
+	if latest := time.Now().Add(MaxClockSkew).UnixMilli(); validate && ts > latest {
+		// The delta is recorded with the clamped time, so resumes and other
+		// servers order it the same way
+		ts = latest
+		delta["timestamp"] = float64(ts)
+	}
+
+	accepted := make(map[string]interface{}, len(changes))
+	for k, v := range changes {
+		if meta.fieldTimes[k] > ts {
+			continue
+		}
+		grow += fieldGrowth(doc, k, v)
+		doc[k] = v
+		meta.fieldTimes[k] = ts
+		accepted[k] = v
+	}
+	h.cache.use(docID, grow, len(accepted) > 0)
+	if hasChanges && len(changes) > 0 && len(accepted) == 0 {
+		return deltaResult{reason: RejectStale, created: created, fieldErrors: fieldErrors}
+	}
+
+	if !meta.mergeClock(delta) {
+		meta.tick(clientID)
+	}
+
+	seq, stamped := h.recordDelta(docID, delta)
+	trimmed := hasChanges && len(accepted) < len(delta["changes"].(map[string]interface{}))
+	if trimmed {
+		stamped["changes"] = accepted
+	}
+	return deltaResult{seq: seq, delta: stamped, created: created, trimmed: trimmed, fieldErrors: fieldErrors}
+}
+
+// dropOversizedChanges removes changes whose JSON-encoded value is larger
+// than MaxBlockSize, describing each in a field error sorted by field
+func (h *Hub) dropOversizedChanges(changes map[string]interface{}) (map[string]interface{}, []map[string]interface{}) {
+	max := h.opts.Limits.MaxBlockSize
+	var kept map[string]interface{}
+	var fieldErrors []map[string]interface{}
+	for k, v := range changes {
+		encoded, err := json.Marshal(v)
+		if err == nil && len(encoded) <= max {
+			continue
+		}
+		if kept == nil {
+			kept = make(map[string]interface{}, len(changes))
+			for field, value := range changes {
+				kept[field] = value
+			}
+		}
+		delete(kept, k)
+		fieldErrors = append(fieldErrors, map[string]interface{}{
+			"field": k,
+			"code":  CodeBlockTooLarge,
+			"size":  len(encoded),
+			"max":   max,
+		})
+	}
+	if kept == nil {
+		return changes, nil
+	}
+	sortFieldErrors(fieldErrors)
+	return kept, fieldErrors
+}
+
+// schemaViolations checks changes against the schema of docID, describing
+// each way they break it in a field error. A delta that breaks its schema
+// is refused whole, since applying part of it could leave the document in
+// a state no client wrote.
+// Must be called with docsMu held.
+func (h *Hub) schemaViolations(docID string, changes map[string]interface{}) []map[string]interface{} {
+	var fieldErrors []map[string]interface{}
+	for _, violation := range h.opts.Schemas.Validate(docID, h.documents[docID], changes) {
+		fieldErrors = append(fieldErrors, map[string]interface{}{
+			"field":   violation.Field,
+			"code":    CodeSchemaViolation,
+			"path":    violation.Path,
+			"keyword": violation.Keyword,
+			"message": violation.Message,
+		})
+	}
+	return fieldErrors
+}
+
+func sortFieldErrors(fieldErrors []map[string]interface{}) {
+	sort.SliceStable(fieldErrors, func(i, j int) bool {
+		return fieldErrors[i]["field"].(string) < fieldErrors[j]["field"].(string)
+	})
+}
+
+// exceedsBlockLimit reports whether applying changes would give doc more
+// than MaxBlocksPerDoc top-level fields. doc may be nil for a new document.
+func (h *Hub) exceedsBlockLimit(doc, changes map[string]interface{}) bool {
+	added := 0
+	for k := range changes {
+		if _, exists := doc[k]; !exists {
+			added++
+		}
+	}
+	return added > 0 && len(doc)+added > h.opts.Limits.MaxBlocksPerDoc
+}
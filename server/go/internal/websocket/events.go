@@ -0,0 +1,46 @@
+package websocket
+
+import (
+	"context"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// EventStream is a read-only document subscription for clients that cannot
+// open a websocket, such as Server-Sent Events. It is a Session subscribed
+// in read mode, so it receives the same broadcasts as websocket subscribers.
+type EventStream struct {
+	*Session
+}
+
+// EventStreamOptions describes who is subscribing and from where
+type EventStreamOptions struct {
+	Token    *auth.TokenPayload // Verified token of the subscriber
+	ClientIP string
+
+	// Resume after this sequence number when Resume is set. The first event
+	// is then a sync_response carrying only the missed deltas, or the full
+	// state if they are no longer buffered.
+	ResumeFrom int64
+	Resume     bool
+}
+
+// OpenEventStream registers a read-only subscriber to docID. The first event
+// is the sync_response (or an error if the subscription was refused).
+func (h *Hub) OpenEventStream(ctx context.Context, docID string, opts EventStreamOptions) (*EventStream, error) {
+	session, err := h.OpenSession(ctx, "sse-", SessionOptions{Token: opts.Token, ClientIP: opts.ClientIP})
+	if err != nil {
+		return nil, err
+	}
+
+	payload := map[string]interface{}{"docId": docID, "mode": ModeRead}
+	if opts.Resume {
+		payload["resumeFrom"] = map[string]interface{}{docID: float64(opts.ResumeFrom)}
+	}
+	if _, err := session.Send(ctx, protocol.TypeSubscribe, payload); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &EventStream{Session: session}, nil
+}
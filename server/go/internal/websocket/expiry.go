@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// DefaultTokenExpiryWarning is how long before its token expires a
+// connection is sent token_expiring
+const DefaultTokenExpiryWarning = 5 * time.Minute
+
+// DefaultTokenExpiryGrace is how long a connection may outlive its token
+// before it is closed with TOKEN_EXPIRED
+const DefaultTokenExpiryGrace = 30 * time.Second
+
+// tokenExpiry warns a connection before its token expires and closes it
+// once the token has been expired longer than HubOptions.TokenExpiryGrace.
+// Authenticating again replaces both timers.
+type tokenExpiry struct {
+	mu        sync.Mutex
+	expiresAt time.Time // Expiry of the token the timers are for
+	warn      *time.Timer
+	close     *time.Timer
+}
+
+// stop disarms the timers. Must be called with mu held.
+func (t *tokenExpiry) stop() {
+	if t.warn != nil {
+		t.warn.Stop()
+	}
+	if t.close != nil {
+		t.close.Stop()
+	}
+	t.expiresAt = time.Time{}
+}
+
+// current reports whether expiresAt is still the expiry the timers are for,
+// so a timer that fired just before a re-authentication does nothing
+func (t *tokenExpiry) current(expiresAt time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.expiresAt.IsZero() && t.expiresAt.Equal(expiresAt)
+}
+
+// armTokenExpiry schedules the expiry warning and close for token, replacing
+// those of conn's earlier token. Tokens without an expiry, like those of API
+// keys, schedule nothing.
+func (h *Hub) armTokenExpiry(conn *Connection, token *auth.TokenPayload) {
+	conn.tokenExpiry.mu.Lock()
+	defer conn.tokenExpiry.mu.Unlock()
+	conn.tokenExpiry.stop()
+	if token == nil || token.ExpiresAt == nil {
+		return
+	}
+
+	expiresAt := token.ExpiresAt.Time
+	conn.tokenExpiry.expiresAt = expiresAt
+	conn.tokenExpiry.warn = time.AfterFunc(time.Until(expiresAt.Add(-h.opts.TokenExpiryWarning)), func() {
+		h.exec(func() { h.tokenExpiring(conn, expiresAt) })
+	})
+	conn.tokenExpiry.close = time.AfterFunc(time.Until(expiresAt.Add(h.opts.TokenExpiryGrace)), func() {
+		h.exec(func() { h.tokenExpired(conn, expiresAt) })
+	})
+}
+
+// stopTokenExpiry disarms conn's expiry timers
+func (c *Connection) stopTokenExpiry() {
+	c.tokenExpiry.mu.Lock()
+	c.tokenExpiry.stop()
+	c.tokenExpiry.mu.Unlock()
+}
+
+// tokenExpiring asks conn to authenticate again with a fresh token. Must be
+// called on the Run goroutine.
+func (h *Hub) tokenExpiring(conn *Connection, expiresAt time.Time) {
+	if conn.IsClosed() || !conn.tokenExpiry.current(expiresAt) {
+		return
+	}
+	conn.Logger().Debug("Token expiring", "expires_at", expiresAt)
+	conn.Send(protocol.NewMessage(protocol.TypeTokenExpiring, map[string]interface{}{
+		"expiresAt": expiresAt.UnixMilli(),
+		"expiresIn": time.Until(expiresAt).Milliseconds(),
+	}))
+}
+
+// tokenExpired closes conn with TOKEN_EXPIRED unless it authenticated again
+// or closed meanwhile. Must be called on the Run goroutine.
+func (h *Hub) tokenExpired(conn *Connection, expiresAt time.Time) {
+	if conn.IsClosed() || !conn.tokenExpiry.current(expiresAt) {
+		return
+	}
+	h.audit(conn, audit.EventAuthFailure, "", map[string]interface{}{"code": "TOKEN_EXPIRED"})
+	h.kick(conn, "Token expired", "TOKEN_EXPIRED",
+		"Token expired at "+expiresAt.UTC().Format(time.RFC3339))
+}
+
+// reauthenticate swaps the token of a connection that already authenticated
+// for a fresh one of the same user, keeping its client ID and
+// subscriptions. Subscriptions the new token no longer allows are dropped or
+// made read-only as when access is revoked.
+func (h *Hub) reauthenticate(ctx context.Context, conn *Connection, msgID string, token *auth.TokenPayload) {
+	if token.UserID != conn.VerifiedUserID() {
+		h.metrics.authFailures.Add(1)
+		h.audit(conn, audit.EventAuthFailure, "", map[string]interface{}{"code": "USER_MISMATCH"})
+		conn.Logger().Warn("Re-authentication failed", "code", "USER_MISMATCH")
+		conn.Send(protocol.NewMessage(protocol.TypeAuthError, map[string]interface{}{
+			"error": "Token is for a different user",
+			"code":  "USER_MISMATCH",
+		}).WithOrigin(msgID))
+		return
+	}
+	// Tenant-scoped quotas and metrics stay with the connection's tenant
+	if token.TenantID != conn.Tenant() {
+		h.refuseAuth(conn, msgID, "TENANT_MISMATCH", "Token is for a different tenant")
+		return
+	}
+
+	// Readers on other goroutines, like fan-out, hold h.mu
+	h.mu.Lock()
+	conn.TokenPayload = token
+	h.mu.Unlock()
+	h.armTokenExpiry(conn, token)
+
+	docIDs := make(map[string]bool, len(conn.Subscriptions)+len(conn.AwarenessSubscriptions))
+	for docID := range conn.Subscriptions {
+		docIDs[docID] = true
+	}
+	for docID := range conn.AwarenessSubscriptions {
+		docIDs[docID] = true
+	}
+	revoked := 0
+	for docID := range docIDs {
+		if h.revokeLostAccess(ctx, conn, docID) {
+			h.syncRelay(docID)
+			revoked++
+		}
+	}
+	conn.Logger().Info("Re-authenticated", "revoked", revoked)
+
+	conn.Send(protocol.NewMessage(protocol.TypeAuthSuccess, map[string]interface{}{
+		"userId":          conn.UserID,
+		"reauthenticated": true,
+		"permissions": map[string]interface{}{
+			"canRead":  token.Permissions.CanRead,
+			"canWrite": token.Permissions.CanWrite,
+			"isAdmin":  token.Permissions.IsAdmin,
+		},
+	}).WithOrigin(msgID))
+}
@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// shortToken issues a token for user-id lasting ttl. JWT expiry has second
+// precision, so it expires up to a second sooner.
+func shortToken(t *testing.T, id string, perms auth.DocumentPermissions, ttl time.Duration) string {
+	t.Helper()
+	token, err := auth.GenerateAccessToken("user-"+id, "", perms, testSecret, ttl)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken failed: %v", err)
+	}
+	return token
+}
+
+func TestHub_WarnsBeforeTokenExpiresAndClosesAfterGrace(t *testing.T) {
+	hub := startHub(t, HubOptions{TokenExpiryWarning: 1500 * time.Millisecond, TokenExpiryGrace: 100 * time.Millisecond})
+	conn := newTestConnection(hub, "alice")
+	hub.Register <- conn
+	dispatch(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": shortToken(t, "alice", auth.CreateUserPermissions([]string{"*"}, nil), 2*time.Second)})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+
+	msg := expectMessage(t, conn, protocol.TypeTokenExpiring)
+	if msg.Payload["expiresAt"] != float64(conn.TokenPayload.ExpiresAt.UnixMilli()) {
+		t.Errorf("expiresAt = %v, want the token's expiry", msg.Payload["expiresAt"])
+	}
+	if conn.IsClosed() {
+		t.Fatal("connection closed before its token expired")
+	}
+
+	expectError(t, conn, "TOKEN_EXPIRED")
+	flushHub(t, hub)
+	if !conn.IsClosed() {
+		t.Error("connection with an expired token left open")
+	}
+}
+
+func TestHub_ReauthenticationRefreshesToken(t *testing.T) {
+	hub := startHub(t, HubOptions{TokenExpiryWarning: 1500 * time.Millisecond, TokenExpiryGrace: 100 * time.Millisecond})
+	perms := auth.CreateUserPermissions([]string{"*"}, []string{"*"})
+	conn := newTestConnection(hub, "alice")
+	hub.Register <- conn
+	dispatch(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": shortToken(t, "alice", perms, 2*time.Second), "clientId": "tab-1"})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	subscribe(t, hub, conn, "room:keep")
+	subscribe(t, hub, conn, "room:drop")
+	expectMessage(t, conn, protocol.TypeTokenExpiring)
+
+	// A token of another user is refused and the connection keeps its own
+	dispatch(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": shortToken(t, "mallory", perms, time.Hour)})
+	if msg := expectMessage(t, conn, protocol.TypeAuthError); msg.Payload["code"] != "USER_MISMATCH" {
+		t.Errorf("auth as another user = %v, want USER_MISMATCH", msg.Payload)
+	}
+
+	// The fresh token no longer covers room:drop
+	fresh := auth.CreateUserPermissions([]string{"room:keep"}, []string{"room:keep"})
+	dispatch(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": shortToken(t, "alice", fresh, time.Hour)})
+	if msg := expectMessage(t, conn, protocol.TypeError); msg.Payload["code"] != "ACCESS_REVOKED" || msg.Payload["docId"] != "room:drop" {
+		t.Errorf("error = %v, want ACCESS_REVOKED for room:drop", msg.Payload)
+	}
+	if msg := expectMessage(t, conn, protocol.TypeAuthSuccess); msg.Payload["reauthenticated"] != true {
+		t.Errorf("auth_success = %v, want reauthenticated", msg.Payload)
+	}
+	flushHub(t, hub)
+	if conn.ClientID != "tab-1" || !conn.Subscriptions["room:keep"] || conn.Subscriptions["room:drop"] {
+		t.Errorf("client %q subscriptions %v, want tab-1 still on room:keep only", conn.ClientID, conn.Subscriptions)
+	}
+
+	// Past the first token's expiry and grace the connection stays open
+	time.Sleep(2200 * time.Millisecond)
+	flushHub(t, hub)
+	if conn.IsClosed() {
+		t.Fatal("connection closed after re-authenticating")
+	}
+	sendDelta(hub, conn, "room:keep", "title", "still here")
+	expectMessage(t, conn, protocol.TypeAck)
+}
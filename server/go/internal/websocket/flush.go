@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// RejectOutOfOrder is the ACK reason for a flushed delta numbered past the
+// one the server expects next
+const RejectOutOfOrder = "out_of_order"
+
+// FlushRetention is how long a flush watermark nobody uses stays in memory
+const FlushRetention = 24 * time.Hour
+
+// flushKey identifies a client's flushes to one document
+type flushKey struct {
+	clientID string
+	docID    string
+}
+
+// flushMark is how far a client's latest flush to a document was handled
+type flushMark struct {
+	flushID   string
+	watermark int64 // Highest contiguous deltaIndex handled, -1 before any
+	used      time.Time
+}
+
+// flushMarks holds flush watermarks. Batches for a document are handled on
+// its worker, so a watermark is only read and advanced by one goroutine at
+// a time; the mutex guards the map between workers.
+type flushMarks struct {
+	mu      sync.Mutex
+	entries map[flushKey]*flushMark
+	pruned  time.Time
+}
+
+// flushWatermark returns the watermark of conn's flush flushID to docID,
+// -1 for a flush the hub has not seen. A watermark not in memory is read
+// from HubOptions.FlushWatermarks. If the read fails conn is sent TIMEOUT or
+// STORAGE_ERROR and false is returned: resuming without it could apply
+// deltas twice.
+func (h *Hub) flushWatermark(ctx context.Context, conn *Connection, docID, flushID string) (int64, bool) {
+	key := flushKey{conn.ClientID, docID}
+	marks := &h.flushes
+	marks.mu.Lock()
+	mark := marks.entries[key]
+	marks.mu.Unlock()
+
+	if mark == nil && h.opts.FlushWatermarks != nil {
+		entry, err := h.opts.FlushWatermarks.GetFlushWatermark(ctx, conn.ClientID, docID)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) || (err != nil && ctx.Err() != nil):
+			conn.Logger().Warn("Flush watermark read timed out", "doc_id", docID)
+			conn.replyError("Request timed out", "TIMEOUT")
+			return 0, false
+		case err != nil:
+			conn.Logger().Error("Flush watermark read failed", "doc_id", docID, "err", err)
+			conn.replyError("Failed to load flush watermark", "STORAGE_ERROR")
+			return 0, false
+		case entry != nil:
+			mark = &flushMark{flushID: entry.FlushID, watermark: entry.Watermark}
+		}
+	}
+
+	if mark == nil || mark.flushID != flushID {
+		return -1, true
+	}
+	return mark.watermark, true
+}
+
+// advanceFlush records that conn's flush flushID to docID was handled up
+// to watermark
+func (h *Hub) advanceFlush(conn *Connection, docID, flushID string, watermark int64) {
+	now := time.Now()
+	marks := &h.flushes
+	marks.mu.Lock()
+	defer marks.mu.Unlock()
+	if marks.entries == nil {
+		marks.entries = make(map[flushKey]*flushMark)
+	}
+	marks.entries[flushKey{conn.ClientID, docID}] = &flushMark{flushID: flushID, watermark: watermark, used: now}
+
+	// Clients that finished flushing long ago need not be remembered here;
+	// HubOptions.FlushWatermarks still has them
+	if now.Sub(marks.pruned) < FlushRetention/24 {
+		return
+	}
+	marks.pruned = now
+	for key, mark := range marks.entries {
+		if now.Sub(mark.used) > FlushRetention {
+			delete(marks.entries, key)
+		}
+	}
+}
+
+// saveFlush stores a watermark with HubOptions.FlushWatermarks, if set.
+// Failures are logged: the watermark in memory still guards this server.
+func (h *Hub) saveFlush(ctx context.Context, conn *Connection, docID, flushID string, watermark int64) {
+	if h.opts.FlushWatermarks == nil {
+		return
+	}
+	err := h.opts.FlushWatermarks.SaveFlushWatermark(ctx, &storage.FlushWatermarkEntry{
+		ClientID:   conn.ClientID,
+		DocumentID: docID,
+		FlushID:    flushID,
+		Watermark:  watermark,
+	})
+	if err != nil {
+		conn.Logger().Warn("Flush watermark save failed", "doc_id", docID, "flush_id", flushID, "err", err)
+	}
+}
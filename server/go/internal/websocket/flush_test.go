@@ -0,0 +1,219 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// memoryWatermarks is a FlushWatermarkStorage kept in a map
+type memoryWatermarks struct {
+	mu      sync.Mutex
+	entries map[flushKey]storage.FlushWatermarkEntry
+	saves   int
+}
+
+func newMemoryWatermarks() *memoryWatermarks {
+	return &memoryWatermarks{entries: make(map[flushKey]storage.FlushWatermarkEntry)}
+}
+
+func (m *memoryWatermarks) GetFlushWatermark(ctx context.Context, clientID, documentID string) (*storage.FlushWatermarkEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[flushKey{clientID, documentID}]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (m *memoryWatermarks) SaveFlushWatermark(ctx context.Context, entry *storage.FlushWatermarkEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[flushKey{entry.ClientID, entry.DocumentID}] = *entry
+	m.saves++
+	return nil
+}
+
+func (m *memoryWatermarks) get(clientID, docID string) (storage.FlushWatermarkEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[flushKey{clientID, docID}]
+	return entry, ok
+}
+
+// joinAsClient is joinDirect for a connection authenticating with clientID
+func joinAsClient(t *testing.T, hub *Hub, id, clientID, docID string) *Connection {
+	t.Helper()
+	conn := newTestConnection(hub, id)
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "alice", "clientId": clientID})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": docID})
+	expectMessage(t, conn, protocol.TypeSyncResponse)
+	return conn
+}
+
+// flushDeltas sends deltas first to last of flush flushID, delta i setting
+// field "n<i>"
+func flushDeltas(hub *Hub, conn *Connection, docID, flushID string, first, last int) {
+	deltas := []interface{}{}
+	for i := first; i <= last; i++ {
+		deltas = append(deltas, map[string]interface{}{"changes": map[string]interface{}{"n" + string(rune('0'+i)): float64(i)}})
+	}
+	handleDirect(hub, conn, protocol.TypeDeltaBatch, map[string]interface{}{
+		"docId":      docID,
+		"deltas":     deltas,
+		"flushId":    flushID,
+		"deltaIndex": float64(first),
+	})
+}
+
+func ackStatuses(ack *protocol.Message) []string {
+	var statuses []string
+	results, _ := ack.Payload["results"].([]interface{})
+	for _, result := range results {
+		status, _ := result.(map[string]interface{})
+		s, _ := status["status"].(string)
+		if reason, ok := status["reason"].(string); ok {
+			s += ":" + reason
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// seqOf reads a document's sequence number the way the hub does
+func seqOf(hub *Hub, docID string) int64 {
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	return hub.currentSeq(docID)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHub_ReflushAfterDisconnectAppliesEachDeltaOnce(t *testing.T) {
+	hub := NewHub(testAuth)
+	observer := joinDirect(t, hub, "observer", "room:flush")
+
+	// The first three deltas arrive, then the connection dies before the
+	// client reads the ACK
+	first := joinAsClient(t, hub, "first", "device-1", "room:flush")
+	flushDeltas(hub, first, "room:flush", "flush-1", 0, 2)
+	hub.unregister(first)
+
+	// Not knowing how far it got, the client flushes everything again
+	second := joinAsClient(t, hub, "second", "device-1", "room:flush")
+	flushDeltas(hub, second, "room:flush", "flush-1", 0, 4)
+	ack := expectMessage(t, second, protocol.TypeAck)
+	if want := []string{"duplicate", "duplicate", "duplicate", "applied", "applied"}; !equalStrings(ackStatuses(ack), want) {
+		t.Errorf("results = %v, want %v", ackStatuses(ack), want)
+	}
+	if ack.Payload["flushId"] != "flush-1" || ack.Payload["watermark"] != float64(4) {
+		t.Errorf("ACK = %v, want flush-1 handled up to 4", ack.Payload)
+	}
+
+	// Exactly five deltas were applied and broadcast
+	if seq := seqOf(hub, "room:flush"); seq != 5 {
+		t.Errorf("document seq = %d, want 5", seq)
+	}
+	for i := 0; i < 5; i++ {
+		expectMessage(t, observer, protocol.TypeDelta)
+	}
+	if n := len(observer.send); n != 0 {
+		t.Errorf("observer has %d more messages queued, want no repeated deltas", n)
+	}
+
+	// Flushing it yet again changes nothing
+	flushDeltas(hub, second, "room:flush", "flush-1", 0, 4)
+	ack = expectMessage(t, second, protocol.TypeAck)
+	if ack.Payload["count"] != float64(5) || ack.Payload["watermark"] != float64(4) || seqOf(hub, "room:flush") != 5 {
+		t.Errorf("third flush ACK = %v, seq %d; want nothing applied", ack.Payload, seqOf(hub, "room:flush"))
+	}
+}
+
+func TestHub_FlushRejectsDeltasPastTheWatermark(t *testing.T) {
+	hub := NewHub(testAuth)
+	conn := joinAsClient(t, hub, "conn", "device-1", "room:flush")
+
+	// Deltas 0 to 2 never arrived
+	flushDeltas(hub, conn, "room:flush", "flush-1", 3, 4)
+	ack := expectMessage(t, conn, protocol.TypeAck)
+	if want := []string{"rejected:" + RejectOutOfOrder, "rejected:" + RejectOutOfOrder}; !equalStrings(ackStatuses(ack), want) {
+		t.Errorf("results = %v, want %v", ackStatuses(ack), want)
+	}
+	if ack.Payload["watermark"] != float64(-1) {
+		t.Errorf("watermark = %v, want -1", ack.Payload["watermark"])
+	}
+
+	flushDeltas(hub, conn, "room:flush", "flush-1", 0, 4)
+	ack = expectMessage(t, conn, protocol.TypeAck)
+	if ack.Payload["watermark"] != float64(4) || seqOf(hub, "room:flush") != 5 {
+		t.Errorf("ACK = %v, seq %d; want all five applied", ack.Payload, seqOf(hub, "room:flush"))
+	}
+
+	// A new flush starts over
+	flushDeltas(hub, conn, "room:flush", "flush-2", 0, 0)
+	ack = expectMessage(t, conn, protocol.TypeAck)
+	if want := []string{"applied"}; !equalStrings(ackStatuses(ack), want) || ack.Payload["watermark"] != float64(0) {
+		t.Errorf("new flush ACK = %v, want its first delta applied", ack.Payload)
+	}
+
+	// Another client's flush of the same name is its own
+	other := joinAsClient(t, hub, "other", "device-2", "room:flush")
+	flushDeltas(hub, other, "room:flush", "flush-2", 0, 0)
+	if ack := expectMessage(t, other, protocol.TypeAck); !equalStrings(ackStatuses(ack), []string{"applied"}) {
+		t.Errorf("other client's ACK = %v, want applied", ack.Payload)
+	}
+}
+
+func TestHub_FlushWatermarkOutlivesTheServer(t *testing.T) {
+	store := newGatedStore()
+	watermarks := newMemoryWatermarks()
+	hub := NewHubWithOptions(testAuth, HubOptions{Persist: store.persist, DurableAcks: true, FlushWatermarks: watermarks})
+	conn := joinAsClient(t, hub, "conn", "device-1", "room:flush")
+
+	flushDeltas(hub, conn, "room:flush", "flush-1", 0, 2)
+	store.waitStarted(t)
+	if _, ok := watermarks.get("device-1", "room:flush"); ok {
+		t.Fatal("watermark saved before the document was written")
+	}
+	store.gate <- struct{}{}
+	expectMessage(t, conn, protocol.TypeAck)
+	if entry, ok := watermarks.get("device-1", "room:flush"); !ok || entry.FlushID != "flush-1" || entry.Watermark != 2 {
+		t.Fatalf("stored watermark = %+v, want flush-1 at 2", entry)
+	}
+
+	// After a restart the re-flush resumes from the stored watermark
+	restarted := NewHubWithOptions(testAuth, HubOptions{FlushWatermarks: watermarks})
+	conn = joinAsClient(t, restarted, "conn", "device-1", "room:flush")
+	flushDeltas(restarted, conn, "room:flush", "flush-1", 0, 3)
+	ack := expectMessage(t, conn, protocol.TypeAck)
+	if want := []string{"duplicate", "duplicate", "duplicate", "applied"}; !equalStrings(ackStatuses(ack), want) {
+		t.Errorf("results = %v, want %v", ackStatuses(ack), want)
+	}
+	if entry, _ := watermarks.get("device-1", "room:flush"); entry.Watermark != 3 {
+		t.Errorf("stored watermark = %d, want 3", entry.Watermark)
+	}
+
+	// Nothing new handled, nothing saved
+	saves := watermarks.saves
+	flushDeltas(restarted, conn, "room:flush", "flush-1", 0, 3)
+	expectMessage(t, conn, protocol.TypeAck)
+	if watermarks.saves != saves {
+		t.Errorf("re-flushing handled deltas saved the watermark again")
+	}
+}
@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+)
+
+// DefaultAuthTimeout is how long a connection may stay unauthenticated
+const DefaultAuthTimeout = 10 * time.Second
+
+// authDeadline tracks a connection until it authenticates: a timer closes it
+// if it takes longer than HubOptions.AuthTimeout, and it counts against the
+// per-IP unauthenticated connection limit meanwhile
+type authDeadline struct {
+	timer   *time.Timer // Set in register, before any message is handled
+	done    atomic.Bool // Set once the connection authenticates
+	counted atomic.Bool // Holding a slot in SecurityManager.PendingAuthLimiter
+}
+
+// startAuthDeadline counts conn as unauthenticated and arms its timer. Must
+// be called on the Run goroutine.
+func (h *Hub) startAuthDeadline(conn *Connection) {
+	if conn.SecurityManager != nil {
+		conn.SecurityManager.PendingAuthLimiter.AddConnection(conn.ClientIP)
+		conn.authDeadline.counted.Store(true)
+	}
+	conn.authDeadline.timer = time.AfterFunc(h.opts.AuthTimeout, func() {
+		h.exec(func() { h.authTimedOut(conn) })
+	})
+}
+
+// authTimedOut closes conn with AUTH_TIMEOUT unless it authenticated or closed
+// meanwhile. Must be called on the Run goroutine.
+func (h *Hub) authTimedOut(conn *Connection) {
+	if conn.authDeadline.done.Load() || conn.IsClosed() {
+		return
+	}
+	h.metrics.authFailures.Add(1)
+	h.audit(conn, audit.EventAuthFailure, "", map[string]interface{}{"code": "AUTH_TIMEOUT"})
+	h.kick(conn, "Authentication timed out", "AUTH_TIMEOUT",
+		"No successful auth within "+h.opts.AuthTimeout.String())
+}
+
+// authenticated disarms the deadline and frees the connection's
+// unauthenticated slot
+func (c *Connection) authenticated() {
+	c.authDeadline.done.Store(true)
+	c.releaseAuthDeadline()
+}
+
+// releaseAuthDeadline stops the timer and frees the unauthenticated slot, if
+// still held. Safe to call more than once.
+func (c *Connection) releaseAuthDeadline() {
+	if c.authDeadline.timer != nil {
+		c.authDeadline.timer.Stop()
+	}
+	if c.authDeadline.counted.CompareAndSwap(true, false) {
+		c.SecurityManager.PendingAuthLimiter.RemoveConnection(c.ClientIP)
+	}
+}
@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// tickPeriod is how often WritePump's ticker fires: the ping period, or the
+// heartbeat interval when that is shorter
+func (c *Connection) tickPeriod() time.Duration {
+	if c.hub == nil {
+		return pingPeriod
+	}
+	if interval := c.hub.opts.HeartbeatInterval; interval > 0 && interval < pingPeriod {
+		return interval
+	}
+	return pingPeriod
+}
+
+// heartbeatDue reports whether a heartbeat should go out on the tick at
+// now, the last having gone out at last. Ticks jitter, so half a tick
+// early counts as on time.
+func (c *Connection) heartbeatDue(now, last time.Time, tick time.Duration) bool {
+	if c.hub == nil || c.hub.opts.HeartbeatInterval <= 0 || !c.can(capHeartbeat) {
+		return false
+	}
+	return now.Sub(last) >= c.hub.opts.HeartbeatInterval-tick/2
+}
+
+// sendHeartbeat queues a heartbeat for conn: the server time, conn's ping
+// round trip once measured, the number of other clients with awareness
+// state on each document it subscribes to, and whether the server is in
+// maintenance mode. It reports false if the heartbeat was not sent and
+// should be tried again on the next tick, as when conn is busy having a
+// message handled.
+func (h *Hub) sendHeartbeat(conn *Connection, now time.Time) bool {
+	// Subscriptions belong to the handler; WritePump must not wait on it
+	if !conn.handleMu.TryLock() {
+		return false
+	}
+	clientID := conn.ClientID
+	docIDs := make([]string, 0, len(conn.Subscriptions))
+	for docID := range conn.Subscriptions {
+		docIDs = append(docIDs, docID)
+	}
+	conn.handleMu.Unlock()
+
+	peers := make(map[string]interface{}, len(docIDs))
+	h.awareMu.RLock()
+	for _, docID := range docIDs {
+		states := h.awareness[docID]
+		n := len(states)
+		if _, own := states[clientID]; own {
+			n--
+		}
+		peers[docID] = n
+	}
+	h.awareMu.RUnlock()
+
+	payload := map[string]interface{}{
+		"serverTime":  now.UnixMilli(),
+		"peers":       peers,
+		"maintenance": h.Maintenance().Enabled,
+	}
+	if rtt, ok := conn.RTT(); ok {
+		payload["rttMs"] = float64(rtt.Microseconds()) / 1000
+	}
+	return conn.Send(protocol.NewMessage(protocol.TypeHeartbeat, payload)) == nil
+}
@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// newHeartbeatHub runs a hub sending heartbeats every interval
+func newHeartbeatHub(t *testing.T, interval time.Duration) *Hub {
+	t.Helper()
+	hub := NewHubWithOptions(testAuth, HubOptions{HeartbeatInterval: interval})
+	go hub.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		hub.Stop(ctx)
+	})
+	return hub
+}
+
+// authHeartbeat authenticates c asking for heartbeats
+func authHeartbeat(c *TestClient, userID string) {
+	c.Send(protocol.TypeAuth, map[string]interface{}{"userId": userID, "protocolVersion": 2.0, "capabilities": []interface{}{"heartbeat"}})
+	c.Expect(protocol.TypeAuthSuccess)
+}
+
+// expectHeartbeat returns the first heartbeat c gets that satisfies ok,
+// or the last one if none does within a second
+func expectHeartbeat(c *TestClient, ok func(payload map[string]interface{}) bool) *protocol.Message {
+	c.t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		msg := c.Expect(protocol.TypeHeartbeat)
+		if ok(msg.Payload) || time.Now().After(deadline) {
+			return msg
+		}
+	}
+}
+
+func TestTransport_HeartbeatReportsStatus(t *testing.T) {
+	hub := newHeartbeatHub(t, 20*time.Millisecond)
+	alice := NewTestClient(t, hub)
+	authHeartbeat(alice, "alice")
+	alice.Subscribe("room:busy")
+	alice.Subscribe("room:quiet")
+	alice.Conn.rtt.pingSent(time.Unix(0, 0))
+	alice.Conn.rtt.pongReceived(time.Unix(0, 0).Add(34 * time.Millisecond))
+
+	// Two peers on room:busy, plus alice's own state, which is not counted
+	for _, user := range []string{"bob", "carol"} {
+		peer := NewTestClient(t, hub)
+		peer.Auth(user)
+		peer.Subscribe("room:busy")
+		peer.Send(protocol.TypeAwarenessUpdate, map[string]interface{}{"docId": "room:busy", "state": map[string]interface{}{"cursor": 1}})
+		peer.Sync()
+	}
+	alice.Send(protocol.TypeAwarenessUpdate, map[string]interface{}{"docId": "room:busy", "state": map[string]interface{}{"cursor": 2}})
+	alice.Sync()
+
+	// Earlier heartbeats may predate the peers
+	msg := expectHeartbeat(alice, func(payload map[string]interface{}) bool {
+		peers, _ := payload["peers"].(map[string]interface{})
+		return peers["room:busy"] == float64(2)
+	})
+	peers, _ := msg.Payload["peers"].(map[string]interface{})
+	if len(peers) != 2 || peers["room:busy"] != float64(2) || peers["room:quiet"] != float64(0) {
+		t.Errorf("peers = %v, want 2 on room:busy and 0 on room:quiet", msg.Payload["peers"])
+	}
+	if msg.Payload["rttMs"] != float64(34) {
+		t.Errorf("rttMs = %v, want 34", msg.Payload["rttMs"])
+	}
+	if serverTime, _ := msg.Payload["serverTime"].(float64); time.Since(time.UnixMilli(int64(serverTime))).Abs() > time.Minute {
+		t.Errorf("serverTime = %v, want about now", msg.Payload["serverTime"])
+	}
+	if msg.Payload["maintenance"] != false {
+		t.Errorf("maintenance = %v, want false", msg.Payload["maintenance"])
+	}
+
+	hub.SetMaintenance(Maintenance{Enabled: true})
+	msg = expectHeartbeat(alice, func(payload map[string]interface{}) bool { return payload["maintenance"] == true })
+	if msg.Payload["maintenance"] != true {
+		t.Errorf("heartbeat in maintenance mode = %v", msg.Payload)
+	}
+}
+
+func TestTransport_HeartbeatsNeedIntervalAndCapability(t *testing.T) {
+	// Disabled on the server
+	off := NewTestClient(t, newHeartbeatHub(t, 0))
+	authHeartbeat(off, "alice")
+	off.ExpectNone(protocol.TypeHeartbeat, 100*time.Millisecond)
+
+	// Not asked for by the client
+	plain := NewTestClient(t, newHeartbeatHub(t, 10*time.Millisecond))
+	plain.Auth("bob")
+	plain.ExpectNone(protocol.TypeHeartbeat, 100*time.Millisecond)
+}
@@ -0,0 +1,153 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+// Resume buffer defaults
+const (
+	DefaultResumeBufferSize = 256
+	DefaultResumeRetention  = 5 * time.Minute
+)
+
+// historyEntry is a single broadcast delta remembered for resume
+type historyEntry struct {
+	seq   int64
+	delta map[string]interface{}
+	at    time.Time
+}
+
+// deltaHistory assigns per-document sequence numbers and keeps a bounded ring
+// of recent broadcasts so reconnecting clients can replay what they missed
+// instead of downloading the full document.
+//
+// Not safe for concurrent use; the hub guards it with docsMu.
+type deltaHistory struct {
+	seq       int64          // Last sequence number assigned
+	size      int            // Capacity of the ring
+	entries   []historyEntry // Nil once released, until the next append
+	start     int            // Index of the oldest entry
+	count     int
+	retention time.Duration
+	turn      *fanoutTurn
+}
+
+func newDeltaHistory(size int, retention time.Duration) *deltaHistory {
+	if size <= 0 {
+		size = DefaultResumeBufferSize
+	}
+	return &deltaHistory{
+		size:      size,
+		entries:   make([]historyEntry, size),
+		retention: retention,
+		turn:      newFanoutTurn(),
+	}
+}
+
+// append records a delta and returns its sequence number, evicting the oldest
+// entry when the ring is full
+func (dh *deltaHistory) append(delta map[string]interface{}, now time.Time) int64 {
+	dh.seq++
+	entry := historyEntry{seq: dh.seq, delta: delta, at: now}
+	if dh.entries == nil {
+		dh.entries = make([]historyEntry, dh.size)
+	}
+
+	if dh.count < len(dh.entries) {
+		dh.entries[(dh.start+dh.count)%len(dh.entries)] = entry
+		dh.count++
+	} else {
+		dh.entries[dh.start] = entry
+		dh.start = (dh.start + 1) % len(dh.entries)
+	}
+	return dh.seq
+}
+
+// clear drops the buffered deltas but keeps the sequence number, so resuming
+// from any earlier point needs a full sync
+func (dh *deltaHistory) clear() {
+	dh.start, dh.count = 0, 0
+	for i := range dh.entries {
+		dh.entries[i] = historyEntry{}
+	}
+}
+
+// release is clear that also frees the ring, for documents evicted from
+// memory. The sequence number is kept, so numbering carries on when the
+// document is loaded again.
+func (dh *deltaHistory) release() {
+	dh.start, dh.count = 0, 0
+	dh.entries = nil
+}
+
+// since returns the deltas after sequence number from, oldest first. ok is
+// false when the buffer no longer covers the gap (evicted or expired entries)
+// or when from is ahead of the document.
+func (dh *deltaHistory) since(from int64, now time.Time) (deltas []map[string]interface{}, ok bool) {
+	if from > dh.seq || from < 0 {
+		return nil, false
+	}
+	if from == dh.seq {
+		return []map[string]interface{}{}, true
+	}
+
+	// Find the oldest entry still inside the retention window
+	first := 0
+	for first < dh.count {
+		e := dh.entries[(dh.start+first)%len(dh.entries)]
+		if dh.retention <= 0 || now.Sub(e.at) <= dh.retention {
+			break
+		}
+		first++
+	}
+	if first == dh.count {
+		return nil, false
+	}
+
+	oldest := dh.entries[(dh.start+first)%len(dh.entries)].seq
+	if from+1 < oldest {
+		return nil, false
+	}
+
+	deltas = make([]map[string]interface{}, 0, dh.seq-from)
+	for i := first; i < dh.count; i++ {
+		e := dh.entries[(dh.start+i)%len(dh.entries)]
+		if e.seq > from {
+			deltas = append(deltas, e.delta)
+		}
+	}
+	return deltas, true
+}
+
+// fanoutTurn makes broadcasts for one document go out in sequence order.
+// Sequence numbers are assigned under docsMu, but fan-out happens after the
+// lock is released, so a handler waits for its turn before enqueueing.
+type fanoutTurn struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	next int64 // Next sequence number allowed to broadcast
+}
+
+func newFanoutTurn() *fanoutTurn {
+	ft := &fanoutTurn{next: 1}
+	ft.cond = sync.NewCond(&ft.mu)
+	return ft
+}
+
+// wait blocks until every sequence number before seq has been broadcast
+func (ft *fanoutTurn) wait(seq int64) {
+	ft.mu.Lock()
+	for ft.next != seq {
+		ft.cond.Wait()
+	}
+	ft.mu.Unlock()
+}
+
+// done hands the turn to the handler holding sequence number next
+func (ft *fanoutTurn) done(next int64) {
+	ft.mu.Lock()
+	ft.next = next
+	ft.mu.Unlock()
+	ft.cond.Broadcast()
+}
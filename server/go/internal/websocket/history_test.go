@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func deltaWithSeq(seq int64) map[string]interface{} {
+	return map[string]interface{}{"seq": seq}
+}
+
+func TestDeltaHistory_AssignsSequentialNumbers(t *testing.T) {
+	dh := newDeltaHistory(4, time.Minute)
+	now := time.Now()
+
+	for want := int64(1); want <= 3; want++ {
+		if got := dh.append(deltaWithSeq(want), now); got != want {
+			t.Errorf("append seq = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestDeltaHistory_SinceReturnsMissedDeltas(t *testing.T) {
+	dh := newDeltaHistory(4, time.Minute)
+	now := time.Now()
+	for i := int64(1); i <= 3; i++ {
+		dh.append(deltaWithSeq(i), now)
+	}
+
+	deltas, ok := dh.since(1, now)
+	if !ok {
+		t.Fatal("Expected resume from 1 to succeed")
+	}
+	if len(deltas) != 2 || deltas[0]["seq"] != int64(2) || deltas[1]["seq"] != int64(3) {
+		t.Errorf("since(1) = %v, want seq 2 and 3", deltas)
+	}
+
+	deltas, ok = dh.since(3, now)
+	if !ok || len(deltas) != 0 {
+		t.Errorf("since(current) = %v, %v; want empty, true", deltas, ok)
+	}
+}
+
+func TestDeltaHistory_EvictsOldestWhenFull(t *testing.T) {
+	dh := newDeltaHistory(3, time.Minute)
+	now := time.Now()
+	for i := int64(1); i <= 5; i++ {
+		dh.append(deltaWithSeq(i), now)
+	}
+
+	// Entries 1 and 2 were evicted, so resuming from 0 or 1 leaves a gap
+	if _, ok := dh.since(1, now); ok {
+		t.Error("Expected resume from evicted sequence to fail")
+	}
+
+	deltas, ok := dh.since(2, now)
+	if !ok {
+		t.Fatal("Expected resume from 2 to succeed")
+	}
+	if len(deltas) != 3 || deltas[0]["seq"] != int64(3) || deltas[2]["seq"] != int64(5) {
+		t.Errorf("since(2) = %v, want seq 3..5", deltas)
+	}
+}
+
+func TestDeltaHistory_RetentionExpiresEntries(t *testing.T) {
+	dh := newDeltaHistory(10, time.Minute)
+	start := time.Now()
+	dh.append(deltaWithSeq(1), start)
+	dh.append(deltaWithSeq(2), start.Add(2*time.Minute))
+
+	later := start.Add(2*time.Minute + time.Second)
+	if _, ok := dh.since(0, later); ok {
+		t.Error("Expected resume across an expired entry to fail")
+	}
+	if deltas, ok := dh.since(1, later); !ok || len(deltas) != 1 {
+		t.Errorf("since(1) = %v, %v; want one delta", deltas, ok)
+	}
+}
+
+func TestDeltaHistory_ResumeAheadOfServerFails(t *testing.T) {
+	dh := newDeltaHistory(4, time.Minute)
+	dh.append(deltaWithSeq(1), time.Now())
+
+	if _, ok := dh.since(5, time.Now()); ok {
+		t.Error("Expected resume from a future sequence to fail")
+	}
+}
@@ -0,0 +1,153 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// Hooks let an application embedding the hub take part in handling
+// connections. Each runs on the goroutine handling the message, so it
+// should return quickly and must not wait on the hub. A nil hook allows
+// everything; a hook returning an error denies the action, with the code
+// and message of a *HookError when it is one.
+type Hooks struct {
+	// OnAuthenticate runs once a connection's credentials are accepted,
+	// before auth_success. Denying sends auth_error and leaves the
+	// connection unauthenticated.
+	OnAuthenticate func(ctx context.Context, peer Peer) error
+
+	// OnSubscribe runs after the permission checks for a subscribe.
+	// Denying answers with an error.
+	OnSubscribe func(ctx context.Context, peer Peer, docID string) error
+
+	// OnDelta runs after the permission checks for each delta, including
+	// those in a delta_batch. It may change delta.Changes in place or
+	// replace it; denying rejects the delta in its ACK with reason "hook".
+	OnDelta func(ctx context.Context, peer Peer, delta *Delta) error
+}
+
+// Peer describes the connection a hook runs for
+type Peer struct {
+	ConnID      string
+	UserID      string
+	ClientID    string // Empty in OnAuthenticate when the client sent none
+	ClientIP    string
+	Anonymous   bool // Authenticated without a token or API key
+	Permissions auth.DocumentPermissions
+	TenantID    string // Tenant the token is confined to, if any
+}
+
+// Delta is a client's delta as OnDelta sees it
+type Delta struct {
+	DocID   string
+	Changes map[string]interface{} // Field -> value; nil deletes the field
+}
+
+// HookError is a hook's refusal as clients are told it
+type HookError struct {
+	Code    string
+	Message string
+}
+
+func (e *HookError) Error() string {
+	return e.Message + " (" + e.Code + ")"
+}
+
+// hookRefusal is the code and message a client is sent for a hook's error.
+// Errors other than *HookError are logged but not shown to the client.
+func hookRefusal(conn *Connection, hook string, err error) (code, message string) {
+	var hookErr *HookError
+	if errors.As(err, &hookErr) {
+		return hookErr.Code, hookErr.Message
+	}
+	conn.Logger().Info("Denied by hook", "hook", hook, "err", err)
+	return "DENIED", "Denied by server policy"
+}
+
+// peer describes conn for a hook
+func (conn *Connection) peer() Peer {
+	p := Peer{
+		ConnID:    conn.ID,
+		UserID:    conn.UserID,
+		ClientID:  conn.ClientID,
+		ClientIP:  conn.ClientIP,
+		Anonymous: conn.VerifiedUserID() == "",
+		TenantID:  conn.Tenant(),
+	}
+	if conn.TokenPayload != nil {
+		p.Permissions = conn.TokenPayload.Permissions
+	}
+	return p
+}
+
+// hookAuthenticate runs OnAuthenticate for a connection whose credentials
+// were accepted. On denial it sends auth_error answering msgID, undoes the
+// authentication and returns false.
+func (h *Hub) hookAuthenticate(ctx context.Context, conn *Connection, clientID, msgID string) bool {
+	if h.opts.Hooks.OnAuthenticate == nil {
+		return true
+	}
+	peer := conn.peer()
+	peer.ClientID = clientID
+	err := h.opts.Hooks.OnAuthenticate(ctx, peer)
+	if err == nil {
+		return true
+	}
+
+	code, message := hookRefusal(conn, "OnAuthenticate", err)
+	conn.Authenticated = false
+	conn.UserID = ""
+	conn.TokenPayload = nil
+	conn.verifiedUser.Store("")
+	conn.tenant.Store("")
+	conn.anonymousRead.Store(false)
+	conn.stopTokenExpiry()
+	h.metrics.authFailures.Add(1)
+	h.audit(conn, audit.EventAuthFailure, "", map[string]interface{}{"code": code})
+	conn.Logger().Warn("Authentication failed", "code", code)
+	conn.Send(protocol.NewMessage(protocol.TypeAuthError, map[string]interface{}{
+		"error": message,
+		"code":  code,
+	}).WithOrigin(msgID))
+	return false
+}
+
+// hookSubscribe runs OnSubscribe, answering with an error and returning
+// false on denial
+func (h *Hub) hookSubscribe(ctx context.Context, conn *Connection, docID string) bool {
+	if h.opts.Hooks.OnSubscribe == nil {
+		return true
+	}
+	if err := h.opts.Hooks.OnSubscribe(ctx, conn.peer(), docID); err != nil {
+		code, message := hookRefusal(conn, "OnSubscribe", err)
+		conn.Logger().Warn("Subscribe denied", "doc_id", docID, "code", code)
+		conn.replyError(message, code)
+		return false
+	}
+	return true
+}
+
+// hookDelta runs OnDelta on a delta about to be applied, updating its
+// changes with the hook's. It returns the rejection when the hook denies
+// the delta or leaves it unusable, nil otherwise. Must be called without
+// docsMu held.
+func (h *Hub) hookDelta(ctx context.Context, conn *Connection, docID string, delta map[string]interface{}) *deltaResult {
+	if h.opts.Hooks.OnDelta == nil {
+		return nil
+	}
+	changes, _ := delta["changes"].(map[string]interface{})
+	d := &Delta{DocID: docID, Changes: changes}
+	if err := h.opts.Hooks.OnDelta(ctx, conn.peer(), d); err != nil {
+		code, _ := hookRefusal(conn, "OnDelta", err)
+		return &deltaResult{reason: RejectHook, code: code}
+	}
+	if d.Changes == nil {
+		return &deltaResult{reason: RejectHook, code: "DENIED"}
+	}
+	delta["changes"] = d.Changes
+	return nil
+}
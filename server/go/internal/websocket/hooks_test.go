@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+func TestHub_DeltaHookRunsForEachDeltaInABatch(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{Hooks: Hooks{
+		OnDelta: func(ctx context.Context, peer Peer, delta *Delta) error {
+			if peer.UserID != "alice" || delta.DocID != "room:hooked" {
+				t.Errorf("OnDelta(%+v, %+v)", peer, delta)
+			}
+			if _, ok := delta.Changes["secret"]; ok {
+				return &HookError{Code: "NO_SECRETS", Message: "No secrets"}
+			}
+			delta.Changes["checked"] = true
+			return nil
+		},
+	}})
+	writer := authAs(t, hub, "writer", "alice", "client-a")
+	reader := joinDirect(t, hub, "reader", "room:hooked")
+
+	handleDirect(hub, writer, protocol.TypeDeltaBatch, map[string]interface{}{
+		"docId": "room:hooked",
+		"deltas": []interface{}{
+			map[string]interface{}{"changes": map[string]interface{}{"title": "a"}},
+			map[string]interface{}{"changes": map[string]interface{}{"secret": "s"}},
+		},
+	})
+	ack := expectMessage(t, writer, protocol.TypeAck)
+	results, _ := ack.Payload["results"].([]interface{})
+	if len(results) != 2 {
+		t.Fatalf("results = %v, want 2", ack.Payload["results"])
+	}
+	if r := results[0].(map[string]interface{}); r["status"] != "applied" {
+		t.Errorf("result 0 = %v, want applied", r)
+	}
+	if r := results[1].(map[string]interface{}); r["status"] != "rejected" || r["reason"] != RejectHook || r["code"] != "NO_SECRETS" {
+		t.Errorf("result 1 = %v, want rejected by the hook", r)
+	}
+
+	// Subscribers get the hook's changes
+	delta := expectMessage(t, reader, protocol.TypeDelta)
+	if changes, _ := delta.Payload["changes"].(map[string]interface{}); changes["checked"] != true || changes["title"] != "a" {
+		t.Errorf("broadcast changes = %v, want the hook's", changes)
+	}
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	if _, has := hub.documents["room:hooked"]["secret"]; has {
+		t.Error("denied change applied")
+	}
+}
+
+func TestHub_AuthenticateHookDenial(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{Hooks: Hooks{
+		OnAuthenticate: func(ctx context.Context, peer Peer) error {
+			if peer.ClientID != "client-b" || !peer.Anonymous {
+				t.Errorf("OnAuthenticate(%+v)", peer)
+			}
+			return &HookError{Code: "BANNED_CLIENT", Message: "Banned"}
+		},
+	}})
+	conn := newTestConnection(hub, "conn")
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "bob", "clientId": "client-b"})
+
+	if msg := expectMessage(t, conn, protocol.TypeAuthError); msg.Payload["code"] != "BANNED_CLIENT" {
+		t.Errorf("auth_error = %v, want BANNED_CLIENT", msg.Payload)
+	}
+	if conn.Authenticated || conn.TokenPayload != nil || conn.ClientID != "" {
+		t.Errorf("connection authenticated as %q/%q after denial", conn.UserID, conn.ClientID)
+	}
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:1"})
+	expectError(t, conn, "NOT_AUTHENTICATED")
+}
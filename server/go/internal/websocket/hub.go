@@ -1,15 +1,24 @@
 package websocket
 
 import (
-	"crypto/rand"
-	"encoding/hex"
-	"os"
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Dancode-188/synckit/server/go/internal/acl"
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
 	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/metrics"
 	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/schema"
 	"github.com/Dancode-188/synckit/server/go/internal/security"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/gorilla/websocket"
 )
 
 // AwarenessTimeout is the time after which stale awareness entries are cleaned up
@@ -18,10 +27,213 @@ const AwarenessTimeout = 30 * time.Second
 // AwarenessCleanupInterval is how often the cleanup runs
 const AwarenessCleanupInterval = 30 * time.Second
 
+// HubOptions holds optional hub behaviour
+type HubOptions struct {
+	// KickDuplicateClients closes an older connection when a new one
+	// authenticates with the same user and client ID
+	KickDuplicateClients bool
+
+	// ResumeBufferSize is how many recent broadcasts are kept per document
+	// for resuming clients (default DefaultResumeBufferSize)
+	ResumeBufferSize int
+
+	// ResumeRetention is how long buffered broadcasts stay replayable
+	// (default DefaultResumeRetention)
+	ResumeRetention time.Duration
+
+	// ShutdownReconnectDelay is the reconnect delay suggested to clients in
+	// the server_shutdown notice (default DefaultShutdownReconnectDelay)
+	ShutdownReconnectDelay time.Duration
+
+	// Flush persists dirty documents during Stop, after queued messages have
+	// been applied and before connections close. Nil when storage is not
+	// configured.
+	Flush func(ctx context.Context) error
+
+	// Workers is how many goroutines handle document messages in parallel
+	// (default runtime.GOMAXPROCS)
+	Workers int
+
+	// SyncRequiredThreshold is how many rejected or dropped deltas for a
+	// document trigger a sync_required notice (default
+	// DefaultSyncRequiredThreshold)
+	SyncRequiredThreshold int
+
+	// Persist saves a document's state after deltas apply. Writes are
+	// queued per document off the handling path. Nil keeps documents in
+	// memory only.
+	Persist PersistFunc
+
+	// DurableAcks holds each ACK until the write covering its delta has
+	// finished. Has no effect without Persist.
+	DurableAcks bool
+
+	// StorageHealth probes storage every HealthCheckInterval (default
+	// DefaultHealthCheckInterval). While a probe fails, or after a write
+	// fails every attempt, the hub is degraded: it keeps serving from
+	// memory and holds document writes, up to WriteBufferSize documents
+	// (default DefaultWriteBufferSize), until a probe succeeds. Documents
+	// past the buffer are saved in full once storage is back. Nil reports
+	// failed writes instead. Has no effect without Persist.
+	StorageHealth       HealthFunc
+	HealthCheckInterval time.Duration
+	WriteBufferSize     int
+
+	// Load reads a document's stored state the first time the hub uses it,
+	// so documents outlive restarts. Nil starts every document empty.
+	Load LoadFunc
+
+	// MessageTimeout bounds the handling of each message, storage calls
+	// included (default DefaultMessageTimeout)
+	MessageTimeout time.Duration
+
+	// Relay shares applied deltas and awareness updates with other servers
+	// through per-document channels. Nil keeps them on this server.
+	Relay Relay
+
+	// ServerID tags this hub's relayed messages so it ignores its own
+	// (default a random ID)
+	ServerID string
+
+	// SharedAwareness keeps awareness states where every server can read
+	// them, so awareness_subscribe sees clients on other servers too. Nil
+	// keeps each server's states to itself.
+	SharedAwareness AwarenessStore
+
+	// FlushWatermarks keeps how far each client's flush of offline deltas
+	// got, so a re-flush after a restart or to another server resumes
+	// there. Nil keeps watermarks in memory only.
+	FlushWatermarks storage.FlushWatermarkStorage
+
+	// MaxDocuments and MaxDocumentBytes bound the documents kept in memory,
+	// by count and approximate size. Past either, the least recently used
+	// documents nobody is subscribed to are evicted once their changes have
+	// been written and they have gone DocumentIdleTime without one; the next
+	// use loads them again. 0 leaves that bound off. Has no effect without
+	// Persist and Load.
+	MaxDocuments     int
+	MaxDocumentBytes int64
+
+	// DocumentIdleTime is how long a document must go without changes
+	// before it may be evicted (default DefaultDocumentIdleTime)
+	DocumentIdleTime time.Duration
+
+	// HeartbeatInterval is how often clients that negotiated the heartbeat
+	// capability are sent a heartbeat with the server's status. It is
+	// checked on the ping ticker, so intervals over the ping period are
+	// rounded to a multiple of it. 0 sends none.
+	HeartbeatInterval time.Duration
+
+	// ChecksumInterval is how often the hub publishes checksums of relayed
+	// documents so servers holding diverged copies reload them (default
+	// DefaultChecksumInterval). Has no effect without Relay.
+	ChecksumInterval time.Duration
+
+	// Limits caps subscriptions and awareness traffic. Unset limits use
+	// security.DefaultLimits.
+	Limits security.Limits
+
+	// PublicDocuments decides which documents clients may access at all
+	// (nil uses security.DefaultPublicDocumentPolicy)
+	PublicDocuments *security.PublicDocumentPolicy
+
+	// Audit records authentication failures, permission denials and limit
+	// hits (nil discards them)
+	Audit audit.AuditLogger
+
+	// AuthTimeout is how long a connection may stay unauthenticated before
+	// it is closed with AUTH_TIMEOUT (default DefaultAuthTimeout)
+	AuthTimeout time.Duration
+
+	// Verifier checks the tokens clients authenticate with (nil verifies
+	// HS256 tokens with the hub's JWT secret)
+	Verifier auth.TokenVerifier
+
+	// APIKeys authenticates clients sending an apiKey instead of a token
+	// (nil refuses API keys)
+	APIKeys *auth.APIKeys
+
+	// ACL grants access to documents beyond the token's permissions (nil
+	// checks token permissions only)
+	ACL *acl.Evaluator
+
+	// Schemas validates deltas to the documents whose IDs match one of its
+	// prefixes, refusing those that break the schema (nil checks nothing)
+	Schemas *schema.Registry
+
+	// CompressionThreshold is the payload size in bytes above which
+	// messages to clients that asked for compression in their auth message
+	// are deflate-compressed (0 disables compression)
+	CompressionThreshold int
+
+	// StrictBatches refuses a whole batch envelope when any message in it
+	// does not decode, instead of handling the rest
+	StrictBatches bool
+
+	// OpenDocumentCreation lets verified users create documents their token
+	// does not cover, becoming their owner (needs ACL)
+	OpenDocumentCreation bool
+
+	// MultiTenancy refuses connections whose token has no tenantId claim,
+	// unless it is an admin's. Tokens with a tenant reach only that
+	// tenant's documents whether or not it is set.
+	MultiTenancy bool
+
+	// TokenExpiryWarning is how long before its token expires a connection
+	// is sent token_expiring, asking it to authenticate again (default
+	// DefaultTokenExpiryWarning)
+	TokenExpiryWarning time.Duration
+
+	// TokenExpiryGrace is how long after its token expires a connection is
+	// closed with TOKEN_EXPIRED (default DefaultTokenExpiryGrace)
+	TokenExpiryGrace time.Duration
+
+	// Revocations is checked when a client authenticates, refusing revoked
+	// tokens with TOKEN_REVOKED (nil skips the check)
+	Revocations auth.RevocationStore
+
+	// CheckRevocationOnWrite checks Revocations again before every delta and
+	// batch, closing connections whose token has since been revoked
+	CheckRevocationOnWrite bool
+
+	// Maintenance is the maintenance mode the hub starts in (see
+	// Hub.SetMaintenance)
+	Maintenance Maintenance
+
+	// Metrics receives the hub's instrumentation (nil keeps it private to
+	// the hub, readable through Hub.Metrics)
+	Metrics *metrics.Registry
+
+	// Logger is the default logger for connections (nil uses slog.Default)
+	Logger *slog.Logger
+
+	// Hooks are an embedding application's say over authentication,
+	// subscriptions and deltas (see Hooks)
+	Hooks Hooks
+}
+
+// AuthConfig is how a hub authenticates connections
+type AuthConfig struct {
+	// JWTSecret verifies tokens sent in auth messages
+	JWTSecret string
+
+	// Required refuses connections that authenticate without a token;
+	// otherwise they are let in with full access
+	Required bool
+
+	// AnonymousRead accepts connections without a token when Required is
+	// set, as read-only readers of public documents
+	AnonymousRead bool
+}
+
+// DefaultShutdownReconnectDelay is the reconnect delay suggested on shutdown
+const DefaultShutdownReconnectDelay = 5 * time.Second
+
 // Hub maintains active connections and broadcasts messages
 type Hub struct {
 	// Configuration
-	jwtSecret string
+	authConfig AuthConfig
+	opts       HubOptions
 
 	// Registered connections
 	connections map[string]*Connection
@@ -30,9 +242,20 @@ type Hub struct {
 	// Document subscribers
 	subscribers map[string]map[string]bool // docId -> connectionId -> true
 
+	// Prefix subscribers receive deltas for every document under the prefix
+	prefixSubscribers map[string]map[string]bool // prefix -> connectionId -> true
+
+	// List subscribers are told when documents under the prefix come and go
+	listSubscribers map[string]map[string]bool // prefix -> connectionId -> true
+
 	// Document storage (in-memory)
 	documents map[string]map[string]interface{}
+	history   map[string]*deltaHistory // docId -> recent broadcasts, guarded by docsMu
+	meta      map[string]*documentMeta // docId -> clock and LWW state, guarded by docsMu
+	loaded    map[string]bool          // docId -> HubOptions.Load consulted, guarded by docsMu
+	versions  map[string]relayVersions // docId -> servers' relayed sequence numbers, guarded by docsMu
 	docsMu    sync.RWMutex
+	cache     *documentCache // Use order and size of documents
 
 	// Awareness states with timestamps
 	awareness map[string]map[string]interface{} // docId -> clientId -> state
@@ -41,39 +264,171 @@ type Hub struct {
 	// Cleanup ticker for stale awareness
 	cleanupTicker *time.Ticker
 	stopChan      chan struct{}
+	stopOnce      sync.Once
+	stopping      bool // Guarded by mu; set once Stop begins
+
+	// Parent of every message's context; cancelled when Stop gives up on
+	// queued messages, and once it returns
+	handlingCtx    context.Context
+	cancelHandling context.CancelFunc
+
+	// Document channels subscribed on HubOptions.Relay
+	relaySubs relaySubscriptions
+
+	// Maintenance mode, read by every write
+	maintenance Maintenance
+	maintMu     sync.RWMutex
 
 	// Channels
 	Register      chan *Connection
 	Unregister    chan *Connection
 	HandleMessage chan *MessageEvent
+
+	// Functions run on the Run goroutine on behalf of other goroutines
+	calls chan func()
+
+	// Document message workers, started by Run
+	workers   []chan *MessageEvent
+	inflight  sync.WaitGroup // Messages handed to workers and not yet handled
+	preparing int            // Messages prepared off the Run goroutine; touched only by Run
+
+	metrics *hubMetrics
+
+	writer *Writer // Nil when HubOptions.Persist is not set
+
+	flushes flushMarks
+
+	events eventBus // Subscribers of Subscribe
 }
 
 // MessageEvent represents a message from a connection
 type MessageEvent struct {
 	Connection *Connection
 	Message    *protocol.Message
+
+	// Context bounds the handling of the message, storage calls included
+	// (nil for no bound)
+	Context context.Context
+	cancel  context.CancelFunc
+
+	run func() // Hub work queued on a document's worker instead of a message
+
+	prepared *prepared // Set once the lookups prepareOffRun ran are done
+}
+
+// NewHub creates a new Hub with default options
+func NewHub(authConfig AuthConfig) *Hub {
+	return NewHubWithOptions(authConfig, HubOptions{})
 }
 
-// NewHub creates a new Hub
-func NewHub(jwtSecret string) *Hub {
-	return &Hub{
-		jwtSecret:     jwtSecret,
-		connections:   make(map[string]*Connection),
-		subscribers:   make(map[string]map[string]bool),
-		documents:     make(map[string]map[string]interface{}),
-		awareness:     make(map[string]map[string]interface{}),
-		stopChan:      make(chan struct{}),
-		Register:      make(chan *Connection),
-		Unregister:    make(chan *Connection),
-		HandleMessage: make(chan *MessageEvent, 256),
+// NewHubWithOptions creates a new Hub
+func NewHubWithOptions(authConfig AuthConfig, opts HubOptions) *Hub {
+	if opts.ResumeBufferSize <= 0 {
+		opts.ResumeBufferSize = DefaultResumeBufferSize
+	}
+	if opts.ResumeRetention <= 0 {
+		opts.ResumeRetention = DefaultResumeRetention
+	}
+	if opts.ShutdownReconnectDelay <= 0 {
+		opts.ShutdownReconnectDelay = DefaultShutdownReconnectDelay
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.GOMAXPROCS(0)
+	}
+	if opts.SyncRequiredThreshold <= 0 {
+		opts.SyncRequiredThreshold = DefaultSyncRequiredThreshold
+	}
+	opts.Limits = opts.Limits.WithDefaults()
+	if opts.PublicDocuments == nil {
+		opts.PublicDocuments = security.DefaultPublicDocumentPolicy()
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Nop{}
 	}
+	if opts.AuthTimeout <= 0 {
+		opts.AuthTimeout = DefaultAuthTimeout
+	}
+	if opts.TokenExpiryWarning <= 0 {
+		opts.TokenExpiryWarning = DefaultTokenExpiryWarning
+	}
+	if opts.TokenExpiryGrace <= 0 {
+		opts.TokenExpiryGrace = DefaultTokenExpiryGrace
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.NewRegistry()
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.MessageTimeout <= 0 {
+		opts.MessageTimeout = DefaultMessageTimeout
+	}
+	if opts.ServerID == "" {
+		opts.ServerID = protocol.NewID()
+	}
+	if opts.ChecksumInterval <= 0 {
+		opts.ChecksumInterval = DefaultChecksumInterval
+	}
+	if opts.DocumentIdleTime <= 0 {
+		opts.DocumentIdleTime = DefaultDocumentIdleTime
+	}
+	if opts.HealthCheckInterval <= 0 {
+		opts.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if opts.WriteBufferSize <= 0 {
+		opts.WriteBufferSize = DefaultWriteBufferSize
+	}
+
+	h := &Hub{
+		authConfig:        authConfig,
+		opts:              opts,
+		connections:       make(map[string]*Connection),
+		subscribers:       make(map[string]map[string]bool),
+		prefixSubscribers: make(map[string]map[string]bool),
+		listSubscribers:   make(map[string]map[string]bool),
+		documents:         make(map[string]map[string]interface{}),
+		history:           make(map[string]*deltaHistory),
+		meta:              make(map[string]*documentMeta),
+		loaded:            make(map[string]bool),
+		versions:          make(map[string]relayVersions),
+		awareness:         make(map[string]map[string]interface{}),
+		stopChan:          make(chan struct{}),
+		Register:          make(chan *Connection),
+		Unregister:        make(chan *Connection),
+		HandleMessage:     make(chan *MessageEvent, 256),
+		calls:             make(chan func()),
+		cache:             newDocumentCache(opts.MaxDocuments, opts.MaxDocumentBytes, opts.DocumentIdleTime),
+	}
+	h.relaySubs.docs = make(map[string]bool)
+	if opts.Maintenance.Enabled {
+		h.maintenance = opts.Maintenance
+		h.maintenance.Since = time.Now()
+	}
+	h.handlingCtx, h.cancelHandling = context.WithCancel(context.Background())
+	h.metrics = newHubMetrics(h, opts.Metrics)
+	if opts.Persist != nil {
+		h.writer = NewWriter(opts.Persist, h.writerOptions())
+	}
+	return h
 }
 
-// Run starts the hub
+// Run starts the hub. Connection lifecycle and message routing happen on
+// the Run goroutine; document messages are handled by the worker pool.
 func (h *Hub) Run() {
 	// Start periodic awareness cleanup
 	h.cleanupTicker = time.NewTicker(AwarenessCleanupInterval)
 	go h.runAwarenessCleanup()
+	if h.opts.Relay != nil {
+		go h.runChecksums()
+	}
+	if h.evicts() {
+		go h.runEviction()
+	}
+	if h.degrades() {
+		go h.runHealthChecks()
+	}
+
+	h.startWorkers()
 
 	for {
 		select {
@@ -81,52 +436,250 @@ func (h *Hub) Run() {
 			if h.cleanupTicker != nil {
 				h.cleanupTicker.Stop()
 			}
+			h.stopWorkers()
 			return
 
 		case conn := <-h.Register:
-			h.mu.Lock()
-			h.connections[conn.ID] = conn
-			h.mu.Unlock()
+			h.register(conn)
 
 		case conn := <-h.Unregister:
-			h.mu.Lock()
-			if _, ok := h.connections[conn.ID]; ok {
-				// Remove from subscribers
-				for docID := range conn.Subscriptions {
-					if subs, exists := h.subscribers[docID]; exists {
-						delete(subs, conn.ID)
-						if len(subs) == 0 {
-							delete(h.subscribers, docID)
-						}
-					}
-				}
+			h.unregister(conn)
 
-				// Clean up awareness
-				h.awareMu.Lock()
-				for docID := range conn.AwarenessSubscriptions {
-					if states, exists := h.awareness[docID]; exists {
-						delete(states, conn.ClientID)
-						if len(states) == 0 {
-							delete(h.awareness, docID)
-						}
-					}
-				}
-				h.awareMu.Unlock()
+		case event := <-h.HandleMessage:
+			h.route(event)
+
+		case fn := <-h.calls:
+			fn()
+		}
+	}
+}
+
+// register adds a connection to the hub. Connections arriving while the hub
+// is stopping are closed immediately instead. Connections that arrive already
+// authenticated, like event streams, get no auth deadline but are closed when
+// their token expires.
+func (h *Hub) register(conn *Connection) {
+	h.mu.Lock()
+	if h.stopping {
+		h.mu.Unlock()
+		conn.Close()
+		return
+	}
+	h.connections[conn.ID] = conn
+	h.mu.Unlock()
+	if !conn.authDeadline.done.Load() {
+		h.startAuthDeadline(conn)
+	} else {
+		h.armTokenExpiry(conn, conn.TokenPayload)
+	}
+	conn.Logger().Debug("Connection registered")
+}
+
+// unregister removes a connection along with its subscriptions and awareness
+// state, then closes it
+func (h *Hub) unregister(conn *Connection) {
+	conn.handleMu.Lock()
+	defer conn.handleMu.Unlock()
+
+	h.removeConnection(conn)
+}
 
-				delete(h.connections, conn.ID)
-				close(conn.send)
+// removeConnection is unregister for callers already holding conn.handleMu
+func (h *Hub) removeConnection(conn *Connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.connections[conn.ID]; !ok {
+		return
+	}
+
+	// Remove from subscribers
+	var emptied []string
+	for docID := range conn.Subscriptions {
+		if subs, exists := h.subscribers[docID]; exists {
+			if subs[conn.ID] {
+				h.publishSubscriber(EventSubscriberLeft, conn, docID)
 			}
-			h.mu.Unlock()
+			delete(subs, conn.ID)
+			if len(subs) == 0 {
+				delete(h.subscribers, docID)
+				emptied = append(emptied, docID)
+			}
+		}
+	}
+	if len(emptied) > 0 && h.opts.Relay != nil {
+		// Off the Run goroutine; syncRelay takes h.mu and talks to Redis
+		go h.syncRelay(emptied...)
+	}
 
-		case event := <-h.HandleMessage:
-			h.handleMessage(event.Connection, event.Message)
+	// Remove from prefix subscribers
+	for prefix := range conn.PrefixSubscriptions {
+		h.removePrefixSubscriber(prefix, conn.ID)
+	}
+
+	// Remove from list subscribers
+	for prefix := range conn.ListSubscriptions {
+		h.removeListSubscriber(prefix, conn.ID)
+	}
+
+	// Clean up awareness; the removal is announced off the Run goroutine,
+	// since broadcasting takes h.mu
+	var left []string
+	for docID := range conn.AwarenessSubscriptions {
+		if h.deleteAwareness(docID, conn.ClientID) {
+			left = append(left, docID)
 		}
 	}
+	if len(left) > 0 {
+		go func(clientID string) {
+			for _, docID := range left {
+				h.announceAwarenessRemoved(docID, clientID)
+			}
+		}(conn.ClientID)
+	}
+
+	conn.awarenessThrottle.stop()
+	conn.releaseAuthDeadline()
+	conn.stopTokenExpiry()
+
+	delete(h.connections, conn.ID)
+	conn.Close()
+	conn.Logger().Debug("Connection closed", "duration", time.Since(conn.ConnectedAt))
+}
+
+// Stop gracefully stops the hub.
+//
+// New registrations are refused and every connection is sent a
+// server_shutdown notice with a suggested reconnect delay. Messages already
+// queued in HandleMessage are applied, queued writes finish (sending any
+// durable ACKs), dirty documents are flushed if HubOptions.Flush is set, and
+// then every connection is closed with 1001 (going away). Run keeps draining
+// Register, Unregister and HandleMessage until all connections have
+// unregistered or ctx expires. Run exits once Stop returns. The error is
+// ctx's on timeout, otherwise the writer's or Flush's.
+func (h *Hub) Stop(ctx context.Context) error {
+	h.mu.Lock()
+	h.stopping = true
+	conns := make([]*Connection, 0, len(h.connections))
+	for _, conn := range h.connections {
+		conns = append(conns, conn)
+	}
+	h.mu.Unlock()
+
+	defer h.stopOnce.Do(func() {
+		close(h.stopChan)
+		h.events.close()
+	})
+	defer h.cancelHandling()
+
+	for _, conn := range conns {
+		conn.Send(protocol.NewMessage(protocol.TypeServerShutdown, map[string]interface{}{
+			"reconnectAfter": h.opts.ShutdownReconnectDelay.Milliseconds(),
+		}))
+	}
+
+	// Apply what clients already sent before saying goodbye. On a timeout
+	// messages still being handled are cancelled, connections are still
+	// closed and Stop reports ctx's error.
+	var flushErr error
+	if err := h.execContext(ctx, h.drainMessages); err == nil {
+		if h.writer != nil {
+			// One last attempt at writes held while storage was down
+			if h.Degraded() {
+				h.resave(h.writer.Resume())
+			}
+			flushErr = h.writer.Flush(ctx)
+		}
+		if flushErr == nil && h.opts.Flush != nil {
+			flushErr = h.opts.Flush(ctx)
+		}
+	} else {
+		h.cancelHandling()
+	}
+
+	for _, conn := range conns {
+		conn.CloseWithCode(websocket.CloseGoingAway, "Server shutting down")
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for h.ConnectionCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return flushErr
+}
+
+// Done returns a channel that is closed once the hub has stopped
+func (h *Hub) Done() <-chan struct{} {
+	return h.stopChan
+}
+
+// ConnectionCount returns the number of registered connections
+func (h *Hub) ConnectionCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.connections)
 }
 
-// Stop gracefully stops the hub
-func (h *Hub) Stop() {
-	close(h.stopChan)
+// exec runs fn on the Run goroutine, where connection state can be read and
+// changed without racing message handling, and waits for it to finish. It
+// returns false without running fn if the hub has stopped. Must not be called
+// from the Run goroutine.
+func (h *Hub) exec(fn func()) bool {
+	return h.execContext(context.Background(), fn) == nil
+}
+
+// execContext is exec bounded by ctx. It returns errHubStopped if the hub has
+// stopped, or ctx's error if fn did not finish in time.
+func (h *Hub) execContext(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	select {
+	case h.calls <- func() { fn(); close(done) }:
+	case <-h.stopChan:
+		return errHubStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drainMessages handles every message already queued in HandleMessage and
+// waits for the workers to finish them. Must be called on the Run goroutine.
+func (h *Hub) drainMessages() {
+	for {
+		select {
+		case event := <-h.HandleMessage:
+			h.route(event)
+			continue
+		default:
+		}
+		if h.preparing == 0 {
+			h.inflight.Wait()
+			return
+		}
+		// Messages being prepared come back through calls, unless Stop has
+		// given up on them
+		select {
+		case event := <-h.HandleMessage:
+			h.route(event)
+		case fn := <-h.calls:
+			fn()
+		case <-h.handlingCtx.Done():
+			h.inflight.Wait()
+			return
+		}
+	}
 }
 
 // runAwarenessCleanup periodically removes stale awareness entries
@@ -142,14 +695,18 @@ func (h *Hub) runAwarenessCleanup() {
 }
 
 // cleanupStaleAwareness removes awareness entries older than AwarenessTimeout
+// and tells local subscribers those clients left. With a shared store the
+// stale entries are expired there too; whichever server expires an entry
+// tells the others.
 func (h *Hub) cleanupStaleAwareness() {
-	now := time.Now().UnixMilli()
-	timeoutMs := AwarenessTimeout.Milliseconds()
+	now := time.Now()
+	cutoff := now.Add(-AwarenessTimeout)
+	removed := make(map[string][]string) // docId -> client IDs
 
 	h.awareMu.Lock()
-	defer h.awareMu.Unlock()
-
+	docIDs := make([]string, 0, len(h.awareness))
 	for docID, clients := range h.awareness {
+		docIDs = append(docIDs, docID)
 		for clientID, stateRaw := range clients {
 			state, ok := stateRaw.(map[string]interface{})
 			if !ok {
@@ -158,8 +715,9 @@ func (h *Hub) cleanupStaleAwareness() {
 
 			// Check lastUpdate timestamp
 			if lastUpdate, ok := state["lastUpdate"].(float64); ok {
-				if now-int64(lastUpdate) > timeoutMs {
+				if int64(lastUpdate) < cutoff.UnixMilli() {
 					delete(clients, clientID)
+					removed[docID] = append(removed[docID], clientID)
 				}
 			}
 		}
@@ -169,33 +727,142 @@ func (h *Hub) cleanupStaleAwareness() {
 			delete(h.awareness, docID)
 		}
 	}
+	h.awareMu.Unlock()
+
+	for docID, clientIDs := range removed {
+		for _, clientID := range clientIDs {
+			h.broadcastAwareness(docID, clientID, nil, nil, "", "")
+		}
+	}
+
+	store := h.opts.SharedAwareness
+	if store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(h.handlingCtx, AwarenessCleanupInterval)
+	defer cancel()
+	for _, docID := range docIDs {
+		expired, err := store.ExpireAwareness(ctx, docID, cutoff)
+		if err != nil {
+			h.opts.Logger.Warn("Expiring shared awareness failed", "doc_id", docID, "err", err)
+			continue
+		}
+		for _, clientID := range expired {
+			if h.deleteAwareness(docID, clientID) {
+				h.broadcastAwareness(docID, clientID, nil, nil, "", "")
+			}
+			h.relayAwarenessRemoved(docID, clientID)
+		}
+	}
 }
 
-func (h *Hub) handleMessage(conn *Connection, msg *protocol.Message) {
+// handleMessage handles one message from conn. ctx bounds storage calls made
+// on its behalf.
+func (h *Hub) handleMessage(ctx context.Context, conn *Connection, msg *protocol.Message) {
+	h.handlePrepared(ctx, conn, msg, nil)
+}
+
+// handlePrepared is handleMessage with what prepareOffRun looked up for the
+// message, or nil to look it up while handling
+func (h *Hub) handlePrepared(ctx context.Context, conn *Connection, msg *protocol.Message, prep *prepared) {
+	// Events can still be queued for a connection that has since unregistered;
+	// handling them would resurrect its subscriptions
+	if conn.IsClosed() {
+		return
+	}
+
+	start := time.Now()
+	defer func() { h.metrics.observeLatency(msg.Type, time.Since(start)) }()
+	if tenant := conn.Tenant(); tenant != "" {
+		h.metrics.tenantMessages.With(tenant).Inc()
+	}
+
+	// Errors sent while handling answer the message
+	conn.handlingID.Store(msg.ID)
+	defer conn.handlingID.Store("")
+
 	switch msg.Type {
 	case protocol.TypePing:
-		conn.SendMessage(protocol.TypePong, map[string]interface{}{
-			"type":      protocol.TypePong,
-			"id":        msg.ID,
-			"timestamp": time.Now().UnixMilli(),
-		})
+		// Echo the client's timestamp so it can measure its own round trip,
+		// and share the server-measured RTT for connection quality displays
+		pong := protocol.NewMessage(protocol.TypePong, map[string]interface{}{
+			"clientTimestamp": msg.Timestamp,
+		}).WithOrigin(msg.ID)
+		if rtt, ok := conn.RTT(); ok {
+			pong.Payload["rttMs"] = float64(rtt.Microseconds()) / 1000
+		}
+		conn.Send(pong)
 
 	case protocol.TypeAuth:
-		// JWT token validation
-		token, _ := msg.Payload["token"].(string)
-
-		if token != "" {
-			// Validate JWT token
-			decoded, err := auth.VerifyToken(token, h.jwtSecret)
-			if err != nil {
-				// Invalid or expired token
-				conn.SendMessage(protocol.TypeAuthError, map[string]interface{}{
-					"type":      protocol.TypeAuthError,
-					"id":        msg.ID,
-					"timestamp": time.Now().UnixMilli(),
-					"error":     "Invalid or expired token",
-					"code":      "INVALID_TOKEN",
-				})
+		var payload protocol.AuthPayload
+		if err := protocol.UnmarshalPayload(msg, &payload); err != nil {
+			h.metrics.authFailures.Add(1)
+			conn.Logger().Warn("Authentication failed", "code", "INVALID_REQUEST", "err", err)
+			conn.Send(protocol.NewMessage(protocol.TypeAuthError, map[string]interface{}{
+				"error": "Invalid request: " + err.Error(),
+				"code":  "INVALID_REQUEST",
+			}).WithOrigin(msg.ID))
+			return
+		}
+
+		// Refuse protocol versions this server does not speak before anything else
+		version, caps, ok := h.negotiate(&payload)
+		if !ok {
+			h.metrics.authFailures.Add(1)
+			h.audit(conn, audit.EventAuthFailure, "", map[string]interface{}{"code": "UNSUPPORTED_PROTOCOL"})
+			conn.Logger().Warn("Authentication failed", "code", "UNSUPPORTED_PROTOCOL", "protocol_version", payload.ProtocolVersion)
+			conn.Send(protocol.NewMessage(protocol.TypeAuthError, map[string]interface{}{
+				"error":      fmt.Sprintf("Unsupported protocol version; this server speaks %d to %d", protocol.MinProtocolVersion, protocol.ProtocolVersion),
+				"code":       "UNSUPPORTED_PROTOCOL",
+				"minVersion": protocol.MinProtocolVersion,
+				"maxVersion": protocol.ProtocolVersion,
+			}).WithOrigin(msg.ID))
+			return
+		}
+
+		// JWT token validation, or an API key for server-to-server clients
+		token, apiKey := payload.Token, payload.APIKey
+
+		if token != "" || apiKey != "" {
+			var creds *credentials
+			if prep != nil && prep.creds != nil {
+				creds = prep.creds
+			} else {
+				creds = h.checkCredentials(ctx, conn, token, apiKey)
+			}
+			if creds.err != nil {
+				// Invalid or expired credentials
+				h.metrics.authFailures.Add(1)
+				h.audit(conn, audit.EventAuthFailure, "", map[string]interface{}{"code": creds.code})
+				conn.Logger().Warn("Authentication failed", "code", creds.code, "err", creds.err)
+				conn.Send(protocol.NewMessage(protocol.TypeAuthError, map[string]interface{}{
+					"error": creds.message,
+					"code":  creds.code,
+				}).WithOrigin(msg.ID))
+				return
+			}
+
+			decoded := creds.token
+			if creds.revoked {
+				h.metrics.authFailures.Add(1)
+				h.audit(conn, audit.EventAuthFailure, "", map[string]interface{}{"code": "TOKEN_REVOKED"})
+				conn.Logger().Warn("Authentication failed", "code", "TOKEN_REVOKED")
+				conn.Send(protocol.NewMessage(protocol.TypeAuthError, map[string]interface{}{
+					"error": "Token has been revoked",
+					"code":  "TOKEN_REVOKED",
+				}).WithOrigin(msg.ID))
+				return
+			}
+
+			// Admins may act for a tenant; with multi-tenancy everyone else
+			// must have one
+			if decoded = h.scopeTenant(conn, msg.ID, decoded, payload.TenantID); decoded == nil {
+				return
+			}
+
+			// A fresh token for a connection that already authenticated
+			if conn.Authenticated && conn.VerifiedUserID() != "" {
+				h.reauthenticate(ctx, conn, msg.ID, decoded)
 				return
 			}
 
@@ -203,22 +870,48 @@ func (h *Hub) handleMessage(conn *Connection, msg *protocol.Message) {
 			conn.Authenticated = true
 			conn.UserID = decoded.UserID
 			conn.TokenPayload = decoded
-		} else {
-			// Anonymous connection - only allowed when auth is disabled
-			authRequired := os.Getenv("SYNCKIT_AUTH_REQUIRED") != "false"
-			if authRequired {
-				conn.SendMessage(protocol.TypeAuthError, map[string]interface{}{
-					"type":      protocol.TypeAuthError,
-					"id":        msg.ID,
-					"timestamp": time.Now().UnixMilli(),
-					"error":     "Authentication required",
-					"code":      "AUTH_REQUIRED",
-				})
+			conn.verifiedUser.Store(decoded.UserID)
+			conn.tenant.Store(decoded.TenantID)
+			conn.anonymousRead.Store(false)
+			h.armTokenExpiry(conn, decoded)
+		} else if h.opts.MultiTenancy {
+			// Every connection belongs to a tenant
+			h.refuseAuth(conn, msg.ID, "TENANT_REQUIRED", "A token with a tenant is required")
+			return
+		} else if h.authConfig.Required {
+			// Anonymous connection while auth is required - only as a reader
+			if !h.authConfig.AnonymousRead {
+				h.metrics.authFailures.Add(1)
+				h.audit(conn, audit.EventAuthFailure, "", map[string]interface{}{"code": "AUTH_REQUIRED"})
+				conn.Logger().Warn("Authentication failed", "code", "AUTH_REQUIRED")
+				conn.Send(protocol.NewMessage(protocol.TypeAuthError, map[string]interface{}{
+					"error": "Authentication required",
+					"code":  "AUTH_REQUIRED",
+				}).WithOrigin(msg.ID))
 				return
 			}
 			conn.Authenticated = true
-			if userID, ok := msg.Payload["userId"].(string); ok {
-				conn.UserID = userID
+			conn.UserID = "anonymous"
+			conn.verifiedUser.Store("")
+			conn.tenant.Store("")
+			conn.anonymousRead.Store(true)
+			conn.stopTokenExpiry()
+			// No user ID, so document grants never apply; the public document
+			// policy limits what it reads
+			conn.TokenPayload = &auth.TokenPayload{
+				Permissions: auth.DocumentPermissions{
+					CanRead:  []string{"*"},
+					CanWrite: []string{},
+				},
+			}
+		} else {
+			// Anonymous connection - full access when auth is disabled
+			conn.Authenticated = true
+			conn.verifiedUser.Store("")
+			conn.tenant.Store("")
+			conn.stopTokenExpiry()
+			if payload.UserID != "" {
+				conn.UserID = payload.UserID
 			} else {
 				conn.UserID = "anonymous"
 			}
@@ -232,293 +925,1057 @@ func (h *Hub) handleMessage(conn *Connection, msg *protocol.Message) {
 			}
 		}
 
+		if !h.hookAuthenticate(ctx, conn, payload.ClientID, msg.ID) {
+			return
+		}
+		conn.authenticated()
+		conn.logAs(conn.UserID)
+		conn.Logger().Info("Authenticated", "anonymous", conn.VerifiedUserID() == "")
+
 		// Set client ID
-		if clientID, ok := msg.Payload["clientId"].(string); ok {
-			conn.ClientID = clientID
+		if payload.ClientID != "" {
+			conn.ClientID = payload.ClientID
 		} else {
-			conn.ClientID = generateID()
+			conn.ClientID = protocol.NewID()
+		}
+
+		if h.opts.KickDuplicateClients {
+			h.replaceDuplicateClient(conn)
 		}
 
 		// Send success response with permissions
-		conn.SendMessage(protocol.TypeAuthSuccess, map[string]interface{}{
-			"type":      protocol.TypeAuthSuccess,
-			"id":        msg.ID,
-			"timestamp": time.Now().UnixMilli(),
-			"userId":    conn.UserID,
+		success := protocol.NewMessage(protocol.TypeAuthSuccess, map[string]interface{}{
+			"userId": conn.UserID,
 			"permissions": map[string]interface{}{
 				"canRead":  conn.TokenPayload.Permissions.CanRead,
 				"canWrite": conn.TokenPayload.Permissions.CanWrite,
 				"isAdmin":  conn.TokenPayload.Permissions.IsAdmin,
 			},
-		})
+		}).WithOrigin(msg.ID)
+		if tenant := conn.Tenant(); tenant != "" {
+			success.Payload["tenantId"] = tenant
+		}
+		// The reply reports what was negotiated. Clients that can inflate
+		// payloads may be sent compressed ones from here on
+		success.Payload["protocolVersion"] = version
+		success.Payload["capabilities"] = caps.names()
+		conn.protocolVersion.Store(int32(version))
+		if caps&capCompression != 0 {
+			conn.compressAbove.Store(int64(h.opts.CompressionThreshold))
+			success.Payload["compression"] = "deflate"
+		} else {
+			conn.compressAbove.Store(0)
+		}
+		// The reply is still JSON; once the client knows its encoding was
+		// accepted, every message to it carries MessagePack
+		if caps&capMsgpack != 0 {
+			success.Payload["encoding"] = string(protocol.EncodingMsgpack)
+		}
+		conn.Send(success)
+		conn.capabilities.Store(uint32(caps))
 
 	case protocol.TypeSubscribe:
-		docID, ok := msg.Payload["docId"].(string)
-		if !ok {
-			conn.SendError("Missing docId", "INVALID_REQUEST")
+		var payload protocol.SubscribePayload
+		if !readPayload(conn, msg, &payload) {
 			return
 		}
+		docID := payload.DocID
 
 		// Check authentication
 		if !conn.Authenticated || conn.TokenPayload == nil {
-			conn.SendError("Not authenticated", "NOT_AUTHENTICATED")
+			conn.replyError("Not authenticated", "NOT_AUTHENTICATED")
 			return
 		}
 
 		// Validate document ID
 		if valid, errMsg := security.ValidateDocumentID(docID); !valid {
-			conn.SendError(errMsg, "INVALID_DOCUMENT_ID")
+			conn.replyError(errMsg, "INVALID_DOCUMENT_ID")
 			return
 		}
 
 		// Check document access
-		if !security.CanAccessDocument(docID) {
-			conn.SendError("Access denied to this document", "ACCESS_DENIED")
+		if !h.opts.PublicDocuments.Allows(docID) {
+			h.metrics.permissionDenials.Add(1)
+			h.audit(conn, audit.EventPermissionDenied, docID, map[string]interface{}{"action": "subscribe", "code": "ACCESS_DENIED"})
+			conn.Logger().Warn("Subscribe denied", "doc_id", docID, "code", "ACCESS_DENIED")
+			conn.replyError("Access denied to this document", "ACCESS_DENIED")
 			return
 		}
 
 		// Check read permission
-		if !auth.CanReadDocument(conn.TokenPayload, docID) {
-			conn.SendError("Permission denied", "PERMISSION_DENIED")
+		if !h.opts.ACL.CanRead(ctx, conn.TokenPayload, docID) && !h.createOwnDocument(ctx, conn, docID) {
+			h.metrics.permissionDenials.Add(1)
+			h.audit(conn, audit.EventPermissionDenied, docID, map[string]interface{}{"action": "subscribe", "code": "PERMISSION_DENIED"})
+			conn.Logger().Warn("Subscribe denied", "doc_id", docID, "code", "PERMISSION_DENIED")
+			conn.replyError("Permission denied", "PERMISSION_DENIED")
+			return
+		}
+
+		// Resolve the subscription mode; writers without write permission are
+		// downgraded to read so they learn up front instead of on first delta
+		mode := ModeWrite
+		if payload.Mode != "" {
+			mode = payload.Mode
+		}
+		if mode != ModeWrite && mode != ModeRead {
+			conn.replyError("Invalid subscription mode: "+mode, "INVALID_REQUEST")
+			return
+		}
+		if mode == ModeWrite && !h.opts.ACL.CanWrite(ctx, conn.TokenPayload, docID) {
+			mode = ModeRead
+		}
+
+		// Enforce subscription limits (re-subscribing to the same document is free)
+		if !conn.Subscriptions[docID] && len(conn.Subscriptions) >= h.opts.Limits.MaxSubscriptionsPerConnection {
+			conn.replyError("Too many subscriptions for this connection", "SUBSCRIPTION_LIMIT")
+			return
+		}
+		if !h.hookSubscribe(ctx, conn, docID) {
+			return
+		}
+
+		if !h.loadDocument(ctx, conn, docID) {
 			return
 		}
+		h.claimDocument(ctx, conn, docID)
 
 		// Subscribe
-		conn.Subscriptions[docID] = true
 		h.mu.Lock()
 		if _, exists := h.subscribers[docID]; !exists {
 			h.subscribers[docID] = make(map[string]bool)
 		}
+		if !h.subscribers[docID][conn.ID] && len(h.subscribers[docID]) >= h.opts.Limits.MaxSubscribersPerDocument {
+			h.mu.Unlock()
+			conn.replyError("Document has too many subscribers", "DOCUMENT_FULL")
+			return
+		}
+		joined := !h.subscribers[docID][conn.ID]
 		h.subscribers[docID][conn.ID] = true
 		h.mu.Unlock()
+		conn.Subscriptions[docID] = true
+		if joined {
+			h.publishSubscriber(EventSubscriberJoined, conn, docID)
+		}
+		conn.Logger().Debug("Subscribed", "doc_id", docID, "mode", mode)
+		// Listen for other servers' deltas before sending the state, so none
+		// fall between the two
+		h.syncRelay(docID)
+		if mode == ModeRead {
+			conn.ReadOnly[docID] = true
+		} else {
+			delete(conn.ReadOnly, docID)
+		}
+
+		// A reconnecting client may ask to resume from the last sequence it saw
+		resumeFrom, wantsResume := payload.ResumeFrom[docID]
+		if !conn.can(capResume) {
+			wantsResume = false
+		}
 
-		// Send current document state
+		// Send current document state, or only the missed deltas when resuming
 		h.docsMu.RLock()
 		doc := h.documents[docID]
+		var seq int64
+		var missed []map[string]interface{}
+		resumed := false
+		if hist := h.history[docID]; hist != nil {
+			seq = hist.seq
+			if wantsResume {
+				missed, resumed = hist.since(int64(resumeFrom), time.Now())
+			}
+		} else if wantsResume && resumeFrom == 0 {
+			missed, resumed = []map[string]interface{}{}, true
+		}
 		h.docsMu.RUnlock()
 
 		if doc == nil {
 			doc = make(map[string]interface{})
+		} else {
+			h.cache.use(docID, 0, false)
+		}
+
+		response := protocol.NewMessage(protocol.TypeSyncResponse, map[string]interface{}{
+			"docId":    docID,
+			"seq":      seq,
+			"mode":     mode,
+			"readOnly": mode == ModeRead,
+		}).WithOrigin(msg.ID)
+		if resumed {
+			response.Payload["resumed"] = true
+			response.Payload["deltas"] = missed
+		} else {
+			response.Payload["state"] = doc
+		}
+		if conn.Send(response) == nil && !resumed {
+			conn.divergence.reset(docID)
 		}
 
-		conn.SendMessage(protocol.TypeSyncResponse, map[string]interface{}{
-			"type":      protocol.TypeSyncResponse,
-			"id":        msg.ID,
-			"timestamp": time.Now().UnixMilli(),
-			"docId":     docID,
-			"state":     doc,
-		})
+	case protocol.TypeSubscribePrefix:
+		var payload protocol.PrefixPayload
+		if !readPayload(conn, msg, &payload) {
+			return
+		}
+		prefix := payload.Prefix
 
-	case protocol.TypeUnsubscribe:
-		docID, ok := msg.Payload["docId"].(string)
-		if !ok {
-			conn.SendError("Missing docId", "INVALID_REQUEST")
+		// Check authentication
+		if !conn.Authenticated || conn.TokenPayload == nil {
+			conn.replyError("Not authenticated", "NOT_AUTHENTICATED")
 			return
 		}
 
-		// Remove subscription from connection
-		delete(conn.Subscriptions, docID)
+		// A prefix must itself be a valid document ID fragment
+		if valid, errMsg := security.ValidateDocumentID(prefix); !valid {
+			conn.replyError(errMsg, "INVALID_DOCUMENT_ID")
+			return
+		}
+
+		if !conn.PrefixSubscriptions[prefix] && len(conn.PrefixSubscriptions) >= h.opts.Limits.MaxPrefixSubscriptions {
+			conn.replyError("Too many prefix subscriptions for this connection", "SUBSCRIPTION_LIMIT")
+			return
+		}
 
-		// Remove from document subscribers
 		h.mu.Lock()
-		if subs, exists := h.subscribers[docID]; exists {
-			delete(subs, conn.ID)
-			if len(subs) == 0 {
-				delete(h.subscribers, docID)
-			}
+		if _, exists := h.prefixSubscribers[prefix]; !exists {
+			h.prefixSubscribers[prefix] = make(map[string]bool)
 		}
+		h.prefixSubscribers[prefix][conn.ID] = true
 		h.mu.Unlock()
+		conn.PrefixSubscriptions[prefix] = true
+
+		// List the existing documents under the prefix this connection may
+		// read. Resolving access loaded the grants, so deltaRecipients finds
+		// them cached.
+		docIDs := h.readableDocuments(conn, h.readAccess(ctx, conn, prep, prefix, ""), prefix, "")
 
-		// Clean up awareness for this connection on this document
-		h.awareMu.Lock()
-		if states, exists := h.awareness[docID]; exists {
-			delete(states, conn.ClientID)
-			if len(states) == 0 {
-				delete(h.awareness, docID)
+		conn.Send(protocol.NewMessage(protocol.TypePrefixDocuments, map[string]interface{}{
+			"prefix": prefix,
+			"docIds": docIDs,
+		}).WithOrigin(msg.ID))
+
+	case protocol.TypeUnsubscribePrefix:
+		var payload protocol.PrefixPayload
+		if !readPayload(conn, msg, &payload) {
+			return
+		}
+		prefix := payload.Prefix
+
+		delete(conn.PrefixSubscriptions, prefix)
+		h.mu.Lock()
+		h.removePrefixSubscriber(prefix, conn.ID)
+		h.mu.Unlock()
+
+	case protocol.TypeSubscribeList:
+		// An empty or missing prefix lists every document
+		var payload protocol.ListPayload
+		if !readPayload(conn, msg, &payload) {
+			return
+		}
+		prefix, cursor := payload.Prefix, payload.Cursor
+
+		// Check authentication
+		if !conn.Authenticated || conn.TokenPayload == nil {
+			conn.replyError("Not authenticated", "NOT_AUTHENTICATED")
+			return
+		}
+
+		if prefix != "" {
+			if valid, errMsg := security.ValidateDocumentID(prefix); !valid {
+				conn.replyError(errMsg, "INVALID_DOCUMENT_ID")
+				return
 			}
 		}
-		h.awareMu.Unlock()
 
-		// Remove from awareness subscriptions
-		delete(conn.AwarenessSubscriptions, docID)
+		if !conn.ListSubscriptions[prefix] && len(conn.ListSubscriptions) >= h.opts.Limits.MaxListSubscriptions {
+			conn.replyError("Too many list subscriptions for this connection", "SUBSCRIPTION_LIMIT")
+			return
+		}
+
+		// Register before listing so no change between the two is lost.
+		// Re-sending subscribe_list with a cursor fetches the next page.
+		h.mu.Lock()
+		if _, exists := h.listSubscribers[prefix]; !exists {
+			h.listSubscribers[prefix] = make(map[string]bool)
+		}
+		h.listSubscribers[prefix][conn.ID] = true
+		h.mu.Unlock()
+		conn.ListSubscriptions[prefix] = true
+
+		// Resolving access loaded the grants, so notifyListChanged finds them
+		// cached
+		access := h.readAccess(ctx, conn, prep, prefix, cursor)
+		docIDs, nextCursor := h.listPage(conn, access, prefix, cursor, listPageSize(payload.Limit))
+		response := protocol.NewMessage(protocol.TypeDocumentList, map[string]interface{}{
+			"prefix":  prefix,
+			"docIds":  docIDs,
+			"hasMore": nextCursor != "",
+		}).WithOrigin(msg.ID)
+		if nextCursor != "" {
+			response.Payload["nextCursor"] = nextCursor
+		}
+		conn.Send(response)
+
+	case protocol.TypeUnsubscribeList:
+		var payload protocol.ListPayload
+		if !readPayload(conn, msg, &payload) {
+			return
+		}
+		prefix := payload.Prefix
+
+		delete(conn.ListSubscriptions, prefix)
+		h.mu.Lock()
+		h.removeListSubscriber(prefix, conn.ID)
+		h.mu.Unlock()
+
+	case protocol.TypeUnsubscribe:
+		var payload protocol.DocumentPayload
+		if !readPayload(conn, msg, &payload) {
+			return
+		}
+		docID := payload.DocID
+
+		h.unsubscribe(conn, docID)
+		h.syncRelay(docID)
 
 	case protocol.TypeDelta:
-		docID, ok := msg.Payload["docId"].(string)
-		if !ok {
-			conn.SendError("Missing docId", "INVALID_REQUEST")
+		var payload protocol.DeltaPayload
+		if !readPayload(conn, msg, &payload) {
 			return
 		}
+		docID := payload.DocID
 
 		// Check authentication
 		if !conn.Authenticated || conn.TokenPayload == nil {
-			conn.SendError("Not authenticated", "NOT_AUTHENTICATED")
+			conn.replyError("Not authenticated", "NOT_AUTHENTICATED")
+			return
+		}
+		if h.refuseDuringMaintenance(conn, docID) {
+			return
+		}
+
+		if h.refuseRevoked(ctx, conn) {
+			return
+		}
+
+		// Read-mode subscriptions never write, regardless of token
+		if conn.ReadOnly[docID] {
+			conn.replyError("Document is read-only for this connection", "READ_ONLY")
 			return
 		}
 
 		// Check write permission
-		if !auth.CanWriteDocument(conn.TokenPayload, docID) {
-			conn.SendError("Permission denied", "PERMISSION_DENIED")
+		if !h.opts.ACL.CanWrite(ctx, conn.TokenPayload, docID) && !h.createOwnDocument(ctx, conn, docID) {
+			h.metrics.permissionDenials.Add(1)
+			h.audit(conn, audit.EventPermissionDenied, docID, map[string]interface{}{"action": string(msg.Type), "code": "PERMISSION_DENIED"})
+			conn.Logger().Warn("Write denied", "doc_id", docID, "type", msg.Type)
+			conn.replyError("Permission denied", "PERMISSION_DENIED")
+			return
+		}
+		if !h.loadDocument(ctx, conn, docID) || !h.allowDocumentCreation(conn, docID) {
 			return
 		}
+		h.claimDocument(ctx, conn, docID)
+		fields := h.writableFields(ctx, conn, docID)
+		delta := forwardDelta(msg.Payload, msg.ID)
+		denied := h.hookDelta(ctx, conn, docID, delta)
 
 		// Apply delta
 		h.docsMu.Lock()
-		if h.documents[docID] == nil {
-			h.documents[docID] = make(map[string]interface{})
+		var result deltaResult
+		if denied != nil {
+			result = *denied
+		} else {
+			result = h.applyDelta(docID, conn.ClientID, delta, msg.Timestamp, fields, true)
 		}
-		if changes, ok := msg.Payload["changes"].(map[string]interface{}); ok {
-			for k, v := range changes {
-				h.documents[docID][k] = v
+		if result.applied() {
+			h.noteLocalVersion(docID, result.seq)
+		}
+		seq := h.currentSeq(docID)
+		clock := h.documentMeta(docID).clockSnapshot()
+		var turn *fanoutTurn
+		var state map[string]interface{}
+		if result.applied() {
+			turn = h.history[docID].turn
+			if h.writer != nil {
+				state = h.documentSnapshot(docID)
 			}
 		}
 		h.docsMu.Unlock()
 
-		// Broadcast to other subscribers
-		h.broadcastDelta(docID, msg.Payload, conn.ID)
+		// Broadcast to other subscribers, in sequence order
+		if result.applied() {
+			h.broadcastInOrder(turn, docID, result.seq, []map[string]interface{}{result.delta}, h.reusablePayload(msg, result), result.created, conn)
+			h.relayDeltas(docID, conn.ClientID, []map[string]interface{}{result.delta}, msg.Timestamp)
+		}
+		if result.created {
+			h.recordDocumentCreation(conn)
+			h.notifyListChanged(docID, ListAdded)
+		}
 
-		// Send ACK
-		conn.SendMessage(protocol.TypeAck, map[string]interface{}{
-			"type":      protocol.TypeAck,
-			"id":        msg.ID,
-			"timestamp": time.Now().UnixMilli(),
-			"docId":     docID,
-		})
+		// Send ACK with the outcome and where the document now stands
+		ack := protocol.NewMessage(protocol.TypeAck, map[string]interface{}{
+			"docId": docID,
+			"seq":   seq,
+			"clock": clock,
+		}).WithOrigin(msg.ID)
+		for k, v := range result.ackStatus() {
+			ack.Payload[k] = v
+		}
+		h.persistAndAck(conn, docID, state, ack)
+		if !result.applied() {
+			h.noteDivergence(conn, docID, result.reason, 1)
+		}
 
 	case protocol.TypeDeltaBatch:
-		docID, ok := msg.Payload["docId"].(string)
-		if !ok {
-			conn.SendError("Missing docId", "INVALID_REQUEST")
+		var payload protocol.DeltaBatchPayload
+		if !readPayload(conn, msg, &payload) {
 			return
 		}
+		docID, deltas, flushID := payload.DocID, payload.Deltas, payload.FlushID
 
 		// Check authentication
 		if !conn.Authenticated || conn.TokenPayload == nil {
-			conn.SendError("Not authenticated", "NOT_AUTHENTICATED")
+			conn.replyError("Not authenticated", "NOT_AUTHENTICATED")
+			return
+		}
+		if !conn.can(capBatching) {
+			conn.replyError("delta_batch needs the batching capability", "CAPABILITY_NOT_NEGOTIATED")
+			return
+		}
+		if h.refuseDuringMaintenance(conn, docID) {
 			return
 		}
 
-		// Check write permission
-		if !auth.CanWriteDocument(conn.TokenPayload, docID) {
-			conn.SendError("Permission denied", "PERMISSION_DENIED")
+		if h.refuseRevoked(ctx, conn) {
 			return
 		}
 
-		deltas, ok := msg.Payload["deltas"].([]interface{})
-		if !ok {
-			conn.SendError("Invalid deltas", "INVALID_REQUEST")
+		// Read-mode subscriptions never write, regardless of token
+		if conn.ReadOnly[docID] {
+			conn.replyError("Document is read-only for this connection", "READ_ONLY")
 			return
 		}
 
-		// Apply each delta
-		h.docsMu.Lock()
-		if h.documents[docID] == nil {
-			h.documents[docID] = make(map[string]interface{})
+		// Check write permission
+		if !h.opts.ACL.CanWrite(ctx, conn.TokenPayload, docID) && !h.createOwnDocument(ctx, conn, docID) {
+			h.metrics.permissionDenials.Add(1)
+			h.audit(conn, audit.EventPermissionDenied, docID, map[string]interface{}{"action": string(msg.Type), "code": "PERMISSION_DENIED"})
+			conn.Logger().Warn("Write denied", "doc_id", docID, "type", msg.Type)
+			conn.replyError("Permission denied", "PERMISSION_DENIED")
+			return
+		}
+
+		if !h.loadDocument(ctx, conn, docID) || !h.allowDocumentCreation(conn, docID) {
+			return
 		}
-		for _, deltaRaw := range deltas {
+		// Deltas of a flush the server already handled are skipped, so a
+		// client re-flushing after losing the ACK resumes where it left off
+		watermark := int64(-1)
+		if flushID != "" {
+			var ok bool
+			if watermark, ok = h.flushWatermark(ctx, conn, docID, flushID); !ok {
+				return
+			}
+		}
+		flushed := func(i int) int64 { return payload.DeltaIndex + int64(i) }
+
+		h.claimDocument(ctx, conn, docID)
+		fields := h.writableFields(ctx, conn, docID)
+
+		// Hooks see each delta before the lock is taken
+		forwarded := make([]map[string]interface{}, len(deltas))
+		denied := make([]*deltaResult, len(deltas))
+		for i, deltaRaw := range deltas {
+			if flushID != "" && flushed(i) <= watermark {
+				continue
+			}
 			if delta, ok := deltaRaw.(map[string]interface{}); ok {
-				if changes, ok := delta["changes"].(map[string]interface{}); ok {
-					for k, v := range changes {
-						h.documents[docID][k] = v
-					}
+				// Deltas without an ID of their own come from the batch
+				origin, _ := delta["id"].(string)
+				if origin == "" {
+					origin = msg.ID
+				}
+				forwarded[i] = forwardDelta(delta, origin)
+				denied[i] = h.hookDelta(ctx, conn, docID, forwarded[i])
+			}
+		}
+
+		// Apply each delta under the lock, but broadcast only after releasing it
+		// so slow recipients cannot stall writes to other documents
+		results := make([]interface{}, 0, len(deltas))
+		applied := make([]map[string]interface{}, 0, len(deltas))
+		var firstSeq int64
+		created := false
+		rejected, reason := 0, ""
+		handled := watermark
+		h.docsMu.Lock()
+		for i := range deltas {
+			if flushID != "" && flushed(i) <= watermark {
+				results = append(results, map[string]interface{}{"status": "duplicate", "index": i})
+				continue
+			}
+
+			var result deltaResult
+			switch {
+			case flushID != "" && flushed(i) > handled+1:
+				result = deltaResult{reason: RejectOutOfOrder}
+			case forwarded[i] == nil:
+				result = deltaResult{reason: RejectInvalid}
+			case denied[i] != nil:
+				result = *denied[i]
+			default:
+				result = h.applyDelta(docID, conn.ClientID, forwarded[i], msg.Timestamp, fields, true)
+			}
+
+			created = created || result.created
+			status := result.ackStatus()
+			status["index"] = i
+			results = append(results, status)
+			if flushID != "" && result.reason != RejectOutOfOrder {
+				handled = flushed(i)
+			}
+
+			if result.applied() {
+				if firstSeq == 0 {
+					firstSeq = result.seq
 				}
-				// Broadcast individual delta
-				h.broadcastDelta(docID, delta, conn.ID)
+				applied = append(applied, result.delta)
+			} else {
+				rejected++
+				reason = result.reason
 			}
 		}
+		seq := h.currentSeq(docID)
+		if len(applied) > 0 {
+			h.noteLocalVersion(docID, seq)
+		}
+		clock := h.documentMeta(docID).clockSnapshot()
+		var turn *fanoutTurn
+		if hist := h.history[docID]; hist != nil {
+			turn = hist.turn
+		}
+		var state map[string]interface{}
+		if h.writer != nil && len(applied) > 0 {
+			state = h.documentSnapshot(docID)
+		}
 		h.docsMu.Unlock()
 
+		// Broadcast individual deltas in batch order
+		if len(applied) > 0 {
+			h.broadcastInOrder(turn, docID, firstSeq, applied, nil, created, conn)
+			h.relayDeltas(docID, conn.ClientID, applied, msg.Timestamp)
+		}
+		if created {
+			h.recordDocumentCreation(conn)
+			h.notifyListChanged(docID, ListAdded)
+		}
+
 		// Send ACK
-		conn.SendMessage(protocol.TypeAck, map[string]interface{}{
-			"type":      protocol.TypeAck,
-			"id":        msg.ID,
-			"timestamp": time.Now().UnixMilli(),
-			"docId":     docID,
-			"count":     len(deltas),
-		})
+		ack := protocol.NewMessage(protocol.TypeAck, map[string]interface{}{
+			"docId":   docID,
+			"count":   len(deltas),
+			"seq":     seq,
+			"clock":   clock,
+			"results": results,
+		}).WithOrigin(msg.ID)
+		if flushID == "" {
+			h.persistAndAck(conn, docID, state, ack)
+		} else {
+			ack.Payload["flushId"] = flushID
+			ack.Payload["watermark"] = handled
+			h.persistFlushAndAck(ctx, conn, docID, state, ack, flushID, handled, handled > watermark)
+		}
+		if rejected > 0 {
+			h.noteDivergence(conn, docID, reason, rejected)
+		}
 
 	case protocol.TypeAwarenessUpdate:
-		docID, ok := msg.Payload["docId"].(string)
-		if !ok {
+		var payload protocol.AwarenessPayload
+		if !readPayload(conn, msg, &payload) {
 			return
 		}
-		state, ok := msg.Payload["state"].(map[string]interface{})
-		if !ok {
+		docID, state := payload.DocID, payload.State
+
+		if size := awarenessSize(state); size > h.opts.Limits.MaxAwarenessStateSize {
+			conn.replyError(fmt.Sprintf("Awareness state too large (%d bytes, max %d)", size, h.opts.Limits.MaxAwarenessStateSize), "AWARENESS_TOO_LARGE")
+			return
+		}
+
+		// Tracked so the state is removed when the connection goes
+		conn.AwarenessSubscriptions[docID] = true
+
+		// Bursts beyond the per-second budget are coalesced, not rejected
+		flush := func() { h.flushAwareness(conn) }
+		if !conn.awarenessThrottle.admit(docID, state, time.Now(), h.opts.Limits.MaxAwarenessUpdatesPerSecond, flush) {
 			return
 		}
 
-		// Add lastUpdate timestamp for cleanup tracking
-		state["lastUpdate"] = float64(time.Now().UnixMilli())
+		h.publishAwareness(conn, docID, state, msg.ID)
 
-		// Store awareness state
-		h.awareMu.Lock()
-		if h.awareness[docID] == nil {
-			h.awareness[docID] = make(map[string]interface{})
+	case protocol.TypeAwarenessSubscribe:
+		var payload protocol.DocumentPayload
+		if !readPayload(conn, msg, &payload) {
+			return
+		}
+		docID := payload.DocID
+		if !conn.Authenticated || conn.TokenPayload == nil {
+			conn.replyError("Not authenticated", "NOT_AUTHENTICATED")
+			return
+		}
+		if valid, errMsg := security.ValidateDocumentID(docID); !valid {
+			conn.replyError(errMsg, "INVALID_DOCUMENT_ID")
+			return
+		}
+		if !h.opts.PublicDocuments.Allows(docID) || !h.opts.ACL.CanRead(ctx, conn.TokenPayload, docID) {
+			h.metrics.permissionDenials.Add(1)
+			h.audit(conn, audit.EventPermissionDenied, docID, map[string]interface{}{"action": "awareness_subscribe", "code": "PERMISSION_DENIED"})
+			conn.replyError("Permission denied", "PERMISSION_DENIED")
+			return
 		}
-		h.awareness[docID][conn.ClientID] = state
-		h.awareMu.Unlock()
 
-		// Broadcast to other subscribers
-		h.broadcastAwareness(docID, conn.ClientID, state, conn.ID)
+		// Updates reach document subscribers; this fetches the states so far
+		conn.AwarenessSubscriptions[docID] = true
+		h.sendAwarenessStates(ctx, conn, docID)
+	}
+}
+
+// recordDelta assigns the next sequence number for a document and remembers
+// the delta for resume. It returns a copy of the delta stamped with "seq",
+// which is what gets broadcast. Must be called with docsMu held.
+func (h *Hub) recordDelta(docID string, delta map[string]interface{}) (int64, map[string]interface{}) {
+	hist := h.history[docID]
+	if hist == nil {
+		hist = newDeltaHistory(h.opts.ResumeBufferSize, h.opts.ResumeRetention)
+		h.history[docID] = hist
+	}
+
+	stamped := make(map[string]interface{}, len(delta)+1)
+	for k, v := range delta {
+		stamped[k] = v
 	}
+	stamped["seq"] = hist.seq + 1
+	return hist.append(stamped, time.Now()), stamped
 }
 
-func (h *Hub) broadcastDelta(docID string, delta map[string]interface{}, senderID string) {
+// currentSeq returns the last sequence number assigned to a document. Must be
+// called with docsMu held.
+func (h *Hub) currentSeq(docID string) int64 {
+	if hist := h.history[docID]; hist != nil {
+		return hist.seq
+	}
+	return 0
+}
+
+// readPayload decodes msg's payload into v, one of the protocol payload
+// structs, replying INVALID_REQUEST when a field is missing or malformed
+func readPayload(conn *Connection, msg *protocol.Message, v interface{}) bool {
+	if err := protocol.UnmarshalPayload(msg, v); err != nil {
+		conn.replyError("Invalid request: "+err.Error(), "INVALID_REQUEST")
+		return false
+	}
+	return true
+}
+
+// replaceDuplicateClient closes any other connection authenticated as the same
+// user and client ID, moving its subscriptions over to conn. This happens when
+// a tab reconnects before its old socket has timed out.
+func (h *Hub) replaceDuplicateClient(conn *Connection) {
 	h.mu.RLock()
-	subs := h.subscribers[docID]
+	var old *Connection
+	for _, c := range h.connections {
+		if c != conn && c.Authenticated && c.UserID == conn.UserID && c.ClientID == conn.ClientID {
+			old = c
+			break
+		}
+	}
 	h.mu.RUnlock()
 
-	if subs == nil {
+	if old == nil {
 		return
 	}
 
-	for connID := range subs {
-		if connID == senderID {
+	// Wait out any message of old's still being handled by a worker
+	old.handleMu.Lock()
+	defer old.handleMu.Unlock()
+
+	old.SendError("Session replaced by a newer connection", "SESSION_REPLACED")
+
+	// Move document subscriptions the new token is still allowed to read.
+	// Access is checked before taking h.mu, since grants may need loading.
+	ctx := context.Background()
+	readable := make(map[string]bool, len(old.Subscriptions))
+	writable := make(map[string]bool, len(old.Subscriptions))
+	for docID := range old.Subscriptions {
+		readable[docID] = h.opts.ACL.CanRead(ctx, conn.TokenPayload, docID)
+		writable[docID] = h.opts.ACL.CanWrite(ctx, conn.TokenPayload, docID)
+	}
+	h.mu.Lock()
+	for docID := range old.Subscriptions {
+		subs, exists := h.subscribers[docID]
+		if !exists {
 			continue
 		}
+		delete(subs, old.ID)
+		if readable[docID] {
+			subs[conn.ID] = true
+			conn.Subscriptions[docID] = true
+			if old.ReadOnly[docID] || !writable[docID] {
+				conn.ReadOnly[docID] = true
+			}
+		} else if len(subs) == 0 {
+			delete(h.subscribers, docID)
+			go h.syncRelay(docID)
+		}
+	}
+	old.Subscriptions = make(map[string]bool)
+	old.ReadOnly = make(map[string]bool)
+
+	// Prefix subscriptions are filtered per document at broadcast time
+	for prefix := range old.PrefixSubscriptions {
+		if subs, exists := h.prefixSubscribers[prefix]; exists {
+			delete(subs, old.ID)
+			subs[conn.ID] = true
+			conn.PrefixSubscriptions[prefix] = true
+		}
+	}
+	old.PrefixSubscriptions = make(map[string]bool)
 
-		h.mu.RLock()
-		conn := h.connections[connID]
-		h.mu.RUnlock()
+	for prefix := range old.ListSubscriptions {
+		if subs, exists := h.listSubscribers[prefix]; exists {
+			delete(subs, old.ID)
+			subs[conn.ID] = true
+			conn.ListSubscriptions[prefix] = true
+		}
+	}
+	old.ListSubscriptions = make(map[string]bool)
+	h.mu.Unlock()
 
-		if conn != nil {
-			conn.SendMessage(protocol.TypeDelta, delta)
+	// Awareness state is keyed by client ID, so it carries over as-is
+	for docID := range old.AwarenessSubscriptions {
+		conn.AwarenessSubscriptions[docID] = true
+	}
+	old.AwarenessSubscriptions = make(map[string]bool)
+
+	h.removeConnection(old)
+}
+
+// broadcastInOrder fans out deltas numbered first, first+1, ... once every
+// earlier delta for the document has been enqueued, so each subscriber sees
+// strictly increasing sequence numbers. raw, if not nil, is the JSON a
+// single delta arrived as, from reusablePayload.
+func (h *Hub) broadcastInOrder(turn *fanoutTurn, docID string, first int64, deltas []map[string]interface{}, raw []byte, created bool, sender *Connection) {
+	turn.wait(first)
+	defer turn.done(first + int64(len(deltas)))
+
+	h.publishChanges(docID, first, deltas, created, sender)
+	senderID := ""
+	if sender != nil {
+		senderID = sender.ID
+	}
+
+	if len(deltas) == 1 {
+		h.broadcastDelta(docID, deltas[0], raw, senderID)
+		return
+	}
+
+	// Several deltas go out as one batch to clients that can take one
+	messages := make([]protocol.Message, len(deltas))
+	for i, delta := range deltas {
+		messages[i] = protocol.Message{Type: protocol.TypeDelta, Payload: delta}
+	}
+	recipients := h.deltaRecipients(docID, senderID)
+	for range deltas {
+		h.metrics.fanout.Observe(float64(len(recipients)))
+	}
+	shared := make([]*sharedMessage, len(deltas))
+	for i := range messages {
+		shared[i] = newSharedMessage(&messages[i])
+	}
+	for _, conn := range recipients {
+		if !conn.takesBatches() {
+			for _, msg := range shared {
+				h.deliverDelta(conn, docID, msg)
+			}
+			continue
+		}
+		switch conn.SendBatch(messages) {
+		case nil:
+			h.metrics.broadcastsSent.Add(uint64(len(deltas)))
+			h.noteDivergence(conn, docID, DivergenceDropped, 0)
+		case ErrSendQueueFull:
+			h.noteDivergence(conn, docID, DivergenceDropped, len(deltas))
 		}
 	}
 }
 
-func (h *Hub) broadcastAwareness(docID, clientID string, state map[string]interface{}, senderID string) {
+func (h *Hub) broadcastDelta(docID string, delta map[string]interface{}, raw []byte, senderID string) {
+	recipients := h.deltaRecipients(docID, senderID)
+	h.metrics.fanout.Observe(float64(len(recipients)))
+	msg := newSharedMessage(&protocol.Message{Type: protocol.TypeDelta, Payload: delta}).withRaw(raw)
+	for _, conn := range recipients {
+		h.deliverDelta(conn, docID, msg)
+	}
+}
+
+func (h *Hub) deliverDelta(conn *Connection, docID string, msg *sharedMessage) {
+	switch conn.sendShared(msg) {
+	case nil:
+		h.metrics.broadcastsSent.Add(1)
+		// Delivers a sync_required that an earlier full queue held back
+		h.noteDivergence(conn, docID, DivergenceDropped, 0)
+	case ErrSendQueueFull:
+		h.noteDivergence(conn, docID, DivergenceDropped, 1)
+	}
+}
+
+// broadcastAwareness sends a client's awareness state to the other
+// subscribers of a document, with origin naming the update that set it.
+// Subscribers holding every state of the document are sent only what
+// changed since prev, the state it replaces; the rest get the whole state.
+func (h *Hub) broadcastAwareness(docID, clientID string, prev, state map[string]interface{}, senderID, origin string) {
+	h.publishEvent(DocumentEvent{Type: EventAwareness, DocID: docID, ClientID: clientID, State: state})
+
+	msg := newSharedMessage(protocol.NewMessage(protocol.TypeAwarenessState, map[string]interface{}{
+		"docId":    docID,
+		"clientId": clientID,
+		"state":    state,
+	}).WithOrigin(origin))
+	var diff *sharedMessage
+	if prev != nil && state != nil {
+		changed, removed := awarenessDiff(prev, state)
+		diff = newSharedMessage(protocol.NewMessage(protocol.TypeAwarenessState, map[string]interface{}{
+			"docId":    docID,
+			"clientId": clientID,
+			"changed":  changed,
+			"removed":  removed,
+		}).WithOrigin(origin))
+	}
+	for _, conn := range h.subscriberConnections(docID, senderID) {
+		send := msg
+		if diff != nil && conn.can(capAwarenessDiff) && conn.awarenessBases.has(docID) {
+			send = diff
+		}
+		if err := conn.sendShared(send); err == nil {
+			h.metrics.broadcastsSent.Add(1)
+		} else {
+			// Later diffs would build on the state it missed
+			conn.awarenessBases.forget(docID)
+		}
+	}
+}
+
+// subscriberConnections snapshots the live subscribers of a document, excluding
+// the sender. The snapshot is taken under h.mu so callers can send without
+// holding any hub lock.
+func (h *Hub) subscriberConnections(docID, excludeID string) []*Connection {
 	h.mu.RLock()
+	defer h.mu.RUnlock()
+
 	subs := h.subscribers[docID]
+	conns := make([]*Connection, 0, len(subs))
+	for connID := range subs {
+		if connID == excludeID {
+			continue
+		}
+		if conn := h.connections[connID]; conn != nil {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+// deltaRecipients is subscriberConnections plus prefix subscribers whose
+// prefix matches the document and who are allowed to read it. Each
+// connection appears at most once. Prefix subscribers are collected under
+// h.mu and checked after it is released, without waiting on the ACL store.
+func (h *Hub) deltaRecipients(docID, excludeID string) []*Connection {
+	conns := h.subscriberConnections(docID, excludeID)
+
+	seen := make(map[string]bool, len(conns))
+	for _, conn := range conns {
+		seen[conn.ID] = true
+	}
+	var candidates []*Connection
+	h.mu.RLock()
+	for prefix, subs := range h.prefixSubscribers {
+		if !strings.HasPrefix(docID, prefix) {
+			continue
+		}
+		for connID := range subs {
+			if connID == excludeID || seen[connID] {
+				continue
+			}
+			if conn := h.connections[connID]; conn != nil {
+				seen[connID] = true
+				candidates = append(candidates, conn)
+			}
+		}
+	}
 	h.mu.RUnlock()
 
-	if subs == nil {
-		return
+	for _, conn := range candidates {
+		if h.mayReceive(conn, docID) {
+			conns = append(conns, conn)
+		}
 	}
+	return conns
+}
 
-	for connID := range subs {
-		if connID == senderID {
-			continue
+// unsubscribe drops conn's subscription to a document along with its
+// awareness state there. The caller syncs the relay. Must be called holding
+// conn.handleMu.
+func (h *Hub) unsubscribe(conn *Connection, docID string) {
+	// Remove subscription from connection
+	delete(conn.Subscriptions, docID)
+	delete(conn.ReadOnly, docID)
+	conn.divergence.reset(docID)
+
+	// Remove from document subscribers
+	h.mu.Lock()
+	left := false
+	if subs, exists := h.subscribers[docID]; exists {
+		left = subs[conn.ID]
+		delete(subs, conn.ID)
+		if len(subs) == 0 {
+			delete(h.subscribers, docID)
+		}
+	}
+	h.mu.Unlock()
+	if left {
+		h.publishSubscriber(EventSubscriberLeft, conn, docID)
+	}
+
+	// Clean up awareness for this connection on this document
+	if h.deleteAwareness(docID, conn.ClientID) {
+		h.announceAwarenessRemoved(docID, conn.ClientID)
+	}
+
+	// Remove from awareness subscriptions
+	delete(conn.AwarenessSubscriptions, docID)
+	conn.awarenessBases.forget(docID)
+}
+
+// removePrefixSubscriber drops a connection from a prefix subscription. Must
+// be called with h.mu held.
+func (h *Hub) removePrefixSubscriber(prefix, connID string) {
+	if subs, exists := h.prefixSubscribers[prefix]; exists {
+		delete(subs, connID)
+		if len(subs) == 0 {
+			delete(h.prefixSubscribers, prefix)
 		}
+	}
+}
+
+// allowDocumentCreation checks the creation quota of conn's user (or IP, for
+// anonymous connections) when docID does not exist yet, sending
+// DOCUMENT_QUOTA_EXCEEDED if it is used up
+func (h *Hub) allowDocumentCreation(conn *Connection, docID string) bool {
+	if conn.SecurityManager == nil {
+		return true
+	}
+	h.docsMu.RLock()
+	_, exists := h.documents[docID]
+	h.docsMu.RUnlock()
+	if exists {
+		return true
+	}
+
+	if ok, reason := conn.SecurityManager.CanCreateDocument(conn.ClientIP, conn.quotaUser()); !ok {
+		h.audit(conn, audit.EventQuotaExceeded, docID, map[string]interface{}{"reason": reason})
+		conn.Logger().Warn("Document quota exceeded", "doc_id", docID, "reason", reason)
+		conn.replyError(reason, "DOCUMENT_QUOTA_EXCEEDED")
+		return false
+	}
+	return true
+}
+
+// recordDocumentCreation counts a document conn created against its quota
+func (h *Hub) recordDocumentCreation(conn *Connection) {
+	if tenant := conn.Tenant(); tenant != "" {
+		h.metrics.tenantDocuments.With(tenant).Inc()
+	}
+	if conn.SecurityManager != nil {
+		conn.SecurityManager.RecordDocument(conn.ClientIP, conn.quotaUser())
+	}
+}
 
-		h.mu.RLock()
-		conn := h.connections[connID]
-		h.mu.RUnlock()
+// audit records a security event about conn
+func (h *Hub) audit(conn *Connection, eventType, docID string, details map[string]interface{}) {
+	h.opts.Audit.Log(audit.Event{
+		Type:         eventType,
+		Actor:        conn.VerifiedUserID(),
+		DocumentID:   docID,
+		ConnectionID: conn.ID,
+		IP:           conn.ClientIP,
+		Details:      details,
+	})
+}
+
+// canReadDocument applies the same access and permission checks as
+// subscribe. It may read grants from the ACL store, so it must not be
+// called holding h.mu or docsMu.
+func (h *Hub) canReadDocument(ctx context.Context, token *auth.TokenPayload, docID string) bool {
+	return h.opts.PublicDocuments.Allows(docID) && h.opts.ACL.CanRead(ctx, token, docID)
+}
 
-		if conn != nil {
-			conn.SendMessage(protocol.TypeAwarenessState, map[string]interface{}{
-				"type":      protocol.TypeAwarenessState,
-				"id":        generateID(),
-				"timestamp": time.Now().UnixMilli(),
-				"docId":     docID,
-				"clientId":  clientID,
-				"state":     state,
-			})
+// mayReceive is canReadDocument for broadcasts: it never waits on the ACL
+// store, deciding from token permissions and grants already cached
+func (h *Hub) mayReceive(conn *Connection, docID string) bool {
+	return h.opts.PublicDocuments.Allows(docID) && h.opts.ACL.CanReadCached(conn.TokenPayload, docID)
+}
+
+// documentsUnder returns the in-memory documents under prefix that sort
+// after cursor
+func (h *Hub) documentsUnder(prefix, cursor string) []string {
+	h.docsMu.RLock()
+	defer h.docsMu.RUnlock()
+	docIDs := make([]string, 0)
+	for docID := range h.documents {
+		if strings.HasPrefix(docID, prefix) && docID > cursor {
+			docIDs = append(docIDs, docID)
 		}
 	}
+	return docIDs
+}
+
+// readAccess is whether a token may read each document under a prefix
+type readAccess map[string]bool
+
+// resolveReadAccess loads token's grants and checks which documents under
+// prefix after cursor it may read. Checks may wait on the ACL store, so the
+// hub prepares prefix and list subscriptions with it off the Run goroutine.
+func (h *Hub) resolveReadAccess(ctx context.Context, token *auth.TokenPayload, prefix, cursor string) readAccess {
+	h.opts.ACL.Warm(ctx, token)
+	access := make(readAccess)
+	for _, docID := range h.documentsUnder(prefix, cursor) {
+		access[docID] = h.canReadDocument(ctx, token, docID)
+	}
+	return access
+}
+
+// readAccess returns the access prepared for a prefix or list subscription,
+// or resolves it for a message handled unprepared
+func (h *Hub) readAccess(ctx context.Context, conn *Connection, prep *prepared, prefix, cursor string) readAccess {
+	if prep != nil && prep.access != nil {
+		return prep.access
+	}
+	return h.resolveReadAccess(ctx, conn.TokenPayload, prefix, cursor)
 }
 
-func generateID() string {
-	b := make([]byte, 16)
-	rand.Read(b)
-	return hex.EncodeToString(b)
+// readableDocuments lists the documents under prefix after cursor that conn
+// may read, sorted. Documents created since access was resolved are judged
+// from the grants resolving it cached.
+func (h *Hub) readableDocuments(conn *Connection, access readAccess, prefix, cursor string) []string {
+	docIDs := h.documentsUnder(prefix, cursor)
+	readable := docIDs[:0]
+	for _, docID := range docIDs {
+		allowed, resolved := access[docID]
+		if !resolved {
+			allowed = h.mayReceive(conn, docID)
+		}
+		if allowed {
+			readable = append(readable, docID)
+		}
+	}
+	sort.Strings(readable)
+	return readable
 }
@@ -0,0 +1,2142 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+	gorilla "github.com/gorilla/websocket"
+)
+
+const testSecret = "this-is-a-test-secret-that-is-at-least-32-chars"
+
+// testAuth lets connections without a token in with full access
+var testAuth = AuthConfig{JWTSecret: testSecret}
+
+// --- Helpers ---
+
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	hub := NewHub(testAuth)
+	go hub.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		hub.Stop(ctx)
+	})
+	return hub
+}
+
+// newTestConnection creates a connection with no underlying socket. Messages
+// sent to it stay in its send channel where tests can inspect them.
+func newTestConnection(hub *Hub, id string) *Connection {
+	return NewConnection(id, nil, hub)
+}
+
+func dispatch(hub *Hub, conn *Connection, msgType string, payload map[string]interface{}) {
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	payload["type"] = msgType
+	hub.HandleMessage <- hub.newEvent(context.Background(), conn, &protocol.Message{
+		Type:    msgType,
+		ID:      protocol.NewID(),
+		Payload: payload,
+	})
+}
+
+// expectMessage waits for the next queued message of the given type, skipping others.
+func expectMessage(t testing.TB, conn *Connection, msgType string) *protocol.Message {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case data := <-conn.send:
+			msg, err := protocol.DecodeMessage(data)
+			if err != nil {
+				t.Fatalf("DecodeMessage failed: %v", err)
+			}
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for %q on connection %s", msgType, conn.ID)
+			return nil
+		}
+	}
+}
+
+// connectAnonymous registers a connection and authenticates it with auth disabled.
+func connectAnonymous(t *testing.T, hub *Hub, id string) *Connection {
+	t.Helper()
+	conn := newTestConnection(hub, id)
+	hub.Register <- conn
+	dispatch(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "user-" + id})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	return conn
+}
+
+func subscribe(t *testing.T, hub *Hub, conn *Connection, docID string) {
+	t.Helper()
+	dispatch(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": docID})
+	expectMessage(t, conn, protocol.TypeSyncResponse)
+}
+
+// flushHub waits until every event sent before it has been processed,
+// including messages handed to workers.
+func flushHub(t *testing.T, hub *Hub) {
+	t.Helper()
+	if !hub.exec(hub.drainMessages) {
+		t.Fatal("flushHub: hub is stopped")
+	}
+}
+
+// --- Connection shutdown ---
+
+func TestConnection_SendAfterClose(t *testing.T) {
+	conn := newTestConnection(nil, "c1")
+	conn.Close()
+	conn.Close() // idempotent
+
+	if err := conn.SendMessage(protocol.TypePong, map[string]interface{}{}); err != ErrConnectionClosed {
+		t.Errorf("SendMessage after Close = %v, want ErrConnectionClosed", err)
+	}
+	if !conn.IsClosed() {
+		t.Error("Expected connection to report closed")
+	}
+}
+
+func TestHub_UnregisterClosesConnection(t *testing.T) {
+	hub := newTestHub(t)
+
+	conn := connectAnonymous(t, hub, "c1")
+	hub.Unregister <- conn
+	flushHub(t, hub)
+
+	if !conn.IsClosed() {
+		t.Error("Expected unregistered connection to be closed")
+	}
+	select {
+	case <-conn.done:
+	default:
+		t.Error("Expected done channel to be closed")
+	}
+}
+
+func TestHub_ConcurrentUnregisterDuringBroadcast(t *testing.T) {
+	hub := newTestHub(t)
+	const docID = "room:stress"
+
+	const churn = 200
+	const broadcasters = 4
+	const deltasPerBroadcaster = 2000
+
+	var wg sync.WaitGroup
+	conns := make([]*Connection, churn)
+
+	// Connections join, subscribe, and leave while deltas are in flight
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < churn; i++ {
+			conn := newTestConnection(hub, fmt.Sprintf("churn-%d", i))
+			conns[i] = conn
+			hub.Register <- conn
+			dispatch(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "churn"})
+			dispatch(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": docID})
+			hub.Unregister <- conn
+		}
+	}()
+
+	delta := map[string]interface{}{
+		"type":    protocol.TypeDelta,
+		"docId":   docID,
+		"changes": map[string]interface{}{"k": "v"},
+	}
+	for b := 0; b < broadcasters; b++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < deltasPerBroadcaster; i++ {
+				hub.broadcastDelta(docID, delta, nil, "")
+			}
+		}()
+	}
+
+	wg.Wait()
+	flushHub(t, hub)
+
+	for i, conn := range conns {
+		if !conn.IsClosed() {
+			t.Errorf("connection %d was not closed after unregister", i)
+		}
+	}
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	if len(hub.subscribers[docID]) != 0 {
+		t.Errorf("subscribers = %d, want 0", len(hub.subscribers[docID]))
+	}
+}
+
+// --- Delta batches ---
+
+// handleDirect runs a message through the hub handler synchronously, without Run.
+func handleDirect(hub *Hub, conn *Connection, msgType string, payload map[string]interface{}) {
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	payload["type"] = msgType
+	hub.handleMessage(context.Background(), conn, &protocol.Message{Type: msgType, ID: protocol.NewID(), Payload: payload})
+}
+
+// joinDirect registers, authenticates and subscribes a connection without Run.
+func joinDirect(t testing.TB, hub *Hub, id string, docIDs ...string) *Connection {
+	t.Helper()
+	conn := newTestConnection(hub, id)
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "user-" + id})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	for _, docID := range docIDs {
+		handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": docID})
+		expectMessage(t, conn, protocol.TypeSyncResponse)
+	}
+	return conn
+}
+
+func TestHub_DeltaBatchSlowRecipientDoesNotBlockOtherDocuments(t *testing.T) {
+	hub := NewHub(testAuth)
+
+	writerA := joinDirect(t, hub, "writer-a", "room:a")
+	slow := joinDirect(t, hub, "slow", "room:a")
+	writerB := joinDirect(t, hub, "writer-b", "room:b")
+
+	// Hold the slow recipient's lock so any send to it blocks
+	slow.mu.Lock()
+
+	batchDone := make(chan struct{})
+	go func() {
+		defer close(batchDone)
+		handleDirect(hub, writerA, protocol.TypeDeltaBatch, map[string]interface{}{
+			"docId": "room:a",
+			"deltas": []interface{}{
+				map[string]interface{}{"changes": map[string]interface{}{"x": 1.0}},
+			},
+		})
+	}()
+
+	// Give the batch time to reach the blocked broadcast
+	time.Sleep(50 * time.Millisecond)
+
+	deltaDone := make(chan struct{})
+	go func() {
+		defer close(deltaDone)
+		handleDirect(hub, writerB, protocol.TypeDelta, map[string]interface{}{
+			"docId":   "room:b",
+			"changes": map[string]interface{}{"y": 2.0},
+		})
+	}()
+
+	select {
+	case <-deltaDone:
+	case <-time.After(time.Second):
+		slow.mu.Unlock()
+		t.Fatal("delta on another document was blocked by a slow batch recipient")
+	}
+
+	slow.mu.Unlock()
+	<-batchDone
+	expectMessage(t, slow, protocol.TypeDelta)
+}
+
+func TestHub_DeltaBatchPreservesOrder(t *testing.T) {
+	hub := NewHub(testAuth)
+
+	writer := joinDirect(t, hub, "writer", "room:order")
+	reader := joinDirect(t, hub, "reader", "room:order")
+
+	handleDirect(hub, writer, protocol.TypeDeltaBatch, map[string]interface{}{
+		"docId": "room:order",
+		"deltas": []interface{}{
+			map[string]interface{}{"seq": 1.0, "changes": map[string]interface{}{"k": "first"}},
+			map[string]interface{}{"seq": 2.0, "changes": map[string]interface{}{"k": "second"}},
+			map[string]interface{}{"seq": 3.0, "changes": map[string]interface{}{"k": "third"}},
+		},
+	})
+
+	for want := 1.0; want <= 3; want++ {
+		msg := expectMessage(t, reader, protocol.TypeDelta)
+		if msg.Payload["seq"] != want {
+			t.Errorf("delta seq = %v, want %v", msg.Payload["seq"], want)
+		}
+	}
+
+	ack := expectMessage(t, writer, protocol.TypeAck)
+	if ack.Payload["count"] != 3.0 {
+		t.Errorf("ack count = %v, want 3", ack.Payload["count"])
+	}
+
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	if hub.documents["room:order"]["k"] != "third" {
+		t.Errorf("document k = %v, want %q", hub.documents["room:order"]["k"], "third")
+	}
+}
+
+// --- Shutdown ---
+
+// startFakePump stands in for ReadPump: it leaves the hub once the connection
+// is closed and tracks itself in live.
+func startFakePump(conn *Connection, live *int64) {
+	atomic.AddInt64(live, 1)
+	go func() {
+		defer atomic.AddInt64(live, -1)
+		<-conn.done
+		conn.leaveHub()
+	}()
+}
+
+func TestHub_StopDrainsConnections(t *testing.T) {
+	hub := NewHub(testAuth)
+	go hub.Run()
+
+	const n = 50
+	var live int64
+	for i := 0; i < n; i++ {
+		conn := newTestConnection(hub, fmt.Sprintf("conn-%d", i))
+		hub.Register <- conn
+		startFakePump(conn, &live)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := hub.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&live) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := atomic.LoadInt64(&live); got != 0 {
+		t.Errorf("leaked pump goroutines = %d, want 0", got)
+	}
+	if hub.ConnectionCount() != 0 {
+		t.Errorf("ConnectionCount = %d, want 0", hub.ConnectionCount())
+	}
+
+	// Leaving after the hub has stopped must not block
+	late := newTestConnection(hub, "late")
+	done := make(chan struct{})
+	go func() {
+		late.leaveHub()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("leaveHub blocked after hub stopped")
+	}
+}
+
+func TestHub_StopHonorsContextDeadline(t *testing.T) {
+	hub := NewHub(testAuth)
+	go hub.Run()
+
+	// A connection whose pump never leaves
+	hub.Register <- newTestConnection(hub, "stuck")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := hub.Stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Stop = %v, want context.DeadlineExceeded", err)
+	}
+
+	select {
+	case <-hub.Done():
+	default:
+		t.Error("Expected hub to be stopped after Stop returns")
+	}
+}
+
+func TestHub_RegisterWhileStoppingClosesConnection(t *testing.T) {
+	hub := NewHub(testAuth)
+	hub.mu.Lock()
+	hub.stopping = true
+	hub.mu.Unlock()
+
+	conn := newTestConnection(hub, "c1")
+	hub.register(conn)
+
+	if !conn.IsClosed() {
+		t.Error("Expected connection registered during shutdown to be closed")
+	}
+	if hub.ConnectionCount() != 0 {
+		t.Errorf("ConnectionCount = %d, want 0", hub.ConnectionCount())
+	}
+}
+
+// --- Subscription limits ---
+
+// newLimitedHub creates a hub (without Run) enforcing custom limits
+func newLimitedHub(limits security.Limits) *Hub {
+	return NewHubWithOptions(testAuth, HubOptions{Limits: limits})
+}
+
+func expectError(t *testing.T, conn *Connection, code string) {
+	t.Helper()
+	msg := expectMessage(t, conn, protocol.TypeError)
+	if msg.Payload["code"] != code {
+		t.Errorf("error code = %v, want %q", msg.Payload["code"], code)
+	}
+}
+
+func TestHub_MaxSubscriptionsPerConnection(t *testing.T) {
+	hub := newLimitedHub(security.Limits{MaxSubscriptionsPerConnection: 2})
+
+	conn := joinDirect(t, hub, "c1", "room:1", "room:2")
+
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:3"})
+	expectError(t, conn, "SUBSCRIPTION_LIMIT")
+
+	// Re-subscribing to an existing document does not count against the limit
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:2"})
+	expectMessage(t, conn, protocol.TypeSyncResponse)
+
+	// Unsubscribing frees a slot
+	handleDirect(hub, conn, protocol.TypeUnsubscribe, map[string]interface{}{"docId": "room:1"})
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:3"})
+	expectMessage(t, conn, protocol.TypeSyncResponse)
+
+	if len(conn.Subscriptions) != 2 {
+		t.Errorf("Subscriptions = %d, want 2", len(conn.Subscriptions))
+	}
+}
+
+func TestHub_MaxSubscribersPerDocument(t *testing.T) {
+	hub := newLimitedHub(security.Limits{MaxSubscribersPerDocument: 2})
+
+	first := joinDirect(t, hub, "c1", "room:full")
+	joinDirect(t, hub, "c2", "room:full")
+	third := joinDirect(t, hub, "c3")
+
+	handleDirect(hub, third, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:full"})
+	expectError(t, third, "DOCUMENT_FULL")
+	if third.Subscriptions["room:full"] {
+		t.Error("Rejected subscription should not be recorded on the connection")
+	}
+
+	// Disconnecting a subscriber frees a slot
+	hub.unregister(first)
+	handleDirect(hub, third, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:full"})
+	expectMessage(t, third, protocol.TypeSyncResponse)
+
+	if n := len(hub.subscribers["room:full"]); n != 2 {
+		t.Errorf("subscribers = %d, want 2", n)
+	}
+}
+
+// --- Duplicate client IDs ---
+
+func authAs(t *testing.T, hub *Hub, id, userID, clientID string) *Connection {
+	t.Helper()
+	conn := newTestConnection(hub, id)
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": userID, "clientId": clientID})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	return conn
+}
+
+func connectionsWithClientID(hub *Hub, clientID string) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	n := 0
+	for _, c := range hub.connections {
+		if c.ClientID == clientID {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHub_KickDuplicateClient(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{KickDuplicateClients: true})
+
+	first := authAs(t, hub, "c1", "alice", "tab-1")
+	handleDirect(hub, first, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:dup"})
+	expectMessage(t, first, protocol.TypeSyncResponse)
+
+	second := authAs(t, hub, "c2", "alice", "tab-1")
+
+	expectError(t, first, "SESSION_REPLACED")
+	if !first.IsClosed() {
+		t.Error("Expected replaced connection to be closed")
+	}
+	if n := connectionsWithClientID(hub, "tab-1"); n != 1 {
+		t.Errorf("connections with clientId = %d, want 1", n)
+	}
+	if !second.Subscriptions["room:dup"] {
+		t.Error("Expected subscriptions to move to the new connection")
+	}
+	if subs := hub.subscribers["room:dup"]; len(subs) != 1 || !subs[second.ID] {
+		t.Errorf("subscribers = %v, want only %s", subs, second.ID)
+	}
+}
+
+func TestHub_DuplicateClientAllowedByDefault(t *testing.T) {
+	hub := NewHub(testAuth)
+
+	first := authAs(t, hub, "c1", "alice", "tab-1")
+	authAs(t, hub, "c2", "alice", "tab-1")
+
+	if first.IsClosed() {
+		t.Error("Expected first connection to stay open when kicking is disabled")
+	}
+	if n := connectionsWithClientID(hub, "tab-1"); n != 2 {
+		t.Errorf("connections with clientId = %d, want 2", n)
+	}
+}
+
+func TestHub_DuplicateClientDifferentUserNotKicked(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{KickDuplicateClients: true})
+
+	first := authAs(t, hub, "c1", "alice", "tab-1")
+	authAs(t, hub, "c2", "bob", "tab-1")
+
+	if first.IsClosed() {
+		t.Error("Connections of different users must not replace each other")
+	}
+}
+
+// --- Resume ---
+
+func sendDelta(hub *Hub, conn *Connection, docID, key string, value interface{}) {
+	handleDirect(hub, conn, protocol.TypeDelta, map[string]interface{}{
+		"docId":   docID,
+		"changes": map[string]interface{}{key: value},
+	})
+}
+
+func TestHub_ResumeReplaysMissedDeltas(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer", "room:resume")
+
+	sendDelta(hub, writer, "room:resume", "a", 1.0)
+	ack := expectMessage(t, writer, protocol.TypeAck)
+	if ack.Payload["seq"] != 1.0 {
+		t.Fatalf("ack seq = %v, want 1", ack.Payload["seq"])
+	}
+	sendDelta(hub, writer, "room:resume", "b", 2.0)
+	sendDelta(hub, writer, "room:resume", "c", 3.0)
+
+	reader := joinDirect(t, hub, "reader")
+	handleDirect(hub, reader, protocol.TypeSubscribe, map[string]interface{}{
+		"docId":      "room:resume",
+		"resumeFrom": map[string]interface{}{"room:resume": 1.0},
+	})
+	resp := expectMessage(t, reader, protocol.TypeSyncResponse)
+
+	if resp.Payload["resumed"] != true {
+		t.Fatalf("expected resumed response, got %v", resp.Payload)
+	}
+	if resp.Payload["seq"] != 3.0 {
+		t.Errorf("seq = %v, want 3", resp.Payload["seq"])
+	}
+	if _, hasState := resp.Payload["state"]; hasState {
+		t.Error("Resumed response should not carry full state")
+	}
+	deltas, _ := resp.Payload["deltas"].([]interface{})
+	if len(deltas) != 2 {
+		t.Fatalf("deltas = %d, want 2", len(deltas))
+	}
+	if seq := deltas[0].(map[string]interface{})["seq"]; seq != 2.0 {
+		t.Errorf("first replayed seq = %v, want 2", seq)
+	}
+}
+
+func TestHub_ResumeGapTooLargeFallsBackToState(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{ResumeBufferSize: 2})
+	writer := joinDirect(t, hub, "writer", "room:gap")
+
+	for i := 0; i < 5; i++ {
+		sendDelta(hub, writer, "room:gap", fmt.Sprintf("k%d", i), float64(i))
+	}
+
+	reader := joinDirect(t, hub, "reader")
+	handleDirect(hub, reader, protocol.TypeSubscribe, map[string]interface{}{
+		"docId":      "room:gap",
+		"resumeFrom": map[string]interface{}{"room:gap": 1.0},
+	})
+	resp := expectMessage(t, reader, protocol.TypeSyncResponse)
+
+	if resp.Payload["resumed"] == true {
+		t.Fatal("Expected fallback to full state when the gap is not buffered")
+	}
+	state, _ := resp.Payload["state"].(map[string]interface{})
+	if len(state) != 5 {
+		t.Errorf("state keys = %d, want 5", len(state))
+	}
+	if resp.Payload["seq"] != 5.0 {
+		t.Errorf("seq = %v, want 5", resp.Payload["seq"])
+	}
+}
+
+func TestHub_BroadcastDeltasCarrySeq(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer", "room:seq")
+	reader := joinDirect(t, hub, "reader", "room:seq")
+
+	handleDirect(hub, writer, protocol.TypeDeltaBatch, map[string]interface{}{
+		"docId": "room:seq",
+		"deltas": []interface{}{
+			map[string]interface{}{"changes": map[string]interface{}{"a": 1.0}},
+			map[string]interface{}{"changes": map[string]interface{}{"b": 2.0}},
+		},
+	})
+
+	for want := 1.0; want <= 2; want++ {
+		if msg := expectMessage(t, reader, protocol.TypeDelta); msg.Payload["seq"] != want {
+			t.Errorf("delta seq = %v, want %v", msg.Payload["seq"], want)
+		}
+	}
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["seq"] != 2.0 {
+		t.Errorf("ack seq = %v, want 2", ack.Payload["seq"])
+	}
+}
+
+func TestHub_ForwardedDeltasGetFreshIDs(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer", "room:ids")
+	readers := make([]*Connection, 20)
+	for i := range readers {
+		readers[i] = joinDirect(t, hub, fmt.Sprintf("reader-%d", i), "room:ids")
+	}
+
+	send := func(id string) {
+		hub.handleMessage(context.Background(), writer, &protocol.Message{Type: protocol.TypeDelta, ID: id, Payload: map[string]interface{}{
+			"type":    protocol.TypeDelta,
+			"id":      id,
+			"origin":  "forged",
+			"docId":   "room:ids",
+			"changes": map[string]interface{}{"title": id},
+		}})
+	}
+
+	seen := map[string]bool{}
+	for _, clientID := range []string{"d1", "d2"} {
+		send(clientID)
+		ack := expectMessage(t, writer, protocol.TypeAck)
+		if ack.Origin != clientID || ack.ID == clientID || seen[ack.ID] {
+			t.Errorf("ack id %q origin %q, want a fresh id and origin %q", ack.ID, ack.Origin, clientID)
+		}
+		seen[ack.ID] = true
+
+		// One delta, one ID, whoever receives it
+		var deltaID string
+		for _, reader := range readers {
+			msg := expectMessage(t, reader, protocol.TypeDelta)
+			if msg.Origin != clientID {
+				t.Errorf("%s: delta origin = %q, want %q", reader.ID, msg.Origin, clientID)
+			}
+			if deltaID == "" {
+				deltaID = msg.ID
+			} else if msg.ID != deltaID {
+				t.Errorf("%s: delta id = %q, other recipients got %q", reader.ID, msg.ID, deltaID)
+			}
+		}
+		if deltaID == "" || deltaID == clientID || seen[deltaID] {
+			t.Errorf("delta id %q is not fresh", deltaID)
+		}
+		seen[deltaID] = true
+	}
+
+	// Resuming replays the delta under the ID it was broadcast with
+	late := joinDirect(t, hub, "late")
+	handleDirect(hub, late, protocol.TypeSubscribe, map[string]interface{}{
+		"docId":      "room:ids",
+		"resumeFrom": map[string]interface{}{"room:ids": 1.0},
+	})
+	resp := expectMessage(t, late, protocol.TypeSyncResponse)
+	deltas, _ := resp.Payload["deltas"].([]interface{})
+	if len(deltas) != 1 {
+		t.Fatalf("resumed deltas = %v, want 1", deltas)
+	}
+	replayed := deltas[0].(map[string]interface{})
+	if replayed["origin"] != "d2" || !seen[replayed["id"].(string)] {
+		t.Errorf("replayed delta id %v origin %v, want the broadcast id and origin d2", replayed["id"], replayed["origin"])
+	}
+}
+
+// --- Ordered delivery ---
+
+func TestHub_ConcurrentDeltasDeliveredInSequenceOrder(t *testing.T) {
+	hub := NewHub(testAuth)
+	const docID = "room:ordered"
+	const writers = 8
+	const deltasPerWriter = 125 // 1000 total
+
+	writerConns := make([]*Connection, writers)
+	for i := range writerConns {
+		writerConns[i] = joinDirect(t, hub, fmt.Sprintf("writer-%d", i))
+	}
+	readers := []*Connection{
+		joinDirect(t, hub, "reader-1", docID),
+		joinDirect(t, hub, "reader-2", docID),
+	}
+
+	// Drain each reader concurrently, recording the sequence numbers seen
+	seen := make([][]int64, len(readers))
+	stop := make(chan struct{})
+	var drained sync.WaitGroup
+	for i, reader := range readers {
+		drained.Add(1)
+		go func(i int, reader *Connection) {
+			defer drained.Done()
+			for {
+				select {
+				case data := <-reader.send:
+					msg, err := protocol.DecodeMessage(data)
+					if err == nil && msg.Type == protocol.TypeDelta {
+						seen[i] = append(seen[i], int64(msg.Payload["seq"].(float64)))
+					}
+				case <-stop:
+					return
+				}
+			}
+		}(i, reader)
+	}
+
+	var wg sync.WaitGroup
+	for w, writer := range writerConns {
+		wg.Add(1)
+		go func(w int, writer *Connection) {
+			defer wg.Done()
+			for i := 0; i < deltasPerWriter; i++ {
+				sendDelta(hub, writer, docID, fmt.Sprintf("w%d", w), float64(i))
+			}
+		}(w, writer)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(readers[0].send)+len(readers[1].send) > 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	drained.Wait()
+
+	for i, seqs := range seen {
+		if len(seqs) == 0 {
+			t.Fatalf("reader %d received no deltas", i)
+		}
+		for j := 1; j < len(seqs); j++ {
+			if seqs[j] <= seqs[j-1] {
+				t.Fatalf("reader %d: seq %d followed %d", i, seqs[j], seqs[j-1])
+			}
+		}
+		if last := seqs[len(seqs)-1]; last > writers*deltasPerWriter {
+			t.Errorf("reader %d: last seq = %d, want <= %d", i, last, writers*deltasPerWriter)
+		}
+	}
+}
+
+// --- ACK contents ---
+
+func TestHub_DeltaAckCarriesClockAndSeq(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := authAs(t, hub, "writer", "alice", "client-a")
+
+	handleDirect(hub, writer, protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:ack",
+		"changes": map[string]interface{}{"title": "hello"},
+		"clock":   map[string]interface{}{"client-a": 3.0, "client-b": 7.0},
+	})
+	ack := expectMessage(t, writer, protocol.TypeAck)
+
+	if ack.Payload["status"] != "applied" {
+		t.Errorf("status = %v, want applied", ack.Payload["status"])
+	}
+	if ack.Payload["seq"] != 1.0 {
+		t.Errorf("seq = %v, want 1", ack.Payload["seq"])
+	}
+	clock, _ := ack.Payload["clock"].(map[string]interface{})
+	if clock["client-a"] != 3.0 || clock["client-b"] != 7.0 {
+		t.Errorf("clock = %v, want client-a:3 client-b:7", clock)
+	}
+
+	// Without a client clock the server advances the sender's entry
+	sendDelta(hub, writer, "room:ack", "title", "again")
+	ack = expectMessage(t, writer, protocol.TypeAck)
+	clock, _ = ack.Payload["clock"].(map[string]interface{})
+	if clock["client-a"] != 4.0 {
+		t.Errorf("clock[client-a] = %v, want 4", clock["client-a"])
+	}
+	if ack.Payload["seq"] != 2.0 {
+		t.Errorf("seq = %v, want 2", ack.Payload["seq"])
+	}
+}
+
+func TestHub_DeltaBatchAckReportsPerDeltaStatus(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := authAs(t, hub, "writer", "alice", "client-a")
+	reader := joinDirect(t, hub, "reader", "room:mixed")
+
+	handleDirect(hub, writer, protocol.TypeDeltaBatch, map[string]interface{}{
+		"docId": "room:mixed",
+		"deltas": []interface{}{
+			map[string]interface{}{"timestamp": 2000.0, "changes": map[string]interface{}{"a": "new"}},
+			"not-a-delta",
+			map[string]interface{}{"timestamp": 1000.0, "changes": map[string]interface{}{"a": "old"}},
+			map[string]interface{}{"timestamp": 1000.0, "changes": map[string]interface{}{"a": "old", "b": "fresh"}},
+		},
+	})
+	ack := expectMessage(t, writer, protocol.TypeAck)
+
+	results, _ := ack.Payload["results"].([]interface{})
+	if len(results) != 4 {
+		t.Fatalf("results = %d, want 4", len(results))
+	}
+	want := []struct {
+		status string
+		reason string
+		seq    float64
+	}{
+		{"applied", "", 1},
+		{"rejected", RejectInvalid, 0},
+		{"rejected", RejectStale, 0},
+		{"applied", "", 2},
+	}
+	for i, w := range want {
+		r := results[i].(map[string]interface{})
+		if r["index"] != float64(i) || r["status"] != w.status {
+			t.Errorf("result %d = %v, want status %s", i, r, w.status)
+		}
+		if w.reason != "" && r["reason"] != w.reason {
+			t.Errorf("result %d reason = %v, want %s", i, r["reason"], w.reason)
+		}
+		if w.seq != 0 && r["seq"] != w.seq {
+			t.Errorf("result %d seq = %v, want %v", i, r["seq"], w.seq)
+		}
+	}
+	if ack.Payload["seq"] != 2.0 {
+		t.Errorf("ack seq = %v, want 2", ack.Payload["seq"])
+	}
+	clock, _ := ack.Payload["clock"].(map[string]interface{})
+	if clock["client-a"] != 2.0 {
+		t.Errorf("clock[client-a] = %v, want 2", clock["client-a"])
+	}
+
+	// The stale field is dropped from the broadcast of a partially applied delta
+	expectMessage(t, reader, protocol.TypeDelta)
+	partial := expectMessage(t, reader, protocol.TypeDelta)
+	changes, _ := partial.Payload["changes"].(map[string]interface{})
+	if _, has := changes["a"]; has || changes["b"] != "fresh" {
+		t.Errorf("broadcast changes = %v, want only b", changes)
+	}
+
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	if hub.documents["room:mixed"]["a"] != "new" {
+		t.Errorf("document a = %v, want %q", hub.documents["room:mixed"]["a"], "new")
+	}
+}
+
+func TestHub_FutureTimestampsAreClamped(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer", "room:skew")
+	reader := joinDirect(t, hub, "reader", "room:skew")
+
+	// A delta dated a year ahead is recorded at the skew limit instead
+	handleDirect(hub, writer, protocol.TypeDelta, map[string]interface{}{
+		"docId":     "room:skew",
+		"timestamp": float64(time.Now().AddDate(1, 0, 0).UnixMilli()),
+		"changes":   map[string]interface{}{"title": "future"},
+	})
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["status"] != "applied" {
+		t.Fatalf("ack = %v, want applied", ack.Payload)
+	}
+	latest := float64(time.Now().Add(MaxClockSkew).UnixMilli())
+	if ts, _ := expectMessage(t, reader, protocol.TypeDelta).Payload["timestamp"].(float64); ts <= 0 || ts > latest {
+		t.Errorf("broadcast timestamp = %v, want at most %v", ts, latest)
+	}
+
+	// So a write made once the skew has passed still wins
+	handleDirect(hub, writer, protocol.TypeDelta, map[string]interface{}{
+		"docId":     "room:skew",
+		"timestamp": float64(time.Now().Add(2 * MaxClockSkew).UnixMilli()),
+		"changes":   map[string]interface{}{"title": "later"},
+	})
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["status"] != "applied" {
+		t.Errorf("ack = %v, want the later write applied", ack.Payload)
+	}
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	if title := hub.documents["room:skew"]["title"]; title != "later" {
+		t.Errorf("title = %v, want later", title)
+	}
+}
+
+// --- Read-only subscriptions ---
+
+func authWithToken(t *testing.T, hub *Hub, id string, perms auth.DocumentPermissions) *Connection {
+	t.Helper()
+	token, err := auth.GenerateAccessToken("user-"+id, "", perms, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken failed: %v", err)
+	}
+	conn := newTestConnection(hub, id)
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": token})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	return conn
+}
+
+func TestHub_ReadOnlyTokenDowngradedOnSubscribe(t *testing.T) {
+	hub := NewHub(testAuth)
+	viewer := authWithToken(t, hub, "viewer", auth.CreateUserPermissions([]string{"room:view"}, nil))
+
+	handleDirect(hub, viewer, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:view"})
+	resp := expectMessage(t, viewer, protocol.TypeSyncResponse)
+	if resp.Payload["mode"] != ModeRead || resp.Payload["readOnly"] != true {
+		t.Errorf("mode = %v readOnly = %v, want read/true", resp.Payload["mode"], resp.Payload["readOnly"])
+	}
+
+	sendDelta(hub, viewer, "room:view", "k", "v")
+	expectError(t, viewer, "READ_ONLY")
+
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	if hub.documents["room:view"] != nil {
+		t.Error("Rejected delta should not touch the document")
+	}
+}
+
+func TestHub_ExplicitReadModeRejectsDeltas(t *testing.T) {
+	hub := NewHub(testAuth)
+	editor := authWithToken(t, hub, "editor", auth.CreateUserPermissions([]string{"*"}, []string{"*"}))
+
+	handleDirect(hub, editor, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:r", "mode": "read"})
+	resp := expectMessage(t, editor, protocol.TypeSyncResponse)
+	if resp.Payload["readOnly"] != true {
+		t.Errorf("readOnly = %v, want true", resp.Payload["readOnly"])
+	}
+
+	handleDirect(hub, editor, protocol.TypeDeltaBatch, map[string]interface{}{
+		"docId":  "room:r",
+		"deltas": []interface{}{map[string]interface{}{"changes": map[string]interface{}{"k": "v"}}},
+	})
+	expectError(t, editor, "READ_ONLY")
+
+	// Re-subscribing in write mode lifts the restriction
+	handleDirect(hub, editor, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:r"})
+	resp = expectMessage(t, editor, protocol.TypeSyncResponse)
+	if resp.Payload["mode"] != ModeWrite {
+		t.Errorf("mode = %v, want write", resp.Payload["mode"])
+	}
+	sendDelta(hub, editor, "room:r", "k", "v")
+	if ack := expectMessage(t, editor, protocol.TypeAck); ack.Payload["status"] != "applied" {
+		t.Errorf("ack status = %v, want applied", ack.Payload["status"])
+	}
+}
+
+// recordingAudit collects audit events for assertions
+type recordingAudit struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (r *recordingAudit) Log(event audit.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingAudit) ofType(eventType string) []audit.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []audit.Event
+	for _, e := range r.events {
+		if e.Type == eventType {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func TestHub_AuditsDeniedDelta(t *testing.T) {
+	rec := &recordingAudit{}
+	hub := NewHubWithOptions(testAuth, HubOptions{Audit: rec})
+	viewer := authWithToken(t, hub, "viewer", auth.CreateUserPermissions([]string{"room:audit"}, nil))
+	viewer.ClientIP = "10.5.0.1"
+
+	sendDelta(hub, viewer, "room:audit", "k", "v")
+	expectError(t, viewer, "PERMISSION_DENIED")
+
+	events := rec.ofType(audit.EventPermissionDenied)
+	if len(events) != 1 {
+		t.Fatalf("permission_denied events = %v, want 1", events)
+	}
+	e := events[0]
+	if e.Actor != "user-viewer" || e.DocumentID != "room:audit" || e.ConnectionID != viewer.ID || e.IP != "10.5.0.1" {
+		t.Errorf("event = %+v, want actor user-viewer, room:audit, %s, 10.5.0.1", e, viewer.ID)
+	}
+	if e.Details["action"] != protocol.TypeDelta {
+		t.Errorf("action = %v, want %s", e.Details["action"], protocol.TypeDelta)
+	}
+}
+
+func TestHub_AuditsAuthFailure(t *testing.T) {
+	rec := &recordingAudit{}
+	hub := NewHubWithOptions(testAuth, HubOptions{Audit: rec})
+	conn := newTestConnection(hub, "c1")
+	hub.register(conn)
+
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": "not-a-token"})
+	expectMessage(t, conn, protocol.TypeAuthError)
+
+	events := rec.ofType(audit.EventAuthFailure)
+	if len(events) != 1 || events[0].Details["code"] != "INVALID_TOKEN" {
+		t.Errorf("auth_failure events = %v, want one with INVALID_TOKEN", events)
+	}
+}
+
+func TestHub_AuthRequired(t *testing.T) {
+	// Required refuses connections without a token
+	hub := NewHub(AuthConfig{JWTSecret: testSecret, Required: true})
+	conn := newTestConnection(hub, "c1")
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{})
+	if msg := expectMessage(t, conn, protocol.TypeAuthError); msg.Payload["code"] != "AUTH_REQUIRED" {
+		t.Errorf("auth_error = %v, want AUTH_REQUIRED", msg.Payload)
+	}
+	if conn.Authenticated {
+		t.Error("connection without a token authenticated while auth is required")
+	}
+
+	// Tokens are still accepted
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": shortToken(t, "c1", auth.CreateUserPermissions([]string{"*"}, nil), time.Hour)})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+
+	// Otherwise they get full access under the user ID they claim
+	hub = NewHub(AuthConfig{JWTSecret: testSecret})
+	conn = newTestConnection(hub, "c2")
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "user-c2"})
+	if msg := expectMessage(t, conn, protocol.TypeAuthSuccess); msg.Payload["userId"] != "user-c2" {
+		t.Errorf("userId = %v, want user-c2", msg.Payload["userId"])
+	}
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:open"})
+	expectMessage(t, conn, protocol.TypeSyncResponse)
+	sendDelta(hub, conn, "room:open", "title", "hello")
+	expectMessage(t, conn, protocol.TypeAck)
+}
+
+func TestHub_AnonymousReadOnly(t *testing.T) {
+	hub := NewHub(AuthConfig{JWTSecret: testSecret, Required: true, AnonymousRead: true})
+	reader := newTestConnection(hub, "reader")
+	hub.register(reader)
+	handleDirect(hub, reader, protocol.TypeAuth, map[string]interface{}{"userId": "user-admin"})
+	if msg := expectMessage(t, reader, protocol.TypeAuthSuccess); msg.Payload["userId"] != "anonymous" {
+		t.Errorf("anonymous reader authenticated as %v, want anonymous", msg.Payload["userId"])
+	}
+
+	handleDirect(hub, reader, protocol.TypeSubscribe, map[string]interface{}{"docId": security.PlaygroundDocID})
+	expectMessage(t, reader, protocol.TypeSyncResponse)
+	if !reader.ReadOnly[security.PlaygroundDocID] {
+		t.Error("anonymous subscription should be read-only")
+	}
+
+	sendDelta(hub, reader, security.PlaygroundDocID, "title", "defaced")
+	expectError(t, reader, "READ_ONLY")
+	sendDelta(hub, reader, "room:lobby", "title", "defaced")
+	expectError(t, reader, "PERMISSION_DENIED")
+
+	handleDirect(hub, reader, protocol.TypeSubscribe, map[string]interface{}{"docId": "private-notes"})
+	expectError(t, reader, "ACCESS_DENIED")
+}
+
+func TestHub_CompressesLargeMessagesForClientsThatAsk(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{CompressionThreshold: 1024})
+	writer := joinDirect(t, hub, "writer", "room:large")
+	changes := make(map[string]interface{})
+	for i := 0; i < 200; i++ {
+		changes[fmt.Sprintf("block-%03d", i)] = strings.Repeat("lorem ipsum ", 10)
+	}
+	handleDirect(hub, writer, protocol.TypeDelta, map[string]interface{}{"docId": "room:large", "changes": changes})
+	expectMessage(t, writer, protocol.TypeAck)
+
+	// nextRaw returns the next queued message still encoded
+	nextRaw := func(conn *Connection) []byte {
+		t.Helper()
+		select {
+		case data := <-conn.send:
+			return data
+		case <-time.After(2 * time.Second):
+			t.Fatal("no message queued")
+			return nil
+		}
+	}
+	sizes := make(map[string]int)
+	for _, compression := range []string{"", "deflate"} {
+		conn := newTestConnection(hub, "reader-"+compression)
+		hub.register(conn)
+		handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "user-reader", "compression": compression})
+		if msg := expectMessage(t, conn, protocol.TypeAuthSuccess); (msg.Payload["compression"] == "deflate") != (compression == "deflate") {
+			t.Errorf("auth_success compression = %v, want %q", msg.Payload["compression"], compression)
+		}
+		handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:large"})
+		data := nextRaw(conn)
+		msg, err := protocol.DecodeMessage(data)
+		if err != nil || msg.Type != protocol.TypeSyncResponse {
+			t.Fatalf("decoded %v, %v; want a sync_response", msg, err)
+		}
+		if state, _ := msg.Payload["state"].(map[string]interface{}); len(state) != 200 {
+			t.Errorf("%q: state has %d fields, want 200", compression, len(state))
+		}
+		sizes[compression] = len(data)
+	}
+	if sizes["deflate"]*4 > sizes[""] {
+		t.Errorf("sync_response is %d bytes compressed and %d plain, want under a quarter", sizes["deflate"], sizes[""])
+	}
+}
+
+func TestHub_BroadcastsInEachSubscribersEncoding(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer", "room:codec")
+
+	readers := make(map[string]*Connection)
+	for _, encoding := range []string{"json", "msgpack"} {
+		conn := newTestConnection(hub, encoding)
+		hub.register(conn)
+		handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "user-" + encoding, "encoding": encoding})
+		// auth_success is JSON either way, and confirms only msgpack
+		data := <-conn.send
+		if data[9]&0x80 != 0 {
+			t.Fatalf("%s: auth_success has flags %#x, want plain JSON", encoding, data[13])
+		}
+		if msg, _ := protocol.DecodeMessage(data); (msg.Payload["encoding"] == "msgpack") != (encoding == "msgpack") {
+			t.Errorf("%s: auth_success encoding = %v", encoding, msg.Payload["encoding"])
+		}
+		handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:codec"})
+		expectMessage(t, conn, protocol.TypeSyncResponse)
+		readers[encoding] = conn
+	}
+
+	sendDelta(hub, writer, "room:codec", "title", map[string]interface{}{"text": "Hello", "size": 12.5, "tags": []interface{}{"a", true, nil}})
+	expectMessage(t, writer, protocol.TypeAck)
+
+	deltas := make(map[string]map[string]interface{})
+	for encoding, conn := range readers {
+		data := <-conn.send
+		msgpack := data[9]&0x80 != 0 && data[13] == protocol.FlagMsgpack
+		if msgpack != (encoding == "msgpack") {
+			t.Errorf("%s: delta encoded as msgpack = %v", encoding, msgpack)
+		}
+		msg, err := protocol.DecodeMessage(data)
+		if err != nil || msg.Type != protocol.TypeDelta {
+			t.Fatalf("%s: decoded %v, %v; want a delta", encoding, msg, err)
+		}
+		deltas[encoding] = msg.Payload
+	}
+	if !reflect.DeepEqual(deltas["json"]["changes"], deltas["msgpack"]["changes"]) || deltas["json"]["seq"] != deltas["msgpack"]["seq"] {
+		t.Errorf("subscribers got different deltas: %v and %v", deltas["json"], deltas["msgpack"])
+	}
+}
+
+func TestHub_InvalidSubscribeMode(t *testing.T) {
+	hub := NewHub(testAuth)
+	conn := joinDirect(t, hub, "c1")
+
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:x", "mode": "admin"})
+	expectError(t, conn, "INVALID_REQUEST")
+}
+
+func TestHub_RejectsMalformedPayloads(t *testing.T) {
+	hub := NewHub(testAuth)
+	conn := joinDirect(t, hub, "c1", "room:x")
+
+	tests := []struct {
+		messageType string
+		payload     map[string]interface{}
+		want        string
+	}{
+		{protocol.TypeSubscribe, map[string]interface{}{}, "missing docId"},
+		{protocol.TypeSubscribe, map[string]interface{}{"docId": 7.0}, "invalid docId: must be a string"},
+		{protocol.TypeSubscribe, map[string]interface{}{"docId": "room:x", "resumeFrom": map[string]interface{}{"room:x": "1"}}, "invalid resumeFrom.room:x: must be a number"},
+		{protocol.TypeUnsubscribe, map[string]interface{}{"docId": true}, "invalid docId: must be a string"},
+		{protocol.TypeDelta, map[string]interface{}{"docId": "room:x", "changes": "title"}, "invalid changes: must be an object"},
+		{protocol.TypeDeltaBatch, map[string]interface{}{"docId": "room:x"}, "missing deltas"},
+		{protocol.TypeDeltaBatch, map[string]interface{}{"docId": "room:x", "deltas": map[string]interface{}{}}, "invalid deltas: must be an array"},
+		{protocol.TypeAwarenessUpdate, map[string]interface{}{"docId": "room:x"}, "missing state"},
+		{protocol.TypeSubscribePrefix, map[string]interface{}{"prefix": ""}, "missing prefix"},
+		{protocol.TypeSubscribeList, map[string]interface{}{"limit": "ten"}, "invalid limit: must be an integer"},
+	}
+	for _, tt := range tests {
+		handleDirect(hub, conn, tt.messageType, tt.payload)
+		msg := expectMessage(t, conn, protocol.TypeError)
+		if msg.Payload["code"] != "INVALID_REQUEST" || msg.Payload["error"] != "Invalid request: "+tt.want {
+			t.Errorf("%s %v: error = %v, want INVALID_REQUEST %q", tt.messageType, tt.payload, msg.Payload, tt.want)
+		}
+	}
+
+	// Auth failures are reported as auth errors
+	fresh := newTestConnection(hub, "c2")
+	hub.register(fresh)
+	handleDirect(hub, fresh, protocol.TypeAuth, map[string]interface{}{"token": 12.0})
+	if msg := expectMessage(t, fresh, protocol.TypeAuthError); msg.Payload["code"] != "INVALID_REQUEST" || fresh.Authenticated {
+		t.Errorf("auth with a numeric token = %v, want INVALID_REQUEST", msg.Payload)
+	}
+}
+
+// --- Prefix subscriptions ---
+
+func subscribePrefix(t *testing.T, hub *Hub, conn *Connection, prefix string) []interface{} {
+	t.Helper()
+	handleDirect(hub, conn, protocol.TypeSubscribePrefix, map[string]interface{}{"prefix": prefix})
+	msg := expectMessage(t, conn, protocol.TypePrefixDocuments)
+	docIDs, _ := msg.Payload["docIds"].([]interface{})
+	return docIDs
+}
+
+func TestHub_PrefixSubscriptionListsAndReceivesExistingDocuments(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer")
+	sendDelta(hub, writer, "room:board:1:card:a", "title", "A")
+	sendDelta(hub, writer, "room:board:1:card:b", "title", "B")
+	sendDelta(hub, writer, "room:board:2:card:c", "title", "C")
+
+	watcher := joinDirect(t, hub, "watcher")
+	docIDs := subscribePrefix(t, hub, watcher, "room:board:1:")
+	if len(docIDs) != 2 || docIDs[0] != "room:board:1:card:a" || docIDs[1] != "room:board:1:card:b" {
+		t.Errorf("docIds = %v, want the two board 1 cards", docIDs)
+	}
+
+	sendDelta(hub, writer, "room:board:2:card:c", "title", "C2")
+	sendDelta(hub, writer, "room:board:1:card:a", "title", "A2")
+	msg := expectMessage(t, watcher, protocol.TypeDelta)
+	if msg.Payload["docId"] != "room:board:1:card:a" {
+		t.Errorf("received delta for %v, want room:board:1:card:a", msg.Payload["docId"])
+	}
+}
+
+func TestHub_PrefixSubscriptionReceivesNewDocuments(t *testing.T) {
+	hub := NewHub(testAuth)
+	watcher := joinDirect(t, hub, "watcher")
+	if docIDs := subscribePrefix(t, hub, watcher, "room:board:"); len(docIDs) != 0 {
+		t.Errorf("docIds = %v, want none", docIDs)
+	}
+
+	writer := joinDirect(t, hub, "writer")
+	sendDelta(hub, writer, "room:board:new", "title", "fresh")
+	msg := expectMessage(t, watcher, protocol.TypeDelta)
+	if msg.Payload["docId"] != "room:board:new" {
+		t.Errorf("docId = %v, want room:board:new", msg.Payload["docId"])
+	}
+
+	// Direct and prefix subscriptions to the same document deliver once
+	handleDirect(hub, watcher, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:board:new"})
+	expectMessage(t, watcher, protocol.TypeSyncResponse)
+	sendDelta(hub, writer, "room:board:new", "title", "again")
+	expectMessage(t, watcher, protocol.TypeDelta)
+	select {
+	case data := <-watcher.send:
+		t.Errorf("unexpected duplicate message: %v", data)
+	default:
+	}
+
+	handleDirect(hub, watcher, protocol.TypeUnsubscribePrefix, map[string]interface{}{"prefix": "room:board:"})
+	if len(hub.prefixSubscribers) != 0 {
+		t.Errorf("prefixSubscribers = %v, want empty", hub.prefixSubscribers)
+	}
+}
+
+func TestHub_PrefixSubscriptionFiltersByPermission(t *testing.T) {
+	hub := NewHub(testAuth)
+	admin := authWithToken(t, hub, "admin", auth.CreateAdminPermissions())
+	sendDelta(hub, admin, "room:team:public", "k", "v")
+	sendDelta(hub, admin, "room:team:secret", "k", "v")
+
+	viewer := authWithToken(t, hub, "viewer", auth.CreateUserPermissions([]string{"room:team:public"}, nil))
+	docIDs := subscribePrefix(t, hub, viewer, "room:team:")
+	if len(docIDs) != 1 || docIDs[0] != "room:team:public" {
+		t.Errorf("docIds = %v, want only room:team:public", docIDs)
+	}
+
+	sendDelta(hub, admin, "room:team:secret", "k", "v2")
+	sendDelta(hub, admin, "room:team:public", "k", "v2")
+	if msg := expectMessage(t, viewer, protocol.TypeDelta); msg.Payload["docId"] != "room:team:public" {
+		t.Errorf("viewer received delta for %v", msg.Payload["docId"])
+	}
+}
+
+func TestHub_PrefixSubscriptionLimit(t *testing.T) {
+	hub := newLimitedHub(security.Limits{MaxPrefixSubscriptions: 1})
+	conn := joinDirect(t, hub, "c1")
+
+	subscribePrefix(t, hub, conn, "room:a:")
+	handleDirect(hub, conn, protocol.TypeSubscribePrefix, map[string]interface{}{"prefix": "room:b:"})
+	expectError(t, conn, "SUBSCRIPTION_LIMIT")
+
+	hub.unregister(conn)
+	if len(hub.prefixSubscribers) != 0 {
+		t.Errorf("prefixSubscribers = %v, want empty after disconnect", hub.prefixSubscribers)
+	}
+}
+
+// --- Document list subscriptions ---
+
+func TestHub_ListSubscriptionReceivesAddAndRemove(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer")
+	sendDelta(hub, writer, "room:dash:existing", "k", "v")
+
+	watcher := joinDirect(t, hub, "watcher")
+	handleDirect(hub, watcher, protocol.TypeSubscribeList, map[string]interface{}{"prefix": "room:dash:"})
+	msg := expectMessage(t, watcher, protocol.TypeDocumentList)
+	docIDs, _ := msg.Payload["docIds"].([]interface{})
+	if len(docIDs) != 1 || docIDs[0] != "room:dash:existing" {
+		t.Errorf("docIds = %v, want [room:dash:existing]", docIDs)
+	}
+
+	// Writes to existing documents and other prefixes are not list changes
+	sendDelta(hub, writer, "room:dash:existing", "k", "v2")
+	sendDelta(hub, writer, "room:other:doc", "k", "v")
+	sendDelta(hub, writer, "room:dash:new", "k", "v")
+	msg = expectMessage(t, watcher, protocol.TypeListChanged)
+	if msg.Payload["docId"] != "room:dash:new" || msg.Payload["change"] != ListAdded {
+		t.Errorf("list_changed = %v, want room:dash:new added", msg.Payload)
+	}
+
+	if !hub.DeleteDocument("room:dash:existing") {
+		t.Fatal("DeleteDocument() = false, want true")
+	}
+	msg = expectMessage(t, watcher, protocol.TypeListChanged)
+	if msg.Payload["docId"] != "room:dash:existing" || msg.Payload["change"] != ListRemoved {
+		t.Errorf("list_changed = %v, want room:dash:existing removed", msg.Payload)
+	}
+	if hub.DeleteDocument("room:dash:existing") {
+		t.Error("DeleteDocument() on a missing document = true, want false")
+	}
+
+	handleDirect(hub, watcher, protocol.TypeUnsubscribeList, map[string]interface{}{"prefix": "room:dash:"})
+	if len(hub.listSubscribers) != 0 {
+		t.Errorf("listSubscribers = %v, want empty", hub.listSubscribers)
+	}
+}
+
+func TestHub_ListSubscriptionPaginates(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer")
+	for i := 0; i < 5; i++ {
+		sendDelta(hub, writer, fmt.Sprintf("room:page:%d", i), "k", "v")
+	}
+
+	watcher := joinDirect(t, hub, "watcher")
+	var got []interface{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages, got %v so far", got)
+		}
+		handleDirect(hub, watcher, protocol.TypeSubscribeList, map[string]interface{}{
+			"prefix": "room:page:",
+			"cursor": cursor,
+			"limit":  float64(2),
+		})
+		msg := expectMessage(t, watcher, protocol.TypeDocumentList)
+		docIDs, _ := msg.Payload["docIds"].([]interface{})
+		got = append(got, docIDs...)
+		if msg.Payload["hasMore"] != true {
+			break
+		}
+		cursor, _ = msg.Payload["nextCursor"].(string)
+	}
+
+	if len(got) != 5 {
+		t.Fatalf("listed %d documents, want 5: %v", len(got), got)
+	}
+	for i, docID := range got {
+		if want := fmt.Sprintf("room:page:%d", i); docID != want {
+			t.Errorf("docIds[%d] = %v, want %s", i, docID, want)
+		}
+	}
+	if len(watcher.ListSubscriptions) != 1 {
+		t.Errorf("ListSubscriptions = %v, want a single subscription", watcher.ListSubscriptions)
+	}
+}
+
+func TestHub_ListSubscriptionFiltersByPermission(t *testing.T) {
+	hub := NewHub(testAuth)
+	viewer := authWithToken(t, hub, "viewer", auth.CreateUserPermissions([]string{"room:team:public"}, nil))
+	handleDirect(hub, viewer, protocol.TypeSubscribeList, map[string]interface{}{"prefix": "room:team:"})
+	expectMessage(t, viewer, protocol.TypeDocumentList)
+
+	admin := authWithToken(t, hub, "admin", auth.CreateAdminPermissions())
+	sendDelta(hub, admin, "room:team:secret", "k", "v")
+	sendDelta(hub, admin, "room:team:public", "k", "v")
+	if msg := expectMessage(t, viewer, protocol.TypeListChanged); msg.Payload["docId"] != "room:team:public" {
+		t.Errorf("viewer told about %v", msg.Payload["docId"])
+	}
+}
+
+// --- Document quotas ---
+
+// quotaConn authenticates a connection for userID from ip, sharing sm
+func quotaConn(t *testing.T, hub *Hub, sm *security.SecurityManager, id, userID, ip string) *Connection {
+	t.Helper()
+	token, err := auth.GenerateAccessToken(userID, "", auth.CreateUserPermissions([]string{"*"}, []string{"*"}), testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken failed: %v", err)
+	}
+	conn := newTestConnection(hub, id)
+	conn.ClientIP = ip
+	conn.SecurityManager = sm
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": token})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	return conn
+}
+
+func TestHub_DocumentQuotaIsPerUser(t *testing.T) {
+	hub := NewHub(testAuth)
+	sm := security.NewSecurityManager(security.Limits{MaxDocsPerIP: 1})
+	defer sm.Dispose()
+
+	laptop := quotaConn(t, hub, sm, "c1", "alice", "10.0.0.1")
+	phone := quotaConn(t, hub, sm, "c2", "alice", "10.0.0.2")
+	bob := quotaConn(t, hub, sm, "c3", "bob", "10.0.0.1")
+
+	sendDelta(hub, laptop, "room:alice-1", "k", "v")
+	expectMessage(t, laptop, protocol.TypeAck)
+
+	// alice's quota follows her to another IP
+	sendDelta(hub, phone, "room:alice-2", "k", "v")
+	expectError(t, phone, "DOCUMENT_QUOTA_EXCEEDED")
+
+	// Writing to an existing document needs no quota
+	sendDelta(hub, phone, "room:alice-1", "k", "v2")
+	expectMessage(t, phone, protocol.TypeAck)
+
+	// bob shares alice's IP but not her quota
+	sendDelta(hub, bob, "room:bob-1", "k", "v")
+	expectMessage(t, bob, protocol.TypeAck)
+}
+
+// --- Public document policy ---
+
+func TestHub_PublicDocumentPolicy(t *testing.T) {
+	policy, err := security.NewPublicDocumentPolicy(security.PublicDocumentRules{Prefixes: []string{"team:"}})
+	if err != nil {
+		t.Fatalf("NewPublicDocumentPolicy failed: %v", err)
+	}
+	hub := NewHubWithOptions(testAuth, HubOptions{PublicDocuments: policy})
+
+	conn := joinDirect(t, hub, "c1", "team:notes")
+
+	// The default rooms are not public under this policy
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:1"})
+	expectError(t, conn, "ACCESS_DENIED")
+}
+
+// --- Awareness limits ---
+
+func sendAwareness(hub *Hub, conn *Connection, docID string, state map[string]interface{}) {
+	handleDirect(hub, conn, protocol.TypeAwarenessUpdate, map[string]interface{}{
+		"docId": docID,
+		"state": state,
+	})
+}
+
+func TestHub_AwarenessStateTooLarge(t *testing.T) {
+	hub := newLimitedHub(security.Limits{MaxAwarenessStateSize: 64})
+	sender := joinDirect(t, hub, "sender", "room:aware")
+	receiver := joinDirect(t, hub, "receiver", "room:aware")
+
+	sendAwareness(hub, sender, "room:aware", map[string]interface{}{"cursor": strings.Repeat("x", 100)})
+	expectError(t, sender, "AWARENESS_TOO_LARGE")
+	select {
+	case data := <-receiver.send:
+		t.Errorf("oversized state was broadcast: %v", data)
+	default:
+	}
+	if len(hub.awareness["room:aware"]) != 0 {
+		t.Errorf("oversized state was stored: %v", hub.awareness["room:aware"])
+	}
+
+	sendAwareness(hub, sender, "room:aware", map[string]interface{}{"cursor": 1.0})
+	expectMessage(t, receiver, protocol.TypeAwarenessState)
+}
+
+func TestHub_AwarenessBurstIsCoalesced(t *testing.T) {
+	hub := newLimitedHub(security.Limits{MaxAwarenessUpdatesPerSecond: 2})
+	sender := joinDirect(t, hub, "sender", "room:aware")
+	receiver := joinDirect(t, hub, "receiver", "room:aware")
+
+	for i := 0; i < 10; i++ {
+		sendAwareness(hub, sender, "room:aware", map[string]interface{}{"n": float64(i)})
+	}
+	select {
+	case data := <-sender.send:
+		if msg, _ := protocol.DecodeMessage(data); msg != nil && msg.Type == protocol.TypeError {
+			t.Errorf("burst produced an error: %v", msg.Payload)
+		}
+	default:
+	}
+
+	// Two go out immediately, the rest collapse into the latest state
+	var got []float64
+	for i := 0; i < 3; i++ {
+		msg := expectMessage(t, receiver, protocol.TypeAwarenessState)
+		state, _ := msg.Payload["state"].(map[string]interface{})
+		n, _ := state["n"].(float64)
+		got = append(got, n)
+	}
+	if got[0] != 0 || got[1] != 1 || got[2] != 9 {
+		t.Errorf("received states %v, want [0 1 9]", got)
+	}
+	select {
+	case data := <-receiver.send:
+		t.Errorf("unexpected extra message: %v", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// joinDiffing is joinDirect for a connection that negotiated awarenessDiff
+// and awareness-subscribed to docID
+func joinDiffing(t *testing.T, hub *Hub, id, docID string) *Connection {
+	t.Helper()
+	conn := newTestConnection(hub, id)
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "user-" + id, "protocolVersion": 2.0, "capabilities": []interface{}{"awarenessDiff"}})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": docID})
+	expectMessage(t, conn, protocol.TypeSyncResponse)
+	handleDirect(hub, conn, protocol.TypeAwarenessSubscribe, map[string]interface{}{"docId": docID})
+	expectMessage(t, conn, protocol.TypeAwarenessState)
+	return conn
+}
+
+// nextAwareness reads conn's next queued awareness_state and its size
+func nextAwareness(t *testing.T, conn *Connection) (*protocol.Message, int) {
+	t.Helper()
+	select {
+	case data := <-conn.send:
+		msg, err := protocol.DecodeMessage(data)
+		if err != nil || msg.Type != protocol.TypeAwarenessState {
+			t.Fatalf("queued %v, %v; want awareness_state", msg, err)
+		}
+		return msg, len(data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no awareness_state queued")
+		return nil, 0
+	}
+}
+
+func TestHub_AwarenessDiffsSendOnlyWhatChanged(t *testing.T) {
+	hub := newLimitedHub(security.Limits{MaxAwarenessUpdatesPerSecond: 1000})
+	sender := joinDirect(t, hub, "sender", "room:aware")
+	legacy := joinDirect(t, hub, "legacy", "room:aware")
+	diffing := joinDiffing(t, hub, "diffing", "room:aware")
+
+	selection := make([]interface{}, 100)
+	for i := range selection {
+		selection[i] = map[string]interface{}{"anchor": float64(i * 10), "head": float64(i*10 + 5)}
+	}
+	sendAwareness(hub, sender, "room:aware", map[string]interface{}{"cursor": 0.0, "selection": selection})
+
+	// The first state goes out whole to everyone
+	msg, _ := nextAwareness(t, diffing)
+	state, _ := msg.Payload["state"].(map[string]interface{})
+	if state == nil || msg.Payload["changed"] != nil {
+		t.Fatalf("first update = %v, want the whole state", msg.Payload)
+	}
+	nextAwareness(t, legacy)
+	delete(state, "lastUpdate")
+
+	// Small cursor moves
+	fullBytes, diffBytes := 0, 0
+	for i := 1; i <= 20; i++ {
+		sendAwareness(hub, sender, "room:aware", map[string]interface{}{"cursor": float64(i), "selection": selection})
+		_, n := nextAwareness(t, legacy)
+		fullBytes += n
+		msg, n := nextAwareness(t, diffing)
+		diffBytes += n
+		changed, _ := msg.Payload["changed"].(map[string]interface{})
+		if len(changed) != 1 || changed["cursor"] != float64(i) || msg.Payload["state"] != nil {
+			t.Fatalf("move %d sent %v, want only the cursor", i, msg.Payload)
+		}
+		state["cursor"] = changed["cursor"]
+	}
+	if diffBytes*10 > fullBytes {
+		t.Errorf("diffs took %d bytes against %d for full states, want a tenth or less", diffBytes, fullBytes)
+	}
+
+	// Dropping a field lists it as removed
+	sendAwareness(hub, sender, "room:aware", map[string]interface{}{"cursor": 21.0})
+	msg, _ = nextAwareness(t, diffing)
+	removed, _ := msg.Payload["removed"].([]interface{})
+	if len(removed) != 1 || removed[0] != "selection" {
+		t.Fatalf("removed = %v, want [selection]", msg.Payload["removed"])
+	}
+	delete(state, "selection")
+	state["cursor"] = 21.0
+	nextAwareness(t, legacy)
+
+	// A late joiner gets the complete state, diffs applied
+	late := joinDirect(t, hub, "late", "room:aware")
+	handleDirect(hub, late, protocol.TypeAwarenessSubscribe, map[string]interface{}{"docId": "room:aware"})
+	msg = expectMessage(t, late, protocol.TypeAwarenessState)
+	states, _ := msg.Payload["states"].([]interface{})
+	if len(states) != 1 {
+		t.Fatalf("late joiner got %v, want one state", msg.Payload)
+	}
+	got, _ := states[0].(map[string]interface{})["state"].(map[string]interface{})
+	delete(got, "lastUpdate")
+	if !reflect.DeepEqual(got, state) {
+		t.Errorf("late joiner state = %v, want what the diffs built: %v", got, state)
+	}
+}
+
+func TestHub_AwarenessDiffsStopAfterADroppedUpdate(t *testing.T) {
+	hub := newLimitedHub(security.Limits{MaxAwarenessUpdatesPerSecond: 1000})
+	sender := joinDirect(t, hub, "sender", "room:aware")
+	diffing := joinDiffing(t, hub, "diffing", "room:aware")
+	sendAwareness(hub, sender, "room:aware", map[string]interface{}{"cursor": 0.0})
+	nextAwareness(t, diffing)
+
+	// The queue is full, so the move is dropped
+	for len(diffing.send) < cap(diffing.send) {
+		diffing.send <- nil
+	}
+	sendAwareness(hub, sender, "room:aware", map[string]interface{}{"cursor": 1.0})
+	for len(diffing.send) > 0 {
+		<-diffing.send
+	}
+
+	sendAwareness(hub, sender, "room:aware", map[string]interface{}{"cursor": 2.0})
+	msg, _ := nextAwareness(t, diffing)
+	if state, _ := msg.Payload["state"].(map[string]interface{}); state["cursor"] != 2.0 {
+		t.Errorf("after a drop got %v, want the whole state", msg.Payload)
+	}
+
+	// Subscribing again brings diffs back
+	handleDirect(hub, diffing, protocol.TypeAwarenessSubscribe, map[string]interface{}{"docId": "room:aware"})
+	expectMessage(t, diffing, protocol.TypeAwarenessState)
+	sendAwareness(hub, sender, "room:aware", map[string]interface{}{"cursor": 3.0})
+	if msg, _ := nextAwareness(t, diffing); msg.Payload["changed"] == nil {
+		t.Errorf("after awareness_subscribe got %v, want a diff", msg.Payload)
+	}
+}
+
+// --- Connection introspection ---
+
+func TestHub_ListConnectionsConsistentDuringChurn(t *testing.T) {
+	hub := newTestHub(t)
+
+	stable := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		conn := connectAnonymous(t, hub, fmt.Sprintf("stable-%d", i))
+		subscribe(t, hub, conn, "room:introspect")
+		stable[conn.ID] = true
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				conn := newTestConnection(hub, fmt.Sprintf("churn-%d-%d", w, i))
+				hub.Register <- conn
+				dispatch(hub, conn, protocol.TypeAuth, map[string]interface{}{"userId": "churner"})
+				hub.Unregister <- conn
+			}
+		}(w)
+	}
+
+	for i := 0; i < 200; i++ {
+		infos := hub.ListConnections()
+		seen := make(map[string]bool, len(infos))
+		found := 0
+		for _, info := range infos {
+			if seen[info.ID] {
+				t.Fatalf("snapshot lists %s twice", info.ID)
+			}
+			seen[info.ID] = true
+			if info.ConnectedAt.IsZero() {
+				t.Errorf("%s has zero ConnectedAt", info.ID)
+			}
+			if stable[info.ID] {
+				found++
+				if info.UserID != "user-"+info.ID || info.Subscriptions != 1 || !info.Authenticated {
+					t.Errorf("stable connection snapshot = %+v", info)
+				}
+			}
+		}
+		if found != len(stable) {
+			t.Fatalf("snapshot has %d of %d stable connections", found, len(stable))
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if infos := hub.ListConnections(); len(infos) != len(stable) {
+		t.Errorf("ListConnections() after churn = %d connections, want %d", len(infos), len(stable))
+	}
+}
+
+func TestHub_ListConnectionsAfterStop(t *testing.T) {
+	hub := NewHub(testAuth)
+	go hub.Run()
+	if err := hub.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if infos := hub.ListConnections(); len(infos) != 0 {
+		t.Errorf("ListConnections() on a stopped hub = %v, want none", infos)
+	}
+}
+
+func TestHub_StopAppliesQueuedMessagesBeforeClosing(t *testing.T) {
+	var flushed map[string]interface{}
+	var hub *Hub
+	hub = NewHubWithOptions(testAuth, HubOptions{
+		ShutdownReconnectDelay: 3 * time.Second,
+		Flush: func(ctx context.Context) error {
+			hub.docsMu.RLock()
+			defer hub.docsMu.RUnlock()
+			flushed = make(map[string]interface{})
+			for k, v := range hub.documents["room:shutdown"] {
+				flushed[k] = v
+			}
+			return nil
+		},
+	})
+	writer := joinDirect(t, hub, "writer", "room:shutdown")
+	reader := joinDirect(t, hub, "reader", "room:shutdown")
+
+	// Queue deltas before the hub loop is even running
+	const n = 5
+	for i := 0; i < n; i++ {
+		dispatch(hub, writer, protocol.TypeDelta, map[string]interface{}{
+			"docId":   "room:shutdown",
+			"changes": map[string]interface{}{fmt.Sprintf("k%d", i): float64(i)},
+		})
+	}
+
+	go hub.Run()
+	var live int64
+	startFakePump(writer, &live)
+	startFakePump(reader, &live)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := hub.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	if len(flushed) != n {
+		t.Errorf("Flush saw %d fields, want %d: %v", len(flushed), n, flushed)
+	}
+	msg := expectMessage(t, reader, protocol.TypeServerShutdown)
+	if msg.Payload["reconnectAfter"] != float64(3000) {
+		t.Errorf("reconnectAfter = %v, want 3000", msg.Payload["reconnectAfter"])
+	}
+	for i := 0; i < n; i++ {
+		expectMessage(t, reader, protocol.TypeDelta)
+		expectMessage(t, writer, protocol.TypeAck)
+	}
+	if code := closeCode(writer); code != gorilla.CloseGoingAway {
+		t.Errorf("close code = %d, want %d", code, gorilla.CloseGoingAway)
+	}
+}
+
+// closeCode decodes the status code from a connection's close frame
+func closeCode(conn *Connection) int {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if len(conn.closeFrame) < 2 {
+		return gorilla.CloseNoStatusReceived
+	}
+	return int(conn.closeFrame[0])<<8 | int(conn.closeFrame[1])
+}
+
+// --- Divergence ---
+
+// drainQueued empties a connection's send queue and returns the messages of
+// the given type
+func drainQueued(t *testing.T, conn *Connection, msgType string) []*protocol.Message {
+	t.Helper()
+	var msgs []*protocol.Message
+	for {
+		select {
+		case data := <-conn.send:
+			msg, err := protocol.DecodeMessage(data)
+			if err != nil {
+				t.Fatalf("DecodeMessage failed: %v", err)
+			}
+			if msg.Type == msgType {
+				msgs = append(msgs, msg)
+			}
+		default:
+			return msgs
+		}
+	}
+}
+
+// sendStaleDelta writes a change timestamped before the field's winning write
+func sendStaleDelta(hub *Hub, conn *Connection, docID string) {
+	handleDirect(hub, conn, protocol.TypeDelta, map[string]interface{}{
+		"docId":     docID,
+		"changes":   map[string]interface{}{"title": "old"},
+		"timestamp": float64(1),
+	})
+}
+
+func TestHub_SyncRequiredOncePerThresholdCrossing(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{SyncRequiredThreshold: 3})
+	writer := joinDirect(t, hub, "writer", "room:diverge")
+	laggard := joinDirect(t, hub, "laggard", "room:diverge")
+	sendDelta(hub, writer, "room:diverge", "title", "new")
+
+	for i := 0; i < 5; i++ {
+		sendStaleDelta(hub, laggard, "room:diverge")
+	}
+	notices := drainQueued(t, laggard, protocol.TypeSyncRequired)
+	if len(notices) != 1 {
+		t.Fatalf("sync_required sent %d times for 5 rejections, want 1", len(notices))
+	}
+	if notices[0].Payload["docId"] != "room:diverge" || notices[0].Payload["reason"] != RejectStale {
+		t.Errorf("sync_required payload = %v", notices[0].Payload)
+	}
+
+	// A full sync starts the count over
+	handleDirect(hub, laggard, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:diverge"})
+	expectMessage(t, laggard, protocol.TypeSyncResponse)
+	for i := 0; i < 2; i++ {
+		sendStaleDelta(hub, laggard, "room:diverge")
+	}
+	if n := len(drainQueued(t, laggard, protocol.TypeSyncRequired)); n != 0 {
+		t.Errorf("sync_required sent below the threshold after a full sync (%d)", n)
+	}
+	sendStaleDelta(hub, laggard, "room:diverge")
+	if n := len(drainQueued(t, laggard, protocol.TypeSyncRequired)); n != 1 {
+		t.Errorf("sync_required sent %d times on the second crossing, want 1", n)
+	}
+}
+
+func TestHub_SyncRequiredCountsBatchRejections(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{SyncRequiredThreshold: 2})
+	writer := joinDirect(t, hub, "writer", "room:diverge")
+	sendDelta(hub, writer, "room:diverge", "title", "new")
+	drainQueued(t, writer, protocol.TypeAck)
+
+	handleDirect(hub, writer, protocol.TypeDeltaBatch, map[string]interface{}{
+		"docId":  "room:diverge",
+		"deltas": []interface{}{"not-a-delta", "also-not", map[string]interface{}{"changes": map[string]interface{}{"body": "ok"}}},
+	})
+	notices := drainQueued(t, writer, protocol.TypeSyncRequired)
+	if len(notices) != 1 || notices[0].Payload["count"] != float64(2) {
+		t.Errorf("sync_required = %v, want one notice with count 2", notices)
+	}
+}
+
+func TestHub_SyncRequiredAfterDroppedBroadcasts(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{SyncRequiredThreshold: 3})
+	writer := joinDirect(t, hub, "writer", "room:diverge")
+	slow := joinDirect(t, hub, "slow", "room:diverge")
+
+	// Fill the slow reader's queue so broadcasts to it are dropped
+	for len(slow.send) < cap(slow.send) {
+		slow.send <- nil
+	}
+	for i := 0; i < 4; i++ {
+		sendDelta(hub, writer, "room:diverge", "title", float64(i))
+	}
+
+	// The notice could not be queued either; it follows the next delta that fits
+	for len(slow.send) > 0 {
+		<-slow.send
+	}
+	sendDelta(hub, writer, "room:diverge", "title", "latest")
+	notices := drainQueued(t, slow, protocol.TypeSyncRequired)
+	if len(notices) != 1 || notices[0].Payload["reason"] != DivergenceDropped {
+		t.Fatalf("sync_required = %v, want one notice for dropped deltas", notices)
+	}
+
+	sendDelta(hub, writer, "room:diverge", "title", "after")
+	if n := len(drainQueued(t, slow, protocol.TypeSyncRequired)); n != 0 {
+		t.Errorf("sync_required repeated %d times before a full sync", n)
+	}
+}
+
+func TestHub_MaxBlockSize(t *testing.T) {
+	hub := newLimitedHub(security.Limits{MaxBlockSize: 10})
+	writer := joinDirect(t, hub, "writer", "room:blocks")
+	reader := joinDirect(t, hub, "reader", "room:blocks")
+
+	// "12345678" encodes to exactly 10 bytes; one more character is over
+	handleDirect(hub, writer, protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:blocks",
+		"changes": map[string]interface{}{"fits": "12345678", "big": "123456789"},
+	})
+	ack := expectMessage(t, writer, protocol.TypeAck)
+	if ack.Payload["status"] != "applied" {
+		t.Fatalf("ack = %v, want applied", ack.Payload)
+	}
+	fieldErrors, _ := ack.Payload["fieldErrors"].([]interface{})
+	if len(fieldErrors) != 1 {
+		t.Fatalf("fieldErrors = %v, want one for big", ack.Payload["fieldErrors"])
+	}
+	fe := fieldErrors[0].(map[string]interface{})
+	if fe["field"] != "big" || fe["code"] != CodeBlockTooLarge || fe["size"] != 11.0 || fe["max"] != 10.0 {
+		t.Errorf("field error = %v, want big at 11 of 10 bytes", fe)
+	}
+
+	// Only the fitting field is applied and broadcast
+	delta := expectMessage(t, reader, protocol.TypeDelta)
+	if changes, _ := delta.Payload["changes"].(map[string]interface{}); len(changes) != 1 || changes["fits"] != "12345678" {
+		t.Errorf("broadcast changes = %v, want only fits", changes)
+	}
+
+	// A delta with nothing small enough is rejected outright
+	sendDelta(hub, writer, "room:blocks", "big", "123456789")
+	ack = expectMessage(t, writer, protocol.TypeAck)
+	if ack.Payload["status"] != "rejected" || ack.Payload["reason"] != RejectBlockSize || ack.Payload["code"] != CodeBlockTooLarge {
+		t.Errorf("ack = %v, want rejected with %s", ack.Payload, CodeBlockTooLarge)
+	}
+
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	if _, has := hub.documents["room:blocks"]["big"]; has {
+		t.Error("Oversized field should not be stored")
+	}
+}
+
+func TestHub_MaxBlocksPerDoc(t *testing.T) {
+	hub := newLimitedHub(security.Limits{MaxBlocksPerDoc: 3})
+	writer := joinDirect(t, hub, "writer", "room:count")
+
+	// Just under, then exactly at the limit
+	handleDirect(hub, writer, protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:count",
+		"changes": map[string]interface{}{"a": 1.0, "b": 2.0},
+	})
+	expectMessage(t, writer, protocol.TypeAck)
+	sendDelta(hub, writer, "room:count", "c", 3.0)
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["status"] != "applied" {
+		t.Fatalf("ack = %v, want the third field applied", ack.Payload)
+	}
+
+	// Updating existing fields never counts against the limit
+	sendDelta(hub, writer, "room:count", "a", 10.0)
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["status"] != "applied" {
+		t.Errorf("ack = %v, want an update to apply at the limit", ack.Payload)
+	}
+
+	// One field over
+	sendDelta(hub, writer, "room:count", "d", 4.0)
+	ack := expectMessage(t, writer, protocol.TypeAck)
+	if ack.Payload["status"] != "rejected" || ack.Payload["reason"] != RejectBlockLimit || ack.Payload["code"] != CodeBlockLimitExceeded {
+		t.Errorf("ack = %v, want rejected with %s", ack.Payload, CodeBlockLimitExceeded)
+	}
+
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	if len(hub.documents["room:count"]) != 3 {
+		t.Errorf("document = %v, want 3 fields", hub.documents["room:count"])
+	}
+}
+
+func TestHub_BlockLimitsApplyWithinBatch(t *testing.T) {
+	hub := newLimitedHub(security.Limits{MaxBlocksPerDoc: 2, MaxBlockSize: 10})
+	writer := joinDirect(t, hub, "writer", "room:batch-limits")
+
+	handleDirect(hub, writer, protocol.TypeDeltaBatch, map[string]interface{}{
+		"docId": "room:batch-limits",
+		"deltas": []interface{}{
+			map[string]interface{}{"changes": map[string]interface{}{"a": 1.0}},
+			map[string]interface{}{"changes": map[string]interface{}{"big": "123456789"}},
+			map[string]interface{}{"changes": map[string]interface{}{"b": 2.0}},
+			map[string]interface{}{"changes": map[string]interface{}{"c": 3.0}},
+			map[string]interface{}{"changes": map[string]interface{}{"a": 4.0}},
+		},
+	})
+	ack := expectMessage(t, writer, protocol.TypeAck)
+
+	results, _ := ack.Payload["results"].([]interface{})
+	want := []struct{ status, code string }{
+		{"applied", ""},
+		{"rejected", CodeBlockTooLarge},
+		{"applied", ""},
+		{"rejected", CodeBlockLimitExceeded},
+		{"applied", ""},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("results = %v, want %d", results, len(want))
+	}
+	for i, w := range want {
+		r := results[i].(map[string]interface{})
+		if r["status"] != w.status || (w.code != "" && r["code"] != w.code) {
+			t.Errorf("result %d = %v, want %s %s", i, r, w.status, w.code)
+		}
+	}
+}
+
+func TestHub_FieldWritePermissions(t *testing.T) {
+	hub := NewHub(testAuth)
+	reviewer := authWithToken(t, hub, "reviewer", auth.DocumentPermissions{
+		CanRead:        []string{"room:*"},
+		CanWrite:       []string{"room:*"},
+		CanWriteFields: map[string][]string{"room:spec": {"comments.*"}},
+	})
+	author := authWithToken(t, hub, "author", auth.CreateUserPermissions([]string{"*"}, []string{"*"}))
+	for _, conn := range []*Connection{reviewer, author} {
+		handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:spec"})
+		expectMessage(t, conn, protocol.TypeSyncResponse)
+	}
+
+	// Without field rules every field is writable
+	handleDirect(hub, author, protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:spec",
+		"changes": map[string]interface{}{"content": "v1", "comments.a1": "by author"},
+	})
+	if ack := expectMessage(t, author, protocol.TypeAck); ack.Payload["status"] != "applied" || ack.Payload["fieldErrors"] != nil {
+		t.Fatalf("author ack = %v, want applied without field errors", ack.Payload)
+	}
+	expectMessage(t, reviewer, protocol.TypeDelta)
+
+	// The reviewer's comment applies, the content change does not
+	handleDirect(hub, reviewer, protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:spec",
+		"changes": map[string]interface{}{"content": "rewritten", "comments.r1": "looks good"},
+	})
+	ack := expectMessage(t, reviewer, protocol.TypeAck)
+	if ack.Payload["status"] != "applied" {
+		t.Fatalf("reviewer ack = %v, want applied", ack.Payload)
+	}
+	fieldErrors, _ := ack.Payload["fieldErrors"].([]interface{})
+	if len(fieldErrors) != 1 {
+		t.Fatalf("fieldErrors = %v, want one for content", ack.Payload["fieldErrors"])
+	}
+	if fe := fieldErrors[0].(map[string]interface{}); fe["field"] != "content" || fe["code"] != CodeFieldPermission {
+		t.Errorf("field error = %v, want content with %s", fe, CodeFieldPermission)
+	}
+	delta := expectMessage(t, author, protocol.TypeDelta)
+	if changes, _ := delta.Payload["changes"].(map[string]interface{}); len(changes) != 1 || changes["comments.r1"] != "looks good" {
+		t.Errorf("broadcast changes = %v, want only the comment", changes)
+	}
+
+	// A delta touching only forbidden fields is rejected
+	sendDelta(hub, reviewer, "room:spec", "content", "rewritten")
+	ack = expectMessage(t, reviewer, protocol.TypeAck)
+	if ack.Payload["status"] != "rejected" || ack.Payload["reason"] != RejectFields || ack.Payload["code"] != CodeFieldPermission {
+		t.Errorf("ack = %v, want rejected with %s", ack.Payload, CodeFieldPermission)
+	}
+
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	if doc := hub.documents["room:spec"]; doc["content"] != "v1" || doc["comments.r1"] != "looks good" {
+		t.Errorf("document = %v, want the author's content and the reviewer's comment", doc)
+	}
+}
+
+func TestHub_FieldWritePermissionsCoverDeletes(t *testing.T) {
+	hub := NewHub(testAuth)
+	reviewer := authWithToken(t, hub, "reviewer", auth.DocumentPermissions{
+		CanRead:        []string{"room:*"},
+		CanWrite:       []string{"room:*"},
+		CanWriteFields: map[string][]string{"room:spec": {"comments.*"}},
+	})
+	author := authWithToken(t, hub, "author", auth.CreateUserPermissions([]string{"*"}, []string{"*"}))
+	handleDirect(hub, author, protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:spec",
+		"changes": map[string]interface{}{"content": "v1", "summary": "short", "comments.a1": "by author"},
+	})
+	expectMessage(t, author, protocol.TypeAck)
+
+	// Deleting a comment applies; deleting content, whether by null or by a
+	// tombstone, does not
+	handleDirect(hub, reviewer, protocol.TypeDelta, map[string]interface{}{
+		"docId": "room:spec",
+		"changes": map[string]interface{}{
+			"content":     nil,
+			"summary":     map[string]interface{}{"__deleted": true},
+			"comments.a1": nil,
+		},
+	})
+	ack := expectMessage(t, reviewer, protocol.TypeAck)
+	fieldErrors, _ := ack.Payload["fieldErrors"].([]interface{})
+	if ack.Payload["status"] != "applied" || len(fieldErrors) != 2 {
+		t.Fatalf("ack = %v, want applied with content and summary refused", ack.Payload)
+	}
+	for i, field := range []string{"content", "summary"} {
+		if fe := fieldErrors[i].(map[string]interface{}); fe["field"] != field || fe["code"] != CodeFieldPermission {
+			t.Errorf("field error %d = %v, want %s with %s", i, fe, field, CodeFieldPermission)
+		}
+	}
+
+	// A delta that only deletes forbidden fields is rejected
+	sendDelta(hub, reviewer, "room:spec", "content", nil)
+	if ack := expectMessage(t, reviewer, protocol.TypeAck); ack.Payload["reason"] != RejectFields {
+		t.Errorf("ack = %v, want rejected with %s", ack.Payload, RejectFields)
+	}
+
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	if doc := hub.documents["room:spec"]; doc["content"] != "v1" || doc["summary"] != "short" || doc["comments.a1"] != nil {
+		t.Errorf("document = %v, want only the comment deleted", doc)
+	}
+}
+
+func TestHub_AuthTimeout(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{AuthTimeout: 100 * time.Millisecond})
+	go hub.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		hub.Stop(ctx)
+	})
+
+	idle := newTestConnection(hub, "idle")
+	hub.Register <- idle
+	late := newTestConnection(hub, "late")
+	hub.Register <- late
+
+	// Authenticating late, but within the deadline, keeps the connection
+	time.Sleep(50 * time.Millisecond)
+	dispatch(hub, late, protocol.TypeAuth, map[string]interface{}{"userId": "late"})
+	expectMessage(t, late, protocol.TypeAuthSuccess)
+
+	msg := expectMessage(t, idle, protocol.TypeError)
+	if msg.Payload["code"] != "AUTH_TIMEOUT" {
+		t.Errorf("error code = %v, want AUTH_TIMEOUT", msg.Payload["code"])
+	}
+	flushHub(t, hub)
+	if !idle.IsClosed() {
+		t.Error("Connection that never authenticated should be closed")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	flushHub(t, hub)
+	if late.IsClosed() {
+		t.Error("Connection that authenticated in time should stay open")
+	}
+	if hub.Metrics().AuthFailures != 1 {
+		t.Errorf("AuthFailures = %d, want 1", hub.Metrics().AuthFailures)
+	}
+	hub.Unregister <- late
+}
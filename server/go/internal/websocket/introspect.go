@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/metrics"
+)
+
+// ConnectionInfo is a point-in-time description of a connection
+type ConnectionInfo struct {
+	ID            string    `json:"id"`
+	UserID        string    `json:"userId"`
+	ClientID      string    `json:"clientId"`
+	ClientIP      string    `json:"clientIp"`
+	Authenticated bool      `json:"authenticated"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	LastMessageAt time.Time `json:"lastMessageAt"`
+	Subscriptions int       `json:"subscriptions"`
+	SendQueue     int       `json:"sendQueue"`       // Messages waiting for WritePump
+	RTTMillis     float64   `json:"rttMs,omitempty"` // Smoothed ping round trip, 0 until measured
+
+	// Frame sequence numbers, for clients that negotiated serverSeq: the
+	// last queued or dropped, and the last written to the socket
+	ServerSeq    uint64 `json:"serverSeq,omitempty"`
+	DeliveredSeq uint64 `json:"deliveredSeq,omitempty"`
+}
+
+// ListConnections returns a snapshot of every registered connection, oldest
+// first. The snapshot is taken on the Run goroutine so it is consistent with
+// message handling; it is safe to call from any other goroutine. A stopped
+// hub reports no connections.
+func (h *Hub) ListConnections() []ConnectionInfo {
+	infos := make([]ConnectionInfo, 0)
+	h.exec(func() {
+		h.mu.RLock()
+		conns := make([]*Connection, 0, len(h.connections))
+		for _, conn := range h.connections {
+			conns = append(conns, conn)
+		}
+		h.mu.RUnlock()
+
+		for _, conn := range conns {
+			infos = append(infos, conn.info())
+		}
+	})
+
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].ConnectedAt.Equal(infos[j].ConnectedAt) {
+			return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// info describes the connection. Must be called on the Run goroutine without
+// hub locks held.
+func (c *Connection) info() ConnectionInfo {
+	c.handleMu.Lock()
+	defer c.handleMu.Unlock()
+
+	rtt, _ := c.RTT()
+	sent, delivered := c.ServerSeqs()
+	return ConnectionInfo{
+		ID:            c.ID,
+		UserID:        c.UserID,
+		ClientID:      c.ClientID,
+		ClientIP:      c.ClientIP,
+		Authenticated: c.Authenticated,
+		ConnectedAt:   c.ConnectedAt,
+		LastMessageAt: c.LastMessageAt(),
+		Subscriptions: len(c.Subscriptions),
+		SendQueue:     len(c.send),
+		RTTMillis:     float64(rtt.Microseconds()) / 1000,
+		ServerSeq:     sent,
+		DeliveredSeq:  delivered,
+	}
+}
+
+// HubStats summarizes the hub's current load and its traffic since start
+type HubStats struct {
+	Connections              int    `json:"connections"`
+	AuthenticatedConnections int    `json:"authenticatedConnections"`
+	Documents                int    `json:"documents"` // Documents held in memory
+	Subscriptions            int    `json:"subscriptions"`
+	MessagesHandled          uint64 `json:"messagesHandled"`
+	BroadcastsSent           uint64 `json:"broadcastsSent"`
+	SendsDropped             uint64 `json:"sendsDropped"` // Sends refused by a full send queue
+}
+
+// Stats returns a snapshot of the hub's load and traffic counters. Like
+// ListConnections, connections are counted on the Run goroutine.
+func (h *Hub) Stats() HubStats {
+	stats := HubStats{
+		Subscriptions:  h.subscriptionCount(),
+		BroadcastsSent: h.metrics.broadcastsSent.Value(),
+		SendsDropped:   h.metrics.sendsDropped.Value(),
+	}
+	h.metrics.messagesIn.Each(func(_ []string, c *metrics.Counter) {
+		stats.MessagesHandled += c.Value()
+	})
+
+	h.exec(func() {
+		h.mu.RLock()
+		conns := make([]*Connection, 0, len(h.connections))
+		for _, conn := range h.connections {
+			conns = append(conns, conn)
+		}
+		h.mu.RUnlock()
+
+		stats.Connections = len(conns)
+		for _, conn := range conns {
+			conn.handleMu.Lock()
+			if conn.Authenticated {
+				stats.AuthenticatedConnections++
+			}
+			conn.handleMu.Unlock()
+		}
+	})
+
+	h.docsMu.RLock()
+	stats.Documents = len(h.documents)
+	h.docsMu.RUnlock()
+	return stats
+}
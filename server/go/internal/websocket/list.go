@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"strings"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// Document list changes reported in list_changed events
+const (
+	ListAdded   = "added"
+	ListRemoved = "removed"
+)
+
+// Document list page sizes for subscribe_list
+const (
+	DefaultListPageSize = 100
+	MaxListPageSize     = 1000
+)
+
+// listPage returns up to limit readable document IDs under prefix that sort
+// after cursor, and the cursor for the next page ("" when there is none).
+func (h *Hub) listPage(conn *Connection, access readAccess, prefix, cursor string, limit int) ([]string, string) {
+	docIDs := h.readableDocuments(conn, access, prefix, cursor)
+
+	if len(docIDs) <= limit {
+		return docIDs, ""
+	}
+	docIDs = docIDs[:limit]
+	return docIDs, docIDs[limit-1]
+}
+
+// listPageSize reads the requested page size, clamped to MaxListPageSize
+func listPageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultListPageSize
+	}
+	if limit > MaxListPageSize {
+		return MaxListPageSize
+	}
+	return limit
+}
+
+// notifyListChanged tells list subscribers whose prefix matches the document,
+// and who may read it, that it was added or removed
+func (h *Hub) notifyListChanged(docID, change string) {
+	h.mu.RLock()
+	type target struct {
+		conn   *Connection
+		prefix string
+	}
+	targets := make([]target, 0)
+	for prefix, subs := range h.listSubscribers {
+		if !strings.HasPrefix(docID, prefix) {
+			continue
+		}
+		for connID := range subs {
+			if conn := h.connections[connID]; conn != nil {
+				targets = append(targets, target{conn, prefix})
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, t := range targets {
+		if !h.mayReceive(t.conn, docID) {
+			continue
+		}
+		t.conn.Send(protocol.NewMessage(protocol.TypeListChanged, map[string]interface{}{
+			"prefix": t.prefix,
+			"docId":  docID,
+			"change": change,
+		}))
+	}
+}
+
+// removeListSubscriber drops a connection from a list subscription. Must be
+// called with h.mu held.
+func (h *Hub) removeListSubscriber(prefix, connID string) {
+	if subs, exists := h.listSubscribers[prefix]; exists {
+		delete(subs, connID)
+		if len(subs) == 0 {
+			delete(h.listSubscribers, prefix)
+		}
+	}
+}
+
+// DeleteDocument removes an in-memory document along with its resume history
+// and conflict metadata, and notifies list subscribers and the subscribers of
+// Subscribe. Subscriptions are kept, so writing to the document again
+// recreates it. It reports whether the document existed.
+func (h *Hub) DeleteDocument(docID string) bool {
+	h.docsMu.Lock()
+	_, exists := h.documents[docID]
+	delete(h.documents, docID)
+	h.loaded[docID] = true
+	delete(h.history, docID)
+	delete(h.meta, docID)
+	h.cache.remove(docID)
+	h.docsMu.Unlock()
+
+	if exists {
+		h.publishEvent(DocumentEvent{Type: EventDocumentDeleted, DocID: docID})
+		h.notifyListChanged(docID, ListRemoved)
+	}
+	return exists
+}
@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// DefaultMessageTimeout is how long handling one message may take, storage
+// calls included
+const DefaultMessageTimeout = 5 * time.Second
+
+// LoadFunc reads a document's stored state. A nil state with a nil error
+// means the document is not stored.
+type LoadFunc func(ctx context.Context, docID string) (map[string]interface{}, error)
+
+// newEvent wraps a message from conn. Its context expires after
+// MessageTimeout and is cancelled with parent or when Stop gives up on
+// queued messages.
+func (h *Hub) newEvent(parent context.Context, conn *Connection, msg *protocol.Message) *MessageEvent {
+	ctx, cancel := context.WithTimeout(parent, h.opts.MessageTimeout)
+	stop := context.AfterFunc(h.handlingCtx, cancel)
+	return &MessageEvent{
+		Connection: conn,
+		Message:    msg,
+		Context:    ctx,
+		cancel: func() {
+			stop()
+			cancel()
+		},
+	}
+}
+
+// context returns the event's context, or a background one for events
+// built without newEvent
+func (e *MessageEvent) context() context.Context {
+	if e.Context == nil {
+		return context.Background()
+	}
+	return e.Context
+}
+
+// release frees the event's context once it has been handled
+func (e *MessageEvent) release() {
+	if e.cancel != nil {
+		e.cancel()
+	}
+}
+
+// loadDocument makes sure a stored document is in memory before it is used,
+// reading it with HubOptions.Load the first time. If the read fails conn is
+// sent TIMEOUT or STORAGE_ERROR, the connection stays open, and false is
+// returned. Messages for a document are handled on its worker, so it is
+// loaded at most once.
+func (h *Hub) loadDocument(ctx context.Context, conn *Connection, docID string) bool {
+	if h.opts.Load == nil {
+		return true
+	}
+	h.docsMu.RLock()
+	loaded := h.loaded[docID]
+	h.docsMu.RUnlock()
+	if loaded {
+		return true
+	}
+
+	state, err := h.opts.Load(ctx, docID)
+	if err != nil && ctx.Err() != nil {
+		// Drivers do not always wrap the context's error
+		err = ctx.Err()
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		conn.Logger().Warn("Document load timed out", "doc_id", docID)
+		conn.replyError("Request timed out", "TIMEOUT")
+		return false
+	case errors.Is(err, context.Canceled):
+		// The hub is stopping or the caller went away
+		return false
+	case err != nil:
+		conn.Logger().Error("Document load failed", "doc_id", docID, "err", err)
+		conn.replyError("Failed to load document", "STORAGE_ERROR")
+		return false
+	}
+
+	h.docsMu.Lock()
+	defer h.docsMu.Unlock()
+	// An administrator may have restored or deleted the document meanwhile
+	if h.loaded[docID] {
+		return true
+	}
+	h.loaded[docID] = true
+	if state != nil {
+		if _, exists := h.documents[docID]; !exists {
+			h.documents[docID] = state
+			h.cache.reset(docID, documentSize(state))
+			h.metrics.documentReloads.Add(1)
+		}
+	}
+	return true
+}
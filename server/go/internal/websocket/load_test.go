@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// startHub runs a hub with opts and auth disabled, stopping it when the
+// test ends
+func startHub(t *testing.T, opts HubOptions) *Hub {
+	t.Helper()
+	hub := NewHubWithOptions(testAuth, opts)
+	go hub.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		hub.Stop(ctx)
+	})
+	return hub
+}
+
+func TestHub_LoadsStoredDocumentsOnce(t *testing.T) {
+	var loads atomic.Int32
+	hub := startHub(t, HubOptions{
+		Load: func(ctx context.Context, docID string) (map[string]interface{}, error) {
+			loads.Add(1)
+			if docID == "room:stored" {
+				return map[string]interface{}{"title": "From storage"}, nil
+			}
+			return nil, nil
+		},
+	})
+
+	alice := connectAnonymous(t, hub, "alice")
+	bob := connectAnonymous(t, hub, "bob")
+	for _, conn := range []*Connection{alice, bob} {
+		dispatch(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:stored"})
+		sync := expectMessage(t, conn, protocol.TypeSyncResponse)
+		if state, _ := sync.Payload["state"].(map[string]interface{}); state["title"] != "From storage" {
+			t.Errorf("state = %v, want the stored document", sync.Payload["state"])
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("loads = %d, want 1", n)
+	}
+
+	// A document that is not stored is created by its first delta
+	sendDelta(hub, alice, "room:new", "a", 1.0)
+	expectMessage(t, alice, protocol.TypeAck)
+	sendDelta(hub, alice, "room:new", "b", 2.0)
+	expectMessage(t, alice, protocol.TypeAck)
+	if n := loads.Load(); n != 2 {
+		t.Errorf("loads = %d, want 2", n)
+	}
+}
+
+// slowLoad blocks loading "room:slow" until the message's context is done,
+// reporting how it ended on ended
+func slowLoad(ended chan<- error) LoadFunc {
+	return func(ctx context.Context, docID string) (map[string]interface{}, error) {
+		if docID != "room:slow" {
+			return nil, nil
+		}
+		<-ctx.Done()
+		ended <- ctx.Err()
+		return nil, ctx.Err()
+	}
+}
+
+func TestHub_LoadTimeoutKeepsConnectionAndOtherDocuments(t *testing.T) {
+	ended := make(chan error, 1)
+	hub := startHub(t, HubOptions{Load: slowLoad(ended), MessageTimeout: 200 * time.Millisecond, Workers: 4})
+
+	// A document owned by another worker than the stuck one
+	fast := "room:fast"
+	for i := 0; workerFor(fast, 4) == workerFor("room:slow", 4); i++ {
+		fast = "room:fast-" + string(rune('a'+i))
+	}
+
+	alice := connectAnonymous(t, hub, "alice")
+	bob := connectAnonymous(t, hub, "bob")
+	start := time.Now()
+	dispatch(hub, alice, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:slow"})
+	subscribe(t, hub, bob, fast)
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("other document waited %v behind the slow load", elapsed)
+	}
+
+	expectError(t, alice, "TIMEOUT")
+	if err := <-ended; err != context.DeadlineExceeded {
+		t.Errorf("load ended with %v, want context.DeadlineExceeded", err)
+	}
+	dispatch(hub, alice, protocol.TypePing, nil)
+	expectMessage(t, alice, protocol.TypePong)
+	subscribe(t, hub, alice, fast)
+}
+
+func TestHub_StopCancelsOutstandingMessages(t *testing.T) {
+	ended := make(chan error, 1)
+	hub := NewHubWithOptions(testAuth, HubOptions{Load: slowLoad(ended), MessageTimeout: time.Minute})
+	go hub.Run()
+
+	alice := connectAnonymous(t, hub, "alice")
+	dispatch(hub, alice, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:slow"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	hub.Stop(ctx)
+	select {
+	case err := <-ended:
+		if err != context.Canceled {
+			t.Errorf("load ended with %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop did not cancel the outstanding load")
+	}
+}
@@ -0,0 +1,32 @@
+package websocket
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// connLogger is a connection's logger. base identifies the connection;
+// current adds the user once the connection authenticates.
+type connLogger struct {
+	base    *slog.Logger
+	current atomic.Pointer[slog.Logger]
+}
+
+// Logger returns the connection's logger, which tags records with conn_id
+// and, once authenticated, user_id. Safe to call from any goroutine.
+func (c *Connection) Logger() *slog.Logger {
+	return c.log.current.Load()
+}
+
+// SetLogger replaces the connection's logger, e.g. with one carrying the
+// upgrade's request ID. conn_id is added. Must be called before the
+// connection is registered.
+func (c *Connection) SetLogger(logger *slog.Logger) {
+	c.log.base = logger.With("conn_id", c.ID)
+	c.log.current.Store(c.log.base)
+}
+
+// logAs tags the connection's log records with userID from now on
+func (c *Connection) logAs(userID string) {
+	c.log.current.Store(c.log.base.With("user_id", userID))
+}
@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// logRecords decodes the JSON lines written to buf
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func findRecord(t *testing.T, records []map[string]interface{}, msg string) map[string]interface{} {
+	t.Helper()
+	for _, record := range records {
+		if record["msg"] == msg {
+			return record
+		}
+	}
+	t.Fatalf("no %q record in %v", msg, records)
+	return nil
+}
+
+func TestHub_ConnectionLogsCarryContext(t *testing.T) {
+	var buf bytes.Buffer
+	hub := NewHubWithOptions(testAuth, HubOptions{
+		Logger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+
+	conn := authWithToken(t, hub, "c1", auth.DocumentPermissions{CanRead: []string{"room:a"}})
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:b"})
+	expectError(t, conn, "PERMISSION_DENIED")
+	hub.unregister(conn)
+
+	records := logRecords(t, &buf)
+
+	registered := findRecord(t, records, "Connection registered")
+	if registered["conn_id"] != "c1" || registered["user_id"] != nil {
+		t.Errorf("registered record = %v, want conn_id c1 and no user_id", registered)
+	}
+
+	authed := findRecord(t, records, "Authenticated")
+	if authed["conn_id"] != "c1" || authed["user_id"] != "user-c1" || authed["anonymous"] != false {
+		t.Errorf("auth record = %v", authed)
+	}
+
+	denied := findRecord(t, records, "Subscribe denied")
+	if denied["level"] != "WARN" || denied["conn_id"] != "c1" || denied["user_id"] != "user-c1" ||
+		denied["doc_id"] != "room:b" || denied["code"] != "PERMISSION_DENIED" {
+		t.Errorf("denied record = %v", denied)
+	}
+
+	closed := findRecord(t, records, "Connection closed")
+	if closed["user_id"] != "user-c1" || closed["duration"] == nil {
+		t.Errorf("closed record = %v", closed)
+	}
+}
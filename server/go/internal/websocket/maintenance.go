@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// Maintenance describes maintenance mode, during which the server keeps
+// serving subscriptions and reads but refuses document writes
+type Maintenance struct {
+	Enabled bool
+
+	// Message is an optional operator message passed on to clients
+	Message string
+
+	// RetryAfter is an optional hint for when writes will be accepted again
+	RetryAfter time.Duration
+
+	// Since is when maintenance mode was entered
+	Since time.Time
+}
+
+// DefaultMaintenanceError is the error text of writes refused during
+// maintenance
+const DefaultMaintenanceError = "Server is in maintenance mode; writes are disabled"
+
+// Maintenance returns the current maintenance mode
+func (h *Hub) Maintenance() Maintenance {
+	h.maintMu.RLock()
+	defer h.maintMu.RUnlock()
+	return h.maintenance
+}
+
+// SetMaintenance enters or leaves maintenance mode and sends every
+// connection a maintenance notice with the new state. Writes already being
+// handled finish; later delta and delta_batch messages are refused with
+// MAINTENANCE_MODE. It returns the state now in effect.
+func (h *Hub) SetMaintenance(m Maintenance) Maintenance {
+	h.maintMu.Lock()
+	switch {
+	case !m.Enabled:
+		m = Maintenance{}
+	case h.maintenance.Enabled:
+		m.Since = h.maintenance.Since
+	default:
+		m.Since = time.Now()
+	}
+	h.maintenance = m
+	h.maintMu.Unlock()
+
+	if m.Enabled {
+		h.opts.Logger.Info("Maintenance mode enabled", "message", m.Message, "retry_after", m.RetryAfter)
+	} else {
+		h.opts.Logger.Info("Maintenance mode disabled")
+	}
+
+	h.mu.RLock()
+	conns := make([]*Connection, 0, len(h.connections))
+	for _, conn := range h.connections {
+		conns = append(conns, conn)
+	}
+	h.mu.RUnlock()
+
+	notice := m.notice(protocol.TypeMaintenance)
+	notice.Payload["enabled"] = m.Enabled
+	for _, conn := range conns {
+		conn.Send(notice)
+	}
+	return m
+}
+
+// refuseDuringMaintenance sends conn a MAINTENANCE_MODE error for a write
+// to docID and reports true if maintenance mode is on
+func (h *Hub) refuseDuringMaintenance(conn *Connection, docID string) bool {
+	m := h.Maintenance()
+	if !m.Enabled {
+		return false
+	}
+	refusal := m.notice(protocol.TypeError)
+	refusal.Payload["error"] = DefaultMaintenanceError
+	refusal.Payload["code"] = "MAINTENANCE_MODE"
+	refusal.Payload["docId"] = docID
+	conn.Send(refusal.WithOrigin(conn.handling()))
+	return true
+}
+
+// notice starts a message of msgType carrying the operator message and
+// retry hint, when set
+func (m Maintenance) notice(msgType string) *protocol.Message {
+	payload := make(map[string]interface{})
+	if m.Message != "" {
+		payload["message"] = m.Message
+	}
+	if m.RetryAfter > 0 {
+		payload["retryAfter"] = m.RetryAfter.Milliseconds()
+	}
+	return protocol.NewMessage(msgType, payload)
+}
@@ -0,0 +1,210 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/metrics"
+)
+
+// LatencyBuckets are the upper bounds of the handling latency histogram
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// HubMetrics is a point-in-time snapshot of hub instrumentation
+type HubMetrics struct {
+	QueueDepth        int                         `json:"queueDepth"` // Messages waiting in HandleMessage
+	BroadcastsSent    uint64                      `json:"broadcastsSent"`
+	SendsDropped      uint64                      `json:"sendsDropped"` // Sends refused by a full send queue
+	AuthFailures      uint64                      `json:"authFailures"`
+	PermissionDenials uint64                      `json:"permissionDenials"`
+	PersistFailures   uint64                      `json:"persistFailures"`   // Document writes that failed after retries
+	OriginRejections  uint64                      `json:"originRejections"`  // Upgrades refused by the origin policy
+	ChecksumFailures  uint64                      `json:"checksumFailures"`  // Client messages whose payload failed its checksum
+	DocumentsCached   int                         `json:"documentsCached"`   // Documents held in memory
+	DocumentBytes     int64                       `json:"documentBytes"`     // Approximate size of the documents held
+	DocumentEvictions uint64                      `json:"documentEvictions"` // Documents dropped from memory to stay within limits
+	DocumentReloads   uint64                      `json:"documentReloads"`   // Stored documents read into memory
+	StorageDegraded   bool                        `json:"storageDegraded"`   // Storage is down and writes are buffered
+	BufferedWrites    int                         `json:"bufferedWrites"`    // Documents with writes waiting or in flight
+	BufferOverflows   uint64                      `json:"bufferOverflows"`   // Writes refused by a full write buffer
+	Latency           map[string]LatencyHistogram `json:"latency"`           // Message type -> handling latency
+}
+
+// LatencyHistogram is a cumulative histogram of handling latency. Counts[i]
+// is the number of observations no slower than LatencyBuckets[i].
+type LatencyHistogram struct {
+	Counts []uint64      `json:"counts"`
+	Count  uint64        `json:"count"`
+	Sum    time.Duration `json:"sum"`
+}
+
+// FanoutBuckets are the upper bounds of the broadcast fan-out histogram
+var FanoutBuckets = []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 1000}
+
+// hubMetrics holds the live instruments, registered on
+// HubOptions.Metrics. Safe for concurrent use.
+type hubMetrics struct {
+	broadcastsSent    *metrics.Counter
+	sendsDropped      *metrics.Counter
+	authFailures      *metrics.Counter
+	permissionDenials *metrics.Counter
+	persistFailures   *metrics.Counter
+	originRejections  *metrics.Counter
+	checksumFailures  *metrics.Counter
+	documentEvictions *metrics.Counter
+	documentReloads   *metrics.Counter
+	bufferOverflows   *metrics.Counter
+	eventsDropped     *metrics.Counter
+	relayDivergences  *metrics.CounterVec   // By cause
+	messagesIn        *metrics.CounterVec   // By message type
+	messagesOut       *metrics.CounterVec   // By message type
+	tenantMessages    *metrics.CounterVec   // Client messages handled, by tenant
+	tenantDocuments   *metrics.CounterVec   // Documents created, by tenant
+	fanout            *metrics.Histogram    // Recipients per document broadcast
+	latency           *metrics.HistogramVec // Handling time by message type
+}
+
+func newHubMetrics(h *Hub, reg *metrics.Registry) *hubMetrics {
+	buckets := make([]float64, len(LatencyBuckets))
+	for i, b := range LatencyBuckets {
+		buckets[i] = b.Seconds()
+	}
+
+	m := &hubMetrics{
+		broadcastsSent:    reg.NewCounter("synckit_broadcasts_sent_total", "Messages sent to document subscribers."),
+		sendsDropped:      reg.NewCounter("synckit_sends_dropped_total", "Sends refused by a full send queue."),
+		authFailures:      reg.NewCounter("synckit_auth_failures_total", "Failed or timed out authentication attempts."),
+		permissionDenials: reg.NewCounter("synckit_permission_denials_total", "Document accesses refused for lack of permission."),
+		persistFailures:   reg.NewCounter("synckit_persist_failures_total", "Document writes that failed after retries."),
+		originRejections:  reg.NewCounter("synckit_origin_rejections_total", "Websocket upgrades refused by the origin policy."),
+		checksumFailures:  reg.NewCounter("synckit_checksum_failures_total", "Client messages whose payload failed its checksum."),
+		documentEvictions: reg.NewCounter("synckit_document_evictions_total", "Documents dropped from memory to stay within the cache limits."),
+		documentReloads:   reg.NewCounter("synckit_document_reloads_total", "Stored documents read into memory."),
+		bufferOverflows:   reg.NewCounter("synckit_write_buffer_overflows_total", "Document writes refused while storage was down because the write buffer was full."),
+		eventsDropped:     reg.NewCounter("synckit_events_dropped_total", "Document events dropped for Subscribe readers that fell behind."),
+		relayDivergences:  reg.NewCounterVec("synckit_relay_divergences_total", "Document copies found to differ from another server's, by cause.", "cause"),
+		messagesIn:        reg.NewCounterVec("synckit_messages_received_total", "Client messages handled, by type.", "type"),
+		messagesOut:       reg.NewCounterVec("synckit_messages_sent_total", "Messages queued to clients, by type.", "type"),
+		tenantMessages:    reg.NewCounterVec("synckit_tenant_messages_received_total", "Client messages handled for tokens with a tenant, by tenant.", "tenant"),
+		tenantDocuments:   reg.NewCounterVec("synckit_tenant_documents_created_total", "Documents created by clients with a tenant, by tenant.", "tenant"),
+		fanout:            reg.NewHistogram("synckit_broadcast_fanout", "Recipients per document broadcast.", FanoutBuckets),
+		latency:           reg.NewHistogramVec("synckit_message_handling_seconds", "Time to handle a client message, by type.", buckets, "type"),
+	}
+
+	reg.NewGaugeFunc("synckit_connections_active", "Registered websocket connections.", func() float64 {
+		return float64(h.ConnectionCount())
+	})
+	reg.NewGaugeFunc("synckit_subscriptions", "Document subscriptions across all connections.", func() float64 {
+		return float64(h.subscriptionCount())
+	})
+	reg.NewGaugeFunc("synckit_documents_cached", "Documents held in memory.", func() float64 {
+		n, _ := h.cache.stats()
+		return float64(n)
+	})
+	reg.NewGaugeFunc("synckit_document_cache_bytes", "Approximate size of the documents held in memory.", func() float64 {
+		_, bytes := h.cache.stats()
+		return float64(bytes)
+	})
+	reg.NewGaugeFunc("synckit_storage_degraded", "1 while storage is down and document writes are buffered in memory.", func() float64 {
+		if h.Degraded() {
+			return 1
+		}
+		return 0
+	})
+	reg.NewGaugeFunc("synckit_write_buffer_documents", "Documents with writes waiting or in flight.", func() float64 {
+		if h.writer == nil {
+			return 0
+		}
+		return float64(h.writer.Pending())
+	})
+	reg.NewGaugeFunc("synckit_hub_queue_depth", "Messages waiting to be handled.", func() float64 {
+		return float64(len(h.HandleMessage))
+	})
+	reg.NewHistogramFunc("synckit_connections_per_ip", "Distribution of registered connections per client IP.",
+		[]float64{1, 2, 5, 10, 25, 50, 100}, h.connectionsPerIP)
+	return m
+}
+
+// observeLatency records how long handling a message of msgType took
+func (m *hubMetrics) observeLatency(msgType string, d time.Duration) {
+	m.messagesIn.With(msgType).Inc()
+	m.latency.With(msgType).Observe(d.Seconds())
+}
+
+// subscriptionCount returns the number of document subscriptions
+func (h *Hub) subscriptionCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	n := 0
+	for _, subs := range h.subscribers {
+		n += len(subs)
+	}
+	return n
+}
+
+// connectionsPerIP returns the number of registered connections from each
+// client IP
+func (h *Hub) connectionsPerIP() []float64 {
+	h.mu.RLock()
+	counts := make(map[string]int)
+	for _, conn := range h.connections {
+		counts[conn.ClientIP]++
+	}
+	h.mu.RUnlock()
+
+	values := make([]float64, 0, len(counts))
+	for _, n := range counts {
+		values = append(values, float64(n))
+	}
+	return values
+}
+
+// Metrics returns a snapshot of the hub's counters, latency histograms and
+// HandleMessage queue depth
+func (h *Hub) Metrics() HubMetrics {
+	snapshot := HubMetrics{
+		QueueDepth:        len(h.HandleMessage),
+		BroadcastsSent:    h.metrics.broadcastsSent.Value(),
+		SendsDropped:      h.metrics.sendsDropped.Value(),
+		AuthFailures:      h.metrics.authFailures.Value(),
+		PermissionDenials: h.metrics.permissionDenials.Value(),
+		PersistFailures:   h.metrics.persistFailures.Value(),
+		OriginRejections:  h.metrics.originRejections.Value(),
+		ChecksumFailures:  h.metrics.checksumFailures.Value(),
+		DocumentEvictions: h.metrics.documentEvictions.Value(),
+		DocumentReloads:   h.metrics.documentReloads.Value(),
+		StorageDegraded:   h.Degraded(),
+		BufferOverflows:   h.metrics.bufferOverflows.Value(),
+		Latency:           make(map[string]LatencyHistogram),
+	}
+	snapshot.DocumentsCached, snapshot.DocumentBytes = h.cache.stats()
+	if h.writer != nil {
+		snapshot.BufferedWrites = h.writer.Pending()
+	}
+
+	h.metrics.latency.Each(func(labels []string, hist *metrics.Histogram) {
+		counts, count, sum := hist.Snapshot()
+		snapshot.Latency[labels[0]] = LatencyHistogram{
+			Counts: counts,
+			Count:  count,
+			Sum:    time.Duration(sum * float64(time.Second)),
+		}
+	})
+	return snapshot
+}
+
+// RecordOriginRejection counts a websocket upgrade refused by the origin
+// policy, which happens before the connection reaches the hub
+func (h *Hub) RecordOriginRejection() {
+	h.metrics.originRejections.Add(1)
+}
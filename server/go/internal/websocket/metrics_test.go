@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+func TestHub_MetricsCountMessageFlows(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer", "room:metrics")
+	joinDirect(t, hub, "reader-1", "room:metrics")
+	joinDirect(t, hub, "reader-2", "room:metrics")
+
+	sendDelta(hub, writer, "room:metrics", "k", "v")
+	handleDirect(hub, writer, protocol.TypePing, nil)
+
+	m := hub.Metrics()
+	if m.BroadcastsSent != 2 {
+		t.Errorf("BroadcastsSent = %d, want 2", m.BroadcastsSent)
+	}
+	for _, msgType := range []string{protocol.TypeAuth, protocol.TypeSubscribe, protocol.TypeDelta, protocol.TypePing} {
+		hist, ok := m.Latency[msgType]
+		if !ok || hist.Count == 0 {
+			t.Errorf("Latency[%q] = %+v, want observations", msgType, hist)
+			continue
+		}
+		if last := hist.Counts[len(hist.Counts)-1]; last > hist.Count {
+			t.Errorf("Latency[%q] cumulative count %d exceeds total %d", msgType, last, hist.Count)
+		}
+	}
+	if got := m.Latency[protocol.TypeDelta].Count; got != 1 {
+		t.Errorf("Latency[delta].Count = %d, want 1", got)
+	}
+}
+
+func TestHub_MetricsCountFailures(t *testing.T) {
+	hub := NewHub(AuthConfig{JWTSecret: testSecret, Required: true})
+
+	conn := newTestConnection(hub, "bad-token")
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": "not-a-jwt"})
+	handleDirect(hub, conn, protocol.TypeAuth, nil)
+	if got := hub.Metrics().AuthFailures; got != 2 {
+		t.Errorf("AuthFailures = %d, want 2", got)
+	}
+
+	viewer := authWithToken(t, hub, "viewer", auth.CreateUserPermissions([]string{"room:open"}, nil))
+	handleDirect(hub, viewer, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:closed"})
+	sendDelta(hub, viewer, "room:open", "k", "v")
+	if got := hub.Metrics().PermissionDenials; got != 2 {
+		t.Errorf("PermissionDenials = %d, want 2", got)
+	}
+}
+
+func TestHub_MetricsSendsDroppedAndQueueDepth(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer")
+	reader := joinDirect(t, hub, "reader", "room:full")
+
+	// Fill the reader's send queue so the broadcast cannot be enqueued
+	for len(reader.send) < cap(reader.send) {
+		reader.send <- []byte{}
+	}
+	sendDelta(hub, writer, "room:full", "k", "v")
+	if got := hub.Metrics().SendsDropped; got != 1 {
+		t.Errorf("SendsDropped = %d, want 1", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		dispatch(hub, writer, protocol.TypePing, nil)
+	}
+	if got := hub.Metrics().QueueDepth; got != 3 {
+		t.Errorf("QueueDepth = %d, want 3", got)
+	}
+}
+
+func TestHub_StatsCountActivity(t *testing.T) {
+	hub := NewHub(testAuth)
+	writer := joinDirect(t, hub, "writer", "room:stats")
+	joinDirect(t, hub, "reader", "room:stats")
+	hub.register(newTestConnection(hub, "anonymous"))
+
+	// Stats counts connections on the Run goroutine, so start it only after
+	// the direct setup above
+	go hub.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		hub.Stop(ctx)
+	})
+
+	before := hub.Stats()
+	if before.Connections != 3 || before.AuthenticatedConnections != 2 || before.Subscriptions != 2 {
+		t.Errorf("Stats = %+v, want 3 connections, 2 authenticated, 2 subscriptions", before)
+	}
+
+	hub.exec(func() { sendDelta(hub, writer, "room:stats", "k", "v") })
+	after := hub.Stats()
+	if after.MessagesHandled != before.MessagesHandled+1 || after.BroadcastsSent != before.BroadcastsSent+1 {
+		t.Errorf("after a delta Stats = %+v, before %+v; want one more message and broadcast", after, before)
+	}
+	if after.Documents != 1 {
+		t.Errorf("Documents = %d, want 1", after.Documents)
+	}
+}
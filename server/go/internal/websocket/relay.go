@@ -0,0 +1,259 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Relay carries document traffic between servers sharing a broker.
+// storage.RedisPubSub, storage.RedisStreams and broker.NATS implement it.
+type Relay interface {
+	PublishDelta(ctx context.Context, documentID string, delta interface{}) error
+	PublishAwareness(ctx context.Context, documentID string, state interface{}) error
+	SubscribeToDocument(ctx context.Context, documentID string, handler func([]byte)) error
+	UnsubscribeFromDocument(ctx context.Context, documentID string) error
+}
+
+// Kinds of relayed message
+const (
+	relayDelta            = "delta"
+	relayAwareness        = "awareness"
+	relayAwarenessRemoved = "awareness_removed"
+	relayChecksum         = "checksum"
+)
+
+// relayMessage is what one server publishes to a document's channel. The
+// server ID lets the publisher ignore its own messages.
+type relayMessage struct {
+	ServerID  string                 `json:"serverId"`
+	Kind      string                 `json:"kind"`
+	DocID     string                 `json:"docId"`
+	ClientID  string                 `json:"clientId"`
+	Timestamp int64                  `json:"timestamp,omitempty"`
+	Delta     map[string]interface{} `json:"delta,omitempty"`
+	State     map[string]interface{} `json:"state,omitempty"`
+
+	// Seq is the publisher's sequence number of a delta, or of its last
+	// delta covered by a checksum
+	Seq int64 `json:"seq,omitempty"`
+
+	// Checksum is the publisher's hash of the document state, covering
+	// the deltas in Versions (server ID -> last sequence number applied)
+	Checksum string           `json:"checksum,omitempty"`
+	Versions map[string]int64 `json:"versions,omitempty"`
+}
+
+// relaySubscriptions tracks the document channels the hub listens on
+type relaySubscriptions struct {
+	mu   sync.Mutex // Held across Redis calls so changes apply in order
+	docs map[string]bool
+}
+
+// ServerID identifies this hub in relayed messages
+func (h *Hub) ServerID() string {
+	return h.opts.ServerID
+}
+
+// syncRelay subscribes to the channels of documents that gained their first
+// local subscriber and unsubscribes from those that lost their last. It
+// compares against the current subscribers, so calls racing for the same
+// document settle on the right state. Must not be called holding h.mu.
+func (h *Hub) syncRelay(docIDs ...string) {
+	if h.opts.Relay == nil {
+		return
+	}
+	h.relaySubs.mu.Lock()
+	defer h.relaySubs.mu.Unlock()
+
+	for _, docID := range docIDs {
+		h.mu.RLock()
+		wanted := len(h.subscribers[docID]) > 0
+		h.mu.RUnlock()
+		if wanted == h.relaySubs.docs[docID] {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(h.handlingCtx, h.opts.MessageTimeout)
+		var err error
+		if wanted {
+			err = h.opts.Relay.SubscribeToDocument(ctx, docID, h.receiveRelayed)
+		} else {
+			err = h.opts.Relay.UnsubscribeFromDocument(ctx, docID)
+		}
+		cancel()
+		if err != nil {
+			h.opts.Logger.Warn("Relay subscription change failed", "doc_id", docID, "subscribe", wanted, "err", err)
+			continue
+		}
+		if wanted {
+			h.relaySubs.docs[docID] = true
+		} else {
+			delete(h.relaySubs.docs, docID)
+			h.forgetVersions(docID)
+		}
+	}
+}
+
+// relayDeltas publishes deltas applied on this server to the other servers,
+// each with the sequence number it was applied at. Called on the document's
+// worker, so deltas are published in sequence order.
+func (h *Hub) relayDeltas(docID, clientID string, deltas []map[string]interface{}, fallbackTs int64) {
+	if h.opts.Relay == nil {
+		return
+	}
+	for _, delta := range deltas {
+		unstamped := make(map[string]interface{}, len(delta))
+		for k, v := range delta {
+			if k != "seq" {
+				unstamped[k] = v
+			}
+		}
+		seq, _ := delta["seq"].(int64)
+		h.publishRelayed(relayMessage{
+			Kind:      relayDelta,
+			DocID:     docID,
+			ClientID:  clientID,
+			Timestamp: fallbackTs,
+			Delta:     unstamped,
+			Seq:       seq,
+		})
+		h.notePublished(docID, seq)
+	}
+}
+
+// relayAwareness publishes a local client's awareness state to the other
+// servers
+func (h *Hub) relayAwareness(docID, clientID string, state map[string]interface{}) {
+	if h.opts.Relay == nil {
+		return
+	}
+	h.publishRelayed(relayMessage{
+		Kind:     relayAwareness,
+		DocID:    docID,
+		ClientID: clientID,
+		State:    state,
+	})
+}
+
+// relayAwarenessRemoved tells the other servers a client's awareness state
+// is gone
+func (h *Hub) relayAwarenessRemoved(docID, clientID string) {
+	if h.opts.Relay == nil {
+		return
+	}
+	h.publishRelayed(relayMessage{
+		Kind:     relayAwarenessRemoved,
+		DocID:    docID,
+		ClientID: clientID,
+	})
+}
+
+func (h *Hub) publishRelayed(msg relayMessage) {
+	msg.ServerID = h.opts.ServerID
+	ctx, cancel := context.WithTimeout(h.handlingCtx, h.opts.MessageTimeout)
+	defer cancel()
+	// Checksums travel with the deltas they cover
+	publish := h.opts.Relay.PublishDelta
+	if msg.Kind != relayDelta && msg.Kind != relayChecksum {
+		publish = h.opts.Relay.PublishAwareness
+	}
+	if err := publish(ctx, msg.DocID, msg); err != nil {
+		h.opts.Logger.Warn("Relay publish failed", "doc_id", msg.DocID, "kind", msg.Kind, "err", err)
+	}
+}
+
+// receiveRelayed applies a message published by another server and fans it
+// out to local subscribers. Remote deltas get local sequence numbers and
+// are not persisted here; the publishing server stores them.
+func (h *Hub) receiveRelayed(data []byte) {
+	var msg relayMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.opts.Logger.Warn("Dropped malformed relay message", "err", err)
+		return
+	}
+	if msg.ServerID == h.opts.ServerID || msg.DocID == "" {
+		return
+	}
+
+	switch msg.Kind {
+	case relayDelta:
+		if msg.Delta == nil {
+			return
+		}
+		h.docsMu.Lock()
+		result := h.applyDelta(msg.DocID, msg.ClientID, msg.Delta, msg.Timestamp, fieldFilter{}, false)
+		if msg.Seq > 0 {
+			h.noteVersion(msg.DocID, msg.ServerID, msg.Seq)
+		}
+		var turn *fanoutTurn
+		if result.applied() {
+			turn = h.history[msg.DocID].turn
+		}
+		h.docsMu.Unlock()
+
+		if result.applied() {
+			h.broadcastInOrder(turn, msg.DocID, result.seq, []map[string]interface{}{result.delta}, nil, result.created, nil)
+		}
+		if result.created {
+			h.notifyListChanged(msg.DocID, ListAdded)
+		}
+
+	case relayAwareness:
+		if msg.State == nil {
+			return
+		}
+		// Remote states expire through the stale awareness cleanup
+		msg.State["lastUpdate"] = float64(time.Now().UnixMilli())
+		prev := h.storeAwareness(msg.DocID, msg.ClientID, msg.State)
+		h.broadcastAwareness(msg.DocID, msg.ClientID, prev, msg.State, "", "")
+
+	case relayAwarenessRemoved:
+		if h.deleteAwareness(msg.DocID, msg.ClientID) {
+			h.broadcastAwareness(msg.DocID, msg.ClientID, nil, nil, "", "")
+		}
+
+	case relayChecksum:
+		h.receiveChecksum(msg)
+	}
+}
+
+// ResyncDocuments recovers documents whose relayed deltas may have been
+// missed, e.g. while Redis was unreachable. With storage configured this
+// server's pending writes are flushed and each document is reloaded,
+// picking up what other servers stored meanwhile. Subscribers are sent
+// sync_required either way. The first flush or load error is returned.
+func (h *Hub) ResyncDocuments(ctx context.Context, docIDs []string) error {
+	return h.reloadDocuments(ctx, docIDs, DivergenceRelayGap)
+}
+
+// reloadDocuments flushes this server's pending writes, reloads each
+// document from storage and sends its subscribers sync_required with reason
+func (h *Hub) reloadDocuments(ctx context.Context, docIDs []string, reason string) error {
+	var firstErr error
+	canReload := h.opts.Load != nil
+	if h.writer != nil && canReload {
+		if err := h.writer.Flush(ctx); err != nil {
+			// Reloading now would drop this server's unwritten deltas
+			firstErr, canReload = err, false
+		}
+	}
+
+	for _, docID := range docIDs {
+		reloaded := false
+		if canReload {
+			state, err := h.opts.Load(ctx, docID)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if err == nil && state != nil {
+				h.replaceDocument(docID, state)
+				reloaded = true
+			}
+		}
+		h.opts.Logger.Info("Resyncing document", "doc_id", docID, "reason", reason, "reloaded", reloaded)
+		h.requireSync(docID, reason)
+	}
+	return firstErr
+}
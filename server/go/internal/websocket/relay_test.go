@@ -0,0 +1,283 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// relayedHub starts a hub with opts, relaying and sharing awareness through
+// the Redis at addr
+func relayedHub(t *testing.T, addr string, opts HubOptions) (*Hub, *storage.RedisPubSub) {
+	t.Helper()
+	pubsub, err := storage.NewRedisPubSub(&storage.RedisPubSubConfig{URL: "redis://" + addr, ChannelPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("NewRedisPubSub: %v", err)
+	}
+	if err := pubsub.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	opts.Relay = pubsub
+	opts.SharedAwareness = storage.NewRedisAwareness(pubsub, 0)
+	hub := startHub(t, opts)
+	t.Cleanup(func() { pubsub.Disconnect(context.Background()) })
+	return hub, pubsub
+}
+
+func TestHub_RelaysDeltasAndAwarenessBetweenServers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	hubA, _ := relayedHub(t, mr.Addr(), HubOptions{})
+	hubB, pubsubB := relayedHub(t, mr.Addr(), HubOptions{})
+
+	alice := connectAnonymous(t, hubA, "alice")
+	bob := connectAnonymous(t, hubB, "bob")
+	subscribe(t, hubA, alice, "room:shared")
+	subscribe(t, hubB, bob, "room:shared")
+
+	sendDelta(hubA, alice, "room:shared", "title", "From A")
+	expectMessage(t, alice, protocol.TypeAck)
+	delta := expectMessage(t, bob, protocol.TypeDelta)
+	if changes, _ := delta.Payload["changes"].(map[string]interface{}); changes["title"] != "From A" {
+		t.Errorf("relayed changes = %v, want title from A", delta.Payload["changes"])
+	}
+	if seq, _ := delta.Payload["seq"].(float64); seq != 1 {
+		t.Errorf("relayed seq = %v, want 1 on hub B", delta.Payload["seq"])
+	}
+
+	// The relayed delta is part of hub B's state for later subscribers
+	carol := connectAnonymous(t, hubB, "carol")
+	dispatch(hubB, carol, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:shared"})
+	sync := expectMessage(t, carol, protocol.TypeSyncResponse)
+	if state, _ := sync.Payload["state"].(map[string]interface{}); state["title"] != "From A" {
+		t.Errorf("hub B state = %v, want the relayed title", sync.Payload["state"])
+	}
+
+	dispatch(hubA, alice, protocol.TypeAwarenessUpdate, map[string]interface{}{
+		"docId": "room:shared",
+		"state": map[string]interface{}{"cursor": 3.0},
+	})
+	aware := expectMessage(t, bob, protocol.TypeAwarenessState)
+	if aware.Payload["clientId"] != alice.ClientID {
+		t.Errorf("awareness clientId = %v, want %s", aware.Payload["clientId"], alice.ClientID)
+	}
+
+	// A server does not apply its own messages a second time
+	flushHub(t, hubA)
+	hubA.docsMu.RLock()
+	seqA := hubA.currentSeq("room:shared")
+	hubA.docsMu.RUnlock()
+	if seqA != 1 {
+		t.Errorf("hub A seq = %d, want 1", seqA)
+	}
+
+	// The channel is dropped once hub B has no subscribers left
+	dispatch(hubB, bob, protocol.TypeUnsubscribe, map[string]interface{}{"docId": "room:shared"})
+	dispatch(hubB, carol, protocol.TypeUnsubscribe, map[string]interface{}{"docId": "room:shared"})
+	flushHub(t, hubB)
+	if n := pubsubB.GetStats().SubscribedChannels; n != 0 {
+		t.Errorf("hub B channels = %d, want 0", n)
+	}
+}
+
+// ageAwareness makes a client's awareness state on hub, and in the shared
+// store, older than AwarenessTimeout
+func ageAwareness(t *testing.T, hub *Hub, mr *miniredis.Miniredis, docID, clientID string) {
+	t.Helper()
+	old := time.Now().Add(-2 * AwarenessTimeout).UnixMilli()
+	hub.awareMu.Lock()
+	if state, ok := hub.awareness[docID][clientID].(map[string]interface{}); ok {
+		state["lastUpdate"] = float64(old)
+	}
+	hub.awareMu.Unlock()
+	if _, err := mr.ZAdd("test:awareness:{"+docID+"}:seen", float64(old), clientID); err != nil {
+		t.Fatalf("ZAdd: %v", err)
+	}
+}
+
+func TestHub_SharesAwarenessBetweenServers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	hubA, _ := relayedHub(t, mr.Addr(), HubOptions{})
+	hubB, _ := relayedHub(t, mr.Addr(), HubOptions{})
+
+	alice := connectAnonymous(t, hubA, "alice")
+	subscribe(t, hubA, alice, "room:shared")
+	dispatch(hubA, alice, protocol.TypeAwarenessUpdate, map[string]interface{}{
+		"docId": "room:shared",
+		"state": map[string]interface{}{"cursor": 3.0},
+	})
+	flushHub(t, hubA)
+
+	// Hub B was not following the document when alice's cursor moved
+	bob := connectAnonymous(t, hubB, "bob")
+	subscribe(t, hubB, bob, "room:shared")
+	dispatch(hubB, bob, protocol.TypeAwarenessSubscribe, map[string]interface{}{"docId": "room:shared"})
+	snapshot := expectMessage(t, bob, protocol.TypeAwarenessState)
+	states, _ := snapshot.Payload["states"].([]interface{})
+	if len(states) != 1 {
+		t.Fatalf("awareness states on hub B = %v, want alice's", snapshot.Payload["states"])
+	}
+	entry, _ := states[0].(map[string]interface{})
+	if state, _ := entry["state"].(map[string]interface{}); entry["clientId"] != alice.ClientID || state["cursor"] != 3.0 {
+		t.Errorf("awareness state on hub B = %v, want alice's cursor", entry)
+	}
+
+	// Leaving is propagated
+	dispatch(hubA, alice, protocol.TypeUnsubscribe, map[string]interface{}{"docId": "room:shared"})
+	removed := expectMessage(t, bob, protocol.TypeAwarenessState)
+	if removed.Payload["clientId"] != alice.ClientID || removed.Payload["state"] != nil {
+		t.Errorf("removal = %v, want alice with a null state", removed.Payload)
+	}
+
+	// Stale states are expired by one server, and announced once
+	carol := connectAnonymous(t, hubA, "carol")
+	subscribe(t, hubA, carol, "room:shared")
+	dispatch(hubA, carol, protocol.TypeAwarenessUpdate, map[string]interface{}{
+		"docId": "room:shared",
+		"state": map[string]interface{}{"cursor": 5.0},
+	})
+	expectMessage(t, bob, protocol.TypeAwarenessState)
+	flushHub(t, hubA)
+	ageAwareness(t, hubA, mr, "room:shared", carol.ClientID)
+	ageAwareness(t, hubB, mr, "room:shared", carol.ClientID)
+	hubA.cleanupStaleAwareness()
+	hubB.cleanupStaleAwareness()
+	expired := expectMessage(t, bob, protocol.TypeAwarenessState)
+	if expired.Payload["clientId"] != carol.ClientID || expired.Payload["state"] != nil {
+		t.Errorf("expiry = %v, want carol with a null state", expired.Payload)
+	}
+	select {
+	case data := <-bob.send:
+		t.Errorf("second expiry notice: %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if states, _ := hubB.opts.SharedAwareness.GetAwareness(context.Background(), "room:shared"); len(states) != 0 {
+		t.Errorf("shared states after expiry = %v, want none", states)
+	}
+}
+
+func TestHub_ResyncDocumentsReloadsAndNotifiesSubscribers(t *testing.T) {
+	stored := map[string]interface{}{"title": "Stored"}
+	hub := startHub(t, HubOptions{
+		Load: func(ctx context.Context, docID string) (map[string]interface{}, error) {
+			return stored, nil
+		},
+	})
+	alice := connectAnonymous(t, hub, "alice")
+	subscribe(t, hub, alice, "room:gap")
+
+	// Another server stored a newer title while the relay was down
+	stored = map[string]interface{}{"title": "Written elsewhere"}
+	if err := hub.ResyncDocuments(context.Background(), []string{"room:gap"}); err != nil {
+		t.Fatalf("ResyncDocuments: %v", err)
+	}
+	notice := expectMessage(t, alice, protocol.TypeSyncRequired)
+	if notice.Payload["reason"] != DivergenceRelayGap || notice.Payload["docId"] != "room:gap" {
+		t.Errorf("sync_required = %v, want a relay_gap notice for room:gap", notice.Payload)
+	}
+
+	dispatch(hub, alice, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:gap"})
+	sync := expectMessage(t, alice, protocol.TypeSyncResponse)
+	if state, _ := sync.Payload["state"].(map[string]interface{}); state["title"] != "Written elsewhere" {
+		t.Errorf("state after resync = %v, want the stored title", sync.Payload["state"])
+	}
+}
+
+// waitPublished waits until hub has recorded relaying its latest delta to
+// docID, which publishChecksums needs before it covers the document
+func waitPublished(t *testing.T, hub *Hub, docID string) {
+	t.Helper()
+	waitFor(t, docID+" relayed", func() bool {
+		hub.docsMu.RLock()
+		defer hub.docsMu.RUnlock()
+		return hub.versions[docID].published == hub.currentSeq(docID)
+	})
+}
+
+func TestHub_ChecksumsRepairDivergedCopies(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var mu sync.Mutex
+	stored := make(map[string]map[string]interface{})
+	opts := HubOptions{
+		Persist: func(ctx context.Context, docID string, state map[string]interface{}) error {
+			mu.Lock()
+			defer mu.Unlock()
+			stored[docID] = state
+			return nil
+		},
+		Load: func(ctx context.Context, docID string) (map[string]interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return stored[docID], nil
+		},
+	}
+	hubA, pubsubA := relayedHub(t, mr.Addr(), opts)
+	hubB, pubsubB := relayedHub(t, mr.Addr(), opts)
+	ctx := context.Background()
+
+	alice := connectAnonymous(t, hubA, "alice")
+	bob := connectAnonymous(t, hubB, "bob")
+	subscribe(t, hubA, alice, "room:sum")
+	subscribe(t, hubB, bob, "room:sum")
+	sendDelta(hubA, alice, "room:sum", "title", "One")
+	expectMessage(t, bob, protocol.TypeDelta)
+
+	// In step, checksums agree
+	waitPublished(t, hubA, "room:sum")
+	hubA.publishChecksums()
+	hubB.publishChecksums()
+	flushHub(t, hubA)
+	flushHub(t, hubB)
+
+	// Hub B misses A's second delta. It listens again only once a message
+	// published after the delta has reached it, so the delta has gone by.
+	pubsubB.UnsubscribeFromDocument(ctx, "room:sum")
+	sendDelta(hubA, alice, "room:sum", "title", "Two")
+	expectMessage(t, alice, protocol.TypeAck)
+	waitPublished(t, hubA, "room:sum")
+	marker := make(chan struct{}, 1)
+	pubsubB.SubscribeToDocument(ctx, "room:marker", func([]byte) { marker <- struct{}{} })
+	pubsubA.PublishDelta(ctx, "room:marker", map[string]interface{}{})
+	select {
+	case <-marker:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the marker on hub B")
+	}
+	pubsubB.SubscribeToDocument(ctx, "room:sum", hubB.receiveRelayed)
+	if err := hubA.writer.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	waitPublished(t, hubA, "room:sum")
+	hubA.publishChecksums()
+	notice := expectMessage(t, bob, protocol.TypeSyncRequired)
+	if notice.Payload["reason"] != DivergenceChecksum {
+		t.Errorf("sync_required = %v, want reason %s", notice.Payload, DivergenceChecksum)
+	}
+	dispatch(hubB, bob, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:sum"})
+	resync := expectMessage(t, bob, protocol.TypeSyncResponse)
+	if state, _ := resync.Payload["state"].(map[string]interface{}); state["title"] != "Two" {
+		t.Errorf("hub B state after repair = %v, want the missed title", resync.Payload["state"])
+	}
+
+	// A copy that differs without any missed delta is reloaded too
+	hubB.docsMu.Lock()
+	hubB.documents["room:sum"]["title"] = "Corrupt"
+	hubB.docsMu.Unlock()
+	hubA.publishChecksums()
+	expectMessage(t, bob, protocol.TypeSyncRequired)
+
+	for cause, want := range map[string]uint64{divergenceMissed: 1, divergenceMismatch: 1} {
+		if n := hubB.metrics.relayDivergences.With(cause).Value(); n != want {
+			t.Errorf("%s divergences on hub B = %d, want %d", cause, n, want)
+		}
+	}
+	if n := hubA.metrics.relayDivergences.With(divergenceMismatch).Value(); n != 0 {
+		t.Errorf("mismatches on hub A = %d, want 0", n)
+	}
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+)
+
+// credentials is what checkCredentials found for an auth message's token or
+// API key
+type credentials struct {
+	token   *auth.TokenPayload
+	revoked bool
+
+	// Why the credentials were refused, and the auth_error code and message
+	// saying so
+	err           error
+	code, message string
+}
+
+// checkCredentials verifies a token, or an API key without one, and checks
+// the token against revocations. Fetching keys and the stores it consults
+// may take a while, so the hub calls it off the Run goroutine.
+func (h *Hub) checkCredentials(ctx context.Context, conn *Connection, token, apiKey string) *credentials {
+	creds := &credentials{code: "INVALID_TOKEN", message: "Invalid or expired token"}
+	if token != "" {
+		creds.token, creds.err = h.verifyToken(token)
+	} else {
+		creds.token, creds.err = h.authenticateAPIKey(ctx, apiKey)
+		creds.code, creds.message = "INVALID_API_KEY", "Invalid or revoked API key"
+	}
+	if creds.err == nil {
+		creds.revoked = h.tokenRevoked(ctx, conn, creds.token)
+	}
+	return creds
+}
+
+// verifyToken verifies a client's token with HubOptions.Verifier, or the
+// hub's JWT secret without one
+func (h *Hub) verifyToken(token string) (*auth.TokenPayload, error) {
+	if h.opts.Verifier != nil {
+		return h.opts.Verifier.VerifyToken(token)
+	}
+	return auth.VerifyToken(token, h.authConfig.JWTSecret)
+}
+
+// authenticateAPIKey resolves an API key with HubOptions.APIKeys
+func (h *Hub) authenticateAPIKey(ctx context.Context, key string) (*auth.TokenPayload, error) {
+	if h.opts.APIKeys == nil {
+		return nil, auth.ErrInvalidAPIKey
+	}
+	return h.opts.APIKeys.Authenticate(ctx, key)
+}
+
+// tokenRevoked checks a verified token against HubOptions.Revocations. A
+// store that cannot be reached is logged and the token allowed, so an
+// outage does not lock every client out.
+func (h *Hub) tokenRevoked(ctx context.Context, conn *Connection, token *auth.TokenPayload) bool {
+	if h.opts.Revocations == nil || token == nil {
+		return false
+	}
+	revoked, err := h.opts.Revocations.IsRevoked(ctx, token)
+	if err != nil {
+		conn.Logger().Warn("Token revocation check failed", "err", err)
+		return false
+	}
+	return revoked
+}
+
+// refuseRevoked closes conn with TOKEN_REVOKED if CheckRevocationOnWrite is
+// set and its token has been revoked since it authenticated. Called while
+// handling one of conn's messages.
+func (h *Hub) refuseRevoked(ctx context.Context, conn *Connection) bool {
+	if !h.opts.CheckRevocationOnWrite || conn.VerifiedUserID() == "" {
+		return false
+	}
+	if !h.tokenRevoked(ctx, conn, conn.TokenPayload) {
+		return false
+	}
+	h.audit(conn, audit.EventAuthFailure, "", map[string]interface{}{"code": "TOKEN_REVOKED"})
+	h.sendKick(conn, "Token has been revoked", "TOKEN_REVOKED", "Token revoked")
+	h.removeConnection(conn)
+	return true
+}
+
+// DisconnectToken closes every connection authenticated with the token
+// whose ID (jti) is tokenID. Clients receive a TOKEN_REVOKED error carrying
+// the reason. It returns how many were closed.
+func (h *Hub) DisconnectToken(tokenID, reason string) int {
+	return h.disconnectTokens(func(token *auth.TokenPayload) bool {
+		return token.ID == tokenID
+	}, reason)
+}
+
+// DisconnectUserTokens closes every connection authenticated with a token
+// of userID issued before the given time, like DisconnectToken
+func (h *Hub) DisconnectUserTokens(userID string, before time.Time, reason string) int {
+	return h.disconnectTokens(func(token *auth.TokenPayload) bool {
+		return token.UserID == userID && token.IssuedAt != nil && token.IssuedAt.Time.Before(before)
+	}, reason)
+}
+
+// DisconnectAPIKey closes every connection authenticated with the API key
+// whose ID is keyID, like DisconnectToken
+func (h *Hub) DisconnectAPIKey(keyID, reason string) int {
+	return h.disconnectTokens(func(token *auth.TokenPayload) bool {
+		return token.UserID == auth.APIKeyUserPrefix+keyID
+	}, reason)
+}
+
+func (h *Hub) disconnectTokens(match func(*auth.TokenPayload) bool, reason string) int {
+	count := 0
+	h.exec(func() {
+		h.mu.RLock()
+		conns := make([]*Connection, 0)
+		for _, conn := range h.connections {
+			if conn.Authenticated && conn.VerifiedUserID() != "" && match(conn.TokenPayload) {
+				conns = append(conns, conn)
+			}
+		}
+		h.mu.RUnlock()
+
+		for _, conn := range conns {
+			h.kick(conn, "Token has been revoked", "TOKEN_REVOKED", reason)
+		}
+		count = len(conns)
+	})
+	return count
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// connectWithToken is authWithToken through a running hub
+func connectWithToken(t *testing.T, hub *Hub, id string, perms auth.DocumentPermissions) *Connection {
+	t.Helper()
+	token, err := auth.GenerateAccessToken("user-"+id, "", perms, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken failed: %v", err)
+	}
+	conn := newTestConnection(hub, id)
+	hub.Register <- conn
+	dispatch(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": token})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	return conn
+}
+
+func TestHub_RefusesRevokedTokenOnAuth(t *testing.T) {
+	revocations := &auth.MemoryRevocations{}
+	hub := NewHubWithOptions(testAuth, HubOptions{Revocations: revocations})
+	token, _ := auth.GenerateAccessToken("user-alice", "", auth.CreateUserPermissions([]string{"*"}, nil), testSecret, time.Hour)
+	revocations.RevokeUser(context.Background(), "user-alice", time.Now().Add(time.Second), time.Now().Add(time.Hour))
+
+	conn := newTestConnection(hub, "alice")
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": token})
+	msg := expectMessage(t, conn, protocol.TypeAuthError)
+	if msg.Payload["code"] != "TOKEN_REVOKED" {
+		t.Errorf("auth error code = %v, want TOKEN_REVOKED", msg.Payload["code"])
+	}
+	if conn.Authenticated {
+		t.Error("connection authenticated with a revoked token")
+	}
+}
+
+func TestHub_RevocationMidSessionClosesOnNextWrite(t *testing.T) {
+	revocations := &auth.MemoryRevocations{}
+	hub := NewHubWithOptions(testAuth, HubOptions{Revocations: revocations, CheckRevocationOnWrite: true})
+	perms := auth.CreateUserPermissions([]string{"*"}, []string{"*"})
+	alice := authWithToken(t, hub, "alice", perms)
+	bob := authWithToken(t, hub, "bob", perms)
+	for _, conn := range []*Connection{alice, bob} {
+		handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:revoke"})
+		expectMessage(t, conn, protocol.TypeSyncResponse)
+	}
+
+	sendDelta(hub, alice, "room:revoke", "before", 1.0)
+	expectMessage(t, alice, protocol.TypeAck)
+	expectMessage(t, bob, protocol.TypeDelta)
+
+	revocations.RevokeToken(context.Background(), alice.TokenPayload.ID, time.Now().Add(time.Hour))
+	sendDelta(hub, alice, "room:revoke", "after", 1.0)
+	expectError(t, alice, "TOKEN_REVOKED")
+	if !alice.IsClosed() {
+		t.Error("connection with a revoked token left open")
+	}
+
+	hub.docsMu.RLock()
+	_, applied := hub.documents["room:revoke"]["after"]
+	hub.docsMu.RUnlock()
+	if applied {
+		t.Error("delta from a revoked token was applied")
+	}
+
+	sendDelta(hub, bob, "room:revoke", "bob", 1.0)
+	expectMessage(t, bob, protocol.TypeAck)
+}
+
+func TestHub_DisconnectRevokedTokens(t *testing.T) {
+	hub := startHub(t, HubOptions{})
+	perms := auth.CreateUserPermissions([]string{"*"}, nil)
+	first := connectWithToken(t, hub, "first", perms)
+	second := connectWithToken(t, hub, "second", perms)
+	anonymous := connectAnonymous(t, hub, "anonymous")
+
+	if n := hub.DisconnectToken(first.TokenPayload.ID, "Laptop stolen"); n != 1 {
+		t.Fatalf("DisconnectToken closed %d connections, want 1", n)
+	}
+	msg := expectMessage(t, first, protocol.TypeError)
+	if msg.Payload["code"] != "TOKEN_REVOKED" || msg.Payload["reason"] != "Laptop stolen" {
+		t.Errorf("error payload = %v, want TOKEN_REVOKED with the reason", msg.Payload)
+	}
+	if !first.IsClosed() || second.IsClosed() {
+		t.Errorf("closed = %v/%v, want only the revoked token's connection", first.IsClosed(), second.IsClosed())
+	}
+
+	// Tokens issued after the cutoff survive; JWT claims have second precision
+	if n := hub.DisconnectUserTokens("user-second", second.TokenPayload.IssuedAt.Time, "Offboarded"); n != 0 {
+		t.Errorf("DisconnectUserTokens before issue closed %d connections, want 0", n)
+	}
+	if n := hub.DisconnectUserTokens("user-second", time.Now().Add(time.Second), "Offboarded"); n != 1 || !second.IsClosed() {
+		t.Errorf("DisconnectUserTokens closed %d connections, want the second", n)
+	}
+	if anonymous.IsClosed() {
+		t.Error("anonymous connection closed by a token revocation")
+	}
+}
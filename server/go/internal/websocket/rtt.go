@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+// rttTracker smooths websocket ping round-trip times the way TCP does
+// (RFC 6298): the first sample is taken as-is, later ones are blended in
+// with weight 1/8. Safe for concurrent use by WritePump, ReadPump and hub
+// readers.
+type rttTracker struct {
+	mu         sync.Mutex
+	pingSentAt time.Time // When the unanswered ping went out; zero if none
+	smoothed   time.Duration
+	samples    int
+}
+
+// pingSent records that a ping was written at now
+func (r *rttTracker) pingSent(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pingSentAt = now
+}
+
+// pongReceived completes the outstanding ping, if any, and folds the
+// round-trip time into the smoothed value
+func (r *rttTracker) pongReceived(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pingSentAt.IsZero() {
+		return
+	}
+	sample := now.Sub(r.pingSentAt)
+	r.pingSentAt = time.Time{}
+	if sample < 0 {
+		return
+	}
+
+	if r.samples == 0 {
+		r.smoothed = sample
+	} else {
+		r.smoothed += (sample - r.smoothed) / 8
+	}
+	r.samples++
+}
+
+// rtt returns the smoothed round-trip time, and false before the first pong
+func (r *rttTracker) rtt() (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.smoothed, r.samples > 0
+}
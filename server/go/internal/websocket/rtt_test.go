@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+func TestRTTTracker_Smoothing(t *testing.T) {
+	var r rttTracker
+	now := time.Unix(1_700_000_000, 0)
+
+	if _, ok := r.rtt(); ok {
+		t.Fatal("rtt() reported a value before any pong")
+	}
+
+	// A pong without an outstanding ping is ignored
+	r.pongReceived(now)
+	if _, ok := r.rtt(); ok {
+		t.Fatal("unsolicited pong produced a sample")
+	}
+
+	roundTrip := func(d time.Duration) time.Duration {
+		r.pingSent(now)
+		now = now.Add(d)
+		r.pongReceived(now)
+		got, _ := r.rtt()
+		return got
+	}
+
+	// First sample is taken as-is, later ones move 1/8 of the way
+	if got := roundTrip(80 * time.Millisecond); got != 80*time.Millisecond {
+		t.Errorf("after first sample rtt = %v, want 80ms", got)
+	}
+	if got := roundTrip(160 * time.Millisecond); got != 90*time.Millisecond {
+		t.Errorf("after 160ms sample rtt = %v, want 90ms", got)
+	}
+	if got := roundTrip(10 * time.Millisecond); got != 80*time.Millisecond {
+		t.Errorf("after 10ms sample rtt = %v, want 80ms", got)
+	}
+
+	// A duplicate pong for the same ping is not counted twice
+	now = now.Add(time.Second)
+	r.pongReceived(now)
+	if got, _ := r.rtt(); got != 80*time.Millisecond {
+		t.Errorf("duplicate pong changed rtt to %v", got)
+	}
+}
+
+func TestHub_PingEchoesClientTimestampAndRTT(t *testing.T) {
+	hub := newTestHub(t)
+	conn := connectAnonymous(t, hub, "c1")
+
+	hub.HandleMessage <- &MessageEvent{
+		Connection: conn,
+		Message: &protocol.Message{
+			Type:      protocol.TypePing,
+			ID:        "p1",
+			Timestamp: 1234,
+			Payload:   map[string]interface{}{"type": protocol.TypePing},
+		},
+	}
+	pong := expectMessage(t, conn, protocol.TypePong)
+	if pong.Payload["clientTimestamp"] != float64(1234) {
+		t.Errorf("clientTimestamp = %v, want 1234", pong.Payload["clientTimestamp"])
+	}
+	if _, ok := pong.Payload["rttMs"]; ok {
+		t.Errorf("pong carried rttMs %v before any measurement", pong.Payload["rttMs"])
+	}
+
+	now := time.Now()
+	conn.rtt.pingSent(now)
+	conn.rtt.pongReceived(now.Add(42 * time.Millisecond))
+
+	dispatch(hub, conn, protocol.TypePing, nil)
+	pong = expectMessage(t, conn, protocol.TypePong)
+	if pong.Payload["rttMs"] != float64(42) {
+		t.Errorf("rttMs = %v, want 42", pong.Payload["rttMs"])
+	}
+
+	infos := hub.ListConnections()
+	if len(infos) != 1 || infos[0].RTTMillis != 42 {
+		t.Errorf("ListConnections() = %+v, want one connection with RTTMillis 42", infos)
+	}
+}
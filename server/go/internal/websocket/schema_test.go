@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/schema"
+)
+
+// newSchemaHub returns a hub validating room:orders: documents in mode
+func newSchemaHub(t *testing.T, mode string) *Hub {
+	t.Helper()
+	schemas, err := schema.NewRegistry(mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := schema.Compile([]byte(`{
+		"required": ["status"],
+		"properties": {
+			"status": {"enum": ["open", "paid"]},
+			"total": {"type": "number", "minimum": 0}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas.Set("room:orders:", orders)
+	return NewHubWithOptions(testAuth, HubOptions{Schemas: schemas})
+}
+
+// orderDocument returns room:orders:1 and whether it exists
+func orderDocument(hub *Hub) (map[string]interface{}, bool) {
+	hub.docsMu.RLock()
+	defer hub.docsMu.RUnlock()
+	_, ok := hub.documents["room:orders:1"]
+	if !ok {
+		return nil, false
+	}
+	return hub.documentSnapshot("room:orders:1"), true
+}
+
+func TestHub_SchemaRefusesInvalidDeltas(t *testing.T) {
+	hub := newSchemaHub(t, schema.ModeState)
+	writer := joinDirect(t, hub, "writer", "room:orders:1")
+	reader := joinDirect(t, hub, "reader", "room:orders:1")
+
+	// A first delta without the required status would leave an invalid
+	// document, and its total is of the wrong type besides
+	sendDelta(hub, writer, "room:orders:1", "total", "12")
+	ack := expectMessage(t, writer, protocol.TypeAck)
+	if ack.Payload["status"] != "rejected" || ack.Payload["reason"] != RejectSchema || ack.Payload["code"] != CodeSchemaViolation {
+		t.Fatalf("ack = %v, want rejected with %s", ack.Payload, CodeSchemaViolation)
+	}
+	fieldErrors, _ := ack.Payload["fieldErrors"].([]interface{})
+	if len(fieldErrors) != 2 {
+		t.Fatalf("fieldErrors = %v, want status and total", ack.Payload["fieldErrors"])
+	}
+	for i, want := range []struct{ field, keyword, message string }{
+		{"status", "required", "is required"},
+		{"total", "type", "must be a number"},
+	} {
+		fe := fieldErrors[i].(map[string]interface{})
+		if fe["field"] != want.field || fe["keyword"] != want.keyword || fe["message"] != want.message || fe["code"] != CodeSchemaViolation {
+			t.Errorf("field error %d = %v, want %s %s", i, fe, want.field, want.keyword)
+		}
+	}
+	if doc, ok := orderDocument(hub); ok {
+		t.Errorf("refused delta created the document: %v", doc)
+	}
+
+	// A valid delta applies; one breaking a single field is refused whole
+	handleDirect(hub, writer, protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:orders:1",
+		"changes": map[string]interface{}{"status": "open", "total": 12.0},
+	})
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["status"] != "applied" {
+		t.Fatalf("ack = %v, want applied", ack.Payload)
+	}
+	expectMessage(t, reader, protocol.TypeDelta)
+	handleDirect(hub, writer, protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:orders:1",
+		"changes": map[string]interface{}{"status": "lost", "total": 20.0},
+	})
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["reason"] != RejectSchema {
+		t.Fatalf("ack = %v, want rejected for the schema", ack.Payload)
+	}
+	if doc, _ := orderDocument(hub); doc["status"] != "open" || doc["total"] != 12.0 {
+		t.Errorf("document = %v, want the refused delta not applied", doc)
+	}
+	if len(reader.send) != 0 {
+		t.Error("refused delta was broadcast")
+	}
+
+	// Deltas in a batch are checked one by one
+	handleDirect(hub, writer, protocol.TypeDeltaBatch, map[string]interface{}{
+		"docId": "room:orders:1",
+		"deltas": []interface{}{
+			map[string]interface{}{"changes": map[string]interface{}{"total": -1.0}},
+			map[string]interface{}{"changes": map[string]interface{}{"status": "paid"}},
+		},
+	})
+	ack = expectMessage(t, writer, protocol.TypeAck)
+	results, _ := ack.Payload["results"].([]interface{})
+	if len(results) != 2 || results[0].(map[string]interface{})["reason"] != RejectSchema || results[1].(map[string]interface{})["status"] != "applied" {
+		t.Errorf("batch results = %v, want the first refused and the second applied", ack.Payload["results"])
+	}
+
+	// Documents no schema covers are not checked
+	sendDelta(hub, writer, "room:notes", "total", "12")
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["status"] != "applied" {
+		t.Errorf("ack = %v, want applied", ack.Payload)
+	}
+}
+
+func TestHub_SchemaChangesMode(t *testing.T) {
+	hub := newSchemaHub(t, schema.ModeChanges)
+	writer := joinDirect(t, hub, "writer", "room:orders:1")
+
+	// Only the changed fields are checked, so a partial first delta applies
+	sendDelta(hub, writer, "room:orders:1", "total", 12.0)
+	if ack := expectMessage(t, writer, protocol.TypeAck); ack.Payload["status"] != "applied" {
+		t.Fatalf("ack = %v, want applied", ack.Payload)
+	}
+	sendDelta(hub, writer, "room:orders:1", "total", -1.0)
+	ack := expectMessage(t, writer, protocol.TypeAck)
+	fieldErrors, _ := ack.Payload["fieldErrors"].([]interface{})
+	if ack.Payload["reason"] != RejectSchema || len(fieldErrors) != 1 || fieldErrors[0].(map[string]interface{})["keyword"] != "minimum" {
+		t.Errorf("ack = %v, want rejected below the minimum", ack.Payload)
+	}
+}
@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+)
+
+// Session is an authenticated Connection without a socket, for transports
+// other than websockets such as Server-Sent Events and gRPC. Messages sent
+// through it take the normal message path, so the same permission checks,
+// limits and audit events apply; replies and broadcasts come back through
+// Next instead of WritePump writing them.
+type Session struct {
+	conn *Connection
+}
+
+// SessionOptions describes who a session acts for and from where
+type SessionOptions struct {
+	Token    *auth.TokenPayload // Verified token of the client
+	ClientIP string
+
+	// Enforces document creation quotas when set, as it does for websockets
+	SecurityManager *security.SecurityManager
+}
+
+// OpenSession registers a session whose connection ID starts with idPrefix,
+// naming the transport in logs and connection listings
+func (h *Hub) OpenSession(ctx context.Context, idPrefix string, opts SessionOptions) (*Session, error) {
+	conn := NewConnection(idPrefix+protocol.NewID(), nil, h)
+	conn.ClientIP = opts.ClientIP
+	conn.ClientID = protocol.NewID()
+	conn.Authenticated = true
+	conn.UserID = opts.Token.UserID
+	conn.TokenPayload = opts.Token
+	conn.SecurityManager = opts.SecurityManager
+	conn.verifiedUser.Store(opts.Token.UserID)
+	conn.tenant.Store(opts.Token.TenantID)
+	conn.authDeadline.done.Store(true)
+	conn.logAs(conn.UserID)
+
+	select {
+	case h.Register <- conn:
+	case <-h.Done():
+		return nil, errHubStopped
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &Session{conn: conn}, nil
+}
+
+// Send hands a message to the hub as if the client had sent it and returns
+// its ID, which the reply (an ACK, sync_response or the like) carries as its
+// origin. ctx
+// also bounds the handling of the message, within HubOptions.MessageTimeout.
+func (s *Session) Send(ctx context.Context, msgType string, payload map[string]interface{}) (string, error) {
+	id := protocol.NewID()
+	msg := make(map[string]interface{}, len(payload)+2)
+	for k, v := range payload {
+		msg[k] = v
+	}
+	msg["type"] = msgType
+	msg["id"] = id
+
+	event := s.conn.hub.newEvent(ctx, s.conn, &protocol.Message{Type: msgType, ID: id, Timestamp: time.Now().UnixMilli(), Payload: msg})
+	select {
+	case s.conn.hub.HandleMessage <- event:
+		return id, nil
+	case <-s.conn.hub.Done():
+		event.release()
+		return "", errHubStopped
+	case <-ctx.Done():
+		event.release()
+		return "", ctx.Err()
+	}
+}
+
+// Next returns the next message for the client. It returns
+// ErrConnectionClosed once the hub has dropped the session (after any
+// messages queued before that), or ctx's error.
+func (s *Session) Next(ctx context.Context) (*protocol.Message, error) {
+	select {
+	case data := <-s.conn.send:
+		return protocol.DecodeMessage(data)
+	case <-s.conn.done:
+		select {
+		case data := <-s.conn.send:
+			return protocol.DecodeMessage(data)
+		default:
+			return nil, ErrConnectionClosed
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close unsubscribes and unregisters the session
+func (s *Session) Close() {
+	s.conn.leaveHub()
+}
@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// frameKey is everything about a client that changes how a message encodes
+type frameKey struct {
+	opts     protocol.EncodeOptions
+	stripSeq bool
+}
+
+// sharedMessage is one message sent to many clients. It is encoded once per
+// distinct frameKey and the same frame queued to every client sharing it, so
+// frames on a send queue must never be modified. Not safe for concurrent use.
+type sharedMessage struct {
+	messageType string
+	payload     map[string]interface{}
+	timestamp   int64
+	frames      map[frameKey][]byte
+
+	// raw is the JSON a client sent the payload as, when the payload
+	// differs from it only in serverFields
+	raw []byte
+}
+
+// serverFields are the members of a forwarded delta the server sets rather
+// than copying from the client
+var serverFields = []string{"type", "id", "origin", "seq", "timestamp"}
+
+func newSharedMessage(msg *protocol.Message) *sharedMessage {
+	return &sharedMessage{
+		messageType: msg.Type,
+		payload:     msg.Payload,
+		timestamp:   time.Now().UnixMilli(),
+		frames:      make(map[frameKey][]byte, 1),
+	}
+}
+
+// withRaw lets JSON frames copy raw, the payload as a client sent it,
+// encoding only serverFields again
+func (m *sharedMessage) withRaw(raw []byte) *sharedMessage {
+	m.raw = raw
+	return m
+}
+
+// frameFor returns the message encoded for conn
+func (m *sharedMessage) frameFor(conn *Connection) ([]byte, error) {
+	key := frameKey{opts: conn.encodeOptions(), stripSeq: !conn.can(capResume)}
+	if data, ok := m.frames[key]; ok {
+		return data, nil
+	}
+	if m.raw != nil {
+		data, ok, err := m.patchedFrame(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m.frames[key] = data
+			return data, nil
+		}
+	}
+	payload := m.payload
+	if key.stripSeq {
+		payload = withoutSeq(payload)
+	}
+	data, err := protocol.EncodeMessageWith(m.messageType, payload, m.timestamp, key.opts)
+	if err != nil {
+		return nil, err
+	}
+	m.frames[key] = data
+	return data, nil
+}
+
+// patchedFrame encodes the message from raw, replacing serverFields with
+// the payload's own or dropping those it lacks. It reports false for frames
+// that must be encoded in full.
+func (m *sharedMessage) patchedFrame(key frameKey) ([]byte, bool, error) {
+	set := make(map[string]interface{}, len(serverFields))
+	var drop []string
+	for _, field := range serverFields {
+		value, ok := m.payload[field]
+		if !ok || (field == "seq" && key.stripSeq) {
+			drop = append(drop, field)
+			continue
+		}
+		set[field] = value
+	}
+	return protocol.EncodePatchedMessageWith(m.messageType, m.raw, set, drop, m.timestamp, key.opts)
+}
+
+// sendShared queues m for the client, as SendMessage would
+func (c *Connection) sendShared(m *sharedMessage) error {
+	data, err := m.frameFor(c)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(data, m.messageType)
+}
@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+)
+
+// joinNegotiated joins a protocol version 2 client with the given
+// capabilities, subscribed to docID
+func joinNegotiated(t testing.TB, hub *Hub, id, docID string, caps ...interface{}) *Connection {
+	t.Helper()
+	conn := newTestConnection(hub, id)
+	hub.register(conn)
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{
+		"userId":          "user-" + id,
+		"protocolVersion": 2.0,
+		"capabilities":    caps,
+	})
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+	handleDirect(hub, conn, protocol.TypeSubscribe, map[string]interface{}{"docId": docID})
+	expectMessage(t, conn, protocol.TypeSyncResponse)
+	return conn
+}
+
+// clientDelta decodes a delta a client sent as JSON text
+func clientDelta(t testing.TB, text string) *protocol.Message {
+	t.Helper()
+	msg, err := protocol.DecodeMessage([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestSharedMessage_PatchedFramesMatchEncodedOnes(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{CompressionThreshold: 64})
+	readers := map[string]*Connection{
+		"legacy":   joinDirect(t, hub, "legacy", "room:raw"),
+		"no seq":   joinNegotiated(t, hub, "no-seq", "room:raw"),
+		"checksum": joinNegotiated(t, hub, "checksum", "room:raw", "resume", "checksum", "compression"),
+		"msgpack":  joinNegotiated(t, hub, "msgpack", "room:raw", "resume", "msgpack"),
+	}
+	msg := clientDelta(t, `{"type":"delta","id":"client-1","docId":"room:raw","changes":{"title":"Hello","n":3,"body":"`+strings.Repeat("text ", 40)+`"}}`)
+	stamped := forwardDelta(msg.Payload, msg.ID)
+	stamped["seq"] = int64(7)
+
+	for name, conn := range readers {
+		t.Run(name, func(t *testing.T) {
+			fast, err := newSharedMessage(&protocol.Message{Type: protocol.TypeDelta, Payload: stamped}).withRaw(msg.RawPayload()).frameFor(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			slow, err := newSharedMessage(&protocol.Message{Type: protocol.TypeDelta, Payload: stamped}).frameFor(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := protocol.DecodeMessage(fast)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := protocol.DecodeMessage(slow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Payload, want.Payload) {
+				t.Errorf("patched payload = %v, want %v", got.Payload, want.Payload)
+			}
+			if got.Payload["origin"] != "client-1" || got.Payload["id"] == "client-1" {
+				t.Errorf("ids = %v/%v, want a fresh id with the client's as origin", got.Payload["id"], got.Payload["origin"])
+			}
+		})
+	}
+}
+
+func TestHub_ForwardsUnmodifiedDeltasFromClientBytes(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   HubOptions
+		reused bool
+		want   map[string]interface{}
+	}{
+		{"unmodified", HubOptions{}, true, map[string]interface{}{"title": "Hello", "big": "0123456789"}},
+		{"trimmed", HubOptions{Limits: security.Limits{MaxBlockSize: 10}}, false, map[string]interface{}{"title": "Hello"}},
+		{"hooked", HubOptions{Hooks: Hooks{OnDelta: func(ctx context.Context, peer Peer, delta *Delta) error {
+			delta.Changes["title"] = "Rewritten"
+			return nil
+		}}}, false, map[string]interface{}{"title": "Rewritten", "big": "0123456789"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewHubWithOptions(testAuth, tt.opts)
+			writer := joinDirect(t, hub, "writer", "room:raw")
+			reader := joinDirect(t, hub, "reader", "room:raw")
+			msg := clientDelta(t, `{"type":"delta","id":"client-1","docId":"room:raw","changes":{"title":"Hello","big":"0123456789"}}`)
+
+			hub.handleMessage(context.Background(), writer, msg)
+			expectMessage(t, writer, protocol.TypeAck)
+			data := <-reader.send
+			got, err := protocol.DecodeMessage(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Payload["changes"], tt.want) {
+				t.Errorf("changes = %v, want %v", got.Payload["changes"], tt.want)
+			}
+			if got.Payload["seq"] != 1.0 || got.Payload["origin"] != "client-1" || got.Payload["docId"] != "room:raw" {
+				t.Errorf("delta = %v, want seq 1 from client-1", got.Payload)
+			}
+			// Reused bytes keep the client's member order, where encoding
+			// sorts keys
+			if reused := bytes.Contains(data, []byte(`{"title":"Hello","big"`)); reused != tt.reused {
+				t.Errorf("payload %s: reused client bytes = %v, want %v", data[13:], reused, tt.reused)
+			}
+		})
+	}
+}
+
+func BenchmarkBroadcastClientDelta100Subscribers(b *testing.B) {
+	for _, reuse := range []bool{false, true} {
+		b.Run(fmt.Sprintf("reuse=%v", reuse), func(b *testing.B) {
+			hub := NewHub(testAuth)
+			conns := make([]*Connection, 100)
+			for i := range conns {
+				conns[i] = joinDirect(b, hub, fmt.Sprintf("sub-%d", i), "room:bench")
+			}
+			msg := clientDelta(b, `{"type":"delta","id":"delta-1","docId":"room:bench","clientId":"client-1",`+
+				`"changes":{"title":"Quarterly planning","done":false,"body":"`+strings.Repeat("lorem ipsum ", 40)+`"}}`)
+			delta := forwardDelta(msg.Payload, msg.ID)
+			delta["seq"] = int64(42)
+			var raw []byte
+			if reuse {
+				raw = msg.RawPayload()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				hub.broadcastDelta("room:bench", delta, raw, "")
+				for _, conn := range conns {
+					<-conn.send
+				}
+			}
+		})
+	}
+}
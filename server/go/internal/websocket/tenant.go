@@ -0,0 +1,47 @@
+package websocket
+
+import (
+	"github.com/Dancode-188/synckit/server/go/internal/audit"
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// scopeTenant applies the tenant an auth message asks an admin token to act
+// for and, with MultiTenancy, refuses tokens without a tenant. It returns
+// the token to authenticate with, or nil after sending auth_error.
+func (h *Hub) scopeTenant(conn *Connection, msgID string, token *auth.TokenPayload, override string) *auth.TokenPayload {
+	scoped, err := auth.OverrideTenant(token, override)
+	if err != nil {
+		h.refuseAuth(conn, msgID, "TENANT_OVERRIDE_DENIED", err.Error())
+		return nil
+	}
+	if h.opts.MultiTenancy {
+		if err := auth.RequireTenant(scoped); err != nil {
+			h.refuseAuth(conn, msgID, "TENANT_REQUIRED", "A token with a tenant is required")
+			return nil
+		}
+	}
+	return scoped
+}
+
+// refuseAuth answers an auth message with auth_error
+func (h *Hub) refuseAuth(conn *Connection, msgID, code, message string) {
+	h.metrics.authFailures.Add(1)
+	h.audit(conn, audit.EventAuthFailure, "", map[string]interface{}{"code": code})
+	conn.Logger().Warn("Authentication failed", "code", code)
+	conn.Send(protocol.NewMessage(protocol.TypeAuthError, map[string]interface{}{
+		"error": message,
+		"code":  code,
+	}).WithOrigin(msgID))
+}
+
+// quotaUser is who conn's rate limits and document quotas are counted
+// against: its verified user, within its tenant when it has one, so a user
+// ID two tenants share has separate quotas in each
+func (c *Connection) quotaUser() string {
+	userID := c.VerifiedUserID()
+	if tenant := c.Tenant(); userID != "" && tenant != "" {
+		return auth.TenantPrefix(tenant) + userID
+	}
+	return userID
+}
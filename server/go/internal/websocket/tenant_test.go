@@ -0,0 +1,187 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/metrics"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+)
+
+// tenantToken issues a token for userID confined to tenant
+func tenantToken(t *testing.T, userID, tenant string, perms auth.DocumentPermissions) string {
+	t.Helper()
+	token, err := auth.IssueTenantAccessToken(userID, "", tenant, perms, testSecret, time.Hour, auth.ClaimRules{})
+	if err != nil {
+		t.Fatalf("IssueTenantAccessToken failed: %v", err)
+	}
+	return token
+}
+
+// newTenantHub returns a hub with MultiTenancy whose public document policy
+// lets tenants' documents through, as a multi-tenant deployment sets it
+func newTenantHub(t *testing.T, opts HubOptions) *Hub {
+	t.Helper()
+	policy, err := security.NewPublicDocumentPolicy(security.PublicDocumentRules{Mode: security.PolicyModeAllowAll})
+	if err != nil {
+		t.Fatalf("NewPublicDocumentPolicy failed: %v", err)
+	}
+	opts.MultiTenancy = true
+	opts.PublicDocuments = policy
+	return NewHubWithOptions(testAuth, opts)
+}
+
+// authTenant sends an auth message with a token of tenant that may read
+// and write every document, plus extra fields
+func authTenant(t *testing.T, hub *Hub, id, userID, tenant string, extra map[string]interface{}) *Connection {
+	t.Helper()
+	conn := newTestConnection(hub, id)
+	hub.register(conn)
+	payload := map[string]interface{}{"token": tenantToken(t, userID, tenant, auth.CreateUserPermissions([]string{"*"}, []string{"*"}))}
+	for k, v := range extra {
+		payload[k] = v
+	}
+	handleDirect(hub, conn, protocol.TypeAuth, payload)
+	return conn
+}
+
+// expectAuthError waits for auth_error and checks its code
+func expectAuthError(t *testing.T, conn *Connection, code string) {
+	t.Helper()
+	if msg := expectMessage(t, conn, protocol.TypeAuthError); msg.Payload["code"] != code {
+		t.Errorf("auth_error code = %v, want %q", msg.Payload["code"], code)
+	}
+}
+
+func TestHub_TenantTokensStayInTheirTenant(t *testing.T) {
+	hub := newTenantHub(t, HubOptions{})
+	acme := authTenant(t, hub, "acme", "alice", "acme", nil)
+	if msg := expectMessage(t, acme, protocol.TypeAuthSuccess); msg.Payload["tenantId"] != "acme" {
+		t.Errorf("auth_success tenant = %v, want acme", msg.Payload["tenantId"])
+	}
+	globex := authTenant(t, hub, "globex", "alice", "globex", nil)
+	expectMessage(t, globex, protocol.TypeAuthSuccess)
+
+	sendDelta(hub, acme, "acme:room:1", "title", "Acme")
+	expectMessage(t, acme, protocol.TypeAck)
+
+	// Permissions for every document do not reach another tenant's
+	handleDirect(hub, globex, protocol.TypeSubscribe, map[string]interface{}{"docId": "acme:room:1"})
+	expectError(t, globex, "PERMISSION_DENIED")
+	sendDelta(hub, globex, "acme:room:1", "title", "Globex")
+	expectError(t, globex, "PERMISSION_DENIED")
+
+	// Nor documents outside every tenant
+	handleDirect(hub, acme, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:1"})
+	expectError(t, acme, "PERMISSION_DENIED")
+	handleDirect(hub, acme, protocol.TypeSubscribe, map[string]interface{}{"docId": "acme:"})
+	expectError(t, acme, "PERMISSION_DENIED")
+
+	// Listings hold the tenant's own documents only
+	sendDelta(hub, globex, "globex:room:1", "title", "Globex")
+	expectMessage(t, globex, protocol.TypeAck)
+	handleDirect(hub, globex, protocol.TypeSubscribeList, map[string]interface{}{})
+	list := expectMessage(t, globex, protocol.TypeDocumentList)
+	if ids, _ := list.Payload["docIds"].([]interface{}); len(ids) != 1 || ids[0] != "globex:room:1" {
+		t.Errorf("globex lists %v, want only its own document", list.Payload["docIds"])
+	}
+
+	if got := hub.documentSnapshot("acme:room:1")["title"]; got != "Acme" {
+		t.Errorf("acme:room:1 title = %v, want the other tenant's write refused", got)
+	}
+}
+
+func TestHub_MultiTenancyRequiresTenant(t *testing.T) {
+	hub := newTenantHub(t, HubOptions{})
+
+	anonymous := newTestConnection(hub, "anonymous")
+	hub.register(anonymous)
+	handleDirect(hub, anonymous, protocol.TypeAuth, map[string]interface{}{"userId": "someone"})
+	expectAuthError(t, anonymous, "TENANT_REQUIRED")
+
+	expectAuthError(t, authTenant(t, hub, "untenanted", "bob", "", nil), "TENANT_REQUIRED")
+
+	// Users may not pick another tenant
+	expectAuthError(t, authTenant(t, hub, "hopper", "carol", "acme", map[string]interface{}{"tenantId": "globex"}), "TENANT_OVERRIDE_DENIED")
+}
+
+func TestHub_AdminTenantOverride(t *testing.T) {
+	hub := newTenantHub(t, HubOptions{})
+	admin := authWithToken(t, hub, "admin", auth.CreateAdminPermissions())
+	sendDelta(hub, admin, "acme:room:1", "k", "v")
+	expectMessage(t, admin, protocol.TypeAck)
+
+	// Acting for globex, the admin token is confined to it
+	token, err := auth.GenerateAccessToken("user-ops", "", auth.CreateAdminPermissions(), testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := newTestConnection(hub, "ops")
+	hub.register(ops)
+	handleDirect(hub, ops, protocol.TypeAuth, map[string]interface{}{"token": token, "tenantId": "globex"})
+	if msg := expectMessage(t, ops, protocol.TypeAuthSuccess); msg.Payload["tenantId"] != "globex" {
+		t.Errorf("auth_success tenant = %v, want globex", msg.Payload["tenantId"])
+	}
+	handleDirect(hub, ops, protocol.TypeSubscribe, map[string]interface{}{"docId": "acme:room:1"})
+	expectError(t, ops, "PERMISSION_DENIED")
+	handleDirect(hub, ops, protocol.TypeSubscribe, map[string]interface{}{"docId": "globex:room:1"})
+	expectMessage(t, ops, protocol.TypeSyncResponse)
+}
+
+func TestHub_ReauthenticationKeepsTenant(t *testing.T) {
+	hub := newTenantHub(t, HubOptions{})
+	conn := authTenant(t, hub, "c1", "alice", "acme", nil)
+	expectMessage(t, conn, protocol.TypeAuthSuccess)
+
+	token := tenantToken(t, "alice", "globex", auth.CreateUserPermissions([]string{"*"}, []string{"*"}))
+	handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{"token": token})
+	expectAuthError(t, conn, "TENANT_MISMATCH")
+	if conn.Tenant() != "acme" {
+		t.Errorf("tenant = %q after refused re-auth, want acme", conn.Tenant())
+	}
+}
+
+func TestHub_DocumentQuotaIsPerTenant(t *testing.T) {
+	reg := metrics.NewRegistry()
+	hub := newTenantHub(t, HubOptions{Metrics: reg})
+	sm := security.NewSecurityManager(security.Limits{MaxDocsPerIP: 1})
+	defer sm.Dispose()
+	join := func(tenant string) *Connection {
+		conn := newTestConnection(hub, tenant)
+		conn.ClientIP = "10.0.0.1"
+		conn.SecurityManager = sm
+		hub.register(conn)
+		handleDirect(hub, conn, protocol.TypeAuth, map[string]interface{}{
+			"token": tenantToken(t, "alice", tenant, auth.CreateUserPermissions([]string{"*"}, []string{"*"})),
+		})
+		expectMessage(t, conn, protocol.TypeAuthSuccess)
+		return conn
+	}
+	acme := join("acme")
+	globex := join("globex")
+
+	sendDelta(hub, acme, "acme:doc-1", "k", "v")
+	expectMessage(t, acme, protocol.TypeAck)
+	sendDelta(hub, acme, "acme:doc-2", "k", "v")
+	expectError(t, acme, "DOCUMENT_QUOTA_EXCEEDED")
+
+	// The same user ID in another tenant has a quota of its own
+	sendDelta(hub, globex, "globex:doc-1", "k", "v")
+	expectMessage(t, globex, protocol.TypeAck)
+
+	var out strings.Builder
+	if err := reg.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`synckit_tenant_documents_created_total{tenant="acme"} 1`,
+		`synckit_tenant_documents_created_total{tenant="globex"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package websocket
+
+import "time"
+
+// Transport is the socket a Connection reads client frames from and writes
+// its own to. *websocket.Conn from gorilla/websocket is one; tests use one
+// in memory, so the pumps run without opening sockets.
+type Transport interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	SetReadLimit(limit int64)
+	Close() error
+}
@@ -0,0 +1,448 @@
+package websocket
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// --- In-process transport ---
+
+// frame is one websocket frame the server wrote
+type frame struct {
+	kind int // gorilla message type
+	data []byte
+}
+
+// fakeTransport is a Transport over channels. The test side writes client
+// frames to in and reads the server's from out.
+type fakeTransport struct {
+	in     chan []byte
+	out    chan frame
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{
+		in:     make(chan []byte),
+		out:    make(chan frame, 512),
+		closed: make(chan struct{}),
+	}
+}
+
+func (f *fakeTransport) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-f.in:
+		return gorilla.BinaryMessage, data, nil
+	case <-f.closed:
+		return 0, nil, &gorilla.CloseError{Code: gorilla.CloseGoingAway}
+	}
+}
+
+func (f *fakeTransport) WriteMessage(kind int, data []byte) error {
+	select {
+	case <-f.closed:
+		return net.ErrClosed
+	default:
+	}
+	select {
+	case f.out <- frame{kind: kind, data: data}:
+		return nil
+	case <-f.closed:
+		return net.ErrClosed
+	}
+}
+
+func (f *fakeTransport) SetReadDeadline(time.Time) error           { return nil }
+func (f *fakeTransport) SetWriteDeadline(time.Time) error          { return nil }
+func (f *fakeTransport) SetPongHandler(func(appData string) error) {}
+func (f *fakeTransport) SetReadLimit(int64)                        {}
+
+func (f *fakeTransport) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+// TestClient is a client of a running hub connected through a
+// fakeTransport, so messages take the whole path: ReadPump decodes and
+// dispatches them, the hub handles them and WritePump writes the replies.
+type TestClient struct {
+	t         testing.TB
+	Conn      *Connection
+	transport *fakeTransport
+}
+
+// NewTestClient connects a client to hub, which must be running, the way
+// the server does for an upgraded websocket. It disconnects when the test
+// ends.
+func NewTestClient(t testing.TB, hub *Hub) *TestClient {
+	t.Helper()
+	transport := newFakeTransport()
+	conn := NewConnection("test-"+protocol.NewID(), transport, hub)
+	conn.ClientIP = "127.0.0.1"
+	select {
+	case hub.Register <- conn:
+	case <-hub.Done():
+		t.Fatal("NewTestClient: hub is stopped")
+	}
+	go conn.WritePump()
+	go conn.ReadPump()
+
+	c := &TestClient{t: t, Conn: conn, transport: transport}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// Send sends a message as the client would and returns its ID, which
+// replies carry as their origin
+func (c *TestClient) Send(msgType string, payload map[string]interface{}) string {
+	c.t.Helper()
+	msg := map[string]interface{}{}
+	for k, v := range payload {
+		msg[k] = v
+	}
+	id := protocol.NewID()
+	msg["id"] = id
+	data, err := protocol.EncodeMessage(msgType, msg, time.Now().UnixMilli())
+	if err != nil {
+		c.t.Fatalf("EncodeMessage: %v", err)
+	}
+	select {
+	case c.transport.in <- data:
+	case <-c.transport.closed:
+		c.t.Fatalf("Send %s: connection closed", msgType)
+	case <-time.After(2 * time.Second):
+		c.t.Fatalf("Send %s: ReadPump is not reading", msgType)
+	}
+	return id
+}
+
+// next returns the next frame the server wrote, or false after the timeout
+func (c *TestClient) next(timeout time.Duration) (frame, bool) {
+	select {
+	case f := <-c.transport.out:
+		return f, true
+	case <-time.After(timeout):
+		return frame{}, false
+	}
+}
+
+// Expect waits for the next message of the given type, skipping others
+func (c *TestClient) Expect(msgType string) *protocol.Message {
+	c.t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		f, ok := c.next(time.Until(deadline))
+		if !ok {
+			c.t.Fatalf("timed out waiting for %s", msgType)
+		}
+		if f.kind != gorilla.BinaryMessage {
+			continue
+		}
+		msg, err := protocol.DecodeMessage(f.data)
+		if err != nil {
+			c.t.Fatalf("DecodeMessage: %v", err)
+		}
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+// ExpectError waits for an error message and checks its code
+func (c *TestClient) ExpectError(code string) *protocol.Message {
+	c.t.Helper()
+	msg := c.Expect(protocol.TypeError)
+	if msg.Payload["code"] != code {
+		c.t.Fatalf("error code = %v, want %s (%v)", msg.Payload["code"], code, msg.Payload["error"])
+	}
+	return msg
+}
+
+// ExpectNone checks that no message of the given type arrives within d
+func (c *TestClient) ExpectNone(msgType string, d time.Duration) {
+	c.t.Helper()
+	deadline := time.Now().Add(d)
+	for {
+		f, ok := c.next(time.Until(deadline))
+		if !ok {
+			return
+		}
+		if msg, err := protocol.DecodeMessage(f.data); err == nil && msg.Type == msgType {
+			c.t.Fatalf("unexpected %s: %v", msgType, msg.Payload)
+		}
+	}
+}
+
+// ExpectClose waits for the server's close frame and returns its code
+func (c *TestClient) ExpectClose() int {
+	c.t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		f, ok := c.next(time.Until(deadline))
+		if !ok {
+			c.t.Fatal("timed out waiting for the connection to close")
+		}
+		if f.kind != gorilla.CloseMessage {
+			continue
+		}
+		if len(f.data) < 2 {
+			return gorilla.CloseNoStatusReceived
+		}
+		return int(f.data[0])<<8 | int(f.data[1])
+	}
+}
+
+// Auth authenticates as userID and waits for auth_success
+func (c *TestClient) Auth(userID string) *protocol.Message {
+	c.t.Helper()
+	c.Send(protocol.TypeAuth, map[string]interface{}{"userId": userID})
+	return c.Expect(protocol.TypeAuthSuccess)
+}
+
+// Subscribe subscribes to docID and waits for its sync_response
+func (c *TestClient) Subscribe(docID string) *protocol.Message {
+	c.t.Helper()
+	c.Send(protocol.TypeSubscribe, map[string]interface{}{"docId": docID})
+	return c.Expect(protocol.TypeSyncResponse)
+}
+
+// Sync waits until the hub has handled everything the client sent so far
+func (c *TestClient) Sync() {
+	c.t.Helper()
+	c.Send(protocol.TypePing, nil)
+	c.Expect(protocol.TypePong)
+}
+
+// Close disconnects the client as if its socket dropped
+func (c *TestClient) Close() {
+	c.transport.Close()
+}
+
+// --- Handler tests through the transport ---
+
+func TestTransport_AuthSuccess(t *testing.T) {
+	hub := newTestHub(t)
+	c := NewTestClient(t, hub)
+
+	id := c.Send(protocol.TypeAuth, map[string]interface{}{"userId": "alice", "clientId": "client-a"})
+	msg := c.Expect(protocol.TypeAuthSuccess)
+	if msg.Origin != id {
+		t.Errorf("auth_success origin = %q, want %q", msg.Origin, id)
+	}
+	if msg.Payload["userId"] != "alice" {
+		t.Errorf("auth_success = %v, want user alice", msg.Payload)
+	}
+	if !c.Conn.Authenticated {
+		t.Error("connection not authenticated after auth_success")
+	}
+}
+
+func TestTransport_ListConnectionsReportsServerSeq(t *testing.T) {
+	hub := newTestHub(t)
+	c := NewTestClient(t, hub)
+	c.Send(protocol.TypeAuth, map[string]interface{}{"userId": "alice", "protocolVersion": 2.0, "capabilities": []interface{}{"serverSeq"}})
+	c.Expect(protocol.TypeAuthSuccess)
+	c.Sync()
+
+	// WritePump records delivery just after the write the test reads
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		infos := hub.ListConnections()
+		if len(infos) == 1 && infos[0].ServerSeq == 1 && infos[0].DeliveredSeq == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ListConnections() = %+v, want serverSeq and deliveredSeq 1, the pong", infos)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTransport_AuthRejectsBadToken(t *testing.T) {
+	hub := newTestHub(t)
+	c := NewTestClient(t, hub)
+
+	c.Send(protocol.TypeAuth, map[string]interface{}{"token": "not-a-token"})
+	c.Expect(protocol.TypeAuthError)
+
+	// Still unauthenticated, so document messages are refused
+	c.Send(protocol.TypeSubscribe, map[string]interface{}{"docId": "room:1"})
+	c.ExpectError("NOT_AUTHENTICATED")
+}
+
+func TestTransport_AuthWithToken(t *testing.T) {
+	hub := newTestHub(t)
+	token, err := auth.GenerateAccessToken("bob", "", auth.DocumentPermissions{CanRead: []string{"*"}}, testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewTestClient(t, hub)
+	c.Send(protocol.TypeAuth, map[string]interface{}{"token": token})
+	if msg := c.Expect(protocol.TypeAuthSuccess); msg.Payload["userId"] != "bob" {
+		t.Errorf("auth_success = %v, want user bob", msg.Payload)
+	}
+
+	// The token reads, so the subscription is read-only
+	c.Subscribe("room:1")
+	c.Send(protocol.TypeDelta, map[string]interface{}{"docId": "room:1", "changes": map[string]interface{}{"a": 1}})
+	c.ExpectError("READ_ONLY")
+}
+
+func TestTransport_SubscribeReturnsState(t *testing.T) {
+	hub := newTestHub(t)
+	writer := NewTestClient(t, hub)
+	writer.Auth("alice")
+	writer.Subscribe("room:state")
+	writer.Send(protocol.TypeDelta, map[string]interface{}{"docId": "room:state", "changes": map[string]interface{}{"title": "Standup"}})
+	writer.Expect(protocol.TypeAck)
+
+	reader := NewTestClient(t, hub)
+	reader.Auth("bob")
+	id := reader.Send(protocol.TypeSubscribe, map[string]interface{}{"docId": "room:state"})
+	msg := reader.Expect(protocol.TypeSyncResponse)
+	if msg.Origin != id || msg.Payload["docId"] != "room:state" {
+		t.Errorf("sync_response = %v (origin %q), want room:state answering %q", msg.Payload, msg.Origin, id)
+	}
+	if state, _ := msg.Payload["state"].(map[string]interface{}); state["title"] != "Standup" {
+		t.Errorf("sync_response state = %v, want the written title", msg.Payload["state"])
+	}
+}
+
+func TestTransport_SubscribeWithoutDocumentID(t *testing.T) {
+	hub := newTestHub(t)
+	c := NewTestClient(t, hub)
+	c.Auth("alice")
+	c.Send(protocol.TypeSubscribe, map[string]interface{}{})
+	c.ExpectError("INVALID_REQUEST")
+}
+
+func TestTransport_DeltaBroadcast(t *testing.T) {
+	hub := newTestHub(t)
+	writer := NewTestClient(t, hub)
+	writer.Auth("alice")
+	writer.Subscribe("room:broadcast")
+	reader := NewTestClient(t, hub)
+	reader.Auth("bob")
+	reader.Subscribe("room:broadcast")
+	outsider := NewTestClient(t, hub)
+	outsider.Auth("carol")
+	outsider.Subscribe("room:elsewhere")
+
+	id := writer.Send(protocol.TypeDelta, map[string]interface{}{
+		"docId":   "room:broadcast",
+		"changes": map[string]interface{}{"title": "Retro"},
+	})
+	if ack := writer.Expect(protocol.TypeAck); ack.Origin != id {
+		t.Errorf("ack origin = %q, want %q", ack.Origin, id)
+	}
+
+	delta := reader.Expect(protocol.TypeDelta)
+	if delta.Payload["docId"] != "room:broadcast" || delta.ID == id {
+		t.Errorf("broadcast delta = %v (id %q), want room:broadcast with a fresh ID", delta.Payload, delta.ID)
+	}
+	if changes, _ := delta.Payload["changes"].(map[string]interface{}); changes["title"] != "Retro" {
+		t.Errorf("broadcast changes = %v", delta.Payload["changes"])
+	}
+	// Neither the sender nor other documents' subscribers get it
+	writer.ExpectNone(protocol.TypeDelta, 50*time.Millisecond)
+	outsider.ExpectNone(protocol.TypeDelta, 50*time.Millisecond)
+}
+
+func TestTransport_Awareness(t *testing.T) {
+	hub := newTestHub(t)
+	alice := NewTestClient(t, hub)
+	alice.Auth("alice")
+	alice.Subscribe("room:aware")
+	bob := NewTestClient(t, hub)
+	bob.Auth("bob")
+	bob.Subscribe("room:aware")
+
+	alice.Send(protocol.TypeAwarenessUpdate, map[string]interface{}{
+		"docId": "room:aware",
+		"state": map[string]interface{}{"cursor": 4},
+	})
+	msg := bob.Expect(protocol.TypeAwarenessState)
+	if state, _ := msg.Payload["state"].(map[string]interface{}); state["cursor"] != float64(4) {
+		t.Errorf("awareness_state = %v, want alice's cursor", msg.Payload)
+	}
+
+	// A late subscriber asks for the states so far
+	carol := NewTestClient(t, hub)
+	carol.Auth("carol")
+	carol.Send(protocol.TypeAwarenessSubscribe, map[string]interface{}{"docId": "room:aware"})
+	msg = carol.Expect(protocol.TypeAwarenessState)
+	if states, _ := msg.Payload["states"].([]interface{}); len(states) != 1 {
+		t.Errorf("awareness states = %v, want alice's", msg.Payload["states"])
+	}
+}
+
+func TestTransport_Unsubscribe(t *testing.T) {
+	hub := newTestHub(t)
+	writer := NewTestClient(t, hub)
+	writer.Auth("alice")
+	writer.Subscribe("room:leave")
+	reader := NewTestClient(t, hub)
+	reader.Auth("bob")
+	reader.Subscribe("room:leave")
+
+	reader.Send(protocol.TypeUnsubscribe, map[string]interface{}{"docId": "room:leave"})
+	reader.Sync()
+
+	writer.Send(protocol.TypeDelta, map[string]interface{}{"docId": "room:leave", "changes": map[string]interface{}{"a": 1}})
+	writer.Expect(protocol.TypeAck)
+	reader.ExpectNone(protocol.TypeDelta, 100*time.Millisecond)
+}
+
+func TestTransport_InvalidFrames(t *testing.T) {
+	hub := newTestHub(t)
+	c := NewTestClient(t, hub)
+
+	c.transport.in <- []byte("not a message")
+	c.ExpectError("INVALID_MESSAGE")
+	c.Send("no_such_type", nil)
+	c.ExpectError("INVALID_MESSAGE_TYPE")
+
+	// The connection is still usable
+	c.Auth("alice")
+}
+
+func TestTransport_DisconnectLeavesHub(t *testing.T) {
+	hub := newTestHub(t)
+	writer := NewTestClient(t, hub)
+	writer.Auth("alice")
+	writer.Subscribe("room:gone")
+	reader := NewTestClient(t, hub)
+	reader.Auth("bob")
+	reader.Subscribe("room:gone")
+
+	reader.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(hub.ListConnections()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections after disconnect, want 1", len(hub.ListConnections()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Broadcasts carry on for those still connected
+	writer.Send(protocol.TypeDelta, map[string]interface{}{"docId": "room:gone", "changes": map[string]interface{}{"a": 1}})
+	writer.Expect(protocol.TypeAck)
+}
+
+func TestTransport_ServerCloseSendsFrame(t *testing.T) {
+	hub := newTestHub(t)
+	c := NewTestClient(t, hub)
+	c.Auth("alice")
+
+	c.Conn.CloseWithCode(gorilla.ClosePolicyViolation, "kicked")
+	if code := c.ExpectClose(); code != gorilla.ClosePolicyViolation {
+		t.Errorf("close code = %d, want %d", code, gorilla.ClosePolicyViolation)
+	}
+}
@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"context"
+	"hash/fnv"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+)
+
+// workerQueueSize is how many messages may wait for each worker
+const workerQueueSize = 256
+
+// startWorkers launches the document message workers. Must be called on the
+// Run goroutine.
+func (h *Hub) startWorkers() {
+	h.workers = make([]chan *MessageEvent, h.opts.Workers)
+	for i := range h.workers {
+		queue := make(chan *MessageEvent, workerQueueSize)
+		h.workers[i] = queue
+		go h.runWorker(queue)
+	}
+}
+
+// stopWorkers lets the workers finish their queues and exit. Must be called
+// on the Run goroutine, the only sender.
+func (h *Hub) stopWorkers() {
+	for _, queue := range h.workers {
+		close(queue)
+	}
+	h.workers = nil
+}
+
+func (h *Hub) runWorker(queue <-chan *MessageEvent) {
+	for event := range queue {
+		if event.run != nil {
+			event.run()
+		} else {
+			h.handle(event)
+			event.Connection.inflight.Done()
+		}
+		h.inflight.Done()
+	}
+}
+
+// runOnWorker runs fn on the worker owning a document, after the messages
+// for it already queued. Must be called on the Run goroutine.
+func (h *Hub) runOnWorker(docID string, fn func()) {
+	if len(h.workers) == 0 {
+		fn()
+		return
+	}
+	h.inflight.Add(1)
+	h.workers[workerFor(docID, len(h.workers))] <- &MessageEvent{run: fn}
+}
+
+// route hands a message to its handler. Messages naming a document go to the
+// worker that owns the document, so each document's messages are handled in
+// arrival order while different documents proceed in parallel. Everything
+// else (auth, ping, prefix and list subscriptions) may change connection state
+// or span documents, so it runs on the Run goroutine once the connection's
+// earlier messages are done; an auth is therefore always handled before the
+// subscribes sent after it. Lookups that may wait on the network, like
+// verifying a token or checking access for a prefix, run first on a
+// goroutine of their own, and the connection's later messages are held until
+// the message is handled. Must be
+// called on the Run goroutine.
+func (h *Hub) route(event *MessageEvent) {
+	conn, msg := event.Connection, event.Message
+	if conn.preparing {
+		conn.held = append(conn.held, event)
+		return
+	}
+	if event.prepared == nil {
+		if prepare := h.preparer(conn, msg); prepare != nil {
+			h.prepareOffRun(event, prepare)
+			return
+		}
+	}
+	if docID, ok := msg.Payload["docId"].(string); ok && docID != "" && len(h.workers) > 0 {
+		conn.inflight.Add(1)
+		h.inflight.Add(1)
+		h.workers[workerFor(docID, len(h.workers))] <- event
+		return
+	}
+
+	conn.inflight.Wait()
+	h.handle(event)
+}
+
+// handle runs handleMessage holding the connection's handling lock, since the
+// same connection may have messages on several workers at once, then
+// releases the event's context
+func (h *Hub) handle(event *MessageEvent) {
+	defer event.release()
+
+	conn := event.Connection
+	conn.handleMu.Lock()
+	defer conn.handleMu.Unlock()
+
+	h.handlePrepared(event.context(), conn, event.Message, event.prepared)
+}
+
+// prepared is what was looked up for a message off the Run goroutine, for
+// its handler there
+type prepared struct {
+	creds  *credentials // An auth message's token or API key, checked
+	access readAccess   // The documents a prefix or list subscription may list
+}
+
+// preparer returns the lookups a message needs that may wait on the network,
+// or nil if it needs none
+func (h *Hub) preparer(conn *Connection, msg *protocol.Message) func(ctx context.Context) *prepared {
+	switch msg.Type {
+	case protocol.TypeAuth:
+		token, _ := msg.Payload["token"].(string)
+		apiKey, _ := msg.Payload["apiKey"].(string)
+		if token == "" && apiKey == "" {
+			return nil
+		}
+		return func(ctx context.Context) *prepared {
+			return &prepared{creds: h.checkCredentials(ctx, conn, token, apiKey)}
+		}
+
+	case protocol.TypeSubscribePrefix, protocol.TypeSubscribeList:
+		// Subscriptions the handler refuses need nothing looked up
+		token := conn.TokenPayload
+		if !conn.Authenticated || token == nil {
+			return nil
+		}
+		prefix, _ := msg.Payload["prefix"].(string)
+		cursor := ""
+		if msg.Type == protocol.TypeSubscribeList {
+			cursor, _ = msg.Payload["cursor"].(string)
+		}
+		if valid, _ := security.ValidateDocumentID(prefix); !valid && (prefix != "" || msg.Type == protocol.TypeSubscribePrefix) {
+			return nil
+		}
+		return func(ctx context.Context) *prepared {
+			return &prepared{access: h.resolveReadAccess(ctx, token, prefix, cursor)}
+		}
+	}
+	return nil
+}
+
+// prepareOffRun runs prepare for event on a goroutine of its own and routes
+// the event again, prepared, on the Run goroutine. The connection's messages
+// arriving meanwhile are held and routed after it. Must be called on the Run
+// goroutine.
+func (h *Hub) prepareOffRun(event *MessageEvent, prepare func(ctx context.Context) *prepared) {
+	conn := event.Connection
+	conn.preparing = true
+	h.preparing++
+	go func() {
+		result := prepare(event.context())
+		select {
+		case h.calls <- func() { h.prepareDone(event, result) }:
+		case <-h.stopChan:
+			event.release()
+		}
+	}()
+}
+
+// prepareDone routes a prepared event, then the messages held behind it.
+// Must be called on the Run goroutine.
+func (h *Hub) prepareDone(event *MessageEvent, result *prepared) {
+	h.preparing--
+	conn := event.Connection
+	held := conn.held
+	conn.preparing, conn.held = false, nil
+
+	event.prepared = result
+	h.route(event)
+	// Routing may hold them again behind another message to prepare
+	for _, next := range held {
+		h.route(next)
+	}
+}
+
+// workerFor picks the worker owning a document
+func workerFor(docID string, n int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(docID))
+	return int(hash.Sum32() % uint32(n))
+}
@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/auth"
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+func TestHub_WorkersPreservePerDocumentOrder(t *testing.T) {
+	hub := NewHubWithOptions(testAuth, HubOptions{Workers: 4})
+	docs := []string{"room:w:a", "room:w:b", "room:w:c"}
+	const writers = 3
+	const deltasPerDoc = 50 // per writer
+
+	writerConns := make([]*Connection, writers)
+	for w := range writerConns {
+		writerConns[w] = joinDirect(t, hub, fmt.Sprintf("writer-%d", w))
+	}
+	readers := make([]*Connection, len(docs))
+	for i, docID := range docs {
+		readers[i] = joinDirect(t, hub, fmt.Sprintf("reader-%d", i), docID)
+	}
+	go hub.Run()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	defer hub.Stop(ctx)
+
+	var wg sync.WaitGroup
+	for w, writer := range writerConns {
+		wg.Add(1)
+		go func(w int, writer *Connection) {
+			defer wg.Done()
+			for i := 0; i < deltasPerDoc; i++ {
+				for _, docID := range docs {
+					dispatch(hub, writer, protocol.TypeDelta, map[string]interface{}{
+						"docId":   docID,
+						"changes": map[string]interface{}{fmt.Sprintf("w%d", w): float64(i)},
+					})
+				}
+			}
+		}(w, writer)
+	}
+	wg.Wait()
+	flushHub(t, hub)
+
+	for d, reader := range readers {
+		var lastSeq int64
+		lastValue := map[string]float64{}
+		for n := 0; n < writers*deltasPerDoc; n++ {
+			msg := expectMessage(t, reader, protocol.TypeDelta)
+			if msg.Payload["docId"] != docs[d] {
+				t.Fatalf("reader %d got delta for %v", d, msg.Payload["docId"])
+			}
+			seq := int64(msg.Payload["seq"].(float64))
+			if seq != lastSeq+1 {
+				t.Fatalf("%s: seq %d followed %d", docs[d], seq, lastSeq)
+			}
+			lastSeq = seq
+
+			// Each writer's deltas arrive in the order it sent them
+			for key, v := range msg.Payload["changes"].(map[string]interface{}) {
+				if prev, ok := lastValue[key]; ok && v.(float64) != prev+1 {
+					t.Fatalf("%s: %s = %v after %v", docs[d], key, v, prev)
+				}
+				lastValue[key] = v.(float64)
+			}
+		}
+	}
+}
+
+// benchmarkDeltaThroughput pushes deltas round-robin across 100 documents,
+// each with a handful of subscribers so fan-out encoding dominates.
+func benchmarkDeltaThroughput(b *testing.B, workers int) {
+	hub := NewHubWithOptions(testAuth, HubOptions{Workers: workers})
+	const docs = 100
+	const subscribersPerDoc = 5
+
+	stop := make(chan struct{})
+	drain := func(conn *Connection) {
+		go func() {
+			for {
+				select {
+				case <-conn.send:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	writers := make([]*Connection, docs)
+	for d := 0; d < docs; d++ {
+		docID := fmt.Sprintf("room:bench:%d", d)
+		writers[d] = joinDirect(b, hub, fmt.Sprintf("writer-%d", d))
+		drain(writers[d])
+		for s := 0; s < subscribersPerDoc; s++ {
+			drain(joinDirect(b, hub, fmt.Sprintf("sub-%d-%d", d, s), docID))
+		}
+	}
+	go hub.Run()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	defer close(stop)
+	defer hub.Stop(ctx)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := i % docs
+		dispatch(hub, writers[d], protocol.TypeDelta, map[string]interface{}{
+			"docId":   fmt.Sprintf("room:bench:%d", d),
+			"changes": map[string]interface{}{"n": float64(i)},
+		})
+	}
+	hub.exec(hub.drainMessages)
+}
+
+func BenchmarkHub_DeltaThroughput100Docs(b *testing.B) {
+	b.Run("workers=1", func(b *testing.B) { benchmarkDeltaThroughput(b, 1) })
+	b.Run(fmt.Sprintf("workers=%d", runtime.GOMAXPROCS(0)), func(b *testing.B) {
+		benchmarkDeltaThroughput(b, runtime.GOMAXPROCS(0))
+	})
+}
+
+func BenchmarkBroadcast100Subscribers(b *testing.B) {
+	hub := NewHub(testAuth)
+	conns := make([]*Connection, 100)
+	for i := range conns {
+		conns[i] = joinDirect(b, hub, fmt.Sprintf("sub-%d", i), "room:bench")
+	}
+	delta := map[string]interface{}{
+		"type":     protocol.TypeDelta,
+		"id":       "delta-1",
+		"docId":    "room:bench",
+		"seq":      int64(42),
+		"clientId": "client-1",
+		"changes":  map[string]interface{}{"title": "Quarterly planning", "body": strings.Repeat("lorem ipsum ", 40)},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hub.broadcastDelta("room:bench", delta, nil, "")
+		for _, conn := range conns {
+			<-conn.send
+		}
+	}
+}
+
+// blockingVerifier verifies tokens with the test secret once release is
+// closed, like a verifier waiting on its key set endpoint
+type blockingVerifier struct {
+	release chan struct{}
+}
+
+func (v blockingVerifier) VerifyToken(token string) (*auth.TokenPayload, error) {
+	<-v.release
+	return auth.VerifyToken(token, testSecret)
+}
+
+func TestHub_CredentialsAreCheckedOffTheRunGoroutine(t *testing.T) {
+	verifier := blockingVerifier{release: make(chan struct{})}
+	var once sync.Once
+	release := func() { once.Do(func() { close(verifier.release) }) }
+	t.Cleanup(release)
+	hub := startHub(t, HubOptions{Verifier: verifier})
+
+	token, err := auth.GenerateAccessToken("user-alice", "", auth.CreateUserPermissions([]string{"*"}, nil), testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := newTestConnection(hub, "alice")
+	hub.Register <- alice
+	dispatch(hub, alice, protocol.TypeAuth, map[string]interface{}{"token": token})
+	dispatch(hub, alice, protocol.TypeSubscribe, map[string]interface{}{"docId": "room:held"})
+
+	// Other clients carry on while alice's token is checked
+	bob := connectAnonymous(t, hub, "bob")
+	dispatch(hub, bob, protocol.TypePing, nil)
+	expectMessage(t, bob, protocol.TypePong)
+
+	// alice's subscribe waits for her auth instead of being refused
+	release()
+	for _, want := range []string{protocol.TypeAuthSuccess, protocol.TypeSyncResponse} {
+		select {
+		case data := <-alice.send:
+			msg, err := protocol.DecodeMessage(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type != want {
+				t.Fatalf("alice got %s %v, want %s", msg.Type, msg.Payload, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// Writer defaults
+const (
+	DefaultWriteAttempts   = 3
+	DefaultWriteRetryDelay = 100 * time.Millisecond
+	DefaultWriteTimeout    = 5 * time.Second
+)
+
+// PersistFailed is the ACK reason when a durable ACK could not be given
+// because the document failed to persist
+const PersistFailed = "persist_failed"
+
+// PersistFunc saves the full state of a document
+type PersistFunc func(ctx context.Context, docID string, state map[string]interface{}) error
+
+// ErrWriteBufferFull is the outcome of a write refused while writing is
+// paused because WriterOptions.BufferSize documents are already waiting
+var ErrWriteBufferFull = errors.New("write buffer full")
+
+// WriterOptions tunes a Writer. Zero values use the defaults above.
+type WriterOptions struct {
+	MaxAttempts int           // Attempts per write, including the first
+	RetryDelay  time.Duration // Delay before the first retry, doubled after each
+	Timeout     time.Duration // Deadline for a single attempt
+
+	// BufferSize, when positive, lets writing pause while storage is down:
+	// a write that fails every attempt pauses the writer instead of being
+	// reported, and until Resume writes wait in memory, up to BufferSize
+	// documents. 0 reports every failed write.
+	BufferSize int
+
+	// OnPause, if not nil, is called when a failed write pauses the writer
+	OnPause func(err error)
+
+	// OnOverflow, if not nil, is called when a document's write is refused
+	// with ErrWriteBufferFull
+	OnOverflow func(docID string)
+}
+
+// Writer persists documents off the hub's handling path. Writes for one
+// document run one at a time in the order they were enqueued; writes for
+// different documents run in parallel. States enqueued while a write is in
+// flight are batched: only the latest is written, and every caller waiting
+// on the batch is told the outcome. While paused, writes are held until
+// Resume.
+type Writer struct {
+	persist PersistFunc
+	opts    WriterOptions
+
+	mu     sync.Mutex
+	queues map[string]*writeQueue // docId -> pending writes; present until the queue drains
+	idle   chan struct{}          // Closed when the last queue drains; nil when nobody waits
+	paused error                  // Why writing is paused; nil while writing
+	dirty  map[string]bool        // Documents refused with ErrWriteBufferFull since the pause
+}
+
+type writeQueue struct {
+	state   map[string]interface{} // Latest state not yet written, nil if none
+	done    []func(error)          // Callbacks waiting on state
+	running bool                   // A goroutine is writing the queue
+}
+
+// NewWriter creates a Writer that saves documents with persist
+func NewWriter(persist PersistFunc, opts WriterOptions) *Writer {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultWriteAttempts
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = DefaultWriteRetryDelay
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultWriteTimeout
+	}
+	return &Writer{
+		persist: persist,
+		opts:    opts,
+		queues:  make(map[string]*writeQueue),
+		dirty:   make(map[string]bool),
+	}
+}
+
+// Enqueue schedules state to be written for docID. The caller must not
+// modify state afterwards. done, if not nil, is called with the outcome once
+// a write covering this state finishes, on the writer's goroutine. While
+// paused with the buffer full, a document not already waiting is refused:
+// done is called at once with ErrWriteBufferFull, and the document is among
+// those Resume returns.
+func (w *Writer) Enqueue(docID string, state map[string]interface{}, done func(error)) {
+	w.mu.Lock()
+	q := w.queues[docID]
+	if q == nil && w.paused != nil && len(w.queues) >= w.opts.BufferSize {
+		w.dirty[docID] = true
+		w.mu.Unlock()
+		if w.opts.OnOverflow != nil {
+			w.opts.OnOverflow(docID)
+		}
+		if done != nil {
+			done(ErrWriteBufferFull)
+		}
+		return
+	}
+	if q == nil {
+		q = &writeQueue{}
+		w.queues[docID] = q
+	}
+	q.state = state
+	if done != nil {
+		q.done = append(q.done, done)
+	}
+	start := w.paused == nil && !q.running
+	if start {
+		q.running = true
+	}
+	w.mu.Unlock()
+	if start {
+		go w.run(docID, q)
+	}
+}
+
+// Pause holds writes in memory until Resume, as when storage is known to be
+// down. It reports whether the writer was writing until now.
+func (w *Writer) Pause(err error) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused != nil {
+		return false
+	}
+	w.paused = err
+	return true
+}
+
+// Resume writes everything held since Pause. It returns the documents whose
+// writes were refused meanwhile, whose state must be enqueued again in full.
+func (w *Writer) Resume() []string {
+	w.mu.Lock()
+	w.paused = nil
+	dirty := make([]string, 0, len(w.dirty))
+	for docID := range w.dirty {
+		dirty = append(dirty, docID)
+	}
+	clear(w.dirty)
+	var start []string
+	for docID, q := range w.queues {
+		if !q.running && q.state != nil {
+			q.running = true
+			start = append(start, docID)
+		}
+	}
+	for _, docID := range start {
+		go w.run(docID, w.queues[docID])
+	}
+	w.mu.Unlock()
+	return dirty
+}
+
+// Paused reports why writing is paused, nil while writing
+func (w *Writer) Paused() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.paused
+}
+
+// Pending returns the number of documents with writes waiting or in flight
+func (w *Writer) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.queues)
+}
+
+// Flush waits until every enqueued write has finished or ctx expires
+func (w *Writer) Flush(ctx context.Context) error {
+	w.mu.Lock()
+	if len(w.queues) == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	if w.idle == nil {
+		w.idle = make(chan struct{})
+	}
+	idle := w.idle
+	w.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run writes a document's batches until its queue is empty or writing
+// pauses
+func (w *Writer) run(docID string, q *writeQueue) {
+	for {
+		w.mu.Lock()
+		if q.state == nil {
+			delete(w.queues, docID)
+			if len(w.queues) == 0 && w.idle != nil {
+				close(w.idle)
+				w.idle = nil
+			}
+			w.mu.Unlock()
+			return
+		}
+		if w.paused != nil {
+			q.running = false
+			w.mu.Unlock()
+			return
+		}
+		state, done := q.state, q.done
+		q.state, q.done = nil, nil
+		w.mu.Unlock()
+
+		err := w.write(docID, state)
+		if err != nil && w.opts.BufferSize > 0 && isTransient(err) {
+			w.hold(q, state, done, err)
+			continue
+		}
+		for _, fn := range done {
+			fn(err)
+		}
+	}
+}
+
+// hold puts a state whose write failed back in its queue, unless a newer
+// one is waiting, and pauses writing
+func (w *Writer) hold(q *writeQueue, state map[string]interface{}, done []func(error), err error) {
+	w.mu.Lock()
+	if q.state == nil {
+		q.state = state
+	}
+	q.done = append(done, q.done...)
+	paused := w.paused == nil
+	if paused {
+		w.paused = err
+	}
+	w.mu.Unlock()
+	if paused && w.opts.OnPause != nil {
+		w.opts.OnPause(err)
+	}
+}
+
+// write persists one state, retrying transient failures with backoff
+func (w *Writer) write(docID string, state map[string]interface{}) error {
+	delay := w.opts.RetryDelay
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), w.opts.Timeout)
+		err := w.persist(ctx, docID, state)
+		cancel()
+		if err == nil || attempt >= w.opts.MaxAttempts || !isTransient(err) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isTransient reports whether retrying a failed write might succeed
+func isTransient(err error) bool {
+	var notFound *storage.NotFoundError
+	var conflict *storage.ConflictError
+	return !errors.As(err, &notFound) && !errors.As(err, &conflict) && !errors.Is(err, context.Canceled)
+}
+
+// documentSnapshot copies a document's fields for the writer. Values are
+// replaced, never mutated, when deltas apply, so a shallow copy is enough.
+// Must be called with docsMu held.
+func (h *Hub) documentSnapshot(docID string) map[string]interface{} {
+	doc := h.documents[docID]
+	state := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		state[k] = v
+	}
+	return state
+}
+
+// persistAndAck queues a document snapshot for writing, if there is one,
+// and sends the ACK. With DurableAcks the ACK waits for the write and
+// reports "durable"; a failed write turns it into status "failed".
+func (h *Hub) persistAndAck(conn *Connection, docID string, state map[string]interface{}, ack *protocol.Message) {
+	h.persistThenAck(conn, docID, state, ack, nil)
+}
+
+// persistFlushAndAck is persistAndAck for a batch of flush flushID handled
+// up to watermark. When advanced the watermark is recorded, and saved with
+// HubOptions.FlushWatermarks once the state covering it has been written:
+// a stored watermark never runs ahead of the stored document.
+func (h *Hub) persistFlushAndAck(ctx context.Context, conn *Connection, docID string, state map[string]interface{}, ack *protocol.Message, flushID string, watermark int64, advanced bool) {
+	if !advanced {
+		h.persistAndAck(conn, docID, state, ack)
+		return
+	}
+	h.advanceFlush(conn, docID, flushID, watermark)
+	if state == nil {
+		h.saveFlush(ctx, conn, docID, flushID, watermark)
+		conn.Send(ack)
+		return
+	}
+	h.persistThenAck(conn, docID, state, ack, func(err error) {
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), h.writer.opts.Timeout)
+		defer cancel()
+		h.saveFlush(ctx, conn, docID, flushID, watermark)
+	})
+}
+
+// persistThenAck is persistAndAck, calling written, if not nil, with the
+// outcome of the write before a durable ACK is sent
+func (h *Hub) persistThenAck(conn *Connection, docID string, state map[string]interface{}, ack *protocol.Message, written func(error)) {
+	if state == nil {
+		conn.Send(ack)
+		return
+	}
+
+	durable := h.opts.DurableAcks
+	if !durable {
+		conn.Send(ack)
+	}
+	h.enqueueWrite(docID, state, func(err error) {
+		if err != nil {
+			h.metrics.persistFailures.Add(1)
+			conn.Logger().Error("Document write failed", "doc_id", docID, "err", err)
+		}
+		if written != nil {
+			written(err)
+		}
+		if !durable {
+			return
+		}
+		ack.Payload["durable"] = err == nil
+		if err != nil {
+			ack.Payload["status"] = "failed"
+			ack.Payload["reason"] = PersistFailed
+		}
+		conn.Send(ack)
+	})
+}
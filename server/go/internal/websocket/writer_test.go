@@ -0,0 +1,272 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// gatedStore is a PersistFunc backend whose writes block until released
+type gatedStore struct {
+	mu      sync.Mutex
+	writes  []map[string]interface{}
+	active  int
+	overlap bool          // Two writes ran at once
+	started chan struct{} // Receives once per write
+	gate    chan struct{} // Each write takes one value before returning
+	err     error
+}
+
+func newGatedStore() *gatedStore {
+	return &gatedStore{started: make(chan struct{}, 100), gate: make(chan struct{}, 100)}
+}
+
+func (s *gatedStore) persist(ctx context.Context, docID string, state map[string]interface{}) error {
+	s.mu.Lock()
+	s.active++
+	s.overlap = s.overlap || s.active > 1
+	s.writes = append(s.writes, state)
+	s.mu.Unlock()
+
+	s.started <- struct{}{}
+	<-s.gate
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	return s.err
+}
+
+func (s *gatedStore) waitStarted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a write to start")
+	}
+}
+
+func TestWriter_BatchesWritesPerDocumentInOrder(t *testing.T) {
+	store := newGatedStore()
+	w := NewWriter(store.persist, WriterOptions{})
+
+	var mu sync.Mutex
+	var finished []int
+	enqueue := func(n int) {
+		w.Enqueue("room:doc", map[string]interface{}{"n": n}, func(err error) {
+			if err != nil {
+				t.Errorf("write %d failed: %v", n, err)
+			}
+			mu.Lock()
+			finished = append(finished, n)
+			mu.Unlock()
+		})
+	}
+
+	enqueue(1)
+	store.waitStarted(t)
+	// Queued behind the in-flight write, these collapse into one
+	for n := 2; n <= 5; n++ {
+		enqueue(n)
+	}
+	store.gate <- struct{}{}
+	store.waitStarted(t)
+	store.gate <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := w.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	if len(store.writes) != 2 || store.writes[1]["n"] != 5 {
+		t.Errorf("writes = %v, want the first state and then only the latest", store.writes)
+	}
+	if store.overlap {
+		t.Error("writes for the same document overlapped")
+	}
+	for i, n := range finished {
+		if n != i+1 {
+			t.Fatalf("callbacks ran in order %v, want 1..5", finished)
+		}
+	}
+	if len(finished) != 5 {
+		t.Errorf("%d callbacks ran, want 5", len(finished))
+	}
+}
+
+func TestWriter_DocumentsWriteInParallel(t *testing.T) {
+	store := newGatedStore()
+	w := NewWriter(store.persist, WriterOptions{})
+
+	w.Enqueue("room:a", map[string]interface{}{}, nil)
+	w.Enqueue("room:b", map[string]interface{}{}, nil)
+	store.waitStarted(t)
+	store.waitStarted(t) // Both started without either finishing
+	store.gate <- struct{}{}
+	store.gate <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := w.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+}
+
+func TestWriter_RetriesTransientFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"transient then success", []error{errors.New("connection reset"), errors.New("timeout")}, 3, false},
+		{"gives up after max attempts", []error{errors.New("a"), errors.New("b"), errors.New("c")}, 3, true},
+		{"permanent error not retried", []error{storage.NewConflictError("duplicate")}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			persist := func(ctx context.Context, docID string, state map[string]interface{}) error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			}
+			w := NewWriter(persist, WriterOptions{MaxAttempts: 3, RetryDelay: time.Millisecond})
+
+			result := make(chan error, 1)
+			w.Enqueue("room:retry", map[string]interface{}{}, func(err error) { result <- err })
+			err := <-result
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("persist called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWriter_FlushHonorsContext(t *testing.T) {
+	store := newGatedStore()
+	w := NewWriter(store.persist, WriterOptions{})
+	w.Enqueue("room:slow", map[string]interface{}{}, nil)
+	store.waitStarted(t)
+	defer func() { store.gate <- struct{}{} }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := w.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Flush() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// --- Durable ACKs ---
+
+func TestHub_DurableAcksWaitForPersistence(t *testing.T) {
+	store := newGatedStore()
+	hub := NewHubWithOptions(testAuth, HubOptions{Persist: store.persist, DurableAcks: true})
+	writer := joinDirect(t, hub, "writer", "room:durable")
+
+	sendDelta(hub, writer, "room:durable", "title", "hello")
+	store.waitStarted(t)
+	if n := len(writer.send); n != 0 {
+		t.Fatalf("%d messages queued before the write finished, want no ACK yet", n)
+	}
+
+	store.gate <- struct{}{}
+	ack := expectMessage(t, writer, protocol.TypeAck)
+	if ack.Payload["status"] != "applied" || ack.Payload["durable"] != true {
+		t.Errorf("ACK = %v, want applied and durable", ack.Payload)
+	}
+	if store.writes[0]["title"] != "hello" {
+		t.Errorf("persisted state = %v", store.writes[0])
+	}
+}
+
+func TestHub_DurableAckReportsPersistFailure(t *testing.T) {
+	store := newGatedStore()
+	store.err = storage.NewConflictError("rejected by database")
+	hub := NewHubWithOptions(testAuth, HubOptions{Persist: store.persist, DurableAcks: true})
+	writer := joinDirect(t, hub, "writer", "room:durable")
+
+	store.gate <- struct{}{}
+	handleDirect(hub, writer, protocol.TypeDeltaBatch, map[string]interface{}{
+		"docId":  "room:durable",
+		"deltas": []interface{}{map[string]interface{}{"changes": map[string]interface{}{"a": 1.0}}},
+	})
+	ack := expectMessage(t, writer, protocol.TypeAck)
+	if ack.Payload["status"] != "failed" || ack.Payload["reason"] != PersistFailed || ack.Payload["durable"] != false {
+		t.Errorf("ACK = %v, want failed with reason %q", ack.Payload, PersistFailed)
+	}
+	if got := hub.Metrics().PersistFailures; got != 1 {
+		t.Errorf("PersistFailures = %d, want 1", got)
+	}
+}
+
+func TestHub_AcksImmediatelyWithoutDurableAcks(t *testing.T) {
+	store := newGatedStore()
+	hub := NewHubWithOptions(testAuth, HubOptions{Persist: store.persist})
+	writer := joinDirect(t, hub, "writer", "room:fast")
+
+	sendDelta(hub, writer, "room:fast", "title", "hello")
+	ack := expectMessage(t, writer, protocol.TypeAck)
+	if _, ok := ack.Payload["durable"]; ok {
+		t.Errorf("ACK = %v, want no durable flag", ack.Payload)
+	}
+	store.waitStarted(t)
+	store.gate <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := hub.writer.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+}
+
+// --- Restore ---
+
+func TestHub_RestoreDocumentReplacesStateAndResyncs(t *testing.T) {
+	store := newGatedStore()
+	hub := NewHubWithOptions(testAuth, HubOptions{Persist: store.persist})
+	writer := joinDirect(t, hub, "writer", "room:restore")
+	sendDelta(hub, writer, "room:restore", "title", "broken")
+	expectMessage(t, writer, protocol.TypeAck)
+	store.waitStarted(t)
+	store.gate <- struct{}{}
+
+	store.gate <- struct{}{}
+	err := hub.RestoreDocument(context.Background(), "room:restore", map[string]interface{}{"title": "good"})
+	if err != nil {
+		t.Fatalf("RestoreDocument() error = %v", err)
+	}
+	if last := store.writes[len(store.writes)-1]; last["title"] != "good" {
+		t.Errorf("persisted state = %v, want the restored one", last)
+	}
+
+	notice := expectMessage(t, writer, protocol.TypeSyncRequired)
+	if notice.Payload["docId"] != "room:restore" || notice.Payload["reason"] != DivergenceRestored {
+		t.Errorf("sync_required = %v", notice.Payload)
+	}
+
+	// Resuming from before the restore gets the restored state, not a replay
+	reader := joinDirect(t, hub, "reader")
+	handleDirect(hub, reader, protocol.TypeSubscribe, map[string]interface{}{
+		"docId":      "room:restore",
+		"resumeFrom": map[string]interface{}{"room:restore": 0.0},
+	})
+	resp := expectMessage(t, reader, protocol.TypeSyncResponse)
+	state, _ := resp.Payload["state"].(map[string]interface{})
+	if resp.Payload["resumed"] == true || state["title"] != "good" {
+		t.Errorf("sync_response = %v, want the restored state", resp.Payload)
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// SetAwareness shares the client's presence on the document, such as a
+// cursor or selection, with its other subscribers. It replaces the
+// previous state and is shared again after reconnecting; nil clears it.
+func (d *Document) SetAwareness(state map[string]interface{}) error {
+	normalized, err := normalize(state)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrClosed
+	}
+	d.presence = normalized
+	d.mu.Unlock()
+	return d.c.notify(protocol.TypeAwarenessUpdate, map[string]interface{}{"docId": d.id, "state": normalized})
+}
+
+// Awareness returns the presence each other client shares on the document,
+// keyed by client ID
+func (d *Document) Awareness() map[string]map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	states := make(map[string]map[string]interface{}, len(d.awareness))
+	for clientID, state := range d.awareness {
+		states[clientID] = state
+	}
+	return states
+}
+
+// OnAwareness registers fn to be called when another client's presence
+// changes, with a nil state when it leaves. It is called on the client's
+// read goroutine and must not block.
+func (d *Document) OnAwareness(fn func(clientID string, state map[string]interface{})) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onAwareness = append(d.onAwareness, fn)
+}
+
+// applyAwareness takes in an awareness_state: every client's state in
+// answer to awareness_subscribe, or one client's as it changes, whole or
+// as the fields that changed
+func (d *Document) applyAwareness(payload map[string]interface{}) {
+	changes := make(map[string]map[string]interface{})
+	self := d.c.ClientID()
+
+	d.mu.Lock()
+	if list, ok := payload["states"].([]interface{}); ok {
+		seen := make(map[string]bool, len(list))
+		for _, raw := range list {
+			entry, _ := raw.(map[string]interface{})
+			clientID, _ := entry["clientId"].(string)
+			state, _ := entry["state"].(map[string]interface{})
+			if clientID == "" || clientID == self || state == nil {
+				continue
+			}
+			seen[clientID] = true
+			d.awareness[clientID] = state
+			changes[clientID] = state
+		}
+		for clientID := range d.awareness {
+			if !seen[clientID] {
+				delete(d.awareness, clientID)
+				changes[clientID] = nil
+			}
+		}
+	} else if clientID, _ := payload["clientId"].(string); clientID != "" && clientID != self {
+		state, _ := payload["state"].(map[string]interface{})
+		changed, isDiff := payload["changed"].(map[string]interface{})
+		if isDiff {
+			state = patchAwareness(d.awareness[clientID], changed, payload["removed"])
+		}
+		switch {
+		case state != nil:
+			d.awareness[clientID] = state
+			changes[clientID] = state
+		case !isDiff:
+			delete(d.awareness, clientID)
+			changes[clientID] = nil
+		}
+	}
+	handlers := d.onAwareness
+	d.mu.Unlock()
+
+	for clientID, state := range changes {
+		for _, fn := range handlers {
+			fn(clientID, state)
+		}
+	}
+}
+
+// patchAwareness returns a copy of base with a diff applied, or nil
+// without a base to apply it to. The server sends diffs only for states it
+// has sent in full, so that happens only after the client left here.
+func patchAwareness(base, changed map[string]interface{}, removed interface{}) map[string]interface{} {
+	if base == nil {
+		return nil
+	}
+	state := make(map[string]interface{}, len(base)+len(changed))
+	for k, v := range base {
+		state[k] = v
+	}
+	for k, v := range changed {
+		state[k] = v
+	}
+	keys, _ := removed.([]interface{})
+	for _, key := range keys {
+		if k, ok := key.(string); ok {
+			delete(state, k)
+		}
+	}
+	return state
+}
@@ -0,0 +1,582 @@
+// Package client connects Go programs to a SyncKit server: bots, importers
+// and backend jobs that read and write documents alongside browser clients.
+// It speaks the binary websocket protocol the server's hub implements,
+// authenticates, keeps subscriptions across reconnects and resumes them
+// from the last sequence number seen, as it also does when the server
+// reports having dropped messages for it.
+//
+//	c, err := client.Dial(ctx, "ws://localhost:8080/ws", client.Options{Token: token})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	doc, err := c.Open(ctx, "room:standup")
+//	if err != nil {
+//		return err
+//	}
+//	doc.OnChange(func(changes map[string]interface{}) { log.Println(changes) })
+//	doc.Set("status", "ready")
+//	return doc.Flush(ctx)
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// Defaults for zero Options fields
+const (
+	DefaultRequestTimeout = 10 * time.Second
+	DefaultMinBackoff     = 250 * time.Millisecond
+	DefaultMaxBackoff     = 30 * time.Second
+)
+
+var (
+	// ErrClosed is returned once the client is closed
+	ErrClosed = errors.New("client: closed")
+
+	// ErrDisconnected is returned for requests whose connection dropped
+	// before the server answered
+	ErrDisconnected = errors.New("client: disconnected")
+
+	// ErrReadOnly is returned for writes to documents opened read-only
+	ErrReadOnly = errors.New("client: document is read-only")
+)
+
+// ServerError is an error the server sent: an error message or auth_error
+// answering a request, or the rejection of a delta
+type ServerError struct {
+	Code    string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	if e.Message == "" {
+		return "synckit: " + e.Code
+	}
+	return "synckit: " + e.Message + " (" + e.Code + ")"
+}
+
+// Options configure a client. With none of Token, TokenFunc and APIKey set,
+// the client authenticates anonymously, as servers without required auth
+// allow.
+type Options struct {
+	// Token is the JWT sent in AUTH
+	Token string
+	// TokenFunc, when set, is asked for a token on every connect and when
+	// the server warns that the current one is expiring. It overrides Token.
+	TokenFunc func(ctx context.Context) (string, error)
+	// APIKey authenticates server-to-server clients instead of a token
+	APIKey string
+	// UserID names anonymous clients
+	UserID string
+	// ClientID identifies the client across reconnects; random when empty
+	ClientID string
+
+	// Dialer and Header are used for the websocket handshake
+	Dialer *websocket.Dialer
+	Header http.Header
+
+	// RequestTimeout bounds waiting for the answer to a request when the
+	// caller's context has no deadline
+	RequestTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the jittered exponential delay between
+	// reconnect attempts
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// BatchWindow gathers a document's changes for this long before sending
+	// them as one delta; 0 sends them as soon as the previous delta is
+	// acknowledged, which batches whatever changed meanwhile
+	BatchWindow time.Duration
+
+	// OnError receives errors that answer no request: server errors about
+	// nothing in flight and failed reconnect attempts
+	OnError func(error)
+	Logger  *slog.Logger
+}
+
+// Client is a connection to a SyncKit server that reconnects until closed.
+// It is safe for concurrent use.
+type Client struct {
+	url  string
+	opts Options
+
+	ctx    context.Context // Cancelled by Close
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the connection loop exits
+
+	mu     sync.Mutex
+	conn   *conn         // Current connection, nil while reconnecting
+	ready  chan struct{} // Closed once conn is set
+	docs   map[string]*Document
+	userID string
+}
+
+// conn is one websocket connection and the requests awaiting answers on it
+type conn struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+	lost    chan struct{} // Closed when the connection drops
+
+	mu    sync.Mutex
+	calls map[string]chan *protocol.Message // Request ID -> answer
+
+	// Set from server_shutdown: how long to wait before reconnecting
+	reconnectAfter time.Duration
+
+	// Sequence number of the last frame read; touched only by the reader
+	serverSeq uint64
+}
+
+// Dial connects and authenticates to the server at url, a ws:// or wss://
+// websocket endpoint, and returns a client that reconnects until Close
+func Dial(ctx context.Context, url string, opts Options) (*Client, error) {
+	if opts.ClientID == "" {
+		opts.ClientID = protocol.NewID()
+	}
+	if opts.Dialer == nil {
+		opts.Dialer = websocket.DefaultDialer
+	}
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = DefaultRequestTimeout
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(DefaultMaxBackoff, opts.MinBackoff)
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	c := &Client{
+		url:   url,
+		opts:  opts,
+		done:  make(chan struct{}),
+		ready: make(chan struct{}),
+		docs:  make(map[string]*Document),
+	}
+	cn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.setConn(cn)
+	go c.run(cn)
+	return c, nil
+}
+
+// ClientID returns the ID the client authenticates with
+func (c *Client) ClientID() string {
+	return c.opts.ClientID
+}
+
+// UserID returns the user the server authenticated the client as
+func (c *Client) UserID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userID
+}
+
+// Close disconnects and stops reconnecting. Changes not yet acknowledged
+// are lost; Flush documents first to keep them.
+func (c *Client) Close() error {
+	c.cancel()
+	c.mu.Lock()
+	cn := c.conn
+	c.mu.Unlock()
+	if cn != nil {
+		cn.writeMu.Lock()
+		cn.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		cn.writeMu.Unlock()
+		cn.ws.Close()
+	}
+	<-c.done
+	return nil
+}
+
+// connect dials and authenticates one connection
+func (c *Client) connect(ctx context.Context) (*conn, error) {
+	auth := map[string]interface{}{
+		"clientId":        c.opts.ClientID,
+		"protocolVersion": protocol.ProtocolVersion,
+		"capabilities":    []interface{}{protocol.CapabilityResume, protocol.CapabilityServerSeq, protocol.CapabilityAwarenessDiff},
+	}
+	if err := c.credentials(ctx, auth); err != nil {
+		return nil, err
+	}
+
+	ws, _, err := c.opts.Dialer.DialContext(ctx, c.url, c.opts.Header)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{ws: ws, lost: make(chan struct{}), calls: make(map[string]chan *protocol.Message)}
+
+	// Nothing else reads yet, so the answer is read here
+	id := protocol.NewID()
+	if err := cn.send(protocol.TypeAuth, id, auth); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		ws.SetReadDeadline(deadline)
+	} else {
+		ws.SetReadDeadline(time.Now().Add(c.opts.RequestTimeout))
+	}
+	for {
+		msgs, _, err := cn.read()
+		if err != nil {
+			ws.Close()
+			return nil, err
+		}
+		for _, msg := range msgs {
+			if msg.Origin != id {
+				continue
+			}
+			if msg.Type != protocol.TypeAuthSuccess {
+				ws.Close()
+				return nil, serverError(msg)
+			}
+			ws.SetReadDeadline(time.Time{})
+			userID, _ := msg.Payload["userId"].(string)
+			c.mu.Lock()
+			c.userID = userID
+			c.mu.Unlock()
+			return cn, nil
+		}
+	}
+}
+
+// credentials adds whatever the client authenticates with to an auth payload
+func (c *Client) credentials(ctx context.Context, auth map[string]interface{}) error {
+	switch {
+	case c.opts.TokenFunc != nil:
+		token, err := c.opts.TokenFunc(ctx)
+		if err != nil {
+			return fmt.Errorf("client: getting a token: %w", err)
+		}
+		auth["token"] = token
+	case c.opts.Token != "":
+		auth["token"] = c.opts.Token
+	case c.opts.APIKey != "":
+		auth["apiKey"] = c.opts.APIKey
+	case c.opts.UserID != "":
+		auth["userId"] = c.opts.UserID
+	}
+	return nil
+}
+
+func (c *Client) setConn(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = cn
+	if cn != nil {
+		close(c.ready)
+	} else {
+		c.ready = make(chan struct{})
+	}
+}
+
+// current waits for a live connection
+func (c *Client) current(ctx context.Context) (*conn, error) {
+	for {
+		c.mu.Lock()
+		cn, ready := c.conn, c.ready
+		c.mu.Unlock()
+		if cn != nil {
+			return cn, nil
+		}
+		select {
+		case <-ready:
+		case <-c.ctx.Done():
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// run reads from each connection in turn, reconnecting when one drops
+func (c *Client) run(cn *conn) {
+	defer close(c.done)
+	for {
+		c.readLoop(cn)
+		c.setConn(nil)
+		cn.drop()
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.opts.Logger.Info("Disconnected from SyncKit server")
+
+		delay := cn.reconnectAfter
+		for attempt := 0; ; attempt++ {
+			if attempt > 0 || delay == 0 {
+				delay = c.backoff(attempt)
+			}
+			select {
+			case <-time.After(delay):
+			case <-c.ctx.Done():
+				return
+			}
+			var err error
+			if cn, err = c.connect(c.ctx); err == nil {
+				break
+			}
+			if c.ctx.Err() != nil {
+				return
+			}
+			c.opts.Logger.Warn("Reconnect failed", "attempt", attempt+1, "err", err)
+			c.reportError(fmt.Errorf("client: reconnecting: %w", err))
+		}
+		c.opts.Logger.Info("Reconnected to SyncKit server")
+		c.setConn(cn)
+		go c.resubscribe()
+	}
+}
+
+// backoff returns the delay before reconnect attempt n: exponential from
+// MinBackoff up to MaxBackoff, with full jitter over its upper half
+func (c *Client) backoff(n int) time.Duration {
+	d := c.opts.MinBackoff
+	for i := 0; i < n && d < c.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, c.opts.MaxBackoff)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// resubscribe restores every open document on a new connection, resuming
+// from the last sequence number each saw
+func (c *Client) resubscribe() {
+	c.mu.Lock()
+	docs := make([]*Document, 0, len(c.docs))
+	for _, doc := range c.docs {
+		docs = append(docs, doc)
+	}
+	c.mu.Unlock()
+
+	for _, doc := range docs {
+		if err := doc.subscribe(c.ctx, true); err != nil {
+			if errors.Is(err, ErrDisconnected) || c.ctx.Err() != nil {
+				return
+			}
+			c.reportError(fmt.Errorf("client: resubscribing to %s: %w", doc.id, err))
+		}
+	}
+}
+
+func (c *Client) readLoop(cn *conn) {
+	for {
+		msgs, missed, err := cn.read()
+		if err != nil {
+			return
+		}
+		if missed {
+			c.opts.Logger.Warn("Server dropped messages for this client; resubscribing")
+			go c.resubscribe()
+		}
+		for _, msg := range msgs {
+			if !cn.answer(msg) {
+				c.dispatch(cn, msg)
+			}
+		}
+	}
+}
+
+// dispatch handles a message that answers no request
+func (c *Client) dispatch(cn *conn, msg *protocol.Message) {
+	docID, _ := msg.Payload["docId"].(string)
+	switch msg.Type {
+	case protocol.TypeDelta:
+		if doc := c.document(docID); doc != nil {
+			doc.applyRemote(msg.Payload)
+		}
+	case protocol.TypeAwarenessState:
+		if doc := c.document(docID); doc != nil {
+			doc.applyAwareness(msg.Payload)
+		}
+	case protocol.TypeSyncRequired:
+		if doc := c.document(docID); doc != nil {
+			go doc.resync()
+		}
+	case protocol.TypeTokenExpiring:
+		if c.opts.TokenFunc != nil {
+			go c.reauthenticate()
+		}
+	case protocol.TypeServerShutdown:
+		if ms, ok := msg.Payload["reconnectAfter"].(float64); ok && ms > 0 {
+			cn.reconnectAfter = time.Duration(ms) * time.Millisecond
+		}
+	case protocol.TypeError, protocol.TypeAuthError:
+		c.reportError(serverError(msg))
+	}
+}
+
+// reauthenticate sends a fresh token on the current connection
+func (c *Client) reauthenticate() {
+	ctx, cancel := context.WithTimeout(c.ctx, c.opts.RequestTimeout)
+	defer cancel()
+	auth := map[string]interface{}{"clientId": c.opts.ClientID}
+	if err := c.credentials(ctx, auth); err != nil {
+		c.reportError(err)
+		return
+	}
+	if _, err := c.request(ctx, protocol.TypeAuth, auth); err != nil {
+		c.reportError(fmt.Errorf("client: re-authenticating: %w", err))
+	}
+}
+
+func (c *Client) reportError(err error) {
+	if c.opts.OnError != nil {
+		c.opts.OnError(err)
+	}
+}
+
+func (c *Client) document(docID string) *Document {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.docs[docID]
+}
+
+// request sends a message and waits for the message answering it, which
+// is an error for error and auth_error answers
+func (c *Client) request(ctx context.Context, msgType string, payload map[string]interface{}) (*protocol.Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.RequestTimeout)
+		defer cancel()
+	}
+	cn, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id := protocol.NewID()
+	answer := make(chan *protocol.Message, 1)
+	cn.mu.Lock()
+	cn.calls[id] = answer
+	cn.mu.Unlock()
+	defer func() {
+		cn.mu.Lock()
+		delete(cn.calls, id)
+		cn.mu.Unlock()
+	}()
+
+	if err := cn.send(msgType, id, payload); err != nil {
+		return nil, ErrDisconnected
+	}
+	select {
+	case msg := <-answer:
+		if msg.Type == protocol.TypeError || msg.Type == protocol.TypeAuthError {
+			return nil, serverError(msg)
+		}
+		return msg, nil
+	case <-cn.lost:
+		return nil, ErrDisconnected
+	case <-c.ctx.Done():
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// notify sends a message the server does not answer unless it fails
+func (c *Client) notify(msgType string, payload map[string]interface{}) error {
+	c.mu.Lock()
+	cn := c.conn
+	c.mu.Unlock()
+	if cn == nil {
+		return ErrDisconnected
+	}
+	return cn.send(msgType, protocol.NewID(), payload)
+}
+
+func (cn *conn) send(msgType, id string, payload map[string]interface{}) error {
+	msg := make(map[string]interface{}, len(payload)+2)
+	for k, v := range payload {
+		msg[k] = v
+	}
+	msg["type"] = msgType
+	msg["id"] = id
+	data, err := protocol.EncodeMessage(msgType, msg, time.Now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	cn.writeMu.Lock()
+	defer cn.writeMu.Unlock()
+	return cn.ws.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// read returns the messages of the next frame, unpacking batches. missed
+// reports that the frame's sequence number skipped some, which the server
+// dropped.
+func (cn *conn) read() (msgs []*protocol.Message, missed bool, err error) {
+	for {
+		_, data, err := cn.ws.ReadMessage()
+		if err != nil {
+			return nil, false, err
+		}
+		if seq, ok := protocol.ServerSeq(data); ok {
+			missed = missed || seq != cn.serverSeq+1
+			cn.serverSeq = seq
+		}
+		if !protocol.IsBatch(data) {
+			msg, err := protocol.DecodeMessage(data)
+			if err != nil {
+				continue
+			}
+			return []*protocol.Message{msg}, missed, nil
+		}
+		items, err := protocol.Decoder{}.DecodeBatch(data)
+		if err != nil {
+			continue
+		}
+		msgs := make([]*protocol.Message, 0, len(items))
+		for _, item := range items {
+			if item.Err == nil {
+				msgs = append(msgs, item.Message)
+			}
+		}
+		return msgs, missed, nil
+	}
+}
+
+// answer hands msg to the request it answers, if one is waiting
+func (cn *conn) answer(msg *protocol.Message) bool {
+	if msg.Origin == "" {
+		return false
+	}
+	cn.mu.Lock()
+	answer, ok := cn.calls[msg.Origin]
+	delete(cn.calls, msg.Origin)
+	cn.mu.Unlock()
+	if ok {
+		answer <- msg
+	}
+	return ok
+}
+
+// drop closes a connection that stopped reading, failing its requests
+func (cn *conn) drop() {
+	cn.ws.Close()
+	close(cn.lost)
+}
+
+func serverError(msg *protocol.Message) *ServerError {
+	code, _ := msg.Payload["code"].(string)
+	message, _ := msg.Payload["error"].(string)
+	return &ServerError{Code: code, Message: message}
+}
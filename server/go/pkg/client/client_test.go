@@ -0,0 +1,357 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+	"github.com/Dancode-188/synckit/server/go/internal/websocket"
+)
+
+// newServer runs a hub behind an httptest server, as cmd/server would
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	hub := websocket.NewHubWithOptions(websocket.AuthConfig{}, websocket.HubOptions{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	go hub.Run()
+
+	upgrader := gorilla.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := websocket.NewConnection(r.RemoteAddr, ws, hub)
+		hub.Register <- conn
+		go conn.WritePump()
+		go conn.ReadPump()
+	}))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		hub.Stop(ctx)
+		ts.Close()
+	})
+	return ts
+}
+
+func dial(t *testing.T, ts *httptest.Server, opts Options) *Client {
+	t.Helper()
+	opts.MinBackoff = 10 * time.Millisecond
+	opts.MaxBackoff = 50 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "ws"+strings.TrimPrefix(ts.URL, "http"), opts)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func open(t *testing.T, c *Client, docID string) *Document {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	doc, err := c.Open(ctx, docID)
+	if err != nil {
+		t.Fatalf("Open(%q): %v", docID, err)
+	}
+	return doc
+}
+
+func flush(t *testing.T, doc *Document) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := doc.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+}
+
+// eventually polls cond until it holds or the test times out
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDocument_ChangesReachOtherClients(t *testing.T) {
+	ts := newServer(t)
+	alice := open(t, dial(t, ts, Options{UserID: "alice"}), "room:1")
+	bob := open(t, dial(t, ts, Options{UserID: "bob"}), "room:1")
+
+	var mu sync.Mutex
+	var seen []map[string]interface{}
+	bob.OnChange(func(changes map[string]interface{}) {
+		mu.Lock()
+		seen = append(seen, changes)
+		mu.Unlock()
+	})
+
+	if err := alice.Set("title", "Standup"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	flush(t, alice)
+	eventually(t, "bob to see the title", func() bool {
+		v, _ := bob.Get("title")
+		return v == "Standup"
+	})
+
+	alice.Delete("title")
+	flush(t, alice)
+	eventually(t, "bob to see the delete", func() bool {
+		_, ok := bob.Get("title")
+		return !ok
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 2 || seen[0]["title"] != "Standup" || seen[1]["title"] != nil {
+		t.Errorf("OnChange calls = %v, want the set then the delete", seen)
+	}
+	if alice.Seq() != 2 {
+		t.Errorf("alice.Seq() = %d, want 2", alice.Seq())
+	}
+
+	// A late joiner gets the state in its sync_response
+	carol := open(t, dial(t, ts, Options{UserID: "carol"}), "room:1")
+	if state := carol.State(); len(state) != 0 || carol.Seq() != 2 {
+		t.Errorf("carol has %v at seq %d, want nothing at seq 2", state, carol.Seq())
+	}
+}
+
+func TestDocument_BatchesChangesWithinWindow(t *testing.T) {
+	ts := newServer(t)
+	writer := open(t, dial(t, ts, Options{BatchWindow: 50 * time.Millisecond}), "room:batch")
+	reader := open(t, dial(t, ts, Options{}), "room:batch")
+
+	var mu sync.Mutex
+	var deltas int
+	reader.OnChange(func(map[string]interface{}) {
+		mu.Lock()
+		deltas++
+		mu.Unlock()
+	})
+
+	for i, key := range []string{"a", "b", "c", "a"} {
+		writer.Set(key, i)
+	}
+	flush(t, writer)
+	eventually(t, "the batch to arrive", func() bool { return len(reader.State()) == 3 })
+
+	if v, _ := reader.Get("a"); v != float64(3) {
+		t.Errorf("a = %v, want the last write, 3", v)
+	}
+	if writer.Seq() != 1 {
+		t.Errorf("writer.Seq() = %d, want one delta", writer.Seq())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if deltas != 1 {
+		t.Errorf("reader saw %d deltas, want 1", deltas)
+	}
+}
+
+func TestClient_ReconnectsAndResumes(t *testing.T) {
+	ts := newServer(t)
+	bot := dial(t, ts, Options{UserID: "bot"})
+	doc := open(t, bot, "room:resume")
+	doc.Set("before", true)
+	flush(t, doc)
+
+	other := open(t, dial(t, ts, Options{UserID: "other"}), "room:resume")
+	clientID := bot.ClientID()
+
+	// Drop every connection; the other client reconnects as well
+	ts.CloseClientConnections()
+
+	// Written while disconnected, sent once reconnected
+	doc.Set("during", true)
+	flush(t, doc)
+	eventually(t, "the other client to see the write", func() bool {
+		_, ok := other.Get("during")
+		return ok
+	})
+
+	other.Set("after", "hello")
+	flush(t, other)
+	eventually(t, "the bot to see the other client's write", func() bool {
+		v, _ := doc.Get("after")
+		return v == "hello"
+	})
+
+	if bot.ClientID() != clientID {
+		t.Errorf("ClientID changed across reconnect: %q, want %q", bot.ClientID(), clientID)
+	}
+	want := map[string]interface{}{"before": true, "during": true, "after": "hello"}
+	if got := doc.State(); !reflect.DeepEqual(got, want) {
+		t.Errorf("State() = %v, want %v", got, want)
+	}
+}
+
+func TestClient_ResubscribesAfterDroppedFrames(t *testing.T) {
+	// A scripted server: it answers requests, then skips a sequence number
+	subscribes := make(chan map[string]interface{}, 4)
+	upgrader := gorilla.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		seq, subscribed := uint64(0), 0
+		send := func(msgType string, payload map[string]interface{}) {
+			data, _ := protocol.EncodeMessage(msgType, payload, time.Now().UnixMilli())
+			if msgType != protocol.TypeAuthSuccess {
+				seq++
+				data, _ = protocol.StampServerSeq(data, seq)
+			}
+			ws.WriteMessage(gorilla.BinaryMessage, data)
+		}
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg, err := protocol.DecodeMessage(data)
+			if err != nil {
+				return
+			}
+			switch msg.Type {
+			case protocol.TypeAuth:
+				send(protocol.TypeAuthSuccess, map[string]interface{}{"origin": msg.ID, "userId": "bot"})
+			case protocol.TypeSubscribe:
+				subscribed++
+				subscribes <- msg.Payload
+				reply := map[string]interface{}{"origin": msg.ID, "docId": "room:gap", "state": map[string]interface{}{}, "seq": 4.0}
+				if msg.Payload["resumeFrom"] != nil {
+					reply = map[string]interface{}{"origin": msg.ID, "docId": "room:gap", "resumed": true, "deltas": []interface{}{}, "seq": 6.0}
+				}
+				send(protocol.TypeSyncResponse, reply)
+			case protocol.TypeAwarenessSubscribe:
+				send(protocol.TypeAwarenessState, map[string]interface{}{"origin": msg.ID, "docId": "room:gap", "states": []interface{}{}})
+				if subscribed == 1 {
+					// The first subscription is done; drop a frame
+					seq++
+					send(protocol.TypeDelta, map[string]interface{}{"docId": "room:gap", "changes": map[string]interface{}{"title": "After the gap"}, "seq": 6.0})
+				}
+			}
+		}
+	}))
+	defer ts.Close()
+
+	doc := open(t, dial(t, ts, Options{UserID: "bot"}), "room:gap")
+	if first := <-subscribes; first["resumeFrom"] != nil {
+		t.Errorf("first subscribe = %v, want no resumeFrom", first)
+	}
+	select {
+	case again := <-subscribes:
+		if from, _ := again["resumeFrom"].(map[string]interface{}); from["room:gap"] != 6.0 {
+			t.Errorf("subscribe after the gap = %v, want it to resume from seq 6", again)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client did not resubscribe after the gap")
+	}
+	if v, _ := doc.Get("title"); v != "After the gap" {
+		t.Errorf("title = %v, want the delta that revealed the gap", v)
+	}
+}
+
+func TestDocument_Awareness(t *testing.T) {
+	ts := newServer(t)
+	alice := dial(t, ts, Options{UserID: "alice"})
+	aliceDoc := open(t, alice, "room:cursor")
+	if err := aliceDoc.SetAwareness(map[string]interface{}{"cursor": 3}); err != nil {
+		t.Fatalf("SetAwareness: %v", err)
+	}
+
+	// bob gets alice's state in answer to awareness_subscribe, or as it's
+	// broadcast if the update hadn't reached the hub yet
+	bobClient := dial(t, ts, Options{UserID: "bob"})
+	bob := open(t, bobClient, "room:cursor")
+	eventually(t, "bob to see alice's cursor", func() bool {
+		return bob.Awareness()[alice.ClientID()]["cursor"] == float64(3)
+	})
+
+	left := make(chan string, 1)
+	bob.OnAwareness(func(clientID string, state map[string]interface{}) {
+		if state == nil {
+			left <- clientID
+		}
+	})
+	aliceDoc.SetAwareness(map[string]interface{}{"cursor": 9})
+	eventually(t, "bob to see alice's cursor move", func() bool {
+		return bob.Awareness()[alice.ClientID()]["cursor"] == float64(9)
+	})
+
+	// Later states arrive as diffs, removed fields included
+	aliceDoc.SetAwareness(map[string]interface{}{"cursor": 9, "selection": []interface{}{1, 4}})
+	eventually(t, "bob to see alice's selection", func() bool {
+		return bob.Awareness()[alice.ClientID()]["selection"] != nil
+	})
+	aliceDoc.SetAwareness(map[string]interface{}{"cursor": 10})
+	eventually(t, "bob to see alice's selection cleared", func() bool {
+		state := bob.Awareness()[alice.ClientID()]
+		_, selected := state["selection"]
+		return state["cursor"] == float64(10) && !selected
+	})
+	if _, ok := bob.Awareness()[bobClient.ClientID()]; ok {
+		t.Error("Awareness() includes the client's own state")
+	}
+
+	alice.Close()
+	select {
+	case clientID := <-left:
+		if clientID != alice.ClientID() {
+			t.Errorf("left = %q, want alice %q", clientID, alice.ClientID())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bob was not told alice left")
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	ts := newServer(t)
+	c := dial(t, ts, Options{UserID: "alice"})
+
+	_, err := c.Open(context.Background(), "room:no spaces")
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != "INVALID_DOCUMENT_ID" {
+		t.Errorf("Open error = %v, want INVALID_DOCUMENT_ID", err)
+	}
+
+	doc := open(t, c, "room:errors")
+	if err := doc.Set("bad", func() {}); err == nil {
+		t.Error("Set accepted a value JSON cannot encode")
+	}
+
+	c.Close()
+	if _, err := c.Open(context.Background(), "room:other"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Open after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	msg := protocol.NewMessage(protocol.TypeError, map[string]interface{}{"error": "No such document", "code": "NOT_FOUND"})
+	if got := serverError(msg).Error(); got != "synckit: No such document (NOT_FOUND)" {
+		t.Errorf("Error() = %q", got)
+	}
+}
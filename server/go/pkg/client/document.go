@@ -0,0 +1,422 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/protocol"
+)
+
+// Document is a client's copy of a server document: a map of fields, each
+// written last-writer-wins. Local changes apply at once and are sent in
+// order in the background, gathered into as few deltas as possible. A
+// field set to nil is deleted. Safe for concurrent use.
+type Document struct {
+	c  *Client
+	id string
+
+	mu        sync.Mutex
+	state     map[string]interface{}
+	seq       int64
+	readOnly  bool
+	closed    bool
+	awareness map[string]map[string]interface{} // Client ID -> state
+	presence  map[string]interface{}            // Own awareness state, restored on reconnect
+
+	onChange    []func(changes map[string]interface{})
+	onAwareness []func(clientID string, state map[string]interface{})
+
+	// Changes not yet sent, and whether a sender is running
+	outgoing map[string]interface{}
+	sending  bool
+	idle     chan struct{} // Closed while nothing is outgoing or in flight
+	err      error         // First failure since the last Flush
+}
+
+// Open subscribes to a document and returns it once the server has sent
+// its state. Opening a document twice returns the same Document.
+func (c *Client) Open(ctx context.Context, docID string) (*Document, error) {
+	c.mu.Lock()
+	doc := c.docs[docID]
+	if doc == nil {
+		doc = &Document{
+			c:         c,
+			id:        docID,
+			state:     make(map[string]interface{}),
+			awareness: make(map[string]map[string]interface{}),
+			idle:      make(chan struct{}),
+		}
+		close(doc.idle)
+		c.docs[docID] = doc
+	}
+	c.mu.Unlock()
+
+	if err := doc.subscribe(ctx, false); err != nil {
+		c.mu.Lock()
+		if c.docs[docID] == doc && doc.seq == 0 && len(doc.state) == 0 {
+			delete(c.docs, docID)
+		}
+		c.mu.Unlock()
+		return nil, err
+	}
+	return doc, nil
+}
+
+// ID returns the document's ID
+func (d *Document) ID() string {
+	return d.id
+}
+
+// Seq returns the sequence number of the last change the document has
+// from the server
+func (d *Document) Seq() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.seq
+}
+
+// ReadOnly reports whether the server only lets the client read the document
+func (d *Document) ReadOnly() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.readOnly
+}
+
+// Get returns a field's value, as JSON would decode it
+func (d *Document) Get(key string) (interface{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	value, ok := d.state[key]
+	return value, ok && value != nil
+}
+
+// State returns a copy of every field
+func (d *Document) State() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state := make(map[string]interface{}, len(d.state))
+	for k, v := range d.state {
+		if v != nil {
+			state[k] = v
+		}
+	}
+	return state
+}
+
+// Set writes a field. value must encode to JSON; nil deletes the field.
+func (d *Document) Set(key string, value interface{}) error {
+	return d.Update(map[string]interface{}{key: value})
+}
+
+// Delete removes a field
+func (d *Document) Delete(key string) error {
+	return d.Update(map[string]interface{}{key: nil})
+}
+
+// Update writes several fields in one delta, nil values deleting them
+func (d *Document) Update(changes map[string]interface{}) error {
+	normalized, err := normalize(changes)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.closed:
+		return ErrClosed
+	case d.readOnly:
+		return ErrReadOnly
+	}
+	if d.outgoing == nil {
+		d.outgoing = make(map[string]interface{}, len(normalized))
+	}
+	for k, v := range normalized {
+		d.setLocked(k, v)
+		d.outgoing[k] = v
+	}
+	if !d.sending {
+		d.sending = true
+		d.idle = make(chan struct{})
+		go d.send()
+	}
+	return nil
+}
+
+// OnChange registers fn to be called with the fields other clients
+// change, nil for deleted ones, including what a resync brings in. It is
+// called on the client's read goroutine and must not block.
+func (d *Document) OnChange(fn func(changes map[string]interface{})) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onChange = append(d.onChange, fn)
+}
+
+// Flush waits until every change made so far is acknowledged by the
+// server, and returns the first failure since the last Flush
+func (d *Document) Flush(ctx context.Context) error {
+	d.mu.Lock()
+	idle := d.idle
+	d.mu.Unlock()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.err
+	d.err = nil
+	return err
+}
+
+// Close unsubscribes from the document. Changes not yet sent are dropped.
+func (d *Document) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	d.outgoing = nil
+	d.mu.Unlock()
+
+	d.c.mu.Lock()
+	if d.c.docs[d.id] == d {
+		delete(d.c.docs, d.id)
+	}
+	d.c.mu.Unlock()
+	return d.c.notify(protocol.TypeUnsubscribe, map[string]interface{}{"docId": d.id})
+}
+
+// send delivers outgoing changes one delta at a time until none are left.
+// Changes made while a delta is in flight go out together in the next.
+func (d *Document) send() {
+	for {
+		if window := d.c.opts.BatchWindow; window > 0 {
+			select {
+			case <-time.After(window):
+			case <-d.c.ctx.Done():
+			}
+		}
+
+		d.mu.Lock()
+		changes := d.outgoing
+		d.outgoing = nil
+		if len(changes) == 0 || d.closed || d.c.ctx.Err() != nil {
+			d.sending = false
+			close(d.idle)
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+
+		err := d.push(changes)
+		if errors.Is(err, ErrDisconnected) {
+			// Sent again once reconnected, unless changed meanwhile
+			d.mu.Lock()
+			if d.outgoing == nil {
+				d.outgoing = make(map[string]interface{}, len(changes))
+			}
+			for k, v := range changes {
+				if _, changed := d.outgoing[k]; !changed {
+					d.outgoing[k] = v
+				}
+			}
+			d.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			d.mu.Lock()
+			if d.err == nil {
+				d.err = err
+			}
+			d.mu.Unlock()
+			d.c.opts.Logger.Warn("Delta not applied", "doc_id", d.id, "err", err)
+
+			// The server's copy differs from ours now
+			var serverErr *ServerError
+			if errors.As(err, &serverErr) {
+				d.resync()
+			}
+		}
+	}
+}
+
+// push sends one delta and waits for its ACK
+func (d *Document) push(changes map[string]interface{}) error {
+	ack, err := d.c.request(d.c.ctx, protocol.TypeDelta, map[string]interface{}{
+		"docId":   d.id,
+		"changes": changes,
+	})
+	if err != nil {
+		return err
+	}
+	if status, _ := ack.Payload["status"].(string); status == "rejected" {
+		reason, _ := ack.Payload["reason"].(string)
+		code, _ := ack.Payload["code"].(string)
+		if code == "" {
+			code = reason
+		}
+		return &ServerError{Code: code, Message: "Delta rejected: " + reason}
+	}
+	if fieldErrors, ok := ack.Payload["fieldErrors"].([]interface{}); ok && len(fieldErrors) > 0 {
+		return &ServerError{Code: "FIELDS_REJECTED", Message: fmt.Sprintf("%d changes rejected", len(fieldErrors))}
+	}
+	d.mu.Lock()
+	d.noteSeq(ack.Payload["seq"])
+	d.mu.Unlock()
+	return nil
+}
+
+// subscribe asks the server for the document, resuming from the last
+// sequence number seen when resume is set, then for its awareness states
+func (d *Document) subscribe(ctx context.Context, resume bool) error {
+	payload := map[string]interface{}{"docId": d.id}
+	d.mu.Lock()
+	if resume && d.seq > 0 {
+		payload["resumeFrom"] = map[string]interface{}{d.id: d.seq}
+	}
+	presence := d.presence
+	d.mu.Unlock()
+
+	resp, err := d.c.request(ctx, protocol.TypeSubscribe, payload)
+	if err != nil {
+		return err
+	}
+	d.applySync(resp.Payload)
+
+	states, err := d.c.request(ctx, protocol.TypeAwarenessSubscribe, map[string]interface{}{"docId": d.id})
+	if err != nil {
+		return err
+	}
+	d.applyAwareness(states.Payload)
+	if presence != nil {
+		return d.c.notify(protocol.TypeAwarenessUpdate, map[string]interface{}{"docId": d.id, "state": presence})
+	}
+	return nil
+}
+
+// resync replaces the document with the server's full state
+func (d *Document) resync() {
+	ctx, cancel := context.WithTimeout(d.c.ctx, d.c.opts.RequestTimeout)
+	defer cancel()
+	if err := d.subscribe(ctx, false); err != nil && d.c.ctx.Err() == nil {
+		d.c.reportError(fmt.Errorf("client: resyncing %s: %w", d.id, err))
+	}
+}
+
+// applySync takes in a sync_response: the deltas missed since resuming,
+// or the full state
+func (d *Document) applySync(payload map[string]interface{}) {
+	d.mu.Lock()
+	changes := make(map[string]interface{})
+	if payload["resumed"] == true {
+		deltas, _ := payload["deltas"].([]interface{})
+		for _, raw := range deltas {
+			if delta, ok := raw.(map[string]interface{}); ok {
+				d.mergeLocked(delta, changes)
+			}
+		}
+	} else {
+		state, _ := payload["state"].(map[string]interface{})
+		for k, v := range d.state {
+			if _, kept := state[k]; !kept && v != nil {
+				changes[k] = nil
+			}
+		}
+		for k, v := range state {
+			if !jsonEqual(d.state[k], v) {
+				changes[k] = v
+			}
+		}
+		d.state = make(map[string]interface{}, len(state))
+		for k, v := range state {
+			d.state[k] = v
+		}
+	}
+	d.noteSeq(payload["seq"])
+	d.readOnly = payload["readOnly"] == true
+
+	// Changes still to be sent win over what the server had
+	for k, v := range d.outgoing {
+		d.setLocked(k, v)
+		delete(changes, k)
+	}
+	handlers := d.onChange
+	d.mu.Unlock()
+	notify(handlers, changes)
+}
+
+// applyRemote takes in a delta another client made
+func (d *Document) applyRemote(delta map[string]interface{}) {
+	d.mu.Lock()
+	changes := make(map[string]interface{})
+	d.mergeLocked(delta, changes)
+	handlers := d.onChange
+	d.mu.Unlock()
+	notify(handlers, changes)
+}
+
+// mergeLocked applies a delta from the server, collecting its changes.
+// Must be called with d.mu held.
+func (d *Document) mergeLocked(delta map[string]interface{}, changes map[string]interface{}) {
+	if seq, ok := delta["seq"].(float64); ok && int64(seq) <= d.seq {
+		return // Already seen
+	}
+	fields, _ := delta["changes"].(map[string]interface{})
+	for k, v := range fields {
+		if _, pending := d.outgoing[k]; pending {
+			continue
+		}
+		d.setLocked(k, v)
+		changes[k] = v
+	}
+	d.noteSeq(delta["seq"])
+}
+
+func (d *Document) setLocked(key string, value interface{}) {
+	if value == nil {
+		delete(d.state, key)
+		return
+	}
+	d.state[key] = value
+}
+
+// noteSeq records a sequence number from the server, if it is newer.
+// Must be called with d.mu held.
+func (d *Document) noteSeq(raw interface{}) {
+	if seq, ok := raw.(float64); ok && int64(seq) > d.seq {
+		d.seq = int64(seq)
+	}
+}
+
+func notify(handlers []func(map[string]interface{}), changes map[string]interface{}) {
+	if len(changes) == 0 {
+		return
+	}
+	for _, fn := range handlers {
+		fn(changes)
+	}
+}
+
+// normalize round-trips values through JSON, so local reads see what other
+// clients will, and unencodable values are refused up front
+func normalize(changes map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return nil, fmt.Errorf("client: value does not encode to JSON: %w", err)
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
@@ -0,0 +1,377 @@
+// Package storagefake provides a storage adapter for tests that need
+// storage to misbehave: to fail, to be slow or to serve stale reads. Fake
+// stores everything in a storage.MemoryAdapter and runs whatever the test
+// scripted for a method before passing the call through:
+//
+//	store := storagefake.New()
+//	store.Connect(ctx)
+//	store.FailNext("SaveDocument", 2, errors.New("connection reset"))
+//	store.Delay("GetDocument", 50*time.Millisecond)
+//	...
+//	if store.Calls("SaveDocument") != 3 {
+//		t.Error("the write was not retried")
+//	}
+//
+// Methods are named as in the StorageAdapter interface. A Fake is safe for
+// concurrent use, and scripting it while calls are in flight affects the
+// calls that start afterwards.
+package storagefake
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// Fake is a StorageAdapter backed by a MemoryAdapter, with scriptable
+// failures, latency and stale reads per method
+type Fake struct {
+	memory *storage.MemoryAdapter
+
+	mu        sync.Mutex
+	behaviors map[string]*behavior
+	calls     map[string]int
+	stale     map[string]interface{} // Results of stale methods, by method and arguments
+}
+
+// behavior is what a test scripted for one method
+type behavior struct {
+	failures int // Calls left to fail; negative fails every call
+	err      error
+	delay    time.Duration
+	stale    bool
+}
+
+// staleResult is a remembered call of a stale method
+type staleResult struct {
+	value interface{}
+	err   error
+}
+
+var _ storage.StorageAdapter = (*Fake)(nil)
+
+// methods are the names scripting accepts
+var methods = func() map[string]bool {
+	names := make(map[string]bool)
+	adapter := reflect.TypeOf((*storage.StorageAdapter)(nil)).Elem()
+	for i := 0; i < adapter.NumMethod(); i++ {
+		names[adapter.Method(i).Name] = true
+	}
+	return names
+}()
+
+// New returns a Fake over an empty MemoryAdapter. Like other adapters it
+// needs Connect before use.
+func New() *Fake {
+	return &Fake{
+		memory:    storage.NewMemoryAdapter(),
+		behaviors: make(map[string]*behavior),
+		calls:     make(map[string]int),
+		stale:     make(map[string]interface{}),
+	}
+}
+
+// Memory returns the adapter holding the data, for seeding it or checking
+// it without going through the script
+func (f *Fake) Memory() *storage.MemoryAdapter {
+	return f.memory
+}
+
+// FailNext makes the next n calls of method return err
+func (f *Fake) FailNext(method string, n int, err error) {
+	f.script(method, func(b *behavior) { b.failures, b.err = n, err })
+}
+
+// Fail makes every call of method return err until Reset
+func (f *Fake) Fail(method string, err error) {
+	f.script(method, func(b *behavior) { b.failures, b.err = -1, err })
+}
+
+// Delay makes calls of method take d longer. A call whose context ends
+// first returns the context's error, as a real adapter would.
+func (f *Fake) Delay(method string, d time.Duration) {
+	f.script(method, func(b *behavior) { b.delay = d })
+}
+
+// Stale makes method answer each set of arguments with what it returned
+// the first time it was called with them after Stale, whatever was written
+// since, as a lagging replica would
+func (f *Fake) Stale(method string) {
+	f.script(method, func(b *behavior) { b.stale = true })
+}
+
+// Reset clears what was scripted for methods, or for every method when
+// none are given. Call counts are kept.
+func (f *Fake) Reset(methods ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(methods) == 0 {
+		clear(f.behaviors)
+		clear(f.stale)
+		return
+	}
+	for _, method := range methods {
+		delete(f.behaviors, method)
+		for key := range f.stale {
+			if len(key) > len(method) && key[:len(method)+1] == method+"(" {
+				delete(f.stale, key)
+			}
+		}
+	}
+}
+
+// Calls returns how many times method was called, failed calls included
+func (f *Fake) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *Fake) script(method string, set func(*behavior)) {
+	if !methods[method] {
+		panic(fmt.Sprintf("storagefake: %q is not a StorageAdapter method", method))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.behaviors[method]
+	if b == nil {
+		b = &behavior{}
+		f.behaviors[method] = b
+	}
+	set(b)
+}
+
+// before counts a call of method and plays its script, returning the error
+// the call should fail with
+func (f *Fake) before(ctx context.Context, method string) error {
+	f.mu.Lock()
+	f.calls[method]++
+	var delay time.Duration
+	var err error
+	if b := f.behaviors[method]; b != nil {
+		delay = b.delay
+		if b.failures != 0 {
+			err = b.err
+			if b.failures > 0 {
+				b.failures--
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// call plays method's script, then calls through unless it failed,
+// remembering the result while the method is stale
+func call[T any](f *Fake, ctx context.Context, method string, args []interface{}, through func() (T, error)) (T, error) {
+	var zero T
+	if err := f.before(ctx, method); err != nil {
+		return zero, err
+	}
+
+	f.mu.Lock()
+	b := f.behaviors[method]
+	stale := b != nil && b.stale
+	key := fmt.Sprintf("%s(%v)", method, args)
+	remembered, ok := f.stale[key].(staleResult)
+	f.mu.Unlock()
+	if stale && ok {
+		value, _ := remembered.value.(T)
+		return value, remembered.err
+	}
+
+	value, err := through()
+	if stale {
+		f.mu.Lock()
+		f.stale[key] = staleResult{value: value, err: err}
+		f.mu.Unlock()
+	}
+	return value, err
+}
+
+// exec is call for methods that only return an error
+func exec(f *Fake, ctx context.Context, method string, through func() error) error {
+	_, err := call(f, ctx, method, nil, func() (struct{}, error) { return struct{}{}, through() })
+	return err
+}
+
+// Connect connects the memory adapter
+func (f *Fake) Connect(ctx context.Context) error {
+	return exec(f, ctx, "Connect", func() error { return f.memory.Connect(ctx) })
+}
+
+// Disconnect disconnects the memory adapter
+func (f *Fake) Disconnect(ctx context.Context) error {
+	return exec(f, ctx, "Disconnect", func() error { return f.memory.Disconnect(ctx) })
+}
+
+// IsConnected reports whether the memory adapter is connected. It has no
+// context, so scripted delays and failures do not apply to it.
+func (f *Fake) IsConnected() bool {
+	f.mu.Lock()
+	f.calls["IsConnected"]++
+	f.mu.Unlock()
+	return f.memory.IsConnected()
+}
+
+func (f *Fake) HealthCheck(ctx context.Context) (bool, error) {
+	return call(f, ctx, "HealthCheck", nil, func() (bool, error) { return f.memory.HealthCheck(ctx) })
+}
+
+func (f *Fake) GetDocument(ctx context.Context, id string) (*storage.DocumentState, error) {
+	return call(f, ctx, "GetDocument", []interface{}{id}, func() (*storage.DocumentState, error) {
+		return f.memory.GetDocument(ctx, id)
+	})
+}
+
+func (f *Fake) SaveDocument(ctx context.Context, id string, state map[string]interface{}) (*storage.DocumentState, error) {
+	return call(f, ctx, "SaveDocument", nil, func() (*storage.DocumentState, error) {
+		return f.memory.SaveDocument(ctx, id, state)
+	})
+}
+
+func (f *Fake) UpdateDocument(ctx context.Context, id string, state map[string]interface{}) (*storage.DocumentState, error) {
+	return call(f, ctx, "UpdateDocument", nil, func() (*storage.DocumentState, error) {
+		return f.memory.UpdateDocument(ctx, id, state)
+	})
+}
+
+func (f *Fake) DeleteDocument(ctx context.Context, id string) (bool, error) {
+	return call(f, ctx, "DeleteDocument", nil, func() (bool, error) { return f.memory.DeleteDocument(ctx, id) })
+}
+
+func (f *Fake) ListDocuments(ctx context.Context, limit, offset int) ([]*storage.DocumentState, error) {
+	return call(f, ctx, "ListDocuments", []interface{}{limit, offset}, func() ([]*storage.DocumentState, error) {
+		return f.memory.ListDocuments(ctx, limit, offset)
+	})
+}
+
+func (f *Fake) GetVectorClock(ctx context.Context, documentID string) (map[string]int64, error) {
+	return call(f, ctx, "GetVectorClock", []interface{}{documentID}, func() (map[string]int64, error) {
+		return f.memory.GetVectorClock(ctx, documentID)
+	})
+}
+
+func (f *Fake) UpdateVectorClock(ctx context.Context, documentID, clientID string, clockValue int64) error {
+	return exec(f, ctx, "UpdateVectorClock", func() error {
+		return f.memory.UpdateVectorClock(ctx, documentID, clientID, clockValue)
+	})
+}
+
+func (f *Fake) MergeVectorClock(ctx context.Context, documentID string, clock map[string]int64) error {
+	return exec(f, ctx, "MergeVectorClock", func() error { return f.memory.MergeVectorClock(ctx, documentID, clock) })
+}
+
+func (f *Fake) SaveDelta(ctx context.Context, delta *storage.DeltaEntry) (*storage.DeltaEntry, error) {
+	return call(f, ctx, "SaveDelta", nil, func() (*storage.DeltaEntry, error) { return f.memory.SaveDelta(ctx, delta) })
+}
+
+func (f *Fake) GetDeltas(ctx context.Context, documentID string, limit int) ([]*storage.DeltaEntry, error) {
+	return call(f, ctx, "GetDeltas", []interface{}{documentID, limit}, func() ([]*storage.DeltaEntry, error) {
+		return f.memory.GetDeltas(ctx, documentID, limit)
+	})
+}
+
+func (f *Fake) QueryDeltas(ctx context.Context, documentID string, query storage.DeltaQuery) ([]*storage.DeltaEntry, error) {
+	return call(f, ctx, "QueryDeltas", []interface{}{documentID, query}, func() ([]*storage.DeltaEntry, error) {
+		return f.memory.QueryDeltas(ctx, documentID, query)
+	})
+}
+
+func (f *Fake) DeleteDeltasBefore(ctx context.Context, documentID string, before time.Time) (int, error) {
+	return call(f, ctx, "DeleteDeltasBefore", nil, func() (int, error) {
+		return f.memory.DeleteDeltasBefore(ctx, documentID, before)
+	})
+}
+
+func (f *Fake) SaveSession(ctx context.Context, session *storage.SessionEntry) (*storage.SessionEntry, error) {
+	return call(f, ctx, "SaveSession", nil, func() (*storage.SessionEntry, error) {
+		return f.memory.SaveSession(ctx, session)
+	})
+}
+
+func (f *Fake) UpdateSession(ctx context.Context, sessionID string, lastSeen time.Time, metadata map[string]interface{}) error {
+	return exec(f, ctx, "UpdateSession", func() error {
+		return f.memory.UpdateSession(ctx, sessionID, lastSeen, metadata)
+	})
+}
+
+func (f *Fake) DeleteSession(ctx context.Context, sessionID string) (bool, error) {
+	return call(f, ctx, "DeleteSession", nil, func() (bool, error) { return f.memory.DeleteSession(ctx, sessionID) })
+}
+
+func (f *Fake) GetSessions(ctx context.Context, userID string) ([]*storage.SessionEntry, error) {
+	return call(f, ctx, "GetSessions", []interface{}{userID}, func() ([]*storage.SessionEntry, error) {
+		return f.memory.GetSessions(ctx, userID)
+	})
+}
+
+func (f *Fake) SaveSnapshot(ctx context.Context, snapshot *storage.SnapshotEntry) (*storage.SnapshotEntry, error) {
+	return call(f, ctx, "SaveSnapshot", nil, func() (*storage.SnapshotEntry, error) {
+		return f.memory.SaveSnapshot(ctx, snapshot)
+	})
+}
+
+func (f *Fake) GetSnapshot(ctx context.Context, snapshotID string) (*storage.SnapshotEntry, error) {
+	return call(f, ctx, "GetSnapshot", []interface{}{snapshotID}, func() (*storage.SnapshotEntry, error) {
+		return f.memory.GetSnapshot(ctx, snapshotID)
+	})
+}
+
+func (f *Fake) GetLatestSnapshot(ctx context.Context, documentID string) (*storage.SnapshotEntry, error) {
+	return call(f, ctx, "GetLatestSnapshot", []interface{}{documentID}, func() (*storage.SnapshotEntry, error) {
+		return f.memory.GetLatestSnapshot(ctx, documentID)
+	})
+}
+
+func (f *Fake) ListSnapshots(ctx context.Context, documentID string, limit int) ([]*storage.SnapshotEntry, error) {
+	return call(f, ctx, "ListSnapshots", []interface{}{documentID, limit}, func() ([]*storage.SnapshotEntry, error) {
+		return f.memory.ListSnapshots(ctx, documentID, limit)
+	})
+}
+
+func (f *Fake) QuerySnapshots(ctx context.Context, documentID string, query storage.SnapshotQuery) ([]*storage.SnapshotEntry, error) {
+	return call(f, ctx, "QuerySnapshots", []interface{}{documentID, query}, func() ([]*storage.SnapshotEntry, error) {
+		return f.memory.QuerySnapshots(ctx, documentID, query)
+	})
+}
+
+func (f *Fake) DeleteSnapshot(ctx context.Context, snapshotID string) (bool, error) {
+	return call(f, ctx, "DeleteSnapshot", nil, func() (bool, error) { return f.memory.DeleteSnapshot(ctx, snapshotID) })
+}
+
+func (f *Fake) SaveAuditEvent(ctx context.Context, event *storage.AuditEventEntry) (*storage.AuditEventEntry, error) {
+	return call(f, ctx, "SaveAuditEvent", nil, func() (*storage.AuditEventEntry, error) {
+		return f.memory.SaveAuditEvent(ctx, event)
+	})
+}
+
+func (f *Fake) SaveTextDocument(ctx context.Context, id, content, crdtState string, clock int64) (*storage.TextDocumentState, error) {
+	return call(f, ctx, "SaveTextDocument", nil, func() (*storage.TextDocumentState, error) {
+		return f.memory.SaveTextDocument(ctx, id, content, crdtState, clock)
+	})
+}
+
+func (f *Fake) GetTextDocument(ctx context.Context, id string) (*storage.TextDocumentState, error) {
+	return call(f, ctx, "GetTextDocument", []interface{}{id}, func() (*storage.TextDocumentState, error) {
+		return f.memory.GetTextDocument(ctx, id)
+	})
+}
+
+func (f *Fake) Cleanup(ctx context.Context, options *storage.CleanupOptions) (*storage.CleanupResult, error) {
+	return call(f, ctx, "Cleanup", nil, func() (*storage.CleanupResult, error) { return f.memory.Cleanup(ctx, options) })
+}
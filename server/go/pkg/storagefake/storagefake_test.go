@@ -0,0 +1,160 @@
+package storagefake
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/Dancode-188/synckit/server/go/pkg/storagetest"
+)
+
+func connected(t *testing.T) *Fake {
+	t.Helper()
+	f := New()
+	if err := f.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFake_Contract(t *testing.T) {
+	storagetest.RunAdapterTests(t, func() storage.StorageAdapter { return New() })
+}
+
+func TestFake_FailNext(t *testing.T) {
+	ctx := context.Background()
+	f := connected(t)
+	down := errors.New("connection reset")
+	f.FailNext("SaveDocument", 2, down)
+
+	for i := 0; i < 2; i++ {
+		if _, err := f.SaveDocument(ctx, "room:1", map[string]interface{}{"n": i}); !errors.Is(err, down) {
+			t.Fatalf("call %d error = %v, want the injected one", i+1, err)
+		}
+	}
+	if _, err := f.SaveDocument(ctx, "room:1", map[string]interface{}{"n": 2}); err != nil {
+		t.Fatalf("third call = %v, want it to go through", err)
+	}
+	if doc, _ := f.Memory().GetDocument(ctx, "room:1"); doc == nil || doc.Version != 1 {
+		t.Errorf("stored %+v, want only the call that went through", doc)
+	}
+	if calls := f.Calls("SaveDocument"); calls != 3 {
+		t.Errorf("Calls = %d, want 3", calls)
+	}
+	if calls := f.Calls("GetDocument"); calls != 0 {
+		t.Errorf("reading through Memory counted %d calls", calls)
+	}
+}
+
+func TestFake_FailUntilReset(t *testing.T) {
+	ctx := context.Background()
+	f := connected(t)
+	f.Fail("HealthCheck", errors.New("down"))
+	for i := 0; i < 3; i++ {
+		if ok, err := f.HealthCheck(ctx); ok || err == nil {
+			t.Fatalf("HealthCheck = %v, %v; want the injected failure", ok, err)
+		}
+	}
+
+	f.Reset("HealthCheck")
+	if ok, err := f.HealthCheck(ctx); !ok || err != nil {
+		t.Errorf("after Reset HealthCheck = %v, %v", ok, err)
+	}
+	if calls := f.Calls("HealthCheck"); calls != 4 {
+		t.Errorf("Calls = %d, want Reset to keep the count", calls)
+	}
+}
+
+func TestFake_Delay(t *testing.T) {
+	f := connected(t)
+	f.Delay("GetDocument", 50*time.Millisecond)
+
+	start := time.Now()
+	if _, err := f.GetDocument(context.Background(), "room:1"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("call took %v, want the delay", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := f.GetDocument(ctx, "room:1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want the context's", err)
+	}
+}
+
+func TestFake_Stale(t *testing.T) {
+	ctx := context.Background()
+	f := connected(t)
+	if _, err := f.SaveDocument(ctx, "room:1", map[string]interface{}{"title": "Old"}); err != nil {
+		t.Fatal(err)
+	}
+	f.Stale("GetDocument")
+	if doc, _ := f.GetDocument(ctx, "room:1"); doc.State["title"] != "Old" {
+		t.Fatalf("first read = %v", doc.State)
+	}
+
+	if _, err := f.UpdateDocument(ctx, "room:1", map[string]interface{}{"title": "New"}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, _ := f.GetDocument(ctx, "room:1"); doc.State["title"] != "Old" {
+		t.Errorf("stale read = %v, want the first result", doc.State)
+	}
+	if doc, _ := f.GetDocument(ctx, "room:2"); doc != nil {
+		t.Errorf("other document = %+v, want its own result", doc)
+	}
+
+	f.Reset()
+	if doc, _ := f.GetDocument(ctx, "room:1"); doc.State["title"] != "New" {
+		t.Errorf("after Reset read = %v, want the write", doc.State)
+	}
+}
+
+func TestFake_UnknownMethodPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("scripting a misspelled method did not panic")
+		}
+	}()
+	New().FailNext("SaveDocuments", 1, errors.New("x"))
+}
+
+// Run with -race: scripting while calls are in flight
+func TestFake_ConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	f := connected(t)
+	f.FailNext("SaveDelta", 50, errors.New("busy"))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := f.SaveDelta(ctx, &storage.DeltaEntry{
+				DocumentID: "room:1", ClientID: "client-1", OperationType: storage.OpSet, FieldPath: "n", ClockValue: 1,
+			})
+			if err != nil {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+			f.Stale("GetDeltas")
+			f.GetDeltas(ctx, "room:1", 10)
+			f.Calls("SaveDelta")
+		}()
+	}
+	wg.Wait()
+
+	if failed != 50 {
+		t.Errorf("%d calls failed, want exactly the 50 scripted", failed)
+	}
+	if calls := f.Calls("SaveDelta"); calls != 100 {
+		t.Errorf("Calls = %d, want 100", calls)
+	}
+}
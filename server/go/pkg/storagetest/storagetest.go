@@ -0,0 +1,896 @@
+// Package storagetest checks that a storage adapter behaves the way the
+// server relies on: what missing rows return, how upserts and clock merges
+// work, the order listings come back in and what cleanup removes. The
+// built-in adapters run it, and so should third-party ones:
+//
+//	func TestAdapter(t *testing.T) {
+//		storagetest.RunAdapterTests(t, func() synckit.StorageAdapter {
+//			return cockroach.NewAdapter(os.Getenv("TEST_DATABASE_URL"))
+//		})
+//	}
+//
+// The suite gives each row an ID of its own, so it can share a database
+// with other data. Cleanup does remove other rows old enough to go.
+package storagetest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+)
+
+// RunAdapterTests runs the adapter contract as subtests of t. newAdapter
+// returns a new adapter that is not yet connected; each subtest connects
+// its own and disconnects it when done.
+func RunAdapterTests(t *testing.T, newAdapter func() storage.StorageAdapter) {
+	t.Helper()
+	s := &suite{newAdapter: newAdapter, prefix: "storagetest-" + randomHex(4) + "-"}
+	for _, test := range []struct {
+		name string
+		run  func(*testing.T)
+	}{
+		{"Connection", s.testConnection},
+		{"Documents", s.testDocuments},
+		{"ListDocuments", s.testListDocuments},
+		{"VectorClock", s.testVectorClock},
+		{"Deltas", s.testDeltas},
+		{"QueryDeltas", s.testQueryDeltas},
+		{"Sessions", s.testSessions},
+		{"Snapshots", s.testSnapshots},
+		{"AuditEvents", s.testAuditEvents},
+		{"TextDocuments", s.testTextDocuments},
+		{"DeleteDocumentCascades", s.testDeleteCascades},
+		{"Cleanup", s.testCleanup},
+		{"CleanupDefaults", s.testCleanupDefaults},
+		{"NotConnected", s.testNotConnected},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+type suite struct {
+	newAdapter func() storage.StorageAdapter
+	prefix     string // Starts every ID the suite chooses
+}
+
+// missingID is a well-formed ID no stored row has, for adapters whose IDs
+// are UUIDs
+const missingID = "00000000-0000-4000-8000-000000000000"
+
+// connect returns a connected adapter, disconnected when the test ends
+func (s *suite) connect(t *testing.T) storage.StorageAdapter {
+	t.Helper()
+	adapter := s.newAdapter()
+	if err := adapter.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { adapter.Disconnect(context.Background()) })
+	return adapter
+}
+
+// id returns an ID unique to this run of the suite
+func (s *suite) id(t *testing.T, name string) string {
+	return s.prefix + t.Name() + "-" + name
+}
+
+// createDocument saves a document for rows that belong to one
+func createDocument(t *testing.T, adapter storage.StorageAdapter, id string) {
+	t.Helper()
+	if _, err := adapter.SaveDocument(context.Background(), id, map[string]interface{}{"created": true}); err != nil {
+		t.Fatalf("SaveDocument(%q): %v", id, err)
+	}
+}
+
+// tick lets the clock move on, so rows written after it sort after rows
+// written before it even on storage with coarse timestamps
+func tick() {
+	time.Sleep(10 * time.Millisecond)
+}
+
+func (s *suite) testConnection(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.newAdapter()
+	if adapter.IsConnected() {
+		t.Error("IsConnected = true before Connect")
+	}
+	if _, err := adapter.GetDocument(ctx, s.id(t, "doc")); !errors.Is(err, storage.ErrNotConnected) {
+		t.Errorf("GetDocument before Connect = %v, want ErrNotConnected", err)
+	}
+
+	if err := adapter.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if !adapter.IsConnected() {
+		t.Error("IsConnected = false after Connect")
+	}
+	if ok, err := adapter.HealthCheck(ctx); !ok || err != nil {
+		t.Errorf("HealthCheck = %v, %v, want true", ok, err)
+	}
+
+	if err := adapter.Disconnect(ctx); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+	if adapter.IsConnected() {
+		t.Error("IsConnected = true after Disconnect")
+	}
+	if ok, _ := adapter.HealthCheck(ctx); ok {
+		t.Error("HealthCheck = true after Disconnect")
+	}
+}
+
+func (s *suite) testDocuments(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	id := s.id(t, "doc")
+
+	if doc, err := adapter.GetDocument(ctx, id); doc != nil || err != nil {
+		t.Fatalf("GetDocument of a missing document = %v, %v, want nil, nil", doc, err)
+	}
+
+	// Values come back as JSON decodes them
+	state := map[string]interface{}{
+		"title":  "Standup",
+		"count":  3,
+		"nested": map[string]interface{}{"list": []interface{}{"a", 1.5, true, nil}},
+	}
+	want := map[string]interface{}{
+		"title":  "Standup",
+		"count":  float64(3),
+		"nested": map[string]interface{}{"list": []interface{}{"a", 1.5, true, nil}},
+	}
+	created, err := adapter.SaveDocument(ctx, id, state)
+	if err != nil {
+		t.Fatalf("SaveDocument: %v", err)
+	}
+	if created.ID != id || !reflect.DeepEqual(created.State, want) {
+		t.Errorf("SaveDocument = %+v, want %q with %v", created, id, want)
+	}
+	if created.Version < 1 || created.CreatedAt.IsZero() || created.UpdatedAt.Before(created.CreatedAt) {
+		t.Errorf("SaveDocument version %d, created %v, updated %v", created.Version, created.CreatedAt, created.UpdatedAt)
+	}
+
+	got, err := adapter.GetDocument(ctx, id)
+	if err != nil || got == nil {
+		t.Fatalf("GetDocument = %v, %v", got, err)
+	}
+	if !reflect.DeepEqual(got.State, want) || !got.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("GetDocument = %+v, want the saved document", got)
+	}
+
+	// Changing a returned state changes nothing stored
+	got.State["title"] = "changed"
+	if again, _ := adapter.GetDocument(ctx, id); again.State["title"] != "Standup" {
+		t.Errorf("stored title = %v after changing a returned copy", again.State["title"])
+	}
+
+	// Saving again replaces the state, keeping when it was created
+	tick()
+	saved, err := adapter.SaveDocument(ctx, id, map[string]interface{}{"title": "Retro"})
+	if err != nil {
+		t.Fatalf("SaveDocument of an existing document: %v", err)
+	}
+	if !reflect.DeepEqual(saved.State, map[string]interface{}{"title": "Retro"}) {
+		t.Errorf("state after saving again = %v, want it replaced", saved.State)
+	}
+	if !saved.CreatedAt.Equal(created.CreatedAt) || !saved.UpdatedAt.After(created.UpdatedAt) {
+		t.Errorf("saving again: created %v -> %v, updated %v -> %v", created.CreatedAt, saved.CreatedAt, created.UpdatedAt, saved.UpdatedAt)
+	}
+
+	tick()
+	updated, err := adapter.UpdateDocument(ctx, id, map[string]interface{}{"title": "Planning"})
+	if err != nil {
+		t.Fatalf("UpdateDocument: %v", err)
+	}
+	if updated.State["title"] != "Planning" || !updated.CreatedAt.Equal(created.CreatedAt) || !updated.UpdatedAt.After(saved.UpdatedAt) {
+		t.Errorf("UpdateDocument = %+v", updated)
+	}
+
+	// UpdateDocument never creates
+	missing := s.id(t, "missing")
+	var notFound *storage.NotFoundError
+	if _, err := adapter.UpdateDocument(ctx, missing, map[string]interface{}{"a": 1}); !errors.As(err, &notFound) {
+		t.Errorf("UpdateDocument of a missing document = %v, want a *NotFoundError", err)
+	}
+	if doc, _ := adapter.GetDocument(ctx, missing); doc != nil {
+		t.Error("UpdateDocument created a missing document")
+	}
+
+	if deleted, err := adapter.DeleteDocument(ctx, id); !deleted || err != nil {
+		t.Errorf("DeleteDocument = %v, %v, want true", deleted, err)
+	}
+	if deleted, err := adapter.DeleteDocument(ctx, id); deleted || err != nil {
+		t.Errorf("DeleteDocument again = %v, %v, want false", deleted, err)
+	}
+	if doc, err := adapter.GetDocument(ctx, id); doc != nil || err != nil {
+		t.Errorf("GetDocument after DeleteDocument = %v, %v, want nil, nil", doc, err)
+	}
+}
+
+func (s *suite) testListDocuments(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	ids := []string{s.id(t, "a"), s.id(t, "b"), s.id(t, "c")}
+	for _, id := range ids {
+		tick()
+		createDocument(t, adapter, id)
+	}
+
+	// Most recently updated first; these are the most recent of all
+	docs, err := adapter.ListDocuments(ctx, 3, 0)
+	if err != nil {
+		t.Fatalf("ListDocuments: %v", err)
+	}
+	if got := documentIDs(docs); !reflect.DeepEqual(got, []string{ids[2], ids[1], ids[0]}) {
+		t.Errorf("ListDocuments = %q, want newest first", got)
+	}
+	if docs[0].State["created"] != true {
+		t.Errorf("listed state = %v, want the stored state", docs[0].State)
+	}
+
+	// Updating moves a document to the front
+	tick()
+	if _, err := adapter.UpdateDocument(ctx, ids[0], map[string]interface{}{"updated": true}); err != nil {
+		t.Fatal(err)
+	}
+	var paged []string
+	for offset := 0; offset < 3; offset++ {
+		page, err := adapter.ListDocuments(ctx, 1, offset)
+		if err != nil {
+			t.Fatalf("ListDocuments(1, %d): %v", offset, err)
+		}
+		if len(page) != 1 {
+			t.Fatalf("ListDocuments(1, %d) = %d documents, want 1", offset, len(page))
+		}
+		paged = append(paged, page[0].ID)
+	}
+	if want := []string{ids[0], ids[2], ids[1]}; !reflect.DeepEqual(paged, want) {
+		t.Errorf("pages = %q, want %q", paged, want)
+	}
+
+	// A limit of 0 means the default page size of 100
+	all, err := adapter.ListDocuments(ctx, 0, 0)
+	if err != nil {
+		t.Fatalf("ListDocuments(0, 0): %v", err)
+	}
+	if len(all) < 3 || len(all) > 100 {
+		t.Errorf("ListDocuments(0, 0) = %d documents, want 3 to 100", len(all))
+	}
+	if !sort.SliceIsSorted(all, func(i, j int) bool { return all[i].UpdatedAt.After(all[j].UpdatedAt) }) {
+		t.Error("ListDocuments is not ordered by last update, newest first")
+	}
+	if past, err := adapter.ListDocuments(ctx, 10, 1<<30); err != nil || len(past) != 0 {
+		t.Errorf("ListDocuments past the end = %d documents, %v, want none", len(past), err)
+	}
+}
+
+func documentIDs(docs []*storage.DocumentState) []string {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	return ids
+}
+
+func (s *suite) testVectorClock(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	id, other := s.id(t, "doc"), s.id(t, "other")
+	createDocument(t, adapter, id)
+	createDocument(t, adapter, other)
+
+	clock, err := adapter.GetVectorClock(ctx, id)
+	if err != nil || clock == nil || len(clock) != 0 {
+		t.Fatalf("GetVectorClock of a new document = %v, %v, want an empty map", clock, err)
+	}
+
+	if err := adapter.UpdateVectorClock(ctx, id, "a", 5); err != nil {
+		t.Fatalf("UpdateVectorClock: %v", err)
+	}
+	if err := adapter.UpdateVectorClock(ctx, id, "b", 7); err != nil {
+		t.Fatalf("UpdateVectorClock: %v", err)
+	}
+	// UpdateVectorClock sets the value, even a lower one
+	if err := adapter.UpdateVectorClock(ctx, id, "b", 2); err != nil {
+		t.Fatalf("UpdateVectorClock: %v", err)
+	}
+	if clock, _ := adapter.GetVectorClock(ctx, id); !reflect.DeepEqual(clock, map[string]int64{"a": 5, "b": 2}) {
+		t.Errorf("clock after updates = %v, want a:5 b:2", clock)
+	}
+
+	// MergeVectorClock keeps the greater of each entry and adds new ones
+	if err := adapter.MergeVectorClock(ctx, id, map[string]int64{"a": 3, "b": 9, "c": 1}); err != nil {
+		t.Fatalf("MergeVectorClock: %v", err)
+	}
+	if clock, _ := adapter.GetVectorClock(ctx, id); !reflect.DeepEqual(clock, map[string]int64{"a": 5, "b": 9, "c": 1}) {
+		t.Errorf("clock after merge = %v, want a:5 b:9 c:1", clock)
+	}
+	if err := adapter.MergeVectorClock(ctx, id, map[string]int64{}); err != nil {
+		t.Errorf("MergeVectorClock of an empty clock: %v", err)
+	}
+
+	// Clocks are per document
+	if clock, _ := adapter.GetVectorClock(ctx, other); len(clock) != 0 {
+		t.Errorf("other document's clock = %v, want empty", clock)
+	}
+}
+
+// saveDeltas saves n deltas to docID, letting the clock move between them
+func saveDeltas(t *testing.T, adapter storage.StorageAdapter, docID string, n int) []*storage.DeltaEntry {
+	t.Helper()
+	var saved []*storage.DeltaEntry
+	for i := 0; i < n; i++ {
+		tick()
+		delta, err := adapter.SaveDelta(context.Background(), &storage.DeltaEntry{
+			DocumentID:    docID,
+			ClientID:      "client-a",
+			OperationType: "set",
+			FieldPath:     "field",
+			Value:         map[string]interface{}{"n": i},
+			ClockValue:    int64(i + 1),
+		})
+		if err != nil {
+			t.Fatalf("SaveDelta: %v", err)
+		}
+		saved = append(saved, delta)
+	}
+	return saved
+}
+
+func (s *suite) testDeltas(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	id, other := s.id(t, "doc"), s.id(t, "other")
+	createDocument(t, adapter, id)
+	createDocument(t, adapter, other)
+
+	saved := saveDeltas(t, adapter, id, 4)
+	saveDeltas(t, adapter, other, 1)
+	for i, delta := range saved {
+		if delta.ID == "" || delta.Timestamp.IsZero() {
+			t.Fatalf("SaveDelta %d = %+v, want an ID and timestamp set", i, delta)
+		}
+	}
+	if saved[0].ID == saved[1].ID {
+		t.Error("SaveDelta gave two deltas the same ID")
+	}
+
+	// Newest first, only this document's
+	deltas, err := adapter.GetDeltas(ctx, id, 0)
+	if err != nil {
+		t.Fatalf("GetDeltas: %v", err)
+	}
+	if len(deltas) != 4 || deltas[0].ID != saved[3].ID || deltas[3].ID != saved[0].ID {
+		t.Fatalf("GetDeltas = %d deltas starting %v, want the 4 newest first", len(deltas), deltas)
+	}
+	first := deltas[3]
+	if first.DocumentID != id || first.ClientID != "client-a" || first.OperationType != "set" || first.FieldPath != "field" ||
+		first.ClockValue != 1 || !reflect.DeepEqual(first.Value, map[string]interface{}{"n": float64(0)}) {
+		t.Errorf("stored delta = %+v", first)
+	}
+	if limited, _ := adapter.GetDeltas(ctx, id, 2); len(limited) != 2 || limited[0].ID != saved[3].ID {
+		t.Errorf("GetDeltas with limit 2 = %v, want the 2 newest", limited)
+	}
+	if none, err := adapter.GetDeltas(ctx, s.id(t, "missing"), 10); err != nil || len(none) != 0 {
+		t.Errorf("GetDeltas of a document without deltas = %v, %v", none, err)
+	}
+
+	// Deltas recorded before the time go; the one at it stays
+	n, err := adapter.DeleteDeltasBefore(ctx, id, saved[2].Timestamp)
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteDeltasBefore = %d, %v, want 2", n, err)
+	}
+	if left, _ := adapter.GetDeltas(ctx, id, 10); len(left) != 2 || left[1].ID != saved[2].ID {
+		t.Errorf("deltas left = %v, want the 2 newest", left)
+	}
+	if left, _ := adapter.GetDeltas(ctx, other, 10); len(left) != 1 {
+		t.Errorf("other document's deltas = %d, want 1 untouched", len(left))
+	}
+}
+
+func (s *suite) testQueryDeltas(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	id := s.id(t, "doc")
+	createDocument(t, adapter, id)
+	saved := saveDeltas(t, adapter, id, 5)
+
+	// Oldest first
+	all, err := adapter.QueryDeltas(ctx, id, storage.DeltaQuery{})
+	if err != nil {
+		t.Fatalf("QueryDeltas: %v", err)
+	}
+	if got, want := deltaIDs(all), deltaIDs(saved); !reflect.DeepEqual(got, want) {
+		t.Fatalf("QueryDeltas = %q, want %q", got, want)
+	}
+
+	// Pages continue after the last key, without repeats or gaps
+	var paged []string
+	query := storage.DeltaQuery{Limit: 2}
+	for pages := 0; ; pages++ {
+		page, err := adapter.QueryDeltas(ctx, id, query)
+		if err != nil {
+			t.Fatalf("QueryDeltas page %d: %v", pages, err)
+		}
+		if len(page) == 0 || pages > 5 {
+			break
+		}
+		paged = append(paged, deltaIDs(page)...)
+		last := page[len(page)-1]
+		query.After = &storage.PageKey{Timestamp: last.Timestamp, ID: last.ID}
+	}
+	if want := deltaIDs(saved); !reflect.DeepEqual(paged, want) {
+		t.Errorf("paged deltas = %q, want %q", paged, want)
+	}
+
+	// Since includes deltas at that time
+	since, err := adapter.QueryDeltas(ctx, id, storage.DeltaQuery{Since: saved[3].Timestamp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := deltaIDs(since), deltaIDs(saved[3:]); !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryDeltas since the 4th = %q, want %q", got, want)
+	}
+
+	// No deltas is an empty slice, not nil, so it encodes as []
+	none, err := adapter.QueryDeltas(ctx, s.id(t, "missing"), storage.DeltaQuery{})
+	if err != nil || none == nil || len(none) != 0 {
+		t.Errorf("QueryDeltas of a document without deltas = %#v, %v, want an empty slice", none, err)
+	}
+}
+
+func deltaIDs(deltas []*storage.DeltaEntry) []string {
+	ids := make([]string, len(deltas))
+	for i, d := range deltas {
+		ids[i] = d.ID
+	}
+	return ids
+}
+
+func (s *suite) testSessions(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	user := s.id(t, "user")
+	older, newer := s.id(t, "older"), s.id(t, "newer")
+
+	session, err := adapter.SaveSession(ctx, &storage.SessionEntry{ID: older, UserID: user, ClientID: "client-a", Metadata: map[string]interface{}{"agent": "test"}})
+	if err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	if session.ConnectedAt.IsZero() || session.LastSeen.IsZero() {
+		t.Errorf("SaveSession = %+v, want connection times set", session)
+	}
+	tick()
+	if _, err := adapter.SaveSession(ctx, &storage.SessionEntry{ID: newer, UserID: user}); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	if _, err := adapter.SaveSession(ctx, &storage.SessionEntry{ID: s.id(t, "stranger"), UserID: s.id(t, "someone-else")}); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	if _, err := adapter.SaveSession(ctx, &storage.SessionEntry{ID: older, UserID: user}); err == nil {
+		t.Error("SaveSession of an existing session ID succeeded")
+	}
+
+	// Most recently seen first, only this user's
+	sessions, err := adapter.GetSessions(ctx, user)
+	if err != nil {
+		t.Fatalf("GetSessions: %v", err)
+	}
+	if got := sessionIDs(sessions); !reflect.DeepEqual(got, []string{newer, older}) {
+		t.Fatalf("GetSessions = %q, want newest first", got)
+	}
+	if s := sessions[1]; s.UserID != user || s.ClientID != "client-a" || s.Metadata["agent"] != "test" {
+		t.Errorf("stored session = %+v", s)
+	}
+
+	// Updating with metadata replaces it; without, keeps it
+	seen := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	if err := adapter.UpdateSession(ctx, older, seen, map[string]interface{}{"agent": "updated"}); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+	if err := adapter.UpdateSession(ctx, older, seen, nil); err != nil {
+		t.Fatalf("UpdateSession without metadata: %v", err)
+	}
+	sessions, _ = adapter.GetSessions(ctx, user)
+	if got := sessionIDs(sessions); !reflect.DeepEqual(got, []string{older, newer}) {
+		t.Fatalf("GetSessions after UpdateSession = %q, want the updated one first", got)
+	}
+	if !sessions[0].LastSeen.Equal(seen) || sessions[0].Metadata["agent"] != "updated" {
+		t.Errorf("updated session = %+v, want last seen %v and the new metadata", sessions[0], seen)
+	}
+	if err := adapter.UpdateSession(ctx, s.id(t, "missing"), seen, nil); err != nil {
+		t.Errorf("UpdateSession of a missing session = %v, want nil", err)
+	}
+
+	if deleted, err := adapter.DeleteSession(ctx, older); !deleted || err != nil {
+		t.Errorf("DeleteSession = %v, %v, want true", deleted, err)
+	}
+	if deleted, err := adapter.DeleteSession(ctx, older); deleted || err != nil {
+		t.Errorf("DeleteSession again = %v, %v, want false", deleted, err)
+	}
+	if sessions, _ := adapter.GetSessions(ctx, user); len(sessions) != 1 {
+		t.Errorf("sessions after DeleteSession = %d, want 1", len(sessions))
+	}
+	if sessions, err := adapter.GetSessions(ctx, s.id(t, "nobody")); err != nil || len(sessions) != 0 {
+		t.Errorf("GetSessions of a user without sessions = %v, %v", sessions, err)
+	}
+}
+
+func sessionIDs(sessions []*storage.SessionEntry) []string {
+	ids := make([]string, len(sessions))
+	for i, s := range sessions {
+		ids[i] = s.ID
+	}
+	return ids
+}
+
+// saveSnapshots saves n snapshots of docID, letting the clock move between
+// them
+func saveSnapshots(t *testing.T, adapter storage.StorageAdapter, docID string, n int) []*storage.SnapshotEntry {
+	t.Helper()
+	var saved []*storage.SnapshotEntry
+	for i := 0; i < n; i++ {
+		tick()
+		snapshot, err := adapter.SaveSnapshot(context.Background(), &storage.SnapshotEntry{
+			DocumentID: docID,
+			State:      map[string]interface{}{"n": i},
+			Version:    map[string]int64{"client-a": int64(i + 1)},
+			SizeBytes:  100 + i,
+			Compressed: i%2 == 1,
+		})
+		if err != nil {
+			t.Fatalf("SaveSnapshot: %v", err)
+		}
+		saved = append(saved, snapshot)
+	}
+	return saved
+}
+
+func (s *suite) testSnapshots(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	id := s.id(t, "doc")
+	createDocument(t, adapter, id)
+
+	if latest, err := adapter.GetLatestSnapshot(ctx, id); latest != nil || err != nil {
+		t.Errorf("GetLatestSnapshot without snapshots = %v, %v, want nil, nil", latest, err)
+	}
+
+	saved := saveSnapshots(t, adapter, id, 4)
+	for i, snapshot := range saved {
+		if snapshot.ID == "" || snapshot.CreatedAt.IsZero() {
+			t.Fatalf("SaveSnapshot %d = %+v, want an ID and creation time set", i, snapshot)
+		}
+	}
+
+	got, err := adapter.GetSnapshot(ctx, saved[1].ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetSnapshot = %v, %v", got, err)
+	}
+	if got.DocumentID != id || !reflect.DeepEqual(got.State, map[string]interface{}{"n": float64(1)}) ||
+		!reflect.DeepEqual(got.Version, map[string]int64{"client-a": 2}) || got.SizeBytes != 101 || !got.Compressed {
+		t.Errorf("GetSnapshot = %+v, want the saved snapshot", got)
+	}
+	if missing, err := adapter.GetSnapshot(ctx, missingID); missing != nil || err != nil {
+		t.Errorf("GetSnapshot of a missing snapshot = %v, %v, want nil, nil", missing, err)
+	}
+
+	if latest, err := adapter.GetLatestSnapshot(ctx, id); err != nil || latest == nil || latest.ID != saved[3].ID {
+		t.Errorf("GetLatestSnapshot = %v, %v, want the newest", latest, err)
+	}
+
+	// Newest first
+	listed, err := adapter.ListSnapshots(ctx, id, 0)
+	if err != nil {
+		t.Fatalf("ListSnapshots: %v", err)
+	}
+	if got, want := snapshotIDs(listed), reversed(snapshotIDs(saved)); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListSnapshots = %q, want %q", got, want)
+	}
+	if limited, _ := adapter.ListSnapshots(ctx, id, 2); len(limited) != 2 || limited[0].ID != saved[3].ID {
+		t.Errorf("ListSnapshots with limit 2 = %q, want the 2 newest", snapshotIDs(limited))
+	}
+
+	// Pages continue before the last key
+	var paged []string
+	query := storage.SnapshotQuery{Limit: 3}
+	for pages := 0; ; pages++ {
+		page, err := adapter.QuerySnapshots(ctx, id, query)
+		if err != nil {
+			t.Fatalf("QuerySnapshots page %d: %v", pages, err)
+		}
+		if len(page) == 0 || pages > 5 {
+			break
+		}
+		paged = append(paged, snapshotIDs(page)...)
+		last := page[len(page)-1]
+		query.Before = &storage.PageKey{Timestamp: last.CreatedAt, ID: last.ID}
+	}
+	if want := reversed(snapshotIDs(saved)); !reflect.DeepEqual(paged, want) {
+		t.Errorf("paged snapshots = %q, want %q", paged, want)
+	}
+	if none, err := adapter.QuerySnapshots(ctx, s.id(t, "missing"), storage.SnapshotQuery{}); err != nil || none == nil || len(none) != 0 {
+		t.Errorf("QuerySnapshots of a document without snapshots = %#v, %v, want an empty slice", none, err)
+	}
+
+	if deleted, err := adapter.DeleteSnapshot(ctx, saved[3].ID); !deleted || err != nil {
+		t.Errorf("DeleteSnapshot = %v, %v, want true", deleted, err)
+	}
+	if deleted, err := adapter.DeleteSnapshot(ctx, saved[3].ID); deleted || err != nil {
+		t.Errorf("DeleteSnapshot again = %v, %v, want false", deleted, err)
+	}
+	if latest, _ := adapter.GetLatestSnapshot(ctx, id); latest == nil || latest.ID != saved[2].ID {
+		t.Errorf("GetLatestSnapshot after deleting the newest = %v, want the one before", latest)
+	}
+}
+
+func snapshotIDs(snapshots []*storage.SnapshotEntry) []string {
+	ids := make([]string, len(snapshots))
+	for i, s := range snapshots {
+		ids[i] = s.ID
+	}
+	return ids
+}
+
+func reversed(ids []string) []string {
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[len(ids)-1-i] = id
+	}
+	return out
+}
+
+func (s *suite) testAuditEvents(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+
+	event, err := adapter.SaveAuditEvent(ctx, &storage.AuditEventEntry{
+		EventType:  "permission_denied",
+		Actor:      s.id(t, "user"),
+		DocumentID: s.id(t, "doc"),
+		IP:         "192.0.2.1",
+		Details:    map[string]interface{}{"action": "subscribe"},
+	})
+	if err != nil {
+		t.Fatalf("SaveAuditEvent: %v", err)
+	}
+	if event.ID == "" || event.CreatedAt.IsZero() {
+		t.Errorf("SaveAuditEvent = %+v, want an ID and creation time set", event)
+	}
+
+	// A time of the caller's is kept
+	at := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	event, err = adapter.SaveAuditEvent(ctx, &storage.AuditEventEntry{EventType: "auth_failure", CreatedAt: at})
+	if err != nil {
+		t.Fatalf("SaveAuditEvent with a time: %v", err)
+	}
+	if !event.CreatedAt.Equal(at) {
+		t.Errorf("CreatedAt = %v, want %v kept", event.CreatedAt, at)
+	}
+}
+
+func (s *suite) testTextDocuments(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	id, plain := s.id(t, "text"), s.id(t, "plain")
+
+	if doc, err := adapter.GetTextDocument(ctx, id); doc != nil || err != nil {
+		t.Errorf("GetTextDocument of a missing document = %v, %v, want nil, nil", doc, err)
+	}
+
+	saved, err := adapter.SaveTextDocument(ctx, id, "hello", `{"nodes":[]}`, 4)
+	if err != nil {
+		t.Fatalf("SaveTextDocument: %v", err)
+	}
+	if saved.ID != id || saved.Content != "hello" || saved.Clock != 4 || saved.CreatedAt.IsZero() {
+		t.Errorf("SaveTextDocument = %+v", saved)
+	}
+	tick()
+	if _, err := adapter.SaveTextDocument(ctx, id, "hello world", `{"nodes":[1]}`, 9); err != nil {
+		t.Fatalf("SaveTextDocument of an existing document: %v", err)
+	}
+
+	got, err := adapter.GetTextDocument(ctx, id)
+	if err != nil || got == nil {
+		t.Fatalf("GetTextDocument = %v, %v", got, err)
+	}
+	if got.Content != "hello world" || got.CRDTState != `{"nodes":[1]}` || got.Clock != 9 || !got.CreatedAt.Equal(saved.CreatedAt) {
+		t.Errorf("GetTextDocument = %+v", got)
+	}
+
+	// Text documents are documents of type "text"
+	doc, err := adapter.GetDocument(ctx, id)
+	if err != nil || doc == nil || doc.State["type"] != "text" || doc.State["content"] != "hello world" {
+		t.Errorf("GetDocument of a text document = %+v, %v", doc, err)
+	}
+	createDocument(t, adapter, plain)
+	if text, err := adapter.GetTextDocument(ctx, plain); text != nil || err != nil {
+		t.Errorf("GetTextDocument of a plain document = %v, %v, want nil, nil", text, err)
+	}
+}
+
+func (s *suite) testDeleteCascades(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	id := s.id(t, "doc")
+	createDocument(t, adapter, id)
+	if err := adapter.UpdateVectorClock(ctx, id, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	saveDeltas(t, adapter, id, 2)
+	snapshots := saveSnapshots(t, adapter, id, 1)
+
+	if deleted, err := adapter.DeleteDocument(ctx, id); !deleted || err != nil {
+		t.Fatalf("DeleteDocument = %v, %v", deleted, err)
+	}
+	if clock, _ := adapter.GetVectorClock(ctx, id); len(clock) != 0 {
+		t.Errorf("clock after DeleteDocument = %v, want empty", clock)
+	}
+	if deltas, _ := adapter.GetDeltas(ctx, id, 10); len(deltas) != 0 {
+		t.Errorf("deltas after DeleteDocument = %d, want none", len(deltas))
+	}
+	if snapshot, _ := adapter.GetSnapshot(ctx, snapshots[0].ID); snapshot != nil {
+		t.Error("snapshot kept after DeleteDocument")
+	}
+}
+
+func (s *suite) testCleanup(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	id := s.id(t, "doc")
+	createDocument(t, adapter, id)
+	user := s.id(t, "user")
+	stale, fresh := s.id(t, "stale"), s.id(t, "fresh")
+	for _, sessionID := range []string{stale, fresh} {
+		if _, err := adapter.SaveSession(ctx, &storage.SessionEntry{ID: sessionID, UserID: user}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := adapter.UpdateSession(ctx, stale, time.Now().Add(-48*time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	saveDeltas(t, adapter, id, 2)
+	snapshots := saveSnapshots(t, adapter, id, 4)
+	for _, age := range []time.Duration{-40 * 24 * time.Hour, -time.Hour} {
+		if _, err := adapter.SaveAuditEvent(ctx, &storage.AuditEventEntry{EventType: "auth_failure", CreatedAt: time.Now().Add(age)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := adapter.Cleanup(ctx, &storage.CleanupOptions{
+		OldSessionsHours:        24,
+		OldDeltasDays:           1,
+		MaxSnapshotsPerDocument: 2,
+		OldAuditEventsDays:      30,
+	})
+	if err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+	// Other data may be cleaned up alongside the suite's
+	if result.SessionsDeleted < 1 || result.SnapshotsDeleted < 2 || result.AuditEventsDeleted < 1 {
+		t.Errorf("Cleanup = %+v, want at least 1 session, 2 snapshots and 1 audit event deleted", result)
+	}
+
+	if sessions, _ := adapter.GetSessions(ctx, user); !reflect.DeepEqual(sessionIDs(sessions), []string{fresh}) {
+		t.Errorf("sessions after Cleanup = %q, want only the fresh one", sessionIDs(sessions))
+	}
+	if deltas, _ := adapter.GetDeltas(ctx, id, 10); len(deltas) != 2 {
+		t.Errorf("deltas after Cleanup = %d, want the 2 recent ones kept", len(deltas))
+	}
+	listed, _ := adapter.ListSnapshots(ctx, id, 10)
+	if got, want := snapshotIDs(listed), []string{snapshots[3].ID, snapshots[2].ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshots after Cleanup = %q, want the 2 newest %q", got, want)
+	}
+
+	// Zero options clean nothing
+	result, err = adapter.Cleanup(ctx, &storage.CleanupOptions{})
+	if err != nil || *result != (storage.CleanupResult{}) {
+		t.Errorf("Cleanup with zero options = %+v, %v, want nothing deleted", result, err)
+	}
+}
+
+func (s *suite) testCleanupDefaults(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	id := s.id(t, "doc")
+	createDocument(t, adapter, id)
+	user := s.id(t, "user")
+	session := s.id(t, "session")
+	if _, err := adapter.SaveSession(ctx, &storage.SessionEntry{ID: session, UserID: user}); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.UpdateSession(ctx, session, time.Now().Add(-25*time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	snapshots := saveSnapshots(t, adapter, id, 12)
+
+	// Nil options remove sessions idle a day and keep 10 snapshots a document
+	result, err := adapter.Cleanup(ctx, nil)
+	if err != nil {
+		t.Fatalf("Cleanup(nil): %v", err)
+	}
+	if result.SessionsDeleted < 1 || result.SnapshotsDeleted < 2 {
+		t.Errorf("Cleanup(nil) = %+v, want at least 1 session and 2 snapshots deleted", result)
+	}
+	if sessions, _ := adapter.GetSessions(ctx, user); len(sessions) != 0 {
+		t.Errorf("sessions after Cleanup(nil) = %d, want none", len(sessions))
+	}
+	listed, _ := adapter.ListSnapshots(ctx, id, 20)
+	if len(listed) != 10 || listed[9].ID != snapshots[2].ID {
+		t.Errorf("snapshots after Cleanup(nil) = %d, want the 10 newest", len(listed))
+	}
+}
+
+func (s *suite) testNotConnected(t *testing.T) {
+	ctx := context.Background()
+	adapter := s.connect(t)
+	if err := adapter.Disconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	id := s.id(t, "doc")
+	calls := map[string]func() error{
+		"GetDocument":    func() error { _, err := adapter.GetDocument(ctx, id); return err },
+		"SaveDocument":   func() error { _, err := adapter.SaveDocument(ctx, id, nil); return err },
+		"UpdateDocument": func() error { _, err := adapter.UpdateDocument(ctx, id, nil); return err },
+		"DeleteDocument": func() error { _, err := adapter.DeleteDocument(ctx, id); return err },
+		"ListDocuments":  func() error { _, err := adapter.ListDocuments(ctx, 10, 0); return err },
+		"GetVectorClock": func() error { _, err := adapter.GetVectorClock(ctx, id); return err },
+		"UpdateVectorClock": func() error {
+			return adapter.UpdateVectorClock(ctx, id, "a", 1)
+		},
+		"MergeVectorClock": func() error {
+			return adapter.MergeVectorClock(ctx, id, map[string]int64{"a": 1})
+		},
+		"SaveDelta":   func() error { _, err := adapter.SaveDelta(ctx, &storage.DeltaEntry{DocumentID: id}); return err },
+		"GetDeltas":   func() error { _, err := adapter.GetDeltas(ctx, id, 10); return err },
+		"QueryDeltas": func() error { _, err := adapter.QueryDeltas(ctx, id, storage.DeltaQuery{}); return err },
+		"DeleteDeltasBefore": func() error {
+			_, err := adapter.DeleteDeltasBefore(ctx, id, time.Now())
+			return err
+		},
+		"SaveSession":   func() error { _, err := adapter.SaveSession(ctx, &storage.SessionEntry{ID: id}); return err },
+		"UpdateSession": func() error { return adapter.UpdateSession(ctx, id, time.Now(), nil) },
+		"DeleteSession": func() error { _, err := adapter.DeleteSession(ctx, id); return err },
+		"GetSessions":   func() error { _, err := adapter.GetSessions(ctx, id); return err },
+		"SaveSnapshot": func() error {
+			_, err := adapter.SaveSnapshot(ctx, &storage.SnapshotEntry{DocumentID: id})
+			return err
+		},
+		"GetSnapshot":       func() error { _, err := adapter.GetSnapshot(ctx, missingID); return err },
+		"GetLatestSnapshot": func() error { _, err := adapter.GetLatestSnapshot(ctx, id); return err },
+		"ListSnapshots":     func() error { _, err := adapter.ListSnapshots(ctx, id, 10); return err },
+		"QuerySnapshots": func() error {
+			_, err := adapter.QuerySnapshots(ctx, id, storage.SnapshotQuery{})
+			return err
+		},
+		"DeleteSnapshot": func() error { _, err := adapter.DeleteSnapshot(ctx, missingID); return err },
+		"SaveAuditEvent": func() error {
+			_, err := adapter.SaveAuditEvent(ctx, &storage.AuditEventEntry{EventType: "test"})
+			return err
+		},
+		"SaveTextDocument": func() error {
+			_, err := adapter.SaveTextDocument(ctx, id, "", "{}", 0)
+			return err
+		},
+		"GetTextDocument": func() error { _, err := adapter.GetTextDocument(ctx, id); return err },
+		"Cleanup":         func() error { _, err := adapter.Cleanup(ctx, nil); return err },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, storage.ErrNotConnected) {
+			t.Errorf("%s after Disconnect = %v, want ErrNotConnected", name, err)
+		}
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,323 @@
+// Package synckit embeds a SyncKit server in a Go program. The server is
+// configured like the standalone one, from the environment or a config
+// file, and functional options replace parts of it: storage, the broker
+// servers coordinate through, security limits and logging. Hooks let the
+// program vet connections, authentication, subscriptions and deltas, and
+// Subscribe follows the changes the server applies.
+//
+//	srv, err := synckit.New(
+//		synckit.WithConfigFile("synckit.yaml"),
+//		synckit.OnDelta(func(ctx context.Context, peer synckit.Peer, delta *synckit.Delta) error {
+//			if _, ok := delta.Changes["locked"]; ok {
+//				return synckit.Deny("LOCKED", "locked is read-only")
+//			}
+//			return nil
+//		}),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	defer srv.Shutdown(context.Background())
+//	http.Handle("/sync/", http.StripPrefix("/sync", srv.Handler()))
+package synckit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/Dancode-188/synckit/server/go/internal/broker"
+	"github.com/Dancode-188/synckit/server/go/internal/config"
+	"github.com/Dancode-188/synckit/server/go/internal/security"
+	"github.com/Dancode-188/synckit/server/go/internal/server"
+	"github.com/Dancode-188/synckit/server/go/internal/storage"
+	"github.com/Dancode-188/synckit/server/go/internal/websocket"
+)
+
+// Configuration and extension points, shared with the server's own packages
+type (
+	Config = config.Config
+	Limits = security.Limits
+
+	// StorageAdapter persists documents, deltas, sessions, snapshots and
+	// audit events. The types below appear in its methods.
+	StorageAdapter    = storage.StorageAdapter
+	DocumentState     = storage.DocumentState
+	DeltaEntry        = storage.DeltaEntry
+	DeltaQuery        = storage.DeltaQuery
+	SessionEntry      = storage.SessionEntry
+	SnapshotEntry     = storage.SnapshotEntry
+	SnapshotQuery     = storage.SnapshotQuery
+	AuditEventEntry   = storage.AuditEventEntry
+	TextDocumentState = storage.TextDocumentState
+	CleanupOptions    = storage.CleanupOptions
+	CleanupResult     = storage.CleanupResult
+
+	// Broker carries messages between the servers of a deployment
+	Broker      = broker.Broker
+	BrokerStats = storage.Stats
+
+	// Peer, Delta and HookError are what hooks see and return
+	Peer      = websocket.Peer
+	Delta     = websocket.Delta
+	HookError = websocket.HookError
+
+	// Event, EventType and EventFilter are what Subscribe sends and takes
+	Event       = websocket.DocumentEvent
+	EventType   = websocket.EventType
+	EventFilter = websocket.EventFilter
+)
+
+// Event types, for EventFilter.Types
+const (
+	EventDocumentCreated  = websocket.EventDocumentCreated
+	EventDocumentUpdated  = websocket.EventDocumentUpdated
+	EventDocumentDeleted  = websocket.EventDocumentDeleted
+	EventSubscriberJoined = websocket.EventSubscriberJoined
+	EventSubscriberLeft   = websocket.EventSubscriberLeft
+	EventAwareness        = websocket.EventAwareness
+)
+
+// Deny returns the error a hook returns to refuse an action, telling the
+// client code and message
+func Deny(code, message string) error {
+	return &HookError{Code: code, Message: message}
+}
+
+// LoadConfig loads configuration the way the standalone server does: the
+// environment layered over the config file at path, when path is not empty
+func LoadConfig(path string) (*Config, error) {
+	return config.LoadFromFile(path)
+}
+
+// DefaultLimits are the limits a server has unless configured otherwise,
+// for adjusting with WithLimits
+func DefaultLimits() Limits {
+	return security.DefaultLimits()
+}
+
+// NewPostgresStorage connects to PostgreSQL at url, for WithStorage
+func NewPostgresStorage(ctx context.Context, url string) (StorageAdapter, error) {
+	cfg := storage.DefaultStorageConfig()
+	cfg.ConnectionString = url
+	adapter := storage.NewPostgresAdapter(cfg)
+	if err := adapter.Connect(ctx); err != nil {
+		return nil, err
+	}
+	return adapter, nil
+}
+
+// Option configures a Server
+type Option func(*settings) error
+
+// settings is what the options build up
+type settings struct {
+	cfg        *Config
+	configFile string
+	limits     *Limits
+	opts       server.Options
+}
+
+// WithConfig uses cfg instead of loading configuration from the
+// environment
+func WithConfig(cfg *Config) Option {
+	return func(s *settings) error {
+		if cfg == nil {
+			return errors.New("synckit: WithConfig needs a config")
+		}
+		s.cfg = cfg
+		return nil
+	}
+}
+
+// WithConfigFile loads configuration from the environment layered over
+// the file at path. WithConfig takes precedence.
+func WithConfigFile(path string) Option {
+	return func(s *settings) error {
+		s.configFile = path
+		return nil
+	}
+}
+
+// WithStorage persists documents through adapter, which must be
+// connected, instead of DATABASE_URL. The server disconnects it on
+// Shutdown.
+func WithStorage(adapter StorageAdapter) Option {
+	return func(s *settings) error {
+		s.opts.Storage = adapter
+		return nil
+	}
+}
+
+// WithBroker coordinates with other servers through b instead of the
+// configured Redis or NATS
+func WithBroker(b Broker) Option {
+	return func(s *settings) error {
+		s.opts.Broker = b
+		return nil
+	}
+}
+
+// WithLimits replaces the configured connection, rate and document limits
+func WithLimits(limits Limits) Option {
+	return func(s *settings) error {
+		s.limits = &limits
+		return nil
+	}
+}
+
+// WithLogger logs connections and requests through logger instead of
+// slog.Default
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *settings) error {
+		s.opts.Logger = logger
+		return nil
+	}
+}
+
+// OnConnect runs fn before each websocket upgrade; an error refuses the
+// connection with 403. Hooks of each kind run in the order they were
+// given, stopping at the first error.
+func OnConnect(fn func(r *http.Request) error) Option {
+	return func(s *settings) error {
+		prev := s.opts.OnConnect
+		s.opts.OnConnect = func(r *http.Request) error {
+			if prev != nil {
+				if err := prev(r); err != nil {
+					return err
+				}
+			}
+			return fn(r)
+		}
+		return nil
+	}
+}
+
+// OnAuthenticate runs fn once a client's credentials are accepted, before
+// it is told it authenticated; an error refuses it with auth_error
+func OnAuthenticate(fn func(ctx context.Context, peer Peer) error) Option {
+	return func(s *settings) error {
+		prev := s.opts.Hooks.OnAuthenticate
+		s.opts.Hooks.OnAuthenticate = func(ctx context.Context, peer Peer) error {
+			if prev != nil {
+				if err := prev(ctx, peer); err != nil {
+					return err
+				}
+			}
+			return fn(ctx, peer)
+		}
+		return nil
+	}
+}
+
+// OnSubscribe runs fn when a client permitted to subscribe to a document
+// does; an error refuses the subscription
+func OnSubscribe(fn func(ctx context.Context, peer Peer, docID string) error) Option {
+	return func(s *settings) error {
+		prev := s.opts.Hooks.OnSubscribe
+		s.opts.Hooks.OnSubscribe = func(ctx context.Context, peer Peer, docID string) error {
+			if prev != nil {
+				if err := prev(ctx, peer, docID); err != nil {
+					return err
+				}
+			}
+			return fn(ctx, peer, docID)
+		}
+		return nil
+	}
+}
+
+// OnDelta runs fn on each delta a client permitted to write sends, before
+// it is applied. fn may change delta.Changes; an error rejects the delta,
+// which the client's ACK reports with reason "hook".
+func OnDelta(fn func(ctx context.Context, peer Peer, delta *Delta) error) Option {
+	return func(s *settings) error {
+		prev := s.opts.Hooks.OnDelta
+		s.opts.Hooks.OnDelta = func(ctx context.Context, peer Peer, delta *Delta) error {
+			if prev != nil {
+				if err := prev(ctx, peer, delta); err != nil {
+					return err
+				}
+			}
+			return fn(ctx, peer, delta)
+		}
+		return nil
+	}
+}
+
+// Server is an embedded SyncKit server
+type Server struct {
+	srv *server.Server
+	cfg *Config
+}
+
+// New builds a server. Without WithConfig or WithConfigFile it is
+// configured from the environment. The hub starts at once; serve it with
+// Handler, Serve or Start and stop it with Shutdown.
+func New(opts ...Option) (*Server, error) {
+	s := &settings{}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	cfg := s.cfg
+	if cfg == nil {
+		var err error
+		if cfg, err = config.LoadFromFile(s.configFile); err != nil {
+			return nil, err
+		}
+	}
+	if s.limits != nil {
+		cfg.Limits = *s.limits
+	}
+	return &Server{srv: server.NewWithOptions(cfg, s.opts), cfg: cfg}, nil
+}
+
+// Config is the configuration the server runs with
+func (s *Server) Config() *Config {
+	return s.cfg
+}
+
+// Handler serves the server's HTTP API, websocket endpoint /ws included
+func (s *Server) Handler() http.Handler {
+	return s.srv.Handler()
+}
+
+// Start listens on addr, plus the configured health and gRPC ports, and
+// serves until Shutdown
+func (s *Server) Start(addr string) error {
+	return s.srv.Start(addr)
+}
+
+// Serve serves the HTTP API on ln until Shutdown
+func (s *Server) Serve(ln net.Listener) error {
+	return s.srv.Serve(ln)
+}
+
+// Subscribe returns a channel of the changes the server applies to
+// documents, including those relayed from other servers, and of
+// subscribers and awareness states coming and going, as filter chooses.
+// The channel is closed when ctx is done or the server shuts down. Events
+// are never waited for: a reader more than filter.Buffer events behind
+// misses the newer ones, and the next event it gets counts them in
+// Dropped.
+//
+//	events, err := srv.Subscribe(ctx, synckit.EventFilter{
+//		Prefixes: []string{"notes:"},
+//		Types:    []synckit.EventType{synckit.EventDocumentUpdated},
+//	})
+//	for event := range events {
+//		index(event.DocID, event.Changes)
+//	}
+func (s *Server) Subscribe(ctx context.Context, filter EventFilter) (<-chan Event, error) {
+	return s.srv.Subscribe(ctx, filter)
+}
+
+// Shutdown closes client connections, telling them to reconnect, then
+// stops the listeners and disconnects storage and the broker
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
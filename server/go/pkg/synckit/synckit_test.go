@@ -0,0 +1,248 @@
+package synckit_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dancode-188/synckit/server/go/pkg/client"
+	"github.com/Dancode-188/synckit/server/go/pkg/synckit"
+)
+
+// embed serves a server built with opts from an httptest server
+func embed(t *testing.T, opts ...synckit.Option) string {
+	t.Helper()
+	_, url := embedServer(t, opts...)
+	return url
+}
+
+// embedServer is embed returning the server too
+func embedServer(t *testing.T, opts ...synckit.Option) (*synckit.Server, string) {
+	t.Helper()
+	cfg, err := synckit.LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]synckit.Option{
+		synckit.WithConfig(cfg),
+		synckit.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	}, opts...)
+	srv, err := synckit.New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		ts.Close()
+	})
+	return srv, "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+}
+
+func dial(t *testing.T, url string) (*client.Client, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, url, client.Options{})
+	if err == nil {
+		t.Cleanup(func() { c.Close() })
+	}
+	return c, err
+}
+
+func open(t *testing.T, c *client.Client, docID string) (*client.Document, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.Open(ctx, docID)
+}
+
+func flush(doc *client.Document) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return doc.Flush(ctx)
+}
+
+func TestOnDelta_RejectsAndRewrites(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	url := embed(t,
+		synckit.OnDelta(func(ctx context.Context, peer synckit.Peer, delta *synckit.Delta) error {
+			mu.Lock()
+			seen = append(seen, delta.DocID)
+			mu.Unlock()
+			if _, ok := delta.Changes["locked"]; ok {
+				return synckit.Deny("LOCKED", "locked is read-only")
+			}
+			return nil
+		}),
+		// Hooks chain: this one only sees deltas the first allowed
+		synckit.OnDelta(func(ctx context.Context, peer synckit.Peer, delta *synckit.Delta) error {
+			if title, ok := delta.Changes["title"].(string); ok {
+				delta.Changes["title"] = strings.ToUpper(title)
+			}
+			return nil
+		}),
+	)
+
+	c, err := dial(t, url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	doc, err := open(t, c, "room:hooks")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	doc.Set("locked", true)
+	var serverErr *client.ServerError
+	if err := flush(doc); !errors.As(err, &serverErr) || serverErr.Code != "LOCKED" {
+		t.Fatalf("Flush of a denied delta = %v, want a LOCKED rejection", err)
+	}
+
+	doc.Set("title", "standup")
+	if err := flush(doc); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	// A second client sees the hook's version and never the denied change
+	other, err := dial(t, url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	otherDoc, err := open(t, other, "room:hooks")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got, _ := otherDoc.Get("title"); got != "STANDUP" {
+		t.Errorf("title = %v, want the hook's STANDUP", got)
+	}
+	if got, ok := otherDoc.Get("locked"); ok {
+		t.Errorf("locked = %v, want the denied change not applied", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) < 2 || seen[0] != "room:hooks" {
+		t.Errorf("OnDelta saw %q, want both deltas to room:hooks", seen)
+	}
+}
+
+func TestOnSubscribe_Denies(t *testing.T) {
+	url := embed(t, synckit.OnSubscribe(func(ctx context.Context, peer synckit.Peer, docID string) error {
+		if strings.HasPrefix(docID, "room:private") {
+			return synckit.Deny("PRIVATE", "Private room")
+		}
+		return nil
+	}))
+	c, err := dial(t, url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	var serverErr *client.ServerError
+	if _, err := open(t, c, "room:private-1"); !errors.As(err, &serverErr) || serverErr.Code != "PRIVATE" {
+		t.Errorf("Open of a denied document = %v, want PRIVATE", err)
+	}
+	if _, err := open(t, c, "room:public"); err != nil {
+		t.Errorf("Open of an allowed document: %v", err)
+	}
+}
+
+func TestOnAuthenticateAndOnConnect(t *testing.T) {
+	var mu sync.Mutex
+	var peers []synckit.Peer
+	blocked := 0
+	url := embed(t,
+		synckit.OnConnect(func(r *http.Request) error {
+			if r.Header.Get("X-Blocked") != "" {
+				mu.Lock()
+				blocked++
+				mu.Unlock()
+				return synckit.Deny("BLOCKED", "Blocked")
+			}
+			return nil
+		}),
+		synckit.OnAuthenticate(func(ctx context.Context, peer synckit.Peer) error {
+			mu.Lock()
+			defer mu.Unlock()
+			peers = append(peers, peer)
+			if len(peers) > 1 {
+				return errors.New("one client is enough")
+			}
+			return nil
+		}),
+	)
+
+	if _, err := dial(t, url); err != nil {
+		t.Fatalf("first Dial: %v", err)
+	}
+	mu.Lock()
+	if len(peers) != 1 || peers[0].ConnID == "" || !peers[0].Anonymous {
+		t.Errorf("OnAuthenticate saw %+v, want one anonymous peer", peers)
+	}
+	mu.Unlock()
+
+	// Errors other than Deny are not shown to the client
+	var serverErr *client.ServerError
+	if _, err := dial(t, url); !errors.As(err, &serverErr) || serverErr.Code != "DENIED" {
+		t.Errorf("second Dial = %v, want DENIED", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Dial(ctx, url, client.Options{Header: http.Header{"X-Blocked": {"1"}}}); err == nil {
+		t.Error("Dial refused by OnConnect succeeded")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if blocked != 1 {
+		t.Errorf("OnConnect refused %d connections, want 1", blocked)
+	}
+}
+
+func TestSubscribe_FollowsChanges(t *testing.T) {
+	srv, url := embedServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := srv.Subscribe(ctx, synckit.EventFilter{
+		Prefixes: []string{"room:indexed:"},
+		Types:    []synckit.EventType{synckit.EventDocumentCreated, synckit.EventDocumentUpdated},
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	c, err := dial(t, url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	for _, docID := range []string{"room:other", "room:indexed:1"} {
+		doc, err := open(t, c, docID)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		doc.Set("title", docID)
+		if err := flush(doc); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+	}
+
+	select {
+	case event := <-events:
+		if event.Type != synckit.EventDocumentCreated || event.DocID != "room:indexed:1" || event.Changes["title"] != "room:indexed:1" {
+			t.Errorf("event = %+v, want room:indexed:1 created with its title", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the event")
+	}
+}
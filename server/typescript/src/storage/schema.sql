@@ -14,11 +14,16 @@ CREATE TABLE IF NOT EXISTS documents (
   state JSONB NOT NULL DEFAULT '{}',
   created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
   updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
-  version BIGINT NOT NULL DEFAULT 1
+  version BIGINT NOT NULL DEFAULT 1,
+  owner_id VARCHAR(255)
 );
 
+-- The user who created the document; added to databases created before it
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS owner_id VARCHAR(255);
+
 -- Index for fast state queries
 CREATE INDEX IF NOT EXISTS idx_documents_updated_at ON documents(updated_at DESC);
+CREATE INDEX IF NOT EXISTS idx_documents_owner_id ON documents(owner_id);
 
 -- =============================================================================
 -- VECTOR CLOCKS TABLE
@@ -93,6 +98,76 @@ CREATE TABLE IF NOT EXISTS snapshots (
 CREATE INDEX IF NOT EXISTS idx_snapshots_document_id ON snapshots(document_id, created_at DESC);
 CREATE INDEX IF NOT EXISTS idx_snapshots_created_at ON snapshots(created_at DESC);
 
+-- =============================================================================
+-- AUDIT EVENTS TABLE (Optional - for security auditing)
+-- =============================================================================
+-- Records security-relevant events (auth failures, denials, bans, admin actions)
+CREATE TABLE IF NOT EXISTS audit_events (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  event_type VARCHAR(50) NOT NULL,
+  actor VARCHAR(255),
+  document_id VARCHAR(255),
+  connection_id VARCHAR(255),
+  ip VARCHAR(64),
+  details JSONB DEFAULT '{}',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Indexes for audit queries and retention cleanup
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_type ON audit_events(event_type, created_at DESC);
+
+-- =============================================================================
+-- API KEYS TABLE
+-- =============================================================================
+-- Long-lived keys for server-to-server clients; only a SHA-256 of each key is kept
+CREATE TABLE IF NOT EXISTS api_keys (
+  id VARCHAR(64) PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  prefix VARCHAR(16) NOT NULL,
+  key_hash CHAR(64) NOT NULL UNIQUE,
+  permissions JSONB NOT NULL DEFAULT '{}',
+  created_by VARCHAR(255),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- =============================================================================
+-- DOCUMENT ACL TABLE
+-- =============================================================================
+-- Per-document roles (read, write, admin) granted to principals (user IDs),
+-- on top of the permissions carried in tokens
+CREATE TABLE IF NOT EXISTS document_acl (
+  document_id VARCHAR(255) NOT NULL,
+  principal VARCHAR(255) NOT NULL,
+  role VARCHAR(10) NOT NULL CHECK (role IN ('read', 'write', 'admin')),
+  fields TEXT[],
+  granted_by VARCHAR(255),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (document_id, principal)
+);
+
+-- Field patterns a write grant is limited to (NULL for every field); added
+-- to databases created before it
+ALTER TABLE document_acl ADD COLUMN IF NOT EXISTS fields TEXT[];
+
+-- Index for looking up every grant of a principal
+CREATE INDEX IF NOT EXISTS idx_document_acl_principal ON document_acl(principal);
+
+-- =============================================================================
+-- FLUSH WATERMARKS TABLE
+-- =============================================================================
+-- How far the server has handled each client's latest flush of offline
+-- deltas to a document, so re-flushing resumes there
+CREATE TABLE IF NOT EXISTS flush_watermarks (
+  client_id VARCHAR(255) NOT NULL,
+  document_id VARCHAR(255) NOT NULL,
+  flush_id VARCHAR(128) NOT NULL,
+  watermark BIGINT NOT NULL,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (client_id, document_id)
+);
+
 -- =============================================================================
 -- FUNCTIONS
 -- =============================================================================